@@ -512,6 +512,7 @@ class objmodelClient:
 			"fromEndpointGroup": obj.fromEndpointGroup, 
 			"fromIpAddress": obj.fromIpAddress, 
 			"fromNetwork": obj.fromNetwork, 
+			"log": obj.log, 
 			"policyName": obj.policyName, 
 			"port": obj.port, 
 			"priority": obj.priority, 
@@ -344,6 +344,7 @@ type Rule struct {
 	FromEndpointGroup string `json:"fromEndpointGroup,omitempty"` // From Endpoint Group
 	FromIpAddress     string `json:"fromIpAddress,omitempty"`     // IP Address
 	FromNetwork       string `json:"fromNetwork,omitempty"`       // From Network
+	Log               bool   `json:"log,omitempty"`               // Log
 	PolicyName        string `json:"policyName,omitempty"`        // Policy Name
 	Port              int    `json:"port,omitempty"`              // Port No
 	Priority          int    `json:"priority,omitempty"`          // Priority
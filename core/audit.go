@@ -0,0 +1,66 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"sync"
+	"time"
+)
+
+// AuditRecord is the audit trail entry of one dataplane mutation
+type AuditRecord struct {
+	Time   time.Time `json:"time"`
+	Actor  string    `json:"actor"`
+	Op     string    `json:"op"`     // command or logical operation
+	Params []string  `json:"params"` // arguments of the operation
+	Error  string    `json:"error,omitempty"`
+}
+
+// AuditSink stores audit records
+type AuditSink interface {
+	Audit(rec AuditRecord)
+}
+
+var audit struct {
+	sync.Mutex
+	actor string
+	sink  AuditSink
+}
+
+// SetAuditSink sends the audit records of this process to sink, with actor
+// as the author of the mutations. A nil sink turns auditing off.
+func SetAuditSink(actor string, sink AuditSink) {
+	audit.Lock()
+	defer audit.Unlock()
+	audit.actor = actor
+	audit.sink = sink
+}
+
+// Audit records a dataplane mutation and its outcome, err is nil when it
+// succeeded
+func Audit(op string, params []string, err error) {
+	audit.Lock()
+	defer audit.Unlock()
+	if audit.sink == nil {
+		return
+	}
+
+	rec := AuditRecord{Time: time.Now(), Actor: audit.actor, Op: op, Params: params}
+	if err != nil {
+		rec.Error = err.Error()
+	}
+	audit.sink.Audit(rec)
+}
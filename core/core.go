@@ -21,6 +21,8 @@ limitations under the License.
 // hardware/kernel/device specific programming implementation, if any.
 package core
 
+import "io"
+
 // Address is a string representation of a network address (mac, ip, dns-name, url etc)
 type Address struct {
 	addr string
@@ -75,18 +77,65 @@ type Plugin interface {
 // InstanceInfo encapsulates data that is specific to a running instance of
 // netplugin like label of host on which it is started.
 type InstanceInfo struct {
-	StateDriver  StateDriver `json:"-"`
-	HostLabel    string      `json:"host-label"`
-	CtrlIP       string      `json:"ctrl-ip"`
-	VtepIP       string      `json:"vtep-ip"`
-	UplinkIntf   []string    `json:"uplink-if"`
-	RouterIP     string      `json:"router-ip"`
-	FwdMode      string      `json:"fwd-mode"`
-	ArpMode      string      `json:"arp-mode"`
-	DbURL        string      `json:"db-url"`
-	PluginMode   string      `json:"plugin-mode"`
-	HostPvtNW    int         `json:"host-pvt-nw"`
-	VxlanUDPPort int         `json:"vxlan-port"`
+	StateDriver  StateDriver     `json:"-"`
+	HostLabel    string          `json:"host-label"`
+	CtrlIP       string          `json:"ctrl-ip"`
+	VtepIP       string          `json:"vtep-ip"`
+	UplinkIntf   []string        `json:"uplink-if"`
+	RouterIP     string          `json:"router-ip"`
+	FwdMode      string          `json:"fwd-mode"`
+	ArpMode      string          `json:"arp-mode"`
+	DbURL        string          `json:"db-url"`
+	PluginMode   string          `json:"plugin-mode"`
+	HostPvtNW    int             `json:"host-pvt-nw"`
+	VxlanUDPPort int             `json:"vxlan-port"`
+	OvsConfig    OvsDriverConfig `json:"ovs-config"`
+	StateFormat  string          `json:"state-format"`  // format of the state records written
+	StateMetrics bool            `json:"state-metrics"` // state driver records request metrics
+
+	// StateReadEndpoints spread the serializable state reads over more
+	// etcd endpoints, by url, in proportion to their weights
+	StateReadEndpoints map[string]int `json:"state-read-endpoints"`
+
+	ContainerRuntime string `json:"container-runtime"` // docker, containerd or crio
+	CRIEndpoint      string `json:"cri-endpoint"`      // CRI socket of containerd or crio
+}
+
+// OvsDriverConfig holds the datapath sizing of the ovs driver. Zero values
+// are replaced by the driver defaults.
+type OvsDriverConfig struct {
+	FlowLimit    int         `json:"flow-limit"`     // max datapath flows
+	MaxIdle      int         `json:"max-idle"`       // ms an idle datapath flow is kept
+	CtZoneLimit  int         `json:"ct-zone-limit"`  // default conntrack entries per zone, 0 is unlimited
+	CtZoneLimits map[int]int `json:"ct-zone-limits"` // conntrack entries of specific zones
+
+	// ovsdb server of the switch, unix:path, tcp:host:port or
+	// ssl:host:port. The local ovsdb socket is used when empty. The ports
+	// of a switch with a remote ovsdb are set up on the switch host, not
+	// on the host of the agent.
+	OvsdbEndpoint string `json:"ovsdb-endpoint"`
+	OvsdbKey      string `json:"ovsdb-key"`     // private key file of ssl endpoints
+	OvsdbCert     string `json:"ovsdb-cert"`    // certificate file of ssl endpoints
+	OvsdbCACert   string `json:"ovsdb-ca-cert"` // CA certificate file of ssl endpoints
+
+	// veth pairs of endpoint ports created ahead of the attaches, so that
+	// they are not created on the attach path. The pool is refilled in the
+	// background every VethPoolRefill seconds, and as soon as fewer than
+	// VethPoolLowWater pairs are left. It is disabled when VethPoolSize is 0.
+	VethPoolSize     int `json:"veth-pool-size"`
+	VethPoolLowWater int `json:"veth-pool-low-water"` // half the size when 0
+	VethPoolRefill   int `json:"veth-pool-refill"`    // 30s when 0
+
+	// IPFIX collector, host:port, the packets matching the logged policy
+	// rules are sampled to, one in FlowLogSampling. Without a collector
+	// the packet counts of the rules are logged periodically.
+	FlowLogCollector string `json:"flow-log-collector"`
+	FlowLogSampling  int    `json:"flow-log-sampling"` // 1, every packet, when 0
+
+	// settings of the vlan and vxlan bridges, shared by all the networks
+	EnableSTP           bool `json:"enable-stp"`
+	EnableMcastSnooping bool `json:"enable-mcast-snooping"`
+	AgeingTime          int  `json:"ageing-time"` // mac ageing seconds, the ovs default when 0
 }
 
 // PortSpec defines protocol/port info required to host the service
@@ -146,6 +195,26 @@ type NetworkDriver interface {
 	DelPolicyRule(id string) error
 }
 
+// MetricsWriter is implemented by drivers that export metrics, written in
+// the prometheus text format along with those of the agent
+type MetricsWriter interface {
+	WriteMetrics(w io.Writer) error
+}
+
+// QoSMarker is implemented by network drivers that can mark the egress
+// traffic of an endpoint with a DSCP or 802.1p CoS value
+type QoSMarker interface {
+	SupportsDSCPMarking() bool
+	SupportsCoSMarking() bool
+}
+
+// BUMHandler is implemented by network drivers that can handle the
+// broadcast, multicast and unknown unicast traffic of a network otherwise
+// than by flooding it
+type BUMHandler interface {
+	SupportsBUMMode(mode string) bool
+}
+
 // WatchState is used to provide a difference between core.State structs by
 // providing both the current and previous state.
 type WatchState struct {
@@ -185,6 +254,95 @@ type StateDriver interface {
 	WatchAllState(baseKey string, stateType State,
 		unmarshal func([]byte, interface{}) error, rsps chan WatchState) error
 	ClearState(key string) error
+	// Lock acquires a cluster wide lock on key, giving up after a driver
+	// specific timeout. The lock is released by calling the returned
+	// function, or expires on its own when the holder dies.
+	Lock(key string) (func(), error)
+}
+
+// RecordFormat is the storage format of the records of a state driver.
+// Decode accepts records stored in any of the supported formats, so a store
+// keeps working while its format is being changed.
+type RecordFormat interface {
+	Name() string
+	Encode(record []byte) ([]byte, error)
+	Decode(data []byte) ([]byte, error)
+}
+
+// StoppableWatcher is implemented by state drivers whose watches can be
+// stopped. The watches are those of WatchAll and WatchAllState, they stop
+// sending to rsps and release their resources once stop is closed, and
+// WatchAllStateUntil then returns nil.
+type StoppableWatcher interface {
+	WatchAllUntil(baseKey string, rsps chan [2][]byte, stop <-chan struct{}) error
+	WatchAllStateUntil(baseKey string, stateType State,
+		unmarshal func([]byte, interface{}) error, rsps chan WatchState, stop <-chan struct{}) error
+}
+
+// WatchAllUntil watches the state transitions from baseKey until stop is
+// closed, it fails when the driver can not stop its watches
+func WatchAllUntil(d StateDriver, baseKey string, rsps chan [2][]byte, stop <-chan struct{}) error {
+	watcher, ok := d.(StoppableWatcher)
+	if !ok {
+		return Errorf("state driver can not stop its watches")
+	}
+	return watcher.WatchAllUntil(baseKey, rsps, stop)
+}
+
+// WatchAllStateUntil watches all state from baseKey until stop is closed, it
+// fails when the driver can not stop its watches
+func WatchAllStateUntil(d StateDriver, baseKey string, stateType State,
+	unmarshal func([]byte, interface{}) error, rsps chan WatchState, stop <-chan struct{}) error {
+	watcher, ok := d.(StoppableWatcher)
+	if !ok {
+		return Errorf("state driver can not stop its watches")
+	}
+	return watcher.WatchAllStateUntil(baseKey, stateType, unmarshal, rsps, stop)
+}
+
+// ReadConsistency is the consistency of a read of a state driver
+type ReadConsistency int
+
+const (
+	// Linearizable reads see every write completed before them. They are
+	// served through the leader of the store, and are the default.
+	Linearizable ReadConsistency = iota
+	// Serializable reads are served by any member of the store and may
+	// miss the latest writes. They take load off the leader for paths that
+	// can live with that, like listings and metrics.
+	Serializable
+)
+
+// ConsistentReader is implemented by state drivers whose reads can be
+// served at a chosen consistency. The reads of StateDriver are
+// linearizable.
+type ConsistentReader interface {
+	ReadWith(key string, consistency ReadConsistency) ([]byte, error)
+	ReadAllWith(baseKey string, consistency ReadConsistency) ([][]byte, error)
+	ReadStateWith(key string, value State,
+		unmarshal func([]byte, interface{}) error, consistency ReadConsistency) error
+	ReadAllStateWith(baseKey string, stateType State,
+		unmarshal func([]byte, interface{}) error, consistency ReadConsistency) ([]State, error)
+}
+
+// ReadStateWith reads key into a State at consistency when the driver
+// supports it, linearizably otherwise
+func ReadStateWith(d StateDriver, key string, value State,
+	unmarshal func([]byte, interface{}) error, consistency ReadConsistency) error {
+	if reader, ok := d.(ConsistentReader); ok {
+		return reader.ReadStateWith(key, value, unmarshal, consistency)
+	}
+	return d.ReadState(key, value, unmarshal)
+}
+
+// ReadAllStateWith reads all the state from baseKey at consistency when the
+// driver supports it, linearizably otherwise
+func ReadAllStateWith(d StateDriver, baseKey string, stateType State,
+	unmarshal func([]byte, interface{}) error, consistency ReadConsistency) ([]State, error) {
+	if reader, ok := d.(ConsistentReader); ok {
+		return reader.ReadAllStateWith(baseKey, stateType, unmarshal, consistency)
+	}
+	return d.ReadAllState(baseKey, stateType, unmarshal)
 }
 
 // Resource defines a allocatable unit. A resource is uniquely identified
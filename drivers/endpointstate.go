@@ -21,22 +21,78 @@ import (
 
 	"github.com/contiv/netplugin/core"
 	"github.com/contiv/netplugin/netmaster/mastercfg"
+	"github.com/contiv/netplugin/utils/netutils"
 )
 
+// EndpointStats holds the interface counters of an endpoint, as seen on the
+// host side of the endpoint's interface
+type EndpointStats struct {
+	RxBytes   uint64 `json:"rxBytes"`
+	TxBytes   uint64 `json:"txBytes"`
+	RxPackets uint64 `json:"rxPackets"`
+	TxPackets uint64 `json:"txPackets"`
+	RxDropped uint64 `json:"rxDropped"`
+	TxDropped uint64 `json:"txDropped"`
+}
+
+// DataplaneInfo locates the datapath port of an endpoint, for the ovs tools
+type DataplaneInfo struct {
+	Bridge string `json:"bridge"`
+	Port   string `json:"port"`
+	OfPort int    `json:"ofport"` // -1 until ovs assigns it
+	Vlan   int    `json:"vlan"`   // vlan of the network on the bridge
+	Vni    int    `json:"vni"`    // vxlan networks only
+}
+
 // OperEndpointState is the necessary data used to perform operations on endpoints.
 type OperEndpointState struct {
 	core.CommonState
-	NetID       string `json:"netID"`
-	EndpointID  string `json:"endpointID"`
-	ServiceName string `json:"serviceName"`
-	ContUUID    string `json:"contUUID"`
-	IPAddress   string `json:"ipAddress"`
-	IPv6Address string `json:"ipv6Address"`
-	MacAddress  string `json:"macAddress"`
-	HomingHost  string `json:"homingHost"`
-	IntfName    string `json:"intfName"`
-	PortName    string `json:"portName"`
-	VtepIP      string `json:"vtepIP"`
+	NetID        string   `json:"netID"`
+	EndpointID   string   `json:"endpointID"`
+	ServiceName  string   `json:"serviceName"`
+	ContUUID     string   `json:"contUUID"`
+	IPAddress    string   `json:"ipAddress"`
+	IPv6Address  string   `json:"ipv6Address"`
+	MacAddress   string   `json:"macAddress"`
+	HomingHost   string   `json:"homingHost"`
+	IntfName     string   `json:"intfName"`
+	PortName     string   `json:"portName"`
+	VtepIP       string   `json:"vtepIP"`
+	HostIntf     string   `json:"hostIntf"`
+	SecondaryIPs []string `json:"secondaryIPs"`
+	Bonded       bool     `json:"bonded"` // port is a bond of host uplinks
+	Tap          bool     `json:"tap"`    // port is a tap device of a vm
+
+	// RateLimiter enforces the rate limit of the endpoint, a meter of id
+	// MeterID or the policing of its port
+	RateLimiter string `json:"rateLimiter"`
+	MeterID     uint32 `json:"meterId"`
+
+	// IntfFlags are the promisc and allmulti flags in effect on the
+	// interface in the container netns, as read back after the attach
+	IntfFlags []string `json:"intfFlags"`
+}
+
+// Addresses returns the addresses of the endpoint by address family, the
+// primary address of a family first
+func (s *OperEndpointState) Addresses() map[string][]string {
+	addrs := map[string][]string{}
+	add := func(addr string) {
+		if addr == "" {
+			return
+		}
+		family := mastercfg.AddrFamilyIPv4
+		if netutils.IsIPv6(addr) {
+			family = mastercfg.AddrFamilyIPv6
+		}
+		addrs[family] = append(addrs[family], addr)
+	}
+	add(s.IPAddress)
+	add(s.IPv6Address)
+	for _, addr := range s.SecondaryIPs {
+		add(addr)
+	}
+	return addrs
 }
 
 // Matches matches the fields updated from configuration state
@@ -48,7 +104,8 @@ func (s *OperEndpointState) Matches(c *mastercfg.CfgEndpointState) bool {
 		s.MacAddress == c.MacAddress &&
 		s.HomingHost == c.HomingHost &&
 		s.IntfName == c.IntfName &&
-		s.VtepIP == c.VtepIP
+		s.VtepIP == c.VtepIP &&
+		s.HostIntf == c.HostIntfName
 }
 
 // Write the state.
@@ -68,6 +125,19 @@ func (s *OperEndpointState) ReadAll() ([]core.State, error) {
 	return s.StateDriver.ReadAllState(endpointOperPathPrefix, s, json.Unmarshal)
 }
 
+// WatchAll fills a channel on each state event related to endpoints.
+func (s *OperEndpointState) WatchAll(rsps chan core.WatchState) error {
+	return s.StateDriver.WatchAllState(endpointOperPathPrefix, s, json.Unmarshal,
+		rsps)
+}
+
+// WatchAllUntil fills a channel on each state event related to endpoints
+// until stop is closed.
+func (s *OperEndpointState) WatchAllUntil(rsps chan core.WatchState, stop <-chan struct{}) error {
+	return core.WatchAllStateUntil(s.StateDriver, endpointOperPathPrefix, s, json.Unmarshal,
+		rsps, stop)
+}
+
 // Clear removes the state.
 func (s *OperEndpointState) Clear() error {
 	key := fmt.Sprintf(endpointOperPath, s.ID)
@@ -16,9 +16,11 @@ limitations under the License.
 package drivers
 
 import (
+	"reflect"
 	"testing"
 
 	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
 )
 
 const (
@@ -66,6 +68,10 @@ func (d *testEpStateDriver) ClearState(key string) error {
 	return d.validateKey(key)
 }
 
+func (d *testEpStateDriver) Lock(key string) (func(), error) {
+	return nil, core.Errorf("not supported")
+}
+
 func (d *testEpStateDriver) ReadState(key string, value core.State,
 	unmarshal func([]byte, interface{}) error) error {
 	return d.validateKey(key)
@@ -117,3 +123,14 @@ func TestOperEndpointStateClear(t *testing.T) {
 		t.Fatalf("clear oper state failed. Error: %s", err)
 	}
 }
+
+func TestOperEndpointStateAddresses(t *testing.T) {
+	epOper := &OperEndpointState{IPv6Address: "2001::2", SecondaryIPs: []string{"2001::9", "10.1.1.9"}}
+	exp := map[string][]string{
+		mastercfg.AddrFamilyIPv4: {"10.1.1.9"},
+		mastercfg.AddrFamilyIPv6: {"2001::2", "2001::9"},
+	}
+	if addrs := epOper.Addresses(); !reflect.DeepEqual(addrs, exp) {
+		t.Fatalf("unexpected addresses %v, expected %v", addrs, exp)
+	}
+}
@@ -0,0 +1,94 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ovsd
+
+import (
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/contiv/libovsdb"
+	"github.com/contiv/netplugin/core"
+)
+
+// The bridges netplugin creates are labeled with their owner, so that a
+// restarted netplugin adopts them and leaves the bridges of others alone.
+// Its ports are labeled with the endpoint id.
+const (
+	extIDOwner     = "owner"
+	ownerNetplugin = "netplugin"
+)
+
+// bridgeOwner returns the owner label of bridge name and whether the bridge
+// exists. The bridges of older releases have no label.
+func (d *OvsdbDriver) bridgeOwner(name string) (string, bool) {
+	d.cacheLock.RLock()
+	defer d.cacheLock.RUnlock()
+	for _, row := range d.cache[bridgeTable] {
+		if row.Fields["name"] != name {
+			continue
+		}
+		extIDs, _ := row.Fields["external_ids"].(libovsdb.OvsMap)
+		owner, _ := extIDs.GoMap[extIDOwner].(string)
+		return owner, true
+	}
+	return "", false
+}
+
+// portOwner returns the endpoint id port name is labeled with, empty when
+// the port is not netplugin's, and whether the port exists
+func (d *OvsdbDriver) portOwner(name string) (string, bool) {
+	d.cacheLock.RLock()
+	defer d.cacheLock.RUnlock()
+	for _, row := range d.cache[portTable] {
+		if row.Fields["name"] != name {
+			continue
+		}
+		extIDs, _ := row.Fields["external_ids"].(libovsdb.OvsMap)
+		id, _ := extIDs.GoMap[extIDEndpoint].(string)
+		return id, true
+	}
+	return "", false
+}
+
+// checkPortOwned fails when port name exists and is not netplugin's, so
+// that it is left alone
+func (d *OvsdbDriver) checkPortOwned(name string) error {
+	if id, present := d.portOwner(name); present && id == "" {
+		return core.Errorf("port %s exists and is not managed by netplugin", name)
+	}
+	return nil
+}
+
+// adoptEndpointPort returns the interface name of the port of endpoint id
+// left on the switch by a previous run whose oper state is gone, empty when
+// there is none. The port is then rewired in place instead of being
+// duplicated.
+func (d *OvsDriver) adoptEndpointPort(sw *OvsSwitch, id string, skipVethPair bool) string {
+	portName, err := sw.ovsdbDriver.GetPortOrIntfNameFromID(id, true)
+	if err != nil {
+		return ""
+	}
+	intfName := portName
+	if useVethPair && !skipVethPair {
+		// the reverse of getOvsPortName
+		if !strings.HasPrefix(portName, "vvport") {
+			return ""
+		}
+		intfName = strings.Replace(portName, "vport", "port", 1)
+	}
+	log.Infof("Adopting port %s of endpoint %s", portName, id)
+	return intfName
+}
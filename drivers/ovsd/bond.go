@@ -0,0 +1,123 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ovsd
+
+import (
+	"fmt"
+	osexec "os/exec"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+	"github.com/vishvananda/netlink"
+)
+
+// bondMiimon is the interval in ms the bond checks the link of its members
+// at, for failover
+const bondMiimon = "100"
+
+// bondLinkArgs returns the ip arguments creating bond name in the mode of
+// an endpoint bond. The bond is created with ip, the bond modes of the
+// vendored netlink do not match the kernel's.
+func bondLinkArgs(name string, bond *mastercfg.EndpointBond) []string {
+	mode := bond.Mode
+	if mode == mastercfg.BondModeLACP {
+		mode = "802.3ad"
+	}
+	return []string{"link", "add", "name", name, "type", "bond", "mode", mode, "miimon", bondMiimon}
+}
+
+// createBond creates bond name, enslaves the members of the endpoint bond
+// to it and sets it up
+func createBond(name string, bond *mastercfg.EndpointBond) error {
+	log.Infof("Creating bond %s over %v", name, bond.Members)
+
+	members := []netlink.Link{}
+	for _, member := range bond.Members {
+		link, err := netlink.LinkByName(member)
+		if err != nil {
+			return core.Errorf("bond member %s not found. Err: %v", member, err)
+		}
+		if link.Attrs().MasterIndex != 0 {
+			return core.Errorf("bond member %s is already enslaved", member)
+		}
+		members = append(members, link)
+	}
+
+	ipPath, err := osexec.LookPath("ip")
+	if err != nil {
+		return err
+	}
+	args := bondLinkArgs(name, bond)
+	out, err := osexec.Command(ipPath, args...).CombinedOutput()
+	core.Audit(ipPath, args, err)
+	if err != nil {
+		return fmt.Errorf("unable to create bond %s. Err: %v, Out: %s", name, err, out)
+	}
+
+	bondLink, err := netlink.LinkByName(name)
+	if err == nil {
+		err = enslaveBondMembers(bondLink, members)
+	}
+	if err == nil {
+		err = setLinkUp(name)
+	}
+	if err != nil {
+		log.Errorf("Error setting up bond %s. Err: %v", name, err)
+		deleteBond(name)
+		return err
+	}
+	return nil
+}
+
+// enslaveBondMembers enslaves members to bond and sets them up. Members
+// must be down to be enslaved.
+func enslaveBondMembers(bond netlink.Link, members []netlink.Link) error {
+	for _, link := range members {
+		err := netlink.LinkSetDown(link)
+		core.Audit("link set down", []string{link.Attrs().Name}, err)
+		if err != nil {
+			return err
+		}
+		err = netlink.LinkSetMasterByIndex(link, bond.Attrs().Index)
+		core.Audit("link set master", []string{link.Attrs().Name, bond.Attrs().Name}, err)
+		if err != nil {
+			return err
+		}
+		err = netlink.LinkSetUp(link)
+		core.Audit("link set up", []string{link.Attrs().Name}, err)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteBond deletes bond name, its members are released by the kernel
+func deleteBond(name string) error {
+	log.Infof("Deleting bond %s", name)
+
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return err
+	}
+	err = netlink.LinkDel(link)
+	core.Audit("link del bond", []string{name}, err)
+	if err != nil {
+		log.Errorf("error deleting bond: %v", err)
+	}
+	return err
+}
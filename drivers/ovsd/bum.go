@@ -0,0 +1,91 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ovsd
+
+import (
+	"fmt"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+	"github.com/contiv/ofnet"
+)
+
+const (
+	// bumCookie tags the bum flows, the low bits hold the vlan of the
+	// network
+	bumCookie = 0x62756d0000000000
+	// bumPriority puts the bum flows right ahead of the ofnet flood flows,
+	// below the flows of the known macs
+	bumPriority = ofnet.FLOW_FLOOD_PRIORITY + 1
+)
+
+// bumFlowCookie returns the cookie of the bum flow of the network of vlan
+// pktTag
+func bumFlowCookie(pktTag int) string {
+	return fmt.Sprintf("0x%x", bumCookie|uint64(pktTag))
+}
+
+// bumFlow returns the flow handling the broadcast, multicast and unknown
+// unicast traffic of the local endpoints of a vxlan network. It overrides
+// the flood to the local ports and the vteps of the mac miss of the
+// network, the traffic received from the vteps is still flooded locally.
+func bumFlow(cfgNw *mastercfg.CfgNetworkState) (string, error) {
+	action := ""
+	switch cfgNw.BUMMode {
+	case mastercfg.BUMModeDrop:
+		action = "drop"
+	case mastercfg.BUMModeController:
+		action = "controller"
+	default:
+		return "", core.Errorf("no bum flow for mode %q of network %s", cfgNw.BUMMode, cfgNw.ID)
+	}
+	return fmt.Sprintf("cookie=%s,table=%d,priority=%d,dl_vlan=%d,metadata=0/0x%x,actions=%s",
+		bumFlowCookie(cfgNw.PktTag), ofnet.MAC_DEST_TBL_ID, bumPriority, cfgNw.PktTag,
+		ofnet.METADATA_RX_VTEP, action), nil
+}
+
+// SetBUMMode programs the flow handling the bum traffic of a vxlan network
+// as its bum mode asks. Setting it again replaces it.
+func (sw *OvsSwitch) SetBUMMode(cfgNw *mastercfg.CfgNetworkState) error {
+	flow, err := bumFlow(cfgNw)
+	if err != nil {
+		return err
+	}
+	log.Infof("Setting bum mode %s on network %s", cfgNw.BUMMode, cfgNw.ID)
+	_, err = sw.ofctl("add-flow", flow)
+	return err
+}
+
+// ResetBUMMode removes the bum flow of the network of vlan pktTag, if any,
+// its bum traffic is flooded again
+func (sw *OvsSwitch) ResetBUMMode(pktTag int) error {
+	_, err := sw.ofctl("del-flows", "cookie="+bumFlowCookie(pktTag)+"/-1")
+	return err
+}
+
+// SupportsBUMMode tells whether the driver handles bum traffic with mode.
+// Only the bridged vxlan datapath floods through the ofnet flows the bum
+// flows override.
+func (d *OvsDriver) SupportsBUMMode(mode string) bool {
+	switch mode {
+	case "", mastercfg.BUMModeFlood:
+		return true
+	case mastercfg.BUMModeDrop, mastercfg.BUMModeController:
+		return d.fwdMode == "bridge"
+	}
+	return false
+}
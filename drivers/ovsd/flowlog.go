@@ -0,0 +1,280 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ovsd
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/ofnet"
+)
+
+const (
+	// flowLogCookie tags the flows logging the packets of a policy rule,
+	// the low bits hold a hash of the rule id
+	flowLogCookie     = 0x666c000000000000
+	flowLogCookieMask = 0xffff000000000000
+	// flowLogCollectorSetID is the collector set the log flows sample to
+	flowLogCollectorSetID = 1
+	// flowLogActiveTimeout aggregates the IPFIX records of a flow over a
+	// minute, so that busy flows do not flood the collector
+	flowLogActiveTimeout = 60
+	// flowLogInterval is how often the packet counts of the logged rules
+	// are logged, without a collector
+	flowLogInterval = 30 * time.Second
+)
+
+var flowCountRe = regexp.MustCompile(`cookie=0x([0-9a-f]+),.*\bn_packets=([0-9]+)`)
+
+// ovs-ofctl tcp_flags of the ofnet rule tcp flags
+var ofctlTCPFlags = map[string]string{
+	"syn":      "+syn",
+	"syn,ack":  "+syn+ack",
+	"ack":      "+ack",
+	"syn,!ack": "+syn-ack",
+	"!syn,ack": "-syn+ack",
+}
+
+// flowLogger tracks the logged policy rules, to log their packet counts
+// when there is no collector
+type flowLogger struct {
+	sync.Mutex
+	sampling int               // one in sampling packets to the collector, 0 without one
+	rules    map[uint64]string // rule ids by log flow cookie
+	counts   map[uint64]uint64 // packet counts last logged by cookie
+	stopCh   chan struct{}
+}
+
+// flowLogFlowCookie returns the cookie of the log flow of policy rule ruleID
+func flowLogFlowCookie(ruleID string) uint64 {
+	h := fnv.New32a()
+	h.Write([]byte(ruleID))
+	return flowLogCookie | uint64(h.Sum32())
+}
+
+// flowLogAddr checks an address or cidr of a policy rule
+func flowLogAddr(addr string) error {
+	if strings.Contains(addr, "/") {
+		_, _, err := net.ParseCIDR(addr)
+		return err
+	}
+	if net.ParseIP(addr) == nil {
+		return core.Errorf("invalid address %q", addr)
+	}
+	return nil
+}
+
+// flowLogFlow returns the flow logging the packets matching policy rule. It
+// sits right above the flow ofnet programs for the rule in the policy table,
+// below the rules of higher priority as the ofnet rule priorities are even,
+// and resubmits the packets to the table once logged, with reg6 set so that
+// they are logged once. The packets are sampled to the collector one in
+// sampling, when sampling is set.
+func flowLogFlow(rule *ofnet.OfnetPolicyRule, sampling int) (string, error) {
+	cookie := flowLogFlowCookie(rule.RuleId)
+	match := []string{
+		fmt.Sprintf("cookie=0x%x", cookie),
+		fmt.Sprintf("table=%d", ofnet.POLICY_TBL_ID),
+		fmt.Sprintf("priority=%d", ofnet.FLOW_POLICY_PRIORITY_OFFSET+rule.Priority+1),
+		"ip",
+		"reg6=0",
+	}
+
+	var metadata, metadataMask uint64
+	if rule.SrcEndpointGroup != 0 {
+		md, mask := ofnet.SrcGroupMetadata(rule.SrcEndpointGroup)
+		metadata, metadataMask = metadata|md, metadataMask|mask
+	}
+	if rule.DstEndpointGroup != 0 {
+		md, mask := ofnet.DstGroupMetadata(rule.DstEndpointGroup)
+		metadata, metadataMask = metadata|md, metadataMask|mask
+	}
+	if metadataMask != 0 {
+		match = append(match, fmt.Sprintf("metadata=0x%x/0x%x", metadata, metadataMask))
+	}
+
+	if rule.SrcIpAddr != "" {
+		if err := flowLogAddr(rule.SrcIpAddr); err != nil {
+			return "", err
+		}
+		match = append(match, "nw_src="+rule.SrcIpAddr)
+	}
+	if rule.DstIpAddr != "" {
+		if err := flowLogAddr(rule.DstIpAddr); err != nil {
+			return "", err
+		}
+		match = append(match, "nw_dst="+rule.DstIpAddr)
+	}
+	if rule.IpProtocol != 0 {
+		match = append(match, fmt.Sprintf("nw_proto=%d", rule.IpProtocol))
+	}
+	// ports only match with a transport protocol
+	if rule.IpProtocol == 6 || rule.IpProtocol == 17 {
+		if rule.SrcPort != 0 {
+			match = append(match, fmt.Sprintf("tp_src=%d", rule.SrcPort))
+		}
+		if rule.DstPort != 0 {
+			match = append(match, fmt.Sprintf("tp_dst=%d", rule.DstPort))
+		}
+	}
+	if rule.IpProtocol == 6 && rule.TcpFlags != "" {
+		flags, ok := ofctlTCPFlags[rule.TcpFlags]
+		if !ok {
+			return "", core.Errorf("unknown tcp flags %q in rule %s", rule.TcpFlags, rule.RuleId)
+		}
+		match = append(match, "tcp_flags="+flags)
+	}
+
+	actions := []string{}
+	if sampling > 0 {
+		probability := 65535 / sampling
+		if probability == 0 {
+			probability = 1
+		}
+		actions = append(actions, fmt.Sprintf("sample(probability=%d,collector_set_id=%d,obs_domain_id=0,obs_point_id=%d)",
+			probability, flowLogCollectorSetID, uint32(cookie)))
+	}
+	actions = append(actions, "load:0x1->NXM_NX_REG6[0]", fmt.Sprintf("resubmit(,%d)", ofnet.POLICY_TBL_ID))
+
+	return strings.Join(match, ",") + ",actions=" + strings.Join(actions, ","), nil
+}
+
+// parseFlowCounts returns the packet counts of the log flows in the
+// dump-flows output of ovs-ofctl, by cookie
+func parseFlowCounts(out string) map[uint64]uint64 {
+	counts := make(map[uint64]uint64)
+	for _, line := range strings.Split(out, "\n") {
+		m := flowCountRe.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		cookie, err := strconv.ParseUint(m[1], 16, 64)
+		if err != nil || cookie&flowLogCookieMask != flowLogCookie {
+			continue
+		}
+		count, _ := strconv.ParseUint(m[2], 10, 64)
+		counts[cookie] += count
+	}
+	return counts
+}
+
+// AddFlowLog programs the flow logging the packets matching policy rule,
+// sampling them to the collector one in sampling when set
+func (sw *OvsSwitch) AddFlowLog(rule *ofnet.OfnetPolicyRule, sampling int) error {
+	flow, err := flowLogFlow(rule, sampling)
+	if err != nil {
+		return err
+	}
+	log.Infof("Logging the packets of policy rule %s on %s", rule.RuleId, sw.bridgeName)
+	_, err = sw.ofctl("add-flow", flow)
+	return err
+}
+
+// DeleteFlowLog removes the log flow of policy rule ruleID, if any
+func (sw *OvsSwitch) DeleteFlowLog(ruleID string) error {
+	_, err := sw.ofctl("del-flows", fmt.Sprintf("cookie=0x%x/-1", flowLogFlowCookie(ruleID)))
+	return err
+}
+
+// flowLogCounts returns the packet counts of the log flows of the switch,
+// by cookie
+func (sw *OvsSwitch) flowLogCounts() (map[uint64]uint64, error) {
+	out, err := sw.ofctl("dump-flows", fmt.Sprintf("cookie=0x%x/0x%x", uint64(flowLogCookie), uint64(flowLogCookieMask)))
+	if err != nil {
+		return nil, err
+	}
+	return parseFlowCounts(string(out)), nil
+}
+
+// initFlowLog sends the sampled packets of the logged rules to the IPFIX
+// collector of cfg, or starts logging the packet counts of the rules when
+// there is none
+func (d *OvsDriver) initFlowLog(cfg *core.OvsDriverConfig) error {
+	d.flowLog = &flowLogger{
+		rules:  make(map[uint64]string),
+		counts: make(map[uint64]uint64),
+		stopCh: make(chan struct{}),
+	}
+	if cfg.FlowLogCollector == "" {
+		go d.logFlowCounts()
+		return nil
+	}
+
+	d.flowLog.sampling = cfg.FlowLogSampling
+	for _, sw := range []*OvsSwitch{d.switchDb["vlan"], d.switchDb["vxlan"]} {
+		err := sw.ovsdbDriver.SetFlowSampleCollector(flowLogCollectorSetID, cfg.FlowLogCollector, flowLogActiveTimeout)
+		if err != nil {
+			log.Errorf("Error setting the flow log collector of %s. Err: %v", sw.bridgeName, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// logFlowCounts logs the packets the logged rules matched every
+// flowLogInterval, until the driver is cleaned up
+func (d *OvsDriver) logFlowCounts() {
+	ticker := time.NewTicker(flowLogInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-d.flowLog.stopCh:
+			return
+		}
+
+		d.flowLog.Lock()
+		if len(d.flowLog.rules) == 0 {
+			d.flowLog.Unlock()
+			continue
+		}
+		d.flowLog.Unlock()
+
+		counts := make(map[uint64]uint64)
+		for _, sw := range []*OvsSwitch{d.switchDb["vlan"], d.switchDb["vxlan"]} {
+			swCounts, err := sw.flowLogCounts()
+			if err != nil {
+				log.Errorf("Error reading the flow log counts of %s. Err: %v", sw.bridgeName, err)
+				continue
+			}
+			for cookie, count := range swCounts {
+				counts[cookie] += count
+			}
+		}
+
+		d.flowLog.Lock()
+		for cookie, ruleID := range d.flowLog.rules {
+			count, last := counts[cookie], d.flowLog.counts[cookie]
+			if count < last {
+				// the flow was programmed again
+				last = 0
+			}
+			if count > last {
+				log.Infof("Policy rule %s matched %d packets in the last %v", ruleID, count-last, flowLogInterval)
+			}
+			d.flowLog.counts[cookie] = count
+		}
+		d.flowLog.Unlock()
+	}
+}
@@ -0,0 +1,153 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ovsd
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	osexec "os/exec"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+	"github.com/contiv/ofnet"
+)
+
+const (
+	// gwArpCookie tags the gateway arp flows, the low bits hold the vlan
+	// of the network
+	gwArpCookie = 0x6a77000000000000
+	// gwArpInCookie tags the flows taking the arp requests for a gateway
+	// past the ofnet arp redirect, the low bits hold the gateway address
+	gwArpInCookie = 0x6a69000000000000
+	// gwArpPriority puts the gateway arp flows ahead of the ofnet ones
+	gwArpPriority = 500
+)
+
+// gwArpFlowCookie returns the cookie of the gateway arp flow of the network
+// of vlan pktTag
+func gwArpFlowCookie(pktTag int) string {
+	return fmt.Sprintf("0x%x", gwArpCookie|uint64(pktTag))
+}
+
+// gwArpInFlowCookie returns the cookie of the flow taking the arp requests
+// for gateway gwIP to the vlan table
+func gwArpInFlowCookie(gwIP net.IP) string {
+	return fmt.Sprintf("0x%x", gwArpInCookie|uint64(binary.BigEndian.Uint32(gwIP.To4())))
+}
+
+// gwArpFlows returns the flows answering the arp requests of the endpoints
+// of a vxlan network for its gateway. The requests for the gateway skip
+// the ofnet arp redirect of table 0 for the vlan table, which tags them
+// with the vlan of the network of their port. The request is then matched
+// on that vlan, so that networks of other vrfs with the same subnet are
+// left alone, turned into the reply and sent back out of its port. The
+// first flow is shared by the networks with the same gateway.
+func gwArpFlows(cfgNw *mastercfg.CfgNetworkState) (string, string, error) {
+	gwIP := net.ParseIP(cfgNw.Gateway).To4()
+	if gwIP == nil {
+		return "", "", core.Errorf("invalid gateway %q of network %s", cfgNw.Gateway, cfgNw.ID)
+	}
+	gwMac, err := net.ParseMAC(cfgNw.GatewayMac)
+	if err != nil || len(gwMac) != 6 {
+		return "", "", core.Errorf("invalid gateway mac %q of network %s", cfgNw.GatewayMac, cfgNw.ID)
+	}
+
+	inFlow := fmt.Sprintf("cookie=%s,table=0,priority=%d,arp,arp_op=1,arp_tpa=%s,actions=goto_table:%d",
+		gwArpInFlowCookie(gwIP), gwArpPriority, gwIP, ofnet.VLAN_TBL_ID)
+
+	match := fmt.Sprintf("cookie=%s,table=%d,priority=%d,arp,dl_vlan=%d,arp_op=1,arp_tpa=%s,arp_spa=%s/%d",
+		gwArpFlowCookie(cfgNw.PktTag), ofnet.SRV_PROXY_DNAT_TBL_ID, gwArpPriority, cfgNw.PktTag,
+		gwIP, cfgNw.SubnetIP, cfgNw.SubnetLen)
+	actions := []string{
+		"move:NXM_OF_ETH_SRC[]->NXM_OF_ETH_DST[]",
+		"mod_dl_src:" + gwMac.String(),
+		"load:0x2->NXM_OF_ARP_OP[]",
+		"move:NXM_NX_ARP_SHA[]->NXM_NX_ARP_THA[]",
+		"move:NXM_OF_ARP_SPA[]->NXM_OF_ARP_TPA[]",
+		fmt.Sprintf("load:0x%x->NXM_NX_ARP_SHA[]", []byte(gwMac)),
+		fmt.Sprintf("load:0x%x->NXM_OF_ARP_SPA[]", []byte(gwIP)),
+		"pop_vlan",
+		"in_port",
+	}
+	return inFlow, match + ",actions=" + strings.Join(actions, ","), nil
+}
+
+// ofctl runs ovs-ofctl command cmd with args on the bridge of the switch,
+// over OpenFlow13, and returns its output. ovs-ofctl reaches the bridges of
+// the local switch only.
+func (sw *OvsSwitch) ofctl(cmd string, args ...string) ([]byte, error) {
+	if sw.ovsdbDriver.remote {
+		return nil, core.Errorf("ovs-ofctl %s needs the switch on this host, the ovsdb of %s is remote",
+			cmd, sw.bridgeName)
+	}
+	ofctlPath, err := osexec.LookPath("ovs-ofctl")
+	if err != nil {
+		return nil, err
+	}
+	args = append([]string{"-O", "OpenFlow13", cmd, sw.bridgeName}, args...)
+	out, err := osexec.Command(ofctlPath, args...).CombinedOutput()
+	core.Audit(ofctlPath, args, err)
+	if err != nil {
+		return nil, core.Errorf("ovs-ofctl %s failed. Err: %v - %s", cmd, err, out)
+	}
+	return out, nil
+}
+
+// AddGatewayArpProxy programs the flows answering arp for the gateway of a
+// vxlan network. Adding them again replaces them.
+func (sw *OvsSwitch) AddGatewayArpProxy(cfgNw *mastercfg.CfgNetworkState) error {
+	inFlow, flow, err := gwArpFlows(cfgNw)
+	if err != nil {
+		return err
+	}
+	log.Infof("Answering arp for gateway %s of network %s with %s", cfgNw.Gateway, cfgNw.ID, cfgNw.GatewayMac)
+	if _, err := sw.ofctl("add-flow", inFlow); err != nil {
+		return err
+	}
+	if _, err := sw.ofctl("add-flow", flow); err != nil {
+		return err
+	}
+	if sw.gwArpProxies == nil {
+		sw.gwArpProxies = make(map[int]string)
+	}
+	sw.gwArpProxies[cfgNw.PktTag] = net.ParseIP(cfgNw.Gateway).To4().String()
+	return nil
+}
+
+// DeleteGatewayArpProxy removes the gateway arp flow of the network of vlan
+// pktTag, if any, and the flow of its gateway once no other network of the
+// switch answers for it
+func (sw *OvsSwitch) DeleteGatewayArpProxy(pktTag int) error {
+	if _, err := sw.ofctl("del-flows", "cookie="+gwArpFlowCookie(pktTag)+"/-1"); err != nil {
+		return err
+	}
+
+	gateway, ok := sw.gwArpProxies[pktTag]
+	if !ok {
+		return nil
+	}
+	delete(sw.gwArpProxies, pktTag)
+	for _, other := range sw.gwArpProxies {
+		if other == gateway {
+			return nil
+		}
+	}
+	_, err := sw.ofctl("del-flows", "cookie="+gwArpInFlowCookie(net.ParseIP(gateway))+"/-1")
+	return err
+}
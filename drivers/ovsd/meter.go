@@ -0,0 +1,119 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ovsd
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+)
+
+const (
+	// meterCookie tags the flows sending the traffic of an endpoint
+	// through its meter, the low bits hold the meter id
+	meterCookie = 0x6d74000000000000
+	// meterPriority puts the meter flows ahead of all the others of
+	// table 0, the traffic is then resubmitted to table 0 with reg7 set
+	// so that it takes its usual path
+	meterPriority = 600
+)
+
+var maxMeterRe = regexp.MustCompile(`max_meter:(\d+)`)
+
+// parseMaxMeters returns the number of meters in the meter-features output
+// of ovs-ofctl, 0 when the switch has none
+func parseMaxMeters(out string) int {
+	match := maxMeterRe.FindStringSubmatch(out)
+	if match == nil {
+		return 0
+	}
+	count, _ := strconv.Atoi(match[1])
+	return count
+}
+
+// meterFlowCookie returns the cookie of the flow of meter id
+func meterFlowCookie(id uint32) string {
+	return fmt.Sprintf("0x%x", meterCookie|uint64(id))
+}
+
+// meterSpec returns the ovs-ofctl spec of meter id dropping the traffic
+// over the rate limit
+func meterSpec(id uint32, limit *mastercfg.EndpointRateLimit) string {
+	if limit.Burst == 0 {
+		return fmt.Sprintf("meter=%d,kbps,band=type=drop,rate=%d", id, limit.Rate)
+	}
+	return fmt.Sprintf("meter=%d,kbps,burst,band=type=drop,rate=%d,burst_size=%d", id, limit.Rate, limit.Burst)
+}
+
+// meterFlow returns the flow sending the traffic of port ofport through
+// meter id
+func meterFlow(id, ofport uint32) string {
+	return fmt.Sprintf("cookie=%s,table=0,priority=%d,in_port=%d,reg7=0,"+
+		"actions=meter:%d,load:0x1->NXM_NX_REG7[],resubmit(,0)",
+		meterFlowCookie(id), meterPriority, ofport, id)
+}
+
+// SupportsMeters tells whether the datapath of the switch has meters
+func (sw *OvsSwitch) SupportsMeters() bool {
+	out, err := sw.ofctl("meter-features")
+	if err != nil {
+		log.Debugf("Meter features of %s unavailable. Err: %v", sw.bridgeName, err)
+		return false
+	}
+	return parseMaxMeters(string(out)) > 0
+}
+
+// AddEndpointRateLimit limits the traffic entering the switch from port
+// ovsPortName. It uses a meter, whose id is the openflow port number, when
+// the switch supports meters, and polices the port otherwise. It returns
+// the rate limiter used and the meter id.
+func (sw *OvsSwitch) AddEndpointRateLimit(ovsPortName string, limit *mastercfg.EndpointRateLimit) (string, uint32, error) {
+	if !sw.SupportsMeters() {
+		log.Infof("Policing port %s at %d kbps, no meters on %s", ovsPortName, limit.Rate, sw.bridgeName)
+		err := sw.ovsdbDriver.UpdatePolicingRate(ovsPortName, limit.Burst, limit.Rate)
+		return mastercfg.RateLimiterPolicing, 0, err
+	}
+
+	ofport, err := sw.ovsdbDriver.GetOfpPortNo(ovsPortName)
+	if err != nil {
+		return "", 0, err
+	}
+	log.Infof("Metering port %s at %d kbps with meter %d", ovsPortName, limit.Rate, ofport)
+
+	// a meter left by an endpoint that had the port before is replaced
+	sw.DeleteEndpointRateLimit(ofport)
+	if _, err := sw.ofctl("add-meter", meterSpec(ofport, limit)); err != nil {
+		return "", 0, err
+	}
+	if _, err := sw.ofctl("add-flow", meterFlow(ofport, ofport)); err != nil {
+		sw.DeleteEndpointRateLimit(ofport)
+		return "", 0, err
+	}
+	return mastercfg.RateLimiterMeter, ofport, nil
+}
+
+// DeleteEndpointRateLimit removes meter id and its flow
+func (sw *OvsSwitch) DeleteEndpointRateLimit(id uint32) error {
+	_, flowErr := sw.ofctl("del-flows", "cookie="+meterFlowCookie(id)+"/-1")
+	_, err := sw.ofctl("del-meter", fmt.Sprintf("meter=%d", id))
+	if flowErr != nil {
+		return flowErr
+	}
+	return err
+}
@@ -0,0 +1,173 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ovsd
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"github.com/contiv/libovsdb"
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+)
+
+// extIDMirror labels the capture ports added to a bridge for a mirror, they
+// are removed with the last mirror to them
+const extIDMirror = "mirror-port"
+
+// rowUUID returns the uuid of the row of table named name in the cache
+func (d *OvsdbDriver) rowUUID(table, name string) (libovsdb.UUID, bool) {
+	d.cacheLock.RLock()
+	defer d.cacheLock.RUnlock()
+	for uuid, row := range d.cache[table] {
+		if row.Fields["name"] == name {
+			return uuid, true
+		}
+	}
+	return libovsdb.UUID{}, false
+}
+
+// CreateMirror mirrors the packets in and out of port srcPort to port
+// dstPort, adding dstPort to the bridge when it is not on it. A mirror of
+// the same name is replaced, so that creating a mirror again points it to
+// the current ports.
+func (d *OvsdbDriver) CreateMirror(name, srcPort, dstPort string) error {
+	srcUUID, ok := d.rowUUID(portTable, srcPort)
+	if !ok {
+		return core.Errorf("port %s not found on %s", srcPort, d.bridgeName)
+	}
+
+	operations := []libovsdb.Operation{}
+	dstUUID, dstPresent := d.rowUUID(portTable, dstPort)
+	if !dstPresent {
+		portOps, err := d.createPortOps(dstPort, "", "", map[string]string{extIDMirror: "true"}, 0, 0, 0)
+		if err != nil {
+			return err
+		}
+		operations = append(operations, portOps...)
+		dstUUID = libovsdb.UUID{GoUuid: dstPort}
+	}
+
+	srcSet, err := libovsdb.NewOvsSet([]libovsdb.UUID{srcUUID})
+	if err != nil {
+		return err
+	}
+	mirrorUUIDStr := "newMirror"
+	mirrorOp := libovsdb.Operation{
+		Op:    "insert",
+		Table: mirrorTable,
+		Row: map[string]interface{}{
+			"name":            name,
+			"select_src_port": srcSet,
+			"select_dst_port": srcSet,
+			"output_port":     dstUUID,
+		},
+		UUIDName: mirrorUUIDStr,
+	}
+
+	// mirrors are garbage collected once no bridge refers to them
+	mutations := []interface{}{}
+	if oldUUID, ok := d.rowUUID(mirrorTable, name); ok {
+		oldSet, _ := libovsdb.NewOvsSet([]libovsdb.UUID{oldUUID})
+		mutations = append(mutations, libovsdb.NewMutation("mirrors", "delete", oldSet))
+	}
+	newSet, _ := libovsdb.NewOvsSet([]libovsdb.UUID{{GoUuid: mirrorUUIDStr}})
+	mutations = append(mutations, libovsdb.NewMutation("mirrors", "insert", newSet))
+	mutateOp := libovsdb.Operation{
+		Op:        "mutate",
+		Table:     bridgeTable,
+		Mutations: mutations,
+		Where:     []interface{}{libovsdb.NewCondition("name", "==", d.bridgeName)},
+	}
+
+	operations = append(operations, mirrorOp, mutateOp)
+	if err := d.performOvsdbOps(operations); err != nil {
+		return err
+	}
+	if !dstPresent {
+		return setLinkUp(dstPort)
+	}
+	return nil
+}
+
+// DeleteMirror removes mirror name from the bridge, if present, and the
+// capture port added for it when no other mirror uses it
+func (d *OvsdbDriver) DeleteMirror(name string) error {
+	mirrorUUID, ok := d.rowUUID(mirrorTable, name)
+	if !ok {
+		return nil
+	}
+
+	d.cacheLock.RLock()
+	outUUID, _ := d.cache[mirrorTable][mirrorUUID].Fields["output_port"].(libovsdb.UUID)
+	outPort, _ := d.cache[portTable][outUUID].Fields["name"].(string)
+	extIDs, _ := d.cache[portTable][outUUID].Fields["external_ids"].(libovsdb.OvsMap)
+	added := extIDs.GoMap[extIDMirror] == "true"
+	for uuid, row := range d.cache[mirrorTable] {
+		if uuid != mirrorUUID && row.Fields["output_port"] == outUUID {
+			added = false
+		}
+	}
+	d.cacheLock.RUnlock()
+
+	mirrorSet, _ := libovsdb.NewOvsSet([]libovsdb.UUID{mirrorUUID})
+	mutateOp := libovsdb.Operation{
+		Op:        "mutate",
+		Table:     bridgeTable,
+		Mutations: []interface{}{libovsdb.NewMutation("mirrors", "delete", mirrorSet)},
+		Where:     []interface{}{libovsdb.NewCondition("name", "==", d.bridgeName)},
+	}
+	if err := d.performOvsdbOps([]libovsdb.Operation{mutateOp}); err != nil {
+		return err
+	}
+	if added && outPort != "" {
+		log.Infof("Removing capture port %s from %s", outPort, d.bridgeName)
+		return d.DeletePort(outPort)
+	}
+	return nil
+}
+
+// CreateMirror mirrors the traffic of a local endpoint, in and out of its
+// port, to the capture port of the mirror. The capture port is added to the
+// bridge of the endpoint when it is not on it. Creating a mirror again
+// rebuilds it for the current port of the endpoint.
+func (d *OvsDriver) CreateMirror(m *mastercfg.MirrorState) error {
+	d.oper.localEpInfoMutex.Lock()
+	epInfo, found := d.oper.LocalEpInfo[m.EndpointID]
+	d.oper.localEpInfoMutex.Unlock()
+	if !found {
+		return core.Errorf("endpoint %s is not wired on this host", m.EndpointID)
+	}
+	sw, ok := d.switchDb[epInfo.BridgeType]
+	if !ok {
+		return core.Errorf("unknown bridge type %s of endpoint %s", epInfo.BridgeType, m.EndpointID)
+	}
+
+	log.Infof("Mirroring port %s of endpoint %s to %s", epInfo.Ovsportname, m.EndpointID, m.DestPort)
+	return sw.ovsdbDriver.CreateMirror(m.ID, epInfo.Ovsportname, m.DestPort)
+}
+
+// DeleteMirror removes a mirror from the bridges, the endpoint may be gone
+func (d *OvsDriver) DeleteMirror(m *mastercfg.MirrorState) error {
+	for _, sw := range []*OvsSwitch{d.switchDb["vlan"], d.switchDb["vxlan"]} {
+		if sw == nil {
+			continue
+		}
+		if err := sw.ovsdbDriver.DeleteMirror(m.ID); err != nil {
+			log.Errorf("Error deleting mirror %s from %s. Err: %v", m.ID, sw.bridgeName, err)
+			return err
+		}
+	}
+	return nil
+}
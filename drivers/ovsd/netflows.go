@@ -0,0 +1,95 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ovsd
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+	"github.com/contiv/ofnet"
+)
+
+// networkFlowCookie tags the custom flows of the networks, the low bits hold
+// a hash of the flow id. Neither ofnet nor the other flows of the driver use
+// it.
+const networkFlowCookie = 0x6e66000000000000
+
+// networkFlowCookieOf returns the cookie of custom flow id
+func networkFlowCookieOf(id string) uint64 {
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	return networkFlowCookie | uint64(h.Sum32())
+}
+
+// networkFlowSpec returns the ovs-ofctl flow of a custom flow of the network
+// of vlan pktTag. The flow only matches the frames of that network. The
+// switch refuses it when it overlaps a flow of the same table and priority,
+// so that it does not replace a flow netplugin manages.
+func networkFlowSpec(flow *mastercfg.NetworkFlowState, pktTag int) string {
+	fields := []string{
+		fmt.Sprintf("cookie=0x%x", networkFlowCookieOf(flow.ID)),
+		fmt.Sprintf("table=%d", flow.Table),
+		fmt.Sprintf("priority=%d", flow.Priority),
+		"check_overlap",
+		fmt.Sprintf("dl_vlan=%d", pktTag),
+	}
+	if flow.Match != "" {
+		fields = append(fields, flow.Match)
+	}
+	return strings.Join(fields, ",") + ",actions=" + flow.Actions
+}
+
+// AddNetworkFlow programs a custom flow on the switch of its network,
+// replacing the flow programmed before under the same id. The frames are
+// only tagged with the vlan of their network past the vlan table, custom
+// flows go in the tables after it.
+func (d *OvsDriver) AddNetworkFlow(flow *mastercfg.NetworkFlowState) error {
+	if flow.Table <= ofnet.VLAN_TBL_ID {
+		return core.Errorf("flow %s of network %s must go in a table after %d", flow.Name, flow.NetID, ofnet.VLAN_TBL_ID)
+	}
+	cfgNw := mastercfg.CfgNetworkState{}
+	cfgNw.StateDriver = d.oper.StateDriver
+	if err := cfgNw.Read(flow.NetID); err != nil {
+		return err
+	}
+	sw := d.switchDb["vlan"]
+	if cfgNw.PktTagType == "vxlan" {
+		sw = d.switchDb["vxlan"]
+	}
+
+	log.Infof("Adding flow %s of network %s on %s", flow.Name, flow.NetID, sw.bridgeName)
+	if _, err := sw.ofctl("del-flows", fmt.Sprintf("cookie=0x%x/-1", networkFlowCookieOf(flow.ID))); err != nil {
+		return err
+	}
+	_, err := sw.ofctl("add-flow", networkFlowSpec(flow, cfgNw.PktTag))
+	return err
+}
+
+// DeleteNetworkFlow removes a custom flow. It is looked up on both switches,
+// its network may be gone.
+func (d *OvsDriver) DeleteNetworkFlow(flow *mastercfg.NetworkFlowState) error {
+	for _, sw := range []*OvsSwitch{d.switchDb["vlan"], d.switchDb["vxlan"]} {
+		if _, err := sw.ofctl("del-flows", fmt.Sprintf("cookie=0x%x/-1", networkFlowCookieOf(flow.ID))); err != nil {
+			log.Errorf("Error deleting flow %s of network %s on %s. Err: %v", flow.Name, flow.NetID, sw.bridgeName, err)
+			return err
+		}
+	}
+	return nil
+}
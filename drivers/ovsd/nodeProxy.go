@@ -53,8 +53,9 @@ func NewNodeProxy() (*NodeSvcProxy, error) {
 	}
 
 	// Install contiv chain and jump
-	out, err := osexec.Command(ipTablesPath, "-w", iptablesWaitLock,
-		"-t", "nat", "-N", contivNPChain).CombinedOutput()
+	args := []string{"-w", iptablesWaitLock, "-t", "nat", "-N", contivNPChain}
+	out, err := osexec.Command(ipTablesPath, args...).CombinedOutput()
+	core.Audit(ipTablesPath, args, err)
 	if err != nil {
 		if !strings.Contains(string(out), "Chain already exists") {
 			log.Errorf("Failed to setup contiv nodeport chain %v out: %s",
@@ -67,9 +68,10 @@ func NewNodeProxy() (*NodeSvcProxy, error) {
 		"-C", "PREROUTING", "-m", "addrtype", "--dst-type", "LOCAL", "-j",
 		contivNPChain).CombinedOutput()
 	if err != nil {
-		out, err = osexec.Command(ipTablesPath, "-w", iptablesWaitLock,
-			"-t", "nat", "-I", "PREROUTING", "-m", "addrtype", "--dst-type",
-			"LOCAL", "-j", contivNPChain).CombinedOutput()
+		args = []string{"-w", iptablesWaitLock, "-t", "nat", "-I", "PREROUTING",
+			"-m", "addrtype", "--dst-type", "LOCAL", "-j", contivNPChain}
+		out, err = osexec.Command(ipTablesPath, args...).CombinedOutput()
+		core.Audit(ipTablesPath, args, err)
 		if err != nil {
 			log.Errorf("Failed to setup contiv nodeport chain jump %v out: %s",
 				err, out)
@@ -79,8 +81,9 @@ func NewNodeProxy() (*NodeSvcProxy, error) {
 
 	// Flush any old rules we might have added. They will get re-added
 	// if the service is still active
-	osexec.Command(ipTablesPath, "-w", iptablesWaitLock, "-t", "nat", "-F",
-		contivNPChain).CombinedOutput()
+	args = []string{"-w", iptablesWaitLock, "-t", "nat", "-F", contivNPChain}
+	_, err = osexec.Command(ipTablesPath, args...).CombinedOutput()
+	core.Audit(ipTablesPath, args, err)
 
 	proxy := NodeSvcProxy{}
 	proxy.SvcMap = make(map[string]core.ServiceSpec)
@@ -201,10 +204,11 @@ func (p *NodeSvcProxy) SvcProviderUpdate(svcName string, providers []string) {
 }
 
 func (p *NodeSvcProxy) execNATRule(act, dport, dest string) (string, error) {
-	out, err := osexec.Command(p.ipTablesPath, "-w", iptablesWaitLock,
-		"-t", "nat", act, contivNPChain, "-p", "tcp", "-m", "tcp", "--dport",
-		dport, "-j", "DNAT", "--to-destination",
-		dest).CombinedOutput()
+	args := []string{"-w", iptablesWaitLock, "-t", "nat", act, contivNPChain,
+		"-p", "tcp", "-m", "tcp", "--dport", dport, "-j", "DNAT",
+		"--to-destination", dest}
+	out, err := osexec.Command(p.ipTablesPath, args...).CombinedOutput()
+	core.Audit(p.ipTablesPath, args, err)
 	return string(out), err
 }
 func (p *NodeSvcProxy) syncSvc(svcName string) {
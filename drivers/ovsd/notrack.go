@@ -0,0 +1,160 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ovsd
+
+import (
+	osexec "os/exec"
+	"strconv"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+)
+
+// notrackComment tags the notrack rules of an endpoint
+func notrackComment(id string) string {
+	return "contiv-notrack:" + id
+}
+
+// notrackTables returns the iptables commands that program an exemption.
+// An exemption without addresses covers every address family of the
+// endpoint.
+func notrackTables(cfgEp *mastercfg.CfgEndpointState, exemption mastercfg.ConntrackExemption) []string {
+	if exemption.SrcIP != "" || exemption.DstIP != "" {
+		if exemption.IPv6() {
+			return []string{"ip6tables"}
+		}
+		return []string{"iptables"}
+	}
+
+	tables := []string{"iptables"}
+	if cfgEp.IPv6Address != "" {
+		tables = append(tables, "ip6tables")
+	}
+	return tables
+}
+
+// notrackRuleArgs returns the iptables arguments of the raw table rules that
+// act (-A or -D) on the notrack rules of an exemption of endpoint id. The
+// flow is matched in both directions, on packets received from the network
+// and sent by the endpoint.
+func notrackRuleArgs(act, id string, exemption mastercfg.ConntrackExemption) [][]string {
+	reply := mastercfg.ConntrackExemption{
+		Protocol: exemption.Protocol,
+		SrcIP:    exemption.DstIP,
+		SrcPort:  exemption.DstPort,
+		DstIP:    exemption.SrcIP,
+		DstPort:  exemption.SrcPort,
+	}
+	flows := []mastercfg.ConntrackExemption{exemption}
+	if reply != exemption {
+		flows = append(flows, reply)
+	}
+
+	rules := [][]string{}
+	for _, chain := range []string{"PREROUTING", "OUTPUT"} {
+		for _, flow := range flows {
+			args := []string{"-w", iptablesWaitLock, "-t", "raw", act, chain, "-p", flow.Protocol}
+			if flow.SrcIP != "" {
+				args = append(args, "-s", flow.SrcIP)
+			}
+			if flow.DstIP != "" {
+				args = append(args, "-d", flow.DstIP)
+			}
+			if flow.SrcPort != 0 || flow.DstPort != 0 {
+				args = append(args, "-m", flow.Protocol)
+			}
+			if flow.SrcPort != 0 {
+				args = append(args, "--sport", strconv.Itoa(flow.SrcPort))
+			}
+			if flow.DstPort != 0 {
+				args = append(args, "--dport", strconv.Itoa(flow.DstPort))
+			}
+			args = append(args, "-m", "comment", "--comment", notrackComment(id),
+				"-j", "CT", "--notrack")
+			rules = append(rules, args)
+		}
+	}
+	return rules
+}
+
+// execNotrackRules runs act on the notrack rules of the conntrack
+// exemptions of an endpoint in the netns at nsPath. Rules are all tried on
+// delete, the first error is returned.
+func execNotrackRules(act, nsPath string, cfgEp *mastercfg.CfgEndpointState) error {
+	nsenterPath, err := osexec.LookPath("nsenter")
+	if err != nil {
+		return err
+	}
+
+	var firstErr error
+	for _, exemption := range cfgEp.ConntrackExemptions {
+		for _, table := range notrackTables(cfgEp, exemption) {
+			for _, rule := range notrackRuleArgs(act, cfgEp.ID, exemption) {
+				args := append([]string{"--net=" + nsPath, "--", table}, rule...)
+				out, err := osexec.Command(nsenterPath, args...).CombinedOutput()
+				core.Audit(nsenterPath, args, err)
+				if err == nil {
+					continue
+				}
+				err = core.Errorf("%s %v failed. Err: %v - %s", table, rule, err, out)
+				if act != "-D" {
+					return err
+				}
+				if firstErr == nil {
+					firstErr = err
+				}
+			}
+		}
+	}
+	return firstErr
+}
+
+// AddConntrackExemptions takes the flows of the conntrack exemptions of an
+// endpoint out of connection tracking in its netns at nsPath
+func (d *OvsDriver) AddConntrackExemptions(id, nsPath string) error {
+	cfgEp := &mastercfg.CfgEndpointState{}
+	cfgEp.StateDriver = d.oper.StateDriver
+	if err := cfgEp.Read(id); err != nil {
+		return err
+	}
+	if len(cfgEp.ConntrackExemptions) == 0 {
+		return nil
+	}
+
+	if err := execNotrackRules("-A", nsPath, cfgEp); err != nil {
+		log.Errorf("Error adding conntrack exemptions of endpoint %s. Err: %v", id, err)
+		if err := execNotrackRules("-D", nsPath, cfgEp); err != nil {
+			log.Debugf("Error removing partial conntrack exemptions of endpoint %s. Err: %v", id, err)
+		}
+		return err
+	}
+	return nil
+}
+
+// RemoveConntrackExemptions removes the notrack rules of an endpoint from
+// its netns at nsPath
+func (d *OvsDriver) RemoveConntrackExemptions(id, nsPath string) error {
+	cfgEp := &mastercfg.CfgEndpointState{}
+	cfgEp.StateDriver = d.oper.StateDriver
+	if err := cfgEp.Read(id); err != nil {
+		return err
+	}
+	if len(cfgEp.ConntrackExemptions) == 0 {
+		return nil
+	}
+	return execNotrackRules("-D", nsPath, cfgEp)
+}
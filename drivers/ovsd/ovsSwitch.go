@@ -19,6 +19,7 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	osexec "os/exec"
 	"reflect"
 	"sort"
 	"strconv"
@@ -57,6 +58,15 @@ type OvsSwitch struct {
 	ofnetAgent    *ofnet.OfnetAgent
 	hostPvtNW     int
 	vxlanEncapMtu int
+	vtepIP        string    // source address of the vxlan tunnels
+	vethPool      *vethPool // shared by the switches of the driver, may be nil
+
+	// patchPorts are the patch ports joined to the ofnet datapath by name,
+	// changed under the driver lock
+	patchPorts map[string]patchPort
+	// gwArpProxies are the gateways answered for by the gateway arp proxy,
+	// by vlan of their network
+	gwArpProxies map[int]string
 }
 
 // getPvtIP returns a private IP for the port
@@ -91,8 +101,7 @@ func (sw *OvsSwitch) GetUplinkInterfaces(uplinkID string) []string {
 
 // NewOvsSwitch Creates a new OVS switch instance
 func NewOvsSwitch(bridgeName, netType, localIP, fwdMode string,
-	vlanIntf []string, hostPvtNW int, vxlanUDPPort int) (*OvsSwitch, error) {
-	var err error
+	vlanIntf []string, hostPvtNW int, vxlanUDPPort int, ovsCfg *core.OvsDriverConfig) (_ *OvsSwitch, err error) {
 	var datapath string
 	var ofnetPort, ctrlrPort uint16
 	log.Infof("Received request to create new ovs switch bridge:%s, localIP:%s, fwdMode:%s", bridgeName, localIP, fwdMode)
@@ -101,19 +110,43 @@ func NewOvsSwitch(bridgeName, netType, localIP, fwdMode string,
 	sw.netType = netType
 	sw.uplinkDb = cmap.New()
 	sw.hostPvtNW = hostPvtNW
+	sw.vtepIP = localIP
 	sw.vxlanEncapMtu, err = netutils.GetHostLowestLinkMtu()
 	if err != nil {
 		log.Fatalf("Failed to get Host Node MTU. Err: %v", err)
 	}
 
 	// Create OVS db driver
-	sw.ovsdbDriver, err = NewOvsdbDriver(bridgeName, "secure", vxlanUDPPort)
+	sw.ovsdbDriver, err = NewOvsdbDriver(ovsCfg, bridgeName, "secure", vxlanUDPPort)
 	if err != nil {
-		log.Fatalf("Error creating ovsdb driver. Err: %v", err)
+		log.Errorf("Error creating ovsdb driver. Err: %v", err)
+		return nil, err
 	}
+	defer func() {
+		if err != nil {
+			sw.ovsdbDriver.Close()
+		}
+	}()
 
 	sw.ovsdbDriver.ovsSwitch = sw
 
+	// Size the datapath before any endpoint is added
+	err = sw.ovsdbDriver.SetDatapathLimits(ovsCfg)
+	if err != nil {
+		log.Errorf("Error setting datapath limits of %s. Err: %v", bridgeName, err)
+		return nil, err
+	}
+	err = sw.ovsdbDriver.SetBridgeOptions(ovsCfg)
+	if err != nil {
+		log.Errorf("Error setting the options of bridge %s. Err: %v", bridgeName, err)
+		return nil, err
+	}
+	err = setConntrackLimits(ovsCfg)
+	if err != nil {
+		log.Errorf("Error setting conntrack limits of %s. Err: %v", bridgeName, err)
+		return nil, err
+	}
+
 	if netType == "vxlan" {
 		ofnetPort = vxlanOfnetPort
 		ctrlrPort = vxlanCtrlerPort
@@ -161,8 +194,12 @@ func NewOvsSwitch(bridgeName, netType, localIP, fwdMode string,
 		return nil, err
 	}
 
-	// Add controller to the OVS
+	// Add controller to the OVS. A switch managed through a remote ovsdb
+	// reaches the agent on the local IP.
 	ctrlerIP := "127.0.0.1"
+	if sw.ovsdbDriver.remote {
+		ctrlerIP = localIP
+	}
 	target := fmt.Sprintf("tcp:%s:%d", ctrlerIP, ctrlrPort)
 	if !sw.ovsdbDriver.IsControllerPresent(target) {
 		err = sw.ovsdbDriver.AddController(ctrlerIP, ctrlrPort)
@@ -202,6 +239,7 @@ func (sw *OvsSwitch) CreateNetwork(pktTag uint16, extPktTag uint32, defaultGw st
 	// Add the vlan/vni to ofnet
 	if sw.ofnetAgent != nil {
 		err := sw.ofnetAgent.AddNetwork(pktTag, extPktTag, defaultGw, Vrf)
+		core.Audit("ofnet add network", []string{strconv.Itoa(int(pktTag)), strconv.Itoa(int(extPktTag)), defaultGw, Vrf}, err)
 		if err != nil {
 			log.Errorf("Error adding vlan/vni %d/%d. Err: %v", pktTag, extPktTag, err)
 			return err
@@ -215,6 +253,7 @@ func (sw *OvsSwitch) DeleteNetwork(pktTag uint16, extPktTag uint32, gateway stri
 	// Delete vlan/vni mapping
 	if sw.ofnetAgent != nil {
 		err := sw.ofnetAgent.RemoveNetwork(pktTag, extPktTag, gateway, Vrf)
+		core.Audit("ofnet remove network", []string{strconv.Itoa(int(pktTag)), strconv.Itoa(int(extPktTag)), gateway, Vrf}, err)
 		if err != nil {
 			log.Errorf("Error removing vlan/vni %d/%d. Err: %v", pktTag, extPktTag, err)
 			return err
@@ -237,7 +276,9 @@ func createVethPair(name1, name2 string) error {
 	}
 
 	// Create the veth pair
-	if err := netlink.LinkAdd(veth); err != nil {
+	err := netlink.LinkAdd(veth)
+	core.Audit("link add veth", []string{name1, name2}, err)
+	if err != nil {
 		log.Errorf("error creating veth pair: %v", err)
 		return err
 	}
@@ -245,6 +286,16 @@ func createVethPair(name1, name2 string) error {
 	return nil
 }
 
+// vethPairPresent returns true if veth interface name exists
+func vethPairPresent(name string) bool {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return false
+	}
+	_, ok := link.(*netlink.Veth)
+	return ok
+}
+
 // deleteVethPair deletes veth interface pairs
 func deleteVethPair(name1, name2 string) error {
 	log.Infof("Deleting Veth pairs with name: %s, %s", name1, name2)
@@ -259,7 +310,9 @@ func deleteVethPair(name1, name2 string) error {
 	}
 
 	// Create the veth pair
-	if err := netlink.LinkDel(veth); err != nil {
+	err := netlink.LinkDel(veth)
+	core.Audit("link del veth", []string{name1, name2}, err)
+	if err != nil {
 		log.Errorf("error deleting veth pair: %v", err)
 		return err
 	}
@@ -273,7 +326,9 @@ func setLinkUp(name string) error {
 	if err != nil {
 		return err
 	}
-	return netlink.LinkSetUp(iface)
+	err = netlink.LinkSetUp(iface)
+	core.Audit("link set up", []string{name}, err)
+	return err
 }
 
 // Set the link mtu
@@ -282,7 +337,44 @@ func setLinkMtu(name string, mtu int) error {
 	if err != nil {
 		return err
 	}
-	return netlink.LinkSetMTU(iface, mtu)
+	err = netlink.LinkSetMTU(iface, mtu)
+	core.Audit("link set mtu", []string{name, strconv.Itoa(mtu)}, err)
+	return err
+}
+
+// setLinkAltName adds an alternate name to the link so that it can be
+// referred to by a well known name (e.g. for packet capture) while keeping
+// the name OVS knows it by
+func setLinkAltName(name, altName string) error {
+	ipPath, err := osexec.LookPath("ip")
+	if err != nil {
+		return err
+	}
+	if help, _ := osexec.Command(ipPath, "link", "help").CombinedOutput(); !strings.Contains(string(help), "altname") {
+		return fmt.Errorf("unable to add altname %s to %s: %s does not support altnames, iproute2 5.4 or later is required",
+			altName, name, ipPath)
+	}
+
+	args := []string{"link", "property", "add", "dev", name, "altname", altName}
+	out, err := osexec.Command(ipPath, args...).CombinedOutput()
+	core.Audit(ipPath, args, err)
+	if err != nil {
+		return altNameError(name, altName, string(out), err)
+	}
+
+	return nil
+}
+
+// altNameError explains the failure of ip to add altname to link name
+func altNameError(name, altName, out string, err error) error {
+	switch {
+	case strings.Contains(out, "Operation not supported"):
+		return fmt.Errorf("unable to add altname %s to %s: the kernel does not support altnames, linux 5.5 or later is required",
+			altName, name)
+	case strings.Contains(out, "File exists"):
+		return fmt.Errorf("unable to add altname %s to %s: the name is used by another interface of the host", altName, name)
+	}
+	return fmt.Errorf("unable to add altname %s to %s. Err: %v, Out: %s", altName, name, err, out)
 }
 
 // getOvsPortName returns OVS port name depending on if we use Veth pairs
@@ -299,11 +391,25 @@ func getOvsPortName(intfName string, skipVethPair bool) string {
 	return ovsPortName
 }
 
-// CreatePort creates a port in ovs switch
-func (sw *OvsSwitch) CreatePort(intfName string, cfgEp *mastercfg.CfgEndpointState, pktTag, nwPktTag, burst, dscp int, skipVethPair bool, bandwidth int64) error {
+// endpointExtIDs returns the external_ids labeling the ovs port of an
+// endpoint with its network and container
+func endpointExtIDs(cfgEp *mastercfg.CfgEndpointState) map[string]string {
+	return map[string]string{
+		extIDNetwork:   cfgEp.NetID,
+		extIDContainer: cfgEp.ContainerID,
+	}
+}
+
+// CreatePort creates a port in ovs switch. The port mtu is the endpoint's
+// own, or else the network's capped by what the host links can carry. The
+// network mtu is not capped when nwMtuChecked, its underlay links were
+// checked to carry it.
+func (sw *OvsSwitch) CreatePort(intfName string, cfgEp *mastercfg.CfgEndpointState, pktTag, nwPktTag, burst, dscp, nwMtu int, nwMtuChecked, skipVethPair bool, bandwidth int64) error {
 	var ovsIntfType string
 	var err error
 	vethCreated := false
+	bondCreated := false
+	tapCreated := false
 	dbUpdated := false
 
 	// Get OVS port name
@@ -313,22 +419,64 @@ func (sw *OvsSwitch) CreatePort(intfName string, cfgEp *mastercfg.CfgEndpointSta
 			if vethCreated {
 				deleteVethPair(intfName, ovsPortName)
 			}
+			if bondCreated {
+				deleteBond(intfName)
+			}
+			if tapCreated {
+				deleteTap(intfName)
+			}
 			if dbUpdated {
 				sw.ovsdbDriver.DeletePort(intfName)
 			}
 		}
 	}()
 
-	// Create Veth pairs if required
-	if useVethPair && !skipVethPair {
+	if sw.ovsdbDriver.remote {
+		// The interfaces of a remote switch are on its host, it creates
+		// them as internal ports
+		if cfgEp.Bond != nil || cfgEp.Tap {
+			err = core.Errorf("bond and tap endpoints need the switch on this host, the ovsdb of %s is remote",
+				sw.bridgeName)
+			return err
+		}
+		ovsPortName = intfName
+		ovsIntfType = "internal"
+	} else if cfgEp.Bond != nil {
+		// The bond is the port, its members must not be in OVS already
 		ovsIntfType = ""
-
-		// Create a Veth pair
-		err = createVethPair(intfName, ovsPortName)
+		for _, member := range cfgEp.Bond.Members {
+			if sw.ovsdbDriver.IsPortNamePresent(member) {
+				err = core.Errorf("bond member %s is an OVS port", member)
+				return err
+			}
+		}
+		err = createBond(intfName, cfgEp.Bond)
+		if err != nil {
+			log.Errorf("Error creating bond %s. Err: %v", intfName, err)
+			return err
+		}
+		bondCreated = true
+	} else if cfgEp.Tap {
+		// The tap is the port, the hypervisor attaches the vm to it
+		ovsIntfType = ""
+		err = createTap(intfName)
 		if err != nil {
-			log.Errorf("Error creating veth pairs. Err: %v", err)
+			log.Errorf("Error creating tap %s. Err: %v", intfName, err)
 			return err
 		}
+		tapCreated = true
+	} else if useVethPair && !skipVethPair {
+		// Create Veth pairs if required
+		ovsIntfType = ""
+
+		// Create a Veth pair, unless it was taken from the veth pool
+		if !vethPairPresent(ovsPortName) {
+			err = createVethPair(intfName, ovsPortName)
+			if err != nil {
+				log.Errorf("Error creating veth pairs. Err: %v", err)
+				return err
+			}
+		}
 		vethCreated = true
 
 		// Set the OVS side of the port as up
@@ -337,12 +485,26 @@ func (sw *OvsSwitch) CreatePort(intfName string, cfgEp *mastercfg.CfgEndpointSta
 			log.Errorf("Error setting link %s up. Err: %v", ovsPortName, err)
 			return err
 		}
+
+		// Keep the host side visible under the requested name. It stays in
+		// the root namespace and goes away with the veth pair on delete.
+		if cfgEp.HostIntfName != "" {
+			err = setLinkAltName(ovsPortName, cfgEp.HostIntfName)
+			if err != nil {
+				log.Errorf("Error naming host side of %s. Err: %v", ovsPortName, err)
+				return err
+			}
+		}
 	} else {
 		ovsPortName = intfName
 		ovsIntfType = "internal"
 	}
 
-	// If the port already exists in OVS, remove it first
+	// If the port already exists in OVS, remove it first, unless it is not
+	// netplugin's
+	if err = sw.ovsdbDriver.checkPortOwned(ovsPortName); err != nil {
+		return err
+	}
 	if sw.ovsdbDriver.IsPortNamePresent(ovsPortName) {
 		log.Debugf("Removing existing interface entry %s from OVS", ovsPortName)
 
@@ -353,7 +515,7 @@ func (sw *OvsSwitch) CreatePort(intfName string, cfgEp *mastercfg.CfgEndpointSta
 		}
 	}
 	// Ask OVSDB driver to add the port
-	err = sw.ovsdbDriver.CreatePort(ovsPortName, ovsIntfType, cfgEp.ID, pktTag, burst, bandwidth)
+	err = sw.ovsdbDriver.CreatePort(ovsPortName, ovsIntfType, cfgEp.ID, endpointExtIDs(cfgEp), pktTag, burst, bandwidth)
 	if err != nil {
 		return err
 	}
@@ -364,22 +526,37 @@ func (sw *OvsSwitch) CreatePort(intfName string, cfgEp *mastercfg.CfgEndpointSta
 
 	// Set the link mtu to 1450 to allow for 50 bytes vxlan encap
 	// (inner eth header(14) + outer IP(20) outer UDP(8) + vxlan header(8))
+	linkMtu := sw.vxlanEncapMtu
 	if sw.netType == "vxlan" {
-		correctMtu := sw.vxlanEncapMtu - 50 //Include Vxlan header size
-		err = setLinkMtu(intfName, correctMtu)
-	} else {
-		err = setLinkMtu(intfName, sw.vxlanEncapMtu)
+		linkMtu = sw.vxlanEncapMtu - 50 //Include Vxlan header size
 	}
-	if err != nil {
-		log.Errorf("Error setting link %s mtu. Err: %v", intfName, err)
-		return err
+	if cfgEp.MTU != 0 {
+		linkMtu = cfgEp.MTU
+	} else if nwMtu != 0 && (nwMtuChecked || nwMtu < linkMtu) {
+		linkMtu = nwMtu
+	}
+	if sw.ovsdbDriver.remote {
+		err = sw.ovsdbDriver.SetInterfaceLink(intfName, linkMtu, cfgEp.MacAddress)
+		if err != nil {
+			log.Errorf("Error setting link %s mtu and mac. Err: %v", intfName, err)
+			return err
+		}
+	} else {
+		err = setLinkMtu(intfName, linkMtu)
+		if err != nil {
+			log.Errorf("Error setting link %s mtu. Err: %v", intfName, err)
+			return err
+		}
 	}
 
-	// Set the interface mac address
-	err = netutils.SetInterfaceMac(intfName, cfgEp.MacAddress)
-	if err != nil {
-		log.Errorf("Error setting interface Mac %s on port %s", cfgEp.MacAddress, intfName)
-		return err
+	// Set the interface mac address. The mac of a tap endpoint is the one
+	// of the vm nic, the hypervisor sets it.
+	if !cfgEp.Tap && !sw.ovsdbDriver.remote {
+		err = netutils.SetInterfaceMac(intfName, cfgEp.MacAddress)
+		if err != nil {
+			log.Errorf("Error setting interface Mac %s on port %s", cfgEp.MacAddress, intfName)
+			return err
+		}
 	}
 
 	// Add the endpoint to ofnet
@@ -412,7 +589,7 @@ func (sw *OvsSwitch) CreatePort(intfName string, cfgEp *mastercfg.CfgEndpointSta
 
 	// Add the local port to ofnet
 	err = sw.ofnetAgent.AddLocalEndpoint(endpoint)
-
+	core.Audit("ofnet add endpoint", []string{ovsPortName, cfgEp.IPAddress, cfgEp.MacAddress}, err)
 	if err != nil {
 		log.Errorf("Error adding local port %s to ofnet. Err: %v", ovsPortName, err)
 		return err
@@ -443,6 +620,7 @@ func (sw *OvsSwitch) UpdateEndpoint(ovsPortName string, burst, dscp int, epgBand
 
 	// update endpoint state in ofnet
 	err = sw.ofnetAgent.UpdateLocalEndpoint(endpoint)
+	core.Audit("ofnet update endpoint", []string{ovsPortName, "dscp=" + strconv.Itoa(dscp)}, err)
 	if err != nil {
 		log.Errorf("Error updating local port %s to ofnet. Err: %v", ovsPortName, err)
 		return err
@@ -485,6 +663,66 @@ func (sw *OvsSwitch) UpdatePort(intfName string, cfgEp *mastercfg.CfgEndpointSta
 		return nil
 	}
 	err = sw.ofnetAgent.AddLocalEndpoint(endpoint)
+	core.Audit("ofnet add endpoint", []string{ovsPortName, cfgEp.IPAddress, cfgEp.MacAddress}, err)
+	if err != nil {
+		log.Errorf("Error adding local port %s to ofnet. Err: %v", ovsPortName, err)
+		return err
+	}
+	return nil
+}
+
+// MovePort rewires an existing OVS port for the network, address and group
+// of cfgEp. The local endpoint is removed from ofnet and added back with
+// its new tags, the port and its interface are kept.
+func (sw *OvsSwitch) MovePort(intfName string, cfgEp *mastercfg.CfgEndpointState, pktTag, nwPktTag, dscp int, skipVethPair bool) error {
+	ovsPortName := getOvsPortName(intfName, skipVethPair)
+	ofpPort, err := sw.ovsdbDriver.GetOfpPortNo(ovsPortName)
+	if err != nil {
+		log.Errorf("Could not find the OVS port %s. Err: %v", ovsPortName, err)
+		return err
+	}
+
+	if sw.ofnetAgent != nil {
+		err = sw.ofnetAgent.RemoveLocalEndpoint(ofpPort)
+		core.Audit("ofnet remove endpoint", []string{ovsPortName}, err)
+		if err != nil {
+			log.Errorf("Error removing local port %s from ofnet. Err: %v", ovsPortName, err)
+			return err
+		}
+	}
+
+	err = sw.ovsdbDriver.SetPortTag(ovsPortName, pktTag)
+	if err != nil {
+		log.Errorf("Error setting the tag of port %s. Err: %v", ovsPortName, err)
+		return err
+	}
+	err = sw.ovsdbDriver.SetPortExternalIDs(ovsPortName, endpointExtIDs(cfgEp))
+	if err != nil {
+		log.Errorf("Error labeling port %s. Err: %v", ovsPortName, err)
+		return err
+	}
+
+	if sw.ofnetAgent == nil {
+		log.Infof("Skipping adding localport to ofnet")
+		return nil
+	}
+	macAddr, _ := net.ParseMAC(cfgEp.MacAddress)
+	endpoint := ofnet.EndpointInfo{
+		PortNo:            ofpPort,
+		MacAddr:           macAddr,
+		Vlan:              uint16(nwPktTag),
+		IpAddr:            net.ParseIP(cfgEp.IPAddress),
+		Ipv6Addr:          net.ParseIP(cfgEp.IPv6Address),
+		EndpointGroup:     cfgEp.EndpointGroupID,
+		EndpointGroupVlan: uint16(pktTag),
+		Dscp:              dscp,
+		HostPvtIP:         sw.getPvtIP(ovsPortName),
+	}
+
+	log.Infof("Moving local endpoint: {%+v}", endpoint)
+
+	err = sw.ofnetAgent.AddLocalEndpoint(endpoint)
+	core.Audit("ofnet add endpoint", []string{ovsPortName, cfgEp.IPAddress, cfgEp.MacAddress}, err)
 	if err != nil {
 		log.Errorf("Error adding local port %s to ofnet. Err: %v", ovsPortName, err)
 		return err
@@ -507,6 +745,7 @@ func (sw *OvsSwitch) DeletePort(epOper *drivers.OperEndpointState, skipVethPair
 	if err == nil {
 		if sw.ofnetAgent != nil {
 			err = sw.ofnetAgent.RemoveLocalEndpoint(ofpPort)
+			core.Audit("ofnet remove endpoint", []string{ovsPortName, epOper.IPAddress}, err)
 		}
 	} else {
 		if sw.ofnetAgent != nil {
@@ -519,6 +758,7 @@ func (sw *OvsSwitch) DeletePort(epOper *drivers.OperEndpointState, skipVethPair
 			}
 			epID := sw.ofnetAgent.GetEndpointIdByIpVrf(net.ParseIP(epOper.IPAddress), tenantName)
 			err = sw.ofnetAgent.RemoveLocalEndpointByID(epID)
+			core.Audit("ofnet remove endpoint", []string{epID, epOper.IPAddress}, err)
 		}
 	}
 	if err != nil {
@@ -533,8 +773,25 @@ func (sw *OvsSwitch) DeletePort(epOper *drivers.OperEndpointState, skipVethPair
 		// continue with further cleanup
 	}
 
-	// Delete the Veth pairs if required
+	if epOper.Bonded {
+		if berr := deleteBond(epOper.PortName); berr != nil {
+			log.Errorf("Error deleting bond. Err: %v", berr)
+			return berr
+		}
+	}
+	if epOper.Tap {
+		if terr := deleteTap(epOper.PortName); terr != nil {
+			log.Errorf("Error deleting tap. Err: %v", terr)
+			return terr
+		}
+	}
+
+	// Delete the Veth pairs if required, or keep them for other endpoints.
+	// Pairs with an alternate name are not reused.
 	if useVethPair && !skipVethPair {
+		if epOper.HostIntf == "" && sw.vethPool.put(epOper.PortName) {
+			return err
+		}
 		// Delete a Veth pair
 		verr := deleteVethPair(ovsPortName, epOper.PortName)
 		if verr != nil {
@@ -581,6 +838,7 @@ func (sw *OvsSwitch) CreateVtep(vtepIP string) error {
 	// Add info about VTEP port to ofnet
 	if sw.ofnetAgent != nil {
 		err = sw.ofnetAgent.AddVtepPort(ofpPort, net.ParseIP(vtepIP))
+		core.Audit("ofnet add vtep", []string{intfName, vtepIP}, err)
 		if err != nil {
 			log.Errorf("Error adding VTEP port %s to ofnet. Err: %v", intfName, err)
 			return err
@@ -607,6 +865,7 @@ func (sw *OvsSwitch) DeleteVtep(vtepIP string) error {
 	// Add info about VTEP port to ofnet
 	if sw.ofnetAgent != nil {
 		err = sw.ofnetAgent.RemoveVtepPort(ofpPort, net.ParseIP(vtepIP))
+		core.Audit("ofnet remove vtep", []string{intfName, vtepIP}, err)
 		if err != nil {
 			log.Errorf("Error deleting VTEP port %s to ofnet. Err: %v", intfName, err)
 			return err
@@ -650,8 +909,12 @@ func (sw *OvsSwitch) cleanupOldUplinkState(portName string, intfList []string) (
 		return false, nil
 	}
 
-	/* Cleanup any other individual ports that may exist */
+	/* Cleanup any other individual ports that may exist, the ports that
+	   are not netplugin's are left alone */
 	for _, intf := range intfList {
+		if err = sw.ovsdbDriver.checkPortOwned(intf); err != nil {
+			break
+		}
 		if sw.ovsdbDriver.IsIntfNamePresent(intf) {
 			log.Infof("Deleting old uplink port: %+v", intf)
 			err = sw.ovsdbDriver.DeletePort(intf)
@@ -695,7 +958,7 @@ func (sw *OvsSwitch) AddUplink(uplinkName string, intfList []string) error {
 		} else {
 			log.Debugf("Creating uplink port: %s", intfList[0])
 			// Ask OVSDB driver to add the port as a trunk port
-			err = sw.ovsdbDriver.CreatePort(intfList[0], "", uplinkName, 0, 0, 0)
+			err = sw.ovsdbDriver.CreatePort(intfList[0], "", uplinkName, nil, 0, 0, 0)
 			if err != nil {
 				log.Errorf("Error adding uplink %s to OVS. Err: %v", intfList[0], err)
 				return err
@@ -757,6 +1020,46 @@ func (sw *OvsSwitch) AddUplink(uplinkName string, intfList []string) error {
 	return nil
 }
 
+// underlayLinks returns the host interfaces carrying the traffic of the
+// switch: the uplinks of a vlan switch, the interface of the vtep address
+// of a vxlan switch
+func (sw *OvsSwitch) underlayLinks() ([]string, error) {
+	if sw.netType == "vxlan" {
+		intfName, err := netutils.GetAddrIntfName(sw.vtepIP)
+		if err != nil {
+			return nil, err
+		}
+		return []string{intfName}, nil
+	}
+
+	links := []string{}
+	for intfListObj := range sw.uplinkDb.IterBuffered() {
+		links = append(links, intfListObj.Val.([]string)...)
+	}
+	return links, nil
+}
+
+// CheckUnderlayMtu verifies that the underlay links of the switch support
+// the mtu required by a network
+func (sw *OvsSwitch) CheckUnderlayMtu(mtu int) error {
+	links, err := sw.underlayLinks()
+	if err != nil {
+		return core.Errorf("error finding the underlay links of %s: %v", sw.bridgeName, err)
+	}
+
+	for _, link := range links {
+		intf, err := net.InterfaceByName(link)
+		if err != nil {
+			return core.Errorf("error reading the mtu of underlay link %s: %v", link, err)
+		}
+		if intf.MTU < mtu {
+			return core.Errorf("mtu %d of underlay link %s is smaller than the %d required by the network",
+				intf.MTU, link, mtu)
+		}
+	}
+	return nil
+}
+
 // HandleLinkUpdates handle link updates and update the datapath
 func (sw *OvsSwitch) HandleLinkUpdates(linkUpd ofnet.LinkUpdateInfo) {
 	for intfListObj := range sw.uplinkDb.IterBuffered() {
@@ -849,22 +1152,31 @@ func (sw *OvsSwitch) AddHostPort(intfName string, intfNum, network int, isHostNS
 
 	portID := "host" + intfName
 
-	// If the port already exists in OVS, remove it first
-	if sw.ovsdbDriver.IsPortNamePresent(ovsPortName) {
-		log.Infof("Removing existing interface entry %s from OVS", ovsPortName)
+	// The port left by a previous run is adopted, another port of the
+	// same name is removed first unless it is not netplugin's
+	owner, present := sw.ovsdbDriver.portOwner(ovsPortName)
+	if present && owner == "" {
+		return "", core.Errorf("port %s exists and is not managed by netplugin", ovsPortName)
+	}
+	if present && owner == portID {
+		log.Infof("Adopting existing host port %s", ovsPortName)
+	} else {
+		if present {
+			log.Infof("Removing existing interface entry %s from OVS", ovsPortName)
 
-		// Delete it from ovsdb
-		err := sw.ovsdbDriver.DeletePort(ovsPortName)
-		if err != nil {
-			log.Errorf("Error deleting port %s from OVS. Err: %v", ovsPortName, err)
+			// Delete it from ovsdb
+			err := sw.ovsdbDriver.DeletePort(ovsPortName)
+			if err != nil {
+				log.Errorf("Error deleting port %s from OVS. Err: %v", ovsPortName, err)
+			}
 		}
-	}
 
-	// Ask OVSDB driver to add the port as an access port
-	err = sw.ovsdbDriver.CreatePort(ovsPortName, ovsPortType, portID, hostVLAN, 0, 0)
-	if err != nil {
-		log.Errorf("Error adding hostport %s to OVS. Err: %v", intfName, err)
-		return "", err
+		// Ask OVSDB driver to add the port as an access port
+		err = sw.ovsdbDriver.CreatePort(ovsPortName, ovsPortType, portID, nil, hostVLAN, 0, 0)
+		if err != nil {
+			log.Errorf("Error adding hostport %s to OVS. Err: %v", intfName, err)
+			return "", err
+		}
 	}
 
 	// Get the openflow port number for the interface
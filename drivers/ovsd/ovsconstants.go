@@ -17,6 +17,9 @@ const (
 	bridgeTable     = "Bridge"
 	portTable       = "Port"
 	interfaceTable  = "Interface"
+	ipfixTable      = "IPFIX"
+	sampleSetTable  = "Flow_Sample_Collector_Set"
+	mirrorTable     = "Mirror"
 	vlanBridgeName  = "contivVlanBridge"
 	vxlanBridgeName = "contivVxlanBridge"
 	portNameFmt     = "port%d"
@@ -16,10 +16,16 @@ limitations under the License.
 package ovsd
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"net"
 	"reflect"
 	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -33,29 +39,173 @@ import (
 // Max number of retries to get ofp port number
 const maxOfportRetry = 20
 
+// ovsdb connection attempts before giving up, and the delay between them
+const (
+	ovsdbConnectRetries  = 5
+	ovsdbConnectInterval = time.Second
+)
+
+// OvsdbConnError is returned when the ovsdb server can not be reached, as
+// opposed to the server failing an operation
+type OvsdbConnError struct {
+	Endpoint string
+	Err      error
+}
+
+func (e *OvsdbConnError) Error() string {
+	return fmt.Sprintf("ovsdb %s unreachable: %v", e.Endpoint, e.Err)
+}
+
+// Keys of the external_ids set on endpoint ports and interfaces
+const (
+	extIDEndpoint  = "endpoint-id"
+	extIDNetwork   = "network-id"
+	extIDContainer = "container-id"
+)
+
+// EndpointPort is an endpoint port as labeled in ovsdb
+type EndpointPort struct {
+	Name        string `json:"name"`
+	OfPort      int    `json:"ofport"`
+	EndpointID  string `json:"endpointId"`
+	NetworkID   string `json:"networkId"`
+	ContainerID string `json:"containerId"`
+}
+
+type endpointPortsByName []EndpointPort
+
+func (p endpointPortsByName) Len() int           { return len(p) }
+func (p endpointPortsByName) Less(i, j int) bool { return p[i].Name < p[j].Name }
+func (p endpointPortsByName) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
 // OvsdbDriver is responsible for programming OVS using ovsdb protocol. It also
 // implements the libovsdb.Notifier interface to keep cache of ovs table state.
 type OvsdbDriver struct {
 	ovsSwitch    *OvsSwitch
 	bridgeName   string // Name of the bridge we are operating on
+	endpoint     string // ovsdb server, empty for the local socket
+	remote       bool   // the ovsdb server is not on this host
 	ovs          *libovsdb.OvsdbClient
 	cache        map[string]map[libovsdb.UUID]libovsdb.Row
 	cacheLock    sync.RWMutex // lock to protect cache accesses
 	vxlanUDPPort string       // VxLAN UDP port number
+	// linkHandler is called when the link of an endpoint interface changes
+	linkHandler func(endpointID string, up bool)
+
+	// vtepPSK is the IPsec pre-shared key of the vtep tunnels, which are
+	// not encrypted when it is empty
+	vtepPSK  string
+	vtepLock sync.Mutex // serializes vtep creation with rekeys
+}
+
+// parseOvsdbEndpoint splits an ovsdb endpoint, unix:path, tcp:host:port or
+// ssl:host:port as given to ovs-vsctl --db, into its network and address
+func parseOvsdbEndpoint(endpoint string) (string, string, error) {
+	if endpoint == "" {
+		return "unix", libovsdb.DEFAULT_SOCK, nil
+	}
+
+	parts := strings.SplitN(endpoint, ":", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return "", "", core.Errorf("invalid ovsdb endpoint %q", endpoint)
+	}
+	switch parts[0] {
+	case "unix":
+		return "unix", parts[1], nil
+	case "tcp", "ssl":
+		_, port, err := net.SplitHostPort(parts[1])
+		if err != nil {
+			return "", "", core.Errorf("invalid ovsdb endpoint %q: %v", endpoint, err)
+		}
+		if portNo, err := strconv.Atoi(port); err != nil || portNo <= 0 || portNo > 65535 {
+			return "", "", core.Errorf("invalid port in ovsdb endpoint %q", endpoint)
+		}
+		return parts[0], parts[1], nil
+	}
+	return "", "", core.Errorf("unknown protocol in ovsdb endpoint %q", endpoint)
+}
+
+// remoteOvsdb returns true when the ovsdb server at endpoint is reached over
+// the network, the switch then is on another host
+func remoteOvsdb(endpoint string) bool {
+	network, _, _ := parseOvsdbEndpoint(endpoint)
+	return network == "tcp" || network == "ssl"
+}
+
+// ovsdbTLSConfig returns the TLS config of the ssl ovsdb endpoint of cfg at
+// addr. The server certificate is checked against the CA certificate.
+func ovsdbTLSConfig(cfg *core.OvsDriverConfig, addr string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(cfg.OvsdbCert, cfg.OvsdbKey)
+	if err != nil {
+		return nil, core.Errorf("error loading the ovsdb certificate. Err: %v", err)
+	}
+	caCert, err := ioutil.ReadFile(cfg.OvsdbCACert)
+	if err != nil {
+		return nil, core.Errorf("error reading the ovsdb CA certificate. Err: %v", err)
+	}
+	caPool := x509.NewCertPool()
+	if !caPool.AppendCertsFromPEM(caCert) {
+		return nil, core.Errorf("no certificate found in %s", cfg.OvsdbCACert)
+	}
+
+	host, _, _ := net.SplitHostPort(addr)
+	return &tls.Config{Certificates: []tls.Certificate{cert}, RootCAs: caPool, ServerName: host}, nil
+}
+
+// connectOvsdb connects to the ovsdb server of cfg, retrying for a while as
+// the server may be restarting
+func connectOvsdb(cfg *core.OvsDriverConfig) (*libovsdb.OvsdbClient, error) {
+	network, addr, err := parseOvsdbEndpoint(cfg.OvsdbEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	var tlsCfg *tls.Config
+	if network == "ssl" {
+		tlsCfg, err = ovsdbTLSConfig(cfg, addr)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for i := 0; ; i++ {
+		var ovs *libovsdb.OvsdbClient
+		switch network {
+		case "unix":
+			ovs, err = libovsdb.ConnectUnix(addr)
+		case "ssl":
+			ovs, err = connectOvsdbTLS(addr, tlsCfg)
+		default:
+			host, port, _ := net.SplitHostPort(addr)
+			portNo, _ := strconv.Atoi(port)
+			ovs, err = libovsdb.Connect(host, portNo)
+		}
+		if err == nil {
+			return ovs, nil
+		}
+		if i == ovsdbConnectRetries {
+			return nil, &OvsdbConnError{Endpoint: addr, Err: err}
+		}
+
+		log.Warnf("Error connecting to ovsdb %s, retrying. Err: %v", addr, err)
+		time.Sleep(ovsdbConnectInterval)
+	}
 }
 
 // NewOvsdbDriver creates a new OVSDB driver instance.
-// Create one ovsdb driver instance per OVS bridge that needs to be managed
-func NewOvsdbDriver(bridgeName string, failMode string, vxlanUDPPort int) (*OvsdbDriver, error) {
+// Create one ovsdb driver instance per OVS bridge that needs to be managed.
+// The ovsdb server of the switch is the one of ovsCfg.
+func NewOvsdbDriver(ovsCfg *core.OvsDriverConfig, bridgeName string, failMode string, vxlanUDPPort int) (*OvsdbDriver, error) {
 	// Create a new driver instance
 	d := new(OvsdbDriver)
 	d.bridgeName = bridgeName
+	d.endpoint = ovsCfg.OvsdbEndpoint
+	d.remote = remoteOvsdb(ovsCfg.OvsdbEndpoint)
 	d.vxlanUDPPort = fmt.Sprintf("%d", vxlanUDPPort)
 
 	// Connect to OVS
-	ovs, err := libovsdb.ConnectUnix("")
+	ovs, err := connectOvsdb(ovsCfg)
 	if err != nil {
-		log.Fatalf("Error connecting to OVS. Err: %v", err)
+		log.Errorf("Error connecting to OVS. Err: %v", err)
 		return nil, err
 	}
 
@@ -64,22 +214,27 @@ func NewOvsdbDriver(bridgeName string, failMode string, vxlanUDPPort int) (*Ovsd
 	// Initialize the cache
 	d.cache = make(map[string]map[libovsdb.UUID]libovsdb.Row)
 	d.ovs.Register(d)
-	initial, _ := d.ovs.MonitorAll(ovsDataBase, "")
+	initial, err := d.ovs.MonitorAll(ovsDataBase, "")
+	if err != nil {
+		(*d.ovs).Disconnect()
+		return nil, &OvsdbConnError{Endpoint: d.endpoint, Err: err}
+	}
 	d.populateCache(*initial)
 
 	// Create a bridge after registering for events as we depend on ovsdb cache.
 	// Since the same dirver is used as endpoint driver, only create the bridge
-	// if it's not already created
+	// if it's not already created. A bridge left by a previous run is adopted,
+	// unless it is labeled with another owner.
 	// XXX: revisit if the bridge-name needs to be configurable
-	brCreated := false
-	for _, row := range d.cache[bridgeTable] {
-		if row.Fields["name"] == bridgeName {
-			brCreated = true
-			break
-		}
+	owner, brCreated := d.bridgeOwner(bridgeName)
+	if brCreated && owner != "" && owner != ownerNetplugin {
+		(*d.ovs).Disconnect()
+		return nil, core.Errorf("bridge %s is owned by %s", bridgeName, owner)
 	}
 
-	if !brCreated {
+	if brCreated {
+		log.Infof("Adopting existing bridge %s", bridgeName)
+	} else {
 		err = d.createDeleteBridge(bridgeName, failMode, operCreateBridge)
 		if err != nil {
 			log.Fatalf("Error creating bridge %s. Err: %v", bridgeName, err)
@@ -90,6 +245,13 @@ func NewOvsdbDriver(bridgeName string, failMode string, vxlanUDPPort int) (*Ovsd
 	return d, nil
 }
 
+// Close disconnects from the ovsdb server, the bridge is left alone
+func (d *OvsdbDriver) Close() {
+	if d.ovs != nil {
+		(*d.ovs).Disconnect()
+	}
+}
+
 // Delete : Cleanup the ovsdb driver. delete the bridge we created.
 func (d *OvsdbDriver) Delete() error {
 	if d.ovs != nil {
@@ -145,6 +307,7 @@ func (d *OvsdbDriver) Update(context interface{}, tableUpdates libovsdb.TableUpd
 	if !ok {
 		return
 	}
+	d.notifyLinkChanges(intfUpds)
 
 	for _, intfUpd := range intfUpds.Rows {
 		intf := intfUpd.New.Fields["name"]
@@ -169,6 +332,59 @@ func (d *OvsdbDriver) Update(context interface{}, tableUpdates libovsdb.TableUpd
 	}
 }
 
+// SetLinkStateHandler sets the function called with the endpoint id of an
+// interface whose link goes down or up, nil stops the notifications
+func (d *OvsdbDriver) SetLinkStateHandler(handler func(endpointID string, up bool)) {
+	d.cacheLock.Lock()
+	defer d.cacheLock.Unlock()
+	d.linkHandler = handler
+}
+
+// notifyLinkChanges calls the link state handler for the endpoint interfaces
+// whose link changed in an update
+func (d *OvsdbDriver) notifyLinkChanges(intfUpds libovsdb.TableUpdate) {
+	d.cacheLock.RLock()
+	handler := d.linkHandler
+	d.cacheLock.RUnlock()
+	if handler == nil {
+		return
+	}
+
+	for epID, up := range endpointLinkChanges(intfUpds) {
+		handler(epID, up)
+	}
+}
+
+// endpointLinkChanges returns the link state of the endpoint interfaces whose
+// link went down or up in an update, by endpoint id. The link state of new
+// and deleted interfaces is not a change.
+func endpointLinkChanges(intfUpds libovsdb.TableUpdate) map[string]bool {
+	changes := make(map[string]bool)
+	for _, intfUpd := range intfUpds.Rows {
+		// an unset link state is an empty set, not a string
+		oldState, ok := intfUpd.Old.Fields["link_state"].(string)
+		if !ok {
+			continue
+		}
+		newState, ok := intfUpd.New.Fields["link_state"].(string)
+		if !ok || newState == oldState {
+			continue
+		}
+		extIDs, ok := intfUpd.New.Fields["external_ids"].(libovsdb.OvsMap)
+		if !ok {
+			continue
+		}
+		epID, ok := extIDs.GoMap[extIDEndpoint].(string)
+		if !ok || epID == "" {
+			continue
+		}
+
+		log.Infof("Link of interface %v of endpoint %s is %s", intfUpd.New.Fields["name"], epID, newState)
+		changes[epID] = newState == "up"
+	}
+	return changes
+}
+
 // Locked satisfies a libovsdb interface dependency.
 func (d *OvsdbDriver) Locked([]interface{}) {
 }
@@ -182,7 +398,30 @@ func (d *OvsdbDriver) Echo([]interface{}) {
 }
 
 func (d *OvsdbDriver) performOvsdbOps(ops []libovsdb.Operation) error {
-	reply, _ := d.ovs.Transact(ovsDataBase, ops...)
+	err := d.transact(ops)
+	core.Audit("ovsdb transact", ovsdbOpParams(ops), err)
+	return err
+}
+
+// ovsdbOpParams describes ovsdb operations for the audit trail
+func ovsdbOpParams(ops []libovsdb.Operation) []string {
+	params := make([]string, 0, len(ops))
+	for _, op := range ops {
+		param := op.Op + " " + op.Table
+		if name, ok := op.Row["name"].(string); ok {
+			param += " " + name
+		}
+		params = append(params, param)
+	}
+	return params
+}
+
+func (d *OvsdbDriver) transact(ops []libovsdb.Operation) error {
+	reply, err := d.ovs.Transact(ovsDataBase, ops...)
+	if err != nil {
+		log.Errorf("Error sending ovs operation %+v. Err: %v", ops, err)
+		return err
+	}
 	if len(reply) < len(ops) {
 		return core.Errorf("Unexpected number of replies. Expected: %d, Recvd: %d",
 			len(ops), len(reply))
@@ -231,6 +470,7 @@ func (d *OvsdbDriver) createDeleteBridge(bridgeName, failMode string, op oper) e
 		if failMode != "" {
 			bridge["fail_mode"] = "secure"
 		}
+		bridge["external_ids"], _ = libovsdb.NewOvsMap(map[string]string{extIDOwner: ownerNetplugin})
 
 		brOp = libovsdb.Operation{
 			Op:       opStr,
@@ -288,7 +528,7 @@ func (d *OvsdbDriver) GetPortOrIntfNameFromID(id string, isPort bool) (string, e
 	for _, row := range d.cache[table] {
 		if extIDs, ok := row.Fields["external_ids"]; ok {
 			extIDMap := extIDs.(libovsdb.OvsMap).GoMap
-			if portID, ok := extIDMap["endpoint-id"]; ok && portID == id {
+			if portID, ok := extIDMap[extIDEndpoint]; ok && portID == id {
 				return row.Fields["name"].(string), nil
 			}
 		}
@@ -296,8 +536,84 @@ func (d *OvsdbDriver) GetPortOrIntfNameFromID(id string, isPort bool) (string, e
 	return "", core.Errorf("Ovs port/intf not found for id: %s", id)
 }
 
-// CreatePort creates an OVS port
-func (d *OvsdbDriver) CreatePort(intfName, intfType, id string, tag, burst int, bandwidth int64) error {
+// GetEndpointPorts returns the interfaces labeled with the network of an
+// endpoint, sorted by name
+func (d *OvsdbDriver) GetEndpointPorts() []EndpointPort {
+	d.cacheLock.RLock()
+	defer d.cacheLock.RUnlock()
+
+	ports := []EndpointPort{}
+	for _, row := range d.cache[interfaceTable] {
+		extIDs, ok := row.Fields["external_ids"].(libovsdb.OvsMap)
+		if !ok {
+			continue
+		}
+		netID, ok := extIDs.GoMap[extIDNetwork]
+		if !ok {
+			continue
+		}
+
+		port := EndpointPort{Name: row.Fields["name"].(string), OfPort: -1}
+		port.NetworkID, _ = netID.(string)
+		port.EndpointID, _ = extIDs.GoMap[extIDEndpoint].(string)
+		port.ContainerID, _ = extIDs.GoMap[extIDContainer].(string)
+		if ofPort, ok := row.Fields["ofport"].(float64); ok {
+			port.OfPort = int(ofPort)
+		}
+		ports = append(ports, port)
+	}
+
+	sort.Sort(endpointPortsByName(ports))
+	return ports
+}
+
+// SetPortExternalIDs replaces the given keys in the external_ids of a port
+// and its interface, leaving the other keys alone
+func (d *OvsdbDriver) SetPortExternalIDs(portName string, extIDs map[string]string) error {
+	keys := make([]string, 0, len(extIDs))
+	for key := range extIDs {
+		keys = append(keys, key)
+	}
+	delKeys, err := libovsdb.NewOvsSet(keys)
+	if err != nil {
+		return err
+	}
+	addKeys, err := libovsdb.NewOvsMap(extIDs)
+	if err != nil {
+		return err
+	}
+
+	condition := libovsdb.NewCondition("name", "==", portName)
+	operations := []libovsdb.Operation{}
+	for _, table := range []string{portTable, interfaceTable} {
+		operations = append(operations, libovsdb.Operation{
+			Op:    "mutate",
+			Table: table,
+			Mutations: []interface{}{
+				libovsdb.NewMutation("external_ids", "delete", delKeys),
+				libovsdb.NewMutation("external_ids", "insert", addKeys),
+			},
+			Where: []interface{}{condition},
+		})
+	}
+
+	return d.performOvsdbOps(operations)
+}
+
+// CreatePort creates an OVS port. extIDs are set in the external_ids of the
+// port and interface along with the endpoint id.
+func (d *OvsdbDriver) CreatePort(intfName, intfType, id string, extIDs map[string]string, tag, burst int, bandwidth int64) error {
+	operations, err := d.createPortOps(intfName, intfType, id, extIDs, tag, burst, bandwidth)
+	if err != nil {
+		return err
+	}
+	return d.performOvsdbOps(operations)
+}
+
+// createPortOps returns the operations of CreatePort. The new port can be
+// referred to by its name as a named uuid later in the same transaction.
+func (d *OvsdbDriver) createPortOps(intfName, intfType, id string, extIDs map[string]string,
+	tag, burst int, bandwidth int64) ([]libovsdb.Operation, error) {
 	// intfName is assumed to be unique enough to become uuid
 	portUUIDStr := intfName
 	intfUUIDStr := fmt.Sprintf("Intf%s", intfName)
@@ -319,10 +635,13 @@ func (d *OvsdbDriver) CreatePort(intfName, intfType, id string, tag, burst int,
 	if burst != 0 {
 		intf["ingress_policing_burst"] = burst
 	}
-	idMap["endpoint-id"] = id
+	for key, val := range extIDs {
+		idMap[key] = val
+	}
+	idMap[extIDEndpoint] = id
 	intf["external_ids"], err = libovsdb.NewOvsMap(idMap)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// interface table ops
@@ -345,11 +664,11 @@ func (d *OvsdbDriver) CreatePort(intfName, intfType, id string, tag, burst int,
 	}
 	port["interfaces"], err = libovsdb.NewOvsSet(intfUUID)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	port["external_ids"], err = libovsdb.NewOvsMap(idMap)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	portOp = libovsdb.Operation{
 		Op:       opStr,
@@ -369,6 +688,59 @@ func (d *OvsdbDriver) CreatePort(intfName, intfType, id string, tag, burst int,
 		Where:     []interface{}{condition},
 	}
 
+	return []libovsdb.Operation{intfOp, portOp, mutateOp}, nil
+}
+
+// CreatePatchPort creates an OVS patch port to peerName, an access port of
+// vlan tag
+func (d *OvsdbDriver) CreatePatchPort(intfName, peerName string, tag int) error {
+	portUUIDStr := intfName
+	intfUUIDStr := fmt.Sprintf("Intf%s", intfName)
+	portUUID := []libovsdb.UUID{{GoUuid: portUUIDStr}}
+	intfUUID := []libovsdb.UUID{{GoUuid: intfUUIDStr}}
+	opStr := "insert"
+	var err error
+
+	intf := make(map[string]interface{})
+	intf["name"] = intfName
+	intf["type"] = "patch"
+	intf["options"], err = libovsdb.NewOvsMap(map[string]string{"peer": peerName})
+	if err != nil {
+		return err
+	}
+	intfOp := libovsdb.Operation{
+		Op:       opStr,
+		Table:    interfaceTable,
+		Row:      intf,
+		UUIDName: intfUUIDStr,
+	}
+
+	port := make(map[string]interface{})
+	port["name"] = intfName
+	port["vlan_mode"] = "access"
+	port["tag"] = tag
+	port["interfaces"], err = libovsdb.NewOvsSet(intfUUID)
+	if err != nil {
+		return err
+	}
+	portOp := libovsdb.Operation{
+		Op:       opStr,
+		Table:    portTable,
+		Row:      port,
+		UUIDName: portUUIDStr,
+	}
+
+	// mutate the Ports column of the row in the Bridge table
+	mutateSet, _ := libovsdb.NewOvsSet(portUUID)
+	mutation := libovsdb.NewMutation("ports", opStr, mutateSet)
+	condition := libovsdb.NewCondition("name", "==", d.bridgeName)
+	mutateOp := libovsdb.Operation{
+		Op:        "mutate",
+		Table:     bridgeTable,
+		Mutations: []interface{}{mutation},
+		Where:     []interface{}{condition},
+	}
+
 	operations := []libovsdb.Operation{intfOp, portOp, mutateOp}
 	return d.performOvsdbOps(operations)
 }
@@ -556,6 +928,141 @@ func (d *OvsdbDriver) DeletePortBond(bondName string, intfList []string) error {
 	return d.performOvsdbOps(ops)
 }
 
+// SetBridgeOptions applies the stp, multicast snooping and mac ageing
+// settings of the plugin config to the bridge
+func (d *OvsdbDriver) SetBridgeOptions(opts *core.OvsDriverConfig) error {
+	bridge := make(map[string]interface{})
+	bridge["stp_enable"] = opts.EnableSTP
+	bridge["mcast_snooping_enable"] = opts.EnableMcastSnooping
+
+	condition := libovsdb.NewCondition("name", "==", d.bridgeName)
+	updateOp := libovsdb.Operation{
+		Op:    "update",
+		Table: bridgeTable,
+		Row:   bridge,
+		Where: []interface{}{condition},
+	}
+	operations := []libovsdb.Operation{updateOp}
+
+	// replace the ageing time, leaving the rest of other_config alone
+	if opts.AgeingTime != 0 {
+		delKeys, _ := libovsdb.NewOvsSet([]string{"mac-aging-time"})
+		addKeys, _ := libovsdb.NewOvsMap(map[string]string{
+			"mac-aging-time": strconv.Itoa(opts.AgeingTime),
+		})
+		mutateOp := libovsdb.Operation{
+			Op:    "mutate",
+			Table: bridgeTable,
+			Mutations: []interface{}{
+				libovsdb.NewMutation("other_config", "delete", delKeys),
+				libovsdb.NewMutation("other_config", "insert", addKeys),
+			},
+			Where: []interface{}{condition},
+		}
+		operations = append(operations, mutateOp)
+	}
+
+	return d.performOvsdbOps(operations)
+}
+
+// SetInterfaceLink requests the mtu and mac address of interface intfName
+// from the switch, for interfaces that are not on this host. An empty mac
+// is left alone.
+func (d *OvsdbDriver) SetInterfaceLink(intfName string, mtu int, mac string) error {
+	intf := make(map[string]interface{})
+	intf["mtu_request"] = mtu
+	if mac != "" {
+		intf["mac"] = mac
+	}
+
+	condition := libovsdb.NewCondition("name", "==", intfName)
+	updateOp := libovsdb.Operation{
+		Op:    "update",
+		Table: interfaceTable,
+		Row:   intf,
+		Where: []interface{}{condition},
+	}
+	return d.performOvsdbOps([]libovsdb.Operation{updateOp})
+}
+
+// SetDatapathLimits sets the datapath flow limit and idle timeout in the
+// global ovs config, leaving the rest of other_config alone
+func (d *OvsdbDriver) SetDatapathLimits(cfg *core.OvsDriverConfig) error {
+	delKeys, _ := libovsdb.NewOvsSet([]string{"flow-limit", "max-idle"})
+	addKeys, _ := libovsdb.NewOvsMap(map[string]string{
+		"flow-limit": strconv.Itoa(cfg.FlowLimit),
+		"max-idle":   strconv.Itoa(cfg.MaxIdle),
+	})
+
+	condition := libovsdb.NewCondition("_uuid", "==", d.getRootUUID())
+	mutateOp := libovsdb.Operation{
+		Op:    "mutate",
+		Table: rootTable,
+		Mutations: []interface{}{
+			libovsdb.NewMutation("other_config", "delete", delKeys),
+			libovsdb.NewMutation("other_config", "insert", addKeys),
+		},
+		Where: []interface{}{condition},
+	}
+
+	return d.performOvsdbOps([]libovsdb.Operation{mutateOp})
+}
+
+// SetFlowSampleCollector points the sample actions of collector set id of
+// the bridge to IPFIX collector target, host:port, replacing the collector
+// of the set. The flow records are aggregated over activeTimeout seconds.
+func (d *OvsdbDriver) SetFlowSampleCollector(id int, target string, activeTimeout int) error {
+	var brUUID libovsdb.UUID
+	d.cacheLock.RLock()
+	for uuid, row := range d.cache[bridgeTable] {
+		if row.Fields["name"] == d.bridgeName {
+			brUUID = uuid
+			break
+		}
+	}
+	d.cacheLock.RUnlock()
+	if brUUID.GoUuid == "" {
+		return core.Errorf("bridge %s not found", d.bridgeName)
+	}
+
+	// the IPFIX row of a replaced set is garbage collected
+	delOp := libovsdb.Operation{
+		Op:    "delete",
+		Table: sampleSetTable,
+		Where: []interface{}{
+			libovsdb.NewCondition("id", "==", id),
+			libovsdb.NewCondition("bridge", "==", brUUID),
+		},
+	}
+
+	ipfixUUIDStr := "flowLogIpfix"
+	targets, err := libovsdb.NewOvsSet([]string{target})
+	if err != nil {
+		return err
+	}
+	ipfixOp := libovsdb.Operation{
+		Op:    "insert",
+		Table: ipfixTable,
+		Row: map[string]interface{}{
+			"targets":              targets,
+			"cache_active_timeout": activeTimeout,
+		},
+		UUIDName: ipfixUUIDStr,
+	}
+
+	setOp := libovsdb.Operation{
+		Op:    "insert",
+		Table: sampleSetTable,
+		Row: map[string]interface{}{
+			"id":     id,
+			"bridge": brUUID,
+			"ipfix":  libovsdb.UUID{GoUuid: ipfixUUIDStr},
+		},
+	}
+
+	return d.performOvsdbOps([]libovsdb.Operation{delOp, ipfixOp, setOp})
+}
+
 //UpdatePolicingRate will update the ingress policing rate in interface table.
 func (d *OvsdbDriver) UpdatePolicingRate(intfName string, burst int, bandwidth int64) error {
 	bw := int(bandwidth)
@@ -579,6 +1086,32 @@ func (d *OvsdbDriver) UpdatePolicingRate(intfName string, burst int, bandwidth i
 
 }
 
+// SetPortTag makes a port an access port of vlan tag, or a trunk port when
+// tag is zero, as CreatePort does
+func (d *OvsdbDriver) SetPortTag(portName string, tag int) error {
+	var err error
+	port := make(map[string]interface{})
+	if tag != 0 {
+		port["vlan_mode"] = "access"
+		port["tag"] = tag
+	} else {
+		port["vlan_mode"] = "trunk"
+		port["tag"], err = libovsdb.NewOvsSet([]int{})
+		if err != nil {
+			return err
+		}
+	}
+
+	condition := libovsdb.NewCondition("name", "==", portName)
+	updateOp := libovsdb.Operation{
+		Op:    "update",
+		Table: portTable,
+		Row:   port,
+		Where: []interface{}{condition},
+	}
+	return d.performOvsdbOps([]libovsdb.Operation{updateOp})
+}
+
 // DeletePort deletes a port from OVS
 func (d *OvsdbDriver) DeletePort(intfName string) error {
 	portUUIDStr := intfName
@@ -650,6 +1183,12 @@ func (d *OvsdbDriver) CreateVtep(intfName string, vtepRemoteIP string) error {
 	intfOptions["tos"] = "inherit"           // Copy DSCP from inner to outer IP header
 	intfOptions["dst_port"] = d.vxlanUDPPort // Set the UDP port for VXLAN
 
+	d.vtepLock.Lock()
+	defer d.vtepLock.Unlock()
+	if d.vtepPSK != "" {
+		intfOptions["psk"] = d.vtepPSK // Encrypted by ovs-monitor-ipsec
+	}
+
 	intf["options"], err = libovsdb.NewOvsMap(intfOptions)
 	if err != nil {
 		log.Errorf("error '%s' creating options from %v \n", err, intfOptions)
@@ -703,6 +1242,73 @@ func (d *OvsdbDriver) DeleteVtep(intfName string) error {
 	return d.DeletePort(intfName)
 }
 
+// SetVtepPSK sets the IPsec pre-shared key of all the vtep tunnels, and of
+// the ones created later. The tunnels are encrypted by ovs-monitor-ipsec
+// with the key, an empty key removes the encryption.
+func (d *OvsdbDriver) SetVtepPSK(psk string) error {
+	d.vtepLock.Lock()
+	defer d.vtepLock.Unlock()
+
+	ops, err := vtepPSKOps(d.vtepIntfNames(), psk)
+	if err != nil {
+		return err
+	}
+	if len(ops) > 0 {
+		if err := d.performOvsdbOps(ops); err != nil {
+			return err
+		}
+	}
+	d.vtepPSK = psk
+	return nil
+}
+
+// vtepIntfNames returns the names of the vtep interfaces in the cache
+func (d *OvsdbDriver) vtepIntfNames() []string {
+	d.cacheLock.RLock()
+	defer d.cacheLock.RUnlock()
+
+	names := []string{}
+	for _, row := range d.cache[interfaceTable] {
+		name, ok := row.Fields["name"].(string)
+		if !ok || row.Fields["type"] != "vxlan" {
+			continue
+		}
+		if options, ok := row.Fields["options"].(libovsdb.OvsMap); ok && options.GoMap["remote_ip"] != nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// vtepPSKOps returns the operations replacing the pre-shared key of vtep
+// interfaces, removing it when psk is empty
+func vtepPSKOps(intfNames []string, psk string) ([]libovsdb.Operation, error) {
+	delKeys, err := libovsdb.NewOvsSet([]string{"psk"})
+	if err != nil {
+		return nil, err
+	}
+	addKeys, err := libovsdb.NewOvsMap(map[string]string{"psk": psk})
+	if err != nil {
+		return nil, err
+	}
+
+	ops := []libovsdb.Operation{}
+	for _, name := range intfNames {
+		mutations := []interface{}{libovsdb.NewMutation("options", "delete", delKeys)}
+		if psk != "" {
+			mutations = append(mutations, libovsdb.NewMutation("options", "insert", addKeys))
+		}
+		ops = append(ops, libovsdb.Operation{
+			Op:        "mutate",
+			Table:     interfaceTable,
+			Mutations: mutations,
+			Where:     []interface{}{libovsdb.NewCondition("name", "==", name)},
+		})
+	}
+	return ops, nil
+}
+
 // AddController : Add controller configuration to OVS
 func (d *OvsdbDriver) AddController(ipAddr string, portNo uint16) error {
 	// Format target string
@@ -0,0 +1,87 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ovsd
+
+import (
+	"crypto/tls"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+
+	"github.com/contiv/libovsdb"
+)
+
+// connectOvsdbTLS connects to the ovsdb server at addr over TLS. libovsdb
+// only dials plain connections, so the TLS connection is relayed to the
+// client through a unix socket in a directory private to this process.
+func connectOvsdbTLS(addr string, tlsCfg *tls.Config) (*libovsdb.OvsdbClient, error) {
+	remote, err := tls.Dial("tcp", addr, tlsCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := ioutil.TempDir("", "ovsdb-tls-")
+	if err != nil {
+		remote.Close()
+		return nil, err
+	}
+	// the socket is only needed until the client is connected
+	defer os.RemoveAll(dir)
+
+	sockPath := filepath.Join(dir, "db.sock")
+	listener, err := net.Listen("unix", sockPath)
+	if err != nil {
+		remote.Close()
+		return nil, err
+	}
+
+	accepted := make(chan error, 1)
+	go func() {
+		local, err := listener.Accept()
+		if err != nil {
+			remote.Close()
+		} else {
+			go relayOvsdbConn(local, remote)
+		}
+		accepted <- err
+	}()
+
+	ovs, err := libovsdb.ConnectUnix(sockPath)
+	if err == nil {
+		// the connection of the client is accepted before the listener goes
+		err = <-accepted
+		listener.Close()
+		return ovs, err
+	}
+	listener.Close()
+	<-accepted
+	return nil, err
+}
+
+// relayOvsdbConn copies the traffic between the local connection of the
+// ovsdb client and the TLS connection to the server until either side closes
+func relayOvsdbConn(local, remote net.Conn) {
+	go func() {
+		io.Copy(remote, local)
+		remote.Close()
+		local.Close()
+	}()
+	io.Copy(local, remote)
+	local.Close()
+	remote.Close()
+}
@@ -0,0 +1,108 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ovsd
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+// testTLSCert returns a self signed certificate for 127.0.0.1
+func testTLSCert(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating key. Err: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ovsdb"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("error creating certificate. Err: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("error parsing certificate. Err: %v", err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
+
+func TestConnectOvsdbTLS(t *testing.T) {
+	cert, pool := testTLSCert(t)
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatalf("error listening. Err: %v", err)
+	}
+	defer listener.Close()
+
+	// answer list_dbs with no databases
+	methods := make(chan string, 1)
+	serve := func(conn net.Conn) {
+		defer conn.Close()
+		var req struct {
+			Method string      `json:"method"`
+			ID     interface{} `json:"id"`
+		}
+		if err := json.NewDecoder(conn).Decode(&req); err != nil {
+			return
+		}
+		methods <- req.Method
+		json.NewEncoder(conn).Encode(map[string]interface{}{"id": req.ID, "result": []string{}, "error": nil})
+		conn.Read(make([]byte, 1))
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serve(conn)
+		}
+	}()
+
+	ovs, err := connectOvsdbTLS(listener.Addr().String(), &tls.Config{RootCAs: pool})
+	if err != nil {
+		t.Fatalf("error connecting over TLS. Err: %v", err)
+	}
+	defer ovs.Disconnect()
+	if method := <-methods; method != "list_dbs" {
+		t.Fatalf("unexpected request %q", method)
+	}
+
+	// the server certificate is checked
+	if _, err := connectOvsdbTLS(listener.Addr().String(), &tls.Config{}); err == nil {
+		t.Fatalf("connected to a server with an unknown certificate")
+	}
+}
@@ -16,10 +16,17 @@ limitations under the License.
 package ovsd
 
 import (
+	"crypto/sha1"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"net"
 	"os"
+	osexec "os/exec"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -32,6 +39,7 @@ import (
 	"github.com/contiv/netplugin/utils/netutils"
 	"github.com/contiv/ofnet"
 	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
 )
 
 type oper int
@@ -39,6 +47,16 @@ type oper int
 const (
 	maxIntfRetry = 100
 	hostPortName = "contivh0"
+
+	sysClassNet = "/sys/class/net"
+
+	// datapath sizing defaults, same as the ovs ones
+	defaultFlowLimit = 200000
+	defaultMaxIdle   = 10000
+	minMaxIdle       = 500
+	maxCtZone        = 0xffff
+
+	defaultVethPoolRefill = 30 // s
 )
 
 //EpInfo contains the ovsport and id of the group
@@ -46,6 +64,7 @@ type EpInfo struct {
 	Ovsportname string `json:"Ovsportname"`
 	EpgKey      string `json:"EpgKey"`
 	BridgeType  string `json:"BridgeType"`
+	Dscp        int    `json:"Dscp,omitempty"` // endpoint DSCP, overrides the epg
 }
 
 // OvsDriverOperState carries operational state of the OvsDriver.
@@ -86,10 +105,29 @@ func (s *OvsDriverOperState) Clear() error {
 type OvsDriver struct {
 	oper       OvsDriverOperState    // Oper state of the driver
 	localIP    string                // Local IP address
+	fwdMode    string                // "bridge" or "routing"
+	arpMode    string                // "flood" or "proxy"
 	switchDb   map[string]*OvsSwitch // OVS switch instances
 	lock       sync.Mutex            // lock for modifying shared state
 	HostProxy  *NodeSvcProxy
 	nameServer *nameserver.NetpluginNameServer
+
+	// encryptedNets are the encrypted vxlan networks by id. The tunnels
+	// between the vteps carry all the vxlan networks, they are encrypted
+	// while any network asks for it.
+	encryptedNets map[string]bool
+
+	// vethPool holds veth pairs created ahead of the attaches, nil when
+	// disabled
+	vethPool *vethPool
+
+	// flowLog tracks the policy rules whose packets are logged
+	flowLog *flowLogger
+
+	// ovsdbCfg holds the ovsdb server of the switches, gateways are the
+	// bridges of the vxlan gateways by gateway id
+	ovsdbCfg core.OvsDriverConfig
+	gateways map[string]*OvsdbDriver
 }
 
 func (d *OvsDriver) getIntfName() (string, error) {
@@ -164,21 +202,34 @@ func (d *OvsDriver) Init(info *core.InstanceInfo) error {
 		}
 	}
 
+	err = validateOvsConfig(&info.OvsConfig)
+	if err != nil {
+		return err
+	}
+
 	log.Infof("Initializing ovsdriver")
 
 	// Init switch DB
 	d.switchDb = make(map[string]*OvsSwitch)
+	d.ovsdbCfg = info.OvsConfig
+	d.gateways = make(map[string]*OvsdbDriver)
+	d.fwdMode = info.FwdMode
 
 	// Create Vxlan switch
 	d.switchDb["vxlan"], err = NewOvsSwitch(vxlanBridgeName, "vxlan", info.VtepIP,
-		info.FwdMode, nil, info.HostPvtNW, info.VxlanUDPPort)
-	if err != nil {
+		info.FwdMode, nil, info.HostPvtNW, info.VxlanUDPPort, &info.OvsConfig)
+	if _, ok := err.(*OvsdbConnError); ok {
+		return err
+	} else if err != nil {
 		log.Fatalf("Error creating vlan switch. Err: %v", err)
 	}
 	// Create Vlan switch
 	d.switchDb["vlan"], err = NewOvsSwitch(vlanBridgeName, "vlan", info.VtepIP,
-		info.FwdMode, info.UplinkIntf, info.HostPvtNW, info.VxlanUDPPort)
-	if err != nil {
+		info.FwdMode, info.UplinkIntf, info.HostPvtNW, info.VxlanUDPPort, &info.OvsConfig)
+	if _, ok := err.(*OvsdbConnError); ok {
+		d.switchDb["vxlan"].ovsdbDriver.Close()
+		return err
+	} else if err != nil {
 		log.Fatalf("Error creating vlan switch. Err: %v", err)
 	}
 
@@ -197,19 +248,39 @@ func (d *OvsDriver) Init(info *core.InstanceInfo) error {
 		}
 	}
 
+	err = d.initFlowLog(&info.OvsConfig)
+	if err != nil {
+		return err
+	}
+
+	if info.OvsConfig.VethPoolSize > 0 && useVethPair {
+		err = reclaimVethPairs(d.isOvsPort)
+		if err != nil {
+			log.Errorf("Error reclaiming unused veth pairs. Err: %v", err)
+		}
+		d.vethPool = newVethPool(&info.OvsConfig, d.getIntfName)
+		d.switchDb["vxlan"].vethPool = d.vethPool
+		d.switchDb["vlan"].vethPool = d.vethPool
+		d.vethPool.start()
+	}
+
 	if maxPortNum > 0xfffe {
 		log.Fatalf("Host bridge logic assumes maxPortNum <= 0xfffe")
 	}
 
-	// Add host port.
-	_, err = d.switchDb["vxlan"].AddHostPort(hostPortName, maxPortNum, info.HostPvtNW, true)
-	if err != nil {
-		log.Errorf("Could not add host port %s to OVS. Err: %v", hostPortName, err)
-	}
+	// Add host port, the host of a remote switch is not this one
+	if remoteOvsdb(info.OvsConfig.OvsdbEndpoint) {
+		log.Infof("Skipping host port %s, the ovsdb is remote", hostPortName)
+	} else {
+		_, err = d.switchDb["vxlan"].AddHostPort(hostPortName, maxPortNum, info.HostPvtNW, true)
+		if err != nil {
+			log.Errorf("Could not add host port %s to OVS. Err: %v", hostPortName, err)
+		}
 
-	// Add a masquerade rule to ip tables.
-	netmask, _ := netutils.PortToHostIPMAC(0, info.HostPvtNW)
-	netutils.SetIPMasquerade(hostPortName, netmask)
+		// Add a masquerade rule to ip tables.
+		netmask, _ := netutils.PortToHostIPMAC(0, info.HostPvtNW)
+		netutils.SetIPMasquerade(hostPortName, netmask)
+	}
 
 	// Initialize the node proxy
 	d.HostProxy, err = NewNodeProxy()
@@ -260,17 +331,57 @@ func (d *OvsDriver) CreateHostAccPort(portName, globalIP string, net int) (strin
 func (d *OvsDriver) Deinit() {
 	log.Infof("Cleaning up ovsdriver")
 
+	if d.vethPool != nil {
+		d.vethPool.stop()
+	}
+	if d.flowLog != nil {
+		close(d.flowLog.stopCh)
+	}
+
+	for _, gw := range d.gateways {
+		gw.Delete()
+	}
+
 	// cleanup both vlan and vxlan OVS instances
 	if d.switchDb["vlan"] != nil {
 		d.switchDb["vlan"].RemoveUplinks()
 		d.switchDb["vlan"].Delete()
 	}
+	// deleting the switches disconnects from their ovsdb
 	if d.switchDb["vxlan"] != nil {
-		d.switchDb["vxlan"].DelHostPort(hostPortName, true)
+		if !d.switchDb["vxlan"].ovsdbDriver.remote {
+			d.switchDb["vxlan"].DelHostPort(hostPortName, true)
+		}
 		d.switchDb["vxlan"].Delete()
 	}
 }
 
+// CheckHealth returns an error when either switch of the driver lost its
+// connection to the ofnet agent
+func (d *OvsDriver) CheckHealth() error {
+	for _, netType := range []string{"vlan", "vxlan"} {
+		sw := d.switchDb[netType]
+		if sw == nil {
+			return core.Errorf("%s switch not initialized", netType)
+		}
+		if sw.ofnetAgent != nil && !sw.ofnetAgent.IsSwitchConnected() {
+			return core.Errorf("%s switch %s not connected", netType, sw.bridgeName)
+		}
+	}
+	return nil
+}
+
+// checkAddrFamilies fails the networks with an IPv6 subnet only. ofnet keys
+// the endpoints by IPv4 address and vrf, all the endpoints of such a network
+// would share a key.
+func checkAddrFamilies(cfgNw *mastercfg.CfgNetworkState) error {
+	if cfgNw.SubnetIP == "" && cfgNw.IPv6Subnet != "" {
+		return core.Errorf("network %s has no IPv4 subnet, IPv6 only networks are not supported by the ovs driver",
+			cfgNw.ID)
+	}
+	return nil
+}
+
 // CreateNetwork creates a network by named identifier
 func (d *OvsDriver) CreateNetwork(id string) error {
 	cfgNw := mastercfg.CfgNetworkState{}
@@ -282,6 +393,10 @@ func (d *OvsDriver) CreateNetwork(id string) error {
 	}
 	log.Infof("create net %+v \n", cfgNw)
 
+	if err := checkAddrFamilies(&cfgNw); err != nil {
+		return err
+	}
+
 	// Find the switch based on network type
 	var sw *OvsSwitch
 	if cfgNw.PktTagType == "vxlan" {
@@ -290,7 +405,180 @@ func (d *OvsDriver) CreateNetwork(id string) error {
 		sw = d.switchDb["vlan"]
 	}
 
-	return sw.CreateNetwork(uint16(cfgNw.PktTag), uint32(cfgNw.ExtPktTag), cfgNw.Gateway, cfgNw.Tenant)
+	if cfgNw.UnderlayMTU != 0 {
+		err = sw.CheckUnderlayMtu(cfgNw.UnderlayMTU)
+		if err != nil {
+			log.Errorf("Error creating network %s. Err: %v", id, err)
+			return err
+		}
+	}
+
+	if cfgNw.Encrypt && cfgNw.PktTagType == "vxlan" {
+		err = d.setNetworkEncryption(id, true)
+		if err != nil {
+			log.Errorf("Error encrypting network %s. Err: %v", id, err)
+			return err
+		}
+	}
+
+	if cfgNw.Vrf != "" {
+		err = ensureVRF(cfgNw.Vrf, cfgNw.VrfTable)
+		if err != nil {
+			log.Errorf("Error setting up vrf %s of network %s. Err: %v", cfgNw.Vrf, id, err)
+			return err
+		}
+	}
+
+	err = sw.CreateNetwork(uint16(cfgNw.PktTag), uint32(cfgNw.ExtPktTag), cfgNw.Gateway, cfgNw.Tenant)
+	if err != nil {
+		return err
+	}
+
+	if cfgNw.GatewayArpProxy {
+		if cfgNw.PktTagType != "vxlan" || d.fwdMode != "bridge" {
+			return core.Errorf("gateway arp proxy of network %s needs a vxlan network in bridge mode", id)
+		}
+		err = sw.AddGatewayArpProxy(&cfgNw)
+		if err != nil {
+			log.Errorf("Error adding the gateway arp proxy of network %s. Err: %v", id, err)
+			return err
+		}
+	}
+
+	if cfgNw.BUMMode != "" && cfgNw.BUMMode != mastercfg.BUMModeFlood {
+		err = sw.SetBUMMode(&cfgNw)
+		if err != nil {
+			log.Errorf("Error setting the bum mode of network %s. Err: %v", id, err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// setNetworkEncryption records whether a vxlan network is encrypted and
+// keys the vtep tunnels accordingly
+func (d *OvsDriver) setNetworkEncryption(id string, encrypt bool) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if encrypt == d.encryptedNets[id] {
+		return nil
+	}
+	if encrypt {
+		if d.encryptedNets == nil {
+			d.encryptedNets = make(map[string]bool)
+		}
+		d.encryptedNets[id] = true
+	} else {
+		delete(d.encryptedNets, id)
+	}
+	return d.applyOverlayKey()
+}
+
+// UpdateOverlayKey rekeys the vtep tunnels with the current overlay key
+func (d *OvsDriver) UpdateOverlayKey() error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.applyOverlayKey()
+}
+
+// applyOverlayKey sets the overlay key on the vtep tunnels while networks
+// are encrypted and removes it once none is
+func (d *OvsDriver) applyOverlayKey() error {
+	psk := ""
+	if len(d.encryptedNets) > 0 {
+		keyCfg := &mastercfg.OverlayKeyState{}
+		keyCfg.StateDriver = d.oper.StateDriver
+		if err := keyCfg.Read(mastercfg.OverlayKeyID); err != nil {
+			return core.Errorf("error reading the overlay key: %v", err)
+		}
+		psk = keyCfg.Key
+		log.Infof("Keying the vtep tunnels with overlay key generation %d", keyCfg.Generation)
+	}
+	return d.switchDb["vxlan"].ovsdbDriver.SetVtepPSK(psk)
+}
+
+// interconnectPortNames returns the names of the patch ports of an
+// interconnect, short enough for interface names
+func interconnectPortNames(id string) (string, string) {
+	sum := sha1.Sum([]byte(id))
+	name := "cpatch" + hex.EncodeToString(sum[:4])
+	return name + "a", name + "b"
+}
+
+// ConnectNetworks stitches networks netA and netB together with a pair of
+// patch ports between their bridges. Each port is an access port of the
+// vlan of its network, so traffic crossing the pair has its vlan
+// translated. The vlan bridge switches the patch ports like its other
+// ports, on the vxlan bridge they are joined to the ofnet datapath with
+// flows of their own. Ports already present are kept, their flows are
+// programmed again.
+func (d *OvsDriver) ConnectNetworks(id, netA, netB string) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	nameA, nameB := interconnectPortNames(id)
+	ends := []struct {
+		netID, name, peer string
+		cfgNw             mastercfg.CfgNetworkState
+	}{{netID: netA, name: nameA, peer: nameB}, {netID: netB, name: nameB, peer: nameA}}
+	for idx := range ends {
+		end := &ends[idx]
+		end.cfgNw.StateDriver = d.oper.StateDriver
+		if err := end.cfgNw.Read(end.netID); err != nil {
+			return err
+		}
+		if end.cfgNw.PktTagType == "vxlan" {
+			if err := d.checkPatchDatapath(); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, end := range ends {
+		sw := d.switchDb["vlan"]
+		if end.cfgNw.PktTagType == "vxlan" {
+			sw = d.switchDb["vxlan"]
+		}
+		if !sw.ovsdbDriver.IsPortNamePresent(end.name) {
+			if err := sw.ovsdbDriver.CreatePatchPort(end.name, end.peer, end.cfgNw.PktTag); err != nil {
+				log.Errorf("Error creating patch port %s of network %s. Err: %v", end.name, end.netID, err)
+				return err
+			}
+		}
+		if end.cfgNw.PktTagType == "vxlan" {
+			if err := sw.AddPatchPort(end.name, end.cfgNw.PktTag); err != nil {
+				log.Errorf("Error programming patch port %s of network %s. Err: %v", end.name, end.netID, err)
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// DisconnectNetworks removes the patch ports of an interconnect
+func (d *OvsDriver) DisconnectNetworks(id string) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	nameA, nameB := interconnectPortNames(id)
+	for _, sw := range []*OvsSwitch{d.switchDb["vlan"], d.switchDb["vxlan"]} {
+		for _, name := range []string{nameA, nameB} {
+			if err := sw.RemovePatchPort(name); err != nil {
+				log.Errorf("Error removing the flows of patch port %s. Err: %v", name, err)
+				return err
+			}
+			if !sw.ovsdbDriver.IsPortNamePresent(name) {
+				continue
+			}
+			if err := sw.ovsdbDriver.DeletePort(name); err != nil {
+				log.Errorf("Error deleting patch port %s. Err: %v", name, err)
+				return err
+			}
+		}
+	}
+	return nil
 }
 
 // DeleteNetwork deletes a network by named identifier
@@ -322,6 +610,21 @@ func (d *OvsDriver) DeleteNetwork(id, subnet, nwType, encap string, pktTag, extP
 		}
 	}
 
+	if encap == "vxlan" {
+		if err := d.setNetworkEncryption(id, false); err != nil {
+			log.Errorf("Error removing the encryption of network %s. Err: %v", id, err)
+		}
+		if err := sw.ResetBUMMode(pktTag); err != nil {
+			log.Warnf("Error deleting the bum flow of network %s. Err: %v", id, err)
+		}
+	}
+
+	if gateway != "" {
+		if err := sw.DeleteGatewayArpProxy(pktTag); err != nil {
+			log.Warnf("Error deleting the gateway arp proxy of network %s. Err: %v", id, err)
+		}
+	}
+
 	return sw.DeleteNetwork(uint16(pktTag), uint32(extPktTag), gateway, tenant)
 }
 
@@ -376,6 +679,11 @@ func (d *OvsDriver) CreateEndpoint(id string) error {
 		}
 	}
 
+	// endpoint marking takes precedence over the epg
+	if cfgEp.DSCP != 0 {
+		dscp = cfgEp.DSCP
+	}
+
 	// Find the switch based on network type
 	var sw *OvsSwitch
 	if pktTagType == "vxlan" {
@@ -384,8 +692,10 @@ func (d *OvsDriver) CreateEndpoint(id string) error {
 		sw = d.switchDb["vlan"]
 	}
 
-	// Skip Veth pair creation for infra nw endpoints
-	skipVethPair := (cfgNw.NwType == "infra")
+	// Skip Veth pair creation for infra nw endpoints, the port of a bonded
+	// endpoint is its bond and the one of a tap endpoint its tap. A remote
+	// switch creates internal ports on its own host.
+	skipVethPair := (cfgNw.NwType == "infra" || cfgEp.Bond != nil || cfgEp.Tap || sw.ovsdbDriver.remote)
 
 	operEp := &drivers.OperEndpointState{}
 	operEp.StateDriver = d.oper.StateDriver
@@ -411,15 +721,32 @@ func (d *OvsDriver) CreateEndpoint(id string) error {
 			cfgEp, operEp)
 		d.DeleteEndpoint(operEp.ID)
 	}
+	// a port of the endpoint without oper state is left by a previous run
+	adoptPort := err != nil
 
 	if cfgNw.NwType == "infra" {
 		// For infra nw, port name is network name
 		intfName = cfgNw.NetworkName
 	} else {
-		// Get the interface name to use
-		intfName, err = d.getIntfName()
-		if err != nil {
-			return err
+		if adoptPort {
+			intfName = d.adoptEndpointPort(sw, id, skipVethPair)
+		}
+		if intfName == "" {
+			// Get the interface name to use, the veth pair of a pooled one
+			// exists already
+			pooled := false
+			if !skipVethPair {
+				intfName, pooled = d.vethPool.get()
+			}
+			if !pooled {
+				intfName, err = d.getIntfName()
+				if err != nil {
+					return err
+				}
+			}
+			if cfgEp.Tap {
+				intfName = tapIntfName(intfName)
+			}
 		}
 	}
 
@@ -427,18 +754,39 @@ func (d *OvsDriver) CreateEndpoint(id string) error {
 	ovsPortName := getOvsPortName(intfName, skipVethPair)
 
 	// Ask the switch to create the port
-	err = sw.CreatePort(intfName, cfgEp, pktTag, cfgNw.PktTag, cfgEpGroup.Burst, dscp, skipVethPair, epgBandwidth)
+	// endpoints inherit the mtu of networks whose underlay mtu was checked
+	err = sw.CreatePort(intfName, cfgEp, pktTag, cfgNw.PktTag, cfgEpGroup.Burst, dscp, cfgNw.MTU,
+		cfgNw.UnderlayMTU != 0, skipVethPair, epgBandwidth)
 	if err != nil {
 		log.Errorf("Error creating port %s. Err: %v", intfName, err)
 		return err
 	}
 
+	// the port of infra networks and host endpoints stays in the host netns
+	if cfgEp.Vrf != "" && (cfgNw.NwType == "infra" || cfgEp.HostEndpoint) {
+		err = enslaveToVRF(intfName, cfgEp.Vrf)
+		if err != nil {
+			log.Errorf("Error placing port %s in vrf %s. Err: %v", intfName, cfgEp.Vrf, err)
+			return err
+		}
+	}
+
+	rateLimiter, meterID := "", uint32(0)
+	if cfgEp.RateLimit != nil {
+		rateLimiter, meterID, err = sw.AddEndpointRateLimit(ovsPortName, cfgEp.RateLimit)
+		if err != nil {
+			log.Errorf("Error rate limiting port %s. Err: %v", ovsPortName, err)
+			return err
+		}
+	}
+
 	// save local endpoint info
 	d.oper.localEpInfoMutex.Lock()
 	d.oper.LocalEpInfo[id] = &EpInfo{
 		Ovsportname: ovsPortName,
 		EpgKey:      epgKey,
 		BridgeType:  pktTagType,
+		Dscp:        cfgEp.DSCP,
 	}
 	d.oper.localEpInfoMutex.Unlock()
 	err = d.oper.Write()
@@ -447,16 +795,22 @@ func (d *OvsDriver) CreateEndpoint(id string) error {
 	}
 	// Save the oper state
 	operEp = &drivers.OperEndpointState{
-		NetID:       cfgEp.NetID,
-		EndpointID:  cfgEp.EndpointID,
-		ServiceName: cfgEp.ServiceName,
-		IPAddress:   cfgEp.IPAddress,
-		IPv6Address: cfgEp.IPv6Address,
-		MacAddress:  cfgEp.MacAddress,
-		IntfName:    cfgEp.IntfName,
-		PortName:    intfName,
-		HomingHost:  cfgEp.HomingHost,
-		VtepIP:      cfgEp.VtepIP}
+		NetID:        cfgEp.NetID,
+		EndpointID:   cfgEp.EndpointID,
+		ServiceName:  cfgEp.ServiceName,
+		IPAddress:    cfgEp.IPAddress,
+		IPv6Address:  cfgEp.IPv6Address,
+		MacAddress:   cfgEp.MacAddress,
+		IntfName:     cfgEp.IntfName,
+		PortName:     intfName,
+		HomingHost:   cfgEp.HomingHost,
+		VtepIP:       cfgEp.VtepIP,
+		HostIntf:     cfgEp.HostIntfName,
+		SecondaryIPs: cfgEp.SecondaryIPs,
+		Bonded:       cfgEp.Bond != nil,
+		Tap:          cfgEp.Tap,
+		RateLimiter:  rateLimiter,
+		MeterID:      meterID}
 	operEp.StateDriver = d.oper.StateDriver
 	operEp.ID = id
 	err = operEp.Write()
@@ -505,8 +859,13 @@ func (d *OvsDriver) UpdateEndpointGroup(id string) error {
 					sw = d.switchDb["vlan"]
 				}
 
+				dscp := cfgEpGroup.DSCP
+				if epInfo.Dscp != 0 {
+					dscp = epInfo.Dscp
+				}
+
 				// update the endpoint in ovs switch
-				err = sw.UpdateEndpoint(epInfo.Ovsportname, cfgEpGroup.Burst, cfgEpGroup.DSCP, epgBandwidth)
+				err = sw.UpdateEndpoint(epInfo.Ovsportname, cfgEpGroup.Burst, dscp, epgBandwidth)
 				if err != nil {
 					log.Errorf("Error adding bandwidth %v , err: %+v", epgBandwidth, err)
 					return err
@@ -545,7 +904,13 @@ func (d *OvsDriver) DeleteEndpoint(id string) error {
 		sw = d.switchDb["vlan"]
 	}
 
-	skipVethPair := (cfgNw.NwType == "infra")
+	if epOper.RateLimiter == mastercfg.RateLimiterMeter {
+		if err := sw.DeleteEndpointRateLimit(epOper.MeterID); err != nil {
+			log.Errorf("Error deleting meter %d of endpoint %s. Err: %v", epOper.MeterID, id, err)
+		}
+	}
+
+	skipVethPair := (cfgNw.NwType == "infra" || epOper.Bonded || epOper.Tap || sw.ovsdbDriver.remote)
 	err = sw.DeletePort(&epOper, skipVethPair)
 	if err != nil {
 		log.Errorf("Error deleting endpoint: %+v. Err: %v", epOper, err)
@@ -562,6 +927,241 @@ func (d *OvsDriver) DeleteEndpoint(id string) error {
 	return nil
 }
 
+// MoveEndpointIntf moves the container side of the veth pair of endpoint id
+// to the netns at nsPath, names it name there, sets its MAC and addresses,
+// which the move drops, and sets it up. The interface is moved back to the
+// host netns under its port name when a step fails.
+func (d *OvsDriver) MoveEndpointIntf(id, nsPath, name string) error {
+	if remoteOvsdb(d.ovsdbCfg.OvsdbEndpoint) {
+		return core.Errorf("the port of endpoint %s is on the host of the remote ovsdb", id)
+	}
+	operEp := &drivers.OperEndpointState{}
+	operEp.StateDriver = d.oper.StateDriver
+	if err := operEp.Read(id); err != nil {
+		return err
+	}
+	if operEp.Bonded || operEp.Tap {
+		return core.Errorf("port %s of endpoint %s stays in the host netns", operEp.PortName, id)
+	}
+	cfgEp := &mastercfg.CfgEndpointState{}
+	cfgEp.StateDriver = d.oper.StateDriver
+	if err := cfgEp.Read(id); err != nil {
+		return err
+	}
+	cfgNw := &mastercfg.CfgNetworkState{}
+	cfgNw.StateDriver = d.oper.StateDriver
+	if err := cfgNw.Read(cfgEp.NetID); err != nil {
+		return err
+	}
+	nsenterPath, err := osexec.LookPath("nsenter")
+	if err != nil {
+		return err
+	}
+
+	link, err := netlink.LinkByName(operEp.PortName)
+	if err != nil {
+		return core.Errorf("interface %s of endpoint %s not found. Err: %v", operEp.PortName, id, err)
+	}
+	ns, err := netns.GetFromPath(nsPath)
+	if err != nil {
+		return core.Errorf("error opening netns %s: %v", nsPath, err)
+	}
+	defer ns.Close()
+	err = netlink.LinkSetNsFd(link, int(ns))
+	core.Audit("link set netns", []string{operEp.PortName, nsPath}, err)
+	if err != nil {
+		return err
+	}
+
+	runIP := func(cmd []string) error {
+		args := append([]string{"--net=" + nsPath, "--", "ip"}, cmd...)
+		out, err := osexec.Command(nsenterPath, args...).CombinedOutput()
+		core.Audit(nsenterPath, args, err)
+		if err != nil {
+			return core.Errorf("ip %s failed. Err: %v - %s", strings.Join(cmd, " "), err, out)
+		}
+		return nil
+	}
+	cur := operEp.PortName
+	for _, cmd := range moveIntfArgs(operEp.PortName, name, cfgEp.MacAddress, cfgEp.AddrCIDRs(cfgNw)) {
+		if err = runIP(cmd); err != nil {
+			break
+		}
+		if cmd[len(cmd)-2] == "name" {
+			cur = name
+		}
+	}
+	if err == nil {
+		return nil
+	}
+
+	log.Errorf("Error setting up interface %s of endpoint %s in netns %s, moving it back. Err: %v", name, id, nsPath, err)
+	for _, cmd := range moveBackArgs(cur, operEp.PortName, os.Getpid()) {
+		if rerr := runIP(cmd); rerr != nil {
+			log.Errorf("Error moving back interface %s of endpoint %s. Err: %v", cur, id, rerr)
+			break
+		}
+	}
+	return err
+}
+
+// moveIntfArgs returns the ip commands setting up interface port, moved to
+// a netns, as name there with MAC mac and addresses cidrs
+func moveIntfArgs(port, name, mac string, cidrs []string) [][]string {
+	args := [][]string{{"link", "set", "dev", port, "name", name}}
+	if mac != "" {
+		args = append(args, []string{"link", "set", "dev", name, "address", mac})
+	}
+	for _, cidr := range cidrs {
+		args = append(args, []string{"addr", "replace", cidr, "dev", name})
+	}
+	return append(args, []string{"link", "set", "dev", name, "up"})
+}
+
+// moveBackArgs returns the ip commands moving interface cur of a netns back
+// to the netns of process pid under its port name
+func moveBackArgs(cur, port string, pid int) [][]string {
+	args := [][]string{}
+	if cur != port {
+		args = append(args, []string{"link", "set", "dev", cur, "name", port})
+	}
+	return append(args, []string{"link", "set", "dev", port, "netns", strconv.Itoa(pid)})
+}
+
+// validateOvsConfig checks the datapath sizing and fills in the defaults
+func validateOvsConfig(cfg *core.OvsDriverConfig) error {
+	if cfg.FlowLimit < 0 {
+		return core.Errorf("invalid ovs flow limit %d", cfg.FlowLimit)
+	}
+	if cfg.FlowLimit == 0 {
+		cfg.FlowLimit = defaultFlowLimit
+	}
+
+	if cfg.MaxIdle == 0 {
+		cfg.MaxIdle = defaultMaxIdle
+	}
+	if cfg.MaxIdle < minMaxIdle {
+		return core.Errorf("invalid ovs max idle %dms, must be at least %dms", cfg.MaxIdle, minMaxIdle)
+	}
+
+	if cfg.VethPoolSize < 0 {
+		return core.Errorf("invalid veth pool size %d", cfg.VethPoolSize)
+	}
+	if cfg.VethPoolLowWater < 0 || cfg.VethPoolLowWater > cfg.VethPoolSize {
+		return core.Errorf("invalid veth pool low water %d, must be at most the pool size %d",
+			cfg.VethPoolLowWater, cfg.VethPoolSize)
+	}
+	if cfg.VethPoolSize != 0 && cfg.VethPoolLowWater == 0 {
+		cfg.VethPoolLowWater = (cfg.VethPoolSize + 1) / 2
+	}
+	if cfg.VethPoolRefill < 0 {
+		return core.Errorf("invalid veth pool refill interval %ds", cfg.VethPoolRefill)
+	}
+	if cfg.VethPoolRefill == 0 {
+		cfg.VethPoolRefill = defaultVethPoolRefill
+	}
+
+	if cfg.FlowLogCollector != "" {
+		host, port, err := net.SplitHostPort(cfg.FlowLogCollector)
+		if num, perr := strconv.Atoi(port); err != nil || perr != nil || host == "" || num <= 0 || num > 65535 {
+			return core.Errorf("invalid flow log collector %q, must be host:port", cfg.FlowLogCollector)
+		}
+	}
+	if cfg.FlowLogSampling < 0 {
+		return core.Errorf("invalid flow log sampling %d", cfg.FlowLogSampling)
+	}
+	if cfg.FlowLogSampling == 0 {
+		cfg.FlowLogSampling = 1
+	}
+
+	if cfg.AgeingTime < 0 {
+		return core.Errorf("invalid bridge ageing time %d", cfg.AgeingTime)
+	}
+
+	if cfg.CtZoneLimit < 0 {
+		return core.Errorf("invalid conntrack zone limit %d", cfg.CtZoneLimit)
+	}
+	for zone, limit := range cfg.CtZoneLimits {
+		if zone < 0 || zone > maxCtZone {
+			return core.Errorf("invalid conntrack zone %d", zone)
+		}
+		if limit < 0 {
+			return core.Errorf("invalid conntrack limit %d for zone %d", limit, zone)
+		}
+	}
+
+	network, _, err := parseOvsdbEndpoint(cfg.OvsdbEndpoint)
+	if err != nil {
+		return err
+	}
+	if network == "ssl" && (cfg.OvsdbKey == "" || cfg.OvsdbCert == "" || cfg.OvsdbCACert == "") {
+		return core.Errorf("ssl ovsdb endpoints need a private key, a certificate and a CA certificate")
+	}
+	if network != "ssl" && (cfg.OvsdbKey != "" || cfg.OvsdbCert != "" || cfg.OvsdbCACert != "") {
+		return core.Errorf("ovsdb certificates are only used by ssl endpoints")
+	}
+	// conntrack limits are set through ovs-appctl and the veth pairs are
+	// created on this host
+	if remoteOvsdb(cfg.OvsdbEndpoint) && ctLimitArgs(cfg) != nil {
+		return core.Errorf("conntrack limits can not be set on a switch with a remote ovsdb")
+	}
+	if remoteOvsdb(cfg.OvsdbEndpoint) && cfg.VethPoolSize > 0 {
+		return core.Errorf("a veth pool can not be used with a remote ovsdb")
+	}
+
+	return nil
+}
+
+// ctLimitArgs returns the ovs-appctl arguments setting the conntrack limits,
+// nil when no limit is configured
+func ctLimitArgs(cfg *core.OvsDriverConfig) []string {
+	if cfg.CtZoneLimit == 0 && len(cfg.CtZoneLimits) == 0 {
+		return nil
+	}
+
+	zones := make([]int, 0, len(cfg.CtZoneLimits))
+	for zone := range cfg.CtZoneLimits {
+		zones = append(zones, zone)
+	}
+	sort.Ints(zones)
+
+	args := []string{"dpctl/ct-set-limits", fmt.Sprintf("default=%d", cfg.CtZoneLimit)}
+	for _, zone := range zones {
+		args = append(args, fmt.Sprintf("zone=%d,limit=%d", zone, cfg.CtZoneLimits[zone]))
+	}
+	return args
+}
+
+// setConntrackLimits programs the conntrack zone limits of the datapath
+func setConntrackLimits(cfg *core.OvsDriverConfig) error {
+	args := ctLimitArgs(cfg)
+	if args == nil {
+		return nil
+	}
+
+	appctlPath, err := osexec.LookPath("ovs-appctl")
+	if err != nil {
+		return err
+	}
+	out, err := osexec.Command(appctlPath, args...).CombinedOutput()
+	core.Audit(appctlPath, args, err)
+	if err != nil {
+		return core.Errorf("ovs-appctl %v failed. Err: %v - %s", args, err, out)
+	}
+	return nil
+}
+
+// SupportsDSCPMarking returns true, DSCP marking flows are programmed by ofnet
+func (d *OvsDriver) SupportsDSCPMarking() bool {
+	return true
+}
+
+// SupportsCoSMarking returns false, the ofnet pipeline can not set the 802.1p
+// priority of the vlan tag
+func (d *OvsDriver) SupportsCoSMarking() bool {
+	return false
+}
+
 // CreateRemoteEndpoint creates a remote endpoint by named identifier
 func (d *OvsDriver) CreateRemoteEndpoint(id string) error {
 
@@ -773,6 +1373,200 @@ func (d *OvsDriver) GetEndpointStats() ([]byte, error) {
 	return jsonStats, nil
 }
 
+// GetEndpointIntfStats returns the counters of the ovs port of a local
+// endpoint
+func (d *OvsDriver) GetEndpointIntfStats(id string) (*drivers.EndpointStats, error) {
+	d.oper.localEpInfoMutex.Lock()
+	epInfo, found := d.oper.LocalEpInfo[id]
+	d.oper.localEpInfoMutex.Unlock()
+	if !found {
+		return nil, core.Errorf("endpoint %s not found on this host", id)
+	}
+
+	return readIntfStats(filepath.Join(sysClassNet, epInfo.Ovsportname, "statistics"))
+}
+
+// GetEndpointDataplaneInfo returns the bridge, port and tags of a local
+// endpoint. The port is the one whose interface is labeled with the
+// endpoint id, its ofport is -1 until ovs assigned it.
+func (d *OvsDriver) GetEndpointDataplaneInfo(id string) (*drivers.DataplaneInfo, error) {
+	d.oper.localEpInfoMutex.Lock()
+	epInfo, found := d.oper.LocalEpInfo[id]
+	d.oper.localEpInfoMutex.Unlock()
+	if !found {
+		return nil, core.Errorf("endpoint %s not found on this host", id)
+	}
+	sw, ok := d.switchDb[epInfo.BridgeType]
+	if !ok {
+		return nil, core.Errorf("no %s bridge for endpoint %s", epInfo.BridgeType, id)
+	}
+
+	info := &drivers.DataplaneInfo{Bridge: sw.bridgeName, Port: epInfo.Ovsportname, OfPort: -1}
+	for _, port := range sw.ovsdbDriver.GetEndpointPorts() {
+		if port.EndpointID == id {
+			info.Port = port.Name
+			info.OfPort = port.OfPort
+			break
+		}
+	}
+
+	cfgEp := &mastercfg.CfgEndpointState{}
+	cfgEp.StateDriver = d.oper.StateDriver
+	if err := cfgEp.Read(id); err != nil {
+		return nil, err
+	}
+	cfgNw := &mastercfg.CfgNetworkState{}
+	cfgNw.StateDriver = d.oper.StateDriver
+	if err := cfgNw.Read(cfgEp.NetID); err != nil {
+		return nil, err
+	}
+	info.Vlan = cfgNw.PktTag
+	if cfgNw.PktTagType == "vxlan" {
+		info.Vni = cfgNw.ExtPktTag
+	}
+	return info, nil
+}
+
+// readIntfStats reads interface counters from a sysfs statistics directory
+func readIntfStats(statsDir string) (*drivers.EndpointStats, error) {
+	stats := &drivers.EndpointStats{}
+	counters := map[string]*uint64{
+		"rx_bytes":   &stats.RxBytes,
+		"tx_bytes":   &stats.TxBytes,
+		"rx_packets": &stats.RxPackets,
+		"tx_packets": &stats.TxPackets,
+		"rx_dropped": &stats.RxDropped,
+		"tx_dropped": &stats.TxDropped,
+	}
+
+	for name, counter := range counters {
+		data, err := ioutil.ReadFile(filepath.Join(statsDir, name))
+		if err != nil {
+			log.Errorf("Error reading %s. Err: %v", name, err)
+			return nil, err
+		}
+		*counter, err = strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+		if err != nil {
+			log.Errorf("Error parsing %s. Err: %v", name, err)
+			return nil, err
+		}
+	}
+
+	return stats, nil
+}
+
+// UpdateEndpointBinding refreshes the network and container labels of the
+// ovs port of a local endpoint from its config
+func (d *OvsDriver) UpdateEndpointBinding(id string) error {
+	d.oper.localEpInfoMutex.Lock()
+	epInfo, found := d.oper.LocalEpInfo[id]
+	d.oper.localEpInfoMutex.Unlock()
+	if !found {
+		return nil
+	}
+
+	cfgEp := &mastercfg.CfgEndpointState{}
+	cfgEp.StateDriver = d.oper.StateDriver
+	if err := cfgEp.Read(id); err != nil {
+		return err
+	}
+
+	sw, ok := d.switchDb[epInfo.BridgeType]
+	if !ok {
+		return core.Errorf("unknown bridge type %s of endpoint %s", epInfo.BridgeType, id)
+	}
+	return sw.ovsdbDriver.SetPortExternalIDs(epInfo.Ovsportname, endpointExtIDs(cfgEp))
+}
+
+// MoveEndpoint rewires the port of a local endpoint for the network,
+// address and group in its config, after the endpoint moved to another
+// network. The port, and so the interface of the container, is kept, the
+// networks must be on the same bridge.
+func (d *OvsDriver) MoveEndpoint(id string) error {
+	d.oper.localEpInfoMutex.Lock()
+	epInfo, found := d.oper.LocalEpInfo[id]
+	d.oper.localEpInfoMutex.Unlock()
+	if !found {
+		return core.Errorf("endpoint %s is not wired on this host", id)
+	}
+
+	operEp := &drivers.OperEndpointState{}
+	operEp.StateDriver = d.oper.StateDriver
+	if err := operEp.Read(id); err != nil {
+		return err
+	}
+	cfgEp := &mastercfg.CfgEndpointState{}
+	cfgEp.StateDriver = d.oper.StateDriver
+	if err := cfgEp.Read(id); err != nil {
+		return err
+	}
+	cfgNw := mastercfg.CfgNetworkState{}
+	cfgNw.StateDriver = d.oper.StateDriver
+	if err := cfgNw.Read(cfgEp.NetID); err != nil {
+		log.Errorf("Unable to get network %s. Err: %v", cfgEp.NetID, err)
+		return err
+	}
+
+	pktTagType := cfgNw.PktTagType
+	pktTag := cfgNw.PktTag
+	epgKey := ""
+	dscp := 0
+	if cfgEp.EndpointGroupKey != "" {
+		cfgEpGroup := &mastercfg.EndpointGroupState{}
+		cfgEpGroup.StateDriver = d.oper.StateDriver
+		err := cfgEpGroup.Read(cfgEp.EndpointGroupKey)
+		if err == nil {
+			pktTagType = cfgEpGroup.PktTagType
+			pktTag = cfgEpGroup.PktTag
+			epgKey = cfgEp.EndpointGroupKey
+			dscp = cfgEpGroup.DSCP
+		} else if core.ErrIfKeyExists(err) != nil {
+			return err
+		}
+	}
+	if cfgEp.DSCP != 0 {
+		dscp = cfgEp.DSCP
+	}
+
+	if pktTagType != epInfo.BridgeType {
+		return core.Errorf("endpoint %s can not move from a %s to a %s network",
+			id, epInfo.BridgeType, pktTagType)
+	}
+	sw, ok := d.switchDb[epInfo.BridgeType]
+	if !ok {
+		return core.Errorf("unknown bridge type %s of endpoint %s", epInfo.BridgeType, id)
+	}
+
+	err := sw.MovePort(operEp.PortName, cfgEp, pktTag, cfgNw.PktTag, dscp, operEp.Bonded)
+	if err != nil {
+		log.Errorf("Error moving port %s. Err: %v", operEp.PortName, err)
+		return err
+	}
+
+	d.oper.localEpInfoMutex.Lock()
+	epInfo.EpgKey = epgKey
+	epInfo.Dscp = cfgEp.DSCP
+	d.oper.localEpInfoMutex.Unlock()
+	if err := d.oper.Write(); err != nil {
+		return err
+	}
+
+	operEp.NetID = cfgEp.NetID
+	operEp.ServiceName = cfgEp.ServiceName
+	operEp.IPAddress = cfgEp.IPAddress
+	operEp.IPv6Address = cfgEp.IPv6Address
+	operEp.SecondaryIPs = cfgEp.SecondaryIPs
+	return operEp.Write()
+}
+
+// WatchEndpointLinks calls handler when the link of an endpoint port goes
+// down or up, nil stops the notifications
+func (d *OvsDriver) WatchEndpointLinks(handler func(endpointID string, up bool)) {
+	for _, sw := range d.switchDb {
+		sw.ovsdbDriver.SetLinkStateHandler(handler)
+	}
+}
+
 // InspectState returns driver state as json string
 func (d *OvsDriver) InspectState() ([]byte, error) {
 	driverState := make(map[string]interface{})
@@ -792,6 +1586,8 @@ func (d *OvsDriver) InspectState() ([]byte, error) {
 	// build the map
 	driverState["vlan"] = vlanState
 	driverState["vxlan"] = vxlanState
+	// both switches share the same ovsdb, either one lists all the ports
+	driverState["ports"] = d.switchDb["vlan"].ovsdbDriver.GetEndpointPorts()
 
 	// json marshall the map
 	jsonState, err := json.Marshal(driverState)
@@ -803,6 +1599,63 @@ func (d *OvsDriver) InspectState() ([]byte, error) {
 	return jsonState, nil
 }
 
+// DumpFlows returns the raw flow entries programmed on one of the driver's
+// bridges, as reported by ovs-ofctl
+func (d *OvsDriver) DumpFlows(bridge string) ([]string, error) {
+	found := false
+	for _, sw := range d.switchDb {
+		if sw.bridgeName == bridge {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, core.Errorf("bridge %s is not managed by the ovs driver", bridge)
+	}
+
+	ofctlPath, err := osexec.LookPath("ovs-ofctl")
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := osexec.Command(ofctlPath, "-O", "OpenFlow13", "dump-flows",
+		bridge).CombinedOutput()
+	if err != nil {
+		log.Errorf("Error dumping flows on bridge %s. Err: %v - %s", bridge, err, out)
+		return nil, err
+	}
+
+	flows := []string{}
+	for _, line := range strings.Split(string(out), "\n") {
+		line = strings.TrimSpace(line)
+		// skip the reply header and blank lines
+		if line == "" || strings.HasPrefix(line, "OFPST_FLOW") {
+			continue
+		}
+		flows = append(flows, line)
+	}
+
+	return flows, nil
+}
+
+// DumpNetworkFlows returns the flow entries of the bridge serving a network
+func (d *OvsDriver) DumpNetworkFlows(networkID string) ([]string, error) {
+	cfgNw := mastercfg.CfgNetworkState{}
+	cfgNw.StateDriver = d.oper.StateDriver
+	err := cfgNw.Read(networkID)
+	if err != nil {
+		log.Errorf("Failed to read net %s. Err: %v", networkID, err)
+		return nil, err
+	}
+
+	sw := d.switchDb["vlan"]
+	if cfgNw.PktTagType == "vxlan" {
+		sw = d.switchDb["vxlan"]
+	}
+
+	return d.DumpFlows(sw.bridgeName)
+}
+
 // InspectBgp returns bgp state as json string
 func (d *OvsDriver) InspectBgp() ([]byte, error) {
 
@@ -830,9 +1683,11 @@ func (d *OvsDriver) GlobalConfigUpdate(inst core.InstanceInfo) error {
 	switch inst.ArpMode {
 	case "flood":
 		cfg.ArpMode = ofnet.ArpFlood
+		d.arpMode = "flood"
 	default:
 		// set the default to proxy for graceful upgrade
 		cfg.ArpMode = ofnet.ArpProxy
+		d.arpMode = "proxy"
 	}
 
 	errs := ""
@@ -867,12 +1722,45 @@ func (d *OvsDriver) InspectNameserver() ([]byte, error) {
 
 // AddPolicyRule creates a policy rule
 func (d *OvsDriver) AddPolicyRule(id string) error {
-	log.Debug("OVS driver ignoring PolicyRule create as it uses ofnet sync")
+	// ofnet programs the rule itself, only its logging is left to the driver
+	ruleCfg := &mastercfg.CfgPolicyRule{}
+	ruleCfg.StateDriver = d.oper.StateDriver
+	err := ruleCfg.Read(id)
+	if err != nil {
+		return err
+	}
+	if !ruleCfg.Log {
+		return nil
+	}
+
+	for _, sw := range []*OvsSwitch{d.switchDb["vlan"], d.switchDb["vxlan"]} {
+		err = sw.AddFlowLog(&ruleCfg.OfnetPolicyRule, d.flowLog.sampling)
+		if err != nil {
+			log.Errorf("Error logging policy rule %s. Err: %v", id, err)
+			return err
+		}
+	}
+
+	d.flowLog.Lock()
+	d.flowLog.rules[flowLogFlowCookie(id)] = id
+	d.flowLog.Unlock()
 	return nil
 }
 
 // DelPolicyRule deletes a policy rule
 func (d *OvsDriver) DelPolicyRule(id string) error {
-	log.Debug("OVS driver ignoring PolicyRule delete as it uses ofnet sync")
+	// ofnet removes the rule itself, its log flow may be left
+	for _, sw := range []*OvsSwitch{d.switchDb["vlan"], d.switchDb["vxlan"]} {
+		if err := sw.DeleteFlowLog(id); err != nil {
+			log.Errorf("Error deleting the log flow of policy rule %s. Err: %v", id, err)
+			return err
+		}
+	}
+
+	cookie := flowLogFlowCookie(id)
+	d.flowLog.Lock()
+	delete(d.flowLog.rules, cookie)
+	delete(d.flowLog.counts, cookie)
+	d.flowLog.Unlock()
 	return nil
 }
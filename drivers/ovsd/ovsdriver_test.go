@@ -17,14 +17,22 @@ package ovsd
 
 import (
 	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/contiv/libovsdb"
 	"github.com/contiv/netplugin/core"
 	"github.com/contiv/netplugin/netmaster/mastercfg"
 	"github.com/contiv/netplugin/state"
+	"github.com/contiv/ofnet"
+	cmap "github.com/streamrail/concurrent-map"
 )
 
 const (
@@ -368,6 +376,42 @@ func TestOvsDriverCreateEndpoint(t *testing.T) {
 	}
 }
 
+func TestOvsDriverEndpointPortLabels(t *testing.T) {
+	driver := initOvsDriver(t, bridgeMode, defPvtNW)
+	defer func() { driver.Deinit() }()
+	id := createEpID
+
+	err := driver.CreateNetwork(testOvsNwID)
+	if err != nil {
+		t.Fatalf("network creation failed. Error: %s", err)
+	}
+	defer func() {
+		driver.DeleteNetwork(testOvsNwID, "", "", "", testPktTag, testExtPktTag, testGateway, testTenant)
+	}()
+
+	err = driver.CreateEndpoint(id)
+	if err != nil {
+		t.Fatalf("endpoint creation failed. Error: %s", err)
+	}
+	defer func() { driver.DeleteEndpoint(id) }()
+
+	if err := driver.UpdateEndpointBinding(id); err != nil {
+		t.Fatalf("error updating endpoint binding. Error: %s", err)
+	}
+
+	// the ovsdb cache is updated asynchronously
+	time.Sleep(300 * time.Millisecond)
+	for _, port := range driver.switchDb["vlan"].ovsdbDriver.GetEndpointPorts() {
+		if port.EndpointID == id {
+			if port.NetworkID != testOvsNwID {
+				t.Fatalf("port %s labeled with network %q, expected %q", port.Name, port.NetworkID, testOvsNwID)
+			}
+			return
+		}
+	}
+	t.Fatalf("no port labeled with endpoint %s", id)
+}
+
 func TestOvsDriverCreateEndpointStateful(t *testing.T) {
 	driver := initOvsDriver(t, bridgeMode, defPvtNW)
 	defer func() { driver.Deinit() }()
@@ -612,3 +656,760 @@ func TestHostPort(t *testing.T) {
 	}
 	driver.Deinit()
 }
+
+func TestOvsDriverDumpFlows(t *testing.T) {
+	driver := initOvsDriver(t, bridgeMode, defPvtNW)
+	defer func() { driver.Deinit() }()
+
+	flows, err := driver.DumpFlows(vlanBridgeName)
+	if err != nil {
+		t.Fatalf("dumping flows failed. Error: %s", err)
+	}
+	for _, flow := range flows {
+		if strings.HasPrefix(flow, "OFPST_FLOW") {
+			t.Fatalf("flow dump contains reply header: %s", flow)
+		}
+	}
+
+	if _, err := driver.DumpFlows("unknownBridge"); err == nil {
+		t.Fatalf("dumping flows of an unmanaged bridge succeeded, expected to fail")
+	}
+}
+
+func TestReadIntfStats(t *testing.T) {
+	statsDir, err := ioutil.TempDir("", "ovsstats")
+	if err != nil {
+		t.Fatalf("error creating stats dir. Error: %s", err)
+	}
+	defer os.RemoveAll(statsDir)
+
+	counters := map[string]string{
+		"rx_bytes":   "1000\n",
+		"tx_bytes":   "2000\n",
+		"rx_packets": "10\n",
+		"tx_packets": "20\n",
+		"rx_dropped": "1\n",
+		"tx_dropped": "0\n",
+	}
+	for name, val := range counters {
+		if err := ioutil.WriteFile(filepath.Join(statsDir, name), []byte(val), 0644); err != nil {
+			t.Fatalf("error writing %s. Error: %s", name, err)
+		}
+	}
+
+	stats, err := readIntfStats(statsDir)
+	if err != nil {
+		t.Fatalf("error reading stats. Error: %s", err)
+	}
+	if stats.RxBytes != 1000 || stats.TxBytes != 2000 || stats.RxPackets != 10 ||
+		stats.TxPackets != 20 || stats.RxDropped != 1 || stats.TxDropped != 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	if _, err := readIntfStats(filepath.Join(statsDir, "missing")); err == nil {
+		t.Fatalf("reading stats of a missing interface succeeded, expected to fail")
+	}
+}
+
+func TestValidateOvsConfig(t *testing.T) {
+	cfg := core.OvsDriverConfig{}
+	if err := validateOvsConfig(&cfg); err != nil {
+		t.Fatalf("error validating empty ovs config. Error: %s", err)
+	}
+	if cfg.FlowLimit != defaultFlowLimit || cfg.MaxIdle != defaultMaxIdle {
+		t.Fatalf("defaults not applied: %+v", cfg)
+	}
+	if args := ctLimitArgs(&cfg); args != nil {
+		t.Fatalf("unexpected conntrack args %v without limits", args)
+	}
+
+	invalidCfgs := []core.OvsDriverConfig{
+		{FlowLimit: -1},
+		{MaxIdle: 100},
+		{CtZoneLimit: -1},
+		{CtZoneLimits: map[int]int{70000: 10}},
+		{CtZoneLimits: map[int]int{5: -10}},
+		{OvsdbEndpoint: "10.1.1.1:6640"},
+		{OvsdbEndpoint: "tcp:10.1.1.1"},
+		{OvsdbEndpoint: "tcp:10.1.1.1:70000"},
+		{OvsdbEndpoint: "ssl:10.1.1.1:6640"},
+		{OvsdbEndpoint: "ssl:10.1.1.1:6640", OvsdbKey: "key.pem", OvsdbCert: "cert.pem"},
+		{OvsdbEndpoint: "tcp:10.1.1.1:6640", OvsdbCACert: "ca.pem"},
+		{OvsdbEndpoint: "tcp:10.1.1.1:6640", CtZoneLimit: 1000},
+		{OvsdbEndpoint: "ssl:10.1.1.1:6640", OvsdbKey: "key.pem", OvsdbCert: "cert.pem",
+			OvsdbCACert: "ca.pem", VethPoolSize: 10},
+		{VethPoolSize: -1},
+		{VethPoolSize: 4, VethPoolLowWater: 5},
+		{VethPoolSize: 4, VethPoolRefill: -1},
+		{FlowLogCollector: "10.1.1.1"},
+		{FlowLogCollector: ":4739"},
+		{FlowLogCollector: "10.1.1.1:0"},
+		{FlowLogSampling: -1},
+		{AgeingTime: -1},
+	}
+	for _, c := range invalidCfgs {
+		if err := validateOvsConfig(&c); err == nil {
+			t.Fatalf("ovs config %+v validated, expected to fail", c)
+		}
+	}
+
+	cfg = core.OvsDriverConfig{CtZoneLimit: 1000, CtZoneLimits: map[int]int{9: 50, 3: 20}}
+	if err := validateOvsConfig(&cfg); err != nil {
+		t.Fatalf("error validating ovs config. Error: %s", err)
+	}
+	args := strings.Join(ctLimitArgs(&cfg), " ")
+	if args != "dpctl/ct-set-limits default=1000 zone=3,limit=20 zone=9,limit=50" {
+		t.Fatalf("unexpected conntrack args %q", args)
+	}
+
+	for _, endpoint := range []string{"", "unix:/var/run/openvswitch/db.sock", "tcp:10.1.1.1:6640", "tcp:[fd00::1]:6640"} {
+		cfg = core.OvsDriverConfig{OvsdbEndpoint: endpoint}
+		if err := validateOvsConfig(&cfg); err != nil {
+			t.Fatalf("error validating ovsdb endpoint %q. Error: %s", endpoint, err)
+		}
+	}
+	cfg = core.OvsDriverConfig{OvsdbEndpoint: "ssl:10.1.1.1:6640", OvsdbKey: "key.pem",
+		OvsdbCert: "cert.pem", OvsdbCACert: "ca.pem"}
+	if err := validateOvsConfig(&cfg); err != nil {
+		t.Fatalf("error validating ssl ovsdb endpoint. Error: %s", err)
+	}
+	if _, err := ovsdbTLSConfig(&cfg, "10.1.1.1:6640"); err == nil {
+		t.Fatalf("TLS config built without certificate files")
+	}
+
+	if remoteOvsdb("") || remoteOvsdb("unix:/var/run/openvswitch/db.sock") ||
+		!remoteOvsdb("tcp:10.1.1.1:6640") || !remoteOvsdb("ssl:10.1.1.1:6640") {
+		t.Fatalf("unexpected remote ovsdb endpoints")
+	}
+}
+
+func TestEndpointLinkChanges(t *testing.T) {
+	intfRow := func(linkState interface{}, epID string) libovsdb.Row {
+		extIDs := map[interface{}]interface{}{}
+		if epID != "" {
+			extIDs[extIDEndpoint] = epID
+		}
+		return libovsdb.Row{Fields: map[string]interface{}{
+			"name":         "port" + epID,
+			"link_state":   linkState,
+			"external_ids": libovsdb.OvsMap{GoMap: extIDs},
+		}}
+	}
+	unset := libovsdb.OvsSet{}
+
+	upd := libovsdb.TableUpdate{Rows: map[string]libovsdb.RowUpdate{
+		"down":      {Old: intfRow("up", ""), New: intfRow("down", "ep1")},
+		"up":        {Old: intfRow("down", ""), New: intfRow("up", "ep2")},
+		"same":      {Old: intfRow("up", ""), New: intfRow("up", "ep3")},
+		"new":       {New: intfRow("up", "ep4")},
+		"first":     {Old: intfRow(unset, ""), New: intfRow("up", "ep5")},
+		"deleted":   {Old: intfRow("up", "ep6")},
+		"unlabeled": {Old: intfRow("up", ""), New: intfRow("down", "")},
+	}}
+
+	changes := endpointLinkChanges(upd)
+	expChanges := map[string]bool{"ep1": false, "ep2": true}
+	if !reflect.DeepEqual(changes, expChanges) {
+		t.Fatalf("unexpected link changes %v, expected %v", changes, expChanges)
+	}
+}
+
+func TestCheckUnderlayMtu(t *testing.T) {
+	lo, err := net.InterfaceByName("lo")
+	if err != nil {
+		t.Skipf("no loopback interface: %v", err)
+	}
+
+	vxlanSw := &OvsSwitch{bridgeName: vxlanBridgeName, netType: "vxlan", vtepIP: "127.0.0.1"}
+	vlanSw := &OvsSwitch{bridgeName: vlanBridgeName, netType: "vlan", uplinkDb: cmap.New()}
+	vlanSw.uplinkDb.Set("uplinkPort", []string{"lo"})
+
+	for _, sw := range []*OvsSwitch{vxlanSw, vlanSw} {
+		if err := sw.CheckUnderlayMtu(lo.MTU); err != nil {
+			t.Fatalf("%s: underlay mtu %d rejected. Err: %v", sw.netType, lo.MTU, err)
+		}
+		if err := sw.CheckUnderlayMtu(lo.MTU + 1); err == nil {
+			t.Fatalf("%s: underlay mtu %d accepted", sw.netType, lo.MTU+1)
+		}
+	}
+
+	vxlanSw.vtepIP = "192.0.2.123"
+	if err := vxlanSw.CheckUnderlayMtu(1500); err == nil {
+		t.Fatalf("underlay mtu accepted without a vtep interface")
+	}
+}
+
+func TestVtepPSK(t *testing.T) {
+	options := func(opts map[string]string) libovsdb.OvsMap {
+		m, _ := libovsdb.NewOvsMap(opts)
+		return *m
+	}
+	d := &OvsdbDriver{cache: map[string]map[libovsdb.UUID]libovsdb.Row{
+		interfaceTable: {
+			libovsdb.UUID{GoUuid: "1"}: {Fields: map[string]interface{}{"name": "vxif2", "type": "vxlan",
+				"options": options(map[string]string{"remote_ip": "10.1.1.2"})}},
+			libovsdb.UUID{GoUuid: "2"}: {Fields: map[string]interface{}{"name": "vxif1", "type": "vxlan",
+				"options": options(map[string]string{"remote_ip": "10.1.1.1"})}},
+			libovsdb.UUID{GoUuid: "3"}: {Fields: map[string]interface{}{"name": "vport1", "type": "internal",
+				"options": options(map[string]string{})}},
+		},
+	}}
+
+	names := d.vtepIntfNames()
+	if !reflect.DeepEqual(names, []string{"vxif1", "vxif2"}) {
+		t.Fatalf("unexpected vtep interfaces %v", names)
+	}
+
+	for _, psk := range []string{"", "secret"} {
+		ops, err := vtepPSKOps(names, psk)
+		if err != nil {
+			t.Fatalf("error building psk operations. Err: %v", err)
+		}
+		if len(ops) != len(names) {
+			t.Fatalf("expected %d operations, got %d", len(names), len(ops))
+		}
+		expMutations := 1
+		if psk != "" {
+			expMutations = 2
+		}
+		for _, op := range ops {
+			if op.Op != "mutate" || len(op.Mutations) != expMutations {
+				t.Fatalf("psk %q: unexpected operation %+v", psk, op)
+			}
+		}
+	}
+}
+
+func TestInterconnectPortNames(t *testing.T) {
+	portA, portB := interconnectPortNames("host1:blue.default:orange.default")
+	if portA == portB {
+		t.Fatalf("both ends of the interconnect are named %s", portA)
+	}
+	for _, name := range []string{portA, portB} {
+		if len(name) > 15 {
+			t.Fatalf("port name %s is longer than an interface name", name)
+		}
+	}
+	if otherA, _ := interconnectPortNames("host2:blue.default:orange.default"); otherA == portA {
+		t.Fatalf("interconnects of different hosts share port %s", portA)
+	}
+}
+
+func TestPatchFlows(t *testing.T) {
+	if flows := patchFlows(100, nil); len(flows) != 0 {
+		t.Fatalf("flows %v without patch ports", flows)
+	}
+
+	exp := []string{
+		"cookie=0x7063000000000064,table=0,priority=102,in_port=7,actions=push_vlan:0x8100,set_field:4196->vlan_vid,goto_table:9",
+		"cookie=0x7063000000000064,table=0,priority=102,in_port=9,actions=push_vlan:0x8100,set_field:4196->vlan_vid,goto_table:9",
+		"cookie=0x7063000000000064,table=9,priority=12,dl_vlan=100,reg6=0/0x2,actions=load:1->NXM_NX_REG6[1],pop_vlan,output:7,output:9,push_vlan:0x8100,set_field:4196->vlan_vid,resubmit(,9)",
+	}
+	flows := patchFlows(100, []uint32{7, 9})
+	if !reflect.DeepEqual(flows, exp) {
+		t.Fatalf("unexpected patch flows %q, expected %q", flows, exp)
+	}
+
+	d := &OvsDriver{fwdMode: "bridge", arpMode: "proxy"}
+	if err := d.checkPatchDatapath(); err == nil {
+		t.Fatalf("patch ports joined with the arp proxy")
+	}
+	d.arpMode = "flood"
+	if err := d.checkPatchDatapath(); err != nil {
+		t.Fatalf("error checking the patch datapath. Err: %v", err)
+	}
+	d.fwdMode = "routing"
+	if err := d.checkPatchDatapath(); err == nil {
+		t.Fatalf("patch ports joined in routing mode")
+	}
+}
+
+func TestVxlanGatewayNames(t *testing.T) {
+	bridge, gwPort, nwPort := vxlanGatewayNames("host1:orange.default")
+	if gwPort == nwPort || bridge == gwPort {
+		t.Fatalf("vxlan gateway names collide: %s %s %s", bridge, gwPort, nwPort)
+	}
+	for _, name := range []string{bridge, gwPort, nwPort} {
+		if len(name) > 15 {
+			t.Fatalf("name %s is longer than an interface name", name)
+		}
+	}
+	if other, _, _ := vxlanGatewayNames("host2:orange.default"); other == bridge {
+		t.Fatalf("vxlan gateways of different hosts share bridge %s", bridge)
+	}
+}
+
+func TestNotrackRuleArgs(t *testing.T) {
+	exemption := mastercfg.ConntrackExemption{Protocol: "tcp", DstIP: "10.1.1.2", DstPort: 80}
+	rules := notrackRuleArgs("-A", "orange-ep1", exemption)
+	if len(rules) != 4 {
+		t.Fatalf("expected rules for both directions in both chains, got %v", rules)
+	}
+	expRules := []string{
+		"-w 5 -t raw -A PREROUTING -p tcp -d 10.1.1.2 -m tcp --dport 80 -m comment --comment contiv-notrack:orange-ep1 -j CT --notrack",
+		"-w 5 -t raw -A PREROUTING -p tcp -s 10.1.1.2 -m tcp --sport 80 -m comment --comment contiv-notrack:orange-ep1 -j CT --notrack",
+		"-w 5 -t raw -A OUTPUT -p tcp -d 10.1.1.2 -m tcp --dport 80 -m comment --comment contiv-notrack:orange-ep1 -j CT --notrack",
+		"-w 5 -t raw -A OUTPUT -p tcp -s 10.1.1.2 -m tcp --sport 80 -m comment --comment contiv-notrack:orange-ep1 -j CT --notrack",
+	}
+	for idx, rule := range rules {
+		if strings.Join(rule, " ") != expRules[idx] {
+			t.Fatalf("unexpected rule %q, expected %q", strings.Join(rule, " "), expRules[idx])
+		}
+	}
+
+	// a flow that is its own reply is matched once per chain
+	symmetric := mastercfg.ConntrackExemption{Protocol: "udp", SrcPort: 4789, DstPort: 4789}
+	if rules := notrackRuleArgs("-D", "orange-ep1", symmetric); len(rules) != 2 {
+		t.Fatalf("unexpected rules for a symmetric flow: %v", rules)
+	}
+
+	cfgEp := &mastercfg.CfgEndpointState{IPAddress: "10.1.1.2", IPv6Address: "2016::2"}
+	if tables := notrackTables(cfgEp, symmetric); !reflect.DeepEqual(tables, []string{"iptables", "ip6tables"}) {
+		t.Fatalf("unexpected tables %v for an exemption without addresses", tables)
+	}
+	if tables := notrackTables(cfgEp, mastercfg.ConntrackExemption{Protocol: "tcp", SrcIP: "2017::/64"}); !reflect.DeepEqual(tables, []string{"ip6tables"}) {
+		t.Fatalf("unexpected tables %v for an ipv6 exemption", tables)
+	}
+}
+
+func TestBondLinkArgs(t *testing.T) {
+	lacp := &mastercfg.EndpointBond{Mode: mastercfg.BondModeLACP, Members: []string{"eth1", "eth2"}}
+	exp := "link add name vport5 type bond mode 802.3ad miimon 100"
+	if args := strings.Join(bondLinkArgs("vport5", lacp), " "); args != exp {
+		t.Fatalf("unexpected bond args %q, expected %q", args, exp)
+	}
+
+	backup := &mastercfg.EndpointBond{Mode: mastercfg.BondModeActiveBackup, Members: []string{"eth1", "eth2"}}
+	exp = "link add name vport6 type bond mode active-backup miimon 100"
+	if args := strings.Join(bondLinkArgs("vport6", backup), " "); args != exp {
+		t.Fatalf("unexpected bond args %q, expected %q", args, exp)
+	}
+}
+
+func TestTapIntfName(t *testing.T) {
+	if name := tapIntfName("vport12"); name != "tap12" {
+		t.Fatalf("unexpected tap name %q of vport12", name)
+	}
+	if port := getOvsPortName(tapIntfName("vport12"), true); port != "tap12" {
+		t.Fatalf("unexpected ovs port %q of tap12", port)
+	}
+}
+
+func TestNetworkFlowSpec(t *testing.T) {
+	flow := &mastercfg.NetworkFlowState{Table: 5, Priority: 200, Match: "tcp,tp_dst=23", Actions: "drop"}
+	flow.ID = "host1:orange.default:drop-telnet"
+	cookie := networkFlowCookieOf(flow.ID)
+	if cookie&0xffff000000000000 != networkFlowCookie || cookie == networkFlowCookieOf("host1:orange.default:other") {
+		t.Fatalf("unexpected cookie 0x%x", cookie)
+	}
+
+	exp := fmt.Sprintf("cookie=0x%x,table=5,priority=200,check_overlap,dl_vlan=10,tcp,tp_dst=23,actions=drop", cookie)
+	if spec := networkFlowSpec(flow, 10); spec != exp {
+		t.Fatalf("unexpected flow %q, expected %q", spec, exp)
+	}
+
+	flow.Match = ""
+	exp = fmt.Sprintf("cookie=0x%x,table=5,priority=200,check_overlap,dl_vlan=10,actions=drop", cookie)
+	if spec := networkFlowSpec(flow, 10); spec != exp {
+		t.Fatalf("unexpected flow %q without a match, expected %q", spec, exp)
+	}
+
+	// frames are not tagged with their network before the vlan table
+	d := &OvsDriver{}
+	flow.Table = ofnet.VLAN_TBL_ID
+	if err := d.AddNetworkFlow(flow); err == nil {
+		t.Fatalf("flow added to the vlan table")
+	}
+}
+
+func TestVethPool(t *testing.T) {
+	cfg := core.OvsDriverConfig{VethPoolSize: 3}
+	if err := validateOvsConfig(&cfg); err != nil {
+		t.Fatalf("error validating ovs config. Error: %s", err)
+	}
+	if cfg.VethPoolLowWater != 2 || cfg.VethPoolRefill != defaultVethPoolRefill {
+		t.Fatalf("veth pool defaults not applied: %+v", cfg)
+	}
+
+	num := 0
+	pairs := map[string]bool{}
+	vp := newVethPool(&cfg, func() (string, error) {
+		num++
+		return fmt.Sprintf("vport%d", num), nil
+	})
+	vp.create = func(intfName string) error {
+		pairs[intfName] = true
+		return nil
+	}
+	vp.destroy = func(intfName string) error {
+		delete(pairs, intfName)
+		return nil
+	}
+	vp.reset = func(intfName string) error {
+		if intfName == "vport9" {
+			return fmt.Errorf("still has an address")
+		}
+		return nil
+	}
+
+	vp.refill()
+	if len(vp.free) != 3 || len(pairs) != 3 {
+		t.Fatalf("pool not filled, free %v", vp.free)
+	}
+
+	intfName, ok := vp.get()
+	if !ok || !pairs[intfName] {
+		t.Fatalf("unexpected pair %q taken from the pool", intfName)
+	}
+	select {
+	case <-vp.refillCh:
+		t.Fatalf("refill requested above the low water")
+	default:
+	}
+	vp.get()
+	select {
+	case <-vp.refillCh:
+	default:
+		t.Fatalf("refill not requested below the low water")
+	}
+
+	if !vp.put(intfName) || len(vp.free) != 2 {
+		t.Fatalf("pair %s not returned to the pool, free %v", intfName, vp.free)
+	}
+	if vp.put("vport9") {
+		t.Fatalf("pair that failed to reset returned to the pool")
+	}
+	vp.refill()
+	if vp.put("vport10") {
+		t.Fatalf("pair returned to a full pool")
+	}
+
+	vp.stop()
+	if len(pairs) != 1 {
+		t.Fatalf("pooled pairs not deleted on stop, left %v", pairs)
+	}
+	if _, ok := vp.get(); ok {
+		t.Fatalf("pair taken from a stopped pool")
+	}
+
+	var noPool *vethPool
+	if _, ok := noPool.get(); ok || noPool.put("vport1") {
+		t.Fatalf("pair taken from or returned to a disabled pool")
+	}
+}
+
+func TestFlowLogFlow(t *testing.T) {
+	rule := &ofnet.OfnetPolicyRule{
+		RuleId:           "default:web:1-inRx",
+		Priority:         10,
+		DstEndpointGroup: 10,
+		SrcIpAddr:        "10.1.1.0/24",
+		IpProtocol:       6,
+		DstPort:          80,
+		TcpFlags:         "syn,!ack",
+		Action:           "deny",
+	}
+	cookie := flowLogFlowCookie(rule.RuleId)
+	match := fmt.Sprintf("cookie=0x%x,table=5,priority=21,ip,reg6=0,metadata=0x14/0xfffe,nw_src=10.1.1.0/24,"+
+		"nw_proto=6,tp_dst=80,tcp_flags=+syn-ack,actions=", cookie)
+
+	flow, err := flowLogFlow(rule, 0)
+	if err != nil {
+		t.Fatalf("error building the flow log flow. Err: %v", err)
+	}
+	if exp := match + "load:0x1->NXM_NX_REG6[0],resubmit(,5)"; flow != exp {
+		t.Fatalf("unexpected flow log flow %q, expected %q", flow, exp)
+	}
+
+	flow, err = flowLogFlow(rule, 100)
+	if err != nil {
+		t.Fatalf("error building the flow log flow. Err: %v", err)
+	}
+	exp := match + fmt.Sprintf("sample(probability=655,collector_set_id=1,obs_domain_id=0,obs_point_id=%d),"+
+		"load:0x1->NXM_NX_REG6[0],resubmit(,5)", uint32(cookie))
+	if flow != exp {
+		t.Fatalf("unexpected sampling flow log flow %q, expected %q", flow, exp)
+	}
+
+	rule.SrcIpAddr = "10.1.1.1-10"
+	if _, err := flowLogFlow(rule, 0); err == nil {
+		t.Fatalf("flow log flow built for an address range")
+	}
+}
+
+func TestParseFlowCounts(t *testing.T) {
+	cookie := flowLogFlowCookie("default:web:1-inRx")
+	out := fmt.Sprintf("OFPST_FLOW reply (OF1.3) (xid=0x2):\n"+
+		" cookie=0x%x, duration=10.5s, table=5, n_packets=12, n_bytes=720, priority=16,ip,reg6=0 actions=resubmit(,5)\n"+
+		" cookie=0x6d74000000000003, duration=10.5s, table=0, n_packets=7, n_bytes=420, priority=600 actions=meter:3\n",
+		cookie)
+
+	counts := parseFlowCounts(out)
+	if len(counts) != 1 || counts[cookie] != 12 {
+		t.Fatalf("unexpected flow log counts %v", counts)
+	}
+}
+
+func TestVrfLinkArgs(t *testing.T) {
+	exp := "link add name vrf-blue type vrf table 10"
+	if args := strings.Join(vrfLinkArgs("vrf-blue", 10), " "); args != exp {
+		t.Fatalf("unexpected vrf args %q, expected %q", args, exp)
+	}
+}
+
+func TestGatewayArpFlow(t *testing.T) {
+	cfgNw := &mastercfg.CfgNetworkState{SubnetIP: "10.1.1.0", SubnetLen: 24, Gateway: "10.1.1.1",
+		GatewayMac: "02:02:0a:01:01:01", PktTag: 100}
+	expIn := "cookie=0x6a6900000a010101,table=0,priority=500,arp,arp_op=1,arp_tpa=10.1.1.1,actions=goto_table:1"
+	exp := "cookie=0x6a77000000000064,table=3,priority=500,arp,dl_vlan=100,arp_op=1,arp_tpa=10.1.1.1,arp_spa=10.1.1.0/24," +
+		"actions=move:NXM_OF_ETH_SRC[]->NXM_OF_ETH_DST[],mod_dl_src:02:02:0a:01:01:01,load:0x2->NXM_OF_ARP_OP[]," +
+		"move:NXM_NX_ARP_SHA[]->NXM_NX_ARP_THA[],move:NXM_OF_ARP_SPA[]->NXM_OF_ARP_TPA[]," +
+		"load:0x02020a010101->NXM_NX_ARP_SHA[],load:0x0a010101->NXM_OF_ARP_SPA[],pop_vlan,in_port"
+	inFlow, flow, err := gwArpFlows(cfgNw)
+	if err != nil {
+		t.Fatalf("error building the gateway arp flows. Err: %v", err)
+	}
+	if inFlow != expIn {
+		t.Fatalf("unexpected gateway arp redirect flow %q, expected %q", inFlow, expIn)
+	}
+	if flow != exp {
+		t.Fatalf("unexpected gateway arp flow %q, expected %q", flow, exp)
+	}
+
+	// networks of other vrfs with the same subnet get flows of their own
+	other := *cfgNw
+	other.PktTag = 200
+	otherIn, otherFlow, err := gwArpFlows(&other)
+	if err != nil || otherIn != inFlow || otherFlow == flow ||
+		!strings.Contains(otherFlow, ",dl_vlan=200,") || !strings.HasPrefix(otherFlow, "cookie=0x6a770000000000c8,") {
+		t.Fatalf("unexpected gateway arp flows %q %q of another network. Err: %v", otherIn, otherFlow, err)
+	}
+
+	cfgNw.GatewayMac = ""
+	if _, _, err := gwArpFlows(cfgNw); err == nil {
+		t.Fatalf("gateway arp flow built without a gateway mac")
+	}
+}
+
+func TestBUMFlow(t *testing.T) {
+	cfgNw := &mastercfg.CfgNetworkState{PktTagType: "vxlan", PktTag: 100, BUMMode: mastercfg.BUMModeDrop}
+	exp := "cookie=0x62756d0000000064,table=9,priority=11,dl_vlan=100,metadata=0/0x1,actions=drop"
+	flow, err := bumFlow(cfgNw)
+	if err != nil {
+		t.Fatalf("error building the bum flow. Err: %v", err)
+	}
+	if flow != exp {
+		t.Fatalf("unexpected bum flow %q, expected %q", flow, exp)
+	}
+
+	cfgNw.BUMMode = mastercfg.BUMModeController
+	if flow, err := bumFlow(cfgNw); err != nil || !strings.HasSuffix(flow, ",actions=controller") {
+		t.Fatalf("unexpected controller bum flow %q. Err: %v", flow, err)
+	}
+
+	cfgNw.BUMMode = mastercfg.BUMModeFlood
+	if _, err := bumFlow(cfgNw); err == nil {
+		t.Fatalf("bum flow built for the flood mode")
+	}
+
+	d := &OvsDriver{fwdMode: "routing"}
+	if !d.SupportsBUMMode(mastercfg.BUMModeFlood) || d.SupportsBUMMode(mastercfg.BUMModeDrop) {
+		t.Fatalf("unexpected bum modes in routing mode")
+	}
+	d.fwdMode = "bridge"
+	if !d.SupportsBUMMode(mastercfg.BUMModeController) || d.SupportsBUMMode("replicate") {
+		t.Fatalf("unexpected bum modes in bridge mode")
+	}
+}
+
+func TestMeterArgs(t *testing.T) {
+	out := "OFPST_METER_FEATURES reply (OF1.3) (xid=0x2):\nmax_meter:200000 max_bands:1 max_color:0\n"
+	if count := parseMaxMeters(out); count != 200000 {
+		t.Fatalf("unexpected meter count %d", count)
+	}
+	if count := parseMaxMeters("max_meter:0 max_bands:0"); count != 0 {
+		t.Fatalf("unexpected meter count %d", count)
+	}
+
+	limit := &mastercfg.EndpointRateLimit{Rate: 10000}
+	if spec := meterSpec(7, limit); spec != "meter=7,kbps,band=type=drop,rate=10000" {
+		t.Fatalf("unexpected meter spec %q", spec)
+	}
+	limit.Burst = 500
+	if spec := meterSpec(7, limit); spec != "meter=7,kbps,burst,band=type=drop,rate=10000,burst_size=500" {
+		t.Fatalf("unexpected meter spec %q", spec)
+	}
+
+	exp := "cookie=0x6d74000000000007,table=0,priority=600,in_port=7,reg7=0," +
+		"actions=meter:7,load:0x1->NXM_NX_REG7[],resubmit(,0)"
+	if flow := meterFlow(7, 7); flow != exp {
+		t.Fatalf("unexpected meter flow %q, expected %q", flow, exp)
+	}
+}
+
+func TestMoveIntfArgs(t *testing.T) {
+	exp := [][]string{
+		{"link", "set", "dev", "vvport7", "name", "net0"},
+		{"link", "set", "dev", "net0", "address", "02:02:0a:01:01:02"},
+		{"addr", "replace", "10.1.1.2/24", "dev", "net0"},
+		{"link", "set", "dev", "net0", "up"},
+	}
+	if args := moveIntfArgs("vvport7", "net0", "02:02:0a:01:01:02", []string{"10.1.1.2/24"}); !reflect.DeepEqual(args, exp) {
+		t.Fatalf("unexpected move commands %v", args)
+	}
+
+	// the secondary addresses are added along with the primary ones
+	nwCfg := &mastercfg.CfgNetworkState{SubnetLen: 24, IPv6SubnetLen: 100}
+	epCfg := &mastercfg.CfgEndpointState{IPAddress: "10.1.1.2", IPv6Address: "2016::2",
+		SecondaryIPs: []string{"10.1.1.10", "2016::10"}}
+	exp = [][]string{
+		{"link", "set", "dev", "vvport7", "name", "net0"},
+		{"addr", "replace", "10.1.1.2/24", "dev", "net0"},
+		{"addr", "replace", "2016::2/100", "dev", "net0"},
+		{"addr", "replace", "10.1.1.10/24", "dev", "net0"},
+		{"addr", "replace", "2016::10/100", "dev", "net0"},
+		{"link", "set", "dev", "net0", "up"},
+	}
+	if args := moveIntfArgs("vvport7", "net0", "", epCfg.AddrCIDRs(nwCfg)); !reflect.DeepEqual(args, exp) {
+		t.Fatalf("unexpected move commands %v", args)
+	}
+
+	exp = [][]string{
+		{"link", "set", "dev", "net0", "name", "vvport7"},
+		{"link", "set", "dev", "vvport7", "netns", "42"},
+	}
+	if args := moveBackArgs("net0", "vvport7", 42); !reflect.DeepEqual(args, exp) {
+		t.Fatalf("unexpected move back commands %v", args)
+	}
+	if args := moveBackArgs("vvport7", "vvport7", 42); !reflect.DeepEqual(args, exp[1:]) {
+		t.Fatalf("unexpected move back commands %v", args)
+	}
+}
+
+func TestAdoptOwnedObjects(t *testing.T) {
+	extIDs := func(ids map[string]string) libovsdb.OvsMap {
+		m, _ := libovsdb.NewOvsMap(ids)
+		return *m
+	}
+	d := &OvsdbDriver{cache: map[string]map[libovsdb.UUID]libovsdb.Row{
+		bridgeTable: {
+			libovsdb.UUID{GoUuid: "1"}: {Fields: map[string]interface{}{"name": vlanBridgeName,
+				"external_ids": extIDs(map[string]string{extIDOwner: ownerNetplugin})}},
+			libovsdb.UUID{GoUuid: "2"}: {Fields: map[string]interface{}{"name": "br-ext",
+				"external_ids": extIDs(map[string]string{})}},
+		},
+		portTable: {
+			libovsdb.UUID{GoUuid: "3"}: {Fields: map[string]interface{}{"name": "vvport7",
+				"external_ids": extIDs(map[string]string{extIDEndpoint: "blue.default-ep1"})}},
+			libovsdb.UUID{GoUuid: "4"}: {Fields: map[string]interface{}{"name": "eth1",
+				"external_ids": extIDs(map[string]string{})}},
+		},
+	}}
+
+	if owner, present := d.bridgeOwner(vlanBridgeName); !present || owner != ownerNetplugin {
+		t.Fatalf("unexpected owner %q of bridge %s, present %v", owner, vlanBridgeName, present)
+	}
+	if owner, present := d.bridgeOwner("br-ext"); !present || owner != "" {
+		t.Fatalf("unexpected owner %q of an unlabeled bridge, present %v", owner, present)
+	}
+	if _, present := d.bridgeOwner("br-none"); present {
+		t.Fatalf("missing bridge found")
+	}
+
+	if err := d.checkPortOwned("vvport7"); err != nil {
+		t.Fatalf("endpoint port not owned. Err: %v", err)
+	}
+	if err := d.checkPortOwned("eth1"); err == nil {
+		t.Fatalf("foreign port owned")
+	}
+	if err := d.checkPortOwned("vvport8"); err != nil {
+		t.Fatalf("missing port not free. Err: %v", err)
+	}
+
+	drv := &OvsDriver{}
+	sw := &OvsSwitch{ovsdbDriver: d}
+	if name := drv.adoptEndpointPort(sw, "blue.default-ep1", true); name != "vvport7" {
+		t.Fatalf("unexpected adopted interface %q of a port without veth pair", name)
+	}
+	if name := drv.adoptEndpointPort(sw, "blue.default-ep2", false); name != "" {
+		t.Fatalf("port of another endpoint adopted as %q", name)
+	}
+	if useVethPair {
+		if name := drv.adoptEndpointPort(sw, "blue.default-ep1", false); name != "vport7" {
+			t.Fatalf("unexpected adopted interface %q of a veth port", name)
+		}
+	}
+}
+
+func TestGetEndpointDataplaneInfo(t *testing.T) {
+	stateDriver := &state.FakeStateDriver{}
+	stateDriver.Init(nil)
+	nwCfg := &mastercfg.CfgNetworkState{Tenant: "default", NetworkName: "blue",
+		PktTagType: "vxlan", PktTag: 1001, ExtPktTag: 5001}
+	nwCfg.ID = "blue.default"
+	nwCfg.StateDriver = stateDriver
+	epCfg := &mastercfg.CfgEndpointState{NetID: nwCfg.ID}
+	epCfg.ID = "blue.default-ep1"
+	epCfg.StateDriver = stateDriver
+	if err := nwCfg.Write(); err != nil {
+		t.Fatalf("error writing network. Err: %v", err)
+	}
+	if err := epCfg.Write(); err != nil {
+		t.Fatalf("error writing endpoint. Err: %v", err)
+	}
+
+	extIDs, _ := libovsdb.NewOvsMap(map[string]string{extIDEndpoint: epCfg.ID, extIDNetwork: nwCfg.ID})
+	d := &OvsDriver{switchDb: map[string]*OvsSwitch{
+		"vxlan": {bridgeName: vxlanBridgeName, ovsdbDriver: &OvsdbDriver{cache: map[string]map[libovsdb.UUID]libovsdb.Row{
+			interfaceTable: {
+				libovsdb.UUID{GoUuid: "1"}: {Fields: map[string]interface{}{"name": "vvport7",
+					"external_ids": *extIDs, "ofport": float64(12)}},
+			},
+		}}},
+	}}
+	d.oper.StateDriver = stateDriver
+	d.oper.LocalEpInfo = map[string]*EpInfo{epCfg.ID: {Ovsportname: "vvport7", BridgeType: "vxlan"}}
+
+	info, err := d.GetEndpointDataplaneInfo(epCfg.ID)
+	if err != nil {
+		t.Fatalf("error getting dataplane info. Err: %v", err)
+	}
+	if info.Bridge != vxlanBridgeName || info.Port != "vvport7" || info.OfPort != 12 ||
+		info.Vlan != 1001 || info.Vni != 5001 {
+		t.Fatalf("unexpected dataplane info %+v", info)
+	}
+
+	if _, err := d.GetEndpointDataplaneInfo("blue.default-ep2"); err == nil {
+		t.Fatalf("dataplane info of an endpoint of another host")
+	}
+}
+
+func TestCheckAddrFamilies(t *testing.T) {
+	for _, nw := range []mastercfg.CfgNetworkState{
+		{SubnetIP: "10.1.1.0", SubnetLen: 24},
+		{SubnetIP: "10.1.1.0", SubnetLen: 24, IPv6Subnet: "2001::", IPv6SubnetLen: 100},
+		{},
+	} {
+		if err := checkAddrFamilies(&nw); err != nil {
+			t.Fatalf("network %+v rejected. Err: %v", nw, err)
+		}
+	}
+	nw := &mastercfg.CfgNetworkState{IPv6Subnet: "2001::", IPv6SubnetLen: 100}
+	if err := checkAddrFamilies(nw); err == nil {
+		t.Fatalf("IPv6 only network accepted")
+	}
+}
+
+func TestAltNameError(t *testing.T) {
+	exitErr := fmt.Errorf("exit status 2")
+	for out, exp := range map[string]string{
+		"RTNETLINK answers: Operation not supported\n": "the kernel does not support altnames",
+		"RTNETLINK answers: File exists\n":             "the name is used by another interface",
+		"RTNETLINK answers: Invalid argument\n":        "Err: exit status 2",
+	} {
+		err := altNameError("vvport1", "web-capture", out, exitErr)
+		if !strings.Contains(err.Error(), exp) {
+			t.Fatalf("unexpected error %q for output %q", err, out)
+		}
+	}
+}
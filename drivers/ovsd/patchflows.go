@@ -0,0 +1,142 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ovsd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/ofnet"
+)
+
+const (
+	// patchCookie tags the flows of the patch ports of the vxlan bridge,
+	// the low bits hold the vlan of the network
+	patchCookie = 0x7063000000000000
+	// patchInPriority puts the flows of the frames received from the patch
+	// ports ahead of the ofnet arp and dns redirects
+	patchInPriority = ofnet.DNS_FLOW_MATCH_PRIORITY + 2
+	// patchFloodPriority puts the copy of the flooded frames to the patch
+	// ports ahead of the bum flows, below the flows of the known macs
+	patchFloodPriority = bumPriority + 1
+)
+
+// patchPort is a patch port of the vxlan bridge in the vlan of a network
+type patchPort struct {
+	ofport uint32
+	pktTag int
+}
+
+// patchFlowCookie returns the cookie of the patch port flows of the network
+// of vlan pktTag
+func patchFlowCookie(pktTag int) string {
+	return fmt.Sprintf("0x%x", patchCookie|uint64(pktTag))
+}
+
+// patchFlows returns the flows joining the patch ports ofports of vlan
+// pktTag to the ofnet datapath, which drops the frames of the ports it was
+// not told about. Frames received from a patch port are tagged with the
+// vlan and switched in the mac table, without policy lookup. Frames the
+// network floods, from its local endpoints or the vteps, are copied to its
+// patch ports and then flooded as usual; bit 1 of reg6 marks the copied
+// frames, bit 0 is taken by the flow log.
+func patchFlows(pktTag int, ofports []uint32) []string {
+	if len(ofports) == 0 {
+		return nil
+	}
+	cookie := patchFlowCookie(pktTag)
+	setVlan := fmt.Sprintf("push_vlan:0x8100,set_field:%d->vlan_vid", 0x1000|pktTag)
+
+	flows := []string{}
+	outputs := []string{}
+	for _, ofport := range ofports {
+		flows = append(flows, fmt.Sprintf("cookie=%s,table=0,priority=%d,in_port=%d,actions=%s,goto_table:%d",
+			cookie, patchInPriority, ofport, setVlan, ofnet.MAC_DEST_TBL_ID))
+		outputs = append(outputs, fmt.Sprintf("output:%d", ofport))
+	}
+	flows = append(flows, fmt.Sprintf("cookie=%s,table=%d,priority=%d,dl_vlan=%d,reg6=0/0x2,actions=load:1->NXM_NX_REG6[1],pop_vlan,%s,%s,resubmit(,%d)",
+		cookie, ofnet.MAC_DEST_TBL_ID, patchFloodPriority, pktTag, strings.Join(outputs, ","), setVlan, ofnet.MAC_DEST_TBL_ID))
+	return flows
+}
+
+// AddPatchPort joins patch port name of vlan pktTag to the datapath of the
+// vxlan bridge. Adding it again reprograms its flows.
+func (sw *OvsSwitch) AddPatchPort(name string, pktTag int) error {
+	ofport, err := sw.ovsdbDriver.GetOfpPortNo(name)
+	if err != nil {
+		return core.Errorf("no ofport for patch port %s. Err: %v", name, err)
+	}
+	if sw.patchPorts == nil {
+		sw.patchPorts = make(map[string]patchPort)
+	}
+	sw.patchPorts[name] = patchPort{ofport: ofport, pktTag: pktTag}
+	return sw.programPatchFlows(pktTag)
+}
+
+// RemovePatchPort removes the flows of patch port name, if any
+func (sw *OvsSwitch) RemovePatchPort(name string) error {
+	port, ok := sw.patchPorts[name]
+	if !ok {
+		return nil
+	}
+	delete(sw.patchPorts, name)
+	return sw.programPatchFlows(port.pktTag)
+}
+
+// programPatchFlows replaces the flows of the patch ports of vlan pktTag
+func (sw *OvsSwitch) programPatchFlows(pktTag int) error {
+	ofports := []uint32{}
+	for _, port := range sw.patchPorts {
+		if port.pktTag == pktTag {
+			ofports = append(ofports, port.ofport)
+		}
+	}
+	sort.Sort(ofportList(ofports))
+
+	log.Infof("Programming the flows of patch ports %v of vlan %d", ofports, pktTag)
+	if _, err := sw.ofctl("del-flows", "cookie="+patchFlowCookie(pktTag)+"/-1"); err != nil {
+		return err
+	}
+	for _, flow := range patchFlows(pktTag, ofports) {
+		if _, err := sw.ofctl("add-flow", flow); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type ofportList []uint32
+
+func (l ofportList) Len() int           { return len(l) }
+func (l ofportList) Less(i, j int) bool { return l[i] < l[j] }
+func (l ofportList) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+
+// checkPatchDatapath tells whether patch ports of the vxlan bridge can be
+// joined to its datapath. The ofnet arp proxy only sends the arp requests
+// of unknown addresses to the vteps, the hosts behind a patch port would
+// never be resolved.
+func (d *OvsDriver) checkPatchDatapath() error {
+	if d.fwdMode != "bridge" {
+		return core.Errorf("patch ports of vxlan networks need the bridge forwarding mode")
+	}
+	if d.arpMode != "flood" {
+		return core.Errorf("patch ports of vxlan networks need the flood arp mode")
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ovsd
+
+import (
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/contiv/netplugin/core"
+	"github.com/vishvananda/netlink"
+)
+
+// tapIntfName returns the name of the tap device of an endpoint from the
+// interface name allocated to it, e.g. tap12 for vport12
+func tapIntfName(intfName string) string {
+	return strings.Replace(intfName, "vport", "tap", 1)
+}
+
+// createTap creates persistent tap device name and sets it up. The device
+// outlives the driver, the hypervisor opens it by name.
+func createTap(name string) error {
+	log.Infof("Creating tap %s", name)
+
+	tap := &netlink.Tuntap{
+		LinkAttrs: netlink.LinkAttrs{Name: name},
+		Mode:      netlink.TUNTAP_MODE_TAP,
+	}
+	err := netlink.LinkAdd(tap)
+	core.Audit("link add tap", []string{name}, err)
+	if err != nil {
+		return core.Errorf("unable to create tap %s. Err: %v", name, err)
+	}
+
+	err = setLinkUp(name)
+	if err != nil {
+		deleteTap(name)
+		return err
+	}
+	return nil
+}
+
+// deleteTap deletes tap device name
+func deleteTap(name string) error {
+	log.Infof("Deleting tap %s", name)
+
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return err
+	}
+	err = netlink.LinkDel(link)
+	core.Audit("link del tap", []string{name}, err)
+	if err != nil {
+		log.Errorf("error deleting tap: %v", err)
+	}
+	return err
+}
@@ -0,0 +1,260 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ovsd
+
+import (
+	"regexp"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/contiv/netplugin/core"
+	"github.com/vishvananda/netlink"
+)
+
+// pooledIntfRe matches the container side of the veth pairs of endpoints
+var pooledIntfRe = regexp.MustCompile(`^vport[0-9]+$`)
+
+// vethPool keeps veth pairs of endpoint ports created ahead of the
+// attaches. The pairs are named like the ones created on attach, free holds
+// their container side.
+type vethPool struct {
+	sync.Mutex
+	size     int
+	lowWater int
+	interval time.Duration
+	free     []string
+	stopped  bool
+
+	newName func() (string, error) // allocates the name of a new pair
+	create  func(intfName string) error
+	destroy func(intfName string) error
+	reset   func(intfName string) error // readies a released pair for reuse
+
+	refillCh chan struct{}
+	stopCh   chan struct{}
+}
+
+// newVethPool returns the veth pool sized by cfg, naming its pairs with
+// newName. It is filled once started.
+func newVethPool(cfg *core.OvsDriverConfig, newName func() (string, error)) *vethPool {
+	return &vethPool{
+		size:     cfg.VethPoolSize,
+		lowWater: cfg.VethPoolLowWater,
+		interval: time.Duration(cfg.VethPoolRefill) * time.Second,
+		newName:  newName,
+		create:   createPooledVeth,
+		destroy:  destroyPooledVeth,
+		reset:    resetPooledVeth,
+		refillCh: make(chan struct{}, 1),
+		stopCh:   make(chan struct{}),
+	}
+}
+
+// start fills the pool and keeps refilling it in the background, until the
+// pool is stopped
+func (vp *vethPool) start() {
+	go func() {
+		ticker := time.NewTicker(vp.interval)
+		defer ticker.Stop()
+		for {
+			vp.refill()
+			select {
+			case <-ticker.C:
+			case <-vp.refillCh:
+			case <-vp.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+// stop stops refilling the pool and deletes its pairs
+func (vp *vethPool) stop() {
+	vp.Lock()
+	defer vp.Unlock()
+	if vp.stopped {
+		return
+	}
+	vp.stopped = true
+	close(vp.stopCh)
+	for _, intfName := range vp.free {
+		if err := vp.destroy(intfName); err != nil {
+			log.Errorf("Error deleting pooled veth pair %s. Err: %v", intfName, err)
+		}
+	}
+	vp.free = nil
+}
+
+// refill creates pairs until the pool is full. The pairs are created
+// without holding the lock, so that attaches keep drawing from the pool.
+func (vp *vethPool) refill() {
+	for {
+		vp.Lock()
+		missing := vp.size - len(vp.free)
+		stopped := vp.stopped
+		vp.Unlock()
+		if missing <= 0 || stopped {
+			return
+		}
+
+		intfName, err := vp.newName()
+		if err == nil {
+			err = vp.create(intfName)
+		}
+		if err != nil {
+			log.Errorf("Error refilling the veth pool. Err: %v", err)
+			return
+		}
+
+		vp.Lock()
+		if vp.stopped || len(vp.free) >= vp.size {
+			vp.Unlock()
+			vp.destroy(intfName)
+			return
+		}
+		vp.free = append(vp.free, intfName)
+		vp.Unlock()
+	}
+}
+
+// get takes a pair from the pool and returns its container side. It is
+// false when there is no pool or it is empty, the pair is then created on
+// attach.
+func (vp *vethPool) get() (string, bool) {
+	if vp == nil {
+		return "", false
+	}
+	vp.Lock()
+	defer vp.Unlock()
+
+	n := len(vp.free)
+	if n == 0 {
+		vp.kick()
+		return "", false
+	}
+	intfName := vp.free[n-1]
+	vp.free = vp.free[:n-1]
+	if len(vp.free) < vp.lowWater {
+		vp.kick()
+	}
+	return intfName, true
+}
+
+// put returns the pair of a detached endpoint to the pool. It is false when
+// there is no pool, it is full or the pair can not be reused, the pair must
+// then be deleted.
+func (vp *vethPool) put(intfName string) bool {
+	if vp == nil {
+		return false
+	}
+	vp.Lock()
+	defer vp.Unlock()
+
+	if vp.stopped || len(vp.free) >= vp.size {
+		return false
+	}
+	if err := vp.reset(intfName); err != nil {
+		log.Infof("Not pooling veth pair %s. Err: %v", intfName, err)
+		return false
+	}
+	vp.free = append(vp.free, intfName)
+	return true
+}
+
+// kick wakes up the refill, unless it is already pending. Called with the
+// lock held.
+func (vp *vethPool) kick() {
+	select {
+	case vp.refillCh <- struct{}{}:
+	default:
+	}
+}
+
+// createPooledVeth creates the veth pair of container side intfName
+func createPooledVeth(intfName string) error {
+	return createVethPair(intfName, getOvsPortName(intfName, false))
+}
+
+// destroyPooledVeth deletes the veth pair of container side intfName
+func destroyPooledVeth(intfName string) error {
+	return deleteVethPair(getOvsPortName(intfName, false), intfName)
+}
+
+// resetPooledVeth sets both sides of a released pair down. The container
+// side must be back in the host netns, without addresses but link local
+// ones.
+func resetPooledVeth(intfName string) error {
+	link, err := netlink.LinkByName(intfName)
+	if err != nil {
+		return core.Errorf("container side %s not in the host netns. Err: %v", intfName, err)
+	}
+	addrs, err := netlink.AddrList(link, netlink.FAMILY_ALL)
+	if err != nil {
+		return err
+	}
+	for _, addr := range addrs {
+		if !addr.IP.IsLinkLocalUnicast() {
+			return core.Errorf("%s still has address %s", intfName, addr.IPNet)
+		}
+	}
+
+	for _, name := range []string{intfName, getOvsPortName(intfName, false)} {
+		link, err := netlink.LinkByName(name)
+		if err != nil {
+			return err
+		}
+		err = netlink.LinkSetDown(link)
+		core.Audit("link set down", []string{name}, err)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reclaimVethPairs deletes the endpoint veth pairs whose ovs side is not an
+// ovs port, i.e. pairs pooled by a previous run of the driver
+func reclaimVethPairs(isOvsPort func(name string) bool) error {
+	links, err := netlink.LinkList()
+	if err != nil {
+		return err
+	}
+	for _, link := range links {
+		intfName := link.Attrs().Name
+		if _, ok := link.(*netlink.Veth); !ok || !pooledIntfRe.MatchString(intfName) {
+			continue
+		}
+		if isOvsPort(getOvsPortName(intfName, false)) {
+			continue
+		}
+		log.Infof("Reclaiming unused veth pair %s", intfName)
+		if err := destroyPooledVeth(intfName); err != nil {
+			log.Errorf("Error reclaiming veth pair %s. Err: %v", intfName, err)
+		}
+	}
+	return nil
+}
+
+// isOvsPort returns true if name is a port of either switch of the driver
+func (d *OvsDriver) isOvsPort(name string) bool {
+	for _, sw := range d.switchDb {
+		if sw.ovsdbDriver.IsPortNamePresent(name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,81 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ovsd
+
+import (
+	"fmt"
+	osexec "os/exec"
+	"strconv"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/contiv/netplugin/core"
+	"github.com/vishvananda/netlink"
+)
+
+// vrfLinkArgs returns the ip arguments creating vrf device name on routing
+// table table. The vendored netlink has no vrf links.
+func vrfLinkArgs(name string, table int) []string {
+	return []string{"link", "add", "name", name, "type", "vrf", "table", strconv.Itoa(table)}
+}
+
+// ensureVRF creates vrf device name on routing table table when it is
+// missing, and sets it up. An existing device must be a vrf, a missing one
+// needs a table to be created.
+func ensureVRF(name string, table int) error {
+	link, err := netlink.LinkByName(name)
+	if err == nil {
+		if link.Type() != "vrf" {
+			return core.Errorf("%s is a %s device, not a vrf", name, link.Type())
+		}
+		return setLinkUp(name)
+	}
+	if table == 0 {
+		return core.Errorf("vrf %s not found and no table to create it on", name)
+	}
+
+	log.Infof("Creating vrf %s on table %d", name, table)
+	ipPath, err := osexec.LookPath("ip")
+	if err != nil {
+		return err
+	}
+	args := vrfLinkArgs(name, table)
+	out, err := osexec.Command(ipPath, args...).CombinedOutput()
+	core.Audit(ipPath, args, err)
+	if err != nil {
+		return fmt.Errorf("unable to create vrf %s. Err: %v, Out: %s", name, err, out)
+	}
+	return setLinkUp(name)
+}
+
+// enslaveToVRF enslaves host interface intfName to vrf device vrf, moving
+// its routes to the table of the vrf. It is a noop when already enslaved.
+func enslaveToVRF(intfName, vrf string) error {
+	vrfLink, err := netlink.LinkByName(vrf)
+	if err != nil {
+		return core.Errorf("vrf %s not found. Err: %v", vrf, err)
+	}
+	link, err := netlink.LinkByName(intfName)
+	if err != nil {
+		return core.Errorf("interface %s not found. Err: %v", intfName, err)
+	}
+	if link.Attrs().MasterIndex == vrfLink.Attrs().Index {
+		return nil
+	}
+
+	err = netlink.LinkSetMasterByIndex(link, vrfLink.Attrs().Index)
+	core.Audit("link set master", []string{intfName, vrf}, err)
+	return err
+}
@@ -0,0 +1,128 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ovsd
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+)
+
+// vxlanGatewayNames returns the names of the bridge of vxlan gateway id and
+// of the patch port pair between it and the vxlan bridge
+func vxlanGatewayNames(id string) (string, string, string) {
+	sum := sha1.Sum([]byte(id))
+	name := hex.EncodeToString(sum[:4])
+	return "cgw" + name, "cgwp" + name + "a", "cgwp" + name + "b"
+}
+
+// CreateVxlanGateway bridges the vlan of an uplink to a vxlan network. The
+// gateway has its own bridge, in standalone mode, holding the uplink as a
+// trunk and a patch port access to the vlan. Its peer on the vxlan bridge
+// is an access port of the local vlan of the network, joined to the ofnet
+// datapath of the vxlan bridge which maps the vlan to the vni of the
+// network. Parts already present are kept, the flows of the patch port are
+// programmed again.
+func (d *OvsDriver) CreateVxlanGateway(gw *mastercfg.VxlanGatewayState) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	cfgNw := mastercfg.CfgNetworkState{}
+	cfgNw.StateDriver = d.oper.StateDriver
+	if err := cfgNw.Read(gw.NetID); err != nil {
+		return err
+	}
+	if cfgNw.PktTagType != "vxlan" {
+		return core.Errorf("network %s is not a vxlan network", gw.NetID)
+	}
+	if err := d.checkPatchDatapath(); err != nil {
+		return err
+	}
+
+	bridgeName, gwPort, nwPort := vxlanGatewayNames(gw.ID)
+	br := d.gateways[gw.ID]
+	if br == nil {
+		var err error
+		br, err = NewOvsdbDriver(&d.ovsdbCfg, bridgeName, "", 0)
+		if err != nil {
+			log.Errorf("Error creating bridge %s of vxlan gateway %s. Err: %v", bridgeName, gw.ID, err)
+			return err
+		}
+		d.gateways[gw.ID] = br
+	}
+
+	log.Infof("Bridging vlan %d of %s to network %s on %s", gw.Vlan, gw.Uplink, gw.NetID, bridgeName)
+	if !br.IsPortNamePresent(gw.Uplink) {
+		if err := br.CreatePort(gw.Uplink, "", gw.ID, nil, 0, 0, 0); err != nil {
+			log.Errorf("Error adding uplink %s to %s. Err: %v", gw.Uplink, bridgeName, err)
+			return err
+		}
+	}
+	if err := setLinkUp(gw.Uplink); err != nil {
+		return err
+	}
+	if !br.IsPortNamePresent(gwPort) {
+		if err := br.CreatePatchPort(gwPort, nwPort, gw.Vlan); err != nil {
+			return err
+		}
+	}
+	sw := d.switchDb["vxlan"]
+	if !sw.ovsdbDriver.IsPortNamePresent(nwPort) {
+		if err := sw.ovsdbDriver.CreatePatchPort(nwPort, gwPort, cfgNw.PktTag); err != nil {
+			return err
+		}
+	}
+	if err := sw.AddPatchPort(nwPort, cfgNw.PktTag); err != nil {
+		log.Errorf("Error programming patch port %s of vxlan gateway %s. Err: %v", nwPort, gw.ID, err)
+		return err
+	}
+	return nil
+}
+
+// DeleteVxlanGateway removes the patch port of a vxlan gateway from the
+// vxlan bridge and the bridge of the gateway
+func (d *OvsDriver) DeleteVxlanGateway(gw *mastercfg.VxlanGatewayState) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	bridgeName, _, nwPort := vxlanGatewayNames(gw.ID)
+	sw := d.switchDb["vxlan"]
+	if err := sw.RemovePatchPort(nwPort); err != nil {
+		log.Errorf("Error removing the flows of patch port %s. Err: %v", nwPort, err)
+		return err
+	}
+	if sw.ovsdbDriver.IsPortNamePresent(nwPort) {
+		if err := sw.ovsdbDriver.DeletePort(nwPort); err != nil {
+			log.Errorf("Error deleting patch port %s. Err: %v", nwPort, err)
+			return err
+		}
+	}
+
+	br := d.gateways[gw.ID]
+	if br == nil {
+		// the bridge of a gateway created before a restart
+		var err error
+		br, err = NewOvsdbDriver(&d.ovsdbCfg, bridgeName, "", 0)
+		if err != nil {
+			return err
+		}
+	}
+	delete(d.gateways, gw.ID)
+	return br.Delete()
+}
@@ -66,6 +66,9 @@ type VppDriver struct {
 func (d *VppDriver) Init(info *core.InstanceInfo) error {
 	log.Infof("Initializing vppdriver")
 
+	if info != nil {
+		d.oper.StateDriver = info.StateDriver
+	}
 	return nil
 }
 
@@ -40,10 +40,11 @@ type CNIPodAttr struct {
 
 // RspAddPod contains the response to the AddPod
 type RspAddPod struct {
-	Result      uint   `json:"result,omitempty"`
-	EndpointID  string `json:"endpointid,omitempty"`
-	IPAddress   string `json:"ipaddress,omitempty"`
-	IPv6Address string `json:"ipv6address,omitempty"`
-	ErrMsg      string `json:"errmsg,omitempty"`
-	ErrInfo     string `json:"errinfo,omitempty"`
+	Result      uint     `json:"result,omitempty"`
+	EndpointID  string   `json:"endpointid,omitempty"`
+	IPAddress   string   `json:"ipaddress,omitempty"`
+	IPv6Address string   `json:"ipv6address,omitempty"`
+	DNSServers  []string `json:"dnsservers,omitempty"`
+	ErrMsg      string   `json:"errmsg,omitempty"`
+	ErrInfo     string   `json:"errinfo,omitempty"`
 }
@@ -127,6 +127,8 @@ func addPodToContiv(nc *clients.NWClient, pInfo *cniapi.CNIPodAttr) {
 		Address: net.IPNet{IP: ip4Net.IP, Mask: ip4Net.Mask},
 	})
 
+	out.DNS.Nameservers = result.DNSServers
+
 	if result.IPv6Address != "" {
 		ip6Net, err := ip.ParseCIDR(result.IPv6Address)
 		if err != nil {
@@ -0,0 +1,191 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k8splugin
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	osexec "os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// dhcpStateDir holds the lease and pid files of the dhcp clients
+const dhcpStateDir = "/var/run/contiv/dhcp"
+
+// dhcpLease is the part of a dhcp lease applied to the pod interface
+type dhcpLease struct {
+	Address    string // cidr
+	Gateway    string
+	DNSServers []string
+}
+
+func dhcpFiles(epID string) (leaseFile, pidFile string) {
+	return filepath.Join(dhcpStateDir, epID+".leases"),
+		filepath.Join(dhcpStateDir, epID+".pid")
+}
+
+// acquireDhcpLease runs dhclient in the netns of pid to get a lease for
+// ifname. dhclient only negotiates the lease, the address and gateway are
+// set by the caller. When renew is set dhclient keeps running in the
+// background to renew the lease.
+func acquireDhcpLease(pid int, ifname, epID string, timeout time.Duration, renew bool) (*dhcpLease, error) {
+	nsenterPath, err := osexec.LookPath("nsenter")
+	if err != nil {
+		return nil, err
+	}
+	dhclientPath, err := osexec.LookPath("dhclient")
+	if err != nil {
+		return nil, err
+	}
+	truePath, err := osexec.LookPath("true")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(dhcpStateDir, 0755); err != nil {
+		return nil, err
+	}
+	leaseFile, pidFile := dhcpFiles(epID)
+
+	// -1 makes dhclient give up instead of retrying forever, it forks
+	// into the background once a lease is bound
+	nsPid := fmt.Sprintf("%d", pid)
+	cmd := osexec.Command(nsenterPath, "-t", nsPid, "-n", "-F", "--", dhclientPath,
+		"-1", "-sf", truePath, "-lf", leaseFile, "-pf", pidFile, ifname)
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case err = <-done:
+	case <-time.After(timeout):
+		cmd.Process.Kill()
+		<-done
+		err = fmt.Errorf("no dhcp lease for %s after %v", ifname, timeout)
+	}
+	if err != nil {
+		log.Errorf("Error getting dhcp lease for %s. Err: %v", ifname, err)
+		stopDhcpClient(pidFile)
+		return nil, err
+	}
+
+	data, err := ioutil.ReadFile(leaseFile)
+	if err != nil {
+		stopDhcpClient(pidFile)
+		return nil, err
+	}
+	lease, err := parseDhcpLease(string(data))
+	if err != nil {
+		stopDhcpClient(pidFile)
+		return nil, err
+	}
+
+	if !renew {
+		stopDhcpClient(pidFile)
+	}
+
+	log.Infof("Got dhcp lease %+v for %s", lease, ifname)
+	return lease, nil
+}
+
+// releaseDhcpLease releases the lease of an endpoint and stops its dhcp
+// client. The release is only sent when the pod netns still exists.
+func releaseDhcpLease(pid int, ifname, epID string) {
+	leaseFile, pidFile := dhcpFiles(epID)
+	defer os.Remove(leaseFile)
+	defer os.Remove(pidFile)
+
+	if pid > 0 {
+		nsenterPath, err := osexec.LookPath("nsenter")
+		if err == nil {
+			dhclientPath, err := osexec.LookPath("dhclient")
+			if err == nil {
+				truePath, _ := osexec.LookPath("true")
+				out, err := osexec.Command(nsenterPath, "-t", fmt.Sprintf("%d", pid), "-n", "-F",
+					"--", dhclientPath, "-r", "-sf", truePath, "-lf", leaseFile, "-pf", pidFile,
+					ifname).CombinedOutput()
+				if err == nil {
+					return
+				}
+				log.Errorf("Error releasing dhcp lease for %s. Err: %v - %s", ifname, err, out)
+			}
+		}
+	}
+
+	stopDhcpClient(pidFile)
+}
+
+// stopDhcpClient kills a background dhcp client
+func stopDhcpClient(pidFile string) {
+	data, err := ioutil.ReadFile(pidFile)
+	if err != nil {
+		return
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid <= 0 {
+		return
+	}
+	if err := syscall.Kill(pid, syscall.SIGTERM); err != nil && err != syscall.ESRCH {
+		log.Errorf("Error stopping dhcp client %d. Err: %v", pid, err)
+	}
+}
+
+// parseDhcpLease reads the last lease of a dhclient lease file
+func parseDhcpLease(data string) (*dhcpLease, error) {
+	start := strings.LastIndex(data, "lease {")
+	if start < 0 {
+		return nil, fmt.Errorf("no lease found")
+	}
+
+	var addr, mask string
+	lease := &dhcpLease{}
+	for _, line := range strings.Split(data[start:], "\n") {
+		fields := strings.Fields(strings.TrimSuffix(strings.TrimSpace(line), ";"))
+		switch {
+		case len(fields) == 2 && fields[0] == "fixed-address":
+			addr = fields[1]
+		case len(fields) == 3 && fields[0] == "option" && fields[1] == "subnet-mask":
+			mask = fields[2]
+		case len(fields) == 3 && fields[0] == "option" && fields[1] == "routers":
+			lease.Gateway = strings.Split(fields[2], ",")[0]
+		case len(fields) == 3 && fields[0] == "option" && fields[1] == "domain-name-servers":
+			lease.DNSServers = strings.Split(fields[2], ",")
+		}
+	}
+
+	ip := net.ParseIP(addr).To4()
+	if ip == nil {
+		return nil, fmt.Errorf("invalid lease address %q", addr)
+	}
+	maskIP := net.ParseIP(mask).To4()
+	if maskIP == nil {
+		return nil, fmt.Errorf("invalid lease subnet mask %q", mask)
+	}
+	ones, _ := net.IPv4Mask(maskIP[0], maskIP[1], maskIP[2], maskIP[3]).Size()
+	lease.Address = fmt.Sprintf("%s/%d", ip, ones)
+
+	return lease, nil
+}
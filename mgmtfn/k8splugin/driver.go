@@ -20,37 +20,95 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"os"
 	osexec "os/exec"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/contiv/netplugin/mgmtfn/k8splugin/cniapi"
 	"github.com/contiv/netplugin/netmaster/intent"
 	"github.com/contiv/netplugin/netmaster/master"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
 	"github.com/contiv/netplugin/netplugin/cluster"
 	"github.com/contiv/netplugin/utils"
 	"github.com/contiv/netplugin/utils/netutils"
 	"github.com/vishvananda/netlink"
 )
 
+const (
+	netnsRetryInitDelay = 50 * time.Millisecond
+	netnsRetryMaxDelay  = time.Second
+)
+
+// netnsNotReadyError indicates that the network namespace of the pod does
+// not exist yet, and the operation can be retried
+type netnsNotReadyError struct {
+	pid int
+	err error
+}
+
+func (e *netnsNotReadyError) Error() string {
+	return fmt.Sprintf("netns of pid %d is not ready: %v", e.pid, e.err)
+}
+
+// checkNetns verifies that the network namespace of pid exists
+func checkNetns(pid int) error {
+	_, err := os.Stat(fmt.Sprintf("/proc/%d/ns/net", pid))
+	if os.IsNotExist(err) {
+		return &netnsNotReadyError{pid: pid, err: err}
+	}
+	return err
+}
+
+// retryNetnsOp runs op in the netns of pid, retrying with exponential
+// backoff while the netns is not ready. Other errors are returned
+// immediately, as is the last error once timeout expires.
+func retryNetnsOp(pid int, timeout time.Duration, op func() error) error {
+	delay := netnsRetryInitDelay
+	deadline := time.Now().Add(timeout)
+
+	for {
+		err := checkNetns(pid)
+		if err == nil {
+			err = op()
+		}
+
+		if _, retry := err.(*netnsNotReadyError); !retry || time.Now().Add(delay).After(deadline) {
+			return err
+		}
+
+		log.Infof("%v, retrying in %v", err, delay)
+		time.Sleep(delay)
+		delay *= 2
+		if delay > netnsRetryMaxDelay {
+			delay = netnsRetryMaxDelay
+		}
+	}
+}
+
 // epSpec contains the spec of the Endpoint to be created
 type epSpec struct {
-	Tenant     string `json:"tenant,omitempty"`
-	Network    string `json:"network,omitempty"`
-	Group      string `json:"group,omitempty"`
-	EndpointID string `json:"endpointid,omitempty"`
-	Name       string `json:"name,omitempty"`
+	Tenant       string   `json:"tenant,omitempty"`
+	Network      string   `json:"network,omitempty"`
+	Group        string   `json:"group,omitempty"`
+	EndpointID   string   `json:"endpointid,omitempty"`
+	Name         string   `json:"name,omitempty"`
+	HostIntf     string   `json:"hostintf,omitempty"`
+	SecondaryIPs []string `json:"secondaryips,omitempty"`
 }
 
 // epAttr contains the assigned attributes of the created ep
 type epAttr struct {
-	IPAddress   string
-	PortName    string
-	Gateway     string
-	IPv6Address string
-	IPv6Gateway string
+	IPAddress    string
+	PortName     string
+	Gateway      string
+	IPv6Address  string
+	IPv6Gateway  string
+	SecondaryIPs []string
+	DHCP         bool // addresses are leased from a dhcp server on attach
 }
 
 // epCleanUp deletes the ep from netplugin and netmaster
@@ -102,9 +160,11 @@ func createEP(req *epSpec) (*epAttr, error) {
 		EndpointID:   req.EndpointID,
 		EPCommonName: req.Name,
 		ConfigEP: intent.ConfigEP{
-			Container:   req.EndpointID,
-			Host:        pluginHost,
-			ServiceName: req.Group,
+			Container:    req.EndpointID,
+			Host:         pluginHost,
+			ServiceName:  req.Group,
+			HostIntfName: req.HostIntf,
+			SecondaryIPs: req.SecondaryIPs,
 		},
 	}
 
@@ -142,6 +202,10 @@ func createEP(req *epSpec) (*epAttr, error) {
 
 	epResponse := epAttr{}
 	epResponse.PortName = ep.PortName
+	if nw.IPAM == mastercfg.IPAMDhcp {
+		epResponse.DHCP = true
+		return &epResponse, nil
+	}
 	epResponse.IPAddress = ep.IPAddress + "/" + strconv.Itoa(int(nw.SubnetLen))
 	epResponse.Gateway = nw.Gateway
 
@@ -150,6 +214,15 @@ func createEP(req *epSpec) (*epAttr, error) {
 		epResponse.IPv6Gateway = nw.IPv6Gateway
 	}
 
+	for _, addr := range ep.SecondaryIPs {
+		subnetLen := nw.SubnetLen
+		if netutils.IsIPv6(addr) {
+			subnetLen = nw.IPv6SubnetLen
+		}
+		epResponse.SecondaryIPs = append(epResponse.SecondaryIPs,
+			addr+"/"+strconv.Itoa(int(subnetLen)))
+	}
+
 	return &epResponse, nil
 }
 
@@ -228,6 +301,9 @@ func setIfAttrs(pid int, ifname, cidr, cidr6, newname string) error {
 	if err != nil {
 		log.Errorf("unable to move interface %s to pid %d. Error: %s",
 			ifname, pid, err)
+		if err == syscall.ESRCH {
+			return &netnsNotReadyError{pid: pid, err: err}
+		}
 		return err
 	}
 
@@ -242,16 +318,18 @@ func setIfAttrs(pid int, ifname, cidr, cidr6, newname string) error {
 	}
 	log.Infof("Output from rename: %v", rename)
 
-	// set the ip address
-	assignIP, err := osexec.Command(nsenterPath, "-t", nsPid, "-n", "-F", "--", ipPath,
-		"address", "add", cidr, "dev", newname).CombinedOutput()
+	// set the ip address, dhcp endpoints get theirs once the link is up
+	if cidr != "" {
+		assignIP, err := osexec.Command(nsenterPath, "-t", nsPid, "-n", "-F", "--", ipPath,
+			"address", "add", cidr, "dev", newname).CombinedOutput()
 
-	if err != nil {
-		log.Errorf("unable to assign ip %s to %s. Error: %s",
-			cidr, newname, err)
-		return nil
+		if err != nil {
+			log.Errorf("unable to assign ip %s to %s. Error: %s",
+				cidr, newname, err)
+			return nil
+		}
+		log.Infof("Output from ip assign: %v", assignIP)
 	}
-	log.Infof("Output from ip assign: %v", assignIP)
 
 	if cidr6 != "" {
 		out, err := osexec.Command(nsenterPath, "-t", nsPid, "-n", "-F", "--", ipPath,
@@ -278,6 +356,36 @@ func setIfAttrs(pid int, ifname, cidr, cidr6, newname string) error {
 
 }
 
+// addSecondaryAddrs assigns additional addresses, the secondary ones or a
+// dhcp lease, to the container interface
+func addSecondaryAddrs(pid int, cidrs []string, ifname string) error {
+	if len(cidrs) == 0 {
+		return nil
+	}
+
+	nsenterPath, err := osexec.LookPath("nsenter")
+	if err != nil {
+		return err
+	}
+	ipPath, err := osexec.LookPath("ip")
+	if err != nil {
+		return err
+	}
+
+	nsPid := fmt.Sprintf("%d", pid)
+	for _, cidr := range cidrs {
+		out, err := osexec.Command(nsenterPath, "-t", nsPid, "-n", "-F", "--", ipPath,
+			"address", "add", cidr, "dev", ifname).CombinedOutput()
+		if err != nil {
+			log.Errorf("unable to assign secondary ip %s to %s. Error: %s - %s",
+				cidr, ifname, err, out)
+			return err
+		}
+	}
+
+	return nil
+}
+
 func addStaticRoute(pid int, subnet, intfName string) error {
 	nsenterPath, err := osexec.LookPath("nsenter")
 	if err != nil {
@@ -350,12 +458,22 @@ func getEPSpec(pInfo *cniapi.CNIPodAttr) (*epSpec, error) {
 		"io.contiv.network")
 	tenant, _ := kubeAPIClient.GetPodLabel(pInfo.K8sNameSpace, pInfo.Name,
 		"io.contiv.tenant")
+	hostIntf, _ := kubeAPIClient.GetPodLabel(pInfo.K8sNameSpace, pInfo.Name,
+		"io.contiv.host-intf")
+	secondaryIPs, _ := kubeAPIClient.GetPodLabel(pInfo.K8sNameSpace, pInfo.Name,
+		"io.contiv.secondary-ips")
 	log.Infof("labels is %s/%s/%s for pod %s\n", tenant, netw, epg, pInfo.Name)
 	resp.Tenant = tenant
 	resp.Network = netw
 	resp.Group = epg
 	resp.EndpointID = pInfo.InfraContainerID
 	resp.Name = pInfo.Name
+	resp.HostIntf = hostIntf
+	for _, addr := range strings.Split(secondaryIPs, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			resp.SecondaryIPs = append(resp.SecondaryIPs, addr)
+		}
+	}
 
 	return &resp, nil
 }
@@ -417,14 +535,52 @@ func addPod(w http.ResponseWriter, r *http.Request, vars map[string]string) (int
 		return resp, epErr
 	}
 
-	// Set interface attributes for the new port
-	epErr = setIfAttrs(pid, ep.PortName, ep.IPAddress, ep.IPv6Address, pInfo.IntfName)
+	// Set interface attributes for the new port, the netns may not be
+	// ready yet if the attach races with the container start
+	retryTimeout := time.Duration(contivK8Config.NetnsRetryTimeout) * time.Second
+	epErr = retryNetnsOp(pid, retryTimeout, func() error {
+		return setIfAttrs(pid, ep.PortName, ep.IPAddress, ep.IPv6Address, pInfo.IntfName)
+	})
 	if epErr != nil {
 		log.Errorf("Error setting interface attributes. Err: %v", epErr)
 		setErrorResp(&resp, "Error setting interface attributes", epErr)
 		return resp, epErr
 	}
 
+	if ep.DHCP {
+		epID := epReq.Network + "." + epReq.Tenant + "-" + epReq.EndpointID
+		dhcpTimeout := time.Duration(contivK8Config.DhcpTimeout) * time.Second
+		lease, err := acquireDhcpLease(pid, pInfo.IntfName, epID, dhcpTimeout, contivK8Config.DhcpRenew)
+		if err != nil {
+			epErr = err
+			log.Errorf("Error getting dhcp lease. Err: %v", epErr)
+			setErrorResp(&resp, "Error getting dhcp lease", epErr)
+			return resp, epErr
+		}
+		defer func() {
+			if epErr != nil {
+				releaseDhcpLease(pid, pInfo.IntfName, epID)
+			}
+		}()
+
+		epErr = addSecondaryAddrs(pid, []string{lease.Address}, pInfo.IntfName)
+		if epErr != nil {
+			log.Errorf("Error setting dhcp address. Err: %v", epErr)
+			setErrorResp(&resp, "Error setting dhcp address", epErr)
+			return resp, epErr
+		}
+		ep.IPAddress = lease.Address
+		ep.Gateway = lease.Gateway
+		resp.DNSServers = lease.DNSServers
+	}
+
+	epErr = addSecondaryAddrs(pid, ep.SecondaryIPs, pInfo.IntfName)
+	if epErr != nil {
+		log.Errorf("Error setting secondary addresses. Err: %v", epErr)
+		setErrorResp(&resp, "Error setting secondary addresses", epErr)
+		return resp, epErr
+	}
+
 	//TODO: Host access needs to be enabled for IPv6
 	// if Gateway is not specified on the nw, use the host gateway
 	gwIntf := pInfo.IntfName
@@ -499,6 +655,13 @@ func deletePod(w http.ResponseWriter, r *http.Request, vars map[string]string) (
 		return resp, err
 	}
 
+	netID := epReq.Network + "." + epReq.Tenant
+	if nw, err := utils.GetNetwork(netID); err == nil && nw.IPAM == mastercfg.IPAMDhcp {
+		// the netns may already be gone, the lease then just expires
+		pid, _ := nsToPID(pInfo.NwNameSpace)
+		releaseDhcpLease(pid, pInfo.IntfName, netID+"-"+epReq.EndpointID)
+	}
+
 	netPlugin.DeleteHostAccPort(epReq.EndpointID)
 	if err = epCleanUp(epReq); err != nil {
 		log.Errorf("failed to delete pod, error: %s", err)
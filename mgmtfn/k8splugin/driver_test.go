@@ -20,6 +20,8 @@ import (
 	"net"
 	"os/exec"
 	"runtime"
+	"strings"
+	"time"
 
 	. "github.com/contiv/check"
 	"github.com/vishvananda/netlink"
@@ -215,3 +217,77 @@ func (s *NetSetup) TestNetSetup(c *C) {
 		c.Errorf("expected interface to be up, but it's down")
 	}
 }
+
+func (s *NetSetup) TestRetryNetnsOp(c *C) {
+	// a missing netns is retried until the timeout expires
+	attempts := 0
+	err := retryNetnsOp(1<<22, 300*time.Millisecond, func() error {
+		attempts++
+		return nil
+	})
+	if _, ok := err.(*netnsNotReadyError); !ok {
+		c.Fatalf("expected netns not ready error, got: %v", err)
+	}
+	if attempts != 0 {
+		c.Fatalf("operation ran without a netns")
+	}
+
+	// netns not ready errors from the operation are retried
+	attempts = 0
+	err = retryNetnsOp(s.pid, 5*time.Second, func() error {
+		attempts++
+		if attempts < 3 {
+			return &netnsNotReadyError{pid: s.pid, err: fmt.Errorf("not ready")}
+		}
+		return nil
+	})
+	if err != nil || attempts != 3 {
+		c.Fatalf("retry failed after %d attempts: %v", attempts, err)
+	}
+
+	// genuine errors are not retried
+	attempts = 0
+	err = retryNetnsOp(s.pid, 5*time.Second, func() error {
+		attempts++
+		return fmt.Errorf("failed")
+	})
+	if err == nil || attempts != 1 {
+		c.Fatalf("genuine error was retried %d times: %v", attempts, err)
+	}
+}
+
+func (s *NetSetup) TestParseDhcpLease(c *C) {
+	leases := `lease {
+  interface "eth0";
+  fixed-address 10.1.1.5;
+  option subnet-mask 255.255.255.0;
+  option routers 10.1.1.1;
+  option domain-name-servers 10.1.1.2,10.1.1.3;
+}
+lease {
+  interface "eth0";
+  fixed-address 10.1.1.6;
+  option subnet-mask 255.255.0.0;
+  option routers 10.1.1.254;
+}
+`
+	lease, err := parseDhcpLease(leases)
+	if err != nil {
+		c.Fatalf("error parsing lease: %v", err)
+	}
+	if lease.Address != "10.1.1.6/16" || lease.Gateway != "10.1.1.254" || len(lease.DNSServers) != 0 {
+		c.Fatalf("unexpected lease %+v", lease)
+	}
+
+	lease, err = parseDhcpLease(leases[:strings.LastIndex(leases, "lease {")])
+	if err != nil {
+		c.Fatalf("error parsing lease: %v", err)
+	}
+	if lease.Address != "10.1.1.5/24" || len(lease.DNSServers) != 2 {
+		c.Fatalf("unexpected lease %+v", lease)
+	}
+
+	if _, err := parseDhcpLease("lease {\n}\n"); err == nil {
+		c.Fatalf("parsing a lease without address succeeded")
+	}
+}
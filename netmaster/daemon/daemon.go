@@ -26,6 +26,7 @@ import (
 	"sync"
 	"time"
 
+	contivModel "github.com/contiv/netplugin/contivmodel"
 	"github.com/contiv/netplugin/core"
 	"github.com/contiv/netplugin/netmaster/intent"
 	"github.com/contiv/netplugin/netmaster/master"
@@ -51,10 +52,14 @@ type MasterDaemon struct {
 	ControlURL         string // URL where netmaster listens for ctrl pkts
 	ClusterStoreDriver string // state store driver name
 	ClusterStoreURL    string // state store endpoint
+	ClusterStoreFormat string // format of the state records written
 	ClusterMode        string // cluster scheduler used docker/kubernetes/mesos etc
 	NetworkMode        string // network mode (vlan or vxlan)
 	NetForwardMode     string // forwarding mode (bridge or routing)
 	NetInfraType       string // infra type (aci or default)
+	DisableStickyMac   bool   // don't reuse macs of deleted endpoints
+	NetworkDeleteGrace int    // seconds a deleted network with endpoints is kept for them to drain
+	EndpointRetention  int    // seconds a deleted endpoint is kept in the recycle bin
 
 	// Private state
 	currState        string                          // Current state of the daemon
@@ -77,9 +82,13 @@ func (d *MasterDaemon) Init() {
 	if err != nil {
 		log.Fatalf("Failed to set cluster-mode %q. Error: %s", d.ClusterMode, err)
 	}
+	master.SetStickyMac(!d.DisableStickyMac)
+	master.SetNetworkDeleteGrace(time.Duration(d.NetworkDeleteGrace) * time.Second)
+	master.SetEndpointRetention(time.Duration(d.EndpointRetention) * time.Second)
 
 	// initialize state driver
-	d.stateDriver, err = utils.NewStateDriver(d.ClusterStoreDriver, &core.InstanceInfo{DbURL: d.ClusterStoreURL})
+	d.stateDriver, err = utils.NewStateDriver(d.ClusterStoreDriver,
+		&core.InstanceInfo{DbURL: d.ClusterStoreURL, StateFormat: d.ClusterStoreFormat})
 	if err != nil {
 		log.Fatalf("Failed to init state-store: driver %q, URLs %q. Error: %s", d.ClusterStoreDriver, d.ClusterStoreURL, err)
 	}
@@ -267,6 +276,8 @@ func (d *MasterDaemon) registerRoutes(router *mux.Router) {
 	s.HandleFunc("/plugin/createEndpoint", utils.MakeHTTPHandler(master.CreateEndpointHandler))
 	s.HandleFunc("/plugin/deleteEndpoint", utils.MakeHTTPHandler(master.DeleteEndpointHandler))
 	s.HandleFunc("/plugin/updateEndpoint", utils.MakeHTTPHandler(master.UpdateEndpointHandler))
+	s.HandleFunc("/overlayKey/rotate", utils.MakeHTTPHandler(master.RotateOverlayKeyHandler))
+	s.HandleFunc("/network/{id}/scheduleDelete", utils.MakeHTTPHandler(master.ScheduleDeleteNetworkHandler))
 
 	s = router.Methods("Get").Subrouter()
 
@@ -311,6 +322,8 @@ func (d *MasterDaemon) registerRoutes(router *mux.Router) {
 	})
 
 	s = router.Methods("Delete").Subrouter()
+	s.HandleFunc("/network/{id}/force", utils.MakeHTTPHandler(master.ForceDeleteNetworkHandler))
+	s.HandleFunc("/network/{id}/scheduleDelete", utils.MakeHTTPHandler(master.CancelScheduledDeleteHandler))
 	s.HandleFunc("/debug/epcleanup/tenant/{tenant}/{category}/{id}", func(w http.ResponseWriter, r *http.Request) {
 		errStr := ""
 		var epCfgs []core.State
@@ -398,7 +411,7 @@ func (d *MasterDaemon) registerRoutes(router *mux.Router) {
 
 func getEpName(networkName string, ep *intent.ConfigEP) string {
 	if ep.Container != "" {
-		return networkName + "-" + ep.Container
+		return master.ContainerEpID(networkName, ep.Container)
 	}
 
 	return ep.Host + "-native-intf"
@@ -517,8 +530,71 @@ func (d *MasterDaemon) becomeFollower() {
 	go d.runFollower()
 }
 
+// networkReapInterval is how often the leader looks for networks whose
+// delete can complete
+const networkReapInterval = 5 * time.Second
+
+// withReconcileLock runs reconcile holding the reconcile lock, so that a
+// master that still believes it leads does not reconcile the state along
+// with the new leader. The round is skipped when the lock can not be taken
+// in time.
+func (d *MasterDaemon) withReconcileLock(reconcile func()) {
+	unlock, err := d.stateDriver.Lock(mastercfg.ReconcileLockKey)
+	if err != nil {
+		log.Warnf("Skipping a reconcile round, the reconcile lock is not available. Err: %v", err)
+		return
+	}
+	defer unlock()
+	reconcile()
+}
+
+// networkReaper runs, on the leader, the scheduled network deletes that are
+// due and removes the networks being deleted whose endpoints are gone or
+// whose grace period elapsed
+func (d *MasterDaemon) networkReaper() {
+	for now := range time.Tick(networkReapInterval) {
+		if d.currState != "leader" {
+			continue
+		}
+		d.withReconcileLock(func() {
+			if err := master.RunScheduledDeletes(d.stateDriver, now, deleteNetworkObject); err != nil {
+				log.Errorf("Error running the scheduled network deletes. Err: %v", err)
+			}
+			if err := master.ReapDeletingNetworks(d.stateDriver); err != nil {
+				log.Errorf("Error removing deleted networks. Err: %v", err)
+			}
+		})
+	}
+}
+
+// deleteNetworkObject deletes a network the way the network REST api does
+func deleteNetworkObject(nwCfg *mastercfg.CfgNetworkState) error {
+	return contivModel.DeleteNetwork(nwCfg.Tenant + ":" + nwCfg.NetworkName)
+}
+
+// endpointReaper removes for good, on the leader, the endpoints whose
+// retention in the recycle bin elapsed and the endpoints whose lease expired
+func (d *MasterDaemon) endpointReaper() {
+	for range time.Tick(networkReapInterval) {
+		if d.currState != "leader" {
+			continue
+		}
+		d.withReconcileLock(func() {
+			if err := master.ReapRecycledEndpoints(d.stateDriver); err != nil {
+				log.Errorf("Error removing recycled endpoints. Err: %v", err)
+			}
+			if err := master.ReapExpiredEndpoints(d.stateDriver); err != nil {
+				log.Errorf("Error removing expired endpoints. Err: %v", err)
+			}
+		})
+	}
+}
+
 // InitServices init watch services
 func (d *MasterDaemon) InitServices() {
+	go d.networkReaper()
+	go d.endpointReaper()
+
 	if d.ClusterMode == "kubernetes" {
 		isLeader := func() bool {
 			return d.currState == "leader"
@@ -304,6 +304,32 @@ func (gc *Cfg) AllocVLAN(reqVlan uint) (uint, error) {
 	return vlan.(uint), err
 }
 
+// CheckVLAN verifies that a VLAN lies within the configured VLAN range.
+func (gc *Cfg) CheckVLAN(vlan uint) error {
+	return checkTagInRanges(vlan, gc.Auto.VLANs, "vlan")
+}
+
+// CheckVXLAN verifies that a VXLAN lies within the configured VXLAN range.
+func (gc *Cfg) CheckVXLAN(vxlan uint) error {
+	return checkTagInRanges(vxlan, gc.Auto.VXLANs, "vxlan")
+}
+
+func checkTagInRanges(tag uint, tagRanges, tagType string) error {
+	ranges, err := netutils.ParseTagRanges(tagRanges, tagType)
+	if err != nil {
+		return err
+	}
+
+	for _, r := range ranges {
+		if int(tag) >= r.Min && int(tag) <= r.Max {
+			return nil
+		}
+	}
+
+	return core.Errorf("requested %s not available - %s %d is outside the allowed range %s",
+		tagType, tagType, tag, tagRanges)
+}
+
 // FreeVLAN releases a VLAN for a given ID.
 func (gc *Cfg) FreeVLAN(vlan uint) error {
 	tempRm, err := resources.GetStateResourceManager()
@@ -330,3 +330,40 @@ func TestAutoDefaultNetwork(t *testing.T) {
 		t.Fatalf("Error: '%s' could not unassign default network", err)
 	}
 }
+
+func TestCheckVLAN(t *testing.T) {
+	gc := &Cfg{Auto: AutoParams{VLANs: "1-10,100-200"}}
+
+	testData := []struct {
+		vlan       uint
+		shouldFail bool
+	}{
+		{1, false},
+		{10, false},
+		{150, false},
+		{11, true},
+		{201, true},
+		{4000, true},
+	}
+
+	for _, d := range testData {
+		err := gc.CheckVLAN(d.vlan)
+		if (err != nil) != d.shouldFail {
+			t.Fatalf("vlan %d: unexpected result %v", d.vlan, err)
+		}
+	}
+}
+
+func TestCheckVXLAN(t *testing.T) {
+	gc := &Cfg{Auto: AutoParams{VXLANs: "10000-20000"}}
+
+	if err := gc.CheckVXLAN(15000); err != nil {
+		t.Fatalf("vxlan within range was rejected. Error: %s", err)
+	}
+	if err := gc.CheckVXLAN(9999); err == nil {
+		t.Fatalf("vxlan outside range was accepted")
+	}
+	if err := gc.CheckVXLAN(20001); err == nil {
+		t.Fatalf("vxlan outside range was accepted")
+	}
+}
@@ -29,11 +29,79 @@ type ConfigGlobal struct {
 
 // ConfigEP encapulsates an endpoint: a leg into a network
 type ConfigEP struct {
-	Container   string
-	Host        string
-	IPAddress   string
-	IPv6Address string
-	ServiceName string
+	Container    string
+	Host         string
+	IPAddress    string
+	IPv6Address  string
+	ServiceName  string
+	HostIntfName string   // alternate name for the host side of the veth
+	SecondaryIPs []string // additional addresses for the endpoint interface
+	DSCP         int      // DSCP value to mark egress traffic with, 0 to disable
+	CoS          int      // 802.1p priority to mark egress traffic with, 0 to disable
+	MacAddress   string   // requested mac, derived from the ip address when empty
+	MTU          int      // overrides the mtu of the network when set
+	Labels       map[string]string
+	Driver       string // endpoint driver of the plugin, its network driver when empty
+	ForceIPReuse bool   // allocate addresses still in the quarantine of the network
+	AllocRange   string // allocation range of the network the IPv4 address comes from
+	LeaseTTL     int    // seconds the endpoint lives without a keepalive, 0 never expires
+	HostEndpoint bool   // interface left in the host netns, addressed by the plugin
+	Routes       []ConfigRoute
+
+	// bond of host uplinks the endpoint is reached through, instead of a
+	// veth pair
+	Bond *ConfigBond
+
+	// backs the endpoint by a tap device for a vm, instead of a veth pair
+	// moved to a netns
+	Tap bool
+
+	// limits the traffic the endpoint sends
+	RateLimit *ConfigRateLimit
+
+	// flows of the endpoint left out of connection tracking
+	ConntrackExemptions []ConfigConntrackExemption
+
+	// settings of the endpoint interface, applied in the container netns
+	Sysctls         map[string]string // e.g. net.ipv4.conf.eth0.rp_filter
+	EthtoolFeatures map[string]bool   // ethtool -K feature, on or off
+	Promisc         bool              // interface in promiscuous mode
+	AllMulti        bool              // interface receiving all multicast
+}
+
+// ConfigRoute is a static route to set up in an endpoint's namespace
+type ConfigRoute struct {
+	Dest     string // destination cidr
+	Gateway  string
+	NextHops []ConfigNextHop // multipath route, instead of Gateway
+}
+
+// ConfigBond is the bond of host uplinks backing an endpoint
+type ConfigBond struct {
+	Mode    string   // active-backup or lacp
+	Members []string // host interfaces enslaved to the bond
+}
+
+// ConfigRateLimit is the rate limit of the traffic an endpoint sends
+type ConfigRateLimit struct {
+	Rate  int64 // kbps
+	Burst int   // kb, 0 for the default of the switch
+}
+
+// ConfigConntrackExemption is the five-tuple of flows of an endpoint that
+// are not tracked. Empty addresses and zero ports match any.
+type ConfigConntrackExemption struct {
+	Protocol string // tcp, udp or sctp
+	SrcIP    string // address or cidr
+	SrcPort  int
+	DstIP    string // address or cidr
+	DstPort  int
+}
+
+// ConfigNextHop is a weighted next hop of a multipath route
+type ConfigNextHop struct {
+	Gateway string
+	Weight  int // 1 to 256, 1 when unset
 }
 
 // ConfigNetwork is a multi-destination isolated containment of endpoints
@@ -49,9 +117,60 @@ type ConfigNetwork struct {
 	Gateway        string
 	IPv6SubnetCIDR string
 	IPv6Gateway    string
-	Vrf            string
 	CfgdTag        string
 
+	// linux vrf device the interfaces the network has in the host netns
+	// are enslaved to, created with table VrfTable when missing
+	Vrf      string
+	VrfTable int
+
+	// bridge level options, rejected: the networks share the bridge, its
+	// options are set in the plugin config
+	EnableSTP           bool
+	EnableMcastSnooping bool
+	AgeingTime          int
+	FailMode            string
+
+	// delete endpoints when their container dies
+	AutoCleanEndpoints bool
+
+	// "dhcp" to address endpoints from an external dhcp server instead of
+	// allocating from the subnet
+	IPAM string
+
+	// mtu of the underlay links, the endpoint mtu is derived from it
+	UnderlayMTU int
+	// endpoint mtu, e.g. 9000 for jumbo frames, instead of the derived one.
+	// The underlay must carry it with the encap overhead.
+	MTU int
+
+	// "warn" or "fail" to probe for the address of endpoints on attach, and
+	// how long to wait for replies in seconds
+	DupAddrDetect  string
+	DupAddrTimeout int
+
+	// seconds a released address is not reallocated, to let stale
+	// neighbor entries of the previous endpoint expire
+	IPQuarantine int
+
+	// encrypt the vxlan traffic of the network between the hosts
+	Encrypt bool
+
+	// have the hosts answer arp for the gateway of a vxlan network, which
+	// is virtual, with GatewayMac or else a mac derived from the gateway
+	// address
+	GatewayArpProxy bool
+	GatewayMac      string
+
+	// handling of the broadcast, multicast and unknown unicast traffic:
+	// flood, the default, drop or controller
+	BUMMode string
+
+	// named ranges of the IPv4 subnet, first-last address, endpoints can
+	// ask their address from. The other endpoints get addresses outside
+	// of them.
+	AllocRanges map[string]string
+
 	// eps associated with the network
 	Endpoints []ConfigEP
 }
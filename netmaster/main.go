@@ -103,15 +103,31 @@ func initNetMaster(ctx *cli.Context) (*daemon.MasterDaemon, error) {
 		return nil, fmt.Errorf("Unknown netmaster infra type: %s", infra)
 	}
 
+	// 7. validate the network delete grace period
+	deleteGrace := ctx.Int("network-delete-grace")
+	if deleteGrace < 0 {
+		return nil, fmt.Errorf("Invalid network delete grace period: %d", deleteGrace)
+	}
+
+	// 8. validate the endpoint retention
+	epRetention := ctx.Int("endpoint-retention")
+	if epRetention < 0 {
+		return nil, fmt.Errorf("Invalid endpoint retention: %d", epRetention)
+	}
+
 	return &daemon.MasterDaemon{
 		ListenURL:          externalAddress,
 		ControlURL:         internalAddress,
 		ClusterStoreDriver: dbConfigs.StoreDriver,
 		ClusterStoreURL:    dbConfigs.StoreURL, //TODO: support more than one url
+		ClusterStoreFormat: dbConfigs.StoreFormat,
 		ClusterMode:        netConfigs.Mode,
 		NetworkMode:        netConfigs.NetworkMode,
 		NetForwardMode:     netConfigs.ForwardMode,
 		NetInfraType:       infra,
+		DisableStickyMac:   ctx.Bool("disable-sticky-mac"),
+		NetworkDeleteGrace: deleteGrace,
+		EndpointRetention:  epRetention,
 	}, nil
 }
 
@@ -152,6 +168,21 @@ func main() {
 			EnvVar: "CONTIV_NETMASTER_INTERNAL_ADDRESS",
 			Usage:  "set netmaster internal address to listen on, used for RPC and leader election (default: <host-ip-from-local-resolver>:<port-of-external-address>)",
 		},
+		cli.BoolFlag{
+			Name:   "disable-sticky-mac",
+			EnvVar: "CONTIV_NETMASTER_DISABLE_STICKY_MAC",
+			Usage:  "assign a new mac when an endpoint is recreated instead of reusing the previous one",
+		},
+		cli.IntFlag{
+			Name:   "network-delete-grace",
+			EnvVar: "CONTIV_NETMASTER_NETWORK_DELETE_GRACE",
+			Usage:  "set seconds a deleted network keeps its endpoints before removing them (default: 0, deleting a network with endpoints fails)",
+		},
+		cli.IntFlag{
+			Name:   "endpoint-retention",
+			EnvVar: "CONTIV_NETMASTER_ENDPOINT_RETENTION",
+			Usage:  "set seconds a deleted endpoint is kept in the recycle bin before removing it (default: 0, endpoints are removed at once)",
+		},
 	}
 	app.Flags = utils.FlattenFlags(netmasterFlags, utils.BuildDBFlags(binName), utils.BuildNetworkFlags(binName), utils.BuildLogFlags(binName))
 	sort.Sort(cli.FlagsByName(app.Flags))
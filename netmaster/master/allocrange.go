@@ -0,0 +1,165 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package master
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/netmaster/intent"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+	"github.com/contiv/netplugin/utils/netutils"
+	"github.com/jainvipin/bitset"
+)
+
+// RangeExhaustedError is returned when an endpoint asks for an address from
+// an allocation range of a network that has none free
+type RangeExhaustedError struct {
+	Network string
+	Range   string
+}
+
+func (e *RangeExhaustedError) Error() string {
+	return fmt.Sprintf("auto allocation failed - address exhaustion in range %s of network %s",
+		e.Range, e.Network)
+}
+
+// allocRangeBounds returns the numbers in the subnet of the first and last
+// addresses of range ipRange, a first-last address pair
+func allocRangeBounds(subnetIP string, subnetLen uint, ipRange string) (uint, uint, error) {
+	addrs := strings.Split(ipRange, "-")
+	if len(addrs) != 2 {
+		return 0, 0, core.Errorf("invalid range %q, must be first-last address", ipRange)
+	}
+	first, err := netutils.GetIPNumber(subnetIP, subnetLen, 32, addrs[0])
+	if err != nil {
+		return 0, 0, core.Errorf("range %s is not in subnet %s/%d", ipRange, subnetIP, subnetLen)
+	}
+	last, err := netutils.GetIPNumber(subnetIP, subnetLen, 32, addrs[1])
+	if err != nil {
+		return 0, 0, core.Errorf("range %s is not in subnet %s/%d", ipRange, subnetIP, subnetLen)
+	}
+	if first > last {
+		return 0, 0, core.Errorf("invalid range %s, its first address is after its last", ipRange)
+	}
+	return first, last, nil
+}
+
+// validateAllocRanges checks that the allocation ranges of a network are in
+// its IPv4 subnet and do not overlap
+func validateAllocRanges(network *intent.ConfigNetwork) error {
+	if len(network.AllocRanges) == 0 {
+		return nil
+	}
+	if network.SubnetCIDR == "" || network.IPAM == mastercfg.IPAMDhcp {
+		return core.Errorf("allocation ranges need an IPv4 subnet allocated by netmaster")
+	}
+	subnet, subnetLen, err := netutils.ParseCIDR(network.SubnetCIDR)
+	if err != nil {
+		return err
+	}
+	subnetIP := netutils.GetSubnetAddr(subnet, subnetLen)
+
+	names := []string{}
+	for name := range network.AllocRanges {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	type bounds struct{ first, last uint }
+	seen := map[string]bounds{}
+	for _, name := range names {
+		if name == "" {
+			return core.Errorf("allocation range %s has no name", network.AllocRanges[name])
+		}
+		first, last, err := allocRangeBounds(subnetIP, subnetLen, network.AllocRanges[name])
+		if err != nil {
+			return core.Errorf("allocation range %s: %v", name, err)
+		}
+		for other, b := range seen {
+			if first <= b.last && b.first <= last {
+				return core.Errorf("allocation ranges %s and %s overlap", other, name)
+			}
+		}
+		seen[name] = bounds{first, last}
+	}
+	return nil
+}
+
+// unreservedAllocMap returns the allocation map of the network with the
+// addresses of its allocation ranges marked used, for the endpoints that do
+// not ask for a range
+func unreservedAllocMap(nwCfg *mastercfg.CfgNetworkState) bitset.BitSet {
+	if len(nwCfg.AllocRanges) == 0 {
+		return nwCfg.IPAllocMap
+	}
+	allocMap := nwCfg.IPAllocMap.Clone()
+	for name, ipRange := range nwCfg.AllocRanges {
+		first, last, err := allocRangeBounds(nwCfg.SubnetIP, nwCfg.SubnetLen, ipRange)
+		if err != nil {
+			log.Errorf("Invalid allocation range %s of network %s. Err: %v", name, nwCfg.ID, err)
+			continue
+		}
+		for ipAddrValue := first; ipAddrValue <= last; ipAddrValue++ {
+			allocMap.Set(ipAddrValue)
+		}
+	}
+	return *allocMap
+}
+
+// rangeAllocAddress allocates the IPv4 address of an endpoint from allocation
+// range name of the network. reqAddr, when set, must be in the range.
+func rangeAllocAddress(nwCfg *mastercfg.CfgNetworkState, name, reqAddr string, forceReuse bool) (string, error) {
+	ipRange, ok := nwCfg.AllocRanges[name]
+	if !ok {
+		return "", core.Errorf("network %s has no allocation range %s", nwCfg.ID, name)
+	}
+	first, last, err := allocRangeBounds(nwCfg.SubnetIP, nwCfg.SubnetLen, ipRange)
+	if err != nil {
+		return "", err
+	}
+
+	if reqAddr != "" {
+		ipAddrValue, err := netutils.GetIPNumber(nwCfg.SubnetIP, nwCfg.SubnetLen, 32, reqAddr)
+		if err != nil || ipAddrValue < first || ipAddrValue > last {
+			return "", core.Errorf("address %s is not in allocation range %s (%s) of network %s",
+				reqAddr, name, ipRange, nwCfg.ID)
+		}
+		return networkAllocAddress(nwCfg, nil, reqAddr, false, forceReuse)
+	}
+
+	ipAddrValue, found := nextClearIPIn(nwCfg, nwCfg.IPAllocMap, first, last, forceReuse)
+	if !found {
+		log.Errorf("auto allocation failed - address exhaustion in range %s of network %s", name, nwCfg.ID)
+		return "", &RangeExhaustedError{Network: nwCfg.ID, Range: name}
+	}
+	ipAddress, err := netutils.GetSubnetIP(nwCfg.SubnetIP, nwCfg.SubnetLen, 32, ipAddrValue)
+	if err != nil {
+		return "", err
+	}
+
+	nwCfg.IPAllocMap.Set(ipAddrValue)
+	nwCfg.EpAddrCount++
+	delete(nwCfg.IPReleaseTimes, ipAddress)
+	if err := nwCfg.Write(); err != nil {
+		log.Errorf("error writing nw config. Error: %s", err)
+		return "", err
+	}
+	return ipAddress, nil
+}
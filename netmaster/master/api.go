@@ -37,6 +37,7 @@ type AddressAllocRequest struct {
 	NetworkID            string // Unique identifier for the network
 	AddressPool          string // Address pool from which to allocate the address
 	PreferredIPv4Address string // Preferred address
+	ForceIPReuse         bool   // Allocate addresses still in quarantine
 }
 
 // AddressAllocResponse is the response from netmaster
@@ -76,6 +77,7 @@ type DeleteEndpointRequest struct {
 	ServiceName string // service name
 	EndpointID  string // Unique identifier for the endpoint
 	IPv4Address string // Allocated IPv4 address for the endpoint
+	Force       bool   // delete for good, skipping the recycle bin
 }
 
 //UpdateEndpointRequest has the update endpoint request from netplugin
@@ -249,7 +251,8 @@ func AllocAddressHandler(w http.ResponseWriter, r *http.Request, vars map[string
 	}
 
 	// Alloc addresses
-	addr, err := networkAllocAddress(nwCfg, epgCfg, allocReq.PreferredIPv4Address, netutils.IsIPv6(allocReq.AddressPool))
+	addr, err := networkAllocAddress(nwCfg, epgCfg, allocReq.PreferredIPv4Address,
+		netutils.IsIPv6(allocReq.AddressPool), allocReq.ForceIPReuse)
 	if err != nil {
 		log.Errorf("Failed to allocate address. Err: %v", err)
 		return nil, err
@@ -370,6 +373,33 @@ func CreateEndpointHandler(w http.ResponseWriter, r *http.Request, vars map[stri
 	return epResp, nil
 }
 
+// ForceDeleteNetworkHandler removes a network being deleted without waiting
+// for the rest of its grace period. It only cuts a delete short: networks
+// not marked as deleting are refused, their endpoints are only ever evicted
+// after a plain delete was requested.
+func ForceDeleteNetworkHandler(w http.ResponseWriter, r *http.Request, vars map[string]string) (interface{}, error) {
+	stateDriver, err := utils.GetStateDriver()
+	if err != nil {
+		return nil, err
+	}
+
+	netID := vars["id"]
+	nwCfg := &mastercfg.CfgNetworkState{}
+	nwCfg.StateDriver = stateDriver
+	if err := nwCfg.Read(netID); err != nil {
+		return nil, err
+	}
+	if !nwCfg.Deleting {
+		return nil, core.Errorf("network %s is not being deleted", netID)
+	}
+
+	if err := ForceDeleteNetworkID(stateDriver, netID); err != nil {
+		log.Errorf("Error force deleting network %s. Err: %v", netID, err)
+		return nil, err
+	}
+	return nil, nil
+}
+
 // DeleteEndpointHandler handles delete endpoint requests
 func DeleteEndpointHandler(w http.ResponseWriter, r *http.Request, vars map[string]string) (interface{}, error) {
 	var epdelReq DeleteEndpointRequest
@@ -398,7 +428,7 @@ func DeleteEndpointHandler(w http.ResponseWriter, r *http.Request, vars map[stri
 	epID := getEpName(netID, &intent.ConfigEP{Container: epdelReq.EndpointID})
 
 	// delete the endpoint
-	epCfg, err := DeleteEndpointID(stateDriver, epID)
+	epCfg, err := RemoveEndpointID(stateDriver, epID, epdelReq.Force)
 	if err != nil {
 		log.Errorf("Error deleting endpoint: %v", epID)
 		return nil, err
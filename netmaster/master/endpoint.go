@@ -17,17 +17,25 @@ package master
 
 import (
 	"fmt"
+	"hash/fnv"
 	"net"
+	"regexp"
+	"strings"
+	"time"
 
 	"github.com/contiv/netplugin/core"
 	"github.com/contiv/netplugin/drivers"
 	"github.com/contiv/netplugin/netmaster/intent"
 	"github.com/contiv/netplugin/netmaster/mastercfg"
 	"github.com/contiv/netplugin/utils"
+	"github.com/contiv/netplugin/utils/netutils"
 
 	log "github.com/Sirupsen/logrus"
 )
 
+// stickyMacMaxAge is how long the mac of a deleted endpoint is kept for reuse
+const stickyMacMaxAge = 7 * 24 * time.Hour
+
 func validateEndpointConfig(stateDriver core.StateDriver, tenant *intent.ConfigTenant) error {
 	var err error
 
@@ -62,43 +70,594 @@ func validateEndpointConfig(stateDriver core.StateDriver, tenant *intent.ConfigT
 func allocSetEpAddress(ep *intent.ConfigEP, epCfg *mastercfg.CfgEndpointState,
 	nwCfg *mastercfg.CfgNetworkState, epgCfg *mastercfg.EndpointGroupState) (err error) {
 
-	ipAddress, err := networkAllocAddress(nwCfg, epgCfg, ep.IPAddress, false)
-	if err != nil {
-		log.Errorf("Error allocating IP address. Err: %v", err)
-		return
+	// addresses come from the dhcp server, only a mac is needed
+	if nwCfg.IPAM == mastercfg.IPAMDhcp {
+		if ep.IPAddress != "" || ep.IPv6Address != "" || len(ep.SecondaryIPs) > 0 {
+			return core.Errorf("addresses can not be requested on dhcp network %s", nwCfg.ID)
+		}
+		epCfg.MacAddress = ep.MacAddress
+		if epCfg.MacAddress == "" {
+			epCfg.MacAddress = dhcpEpMac(epCfg.ID)
+		}
+		return nil
 	}
 
-	epCfg.IPAddress = ipAddress
+	// an address of each family of the network
+	if nwCfg.SubnetIP == "" && ep.IPAddress != "" {
+		return core.Errorf("network %s has no IPv4 subnet for address %s", nwCfg.ID, ep.IPAddress)
+	}
+	if nwCfg.IPv6Subnet == "" && ep.IPv6Address != "" {
+		return core.Errorf("network %s has no IPv6 subnet for address %s", nwCfg.ID, ep.IPv6Address)
+	}
+	if len(nwCfg.AddrFamilies()) == 0 {
+		return core.Errorf("network %s has no subnet", nwCfg.ID)
+	}
 
-	// Set mac address which is derived from IP address
-	ipAddr := net.ParseIP(ipAddress)
-	macAddr := fmt.Sprintf("02:02:%02x:%02x:%02x:%02x", ipAddr[12], ipAddr[13], ipAddr[14], ipAddr[15])
+	if ep.AllocRange != "" {
+		if nwCfg.SubnetIP == "" {
+			return core.Errorf("network %s has no IPv4 subnet for allocation range %s", nwCfg.ID, ep.AllocRange)
+		}
+		if epgCfg != nil && len(epgCfg.IPPool) > 0 {
+			return core.Errorf("allocation range %s can not be used with the ip pool of group %s",
+				ep.AllocRange, epgCfg.GroupName)
+		}
+	}
 
-	epCfg.MacAddress = macAddr
+	if nwCfg.SubnetIP != "" {
+		var ipAddress string
+		if ep.AllocRange != "" {
+			ipAddress, err = rangeAllocAddress(nwCfg, ep.AllocRange, ep.IPAddress, ep.ForceIPReuse)
+		} else {
+			ipAddress, err = networkAllocAddress(nwCfg, epgCfg, ep.IPAddress, false, ep.ForceIPReuse)
+		}
+		if err != nil {
+			log.Errorf("Error allocating IP address. Err: %v", err)
+			return
+		}
+		epCfg.IPAddress = ipAddress
+	}
 
 	if nwCfg.IPv6Subnet != "" {
 		var ipv6Address string
-		ipv6Address, err = networkAllocAddress(nwCfg, nil, ep.IPv6Address, true)
+		ipv6Address, err = networkAllocAddress(nwCfg, nil, ep.IPv6Address, true, ep.ForceIPReuse)
 		if err != nil {
 			log.Errorf("Error allocating IP address. Err: %v", err)
+			if epCfg.IPAddress != "" {
+				networkReleaseAddress(nwCfg, epgCfg, epCfg.IPAddress)
+				epCfg.IPAddress = ""
+			}
 			return
 		}
 		epCfg.IPv6Address = ipv6Address
 	}
 
+	// Set mac address which is derived from the IPv4 address, or the IPv6
+	// one without, unless requested
+	if ep.MacAddress != "" {
+		epCfg.MacAddress = ep.MacAddress
+	} else {
+		ipAddr := net.ParseIP(epCfg.IPAddress)
+		if ipAddr == nil {
+			ipAddr = net.ParseIP(epCfg.IPv6Address)
+		}
+		macAddr := fmt.Sprintf("02:02:%02x:%02x:%02x:%02x", ipAddr[12], ipAddr[13], ipAddr[14], ipAddr[15])
+
+		epCfg.MacAddress = macAddr
+	}
+
+	err = allocSecondaryAddresses(ep, epCfg, nwCfg)
+	if err != nil {
+		// the primary addresses go with the failed create
+		if epCfg.IPAddress != "" {
+			networkReleaseAddress(nwCfg, epgCfg, epCfg.IPAddress)
+			epCfg.IPAddress = ""
+		}
+		if epCfg.IPv6Address != "" {
+			networkReleaseAddress(nwCfg, nil, epCfg.IPv6Address)
+			epCfg.IPv6Address = ""
+		}
+	}
 	return
 }
 
+// dhcpEpMac derives the mac of an endpoint on a dhcp network from its id
+func dhcpEpMac(epID string) string {
+	h := fnv.New32a()
+	h.Write([]byte(epID))
+	sum := h.Sum32()
+	return fmt.Sprintf("02:03:%02x:%02x:%02x:%02x", byte(sum>>24), byte(sum>>16), byte(sum>>8), byte(sum))
+}
+
+// checkAddrInNetwork verifies that a secondary address belongs to one of the
+// subnets of the network and is not already in use
+func checkAddrInNetwork(nwCfg *mastercfg.CfgNetworkState, addr string) error {
+	ipAddr := net.ParseIP(addr)
+	if ipAddr == nil {
+		return core.Errorf("invalid secondary address %q", addr)
+	}
+
+	subnetIP, subnetLen := nwCfg.SubnetIP, nwCfg.SubnetLen
+	if netutils.IsIPv6(addr) {
+		subnetIP, subnetLen = nwCfg.IPv6Subnet, nwCfg.IPv6SubnetLen
+	}
+	if subnetIP == "" {
+		return core.Errorf("network %s has no subnet for secondary address %s", nwCfg.ID, addr)
+	}
+
+	_, ipNet, err := net.ParseCIDR(fmt.Sprintf("%s/%d", subnetIP, subnetLen))
+	if err != nil {
+		return err
+	}
+	if !ipNet.Contains(ipAddr) {
+		return core.Errorf("secondary address %s is not in subnet %s", addr, ipNet.String())
+	}
+
+	inUse := false
+	if netutils.IsIPv6(addr) {
+		hostID, err := netutils.GetIPv6HostID(nwCfg.IPv6Subnet, nwCfg.IPv6SubnetLen, addr)
+		if err != nil {
+			return err
+		}
+		_, inUse = nwCfg.IPv6AllocMap[hostID]
+	} else {
+		ipAddrValue, err := netutils.GetIPNumber(nwCfg.SubnetIP, nwCfg.SubnetLen, 32, addr)
+		if err != nil {
+			return err
+		}
+		inUse = nwCfg.IPAllocMap.Test(ipAddrValue)
+	}
+	if inUse {
+		return core.Errorf("secondary address %s is already in use", addr)
+	}
+
+	return nil
+}
+
+// allocSecondaryAddresses reserves the secondary addresses of an endpoint.
+// Already reserved addresses are released if any of them fails.
+func allocSecondaryAddresses(ep *intent.ConfigEP, epCfg *mastercfg.CfgEndpointState,
+	nwCfg *mastercfg.CfgNetworkState) (err error) {
+
+	defer func() {
+		if err != nil {
+			releaseSecondaryAddresses(epCfg, nwCfg)
+		}
+	}()
+
+	for _, addr := range ep.SecondaryIPs {
+		if err = checkAddrInNetwork(nwCfg, addr); err != nil {
+			log.Errorf("Error validating secondary address. Err: %v", err)
+			return
+		}
+
+		// release decrements the count for every address it frees
+		nwCfg.EpAddrCount++
+		_, err = networkAllocAddress(nwCfg, nil, addr, netutils.IsIPv6(addr), ep.ForceIPReuse)
+		if err != nil {
+			nwCfg.EpAddrCount--
+			log.Errorf("Error allocating secondary address %s. Err: %v", addr, err)
+			return
+		}
+
+		epCfg.SecondaryIPs = append(epCfg.SecondaryIPs, addr)
+	}
+
+	return
+}
+
+// releaseSecondaryAddresses returns the secondary addresses of an endpoint
+// to the network
+func releaseSecondaryAddresses(epCfg *mastercfg.CfgEndpointState, nwCfg *mastercfg.CfgNetworkState) {
+	for _, addr := range epCfg.SecondaryIPs {
+		if err := networkReleaseAddress(nwCfg, nil, addr); err != nil {
+			log.Errorf("Error releasing secondary address %s. Err: %v", addr, err)
+		}
+	}
+	epCfg.SecondaryIPs = nil
+}
+
+// setStickyMac reuses the mac of a previous endpoint with the same id, and
+// records the mac of the endpoint for future reuse. A mac held by another
+// endpoint of the network is not given to the endpoint: a sticky mac taken
+// in the meantime is not reused, and a fresh mac still held by an endpoint
+// recreated with another address is replaced by one derived from the id.
+func setStickyMac(stateDriver core.StateDriver, epCfg *mastercfg.CfgEndpointState) error {
+	if masterRTCfg.disableStickyMac {
+		return nil
+	}
+
+	macState := &mastercfg.EndpointMacState{}
+	macState.StateDriver = stateDriver
+	err := macState.Read(epCfg.ID)
+	if err == nil && macState.MacAddress != "" {
+		owner, err := macOwner(stateDriver, epCfg.NetID, macState.MacAddress)
+		if err != nil {
+			return err
+		}
+		if owner != "" && owner != epCfg.ID {
+			log.Warnf("Mac %s of endpoint %s is taken by %s, using %s",
+				macState.MacAddress, epCfg.ID, owner, epCfg.MacAddress)
+		} else {
+			log.Infof("Reusing mac %s for endpoint %s", macState.MacAddress, epCfg.ID)
+			epCfg.MacAddress = macState.MacAddress
+		}
+	} else if core.ErrIfKeyExists(err) != nil {
+		return err
+	}
+
+	if !strings.EqualFold(epCfg.MacAddress, macState.MacAddress) {
+		owner, err := macOwner(stateDriver, epCfg.NetID, epCfg.MacAddress)
+		if err != nil {
+			return err
+		}
+		if owner != "" && owner != epCfg.ID {
+			mac := dhcpEpMac(epCfg.ID)
+			idOwner, err := macOwner(stateDriver, epCfg.NetID, mac)
+			if err != nil {
+				return err
+			}
+			if idOwner != "" && idOwner != epCfg.ID {
+				return core.Errorf("macs %s and %s of endpoint %s are held by endpoints %s and %s",
+					epCfg.MacAddress, mac, epCfg.ID, owner, idOwner)
+			}
+			log.Warnf("Mac %s is held by endpoint %s, using %s for endpoint %s",
+				epCfg.MacAddress, owner, mac, epCfg.ID)
+			epCfg.MacAddress = mac
+		}
+		if macState.MacAddress != "" {
+			dropMacOwner(stateDriver, epCfg.NetID, macState.MacAddress, epCfg.ID)
+		}
+	}
+
+	if err := setMacOwner(stateDriver, epCfg); err != nil {
+		return err
+	}
+	macState.ID = epCfg.ID
+	macState.NetID = epCfg.NetID
+	macState.MacAddress = epCfg.MacAddress
+	macState.ReleasedAt = time.Time{}
+	return macState.Write()
+}
+
+// claimMac records the requested mac of endpoint epCfg as held by it. The
+// request wins over another endpoint holding the mac.
+func claimMac(stateDriver core.StateDriver, epCfg *mastercfg.CfgEndpointState) error {
+	if masterRTCfg.disableStickyMac {
+		return nil
+	}
+
+	owner, err := macOwner(stateDriver, epCfg.NetID, epCfg.MacAddress)
+	if err != nil {
+		return err
+	}
+	if owner != "" && owner != epCfg.ID {
+		log.Warnf("Requested mac %s of endpoint %s was held by endpoint %s", epCfg.MacAddress, epCfg.ID, owner)
+	}
+	return setMacOwner(stateDriver, epCfg)
+}
+
+// macOwner returns the id of the endpoint holding mac in network netID, ""
+// when none does
+func macOwner(stateDriver core.StateDriver, netID, mac string) (string, error) {
+	ownerState := &mastercfg.MacOwnerState{}
+	ownerState.StateDriver = stateDriver
+	err := ownerState.Read(mastercfg.MacOwnerID(netID, mac))
+	if err != nil {
+		return "", core.ErrIfKeyExists(err)
+	}
+	return ownerState.EndpointID, nil
+}
+
+// setMacOwner records the mac of endpoint epCfg as held by it
+func setMacOwner(stateDriver core.StateDriver, epCfg *mastercfg.CfgEndpointState) error {
+	ownerState := &mastercfg.MacOwnerState{EndpointID: epCfg.ID}
+	ownerState.ID = mastercfg.MacOwnerID(epCfg.NetID, epCfg.MacAddress)
+	ownerState.StateDriver = stateDriver
+	return ownerState.Write()
+}
+
+// dropMacOwner removes the owner record of mac in network netID when
+// endpoint epID holds it
+func dropMacOwner(stateDriver core.StateDriver, netID, mac, epID string) {
+	ownerState := &mastercfg.MacOwnerState{}
+	ownerState.StateDriver = stateDriver
+	if err := ownerState.Read(mastercfg.MacOwnerID(netID, mac)); err != nil || ownerState.EndpointID != epID {
+		return
+	}
+	if err := ownerState.Clear(); err != nil {
+		log.Errorf("Error clearing the owner of mac %s in network %s. Err: %v", mac, netID, err)
+	}
+}
+
+// releaseStickyMac marks the mac of a deleted endpoint as released and
+// removes macs that have been released for longer than stickyMacMaxAge. A
+// requested mac, that is not kept, is released right away.
+func releaseStickyMac(stateDriver core.StateDriver, epCfg *mastercfg.CfgEndpointState) {
+	if masterRTCfg.disableStickyMac {
+		return
+	}
+
+	macState := &mastercfg.EndpointMacState{}
+	macState.StateDriver = stateDriver
+	if err := macState.Read(epCfg.ID); err == nil && strings.EqualFold(macState.MacAddress, epCfg.MacAddress) {
+		macState.NetID = epCfg.NetID
+		macState.ReleasedAt = time.Now()
+		if err := macState.Write(); err != nil {
+			log.Errorf("Error writing mac state for %s. Err: %v", epCfg.ID, err)
+		}
+	} else {
+		dropMacOwner(stateDriver, epCfg.NetID, epCfg.MacAddress, epCfg.ID)
+	}
+
+	macStates, err := macState.ReadAll()
+	if err != nil {
+		return
+	}
+	for _, state := range macStates {
+		ms := state.(*mastercfg.EndpointMacState)
+		if !ms.ReleasedAt.IsZero() && time.Since(ms.ReleasedAt) > stickyMacMaxAge {
+			if err := ms.Clear(); err != nil {
+				log.Errorf("Error clearing mac state for %s. Err: %v", ms.ID, err)
+				continue
+			}
+			dropMacOwner(stateDriver, ms.NetID, ms.MacAddress, ms.ID)
+		}
+	}
+}
+
+// checkQoSMarking validates the DSCP and 802.1p CoS values of an endpoint
+func checkQoSMarking(dscp, cos int) error {
+	if dscp < 0 || dscp > 63 {
+		return core.Errorf("invalid DSCP value %d, must be between 0 and 63", dscp)
+	}
+	if cos < 0 || cos > 7 {
+		return core.Errorf("invalid CoS value %d, must be between 0 and 7", cos)
+	}
+	return nil
+}
+
+// MinLeaseTTL is the shortest endpoint lease in seconds, the keepalive
+// refreshes leases a few times per ttl
+const MinLeaseTTL = 3
+
+// checkEndpointLease validates the lease ttl of an endpoint
+func checkEndpointLease(ttl int) error {
+	if ttl == 0 {
+		return nil
+	}
+	if ttl < MinLeaseTTL {
+		return core.Errorf("invalid lease ttl %d, must be at least %d seconds", ttl, MinLeaseTTL)
+	}
+	return nil
+}
+
+var (
+	sysctlKeyRe      = regexp.MustCompile(`^net\.[A-Za-z0-9_\-/]+(\.[A-Za-z0-9_\-/]+)*$`)
+	ethtoolFeatureRe = regexp.MustCompile(`^[a-z0-9][a-z0-9\-]*$`)
+	intfNameRe       = regexp.MustCompile(`^[^/:\s]{1,15}$`)
+	altNameRe        = regexp.MustCompile(`^[^/:\s]+$`)
+)
+
+// maxAltNameLen is the longest interface altname the kernel accepts
+const maxAltNameLen = 127
+
+// checkHostIntfName validates the name the host side of the veth of endpoint
+// epID is given on host. The name is added as an altname of the interface and
+// must not be taken by another endpoint of the host.
+func checkHostIntfName(stateDriver core.StateDriver, epID, host, name string) error {
+	if len(name) > maxAltNameLen || name == "." || name == ".." || !altNameRe.MatchString(name) {
+		return core.Errorf("invalid host interface name %q", name)
+	}
+
+	epCfg := &mastercfg.CfgEndpointState{}
+	epCfg.StateDriver = stateDriver
+	epCfgs, err := epCfg.ReadAll()
+	if core.ErrIfKeyExists(err) != nil {
+		return err
+	}
+	for _, state := range epCfgs {
+		other := state.(*mastercfg.CfgEndpointState)
+		if other.ID != epID && other.HomingHost == host && other.HostIntfName == name {
+			return core.Errorf("host interface name %s is used by endpoint %s on host %s", name, other.ID, host)
+		}
+	}
+	return nil
+}
+
+// checkIntfSettings validates the syntax of the sysctls and ethtool features
+// of an endpoint. Only network sysctls can be set in a container netns.
+func checkIntfSettings(sysctls map[string]string, features map[string]bool) error {
+	for key, value := range sysctls {
+		if !sysctlKeyRe.MatchString(key) {
+			return core.Errorf("invalid sysctl key %q", key)
+		}
+		if value == "" || strings.ContainsAny(value, "\n\x00") {
+			return core.Errorf("invalid value %q for sysctl %s", value, key)
+		}
+	}
+	for feature := range features {
+		if !ethtoolFeatureRe.MatchString(feature) {
+			return core.Errorf("invalid ethtool feature %q", feature)
+		}
+	}
+	return nil
+}
+
+// checkIntfFlags validates the promisc and allmulti flags of an endpoint,
+// set on its interface in the container netns. Host endpoints and bonds
+// stay in the host netns, the guest of a tap endpoint owns its flags.
+func checkIntfFlags(ep *intent.ConfigEP) error {
+	if !ep.Promisc && !ep.AllMulti {
+		return nil
+	}
+	if ep.HostEndpoint || ep.Bond != nil || ep.Tap {
+		return core.Errorf("interface flags only apply to endpoints attached to a netns")
+	}
+	return nil
+}
+
+// endpointRoutes validates the static routes of an endpoint and converts
+// them to their state. The next hops of a route must be in the subnet of the
+// network, the only one reachable from the endpoint interface.
+func endpointRoutes(nwCfg *mastercfg.CfgNetworkState, routes []intent.ConfigRoute) ([]mastercfg.EndpointRoute, error) {
+	epRoutes := []mastercfg.EndpointRoute{}
+	for _, route := range routes {
+		_, dest, err := net.ParseCIDR(route.Dest)
+		if err != nil {
+			return nil, core.Errorf("invalid route destination %q", route.Dest)
+		}
+		if (route.Gateway == "") == (len(route.NextHops) == 0) {
+			return nil, core.Errorf("route to %s needs either a gateway or next hops", route.Dest)
+		}
+
+		epRoute := mastercfg.EndpointRoute{Dest: route.Dest, Gateway: route.Gateway}
+		for _, hop := range route.NextHops {
+			epRoute.NextHops = append(epRoute.NextHops,
+				mastercfg.RouteNextHop{Gateway: hop.Gateway, Weight: hop.Weight})
+		}
+
+		seen := make(map[string]bool)
+		for _, hop := range epRoute.Hops() {
+			gw := net.ParseIP(hop.Gateway)
+			if gw == nil || (gw.To4() == nil) != (dest.IP.To4() == nil) {
+				return nil, core.Errorf("invalid gateway %q for route to %s", hop.Gateway, route.Dest)
+			}
+			if seen[gw.String()] {
+				return nil, core.Errorf("duplicate gateway %s for route to %s", hop.Gateway, route.Dest)
+			}
+			seen[gw.String()] = true
+			if hop.Weight < mastercfg.MinRouteWeight || hop.Weight > mastercfg.MaxRouteWeight {
+				return nil, core.Errorf("invalid weight %d of gateway %s, must be between %d and %d",
+					hop.Weight, hop.Gateway, mastercfg.MinRouteWeight, mastercfg.MaxRouteWeight)
+			}
+			if !nwCfg.OnLink(gw) {
+				return nil, core.Errorf("gateway %s of route to %s is not reachable in network %s",
+					hop.Gateway, route.Dest, nwCfg.ID)
+			}
+		}
+		epRoutes = append(epRoutes, epRoute)
+	}
+	return epRoutes, nil
+}
+
+// ctExemptionAddr parses an address of a conntrack exemption, an ip or a
+// cidr, and returns its ip
+func ctExemptionAddr(addr string) (net.IP, error) {
+	if strings.Contains(addr, "/") {
+		ip, _, err := net.ParseCIDR(addr)
+		return ip, err
+	}
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return nil, core.Errorf("invalid address %q", addr)
+	}
+	return ip, nil
+}
+
+// endpointConntrackExemptions validates the conntrack exemptions of an endpoint and
+// converts them to their state. An exemption needs a protocol with ports,
+// addresses of a single family, and must not match every flow of its
+// protocol.
+func endpointConntrackExemptions(exemptions []intent.ConfigConntrackExemption) ([]mastercfg.ConntrackExemption, error) {
+	exemptionStates := []mastercfg.ConntrackExemption{}
+	seen := make(map[mastercfg.ConntrackExemption]bool)
+	for _, exemption := range exemptions {
+		ctExemption := mastercfg.ConntrackExemption{
+			Protocol: strings.ToLower(exemption.Protocol),
+			SrcIP:    exemption.SrcIP,
+			SrcPort:  exemption.SrcPort,
+			DstIP:    exemption.DstIP,
+			DstPort:  exemption.DstPort,
+		}
+		switch ctExemption.Protocol {
+		case "tcp", "udp", "sctp":
+		default:
+			return nil, core.Errorf("invalid conntrack exemption protocol %q, must be tcp, udp or sctp",
+				exemption.Protocol)
+		}
+		for _, port := range []int{exemption.SrcPort, exemption.DstPort} {
+			if port < 0 || port > 65535 {
+				return nil, core.Errorf("invalid conntrack exemption port %d", port)
+			}
+		}
+
+		var family net.IP
+		for _, addr := range []string{exemption.SrcIP, exemption.DstIP} {
+			if addr == "" {
+				continue
+			}
+			ip, err := ctExemptionAddr(addr)
+			if err != nil {
+				return nil, core.Errorf("invalid conntrack exemption address %q", addr)
+			}
+			if family != nil && (family.To4() == nil) != (ip.To4() == nil) {
+				return nil, core.Errorf("conntrack exemption mixes ipv4 and ipv6 addresses %s and %s",
+					exemption.SrcIP, exemption.DstIP)
+			}
+			family = ip
+		}
+
+		if ctExemption == (mastercfg.ConntrackExemption{Protocol: ctExemption.Protocol}) {
+			return nil, core.Errorf("conntrack exemption matches every %s flow", ctExemption.Protocol)
+		}
+		if seen[ctExemption] {
+			return nil, core.Errorf("duplicate conntrack exemption %+v", exemption)
+		}
+		seen[ctExemption] = true
+		exemptionStates = append(exemptionStates, ctExemption)
+	}
+	return exemptionStates, nil
+}
+
+// endpointBond validates the bond backing an endpoint and converts it to
+// its state. A bond needs at least two distinct member interfaces.
+func endpointBond(bond *intent.ConfigBond) (*mastercfg.EndpointBond, error) {
+	switch bond.Mode {
+	case mastercfg.BondModeActiveBackup, mastercfg.BondModeLACP:
+	default:
+		return nil, core.Errorf("invalid bond mode %q, must be %s or %s",
+			bond.Mode, mastercfg.BondModeActiveBackup, mastercfg.BondModeLACP)
+	}
+	if len(bond.Members) < 2 {
+		return nil, core.Errorf("bond needs at least two members, got %v", bond.Members)
+	}
+
+	seen := make(map[string]bool)
+	for _, member := range bond.Members {
+		if !intfNameRe.MatchString(member) {
+			return nil, core.Errorf("invalid bond member %q", member)
+		}
+		if seen[member] {
+			return nil, core.Errorf("duplicate bond member %s", member)
+		}
+		seen[member] = true
+	}
+	return &mastercfg.EndpointBond{Mode: bond.Mode, Members: bond.Members}, nil
+}
+
+// endpointRateLimit validates the rate limit of an endpoint and converts it
+// to its state
+func endpointRateLimit(limit *intent.ConfigRateLimit) (*mastercfg.EndpointRateLimit, error) {
+	if limit.Rate <= 0 {
+		return nil, core.Errorf("invalid endpoint rate limit %d kbps", limit.Rate)
+	}
+	if limit.Burst < 0 {
+		return nil, core.Errorf("invalid endpoint rate limit burst %d kb", limit.Burst)
+	}
+	return &mastercfg.EndpointRateLimit{Rate: limit.Rate, Burst: limit.Burst}, nil
+}
+
 // freeAddrOnErr deferred function that cleans up on error
 func freeAddrOnErr(nwCfg *mastercfg.CfgNetworkState, epgCfg *mastercfg.EndpointGroupState,
 	ipAddress string, pErr *error) {
-	if *pErr != nil {
+	if *pErr != nil && ipAddress != "" {
 		log.Infof("Freeing %s on error", ipAddress)
 		networkReleaseAddress(nwCfg, epgCfg, ipAddress)
 	}
 }
 
-// CreateEndpoint creates an endpoint
+// CreateEndpoint creates an endpoint. Callers in netmaster hold addrMutex,
+// which serializes the quota check with the address allocation.
 func CreateEndpoint(stateDriver core.StateDriver, nwCfg *mastercfg.CfgNetworkState,
 	epReq *CreateEndpointRequest) (*mastercfg.CfgEndpointState, error) {
 
@@ -112,12 +671,94 @@ func CreateEndpoint(stateDriver core.StateDriver, nwCfg *mastercfg.CfgNetworkSta
 		// TODO: check for diffs and possible updates
 		return epCfg, nil
 	}
+	if nwCfg.Deleting {
+		return nil, core.Errorf("network %s is being deleted", nwCfg.ID)
+	}
+	// endpoints that exist already keep working whatever their container id
+	if ep.Container != "" {
+		if err := ValidateContainerID(ep.Container); err != nil {
+			return nil, err
+		}
+	}
+	if err := checkEndpointQuota(stateDriver, nwCfg.Tenant); err != nil {
+		return nil, err
+	}
 
 	epCfg.NetID = nwCfg.ID
 	epCfg.EndpointID = ep.Container
 	epCfg.HomingHost = ep.Host
 	epCfg.ServiceName = ep.ServiceName
 	epCfg.EPCommonName = epReq.EPCommonName
+	if ep.HostIntfName != "" {
+		if err := checkHostIntfName(stateDriver, epCfg.ID, ep.Host, ep.HostIntfName); err != nil {
+			return nil, err
+		}
+		epCfg.HostIntfName = ep.HostIntfName
+	}
+
+	if err := checkQoSMarking(ep.DSCP, ep.CoS); err != nil {
+		return nil, err
+	}
+	epCfg.DSCP = ep.DSCP
+	epCfg.CoS = ep.CoS
+
+	if ep.MTU != 0 && (ep.MTU < minMTU || ep.MTU > 65535) {
+		return nil, core.Errorf("invalid endpoint mtu %d", ep.MTU)
+	}
+	epCfg.MTU = ep.MTU
+	epCfg.Labels = ep.Labels
+	epCfg.Driver = ep.Driver
+
+	if err := checkEndpointLease(ep.LeaseTTL); err != nil {
+		return nil, err
+	}
+	epCfg.LeaseTTL = ep.LeaseTTL
+	epCfg.HostEndpoint = ep.HostEndpoint
+	epCfg.Vrf = nwCfg.Vrf
+	if ep.Bond != nil {
+		if ep.HostEndpoint {
+			return nil, core.Errorf("a host endpoint can not be backed by a bond")
+		}
+		epCfg.Bond, err = endpointBond(ep.Bond)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if ep.Tap {
+		if ep.HostEndpoint || ep.Bond != nil {
+			return nil, core.Errorf("a tap endpoint can not be a host endpoint or be backed by a bond")
+		}
+		epCfg.Tap = true
+	}
+	if ep.RateLimit != nil {
+		epCfg.RateLimit, err = endpointRateLimit(ep.RateLimit)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(ep.Routes) > 0 {
+		epCfg.Routes, err = endpointRoutes(nwCfg, ep.Routes)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(ep.ConntrackExemptions) > 0 {
+		epCfg.ConntrackExemptions, err = endpointConntrackExemptions(ep.ConntrackExemptions)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := checkIntfSettings(ep.Sysctls, ep.EthtoolFeatures); err != nil {
+		return nil, err
+	}
+	epCfg.Sysctls = ep.Sysctls
+	epCfg.EthtoolFeatures = ep.EthtoolFeatures
+	if err := checkIntfFlags(ep); err != nil {
+		return nil, err
+	}
+	epCfg.Promisc = ep.Promisc
+	epCfg.AllMulti = ep.AllMulti
 
 	// In ACI mode, if a pod does not have a group label, we will assume "default-group"
 	isAci, _ := IsAciConfigured()
@@ -146,6 +787,18 @@ func CreateEndpoint(stateDriver core.StateDriver, nwCfg *mastercfg.CfgNetworkSta
 
 	// cleanup relies on var err being used for all error checking
 	defer freeAddrOnErr(nwCfg, epgCfg, epCfg.IPAddress, &err)
+	defer freeAddrOnErr(nwCfg, nil, epCfg.IPv6Address, &err)
+
+	// keep the mac of a previous endpoint with the same id
+	if ep.MacAddress == "" {
+		err = setStickyMac(stateDriver, epCfg)
+	} else {
+		err = claimMac(stateDriver, epCfg)
+	}
+	if err != nil {
+		log.Errorf("Error setting mac for %s. Err: %v", epCfg.ID, err)
+		return nil, err
+	}
 
 	// Set endpoint group
 	// Skip for infra nw
@@ -188,9 +841,127 @@ func CreateEndpoint(stateDriver core.StateDriver, nwCfg *mastercfg.CfgNetworkSta
 		return nil, err
 	}
 
+	if epCfg.LeaseTTL != 0 {
+		err = writeEndpointLease(stateDriver, epCfg)
+		if err != nil {
+			log.Errorf("error writing the lease of ep %s. Error: %s", epCfg.ID, err)
+			epCfg.Clear()
+			return nil, err
+		}
+	}
+
 	return epCfg, nil
 }
 
+// MoveEndpoint moves the config of endpoint epCfg to network nwCfg and
+// returns it. The endpoint keeps its id, container and mac and gets an
+// address in the new network, in the endpoint group of the same name. The
+// resources held in the old network are kept, they are freed by
+// ReleaseEndpointNetwork with the old config once the move is done, or the
+// new ones with the new config when it is rolled back.
+func MoveEndpoint(stateDriver core.StateDriver, epCfg *mastercfg.CfgEndpointState,
+	nwCfg *mastercfg.CfgNetworkState) (*mastercfg.CfgEndpointState, error) {
+
+	var epgCfg *mastercfg.EndpointGroupState
+	if nwCfg.Deleting {
+		return nil, core.Errorf("network %s is being deleted", nwCfg.ID)
+	}
+	if nwCfg.NwType == "infra" {
+		return nil, core.Errorf("endpoints can not move to infra network %s", nwCfg.ID)
+	}
+	if epCfg.HostEndpoint {
+		return nil, core.Errorf("host endpoint %s can not move", epCfg.ID)
+	}
+	if epCfg.IPAddress == "" && epCfg.IPv6Address == "" || nwCfg.IPAM == mastercfg.IPAMDhcp {
+		return nil, core.Errorf("endpoint %s can not move, its addresses come from dhcp", epCfg.ID)
+	}
+	if len(epCfg.SecondaryIPs) > 0 {
+		return nil, core.Errorf("endpoint %s can not move with secondary addresses", epCfg.ID)
+	}
+
+	newCfg := &mastercfg.CfgEndpointState{}
+	*newCfg = *epCfg
+	newCfg.StateDriver = stateDriver
+	newCfg.NetID = nwCfg.ID
+	newCfg.IPAddress = ""
+	newCfg.IPv6Address = ""
+
+	if len(newCfg.ServiceName) > 0 {
+		epgCfg = &mastercfg.EndpointGroupState{}
+		epgCfg.StateDriver = stateDriver
+		if err := epgCfg.Read(newCfg.ServiceName + ":" + nwCfg.Tenant); err != nil {
+			log.Errorf("failed to read endpoint group %s of network %s, %v",
+				newCfg.ServiceName, nwCfg.ID, err)
+			return nil, err
+		}
+	}
+
+	// the interface of the endpoint keeps its mac
+	ep := &intent.ConfigEP{Container: epCfg.EndpointID, MacAddress: epCfg.MacAddress}
+	err := allocSetEpAddress(ep, newCfg, nwCfg, epgCfg)
+	if err != nil {
+		log.Errorf("error allocating IP in network %s. Error: %s", nwCfg.ID, err)
+		return nil, err
+	}
+
+	// cleanup relies on var err being used for all error checking
+	defer freeAddrOnErr(nwCfg, epgCfg, newCfg.IPAddress, &err)
+	defer freeAddrOnErr(nwCfg, nil, newCfg.IPv6Address, &err)
+
+	newCfg.EndpointGroupKey = mastercfg.GetEndpointGroupKey(newCfg.ServiceName, nwCfg.Tenant)
+	newCfg.EndpointGroupID, err = mastercfg.GetEndpointGroupID(stateDriver, newCfg.ServiceName, nwCfg.Tenant)
+	if err != nil {
+		log.Errorf("Error getting endpoint group ID for %s.%s. Err: %v", newCfg.ServiceName, nwCfg.ID, err)
+		return nil, err
+	}
+	if newCfg.EndpointGroupKey != "" {
+		epgCfg := &mastercfg.EndpointGroupState{}
+		epgCfg.StateDriver = stateDriver
+		err = epgCfg.Read(newCfg.EndpointGroupKey)
+		if err != nil {
+			log.Errorf("Error reading Epg info for EP: %+v. Error: %v", newCfg, err)
+			return nil, err
+		}
+
+		epgCfg.EpCount++
+
+		err = epgCfg.Write()
+		if err != nil {
+			log.Errorf("Error saving epg state: %+v", epgCfg)
+			return nil, err
+		}
+	}
+
+	// the mac moves along to the new network
+	err = claimMac(stateDriver, newCfg)
+	if err != nil {
+		log.Errorf("Error setting mac for %s. Err: %v", newCfg.ID, err)
+		return nil, err
+	}
+
+	err = nwCfg.IncrEpCount()
+	if err != nil {
+		log.Errorf("Error incrementing ep count. Err: %v", err)
+		return nil, err
+	}
+
+	err = newCfg.Write()
+	if err != nil {
+		log.Errorf("error writing ep config. Error: %s", err)
+		return nil, err
+	}
+
+	dropMacOwner(stateDriver, epCfg.NetID, epCfg.MacAddress, epCfg.ID)
+	return newCfg, nil
+}
+
+// ReleaseEndpointNetwork frees the addresses held by endpoint epCfg in its
+// network and drops it from the endpoint counts of the network and group.
+// The endpoint config is left alone.
+func ReleaseEndpointNetwork(stateDriver core.StateDriver, epCfg *mastercfg.CfgEndpointState) error {
+	return releaseEndpointResources(stateDriver, epCfg)
+}
+
 // CreateEndpoints creates the endpoints for a given tenant.
 func CreateEndpoints(stateDriver core.StateDriver, tenant *intent.ConfigTenant) error {
 	err := validateEndpointConfig(stateDriver, tenant)
@@ -199,6 +970,9 @@ func CreateEndpoints(stateDriver core.StateDriver, tenant *intent.ConfigTenant)
 		return err
 	}
 
+	addrMutex.Lock()
+	defer addrMutex.Unlock()
+
 	for _, network := range tenant.Networks {
 		nwCfg := &mastercfg.CfgNetworkState{}
 		nwCfg.StateDriver = stateDriver
@@ -286,35 +1060,68 @@ func DeleteEndpoints(hostAddr string) error {
 // DeleteEndpointID deletes an endpoint by ID.
 func DeleteEndpointID(stateDriver core.StateDriver, epID string) (*mastercfg.CfgEndpointState, error) {
 	epCfg := &mastercfg.CfgEndpointState{}
-	var epgCfg *mastercfg.EndpointGroupState
-
 	epCfg.StateDriver = stateDriver
 	err := epCfg.Read(epID)
 	if err != nil {
 		return nil, err
 	}
 
+	if err := releaseEndpointResources(stateDriver, epCfg); err != nil {
+		return nil, err
+	}
+
+	// drop the lease first, so that the homing host tells this delete from
+	// an expiry
+	clearEndpointLease(stateDriver, epCfg)
+
+	// Even if network not present (already deleted), cleanup ep cfg
+	err = epCfg.Clear()
+	if err != nil {
+		log.Errorf("error writing ep config. Error: %s", err)
+		return nil, err
+	}
+
+	releaseStickyMac(stateDriver, epCfg)
+
+	return epCfg, err
+}
+
+// releaseEndpointResources frees the addresses of an endpoint and drops it
+// from the endpoint counts of its network and group
+func releaseEndpointResources(stateDriver core.StateDriver, epCfg *mastercfg.CfgEndpointState) error {
+	var epgCfg *mastercfg.EndpointGroupState
+
 	nwCfg := &mastercfg.CfgNetworkState{}
 	nwCfg.StateDriver = stateDriver
-	err = nwCfg.Read(epCfg.NetID)
+	err := nwCfg.Read(epCfg.NetID)
 
 	// Network may already be deleted if infra nw
 	// If network present, free up nw resources
-	if err == nil && epCfg.IPAddress != "" {
+	if err == nil && (epCfg.IPAddress != "" || epCfg.IPv6Address != "") {
 		if len(epCfg.ServiceName) > 0 {
 			epgCfg = &mastercfg.EndpointGroupState{}
 			epgCfg.StateDriver = stateDriver
 			if err := epgCfg.Read(epCfg.ServiceName + ":" + nwCfg.Tenant); err != nil {
 				log.Errorf("failed to read endpoint group %s, error %s",
 					epCfg.ServiceName+":"+epgCfg.TenantName, err)
-				return nil, err
+				return err
 			}
 		}
 
-		err = networkReleaseAddress(nwCfg, epgCfg, epCfg.IPAddress)
-		if err != nil {
-			log.Errorf("Error releasing endpoint state for: %s. Err: %v", epCfg.IPAddress, err)
+		for _, addr := range []string{epCfg.IPAddress, epCfg.IPv6Address} {
+			if addr == "" {
+				continue
+			}
+			// IPv6 addresses are not allocated from the group pools
+			addrEpg := epgCfg
+			if netutils.IsIPv6(addr) {
+				addrEpg = nil
+			}
+			if err := networkReleaseAddress(nwCfg, addrEpg, addr); err != nil {
+				log.Errorf("Error releasing endpoint state for: %s. Err: %v", addr, err)
+			}
 		}
+		releaseSecondaryAddresses(epCfg, nwCfg)
 
 		if epCfg.EndpointGroupKey != "" {
 			epgCfg := &mastercfg.EndpointGroupState{}
@@ -343,14 +1150,7 @@ func DeleteEndpointID(stateDriver core.StateDriver, epID string) (*mastercfg.Cfg
 		}
 	}
 
-	// Even if network not present (already deleted), cleanup ep cfg
-	err = epCfg.Clear()
-	if err != nil {
-		log.Errorf("error writing ep config. Error: %s", err)
-		return nil, err
-	}
-
-	return epCfg, err
+	return nil
 }
 
 func validateEpBindings(epBindings *[]intent.ConfigEP) error {
@@ -0,0 +1,119 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package master
+
+import (
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+)
+
+// writeEndpointLease starts the lease of an endpoint with a lease ttl
+func writeEndpointLease(stateDriver core.StateDriver, epCfg *mastercfg.CfgEndpointState) error {
+	lease := &mastercfg.EndpointLeaseState{
+		TTL:    epCfg.LeaseTTL,
+		Expiry: time.Now().Unix() + int64(epCfg.LeaseTTL),
+	}
+	lease.ID = epCfg.ID
+	lease.StateDriver = stateDriver
+	return lease.Write()
+}
+
+// clearEndpointLease drops the lease of an endpoint being deleted. Expired
+// leases are kept, they tell the homing host that the endpoint expired and
+// are removed by ReapExpiredEndpoints.
+func clearEndpointLease(stateDriver core.StateDriver, epCfg *mastercfg.CfgEndpointState) {
+	if epCfg.LeaseTTL == 0 {
+		return
+	}
+	lease := &mastercfg.EndpointLeaseState{}
+	lease.StateDriver = stateDriver
+	if err := lease.Read(epCfg.ID); err != nil || lease.Expired {
+		return
+	}
+	if err := lease.Clear(); err != nil {
+		log.Errorf("error dropping the lease of ep %s. Error: %s", epCfg.ID, err)
+	}
+}
+
+// RenewEndpointLease pushes back the expiry of the lease of endpoint epID by
+// its ttl. It fails once the lease expired or the endpoint was deleted.
+func RenewEndpointLease(stateDriver core.StateDriver, epID string) error {
+	lease := &mastercfg.EndpointLeaseState{}
+	lease.StateDriver = stateDriver
+	if err := lease.Read(epID); err != nil {
+		return core.Errorf("endpoint %s has no lease", epID)
+	}
+	if lease.Expired {
+		return core.Errorf("lease of endpoint %s expired", epID)
+	}
+
+	lease.Expiry = time.Now().Unix() + int64(lease.TTL)
+	return lease.Write()
+}
+
+// EndpointLeaseExpired returns true when endpoint epID was removed by
+// ReapExpiredEndpoints rather than deleted
+func EndpointLeaseExpired(stateDriver core.StateDriver, epID string) bool {
+	lease := &mastercfg.EndpointLeaseState{}
+	lease.StateDriver = stateDriver
+	return lease.Read(epID) == nil && lease.Expired
+}
+
+// ReapExpiredEndpoints deletes the endpoints whose lease is past its expiry.
+// The lease is marked expired first and kept for another ttl, for the
+// homing host to remove the endpoint port.
+func ReapExpiredEndpoints(stateDriver core.StateDriver) error {
+	addrMutex.Lock()
+	defer addrMutex.Unlock()
+
+	lease := &mastercfg.EndpointLeaseState{}
+	lease.StateDriver = stateDriver
+	states, err := lease.ReadAll()
+	if core.ErrIfKeyExists(err) != nil {
+		return err
+	}
+
+	now := time.Now().Unix()
+	for _, state := range states {
+		lease := state.(*mastercfg.EndpointLeaseState)
+		lease.StateDriver = stateDriver
+		if lease.Expired {
+			if now > lease.Expiry+int64(lease.TTL) {
+				if err := lease.Clear(); err != nil {
+					log.Errorf("Error removing the lease of endpoint %s. Err: %v", lease.ID, err)
+				}
+			}
+			continue
+		}
+		if now <= lease.Expiry {
+			continue
+		}
+
+		lease.Expired = true
+		if err := lease.Write(); err != nil {
+			log.Errorf("Error expiring the lease of endpoint %s. Err: %v", lease.ID, err)
+			continue
+		}
+		log.Warnf("Lease of endpoint %s expired, removing it", lease.ID)
+		if _, err := DeleteEndpointID(stateDriver, lease.ID); err != nil {
+			log.Errorf("Error removing expired endpoint %s. Err: %v", lease.ID, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,213 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package master
+
+import (
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+)
+
+// RemoveEndpointID deletes an endpoint by ID. With an endpoint retention set
+// the endpoint is moved to the recycle bin, its config is removed so that
+// the agents tear it down but its addresses stay allocated for
+// RestoreEndpointID. Force, or no retention, deletes it like
+// DeleteEndpointID.
+func RemoveEndpointID(stateDriver core.StateDriver, epID string, force bool) (*mastercfg.CfgEndpointState, error) {
+	if force || masterRTCfg.endpointRetention <= 0 {
+		return DeleteEndpointID(stateDriver, epID)
+	}
+	return RecycleEndpointID(stateDriver, epID)
+}
+
+// RecycleEndpointID moves an endpoint to the recycle bin whatever the
+// endpoint retention
+func RecycleEndpointID(stateDriver core.StateDriver, epID string) (*mastercfg.CfgEndpointState, error) {
+	epCfg := &mastercfg.CfgEndpointState{}
+	epCfg.StateDriver = stateDriver
+	if err := epCfg.Read(epID); err != nil {
+		return nil, err
+	}
+
+	// an endpoint recreated with the id of a recycled one replaces it
+	recycled := &mastercfg.RecycledEndpointState{}
+	recycled.StateDriver = stateDriver
+	if err := recycled.Read(epID); err == nil {
+		if err := purgeRecycledEndpoint(stateDriver, recycled); err != nil {
+			return nil, err
+		}
+	}
+
+	// drop the lease first, so that the homing host tells this delete from
+	// an expiry
+	clearEndpointLease(stateDriver, epCfg)
+
+	recycled.ID = epID
+	recycled.Endpoint = *epCfg
+	recycled.DeletedAt = time.Now().Unix()
+	if err := recycled.Write(); err != nil {
+		log.Errorf("error writing recycled ep %s. Error: %s", epID, err)
+		return nil, err
+	}
+
+	if err := epCfg.Clear(); err != nil {
+		log.Errorf("error writing ep config. Error: %s", err)
+		recycled.Clear()
+		return nil, err
+	}
+
+	log.Infof("Moved endpoint %s to the recycle bin", epID)
+	return epCfg, nil
+}
+
+// RestoreEndpointID brings back an endpoint from the recycle bin with the
+// addresses it had. Its config is written again, the agents program it on
+// the remote hosts, the caller on its homing host.
+func RestoreEndpointID(stateDriver core.StateDriver, epID string) (*mastercfg.CfgEndpointState, error) {
+	recycled := &mastercfg.RecycledEndpointState{}
+	recycled.StateDriver = stateDriver
+	if err := recycled.Read(epID); err != nil {
+		return nil, core.Errorf("endpoint %s is not in the recycle bin", epID)
+	}
+
+	epCfg := &mastercfg.CfgEndpointState{}
+	epCfg.StateDriver = stateDriver
+	if err := epCfg.Read(epID); err == nil {
+		return nil, core.Errorf("endpoint %s already exists", epID)
+	}
+
+	nwCfg := &mastercfg.CfgNetworkState{}
+	nwCfg.StateDriver = stateDriver
+	if err := nwCfg.Read(recycled.Endpoint.NetID); err != nil {
+		return nil, core.Errorf("network %s of endpoint %s not found", recycled.Endpoint.NetID, epID)
+	}
+	if nwCfg.Deleting {
+		return nil, core.Errorf("network %s of endpoint %s is being deleted", nwCfg.ID, epID)
+	}
+
+	*epCfg = recycled.Endpoint
+	epCfg.StateDriver = stateDriver
+	if err := epCfg.Write(); err != nil {
+		log.Errorf("error writing ep config. Error: %s", err)
+		return nil, err
+	}
+	if epCfg.LeaseTTL != 0 {
+		if err := writeEndpointLease(stateDriver, epCfg); err != nil {
+			log.Errorf("error writing the lease of ep %s. Error: %s", epID, err)
+		}
+	}
+	if err := recycled.Clear(); err != nil {
+		log.Errorf("error clearing recycled ep %s. Error: %s", epID, err)
+	}
+
+	log.Infof("Restored endpoint %s from the recycle bin", epID)
+	return epCfg, nil
+}
+
+// ReapRecycledEndpoints removes for good the endpoints that have been in the
+// recycle bin for longer than the endpoint retention
+func ReapRecycledEndpoints(stateDriver core.StateDriver) error {
+	addrMutex.Lock()
+	defer addrMutex.Unlock()
+
+	recycled := &mastercfg.RecycledEndpointState{}
+	recycled.StateDriver = stateDriver
+	states, err := recycled.ReadAll()
+	if core.ErrIfKeyExists(err) != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(-masterRTCfg.endpointRetention).Unix()
+	for _, state := range states {
+		rec := state.(*mastercfg.RecycledEndpointState)
+		if rec.DeletedAt > deadline {
+			continue
+		}
+		if err := purgeRecycledEndpoint(stateDriver, rec); err != nil {
+			log.Errorf("Error removing recycled endpoint %s. Err: %v", rec.ID, err)
+		}
+	}
+	return nil
+}
+
+// recycledEndpointCount returns the number of recycled endpoints of network
+// netID, they still count in the endpoints of the network
+func recycledEndpointCount(stateDriver core.StateDriver, netID string) int {
+	recycled := &mastercfg.RecycledEndpointState{}
+	recycled.StateDriver = stateDriver
+	states, err := recycled.ReadAll()
+	if core.ErrIfKeyExists(err) != nil {
+		log.Errorf("Error reading the recycled endpoints. Err: %v", err)
+		return 0
+	}
+
+	count := 0
+	for _, state := range states {
+		if state.(*mastercfg.RecycledEndpointState).Endpoint.NetID == netID {
+			count++
+		}
+	}
+	return count
+}
+
+// purgeRecycledEndpoints removes for good the recycled endpoints of network
+// netID, before the network is deleted
+func purgeRecycledEndpoints(stateDriver core.StateDriver, netID string) error {
+	recycled := &mastercfg.RecycledEndpointState{}
+	recycled.StateDriver = stateDriver
+	states, err := recycled.ReadAll()
+	if core.ErrIfKeyExists(err) != nil {
+		return err
+	}
+
+	for _, state := range states {
+		rec := state.(*mastercfg.RecycledEndpointState)
+		if rec.Endpoint.NetID != netID {
+			continue
+		}
+		if err := purgeRecycledEndpoint(stateDriver, rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// purgeRecycledEndpoint frees the resources of a recycled endpoint and
+// removes it from the recycle bin
+func purgeRecycledEndpoint(stateDriver core.StateDriver, rec *mastercfg.RecycledEndpointState) error {
+	rec.StateDriver = stateDriver
+	epCfg := rec.Endpoint
+	epCfg.StateDriver = stateDriver
+
+	live := &mastercfg.CfgEndpointState{}
+	live.StateDriver = stateDriver
+	exists := live.Read(rec.ID) == nil
+	if exists && live.IPAddress == epCfg.IPAddress {
+		// restored while being purged, its addresses are in use again
+		return rec.Clear()
+	}
+
+	if err := releaseEndpointResources(stateDriver, &epCfg); err != nil {
+		return err
+	}
+	if !exists {
+		releaseStickyMac(stateDriver, &epCfg)
+	}
+	log.Infof("Removed recycled endpoint %s", rec.ID)
+	return rec.Clear()
+}
@@ -18,6 +18,8 @@ package master
 import (
 	"errors"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/contiv/netplugin/core"
 	"github.com/contiv/netplugin/netmaster/gstate"
@@ -35,7 +37,10 @@ const (
 
 // Run Time config of netmaster
 type nmRunTimeConf struct {
-	clusterMode string
+	clusterMode        string
+	disableStickyMac   bool
+	networkDeleteGrace time.Duration
+	endpointRetention  time.Duration
 }
 
 var masterRTCfg nmRunTimeConf
@@ -60,9 +65,49 @@ func GetClusterMode() string {
 	return masterRTCfg.clusterMode
 }
 
+// SetStickyMac enables or disables reusing the mac of a deleted endpoint
+// when an endpoint with the same id is created again
+func SetStickyMac(enable bool) {
+	masterRTCfg.disableStickyMac = !enable
+}
+
+// SetNetworkDeleteGrace sets the time a deleted network with endpoints is
+// kept for its endpoints to drain, 0 fails such deletes
+func SetNetworkDeleteGrace(grace time.Duration) {
+	masterRTCfg.networkDeleteGrace = grace
+}
+
+// SetEndpointRetention sets the time a deleted endpoint is kept in the
+// recycle bin before it is removed for good, 0 removes endpoints at once
+func SetEndpointRetention(retention time.Duration) {
+	masterRTCfg.endpointRetention = retention
+}
+
+// ContainerEpID returns the id of the endpoint of container containerID on
+// network netID, <netID>-<containerID>, e.g. orange.default-c1, the id the
+// docker and k8s plugins give their endpoints too. Network ids may contain
+// a dash, the id is only unique for container ids accepted by
+// ValidateContainerID.
+func ContainerEpID(netID, containerID string) string {
+	return netID + "-" + containerID
+}
+
+// ValidateContainerID checks that containerID can be part of an endpoint id.
+// Container ids with a dash are refused, the endpoint id then splits at its
+// last dash and no two network and container ids give the same endpoint id.
+func ValidateContainerID(containerID string) error {
+	if containerID == "" {
+		return core.Errorf("container id is required")
+	}
+	if strings.Contains(containerID, "-") {
+		return core.Errorf("invalid container id %q, it must not contain '-'", containerID)
+	}
+	return nil
+}
+
 func getEpName(networkName string, ep *intent.ConfigEP) string {
 	if ep.Container != "" {
-		return networkName + "-" + ep.Container
+		return ContainerEpID(networkName, ep.Container)
 	}
 
 	return ep.Host + "-native-intf"
@@ -17,8 +17,10 @@ package master
 
 import (
 	"encoding/json"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 
@@ -30,6 +32,7 @@ import (
 	"github.com/contiv/netplugin/netmaster/resources"
 	"github.com/contiv/netplugin/state"
 	"github.com/contiv/netplugin/utils"
+	"github.com/contiv/netplugin/utils/netutils"
 )
 
 var fakeDriver *state.FakeStateDriver
@@ -716,3 +719,1487 @@ func TestGetNwAndEpgFromAddrReq(t *testing.T) {
 		assertOnTrue(t, e != d.epgName, fmt.Sprintf("epgname mismatch [%s] != [%s]", e, d.epgName))
 	}
 }
+
+func TestCheckSecondaryAddr(t *testing.T) {
+	nwCfg := &mastercfg.CfgNetworkState{
+		SubnetIP:      "10.1.1.0",
+		SubnetLen:     24,
+		IPv6Subnet:    "2001::",
+		IPv6SubnetLen: 100,
+		IPv6AllocMap:  map[string]bool{},
+	}
+	nwCfg.ID = "orange.default"
+	nwCfg.IPAllocMap.Set(5)
+
+	testData := []struct {
+		addr       string
+		shouldFail bool
+	}{
+		{"10.1.1.10", false},
+		{"10.1.1.5", true},
+		{"10.1.2.10", true},
+		{"10.1.1", true},
+		{"2001::10", false},
+		{"2002::10", true},
+	}
+
+	for _, d := range testData {
+		err := checkAddrInNetwork(nwCfg, d.addr)
+		assertOnTrue(t, (err != nil) != d.shouldFail,
+			fmt.Sprintf("secondary address %s: unexpected result %v", d.addr, err))
+	}
+}
+
+func TestAllocSecondaryAddrFailure(t *testing.T) {
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	nwCfg := &mastercfg.CfgNetworkState{SubnetIP: "10.1.1.0", SubnetLen: 24}
+	nwCfg.ID = "orange.default"
+	nwCfg.StateDriver = fakeDriver
+	epCfg := &mastercfg.CfgEndpointState{}
+	ep := &intent.ConfigEP{Container: "myContainer1", IPAddress: "10.1.1.5", SecondaryIPs: []string{"10.1.2.10"}}
+
+	err := allocSetEpAddress(ep, epCfg, nwCfg, nil)
+	assertOnTrue(t, err == nil, "secondary address outside the subnet allocated")
+	assertOnTrue(t, epCfg.IPAddress != "", "primary address left on a failed allocation")
+	assertOnTrue(t, ListAllocatedIPs(nwCfg) != "",
+		fmt.Sprintf("primary address %s not released on a failed allocation", ListAllocatedIPs(nwCfg)))
+}
+
+func TestValidateNetworkSubnet(t *testing.T) {
+	testData := []struct {
+		subnet     string
+		gateway    string
+		ipv6Subnet string
+		ipv6Gw     string
+		numEps     int
+		shouldFail bool
+	}{
+		{"10.1.1.0/24", "10.1.1.254", "", "", 2, false},
+		{"10.1.1.10-10.1.1.20/24", "10.1.1.254", "", "", 1, false},
+		{"10.1.1.0/24", "10.1.2.254", "", "", 0, true},
+		{"10.1.1.0/24", "10.1.1.0", "", "", 0, true},
+		{"10.1.1.0/24", "10.1.1.255", "", "", 0, true},
+		{"10.1.1.0/24", "abc", "", "", 0, true},
+		{"10.1.1.0", "", "", "", 0, true},
+		{"10.1.1.0/33", "", "", "", 0, true},
+		{"10.1.1.1/32", "", "", "", 2, true},
+		{"10.1.1.0/30", "10.1.1.1", "", "", 1, false},
+		{"10.1.1.0/30", "10.1.1.1", "", "", 2, true},
+		{"10.1.1.0/24", "", "2001::/100", "2001::1", 0, false},
+		{"10.1.1.0/24", "", "2001::/100", "2002::1", 0, true},
+		{"10.1.1.0/24", "", "", "2001::1", 0, true},
+		{"", "", "2001::/100", "2001::1", 2, false},
+		{"", "10.1.1.254", "2001::/100", "", 0, true},
+		{"", "", "", "", 0, true},
+	}
+
+	for _, d := range testData {
+		network := intent.ConfigNetwork{
+			Name:           "orange",
+			SubnetCIDR:     d.subnet,
+			Gateway:        d.gateway,
+			IPv6SubnetCIDR: d.ipv6Subnet,
+			IPv6Gateway:    d.ipv6Gw,
+			Endpoints:      make([]intent.ConfigEP, d.numEps),
+		}
+		err := validateNetworkSubnet(&network)
+		assertOnTrue(t, (err != nil) != d.shouldFail,
+			fmt.Sprintf("subnet %s gateway %s: unexpected result %v", d.subnet, d.gateway, err))
+	}
+}
+
+func TestValidateBridgeFailMode(t *testing.T) {
+	for failMode, shouldFail := range map[string]bool{
+		"":           false,
+		"secure":     true,
+		"standalone": true,
+	} {
+		network := intent.ConfigNetwork{
+			Name:       "orange",
+			SubnetCIDR: "10.1.1.0/24",
+			FailMode:   failMode,
+		}
+		err := validateNetworkSubnet(&network)
+		assertOnTrue(t, (err != nil) != shouldFail,
+			fmt.Sprintf("fail mode %q: unexpected result %v", failMode, err))
+	}
+}
+
+func TestValidateBridgeOptions(t *testing.T) {
+	for _, network := range []intent.ConfigNetwork{
+		{EnableSTP: true},
+		{EnableMcastSnooping: true},
+		{AgeingTime: 300},
+	} {
+		network.Name = "orange"
+		network.SubnetCIDR = "10.1.1.0/24"
+		err := validateNetworkSubnet(&network)
+		assertOnTrue(t, err == nil, fmt.Sprintf("bridge options of network %+v accepted", network))
+	}
+}
+
+func TestValidateDupAddrDetect(t *testing.T) {
+	testData := []struct {
+		mode       string
+		timeout    int
+		shouldFail bool
+	}{
+		{"", 0, false},
+		{mastercfg.DupAddrDetectWarn, 0, false},
+		{mastercfg.DupAddrDetectFail, mastercfg.MaxDupAddrTimeout, false},
+		{"drop", 0, true},
+		{mastercfg.DupAddrDetectFail, -1, true},
+		{mastercfg.DupAddrDetectFail, mastercfg.MaxDupAddrTimeout + 1, true},
+	}
+
+	for _, d := range testData {
+		network := intent.ConfigNetwork{
+			Name:           "orange",
+			SubnetCIDR:     "10.1.1.0/24",
+			DupAddrDetect:  d.mode,
+			DupAddrTimeout: d.timeout,
+		}
+		err := validateNetworkSubnet(&network)
+		assertOnTrue(t, (err != nil) != d.shouldFail,
+			fmt.Sprintf("mode %q timeout %d: unexpected result %v", d.mode, d.timeout, err))
+	}
+}
+
+func TestStickyMac(t *testing.T) {
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	newEp := func(id, mac string) *mastercfg.CfgEndpointState {
+		epCfg := &mastercfg.CfgEndpointState{NetID: "orange.default", MacAddress: mac}
+		epCfg.ID = id
+		return epCfg
+	}
+
+	epCfg := newEp("orange.default-cont1", "02:02:0a:01:01:01")
+	if err := setStickyMac(fakeDriver, epCfg); err != nil {
+		t.Fatalf("error setting sticky mac. Error: %s", err)
+	}
+	releaseStickyMac(fakeDriver, epCfg)
+
+	// recreated endpoint gets a different address, but keeps its mac
+	epCfg = newEp("orange.default-cont1", "02:02:0a:01:01:02")
+	if err := setStickyMac(fakeDriver, epCfg); err != nil {
+		t.Fatalf("error setting sticky mac. Error: %s", err)
+	}
+	if epCfg.MacAddress != "02:02:0a:01:01:01" {
+		t.Fatalf("mac was not reused, got %s", epCfg.MacAddress)
+	}
+
+	// the mac derived from the old address of cont1 is not given to the
+	// endpoint that gets the address
+	epCfg4 := newEp("orange.default-cont4", "02:02:0a:01:01:01")
+	if err := setStickyMac(fakeDriver, epCfg4); err != nil {
+		t.Fatalf("error setting sticky mac. Error: %s", err)
+	}
+	if epCfg4.MacAddress != dhcpEpMac(epCfg4.ID) {
+		t.Fatalf("mac %s held by another endpoint given to %s", epCfg4.MacAddress, epCfg4.ID)
+	}
+	if owner, err := macOwner(fakeDriver, "orange.default", "02:02:0A:01:01:01"); err != nil || owner != "orange.default-cont1" {
+		t.Fatalf("unexpected owner %q of the sticky mac. Error: %v", owner, err)
+	}
+
+	// a mac requested by another endpoint of the network is not reused
+	releaseStickyMac(fakeDriver, epCfg)
+	other := newEp("orange.default-cont3", "02:02:0a:01:01:01")
+	if err := claimMac(fakeDriver, other); err != nil {
+		t.Fatalf("error claiming mac. Error: %s", err)
+	}
+	epCfg = newEp("orange.default-cont1", "02:02:0a:01:01:05")
+	if err := setStickyMac(fakeDriver, epCfg); err != nil {
+		t.Fatalf("error setting sticky mac. Error: %s", err)
+	}
+	if epCfg.MacAddress != "02:02:0a:01:01:05" {
+		t.Fatalf("mac %s of another endpoint reused", epCfg.MacAddress)
+	}
+
+	// a requested mac is released with its endpoint
+	releaseStickyMac(fakeDriver, other)
+	if owner, err := macOwner(fakeDriver, "orange.default", other.MacAddress); err != nil || owner != "" {
+		t.Fatalf("requested mac still held by %q. Error: %v", owner, err)
+	}
+
+	// long released macs are garbage collected, along with their owner
+	cont2 := newEp("orange.default-cont2", "02:02:0a:01:01:03")
+	if err := setStickyMac(fakeDriver, cont2); err != nil {
+		t.Fatalf("error setting sticky mac. Error: %s", err)
+	}
+	macState := &mastercfg.EndpointMacState{NetID: "orange.default", MacAddress: "02:02:0a:01:01:03"}
+	macState.StateDriver = fakeDriver
+	macState.ID = "orange.default-cont2"
+	macState.ReleasedAt = time.Now().Add(-2 * stickyMacMaxAge)
+	if err := macState.Write(); err != nil {
+		t.Fatalf("error writing mac state. Error: %s", err)
+	}
+	releaseStickyMac(fakeDriver, epCfg)
+	if err := macState.Read(macState.ID); err == nil {
+		t.Fatalf("stale mac state was not garbage collected")
+	}
+	if owner, err := macOwner(fakeDriver, "orange.default", "02:02:0a:01:01:03"); err != nil || owner != "" {
+		t.Fatalf("garbage collected mac still held by %q. Error: %v", owner, err)
+	}
+
+	// stickiness can be disabled
+	SetStickyMac(false)
+	defer SetStickyMac(true)
+	epCfg = &mastercfg.CfgEndpointState{MacAddress: "02:02:0a:01:01:04"}
+	epCfg.ID = "orange.default-cont1"
+	if err := setStickyMac(fakeDriver, epCfg); err != nil {
+		t.Fatalf("error setting sticky mac. Error: %s", err)
+	}
+	if epCfg.MacAddress != "02:02:0a:01:01:04" {
+		t.Fatalf("mac was reused with stickiness disabled")
+	}
+}
+
+func TestCheckQoSMarking(t *testing.T) {
+	testData := []struct {
+		dscp, cos  int
+		shouldFail bool
+	}{
+		{0, 0, false},
+		{46, 5, false},
+		{63, 7, false},
+		{64, 0, true},
+		{-1, 0, true},
+		{0, 8, true},
+		{0, -1, true},
+	}
+
+	for _, d := range testData {
+		err := checkQoSMarking(d.dscp, d.cos)
+		assertOnTrue(t, (err != nil) != d.shouldFail,
+			fmt.Sprintf("dscp %d cos %d: unexpected result %v", d.dscp, d.cos, err))
+	}
+}
+
+func TestCheckIntfSettings(t *testing.T) {
+	testData := []struct {
+		sysctls    map[string]string
+		features   map[string]bool
+		shouldFail bool
+	}{
+		{nil, nil, false},
+		{map[string]string{"net.ipv4.conf.eth0.rp_filter": "2"}, map[string]bool{"tso": false, "rx-checksumming": true}, false},
+		{map[string]string{"net.ipv6.conf.eth0/100.disable_ipv6": "1"}, nil, false},
+		{map[string]string{"kernel.pid_max": "4096"}, nil, true},
+		{map[string]string{"net..ipv4": "1"}, nil, true},
+		{map[string]string{"net.ipv4.ip_forward": ""}, nil, true},
+		{nil, map[string]bool{"TSO": true}, true},
+		{nil, map[string]bool{"tso on": true}, true},
+	}
+
+	for _, d := range testData {
+		err := checkIntfSettings(d.sysctls, d.features)
+		assertOnTrue(t, (err != nil) != d.shouldFail,
+			fmt.Sprintf("sysctls %v features %v: unexpected result %v", d.sysctls, d.features, err))
+	}
+}
+
+func TestCheckIntfFlags(t *testing.T) {
+	testData := []struct {
+		ep         intent.ConfigEP
+		shouldFail bool
+	}{
+		{intent.ConfigEP{}, false},
+		{intent.ConfigEP{Promisc: true, AllMulti: true}, false},
+		{intent.ConfigEP{AllMulti: true}, false},
+		{intent.ConfigEP{HostEndpoint: true}, false},
+		{intent.ConfigEP{Promisc: true, HostEndpoint: true}, true},
+		{intent.ConfigEP{AllMulti: true, Tap: true}, true},
+		{intent.ConfigEP{Promisc: true, Bond: &intent.ConfigBond{Mode: "lacp"}}, true},
+	}
+
+	for _, d := range testData {
+		err := checkIntfFlags(&d.ep)
+		assertOnTrue(t, (err != nil) != d.shouldFail,
+			fmt.Sprintf("endpoint %+v: unexpected result %v", d.ep, err))
+	}
+}
+
+func TestCheckHostIntfName(t *testing.T) {
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	epCfg := &mastercfg.CfgEndpointState{HomingHost: "host1", HostIntfName: "web-capture"}
+	epCfg.ID = "orange.default-ep1"
+	epCfg.StateDriver = fakeDriver
+	if err := epCfg.Write(); err != nil {
+		t.Fatalf("error writing endpoint state. Error: %s", err)
+	}
+
+	testData := []struct {
+		epID       string
+		host       string
+		name       string
+		shouldFail bool
+	}{
+		{"orange.default-ep2", "host1", "db-capture", false},
+		{"orange.default-ep2", "host1", "a-host-interface-name-longer-than-ifnamsiz", false},
+		{"orange.default-ep2", "host1", strings.Repeat("a", 127), false},
+		{"orange.default-ep2", "host1", strings.Repeat("a", 128), true},
+		{"orange.default-ep2", "host1", "db capture", true},
+		{"orange.default-ep2", "host1", "db/capture", true},
+		{"orange.default-ep2", "host1", "db:capture", true},
+		{"orange.default-ep2", "host1", "..", true},
+		{"orange.default-ep2", "host1", "web-capture", true},
+		{"orange.default-ep2", "host2", "web-capture", false},
+		{"orange.default-ep1", "host1", "web-capture", false},
+	}
+
+	for _, d := range testData {
+		err := checkHostIntfName(fakeDriver, d.epID, d.host, d.name)
+		assertOnTrue(t, (err != nil) != d.shouldFail,
+			fmt.Sprintf("host interface name %q of %s on %s: unexpected result %v", d.name, d.epID, d.host, err))
+	}
+}
+
+func TestContainerEpID(t *testing.T) {
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	for id, shouldFail := range map[string]bool{"c1": false, "0123abcd": false, "": true, "1-c1": true} {
+		err := ValidateContainerID(id)
+		assertOnTrue(t, (err != nil) != shouldFail, fmt.Sprintf("container id %q: unexpected result %v", id, err))
+	}
+
+	nwCfg := &mastercfg.CfgNetworkState{}
+	nwCfg.ID = "orange.default"
+	nwCfg.StateDriver = fakeDriver
+
+	// orange.default-1-c1 could also be the endpoint of c1 on orange.default-1
+	epReq := &CreateEndpointRequest{ConfigEP: intent.ConfigEP{Container: "1-c1"}}
+	if _, err := CreateEndpoint(fakeDriver, nwCfg, epReq); err == nil {
+		t.Fatalf("endpoint created for a container id with a dash")
+	}
+
+	// endpoints created before the check are still found
+	epCfg := &mastercfg.CfgEndpointState{}
+	epCfg.ID = ContainerEpID(nwCfg.ID, "1-c1")
+	epCfg.StateDriver = fakeDriver
+	if err := epCfg.Write(); err != nil {
+		t.Fatalf("error writing endpoint state. Err: %v", err)
+	}
+	if ep, err := CreateEndpoint(fakeDriver, nwCfg, epReq); err != nil || ep.ID != "orange.default-1-c1" {
+		t.Fatalf("existing endpoint not found. Err: %v", err)
+	}
+}
+
+func TestNetworkMTU(t *testing.T) {
+	testData := []struct {
+		pktTagType     string
+		encrypt        bool
+		underlayMTU    int
+		nwMTU          int
+		mtu            int
+		reqUnderlayMTU int
+	}{
+		{"vlan", false, 0, 0, 1500, 0},
+		{"vxlan", false, 0, 0, 1450, 0},
+		{"vlan", false, 9000, 0, 9000, 9000},
+		{"vxlan", false, 9000, 0, 8950, 9000},
+		{"vlan", false, 0, 9000, 9000, 9000},
+		{"vxlan", false, 0, 9000, 9000, 9050},
+		{"vxlan", true, 0, 0, 1397, 0},
+		{"vxlan", true, 0, 9000, 9000, 9103},
+	}
+
+	for _, d := range testData {
+		network := &intent.ConfigNetwork{PktTagType: d.pktTagType, Encrypt: d.encrypt,
+			UnderlayMTU: d.underlayMTU, MTU: d.nwMTU}
+		if mtu := networkMTU(network); mtu != d.mtu {
+			t.Fatalf("%s network with underlay mtu %d: expected mtu %d, got %d",
+				d.pktTagType, d.underlayMTU, d.mtu, mtu)
+		}
+		if mtu := underlayMTU(network); mtu != d.reqUnderlayMTU {
+			t.Fatalf("%s network with mtu %d: expected underlay mtu %d, got %d",
+				d.pktTagType, d.nwMTU, d.reqUnderlayMTU, mtu)
+		}
+	}
+}
+
+func TestValidateNetworkMTU(t *testing.T) {
+	testData := []struct {
+		pktTagType  string
+		underlayMTU int
+		mtu         int
+		shouldFail  bool
+	}{
+		{"vlan", 0, 9000, false},
+		{"vxlan", 9050, 9000, false},
+		{"vxlan", 9000, 9000, true},
+		{"vxlan", 0, 1450, false},
+		{"vxlan", 0, 1451, true},
+		{"vxlan", 0, 9000, true},
+		{"vlan", 0, 40, true},
+		{"vlan", 0, 70000, true},
+	}
+
+	for _, d := range testData {
+		network := intent.ConfigNetwork{
+			Name:        "orange",
+			SubnetCIDR:  "10.1.1.0/24",
+			PktTagType:  d.pktTagType,
+			UnderlayMTU: d.underlayMTU,
+			MTU:         d.mtu,
+		}
+		err := validateNetworkSubnet(&network)
+		assertOnTrue(t, (err != nil) != d.shouldFail,
+			fmt.Sprintf("%s mtu %d underlay %d: unexpected result %v", d.pktTagType, d.mtu, d.underlayMTU, err))
+	}
+}
+
+func TestEndpointRoutes(t *testing.T) {
+	nwCfg := &mastercfg.CfgNetworkState{SubnetIP: "10.1.1.0", SubnetLen: 24,
+		IPv6Subnet: "2016::", IPv6SubnetLen: 100}
+	nwCfg.ID = "orange.default"
+
+	testData := []struct {
+		route      intent.ConfigRoute
+		shouldFail bool
+	}{
+		{intent.ConfigRoute{Dest: "10.2.0.0/16", Gateway: "10.1.1.1"}, false},
+		{intent.ConfigRoute{Dest: "10.2.0.0/16", NextHops: []intent.ConfigNextHop{
+			{Gateway: "10.1.1.1", Weight: 3}, {Gateway: "10.1.1.2"}}}, false},
+		{intent.ConfigRoute{Dest: "2017::/64", NextHops: []intent.ConfigNextHop{
+			{Gateway: "2016::1"}, {Gateway: "2016::2", Weight: 256}}}, false},
+		{intent.ConfigRoute{Dest: "10.2.0.0", Gateway: "10.1.1.1"}, true},
+		{intent.ConfigRoute{Dest: "10.2.0.0/16"}, true},
+		{intent.ConfigRoute{Dest: "10.2.0.0/16", Gateway: "10.1.1.1", NextHops: []intent.ConfigNextHop{
+			{Gateway: "10.1.1.2"}}}, true},
+		{intent.ConfigRoute{Dest: "10.2.0.0/16", Gateway: "10.3.1.1"}, true},
+		{intent.ConfigRoute{Dest: "10.2.0.0/16", Gateway: "2016::1"}, true},
+		{intent.ConfigRoute{Dest: "10.2.0.0/16", NextHops: []intent.ConfigNextHop{
+			{Gateway: "10.1.1.1"}, {Gateway: "10.1.1.1"}}}, true},
+		{intent.ConfigRoute{Dest: "10.2.0.0/16", NextHops: []intent.ConfigNextHop{
+			{Gateway: "10.1.1.1", Weight: 257}}}, true},
+		{intent.ConfigRoute{Dest: "10.2.0.0/16", NextHops: []intent.ConfigNextHop{
+			{Gateway: "10.1.1.1", Weight: -1}}}, true},
+	}
+
+	for _, d := range testData {
+		routes, err := endpointRoutes(nwCfg, []intent.ConfigRoute{d.route})
+		assertOnTrue(t, (err != nil) != d.shouldFail,
+			fmt.Sprintf("route %+v: unexpected result %v", d.route, err))
+		if err == nil {
+			assertOnTrue(t, len(routes) != 1 || len(routes[0].NextHops) != len(d.route.NextHops),
+				fmt.Sprintf("route %+v: unexpected state %+v", d.route, routes))
+		}
+	}
+}
+
+func TestEndpointConntrackExemptions(t *testing.T) {
+	testData := []struct {
+		exemption  intent.ConfigConntrackExemption
+		shouldFail bool
+	}{
+		{intent.ConfigConntrackExemption{Protocol: "tcp", DstPort: 80}, false},
+		{intent.ConfigConntrackExemption{Protocol: "UDP", SrcIP: "10.1.1.0/24", DstPort: 53}, false},
+		{intent.ConfigConntrackExemption{Protocol: "sctp", SrcIP: "2016::1", DstIP: "2017::/64"}, false},
+		{intent.ConfigConntrackExemption{Protocol: "icmp", DstIP: "10.1.1.1"}, true},
+		{intent.ConfigConntrackExemption{DstPort: 80}, true},
+		{intent.ConfigConntrackExemption{Protocol: "tcp"}, true},
+		{intent.ConfigConntrackExemption{Protocol: "tcp", DstPort: 65536}, true},
+		{intent.ConfigConntrackExemption{Protocol: "tcp", SrcPort: -1}, true},
+		{intent.ConfigConntrackExemption{Protocol: "tcp", DstIP: "10.1.1"}, true},
+		{intent.ConfigConntrackExemption{Protocol: "tcp", DstIP: "10.1.1.0/33"}, true},
+		{intent.ConfigConntrackExemption{Protocol: "tcp", SrcIP: "10.1.1.1", DstIP: "2016::1"}, true},
+	}
+
+	for _, d := range testData {
+		exemptions, err := endpointConntrackExemptions([]intent.ConfigConntrackExemption{d.exemption})
+		assertOnTrue(t, (err != nil) != d.shouldFail,
+			fmt.Sprintf("exemption %+v: unexpected result %v", d.exemption, err))
+		if err == nil {
+			assertOnTrue(t, len(exemptions) != 1 || exemptions[0].Protocol != strings.ToLower(d.exemption.Protocol),
+				fmt.Sprintf("exemption %+v: unexpected state %+v", d.exemption, exemptions))
+		}
+	}
+
+	dup := intent.ConfigConntrackExemption{Protocol: "tcp", DstPort: 80}
+	_, err := endpointConntrackExemptions([]intent.ConfigConntrackExemption{dup, dup})
+	assertOnTrue(t, err == nil, "duplicate conntrack exemptions accepted")
+}
+
+func TestEndpointBond(t *testing.T) {
+	testData := []struct {
+		bond       intent.ConfigBond
+		shouldFail bool
+	}{
+		{intent.ConfigBond{Mode: "active-backup", Members: []string{"eth1", "eth2"}}, false},
+		{intent.ConfigBond{Mode: "lacp", Members: []string{"eth1", "eth2", "eth3"}}, false},
+		{intent.ConfigBond{Mode: "balance-rr", Members: []string{"eth1", "eth2"}}, true},
+		{intent.ConfigBond{Mode: "", Members: []string{"eth1", "eth2"}}, true},
+		{intent.ConfigBond{Mode: "lacp", Members: []string{"eth1"}}, true},
+		{intent.ConfigBond{Mode: "lacp", Members: []string{"eth1", "eth1"}}, true},
+		{intent.ConfigBond{Mode: "lacp", Members: []string{"eth1", "eth 2"}}, true},
+		{intent.ConfigBond{Mode: "lacp", Members: []string{"eth1", "averylonginterface"}}, true},
+	}
+
+	for _, d := range testData {
+		bond, err := endpointBond(&d.bond)
+		assertOnTrue(t, (err != nil) != d.shouldFail,
+			fmt.Sprintf("bond %+v: unexpected result %v", d.bond, err))
+		if err == nil {
+			assertOnTrue(t, bond.Mode != d.bond.Mode || len(bond.Members) != len(d.bond.Members),
+				fmt.Sprintf("bond %+v: unexpected state %+v", d.bond, bond))
+		}
+	}
+}
+
+func TestIPQuarantine(t *testing.T) {
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	nwCfg := &mastercfg.CfgNetworkState{
+		SubnetIP:     "10.1.1.0",
+		SubnetLen:    29,
+		IPQuarantine: 60,
+	}
+	nwCfg.ID = "orange.default"
+	nwCfg.StateDriver = fakeDriver
+	netutils.InitSubnetBitset(&nwCfg.IPAllocMap, nwCfg.SubnetLen)
+
+	alloc := func(reqAddr string, force bool) string {
+		addr, err := networkAllocAddress(nwCfg, nil, reqAddr, false, force)
+		if err != nil {
+			t.Fatalf("error allocating address %q. Error: %s", reqAddr, err)
+		}
+		return addr
+	}
+
+	first := alloc("", false)
+	if err := networkReleaseAddress(nwCfg, nil, first); err != nil {
+		t.Fatalf("error releasing address %s. Error: %s", first, err)
+	}
+	if addr := alloc("", false); addr == first {
+		t.Fatalf("address %s reallocated in quarantine", addr)
+	}
+	if _, err := networkAllocAddress(nwCfg, nil, first, false, false); err == nil {
+		t.Fatalf("requested address %s allocated in quarantine", first)
+	}
+	if addr := alloc("", true); addr != first {
+		t.Fatalf("forced allocation returned %s, expected %s", addr, first)
+	}
+	if _, quarantined := nwCfg.IPReleaseTimes[first]; quarantined {
+		t.Fatalf("allocated address %s still in quarantine", first)
+	}
+
+	// out of quarantine
+	second := alloc("", false)
+	if err := networkReleaseAddress(nwCfg, nil, second); err != nil {
+		t.Fatalf("error releasing address %s. Error: %s", second, err)
+	}
+	nwCfg.IPReleaseTimes[second] = time.Now().Add(-time.Minute).Unix()
+	if addr := alloc(second, false); addr != second {
+		t.Fatalf("requested address %s not allocated, got %s", second, addr)
+	}
+
+	// quarantined addresses are reused once the subnet is exhausted
+	third := alloc("", false)
+	if err := networkReleaseAddress(nwCfg, nil, third); err != nil {
+		t.Fatalf("error releasing address %s. Error: %s", third, err)
+	}
+	for {
+		addr, err := networkAllocAddress(nwCfg, nil, "", false, false)
+		if err != nil {
+			t.Fatalf("address exhaustion with address %s in quarantine", third)
+		}
+		if addr == third {
+			break
+		}
+	}
+}
+
+func TestAllocRanges(t *testing.T) {
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	testData := []struct {
+		ranges     map[string]string
+		shouldFail bool
+	}{
+		{map[string]string{"infra": "10.1.1.2-10.1.1.4", "apps": "10.1.1.5-10.1.1.254"}, false},
+		{map[string]string{"infra": "10.1.1.2-10.1.1.5", "apps": "10.1.1.5-10.1.1.254"}, true},
+		{map[string]string{"infra": "10.1.1.4-10.1.1.2"}, true},
+		{map[string]string{"infra": "10.1.2.2-10.1.2.4"}, true},
+		{map[string]string{"infra": "10.1.1.2"}, true},
+		{map[string]string{"": "10.1.1.2-10.1.1.4"}, true},
+	}
+	for _, d := range testData {
+		network := intent.ConfigNetwork{
+			Name:        "orange",
+			PktTagType:  "vlan",
+			SubnetCIDR:  "10.1.1.0/24",
+			AllocRanges: d.ranges,
+		}
+		err := validateNetworkSubnet(&network)
+		assertOnTrue(t, (err != nil) != d.shouldFail, fmt.Sprintf("ranges %v: unexpected result %v", d.ranges, err))
+	}
+
+	nwCfg := &mastercfg.CfgNetworkState{
+		SubnetIP:    "10.1.1.0",
+		SubnetLen:   29,
+		AllocRanges: map[string]string{"infra": "10.1.1.2-10.1.1.3"},
+	}
+	nwCfg.ID = "orange.default"
+	nwCfg.StateDriver = fakeDriver
+	netutils.InitSubnetBitset(&nwCfg.IPAllocMap, nwCfg.SubnetLen)
+
+	// endpoints without a range are kept out of the ranges
+	addr, err := networkAllocAddress(nwCfg, nil, "", false, false)
+	assertOnTrue(t, err != nil || addr != "10.1.1.1", fmt.Sprintf("unexpected address %s. Err: %v", addr, err))
+	addr, err = networkAllocAddress(nwCfg, nil, "", false, false)
+	assertOnTrue(t, err != nil || addr != "10.1.1.4", fmt.Sprintf("unexpected address %s. Err: %v", addr, err))
+
+	for _, exp := range []string{"10.1.1.2", "10.1.1.3"} {
+		addr, err := rangeAllocAddress(nwCfg, "infra", "", false)
+		assertOnTrue(t, err != nil || addr != exp, fmt.Sprintf("unexpected range address %s. Err: %v", addr, err))
+	}
+	_, err = rangeAllocAddress(nwCfg, "infra", "", false)
+	rangeErr, ok := err.(*RangeExhaustedError)
+	assertOnTrue(t, !ok || rangeErr.Range != "infra", fmt.Sprintf("unexpected exhaustion error %v", err))
+
+	_, err = rangeAllocAddress(nwCfg, "infra", "10.1.1.5", false)
+	assertOnTrue(t, err == nil, "address outside of the range allocated from it")
+	_, err = rangeAllocAddress(nwCfg, "apps", "", false)
+	assertOnTrue(t, err == nil, "address allocated from an unknown range")
+
+	if err := networkReleaseAddress(nwCfg, nil, "10.1.1.2"); err != nil {
+		t.Fatalf("error releasing address. Error: %s", err)
+	}
+	addr, err = rangeAllocAddress(nwCfg, "infra", "10.1.1.2", false)
+	assertOnTrue(t, err != nil || addr != "10.1.1.2", fmt.Sprintf("unexpected requested address %s. Err: %v", addr, err))
+}
+
+func TestValidateIPQuarantine(t *testing.T) {
+	network := intent.ConfigNetwork{
+		Name:         "orange",
+		SubnetCIDR:   "10.1.1.0/24",
+		IPQuarantine: -1,
+	}
+	assertOnTrue(t, validateNetworkSubnet(&network) == nil, "negative ip quarantine accepted")
+	network.IPQuarantine = 30
+	assertOnTrue(t, validateNetworkSubnet(&network) != nil, "ip quarantine rejected")
+}
+
+func TestOverlayKey(t *testing.T) {
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	if _, err := RotateOverlayKey(fakeDriver); err == nil {
+		t.Fatalf("rotating a missing overlay key succeeded")
+	}
+
+	if err := ensureOverlayKey(fakeDriver); err != nil {
+		t.Fatalf("error creating the overlay key. Err: %v", err)
+	}
+	keyCfg := &mastercfg.OverlayKeyState{}
+	keyCfg.StateDriver = fakeDriver
+	if err := keyCfg.Read(mastercfg.OverlayKeyID); err != nil {
+		t.Fatalf("error reading the overlay key. Err: %v", err)
+	}
+	if keyCfg.Generation != 1 || len(keyCfg.Key) != 2*overlayKeyLen {
+		t.Fatalf("unexpected overlay key %+v", keyCfg)
+	}
+	oldKey := keyCfg.Key
+
+	// an existing key is kept
+	if err := ensureOverlayKey(fakeDriver); err != nil {
+		t.Fatalf("error ensuring the overlay key. Err: %v", err)
+	}
+	if err := keyCfg.Read(mastercfg.OverlayKeyID); err != nil || keyCfg.Key != oldKey {
+		t.Fatalf("overlay key was replaced. Err: %v", err)
+	}
+
+	generation, err := RotateOverlayKey(fakeDriver)
+	if err != nil {
+		t.Fatalf("error rotating the overlay key. Err: %v", err)
+	}
+	if generation != 2 {
+		t.Fatalf("expected generation 2, got %d", generation)
+	}
+	if err := keyCfg.Read(mastercfg.OverlayKeyID); err != nil || keyCfg.Key == oldKey {
+		t.Fatalf("overlay key was not replaced. Err: %v", err)
+	}
+}
+
+func TestValidateNetworkEncrypt(t *testing.T) {
+	for _, pktTagType := range []string{"vlan", "vxlan"} {
+		network := intent.ConfigNetwork{
+			Name:       "orange",
+			PktTagType: pktTagType,
+			SubnetCIDR: "10.1.1.0/24",
+			Gateway:    "10.1.1.254",
+			Encrypt:    true,
+		}
+		err := validateNetworkSubnet(&network)
+		if pktTagType == "vlan" && err == nil {
+			t.Fatalf("encrypted vlan network passed validation")
+		}
+		if pktTagType == "vxlan" && err != nil {
+			t.Fatalf("encrypted vxlan network failed validation. Err: %v", err)
+		}
+	}
+}
+
+func TestValidateNetworkBUMMode(t *testing.T) {
+	testData := []struct {
+		pktTagType string
+		mode       string
+		shouldFail bool
+	}{
+		{"vlan", "", false},
+		{"vlan", mastercfg.BUMModeFlood, false},
+		{"vlan", mastercfg.BUMModeDrop, true},
+		{"vlan", mastercfg.BUMModeController, true},
+		{"vxlan", mastercfg.BUMModeDrop, false},
+		{"vxlan", mastercfg.BUMModeController, false},
+		{"vxlan", "replicate", true},
+	}
+
+	for _, d := range testData {
+		network := intent.ConfigNetwork{
+			Name:       "orange",
+			PktTagType: d.pktTagType,
+			SubnetCIDR: "10.1.1.0/24",
+			BUMMode:    d.mode,
+		}
+		err := validateNetworkSubnet(&network)
+		assertOnTrue(t, (err != nil) != d.shouldFail,
+			fmt.Sprintf("%s network with bum mode %q: unexpected result %v", d.pktTagType, d.mode, err))
+	}
+}
+
+func TestIPPoolUtilization(t *testing.T) {
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	testData := []struct {
+		subnet  string
+		gateway string
+		allocs  int
+		used    int
+		total   int
+	}{
+		{"10.1.1.0/24", "", 0, 0, 254},
+		{"10.1.1.0/24", "10.1.1.254", 3, 4, 254},
+		{"10.1.1.10-10.1.1.19/24", "", 2, 2, 10},
+		{"10.1.1.250-10.1.1.255/24", "", 1, 1, 5},
+	}
+
+	for _, d := range testData {
+		subnetIP, subnetLen, _ := netutils.ParseCIDR(d.subnet)
+		nwCfg := &mastercfg.CfgNetworkState{
+			SubnetIP:    netutils.GetSubnetAddr(subnetIP, subnetLen),
+			SubnetLen:   subnetLen,
+			IPAddrRange: netutils.GetIPAddrRange(subnetIP, subnetLen),
+		}
+		nwCfg.ID = "orange.default"
+		nwCfg.StateDriver = fakeDriver
+		netutils.InitSubnetBitset(&nwCfg.IPAllocMap, subnetLen)
+		if strings.Contains(subnetIP, "-") {
+			netutils.SetBitsOutsideRange(&nwCfg.IPAllocMap, subnetIP, subnetLen)
+		}
+		if d.gateway != "" {
+			if _, err := networkAllocAddress(nwCfg, nil, d.gateway, false, false); err != nil {
+				t.Fatalf("error reserving gateway %s. Err: %v", d.gateway, err)
+			}
+		}
+		for i := 0; i < d.allocs; i++ {
+			if _, err := networkAllocAddress(nwCfg, nil, "", false, false); err != nil {
+				t.Fatalf("error allocating an address of %s. Err: %v", d.subnet, err)
+			}
+		}
+
+		used, total, err := IPPoolUtilization(nwCfg)
+		if err != nil {
+			t.Fatalf("error computing the utilization of %s. Err: %v", d.subnet, err)
+		}
+		if used != d.used || total != d.total {
+			t.Fatalf("%s: expected %d/%d addresses used, got %d/%d", d.subnet, d.used, d.total, used, total)
+		}
+	}
+
+	nwCfg := &mastercfg.CfgNetworkState{IPAM: mastercfg.IPAMDhcp}
+	if _, _, err := IPPoolUtilization(nwCfg); err == nil {
+		t.Fatalf("utilization of a dhcp network succeeded")
+	}
+}
+
+func TestNetworkDeleteGrace(t *testing.T) {
+	cfgBytes := []byte(`{
+    "Tenants" : [{
+        "Name"                  : "tenant-one",
+        "Networks"  : [{
+            "Name"              : "orange",
+            "SubnetCIDR"        : "10.1.1.1/24",
+            "Endpoints" : [{ "Container" : "myContainer1" }, { "Container" : "myContainer2" }]
+        },
+        {
+            "Name"              : "purple",
+            "SubnetCIDR"        : "10.1.2.1/24",
+            "Endpoints" : [{ "Container" : "myContainer3" }]
+        },
+        {
+            "Name"              : "green",
+            "SubnetCIDR"        : "10.1.3.1/24",
+            "Endpoints" : [{ "Container" : "myContainer4" }]
+        }]
+    }]}`)
+
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	applyConfig(t, cfgBytes)
+
+	_, err := resources.NewStateResourceManager(fakeDriver)
+	if err != nil {
+		t.Fatalf("state store initialization failed. Error: %s", err)
+	}
+	defer func() { resources.ReleaseStateResourceManager() }()
+
+	SetNetworkDeleteGrace(time.Minute)
+	defer SetNetworkDeleteGrace(0)
+
+	networkExists := func(netID string) bool {
+		nwCfg := &mastercfg.CfgNetworkState{}
+		nwCfg.StateDriver = fakeDriver
+		return nwCfg.Read(netID) == nil
+	}
+	endpointExists := func(epID string) bool {
+		epCfg := &mastercfg.CfgEndpointState{}
+		epCfg.StateDriver = fakeDriver
+		return epCfg.Read(epID) == nil
+	}
+	reap := func() {
+		if err := ReapDeletingNetworks(fakeDriver); err != nil {
+			t.Fatalf("error reaping networks. Err: %v", err)
+		}
+	}
+
+	for _, netID := range []string{"orange.tenant-one", "purple.tenant-one", "green.tenant-one"} {
+		if err := DeleteNetworkID(fakeDriver, netID); err != nil {
+			t.Fatalf("error deleting network %s with endpoints. Err: %v", netID, err)
+		}
+	}
+	nwCfg := &mastercfg.CfgNetworkState{}
+	nwCfg.StateDriver = fakeDriver
+	if err := nwCfg.Read("orange.tenant-one"); err != nil || !nwCfg.Deleting {
+		t.Fatalf("network not marked as deleting. Err: %v", err)
+	}
+
+	// a network being deleted takes no new endpoints
+	epReq := &CreateEndpointRequest{ConfigEP: intent.ConfigEP{Container: "myContainer5"}}
+	if _, err := CreateEndpoint(fakeDriver, nwCfg, epReq); err == nil {
+		t.Fatalf("endpoint created on a network being deleted")
+	}
+
+	// the network is removed once its endpoints are gone
+	reap()
+	assertOnTrue(t, !networkExists("orange.tenant-one"), "network removed with endpoints left")
+	for _, epID := range []string{"orange.tenant-one-myContainer1", "orange.tenant-one-myContainer2"} {
+		if _, err := DeleteEndpointID(fakeDriver, epID); err != nil {
+			t.Fatalf("error deleting endpoint %s. Err: %v", epID, err)
+		}
+	}
+	reap()
+	assertOnTrue(t, networkExists("orange.tenant-one"), "drained network not removed")
+
+	// or once its grace period elapsed, along with its endpoints
+	if err := nwCfg.Read("purple.tenant-one"); err != nil {
+		t.Fatalf("error reading network. Err: %v", err)
+	}
+	nwCfg.DeleteDeadline = time.Now().Add(-time.Second).Unix()
+	if err := nwCfg.Write(); err != nil {
+		t.Fatalf("error writing network. Err: %v", err)
+	}
+	reap()
+	assertOnTrue(t, networkExists("purple.tenant-one"), "network not removed after its grace period")
+	assertOnTrue(t, endpointExists("purple.tenant-one-myContainer3"), "endpoint left after its network was removed")
+
+	// a force delete does not wait
+	if err := ForceDeleteNetworkID(fakeDriver, "green.tenant-one"); err != nil {
+		t.Fatalf("error force deleting network. Err: %v", err)
+	}
+	assertOnTrue(t, networkExists("green.tenant-one"), "network not removed by a force delete")
+	assertOnTrue(t, endpointExists("green.tenant-one-myContainer4"), "endpoint left after a force delete")
+}
+
+func TestScheduleDeleteNetwork(t *testing.T) {
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	for _, id := range []string{"orange.default", "blue.default"} {
+		nwCfg := &mastercfg.CfgNetworkState{}
+		nwCfg.ID = id
+		nwCfg.StateDriver = fakeDriver
+		if err := nwCfg.Write(); err != nil {
+			t.Fatalf("error writing network state. Error: %s", err)
+		}
+	}
+	scheduled := func(id string) bool {
+		sched := &mastercfg.ScheduledDeleteState{}
+		sched.StateDriver = fakeDriver
+		return sched.Read(id) == nil
+	}
+	deleted := []string{}
+	var deleteErr error
+	deleteNetwork := func(nwCfg *mastercfg.CfgNetworkState) error {
+		if deleteErr != nil {
+			return deleteErr
+		}
+		deleted = append(deleted, nwCfg.ID)
+		return nwCfg.Clear()
+	}
+	run := func(now time.Time) {
+		if err := RunScheduledDeletes(fakeDriver, now, deleteNetwork); err != nil {
+			t.Fatalf("error running the scheduled deletes. Error: %s", err)
+		}
+	}
+
+	at := time.Now().Add(time.Hour)
+	if err := ScheduleDeleteNetwork(fakeDriver, "green.default", at); err == nil {
+		t.Fatalf("delete of an unknown network scheduled")
+	}
+	if err := ScheduleDeleteNetwork(fakeDriver, "orange.default", time.Time{}); err == nil {
+		t.Fatalf("delete scheduled without a time")
+	}
+	for _, id := range []string{"orange.default", "blue.default"} {
+		if err := ScheduleDeleteNetwork(fakeDriver, id, at); err != nil {
+			t.Fatalf("error scheduling the delete of network %s. Error: %s", id, err)
+		}
+	}
+
+	// not due yet
+	run(time.Now())
+	assertOnTrue(t, !scheduled("orange.default") || !scheduled("blue.default"), "scheduled deletes run before they were due")
+
+	if err := CancelScheduledDelete(fakeDriver, "blue.default"); err != nil {
+		t.Fatalf("error canceling the scheduled delete. Error: %s", err)
+	}
+	assertOnTrue(t, scheduled("blue.default"), "canceled delete still scheduled")
+	if err := CancelScheduledDelete(fakeDriver, "blue.default"); err == nil {
+		t.Fatalf("canceled a delete that is not scheduled")
+	}
+
+	// a failed delete is retried
+	deleteErr = core.Errorf("network has endpoint groups")
+	run(at.Add(time.Minute))
+	assertOnTrue(t, !scheduled("orange.default"), "schedule of a failed delete dropped")
+
+	deleteErr = nil
+	run(at.Add(time.Minute))
+	assertOnTrue(t, !reflect.DeepEqual(deleted, []string{"orange.default"}), "scheduled delete not run")
+	assertOnTrue(t, scheduled("orange.default"), "schedule of a deleted network kept")
+
+	// the schedule of a network that is gone is dropped
+	if err := ScheduleDeleteNetwork(fakeDriver, "blue.default", at); err != nil {
+		t.Fatalf("error scheduling the delete of network. Error: %s", err)
+	}
+	nwCfg := &mastercfg.CfgNetworkState{}
+	nwCfg.ID = "blue.default"
+	nwCfg.StateDriver = fakeDriver
+	if err := nwCfg.Clear(); err != nil {
+		t.Fatalf("error clearing network state. Error: %s", err)
+	}
+	run(at.Add(time.Minute))
+	assertOnTrue(t, scheduled("blue.default"), "schedule of a removed network kept")
+	assertOnTrue(t, len(deleted) != 1, "removed network deleted again")
+}
+
+func TestScheduledDeleteOfRecreatedNetwork(t *testing.T) {
+	cfgBytes := []byte(`{
+    "Tenants" : [{
+        "Name"                  : "tenant-one",
+        "Networks"  : [{
+            "Name"              : "orange",
+            "SubnetCIDR"        : "10.1.1.1/24"
+        }]
+    }]}`)
+
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	applyConfig(t, cfgBytes)
+
+	_, err := resources.NewStateResourceManager(fakeDriver)
+	if err != nil {
+		t.Fatalf("state store initialization failed. Error: %s", err)
+	}
+	defer func() { resources.ReleaseStateResourceManager() }()
+
+	at := time.Now().Add(time.Hour)
+	if err := ScheduleDeleteNetwork(fakeDriver, "orange.tenant-one", at); err != nil {
+		t.Fatalf("error scheduling the delete of network. Error: %s", err)
+	}
+	if err := DeleteNetworkID(fakeDriver, "orange.tenant-one"); err != nil {
+		t.Fatalf("error deleting network. Error: %s", err)
+	}
+	sched := &mastercfg.ScheduledDeleteState{}
+	sched.StateDriver = fakeDriver
+	assertOnTrue(t, sched.Read("orange.tenant-one") == nil, "schedule of a deleted network kept")
+
+	// the network recreated with the same id outlives the old schedule
+	nwCfg := &mastercfg.CfgNetworkState{Tenant: "tenant-one", NetworkName: "orange"}
+	nwCfg.ID = "orange.tenant-one"
+	nwCfg.StateDriver = fakeDriver
+	if err := nwCfg.Write(); err != nil {
+		t.Fatalf("error writing network state. Error: %s", err)
+	}
+	deleteNetwork := func(nwCfg *mastercfg.CfgNetworkState) error {
+		return DeleteNetworkID(fakeDriver, nwCfg.ID)
+	}
+	if err := RunScheduledDeletes(fakeDriver, at.Add(time.Minute), deleteNetwork); err != nil {
+		t.Fatalf("error running the scheduled deletes. Error: %s", err)
+	}
+	assertOnTrue(t, nwCfg.Read("orange.tenant-one") != nil, "recreated network deleted by the schedule of the old one")
+}
+
+func TestEndpointRecycleBin(t *testing.T) {
+	cfgBytes := []byte(`{
+    "Tenants" : [{
+        "Name"                  : "tenant-one",
+        "Networks"  : [{
+            "Name"              : "orange",
+            "SubnetCIDR"        : "10.1.1.1/24",
+            "Endpoints" : [{ "Container" : "myContainer1" }, { "Container" : "myContainer2" }]
+        },
+        {
+            "Name"              : "purple",
+            "SubnetCIDR"        : "10.1.2.1/24",
+            "Endpoints" : [{ "Container" : "myContainer3" }, { "Container" : "myContainer4" }]
+        }]
+    }]}`)
+
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	applyConfig(t, cfgBytes)
+
+	_, err := resources.NewStateResourceManager(fakeDriver)
+	if err != nil {
+		t.Fatalf("state store initialization failed. Error: %s", err)
+	}
+	defer func() { resources.ReleaseStateResourceManager() }()
+
+	SetEndpointRetention(time.Minute)
+	defer SetEndpointRetention(0)
+
+	nwCfg := &mastercfg.CfgNetworkState{}
+	nwCfg.StateDriver = fakeDriver
+	allocated := func(netID string) string {
+		if err := nwCfg.Read(netID); err != nil {
+			t.Fatalf("error reading network %s. Err: %v", netID, err)
+		}
+		return ListAllocatedIPs(nwCfg)
+	}
+	endpointExists := func(epID string) bool {
+		epCfg := &mastercfg.CfgEndpointState{}
+		epCfg.StateDriver = fakeDriver
+		return epCfg.Read(epID) == nil
+	}
+	recycledExists := func(epID string) bool {
+		recycled := &mastercfg.RecycledEndpointState{}
+		recycled.StateDriver = fakeDriver
+		return recycled.Read(epID) == nil
+	}
+
+	// a delete moves the endpoint to the recycle bin, keeping its address
+	epID := "orange.tenant-one-myContainer1"
+	before := allocated("orange.tenant-one")
+	epCfg, err := RemoveEndpointID(fakeDriver, epID, false)
+	if err != nil {
+		t.Fatalf("error deleting endpoint. Err: %v", err)
+	}
+	assertOnTrue(t, endpointExists(epID), "recycled endpoint left in the config")
+	assertOnTrue(t, !recycledExists(epID), "deleted endpoint not in the recycle bin")
+	assertOnTrue(t, allocated("orange.tenant-one") != before, "address of a recycled endpoint released")
+
+	// a restore brings it back as it was
+	restored, err := RestoreEndpointID(fakeDriver, epID)
+	if err != nil {
+		t.Fatalf("error restoring endpoint. Err: %v", err)
+	}
+	assertOnTrue(t, restored.IPAddress != epCfg.IPAddress || restored.MacAddress != epCfg.MacAddress,
+		"restored endpoint changed its addresses")
+	assertOnTrue(t, !endpointExists(epID), "restored endpoint not in the config")
+	assertOnTrue(t, recycledExists(epID), "restored endpoint left in the recycle bin")
+	if _, err := RestoreEndpointID(fakeDriver, epID); err == nil {
+		t.Fatalf("endpoint restored twice")
+	}
+
+	// a force delete skips the recycle bin
+	if _, err := RemoveEndpointID(fakeDriver, epID, true); err != nil {
+		t.Fatalf("error force deleting endpoint. Err: %v", err)
+	}
+	assertOnTrue(t, recycledExists(epID), "force deleted endpoint in the recycle bin")
+	assertOnTrue(t, allocated("orange.tenant-one") == before, "address of a force deleted endpoint kept")
+
+	// recycled endpoints are removed for good once their retention elapsed
+	epID = "orange.tenant-one-myContainer2"
+	if _, err := RemoveEndpointID(fakeDriver, epID, false); err != nil {
+		t.Fatalf("error deleting endpoint. Err: %v", err)
+	}
+	if err := ReapRecycledEndpoints(fakeDriver); err != nil {
+		t.Fatalf("error reaping endpoints. Err: %v", err)
+	}
+	assertOnTrue(t, !recycledExists(epID), "endpoint removed before its retention elapsed")
+	SetEndpointRetention(0)
+	if err := ReapRecycledEndpoints(fakeDriver); err != nil {
+		t.Fatalf("error reaping endpoints. Err: %v", err)
+	}
+	assertOnTrue(t, recycledExists(epID), "endpoint kept after its retention elapsed")
+	assertOnTrue(t, allocated("orange.tenant-one") != "" || nwCfg.EpCount != 0, "network counts a removed endpoint")
+	SetEndpointRetention(time.Minute)
+
+	// recycled endpoints go with their network, not with a refused delete
+	epID = "purple.tenant-one-myContainer3"
+	if _, err := RemoveEndpointID(fakeDriver, epID, false); err != nil {
+		t.Fatalf("error deleting endpoint. Err: %v", err)
+	}
+	if err := DeleteNetworkID(fakeDriver, "purple.tenant-one"); err == nil {
+		t.Fatalf("network with an active endpoint deleted")
+	}
+	assertOnTrue(t, !recycledExists(epID), "recycled endpoint purged by a refused network delete")
+	if _, err := RemoveEndpointID(fakeDriver, "purple.tenant-one-myContainer4", true); err != nil {
+		t.Fatalf("error deleting endpoint. Err: %v", err)
+	}
+	if err := DeleteNetworkID(fakeDriver, "purple.tenant-one"); err != nil {
+		t.Fatalf("error deleting network with a recycled endpoint. Err: %v", err)
+	}
+	assertOnTrue(t, recycledExists(epID), "recycled endpoint left after its network was removed")
+}
+
+func TestEndpointLease(t *testing.T) {
+	cfgBytes := []byte(`{
+    "Tenants" : [{
+        "Name"                  : "tenant-one",
+        "Networks"  : [{
+            "Name"              : "orange",
+            "SubnetCIDR"        : "10.1.1.1/24",
+            "Endpoints" : [{ "Container" : "myContainer1", "LeaseTTL" : 30 },
+                           { "Container" : "myContainer2", "LeaseTTL" : 30 }]
+        }]
+    }]}`)
+
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	applyConfig(t, cfgBytes)
+
+	_, err := resources.NewStateResourceManager(fakeDriver)
+	if err != nil {
+		t.Fatalf("state store initialization failed. Error: %s", err)
+	}
+	defer func() { resources.ReleaseStateResourceManager() }()
+
+	endpointExists := func(epID string) bool {
+		epCfg := &mastercfg.CfgEndpointState{}
+		epCfg.StateDriver = fakeDriver
+		return epCfg.Read(epID) == nil
+	}
+	readLease := func(epID string) *mastercfg.EndpointLeaseState {
+		lease := &mastercfg.EndpointLeaseState{}
+		lease.StateDriver = fakeDriver
+		if err := lease.Read(epID); err != nil {
+			return nil
+		}
+		return lease
+	}
+
+	epID := "orange.tenant-one-myContainer1"
+	lease := readLease(epID)
+	assertOnTrue(t, lease == nil || lease.TTL != 30, "endpoint created without its lease")
+
+	// live leases are renewed and their endpoints kept
+	if err := RenewEndpointLease(fakeDriver, epID); err != nil {
+		t.Fatalf("error renewing the lease. Err: %v", err)
+	}
+	if err := ReapExpiredEndpoints(fakeDriver); err != nil {
+		t.Fatalf("error reaping endpoints. Err: %v", err)
+	}
+	assertOnTrue(t, !endpointExists(epID), "endpoint with a live lease removed")
+	assertOnTrue(t, EndpointLeaseExpired(fakeDriver, epID), "live lease reported expired")
+
+	// leases past their expiry have their endpoint deleted
+	lease.Expiry = time.Now().Unix() - 1
+	if err := lease.Write(); err != nil {
+		t.Fatalf("error writing the lease. Err: %v", err)
+	}
+	if err := ReapExpiredEndpoints(fakeDriver); err != nil {
+		t.Fatalf("error reaping endpoints. Err: %v", err)
+	}
+	assertOnTrue(t, endpointExists(epID), "endpoint with an expired lease kept")
+	assertOnTrue(t, !EndpointLeaseExpired(fakeDriver, epID), "lease of a reaped endpoint not marked expired")
+	if err := RenewEndpointLease(fakeDriver, epID); err == nil {
+		t.Fatalf("expired lease renewed")
+	}
+
+	// expired leases are dropped once their homing host had a ttl to see them
+	lease = readLease(epID)
+	lease.Expiry = time.Now().Unix() - 31
+	if err := lease.Write(); err != nil {
+		t.Fatalf("error writing the lease. Err: %v", err)
+	}
+	if err := ReapExpiredEndpoints(fakeDriver); err != nil {
+		t.Fatalf("error reaping endpoints. Err: %v", err)
+	}
+	assertOnTrue(t, readLease(epID) != nil, "expired lease kept")
+
+	// endpoints deleted on purpose drop their lease
+	epID = "orange.tenant-one-myContainer2"
+	if _, err := DeleteEndpointID(fakeDriver, epID); err != nil {
+		t.Fatalf("error deleting endpoint. Err: %v", err)
+	}
+	assertOnTrue(t, readLease(epID) != nil, "lease of a deleted endpoint kept")
+}
+
+func TestGatewayArpProxy(t *testing.T) {
+	testData := []struct {
+		gateway    string
+		arpProxy   bool
+		gatewayMac string
+		expMac     string
+		shouldFail bool
+	}{
+		{"10.1.1.1", false, "", "", false},
+		{"10.1.1.1", true, "", "02:02:0a:01:01:01", false},
+		{"10.1.1.1", true, "00:AA:bb:cc:dd:ee", "00:aa:bb:cc:dd:ee", false},
+		{"", true, "", "", true},
+		{"10.1.1.1", false, "00:aa:bb:cc:dd:ee", "", true},
+		{"10.1.1.1", true, "01:00:5e:00:00:01", "", true},
+		{"10.1.1.1", true, "00:aa:bb", "", true},
+	}
+
+	for _, d := range testData {
+		network := intent.ConfigNetwork{
+			Name:            "orange",
+			SubnetCIDR:      "10.1.1.0/24",
+			PktTagType:      "vxlan",
+			Gateway:         d.gateway,
+			GatewayArpProxy: d.arpProxy,
+			GatewayMac:      d.gatewayMac,
+		}
+		err := validateNetworkSubnet(&network)
+		assertOnTrue(t, (err != nil) != d.shouldFail,
+			fmt.Sprintf("gateway %q proxy %v mac %q: unexpected result %v", d.gateway, d.arpProxy, d.gatewayMac, err))
+		if err == nil {
+			mac := gatewayArpMac(&network)
+			assertOnTrue(t, mac != d.expMac, fmt.Sprintf("unexpected gateway mac %q, expected %q", mac, d.expMac))
+		}
+	}
+
+	network := intent.ConfigNetwork{Name: "orange", SubnetCIDR: "10.1.1.0/24", PktTagType: "vlan",
+		Gateway: "10.1.1.1", GatewayArpProxy: true}
+	assertOnTrue(t, validateNetworkSubnet(&network) == nil, "gateway arp proxy allowed on a vlan network")
+}
+
+func TestNetworkVrf(t *testing.T) {
+	testData := []struct {
+		vrf        string
+		table      int
+		shouldFail bool
+	}{
+		{"", 0, false},
+		{"vrf-blue", 0, false},
+		{"vrf-blue", 10, false},
+		{"", 10, true},
+		{"vrf-blue", -1, true},
+		{"vrf-blue", 254, true},
+		{"vrf/blue", 10, true},
+		{"vrf-too-long-for-linux", 10, true},
+	}
+
+	for _, d := range testData {
+		network := intent.ConfigNetwork{
+			Name:       "orange",
+			SubnetCIDR: "10.1.1.0/24",
+			PktTagType: "vlan",
+			Vrf:        d.vrf,
+			VrfTable:   d.table,
+		}
+		err := validateNetworkSubnet(&network)
+		assertOnTrue(t, (err != nil) != d.shouldFail,
+			fmt.Sprintf("vrf %q table %d: unexpected result %v", d.vrf, d.table, err))
+	}
+}
+
+func TestEndpointRateLimit(t *testing.T) {
+	testData := []struct {
+		limit      intent.ConfigRateLimit
+		shouldFail bool
+	}{
+		{intent.ConfigRateLimit{Rate: 10000}, false},
+		{intent.ConfigRateLimit{Rate: 10000, Burst: 1000}, false},
+		{intent.ConfigRateLimit{Rate: 0, Burst: 1000}, true},
+		{intent.ConfigRateLimit{Rate: -1}, true},
+		{intent.ConfigRateLimit{Rate: 10000, Burst: -1}, true},
+	}
+
+	for _, d := range testData {
+		limit, err := endpointRateLimit(&d.limit)
+		assertOnTrue(t, (err != nil) != d.shouldFail,
+			fmt.Sprintf("rate limit %+v: unexpected result %v", d.limit, err))
+		if err == nil {
+			assertOnTrue(t, limit.Rate != d.limit.Rate || limit.Burst != d.limit.Burst,
+				fmt.Sprintf("rate limit %+v: unexpected state %+v", d.limit, limit))
+		}
+	}
+}
+
+func TestAddrFamilies(t *testing.T) {
+	cfgBytes := []byte(`{
+    "Tenants" : [{
+        "Name"                  : "tenant-one",
+        "Networks"  : [{
+            "Name"              : "blue",
+            "IPv6SubnetCIDR"    : "2001::/100",
+            "Endpoints" : [{ "Container" : "myContainer1" }]
+        },
+        {
+            "Name"              : "green",
+            "SubnetCIDR"        : "10.1.2.1/24",
+            "IPv6SubnetCIDR"    : "2002::/100",
+            "Endpoints" : [{ "Container" : "myContainer2" }]
+        }]
+    }]}`)
+
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	applyConfig(t, cfgBytes)
+
+	readNw := func(netID string) *mastercfg.CfgNetworkState {
+		nwCfg := &mastercfg.CfgNetworkState{}
+		nwCfg.StateDriver = fakeDriver
+		if err := nwCfg.Read(netID); err != nil {
+			t.Fatalf("error reading network %s. Err: %v", netID, err)
+		}
+		return nwCfg
+	}
+	readEp := func(epID string) *mastercfg.CfgEndpointState {
+		epCfg := &mastercfg.CfgEndpointState{}
+		epCfg.StateDriver = fakeDriver
+		if err := epCfg.Read(epID); err != nil {
+			t.Fatalf("error reading endpoint %s. Err: %v", epID, err)
+		}
+		return epCfg
+	}
+
+	families := readNw("blue.tenant-one").AddrFamilies()
+	assertOnTrue(t, !reflect.DeepEqual(families, []string{mastercfg.AddrFamilyIPv6}),
+		fmt.Sprintf("unexpected families %v of an IPv6 only network", families))
+	families = readNw("green.tenant-one").AddrFamilies()
+	assertOnTrue(t, !reflect.DeepEqual(families, []string{mastercfg.AddrFamilyIPv4, mastercfg.AddrFamilyIPv6}),
+		fmt.Sprintf("unexpected families %v of a dual stack network", families))
+
+	// an endpoint gets an address of each family of its network
+	blue := readEp("blue.tenant-one-myContainer1")
+	assertOnTrue(t, blue.IPAddress != "" || blue.IPv6Address != "2001::1" || blue.MacAddress != "02:02:00:00:00:01",
+		fmt.Sprintf("unexpected addresses of endpoint on an IPv6 only network: %+v", blue))
+	green := readEp("green.tenant-one-myContainer2")
+	assertOnTrue(t, green.IPAddress == "" || green.IPv6Address == "",
+		fmt.Sprintf("unexpected addresses of endpoint on a dual stack network: %+v", green))
+
+	epReq := &CreateEndpointRequest{ConfigEP: intent.ConfigEP{Container: "myContainer3", IPAddress: "10.1.1.3"}}
+	if _, err := CreateEndpoint(fakeDriver, readNw("blue.tenant-one"), epReq); err == nil {
+		t.Fatalf("IPv4 address allocated on an IPv6 only network")
+	}
+	epReq = &CreateEndpointRequest{ConfigEP: intent.ConfigEP{Container: "myContainer3", IPv6Address: "2001::7"}}
+	ep, err := CreateEndpoint(fakeDriver, readNw("blue.tenant-one"), epReq)
+	if err != nil || ep.IPv6Address != "2001::7" {
+		t.Fatalf("requested IPv6 address not allocated: %+v. Err: %v", ep, err)
+	}
+
+	// the IPv6 addresses are released with their endpoints
+	for _, epID := range []string{"blue.tenant-one-myContainer1", "blue.tenant-one-myContainer3"} {
+		if _, err := DeleteEndpointID(fakeDriver, epID); err != nil {
+			t.Fatalf("error deleting endpoint %s. Err: %v", epID, err)
+		}
+	}
+	nwCfg := readNw("blue.tenant-one")
+	assertOnTrue(t, len(nwCfg.IPv6AllocMap) != 0 || nwCfg.EpCount != 0,
+		fmt.Sprintf("IPv6 addresses %v left allocated, %d endpoints", nwCfg.IPv6AllocMap, nwCfg.EpCount))
+}
+
+func TestTenantQuota(t *testing.T) {
+	cfgBytes := []byte(`{
+    "Tenants" : [{
+        "Name"                  : "tenant-one",
+        "Networks"  : [{
+            "Name"              : "orange",
+            "SubnetCIDR"        : "10.1.1.1/24",
+            "Endpoints" : [{ "Container" : "myContainer1" }]
+        }]
+    }]}`)
+
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	applyConfig(t, cfgBytes)
+	if _, err := resources.NewStateResourceManager(fakeDriver); err != nil {
+		t.Fatalf("state store initialization failed. Error: %s", err)
+	}
+	defer resources.ReleaseStateResourceManager()
+
+	usage, err := GetTenantUsage(fakeDriver, "tenant-one")
+	assertOnTrue(t, err != nil || *usage != TenantUsage{Networks: 1, Endpoints: 1, Addresses: 256},
+		fmt.Sprintf("unexpected usage %+v. Err: %v", usage, err))
+
+	setQuota := func(networks, endpoints, addresses int) error {
+		quota := &mastercfg.TenantQuotaState{Networks: networks, Endpoints: endpoints, Addresses: addresses}
+		quota.ID = "tenant-one"
+		return SetTenantQuota(fakeDriver, quota)
+	}
+	assertOnTrue(t, setQuota(-1, 0, 0) == nil, "negative quota set")
+
+	network := func(name, subnet string) intent.ConfigNetwork {
+		return intent.ConfigNetwork{Name: name, PktTagType: "vxlan", SubnetCIDR: subnet}
+	}
+	quotaExceeded := func(err error, resource string) bool {
+		qErr, ok := err.(*QuotaExceededError)
+		return ok && qErr.Resource == resource
+	}
+
+	assertOnTrue(t, setQuota(1, 0, 0) != nil, "error setting quota")
+	err = CreateNetwork(network("blue", "10.1.2.1/28"), fakeDriver, "tenant-one")
+	assertOnTrue(t, !quotaExceeded(err, QuotaNetworks), fmt.Sprintf("network over quota created. Err: %v", err))
+	err = CreateNetwork(network("blue", "10.1.2.1/28"), fakeDriver, "tenant-two")
+	assertOnTrue(t, err != nil, fmt.Sprintf("network of another tenant not created. Err: %v", err))
+
+	assertOnTrue(t, setQuota(2, 0, 300) != nil, "error setting quota")
+	err = CreateNetwork(network("blue", "10.1.2.1/24"), fakeDriver, "tenant-one")
+	assertOnTrue(t, !quotaExceeded(err, QuotaAddresses), fmt.Sprintf("addresses over quota allocated. Err: %v", err))
+	err = CreateNetwork(network("blue", "10.1.2.1/28"), fakeDriver, "tenant-one")
+	assertOnTrue(t, err != nil, fmt.Sprintf("network within quota not created. Err: %v", err))
+
+	nwCfg := &mastercfg.CfgNetworkState{}
+	nwCfg.StateDriver = fakeDriver
+	if err := nwCfg.Read("orange.tenant-one"); err != nil {
+		t.Fatalf("error reading network. Err: %v", err)
+	}
+	assertOnTrue(t, setQuota(2, 1, 300) != nil, "error setting quota")
+	epReq := &CreateEndpointRequest{ConfigEP: intent.ConfigEP{Container: "myContainer2"}}
+	_, err = CreateEndpoint(fakeDriver, nwCfg, epReq)
+	assertOnTrue(t, !quotaExceeded(err, QuotaEndpoints), fmt.Sprintf("endpoint over quota created. Err: %v", err))
+	assertOnTrue(t, setQuota(2, 2, 300) != nil, "error setting quota")
+	_, err = CreateEndpoint(fakeDriver, nwCfg, epReq)
+	assertOnTrue(t, err != nil, fmt.Sprintf("endpoint within quota not created. Err: %v", err))
+
+	usage, err = GetTenantUsage(fakeDriver, "tenant-one")
+	assertOnTrue(t, err != nil || *usage != TenantUsage{Networks: 2, Endpoints: 2, Addresses: 272},
+		fmt.Sprintf("unexpected usage %+v. Err: %v", usage, err))
+
+	// a quota of no limits is removed
+	assertOnTrue(t, setQuota(0, 0, 0) != nil, "error removing quota")
+	quota, err := GetTenantQuota(fakeDriver, "tenant-one")
+	assertOnTrue(t, err != nil || quota.Networks != 0 || quota.Endpoints != 0 || quota.Addresses != 0,
+		fmt.Sprintf("unexpected quota %+v after its removal. Err: %v", quota, err))
+}
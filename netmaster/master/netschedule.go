@@ -0,0 +1,154 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package master
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+	"github.com/contiv/netplugin/utils"
+)
+
+// ScheduleDeleteRequest is the request to delete a network at a later time
+type ScheduleDeleteRequest struct {
+	At int64 // unix time of the delete
+}
+
+// ScheduleDeleteNetwork schedules the delete of network netID at time at,
+// e.g. the end of a maintenance window. Scheduling a network again replaces
+// its schedule.
+func ScheduleDeleteNetwork(stateDriver core.StateDriver, netID string, at time.Time) error {
+	if at.IsZero() {
+		return core.Errorf("no time given for the delete of network %s", netID)
+	}
+
+	nwCfg := &mastercfg.CfgNetworkState{}
+	nwCfg.StateDriver = stateDriver
+	if err := nwCfg.Read(netID); err != nil {
+		return core.Errorf("network %s not found", netID)
+	}
+	if nwCfg.Deleting {
+		return core.Errorf("network %s is being deleted", netID)
+	}
+
+	sched := &mastercfg.ScheduledDeleteState{At: at.Unix()}
+	sched.ID = netID
+	sched.StateDriver = stateDriver
+	if err := sched.Write(); err != nil {
+		log.Errorf("Error writing the scheduled delete of network %s. Err: %v", netID, err)
+		return err
+	}
+	log.Infof("Scheduled the delete of network %s at %v", netID, at)
+	return nil
+}
+
+// CancelScheduledDelete cancels the scheduled delete of network netID
+func CancelScheduledDelete(stateDriver core.StateDriver, netID string) error {
+	sched := &mastercfg.ScheduledDeleteState{}
+	sched.StateDriver = stateDriver
+	if err := sched.Read(netID); err != nil {
+		return core.Errorf("no delete of network %s is scheduled", netID)
+	}
+	if err := sched.Clear(); err != nil {
+		return err
+	}
+	log.Infof("Canceled the scheduled delete of network %s", netID)
+	return nil
+}
+
+// clearScheduledDelete drops the scheduled delete of network netID, if any
+func clearScheduledDelete(stateDriver core.StateDriver, netID string) error {
+	sched := &mastercfg.ScheduledDeleteState{}
+	sched.StateDriver = stateDriver
+	if err := sched.Read(netID); err != nil {
+		return nil
+	}
+	if err := sched.Clear(); err != nil {
+		log.Errorf("Error clearing the scheduled delete of network %s. Err: %v", netID, err)
+		return err
+	}
+	return nil
+}
+
+// RunScheduledDeletes deletes, with deleteNetwork, the networks whose delete
+// is due at time now. deleteNetwork is the delete of the network object, the
+// network delete grace period applies. A delete that fails is retried on the
+// next run, the schedule of a network that is gone is dropped.
+func RunScheduledDeletes(stateDriver core.StateDriver, now time.Time,
+	deleteNetwork func(nwCfg *mastercfg.CfgNetworkState) error) error {
+	sched := &mastercfg.ScheduledDeleteState{}
+	sched.StateDriver = stateDriver
+	states, err := sched.ReadAll()
+	if core.ErrIfKeyExists(err) != nil {
+		return err
+	}
+
+	for _, state := range states {
+		s := state.(*mastercfg.ScheduledDeleteState)
+		if s.At > now.Unix() {
+			continue
+		}
+		s.StateDriver = stateDriver
+
+		nwCfg := &mastercfg.CfgNetworkState{}
+		nwCfg.StateDriver = stateDriver
+		if err := nwCfg.Read(s.ID); err != nil {
+			log.Infof("Network %s of a scheduled delete is gone", s.ID)
+		} else if err := deleteNetwork(nwCfg); err != nil {
+			log.Errorf("Error running the scheduled delete of network %s. Err: %v", s.ID, err)
+			continue
+		} else {
+			log.Infof("Deleted network %s as scheduled", s.ID)
+		}
+
+		if err := s.Clear(); err != nil {
+			log.Errorf("Error clearing the scheduled delete of network %s. Err: %v", s.ID, err)
+		}
+	}
+	return nil
+}
+
+// ScheduleDeleteNetworkHandler schedules the delete of a network
+func ScheduleDeleteNetworkHandler(w http.ResponseWriter, r *http.Request, vars map[string]string) (interface{}, error) {
+	var schedReq ScheduleDeleteRequest
+	if err := json.NewDecoder(r.Body).Decode(&schedReq); err != nil {
+		log.Errorf("Error decoding ScheduleDeleteNetworkHandler. Err %v", err)
+		return nil, err
+	}
+
+	stateDriver, err := utils.GetStateDriver()
+	if err != nil {
+		return nil, err
+	}
+	var at time.Time
+	if schedReq.At != 0 {
+		at = time.Unix(schedReq.At, 0)
+	}
+	return nil, ScheduleDeleteNetwork(stateDriver, vars["id"], at)
+}
+
+// CancelScheduledDeleteHandler cancels the scheduled delete of a network
+func CancelScheduledDeleteHandler(w http.ResponseWriter, r *http.Request, vars map[string]string) (interface{}, error) {
+	stateDriver, err := utils.GetStateDriver()
+	if err != nil {
+		return nil, err
+	}
+	return nil, CancelScheduledDelete(stateDriver, vars["id"])
+}
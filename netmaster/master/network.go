@@ -16,8 +16,10 @@ limitations under the License.
 package master
 
 import (
+	"fmt"
 	"net"
 	"strings"
+	"time"
 
 	"github.com/contiv/netplugin/core"
 	"github.com/contiv/netplugin/netmaster/docknet"
@@ -27,6 +29,7 @@ import (
 	"github.com/contiv/netplugin/utils/netutils"
 
 	log "github.com/Sirupsen/logrus"
+	"github.com/jainvipin/bitset"
 )
 
 func checkPktTagType(pktTagType string) error {
@@ -71,6 +74,313 @@ func validateNetworkConfig(tenant *intent.ConfigTenant) error {
 	return err
 }
 
+// validateNetworkSubnet checks that the subnets and gateways of a network
+// are consistent and that the IPv4 subnet has room for the expected
+// endpoints. A network has an IPv4 subnet, an IPv6 subnet or both.
+func validateNetworkSubnet(network *intent.ConfigNetwork) error {
+	if network.SubnetCIDR != "" || network.IPv6SubnetCIDR == "" {
+		if err := validateIPv4Subnet(network); err != nil {
+			return err
+		}
+	} else if network.Gateway != "" {
+		return core.Errorf("gateway %s needs an IPv4 subnet", network.Gateway)
+	}
+
+	// the networks share the bridge, its options are set in the plugin config
+	if network.EnableSTP || network.EnableMcastSnooping || network.AgeingTime != 0 {
+		return core.Errorf("bridge options can not be set per network")
+	}
+
+	// the networks share the bridge, and ofnet must keep controlling it
+	if network.FailMode != "" {
+		return core.Errorf("bridge fail mode %q can not be set per network", network.FailMode)
+	}
+
+	if network.IPAM != "" && network.IPAM != mastercfg.IPAMDhcp {
+		return core.Errorf("invalid ipam %q", network.IPAM)
+	}
+
+	switch network.DupAddrDetect {
+	case "", mastercfg.DupAddrDetectWarn, mastercfg.DupAddrDetectFail:
+	default:
+		return core.Errorf("invalid duplicate address detection mode %q", network.DupAddrDetect)
+	}
+	if network.DupAddrTimeout < 0 || network.DupAddrTimeout > mastercfg.MaxDupAddrTimeout {
+		return core.Errorf("invalid duplicate address detection timeout %d, must be at most %d seconds",
+			network.DupAddrTimeout, mastercfg.MaxDupAddrTimeout)
+	}
+
+	if network.IPQuarantine < 0 {
+		return core.Errorf("invalid ip quarantine %d", network.IPQuarantine)
+	}
+
+	if network.Encrypt && network.PktTagType != "vxlan" {
+		return core.Errorf("encryption needs a vxlan network")
+	}
+
+	if network.GatewayArpProxy && network.Gateway == "" {
+		return core.Errorf("gateway arp proxy needs a gateway")
+	}
+	if network.GatewayArpProxy && network.PktTagType != "vxlan" {
+		return core.Errorf("gateway arp proxy needs a vxlan network")
+	}
+	if network.GatewayMac != "" {
+		if !network.GatewayArpProxy {
+			return core.Errorf("gateway mac %s set without the gateway arp proxy", network.GatewayMac)
+		}
+		mac, err := net.ParseMAC(network.GatewayMac)
+		if err != nil || len(mac) != 6 || mac[0]&1 != 0 {
+			return core.Errorf("invalid gateway mac %q", network.GatewayMac)
+		}
+	}
+
+	if err := validateAllocRanges(network); err != nil {
+		return err
+	}
+
+	switch network.BUMMode {
+	case "", mastercfg.BUMModeFlood:
+	case mastercfg.BUMModeDrop, mastercfg.BUMModeController:
+		// vlan networks are switched by a normal lookup, which does not
+		// tell unknown unicast apart
+		if network.PktTagType != "vxlan" {
+			return core.Errorf("bum mode %s needs a vxlan network", network.BUMMode)
+		}
+	default:
+		return core.Errorf("invalid bum mode %q", network.BUMMode)
+	}
+
+	if network.Vrf != "" && !intfNameRe.MatchString(network.Vrf) {
+		return core.Errorf("invalid vrf name %q", network.Vrf)
+	}
+	if network.VrfTable != 0 {
+		if network.Vrf == "" {
+			return core.Errorf("vrf table %d set without a vrf", network.VrfTable)
+		}
+		// 253 to 255 are the default, main and local tables
+		if network.VrfTable < 0 || (network.VrfTable >= 253 && network.VrfTable <= 255) {
+			return core.Errorf("invalid vrf table %d", network.VrfTable)
+		}
+	}
+
+	if network.UnderlayMTU != 0 && networkMTU(network) < minMTU {
+		return core.Errorf("underlay mtu %d is too small", network.UnderlayMTU)
+	}
+	if network.MTU != 0 && (network.MTU < minMTU || network.MTU > maxMTU) {
+		return core.Errorf("invalid network mtu %d", network.MTU)
+	}
+	// an encapsulated network must leave room for the encapsulation on
+	// the uplinks, which have the default mtu unless the network says so
+	if network.MTU != 0 && (network.UnderlayMTU != 0 || encapOverhead(network) != 0) {
+		uplinkMTU := network.UnderlayMTU
+		if uplinkMTU == 0 {
+			uplinkMTU = defaultUnderlayMTU
+		}
+		if underlayMTU(network) > uplinkMTU {
+			return core.Errorf("network mtu %d does not fit in underlay mtu %d with %d bytes of encapsulation",
+				network.MTU, uplinkMTU, encapOverhead(network))
+		}
+	}
+
+	if network.IPv6SubnetCIDR != "" {
+		_, ipv6Net, err := net.ParseCIDR(network.IPv6SubnetCIDR)
+		if err != nil || ipv6Net.IP.To4() != nil {
+			return core.Errorf("invalid IPv6 subnet %q", network.IPv6SubnetCIDR)
+		}
+		if network.IPv6Gateway != "" {
+			gwIP := net.ParseIP(network.IPv6Gateway)
+			if gwIP == nil || gwIP.To4() != nil {
+				return core.Errorf("invalid IPv6 gateway %q", network.IPv6Gateway)
+			}
+			if !ipv6Net.Contains(gwIP) {
+				return core.Errorf("IPv6 gateway %s is not in subnet %s",
+					network.IPv6Gateway, ipv6Net.String())
+			}
+		}
+	} else if network.IPv6Gateway != "" {
+		return core.Errorf("IPv6 gateway %s specified without an IPv6 subnet",
+			network.IPv6Gateway)
+	}
+
+	return nil
+}
+
+// validateIPv4Subnet checks the IPv4 subnet and gateway of a network
+func validateIPv4Subnet(network *intent.ConfigNetwork) error {
+	subnetIP, subnetLen, err := netutils.ParseCIDR(network.SubnetCIDR)
+	if err != nil {
+		return core.Errorf("invalid subnet %q: %v", network.SubnetCIDR, err)
+	}
+	if netutils.IsIPv6(subnetIP) {
+		return core.Errorf("subnet %q is not an IPv4 subnet", network.SubnetCIDR)
+	}
+	if err = netutils.ValidateNetworkRangeParams(subnetIP, subnetLen); err != nil {
+		return err
+	}
+
+	subnetAddr := netutils.GetSubnetAddr(subnetIP, subnetLen)
+	if net.ParseIP(subnetAddr) == nil {
+		return core.Errorf("invalid subnet %q", network.SubnetCIDR)
+	}
+
+	// the network and broadcast addresses are never handed out
+	usableHosts := 0
+	if subnetLen < 31 {
+		usableHosts = (1 << (32 - subnetLen)) - 2
+	}
+
+	if network.Gateway != "" {
+		gwIP := net.ParseIP(network.Gateway)
+		if gwIP == nil || gwIP.To4() == nil {
+			return core.Errorf("invalid gateway %q", network.Gateway)
+		}
+		if netutils.GetSubnetAddr(network.Gateway, subnetLen) != subnetAddr {
+			return core.Errorf("gateway %s is not in subnet %s/%d",
+				network.Gateway, subnetAddr, subnetLen)
+		}
+		if usableHosts == 0 {
+			return core.Errorf("subnet %s/%d has no room for gateway %s",
+				subnetAddr, subnetLen, network.Gateway)
+		}
+		if network.Gateway == subnetAddr {
+			return core.Errorf("gateway %s is the network address of subnet %s/%d",
+				network.Gateway, subnetAddr, subnetLen)
+		}
+		if gwIP.Equal(broadcastAddr(subnetAddr, subnetLen)) {
+			return core.Errorf("gateway %s is the broadcast address of subnet %s/%d",
+				network.Gateway, subnetAddr, subnetLen)
+		}
+		usableHosts--
+	}
+
+	if len(network.Endpoints) > usableHosts {
+		return core.Errorf("subnet %s/%d has %d usable addresses, %d endpoints requested",
+			subnetAddr, subnetLen, usableHosts, len(network.Endpoints))
+	}
+	return nil
+}
+
+// broadcastAddr returns the last address of an IPv4 subnet
+func broadcastAddr(subnetAddr string, subnetLen uint) net.IP {
+	ip := net.ParseIP(subnetAddr).To4()
+	mask := net.CIDRMask(int(subnetLen), 32)
+	bcast := make(net.IP, len(ip))
+	for i := range ip {
+		bcast[i] = ip[i] | ^mask[i]
+	}
+	return bcast
+}
+
+// checkPktTagAvailable verifies that a requested vlan or vxlan is within the
+// configured range and not used by another network of the same type
+func checkPktTagAvailable(stateDriver core.StateDriver, gCfg *gstate.Cfg, pktTagType string, tag uint) error {
+	var err error
+	if pktTagType == "vlan" {
+		err = gCfg.CheckVLAN(tag)
+	} else {
+		err = gCfg.CheckVXLAN(tag)
+	}
+	if err != nil {
+		return err
+	}
+
+	readNet := &mastercfg.CfgNetworkState{}
+	readNet.StateDriver = stateDriver
+	netCfgs, err := readNet.ReadAll()
+	if err != nil {
+		if core.ErrIfKeyExists(err) != nil {
+			return err
+		}
+		return nil
+	}
+
+	for _, cfg := range netCfgs {
+		nw := cfg.(*mastercfg.CfgNetworkState)
+		if nw.PktTagType != pktTagType {
+			continue
+		}
+
+		// the vxlan id of a vxlan network is its external tag
+		nwTag := nw.PktTag
+		if pktTagType == "vxlan" {
+			nwTag = nw.ExtPktTag
+		}
+		if uint(nwTag) == tag {
+			return core.Errorf("%s %d is already used by network %s", pktTagType, tag, nw.ID)
+		}
+	}
+
+	return nil
+}
+
+const (
+	// defaultUnderlayMTU is assumed when a network does not set one
+	defaultUnderlayMTU = 1500
+	// vxlanEncapOverhead is the inner eth header(14) + outer IP(20) +
+	// outer UDP(8) + vxlan header(8)
+	vxlanEncapOverhead = 50
+	// ipsecEncapOverhead is the worst case ESP transport mode overhead with
+	// AES-CBC and HMAC-SHA1: ESP header(8) + IV(16) + padding(15) +
+	// trailer(2) + ICV(12)
+	ipsecEncapOverhead = 53
+	// minMTU is the smallest mtu an IPv4 host must accept
+	minMTU = 68
+	// maxMTU is the largest mtu of an interface
+	maxMTU = 65535
+)
+
+// networkMTU returns the endpoint mtu of a network, derived from its
+// underlay mtu unless the network sets it
+func networkMTU(network *intent.ConfigNetwork) int {
+	if network.MTU != 0 {
+		return network.MTU
+	}
+	mtu := network.UnderlayMTU
+	if mtu == 0 {
+		mtu = defaultUnderlayMTU
+	}
+	return mtu - encapOverhead(network)
+}
+
+// encapOverhead returns the bytes the encapsulation of a network adds to
+// the frames of its endpoints on the underlay
+func encapOverhead(network *intent.ConfigNetwork) int {
+	if network.PktTagType != "vxlan" {
+		return 0
+	}
+	if network.Encrypt {
+		return vxlanEncapOverhead + ipsecEncapOverhead
+	}
+	return vxlanEncapOverhead
+}
+
+// underlayMTU returns the mtu the underlay links of a network must support,
+// zero when the network sets neither its mtu nor its underlay mtu
+func underlayMTU(network *intent.ConfigNetwork) int {
+	if network.MTU == 0 {
+		return network.UnderlayMTU
+	}
+	return network.MTU + encapOverhead(network)
+}
+
+// gatewayArpMac returns the mac the hosts answer arp for the gateway of a
+// network with, derived from the gateway address like the endpoint macs
+// unless the network sets it
+func gatewayArpMac(network *intent.ConfigNetwork) string {
+	if !network.GatewayArpProxy {
+		return ""
+	}
+	if network.GatewayMac != "" {
+		mac, _ := net.ParseMAC(network.GatewayMac)
+		return mac.String()
+	}
+	gwIP := net.ParseIP(network.Gateway).To4()
+	if gwIP == nil {
+		return ""
+	}
+	return fmt.Sprintf("02:02:%02x:%02x:%02x:%02x", gwIP[0], gwIP[1], gwIP[2], gwIP[3])
+}
+
 // CreateNetwork creates a network from intent
 func CreateNetwork(network intent.ConfigNetwork, stateDriver core.StateDriver, tenantName string) error {
 	var extPktTag, pktTag uint
@@ -90,16 +400,33 @@ func CreateNetwork(network intent.ConfigNetwork, stateDriver core.StateDriver, t
 	nwCfg := &mastercfg.CfgNetworkState{}
 	nwCfg.StateDriver = stateDriver
 	if nwCfg.Read(networkID) == nil {
+		if nwCfg.Deleting {
+			return core.Errorf("network %s is being deleted", networkID)
+		}
 		// TODO: check if parameters changed and apply an update if needed
 		return nil
 	}
 
-	subnetIP, subnetLen, _ := netutils.ParseCIDR(network.SubnetCIDR)
-	err = netutils.ValidateNetworkRangeParams(subnetIP, subnetLen)
+	err = validateNetworkSubnet(&network)
 	if err != nil {
+		log.Errorf("Error validating network %s. Err: %v", networkID, err)
 		return err
 	}
 
+	if err := checkNetworkQuota(stateDriver, tenantName, &network); err != nil {
+		log.Errorf("Error creating network %s. Err: %v", networkID, err)
+		return err
+	}
+
+	if network.Encrypt {
+		if err := ensureOverlayKey(stateDriver); err != nil {
+			log.Errorf("Error creating the overlay key for network %s. Err: %v", networkID, err)
+			return err
+		}
+	}
+
+	subnetIP, subnetLen, _ := netutils.ParseCIDR(network.SubnetCIDR)
+
 	ipv6Subnet, ipv6SubnetLen, _ := netutils.ParseCIDR(network.IPv6SubnetCIDR)
 
 	// if there is no label given generate one for the network
@@ -110,39 +437,55 @@ func CreateNetwork(network intent.ConfigNetwork, stateDriver core.StateDriver, t
 
 	// construct and update network state
 	nwCfg = &mastercfg.CfgNetworkState{
-		Tenant:        tenantName,
-		NetworkName:   network.Name,
-		NwType:        network.NwType,
-		PktTagType:    network.PktTagType,
-		SubnetIP:      subnetIP,
-		SubnetLen:     subnetLen,
-		IPv6Subnet:    ipv6Subnet,
-		IPv6SubnetLen: ipv6SubnetLen,
-		NetworkTag:    nwTag,
+		Tenant:          tenantName,
+		NetworkName:     network.Name,
+		NwType:          network.NwType,
+		PktTagType:      network.PktTagType,
+		SubnetIP:        subnetIP,
+		SubnetLen:       subnetLen,
+		IPv6Subnet:      ipv6Subnet,
+		IPv6SubnetLen:   ipv6SubnetLen,
+		NetworkTag:      nwTag,
+		AutoCleanEps:    network.AutoCleanEndpoints,
+		IPAM:            network.IPAM,
+		MTU:             networkMTU(&network),
+		UnderlayMTU:     underlayMTU(&network),
+		DupAddrDetect:   network.DupAddrDetect,
+		DupAddrTimeout:  network.DupAddrTimeout,
+		IPQuarantine:    network.IPQuarantine,
+		Encrypt:         network.Encrypt,
+		GatewayArpProxy: network.GatewayArpProxy,
+		GatewayMac:      gatewayArpMac(&network),
+		BUMMode:         network.BUMMode,
+		AllocRanges:     network.AllocRanges,
+		Vrf:             network.Vrf,
+		VrfTable:        network.VrfTable,
 	}
 
 	nwCfg.ID = networkID
 	nwCfg.StateDriver = stateDriver
 
-	netutils.InitSubnetBitset(&nwCfg.IPAllocMap, nwCfg.SubnetLen)
-	subnetAddr := netutils.GetSubnetAddr(nwCfg.SubnetIP, nwCfg.SubnetLen)
-	nwCfg.SubnetIP = subnetAddr
-	nwCfg.IPAddrRange = netutils.GetIPAddrRange(subnetIP, subnetLen)
+	if subnetIP != "" {
+		netutils.InitSubnetBitset(&nwCfg.IPAllocMap, nwCfg.SubnetLen)
+		subnetAddr := netutils.GetSubnetAddr(nwCfg.SubnetIP, nwCfg.SubnetLen)
+		nwCfg.SubnetIP = subnetAddr
+		nwCfg.IPAddrRange = netutils.GetIPAddrRange(subnetIP, subnetLen)
 
-	if network.Gateway != "" {
-		nwCfg.Gateway = network.Gateway
+		if network.Gateway != "" {
+			nwCfg.Gateway = network.Gateway
 
-		// Reserve gateway IP address if gateway is specified
-		ipAddrValue, err := netutils.GetIPNumber(subnetAddr, nwCfg.SubnetLen, 32, nwCfg.Gateway)
-		if err != nil {
-			log.Errorf("Error parsing gateway address %s. Err: %v", nwCfg.Gateway, err)
-			return err
+			// Reserve gateway IP address if gateway is specified
+			ipAddrValue, err := netutils.GetIPNumber(subnetAddr, nwCfg.SubnetLen, 32, nwCfg.Gateway)
+			if err != nil {
+				log.Errorf("Error parsing gateway address %s. Err: %v", nwCfg.Gateway, err)
+				return err
+			}
+			nwCfg.IPAllocMap.Set(ipAddrValue)
 		}
-		nwCfg.IPAllocMap.Set(ipAddrValue)
-	}
 
-	if strings.Contains(subnetIP, "-") {
-		netutils.SetBitsOutsideRange(&nwCfg.IPAllocMap, subnetIP, subnetLen)
+		if strings.Contains(subnetIP, "-") {
+			netutils.SetBitsOutsideRange(&nwCfg.IPAllocMap, subnetIP, subnetLen)
+		}
 	}
 
 	if network.IPv6Gateway != "" {
@@ -157,8 +500,15 @@ func CreateNetwork(network intent.ConfigNetwork, stateDriver core.StateDriver, t
 		netutils.ReserveIPv6HostID(hostID, &nwCfg.IPv6AllocMap)
 	}
 
-	// Allocate pkt tags
+	// Allocate pkt tags, a zero tag is allocated from the pool
 	reqPktTag := uint(network.PktTag)
+	if reqPktTag != 0 && (nwCfg.PktTagType == "vlan" || nwCfg.PktTagType == "vxlan") {
+		err = checkPktTagAvailable(stateDriver, &gCfg, nwCfg.PktTagType, reqPktTag)
+		if err != nil {
+			log.Errorf("Error validating %s for network %s. Err: %v", nwCfg.PktTagType, networkID, err)
+			return err
+		}
+	}
 	if nwCfg.PktTagType == "vlan" {
 		pktTag, err = gCfg.AllocVLAN(reqPktTag)
 		if err != nil {
@@ -244,8 +594,28 @@ func freeNetworkResources(stateDriver core.StateDriver, nwCfg *mastercfg.CfgNetw
 	return err
 }
 
-// DeleteNetworkID removes a network by ID.
+// DeleteNetworkID removes a network by ID. With a network delete grace
+// period set, a network with endpoints is only marked as deleting; it is
+// removed by ReapDeletingNetworks.
 func DeleteNetworkID(stateDriver core.StateDriver, netID string) error {
+	return deleteNetworkID(stateDriver, netID, masterRTCfg.networkDeleteGrace)
+}
+
+// ForceDeleteNetworkID removes a network by ID without a grace period. The
+// endpoints left on a network being deleted are removed with it.
+func ForceDeleteNetworkID(stateDriver core.StateDriver, netID string) error {
+	nwCfg := &mastercfg.CfgNetworkState{}
+	nwCfg.StateDriver = stateDriver
+	if err := nwCfg.Read(netID); err != nil {
+		return err
+	}
+	if nwCfg.Deleting {
+		return finishNetworkDelete(stateDriver, nwCfg)
+	}
+	return deleteNetworkID(stateDriver, netID, 0)
+}
+
+func deleteNetworkID(stateDriver core.StateDriver, netID string, grace time.Duration) error {
 	nwCfg := &mastercfg.CfgNetworkState{}
 	nwCfg.StateDriver = stateDriver
 	err := nwCfg.Read(netID)
@@ -258,9 +628,19 @@ func DeleteNetworkID(stateDriver core.StateDriver, netID string) error {
 	aci, _ := IsAciConfigured()
 
 	if nwCfg.NwType != "infra" {
+		if grace > 0 && hasActiveEndpoints(stateDriver, nwCfg) {
+			if nwCfg.Deleting {
+				return nil
+			}
+			nwCfg.Deleting = true
+			nwCfg.DeleteDeadline = time.Now().Add(grace).Unix()
+			log.Infof("Network %s has %d endpoints, deleting it in %v at the latest", netID, nwCfg.EpCount, grace)
+			return nwCfg.Write()
+		}
+
 		// For Infra nw, endpoint delete initiated by netplugin
 		// Check if there are any active endpoints
-		if hasActiveEndpoints(nwCfg) {
+		if hasActiveEndpoints(stateDriver, nwCfg) {
 			return core.Errorf("Error: Network has active endpoints")
 		}
 
@@ -274,6 +654,14 @@ func DeleteNetworkID(stateDriver core.StateDriver, netID string) error {
 				return err
 			}
 		}
+
+		// recycled endpoints go with their network
+		if err := purgeRecycledEndpoints(stateDriver, netID); err != nil {
+			return err
+		}
+		if err := nwCfg.Read(netID); err != nil {
+			return err
+		}
 	}
 
 	gstate.GlobalMutex.Lock()
@@ -301,7 +689,64 @@ func DeleteNetworkID(stateDriver core.StateDriver, netID string) error {
 		return err
 	}
 
-	return err
+	// a schedule left behind would delete a network recreated with the id
+	return clearScheduledDelete(stateDriver, netID)
+}
+
+// ReapDeletingNetworks removes the networks being deleted whose endpoints
+// are gone or whose grace period elapsed
+func ReapDeletingNetworks(stateDriver core.StateDriver) error {
+	nwCfg := &mastercfg.CfgNetworkState{}
+	nwCfg.StateDriver = stateDriver
+	nwCfgs, err := nwCfg.ReadAll()
+	if core.ErrIfKeyExists(err) != nil {
+		return err
+	}
+
+	now := time.Now().Unix()
+	for _, state := range nwCfgs {
+		nw := state.(*mastercfg.CfgNetworkState)
+		if !nw.Deleting || (hasActiveEndpoints(stateDriver, nw) && now < nw.DeleteDeadline) {
+			continue
+		}
+		if err := finishNetworkDelete(stateDriver, nw); err != nil {
+			log.Errorf("Error deleting network %s. Err: %v", nw.ID, err)
+		}
+	}
+	return nil
+}
+
+// finishNetworkDelete removes a network being deleted, along with the
+// endpoints it still has. Those are marked evicted before they are removed,
+// for their homing host to remove their ports.
+func finishNetworkDelete(stateDriver core.StateDriver, nwCfg *mastercfg.CfgNetworkState) error {
+	if hasActiveEndpoints(stateDriver, nwCfg) {
+		log.Warnf("Removing the %d endpoints left on network %s", nwCfg.EpCount, nwCfg.ID)
+		addrMutex.Lock()
+		defer addrMutex.Unlock()
+
+		epCfg := &mastercfg.CfgEndpointState{}
+		epCfg.StateDriver = stateDriver
+		epCfgs, err := epCfg.ReadAll()
+		if core.ErrIfKeyExists(err) != nil {
+			return err
+		}
+		for _, state := range epCfgs {
+			ep := state.(*mastercfg.CfgEndpointState)
+			if ep.NetID != nwCfg.ID {
+				continue
+			}
+			ep.StateDriver = stateDriver
+			ep.Evicted = true
+			if err := ep.Write(); err != nil {
+				return err
+			}
+			if _, err := DeleteEndpointID(stateDriver, ep.ID); err != nil {
+				return err
+			}
+		}
+	}
+	return deleteNetworkID(stateDriver, nwCfg.ID, 0)
 }
 
 // DeleteNetworks removes all the virtual networks for a given tenant.
@@ -342,9 +787,50 @@ func ListAvailableIPs(nwCfg *mastercfg.CfgNetworkState) string {
 	return netutils.ListAvailableIPs(nwCfg.IPAllocMap, nwCfg.SubnetIP, nwCfg.SubnetLen)
 }
 
-// Allocate an address from the network
+// IPPoolUtilization returns the number of allocated and of usable IPv4
+// addresses of a network. The subnet and broadcast addresses are not usable,
+// the gateway and the pools of endpoint groups count as allocated.
+func IPPoolUtilization(nwCfg *mastercfg.CfgNetworkState) (int, int, error) {
+	if nwCfg.IPAM == mastercfg.IPAMDhcp {
+		return 0, 0, core.Errorf("addresses of network %s are not allocated by netmaster", nwCfg.ID)
+	}
+	addrRange := strings.Split(nwCfg.IPAddrRange, "-")
+	if nwCfg.SubnetIP == "" || len(addrRange) != 2 {
+		return 0, 0, core.Errorf("network %s has no address pool", nwCfg.ID)
+	}
+	first, err := netutils.GetIPNumber(nwCfg.SubnetIP, nwCfg.SubnetLen, 32, addrRange[0])
+	if err != nil {
+		return 0, 0, err
+	}
+	last, err := netutils.GetIPNumber(nwCfg.SubnetIP, nwCfg.SubnetLen, 32, addrRange[1])
+	if err != nil {
+		return 0, 0, err
+	}
+
+	broadcast := uint(1<<(32-nwCfg.SubnetLen)) - 1
+	reserved := func(ipAddrValue uint) bool {
+		return ipAddrValue == 0 || ipAddrValue == broadcast
+	}
+
+	total := int(last - first + 1)
+	for _, ipAddrValue := range []uint{first, last} {
+		if reserved(ipAddrValue) {
+			total--
+		}
+	}
+	used := 0
+	for ipAddrValue, ok := nwCfg.IPAllocMap.NextSet(first); ok && ipAddrValue <= last; ipAddrValue, ok = nwCfg.IPAllocMap.NextSet(ipAddrValue + 1) {
+		if !reserved(ipAddrValue) {
+			used++
+		}
+	}
+	return used, total, nil
+}
+
+// Allocate an address from the network. Addresses in the quarantine of the
+// network are only allocated with forceReuse, or when no other is free.
 func networkAllocAddress(nwCfg *mastercfg.CfgNetworkState, epgCfg *mastercfg.EndpointGroupState,
-	reqAddr string, isIPv6 bool) (string, error) {
+	reqAddr string, isIPv6 bool, forceReuse bool) (string, error) {
 	var ipAddress string
 	var ipAddrValue uint
 	var found bool
@@ -370,7 +856,7 @@ func networkAllocAddress(nwCfg *mastercfg.CfgNetworkState, epgCfg *mastercfg.End
 		} else {
 			if epgCfg != nil && len(epgCfg.IPPool) > 0 { // allocate from epg network
 				log.Infof("allocating ip address from epg pool %s", epgCfg.IPPool)
-				ipAddrValue, found = netutils.NextClear(epgCfg.EPGIPAllocMap, 0, nwCfg.SubnetLen)
+				ipAddrValue, found = nextClearIP(nwCfg, epgCfg.EPGIPAllocMap, forceReuse)
 				if !found {
 					log.Errorf("auto allocation failed - address exhaustion in pool %s",
 						epgCfg.IPPool)
@@ -385,7 +871,7 @@ func networkAllocAddress(nwCfg *mastercfg.CfgNetworkState, epgCfg *mastercfg.End
 				}
 				epgCfg.EPGIPAllocMap.Set(ipAddrValue)
 			} else {
-				ipAddrValue, found = netutils.NextClear(nwCfg.IPAllocMap, 0, nwCfg.SubnetLen)
+				ipAddrValue, found = nextClearIP(nwCfg, unreservedAllocMap(nwCfg), forceReuse)
 				if !found {
 					log.Errorf("auto allocation failed - address exhaustion in subnet %s/%d",
 						nwCfg.SubnetIP, nwCfg.SubnetLen)
@@ -410,7 +896,7 @@ func networkAllocAddress(nwCfg *mastercfg.CfgNetworkState, epgCfg *mastercfg.End
 		// allocateAddress had allocated in the earlier call.
 		nwCfg.EpAddrCount++
 
-	} else if reqAddr != "" && nwCfg.SubnetIP != "" {
+	} else if isIPv6 && nwCfg.IPv6Subnet != "" || !isIPv6 && nwCfg.SubnetIP != "" {
 		if isIPv6 {
 			hostID, err = netutils.GetIPv6HostID(nwCfg.IPv6Subnet, nwCfg.IPv6SubnetLen, reqAddr)
 			if err != nil {
@@ -420,6 +906,10 @@ func networkAllocAddress(nwCfg *mastercfg.CfgNetworkState, epgCfg *mastercfg.End
 			}
 			netutils.ReserveIPv6HostID(hostID, &nwCfg.IPv6AllocMap)
 		} else {
+			if !forceReuse && ipQuarantined(nwCfg, reqAddr, time.Now()) {
+				return "", core.Errorf("address %s was released less than %d seconds ago",
+					reqAddr, nwCfg.IPQuarantine)
+			}
 
 			if epgCfg != nil && len(epgCfg.IPPool) > 0 { // allocate from epg network
 				ipAddrValue, err = netutils.GetIPNumber(nwCfg.SubnetIP, nwCfg.SubnetLen, 32, reqAddr)
@@ -442,6 +932,9 @@ func networkAllocAddress(nwCfg *mastercfg.CfgNetworkState, epgCfg *mastercfg.End
 
 		ipAddress = reqAddr
 	}
+	if !isIPv6 {
+		delete(nwCfg.IPReleaseTimes, ipAddress)
+	}
 
 	if epgCfg != nil && len(epgCfg.IPPool) > 0 {
 		err = epgCfg.Write()
@@ -464,10 +957,10 @@ func networkAllocAddress(nwCfg *mastercfg.CfgNetworkState, epgCfg *mastercfg.End
 func networkReleaseAddress(nwCfg *mastercfg.CfgNetworkState, epgCfg *mastercfg.EndpointGroupState, ipAddress string) error {
 	isIPv6 := netutils.IsIPv6(ipAddress)
 	if isIPv6 {
-		hostID, err := netutils.GetIPv6HostID(nwCfg.SubnetIP, nwCfg.SubnetLen, ipAddress)
+		hostID, err := netutils.GetIPv6HostID(nwCfg.IPv6Subnet, nwCfg.IPv6SubnetLen, ipAddress)
 		if err != nil {
 			log.Errorf("error getting host id from hostIP %s Subnet %s/%d. Error: %s",
-				ipAddress, nwCfg.SubnetIP, nwCfg.SubnetLen, err)
+				ipAddress, nwCfg.IPv6Subnet, nwCfg.IPv6SubnetLen, err)
 			return err
 		}
 		// networkReleaseAddress is called from multiple places
@@ -516,6 +1009,7 @@ func networkReleaseAddress(nwCfg *mastercfg.CfgNetworkState, epgCfg *mastercfg.E
 				"from networkId:%+v", ipAddrValue,
 				nwCfg.NetworkName)
 		}
+		quarantineIP(nwCfg, ipAddress)
 	}
 	err := nwCfg.Write()
 	if err != nil {
@@ -526,6 +1020,64 @@ func networkReleaseAddress(nwCfg *mastercfg.CfgNetworkState, epgCfg *mastercfg.E
 	return nil
 }
 
-func hasActiveEndpoints(nwCfg *mastercfg.CfgNetworkState) bool {
-	return nwCfg.EpCount > 0
+// ipQuarantined returns whether an address released from the network is
+// still in the quarantine window of the network
+func ipQuarantined(nwCfg *mastercfg.CfgNetworkState, ipAddress string, now time.Time) bool {
+	released, ok := nwCfg.IPReleaseTimes[ipAddress]
+	quarantine := time.Duration(nwCfg.IPQuarantine) * time.Second
+	return ok && now.Before(time.Unix(released, 0).Add(quarantine))
+}
+
+// quarantineIP records the release time of an address when the network has
+// a quarantine window, and forgets the addresses out of quarantine
+func quarantineIP(nwCfg *mastercfg.CfgNetworkState, ipAddress string) {
+	if nwCfg.IPQuarantine <= 0 {
+		return
+	}
+
+	now := time.Now()
+	for addr := range nwCfg.IPReleaseTimes {
+		if !ipQuarantined(nwCfg, addr, now) {
+			delete(nwCfg.IPReleaseTimes, addr)
+		}
+	}
+	if nwCfg.IPReleaseTimes == nil {
+		nwCfg.IPReleaseTimes = make(map[string]int64)
+	}
+	nwCfg.IPReleaseTimes[ipAddress] = now.Unix()
+}
+
+// nextClearIP returns the first free address of allocMap that is not in
+// quarantine. The first free address is returned with forceReuse, or when
+// all the free addresses are in quarantine.
+func nextClearIP(nwCfg *mastercfg.CfgNetworkState, allocMap bitset.BitSet, forceReuse bool) (uint, bool) {
+	return nextClearIPIn(nwCfg, allocMap, 0, uint(1<<(32-nwCfg.SubnetLen))-1, forceReuse)
+}
+
+// nextClearIPIn is nextClearIP among the addresses numbered first to last
+func nextClearIPIn(nwCfg *mastercfg.CfgNetworkState, allocMap bitset.BitSet, first, last uint, forceReuse bool) (uint, bool) {
+	free, found := netutils.NextClear(allocMap, first, nwCfg.SubnetLen)
+	if !found || free > last {
+		return 0, false
+	}
+	if forceReuse || len(nwCfg.IPReleaseTimes) == 0 {
+		return free, true
+	}
+
+	now := time.Now()
+	for ipAddrValue, ok := free, found; ok && ipAddrValue <= last; ipAddrValue, ok = netutils.NextClear(allocMap, ipAddrValue+1, nwCfg.SubnetLen) {
+		ipAddress, err := netutils.GetSubnetIP(nwCfg.SubnetIP, nwCfg.SubnetLen, 32, ipAddrValue)
+		if err != nil || !ipQuarantined(nwCfg, ipAddress, now) {
+			return ipAddrValue, true
+		}
+	}
+
+	log.Warnf("All free addresses of network %s are in quarantine, reusing a released address", nwCfg.ID)
+	return free, true
+}
+
+// hasActiveEndpoints returns true when network nwCfg has endpoints besides
+// its recycled ones, which go with the network
+func hasActiveEndpoints(stateDriver core.StateDriver, nwCfg *mastercfg.CfgNetworkState) bool {
+	return nwCfg.EpCount > recycledEndpointCount(stateDriver, nwCfg.ID)
 }
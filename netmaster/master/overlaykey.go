@@ -0,0 +1,91 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package master
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+	"github.com/contiv/netplugin/utils"
+)
+
+// overlayKeyLen is the length in bytes of the generated overlay keys
+const overlayKeyLen = 32
+
+// RotateOverlayKeyResponse is the response to an overlay key rotation
+type RotateOverlayKeyResponse struct {
+	Generation int // generation of the new key
+}
+
+// ensureOverlayKey generates the key of the encrypted overlay when there is
+// none yet
+func ensureOverlayKey(stateDriver core.StateDriver) error {
+	keyCfg := &mastercfg.OverlayKeyState{}
+	keyCfg.StateDriver = stateDriver
+	if keyCfg.Read(mastercfg.OverlayKeyID) == nil {
+		return nil
+	}
+	return writeOverlayKey(keyCfg, 1)
+}
+
+// RotateOverlayKey replaces the key of the encrypted overlay and returns the
+// generation of the new key. The plugins rekey their tunnels when they see
+// the new key.
+func RotateOverlayKey(stateDriver core.StateDriver) (int, error) {
+	keyCfg := &mastercfg.OverlayKeyState{}
+	keyCfg.StateDriver = stateDriver
+	if err := keyCfg.Read(mastercfg.OverlayKeyID); err != nil {
+		return 0, core.Errorf("no overlay key to rotate, no network is encrypted")
+	}
+
+	if err := writeOverlayKey(keyCfg, keyCfg.Generation+1); err != nil {
+		return 0, err
+	}
+	log.Infof("Rotated the overlay key to generation %d", keyCfg.Generation)
+	return keyCfg.Generation, nil
+}
+
+// writeOverlayKey writes a new random overlay key of the given generation
+func writeOverlayKey(keyCfg *mastercfg.OverlayKeyState, generation int) error {
+	key := make([]byte, overlayKeyLen)
+	if _, err := rand.Read(key); err != nil {
+		return err
+	}
+
+	keyCfg.ID = mastercfg.OverlayKeyID
+	keyCfg.Key = hex.EncodeToString(key)
+	keyCfg.Generation = generation
+	return keyCfg.Write()
+}
+
+// RotateOverlayKeyHandler rotates the overlay key
+func RotateOverlayKeyHandler(w http.ResponseWriter, r *http.Request, vars map[string]string) (interface{}, error) {
+	stateDriver, err := utils.GetStateDriver()
+	if err != nil {
+		return nil, err
+	}
+
+	generation, err := RotateOverlayKey(stateDriver)
+	if err != nil {
+		log.Errorf("Error rotating the overlay key. Err: %v", err)
+		return nil, err
+	}
+	return RotateOverlayKeyResponse{Generation: generation}, nil
+}
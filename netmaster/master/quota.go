@@ -0,0 +1,169 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package master
+
+import (
+	"fmt"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/netmaster/intent"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+	"github.com/contiv/netplugin/utils/netutils"
+)
+
+// resources of a tenant capped by its quota
+const (
+	QuotaNetworks  = "networks"
+	QuotaEndpoints = "endpoints"
+	QuotaAddresses = "addresses"
+)
+
+// QuotaExceededError is returned when a create would take a tenant over
+// its quota
+type QuotaExceededError struct {
+	Tenant   string
+	Resource string
+	Limit    int
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("quota exceeded - tenant %s is limited to %d %s", e.Tenant, e.Limit, e.Resource)
+}
+
+// TenantUsage is what a tenant consumes of the resources capped by quotas
+type TenantUsage struct {
+	Networks  int `json:"networks"`
+	Endpoints int `json:"endpoints"`
+	Addresses int `json:"addresses"`
+}
+
+// subnetSize returns the number of IPv4 addresses of subnet cidr, none when
+// the network has no IPv4 subnet
+func subnetSize(cidr string) int {
+	if cidr == "" {
+		return 0
+	}
+	_, subnetLen, err := netutils.ParseCIDR(cidr)
+	if err != nil || subnetLen > 32 {
+		return 0
+	}
+	return 1 << (32 - subnetLen)
+}
+
+// SetTenantQuota sets the quota of tenant quota.ID. A quota of no limits
+// removes it. A quota below what the tenant consumes only fails its
+// creates until it consumes less.
+func SetTenantQuota(stateDriver core.StateDriver, quota *mastercfg.TenantQuotaState) error {
+	if quota.ID == "" {
+		return core.Errorf("tenant name is required")
+	}
+	if quota.Networks < 0 || quota.Endpoints < 0 || quota.Addresses < 0 {
+		return core.Errorf("invalid quota %d networks, %d endpoints, %d addresses, limits can not be negative",
+			quota.Networks, quota.Endpoints, quota.Addresses)
+	}
+
+	quota.StateDriver = stateDriver
+	if quota.Networks == 0 && quota.Endpoints == 0 && quota.Addresses == 0 {
+		if err := quota.Clear(); core.ErrIfKeyExists(err) != nil {
+			return err
+		}
+		log.Infof("Removed the quota of tenant %s", quota.ID)
+		return nil
+	}
+	if err := quota.Write(); err != nil {
+		log.Errorf("error writing the quota of tenant %s. Error: %s", quota.ID, err)
+		return err
+	}
+	log.Infof("Set the quota of tenant %s to %d networks, %d endpoints, %d addresses",
+		quota.ID, quota.Networks, quota.Endpoints, quota.Addresses)
+	return nil
+}
+
+// GetTenantQuota returns the quota of tenant, with no limits when it has
+// none
+func GetTenantQuota(stateDriver core.StateDriver, tenant string) (*mastercfg.TenantQuotaState, error) {
+	quota := &mastercfg.TenantQuotaState{}
+	quota.StateDriver = stateDriver
+	if err := quota.Read(tenant); core.ErrIfKeyExists(err) != nil {
+		return nil, err
+	}
+	quota.ID = tenant
+	return quota, nil
+}
+
+// GetTenantUsage returns what tenant consumes. The networks being deleted
+// and their endpoints still count, as do the recycled endpoints.
+func GetTenantUsage(stateDriver core.StateDriver, tenant string) (*TenantUsage, error) {
+	nwCfg := &mastercfg.CfgNetworkState{}
+	nwCfg.StateDriver = stateDriver
+	nwStates, err := nwCfg.ReadAll()
+	if core.ErrIfKeyExists(err) != nil {
+		return nil, err
+	}
+
+	usage := &TenantUsage{}
+	for _, state := range nwStates {
+		nw := state.(*mastercfg.CfgNetworkState)
+		if nw.Tenant != tenant {
+			continue
+		}
+		usage.Networks++
+		usage.Endpoints += nw.EpCount
+		if nw.SubnetIP != "" {
+			usage.Addresses += 1 << (32 - nw.SubnetLen)
+		}
+	}
+	return usage, nil
+}
+
+// checkNetworkQuota fails the create of network for tenant when it takes
+// the tenant over its quota of networks or addresses
+func checkNetworkQuota(stateDriver core.StateDriver, tenant string, network *intent.ConfigNetwork) error {
+	quota, err := GetTenantQuota(stateDriver, tenant)
+	if err != nil || (quota.Networks == 0 && quota.Addresses == 0) {
+		return err
+	}
+	usage, err := GetTenantUsage(stateDriver, tenant)
+	if err != nil {
+		return err
+	}
+	if quota.Networks != 0 && usage.Networks+1 > quota.Networks {
+		return &QuotaExceededError{Tenant: tenant, Resource: QuotaNetworks, Limit: quota.Networks}
+	}
+	if quota.Addresses != 0 && usage.Addresses+subnetSize(network.SubnetCIDR) > quota.Addresses {
+		return &QuotaExceededError{Tenant: tenant, Resource: QuotaAddresses, Limit: quota.Addresses}
+	}
+	return nil
+}
+
+// checkEndpointQuota fails the create of an endpoint for tenant when it
+// takes the tenant over its quota of endpoints. Callers hold addrMutex, so
+// that the check and the allocation of the endpoint are not interleaved.
+func checkEndpointQuota(stateDriver core.StateDriver, tenant string) error {
+	quota, err := GetTenantQuota(stateDriver, tenant)
+	if err != nil || quota.Endpoints == 0 {
+		return err
+	}
+	usage, err := GetTenantUsage(stateDriver, tenant)
+	if err != nil {
+		return err
+	}
+	if usage.Endpoints+1 > quota.Endpoints {
+		return &QuotaExceededError{Tenant: tenant, Resource: QuotaEndpoints, Limit: quota.Endpoints}
+	}
+	return nil
+}
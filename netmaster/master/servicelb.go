@@ -77,7 +77,7 @@ func CreateServiceLB(stateDriver core.StateDriver, serviceLbCfg *intent.ConfigSe
 	}
 
 	// Alloc addresses
-	addr, err := networkAllocAddress(nwCfg, nil, serviceIP, false)
+	addr, err := networkAllocAddress(nwCfg, nil, serviceIP, false, false)
 	if err != nil {
 		log.Errorf("Failed to allocate address. Err: %v", err)
 		return err
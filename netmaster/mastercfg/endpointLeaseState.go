@@ -0,0 +1,61 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mastercfg
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/contiv/netplugin/core"
+)
+
+const (
+	endpointLeasePathPrefix = StateConfigPath + "epleases/"
+	endpointLeasePath       = endpointLeasePathPrefix + "%s"
+)
+
+// EndpointLeaseState is the lease of an endpoint with a lease ttl, by
+// endpoint id. Its homing host renews it, netmaster removes the endpoint
+// once the lease is past its expiry.
+type EndpointLeaseState struct {
+	core.CommonState
+	TTL     int   `json:"ttl"`     // seconds the lease lasts from a renewal
+	Expiry  int64 `json:"expiry"`  // unix time the lease expires at
+	Expired bool  `json:"expired"` // set by netmaster before it removes the endpoint
+}
+
+// Write the state
+func (s *EndpointLeaseState) Write() error {
+	key := fmt.Sprintf(endpointLeasePath, s.ID)
+	return s.StateDriver.WriteState(key, s, json.Marshal)
+}
+
+// Read the state in for a given ID.
+func (s *EndpointLeaseState) Read(id string) error {
+	key := fmt.Sprintf(endpointLeasePath, id)
+	return s.StateDriver.ReadState(key, s, json.Unmarshal)
+}
+
+// ReadAll reads the leases of all the endpoints.
+func (s *EndpointLeaseState) ReadAll() ([]core.State, error) {
+	return s.StateDriver.ReadAllState(endpointLeasePathPrefix, s, json.Unmarshal)
+}
+
+// Clear removes the lease from the state store.
+func (s *EndpointLeaseState) Clear() error {
+	key := fmt.Sprintf(endpointLeasePath, s.ID)
+	return s.StateDriver.ClearState(key)
+}
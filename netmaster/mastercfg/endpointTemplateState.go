@@ -0,0 +1,66 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mastercfg
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/contiv/netplugin/core"
+)
+
+const (
+	endpointTemplatePathPrefix = StateConfigPath + "endpointTemplates/"
+	endpointTemplatePath       = endpointTemplatePathPrefix + "%s"
+)
+
+// EndpointTemplateState is a named set of endpoint options that endpoint
+// specs can reference. The id is the template name.
+type EndpointTemplateState struct {
+	core.CommonState
+	ServiceName         string               `json:"serviceName"`
+	MTU                 int                  `json:"mtu"`
+	Labels              map[string]string    `json:"labels"`
+	Routes              []EndpointRoute      `json:"routes"`
+	Driver              string               `json:"driver"`
+	LeaseTTL            int                  `json:"leaseTtl"`
+	Sysctls             map[string]string    `json:"sysctls"`
+	EthtoolFeatures     map[string]bool      `json:"ethtoolFeatures"`
+	ConntrackExemptions []ConntrackExemption `json:"conntrackExemptions"`
+}
+
+// Write the state
+func (s *EndpointTemplateState) Write() error {
+	key := fmt.Sprintf(endpointTemplatePath, s.ID)
+	return s.StateDriver.WriteState(key, s, json.Marshal)
+}
+
+// Read the state in for a given ID.
+func (s *EndpointTemplateState) Read(id string) error {
+	key := fmt.Sprintf(endpointTemplatePath, id)
+	return s.StateDriver.ReadState(key, s, json.Unmarshal)
+}
+
+// ReadAll reads all the endpoint templates.
+func (s *EndpointTemplateState) ReadAll() ([]core.State, error) {
+	return s.StateDriver.ReadAllState(endpointTemplatePathPrefix, s, json.Unmarshal)
+}
+
+// Clear removes the endpoint template from the state store.
+func (s *EndpointTemplateState) Clear() error {
+	key := fmt.Sprintf(endpointTemplatePath, s.ID)
+	return s.StateDriver.ClearState(key)
+}
@@ -18,6 +18,8 @@ package mastercfg
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/contiv/netplugin/core"
 )
@@ -26,20 +28,129 @@ import (
 // vlans with ovs. The state is stored as Json objects.
 type CfgEndpointState struct {
 	core.CommonState
-	NetID            string            `json:"netID"`
-	EndpointID       string            `json:"endpointID"`
-	ServiceName      string            `json:"serviceName"`
-	EndpointGroupID  int               `json:"endpointGroupId"`
-	EndpointGroupKey string            `json:"endpointGroupKey"`
-	IPAddress        string            `json:"ipAddress"`
-	IPv6Address      string            `json:"ipv6Address"`
-	MacAddress       string            `json:"macAddress"`
-	HomingHost       string            `json:"homingHost"`
-	IntfName         string            `json:"intfName"`
-	VtepIP           string            `json:"vtepIP"`
-	Labels           map[string]string `json:"labels"`
-	ContainerID      string            `json:"containerId"`
-	EPCommonName     string            `json:"epCommonName"`
+	NetID               string               `json:"netID"`
+	EndpointID          string               `json:"endpointID"`
+	ServiceName         string               `json:"serviceName"`
+	EndpointGroupID     int                  `json:"endpointGroupId"`
+	EndpointGroupKey    string               `json:"endpointGroupKey"`
+	IPAddress           string               `json:"ipAddress"`
+	IPv6Address         string               `json:"ipv6Address"`
+	MacAddress          string               `json:"macAddress"`
+	HomingHost          string               `json:"homingHost"`
+	IntfName            string               `json:"intfName"`
+	IntfNameAuto        bool                 `json:"intfNameAuto"` // IntfName was picked on attach, not requested
+	VtepIP              string               `json:"vtepIP"`
+	Labels              map[string]string    `json:"labels"`
+	ContainerID         string               `json:"containerId"`
+	EPCommonName        string               `json:"epCommonName"`
+	HostIntfName        string               `json:"hostIntfName"`
+	SecondaryIPs        []string             `json:"secondaryIPs"`
+	Retained            bool                 `json:"retained"` // container died, endpoint kept
+	DSCP                int                  `json:"dscp"`
+	CoS                 int                  `json:"cos"`
+	Routes              []EndpointRoute      `json:"routes"`
+	ConntrackExemptions []ConntrackExemption `json:"conntrackExemptions"`
+	MTU                 int                  `json:"mtu"` // overrides the network mtu
+	Status              string               `json:"status"`
+	Sysctls             map[string]string    `json:"sysctls"`
+	EthtoolFeatures     map[string]bool      `json:"ethtoolFeatures"`
+	Promisc             bool                 `json:"promisc"`      // interface in promiscuous mode
+	AllMulti            bool                 `json:"allMulti"`     // interface receiving all multicast
+	Driver              string               `json:"driver"`       // endpoint driver, the network driver when empty
+	LeaseTTL            int                  `json:"leaseTtl"`     // seconds the endpoint lives without a keepalive, 0 never expires
+	NetnsPath           string               `json:"netnsPath"`    // container netns given on attach
+	HostEndpoint        bool                 `json:"hostEndpoint"` // attaches the host, the interface stays in the host netns
+	Bond                *EndpointBond        `json:"bond"`         // port of the endpoint is a bond of host uplinks
+	Tap                 bool                 `json:"tap"`          // port of the endpoint is a tap device handed to a hypervisor
+	RateLimit           *EndpointRateLimit   `json:"rateLimit"`    // limits the traffic the endpoint sends
+	Vrf                 string               `json:"vrf"`          // vrf of the network of the endpoint
+	Evicted             bool                 `json:"evicted"`      // removed by netmaster rather than by its host
+}
+
+// Endpoint status values
+const (
+	EndpointStatusAttached = "attached"
+	EndpointStatusDetached = "detached"
+	EndpointStatusLinkDown = "link-down" // port link went down while attached
+)
+
+// EndpointRoute is a static route of an endpoint. A route has either a
+// single gateway or weighted next hops, which make it a multipath route.
+type EndpointRoute struct {
+	Dest     string         `json:"dest"`
+	Gateway  string         `json:"gateway"`
+	NextHops []RouteNextHop `json:"nextHops,omitempty"`
+}
+
+// EndpointBond is the bond of host uplinks the datapath reaches an endpoint
+// through, in place of a veth pair
+type EndpointBond struct {
+	Mode    string   `json:"mode"`
+	Members []string `json:"members"`
+}
+
+// Endpoint bond modes
+const (
+	BondModeActiveBackup = "active-backup"
+	BondModeLACP         = "lacp" // 802.3ad
+)
+
+// EndpointRateLimit is the rate limit of the traffic an endpoint sends. The
+// driver enforces it with a meter when the switch supports meters, by
+// policing the port of the endpoint otherwise.
+type EndpointRateLimit struct {
+	Rate  int64 `json:"rate"`  // kbps
+	Burst int   `json:"burst"` // kb, 0 for the default of the switch
+}
+
+// Rate limiters an endpoint rate limit is enforced with
+const (
+	RateLimiterMeter    = "meter"
+	RateLimiterPolicing = "policing"
+)
+
+// ConntrackExemption is the five-tuple of flows of an endpoint that bypass
+// connection tracking. Empty addresses and zero ports match any.
+type ConntrackExemption struct {
+	Protocol string `json:"protocol"`
+	SrcIP    string `json:"srcIP,omitempty"`
+	SrcPort  int    `json:"srcPort,omitempty"`
+	DstIP    string `json:"dstIP,omitempty"`
+	DstPort  int    `json:"dstPort,omitempty"`
+}
+
+// IPv6 tells whether the exemption matches ipv6 addresses
+func (e *ConntrackExemption) IPv6() bool {
+	return strings.Contains(e.SrcIP+e.DstIP, ":")
+}
+
+// RouteNextHop is one of the paths of a multipath route
+type RouteNextHop struct {
+	Gateway string `json:"gateway"`
+	Weight  int    `json:"weight"` // 1 to 256, 1 when unset
+}
+
+// Route next hop weight bounds, as supported by the kernel
+const (
+	MinRouteWeight = 1
+	MaxRouteWeight = 256
+)
+
+// Hops returns the next hops of the route. A route with a single gateway
+// has one next hop of weight 1.
+func (r *EndpointRoute) Hops() []RouteNextHop {
+	if len(r.NextHops) == 0 {
+		return []RouteNextHop{{Gateway: r.Gateway, Weight: MinRouteWeight}}
+	}
+
+	hops := make([]RouteNextHop, len(r.NextHops))
+	for idx, hop := range r.NextHops {
+		hops[idx] = hop
+		if hop.Weight == 0 {
+			hops[idx].Weight = MinRouteWeight
+		}
+	}
+	return hops
 }
 
 // Write the state.
@@ -54,11 +165,42 @@ func (s *CfgEndpointState) Read(id string) error {
 	return s.StateDriver.ReadState(key, s, json.Unmarshal)
 }
 
+// AddrCIDRs returns the primary and secondary addresses of the endpoint
+// with the prefix length of their subnet in network nw
+func (s *CfgEndpointState) AddrCIDRs(nw *CfgNetworkState) []string {
+	cidrs := []string{}
+	if s.IPAddress != "" {
+		cidrs = append(cidrs, fmt.Sprintf("%s/%d", s.IPAddress, nw.SubnetLen))
+	}
+	if s.IPv6Address != "" {
+		cidrs = append(cidrs, fmt.Sprintf("%s/%d", s.IPv6Address, nw.IPv6SubnetLen))
+	}
+	for _, addr := range s.SecondaryIPs {
+		subnetLen := nw.SubnetLen
+		if strings.Contains(addr, ":") {
+			subnetLen = nw.IPv6SubnetLen
+		}
+		cidrs = append(cidrs, fmt.Sprintf("%s/%d", addr, subnetLen))
+	}
+	return cidrs
+}
+
 // ReadAll reads all state objects for the endpoints.
 func (s *CfgEndpointState) ReadAll() ([]core.State, error) {
 	return s.StateDriver.ReadAllState(endpointConfigPathPrefix, s, json.Unmarshal)
 }
 
+// ReadAllWith reads all the endpoints at consistency, for listings that can
+// be served by a replica of the store.
+func (s *CfgEndpointState) ReadAllWith(consistency core.ReadConsistency) ([]core.State, error) {
+	return core.ReadAllStateWith(s.StateDriver, endpointConfigPathPrefix, s, json.Unmarshal, consistency)
+}
+
+// ReadAllRaw returns the undecoded records of all endpoints
+func (s *CfgEndpointState) ReadAllRaw() ([][]byte, error) {
+	return s.StateDriver.ReadAll(endpointConfigPathPrefix)
+}
+
 // WatchAll fills a channel on each state event related to endpoints.
 func (s *CfgEndpointState) WatchAll(rsps chan core.WatchState) error {
 	return s.StateDriver.WatchAllState(endpointConfigPathPrefix, s, json.Unmarshal,
@@ -70,3 +212,73 @@ func (s *CfgEndpointState) Clear() error {
 	key := fmt.Sprintf(endpointConfigPath, s.ID)
 	return s.StateDriver.ClearState(key)
 }
+
+// EndpointMacState records the mac address last assigned to an endpoint id,
+// so that an endpoint recreated with the same id keeps its mac. The record
+// outlives the endpoint and is garbage collected once it has been released
+// for long enough.
+type EndpointMacState struct {
+	core.CommonState
+	NetID      string    `json:"netID"`
+	MacAddress string    `json:"macAddress"`
+	ReleasedAt time.Time `json:"releasedAt"` // zero while the endpoint exists
+}
+
+// Write the state.
+func (s *EndpointMacState) Write() error {
+	key := fmt.Sprintf(endpointMacPath, s.ID)
+	return s.StateDriver.WriteState(key, s, json.Marshal)
+}
+
+// Read the state for a given identifier.
+func (s *EndpointMacState) Read(id string) error {
+	key := fmt.Sprintf(endpointMacPath, id)
+	return s.StateDriver.ReadState(key, s, json.Unmarshal)
+}
+
+// ReadAll reads all state objects for the endpoint macs.
+func (s *EndpointMacState) ReadAll() ([]core.State, error) {
+	return s.StateDriver.ReadAllState(endpointMacPathPrefix, s, json.Unmarshal)
+}
+
+// Clear removes the state.
+func (s *EndpointMacState) Clear() error {
+	key := fmt.Sprintf(endpointMacPath, s.ID)
+	return s.StateDriver.ClearState(key)
+}
+
+// MacOwnerState records the endpoint holding a mac in a network, live or
+// kept for its recreation, so that no other endpoint of the network is
+// given the mac.
+type MacOwnerState struct {
+	core.CommonState
+	EndpointID string `json:"endpointID"`
+}
+
+// MacOwnerID returns the id of the owner record of mac in network netID
+func MacOwnerID(netID, mac string) string {
+	return netID + "-" + strings.Replace(strings.ToLower(mac), ":", "", -1)
+}
+
+// Write the state.
+func (s *MacOwnerState) Write() error {
+	key := fmt.Sprintf(macOwnerPath, s.ID)
+	return s.StateDriver.WriteState(key, s, json.Marshal)
+}
+
+// Read the state for a given identifier.
+func (s *MacOwnerState) Read(id string) error {
+	key := fmt.Sprintf(macOwnerPath, id)
+	return s.StateDriver.ReadState(key, s, json.Unmarshal)
+}
+
+// ReadAll reads all state objects for the mac owners.
+func (s *MacOwnerState) ReadAll() ([]core.State, error) {
+	return s.StateDriver.ReadAllState(macOwnerPathPrefix, s, json.Unmarshal)
+}
+
+// Clear removes the state.
+func (s *MacOwnerState) Clear() error {
+	key := fmt.Sprintf(macOwnerPath, s.ID)
+	return s.StateDriver.ClearState(key)
+}
@@ -64,6 +64,10 @@ func (d *testglobalStateDriver) ClearState(key string) error {
 	return d.validateKey(key)
 }
 
+func (d *testglobalStateDriver) Lock(key string) (func(), error) {
+	return nil, core.Errorf("not supported")
+}
+
 func (d *testglobalStateDriver) ReadState(key string, value core.State,
 	unmarshal func([]byte, interface{}) error) error {
 	return d.validateKey(key)
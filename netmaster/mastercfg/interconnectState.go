@@ -0,0 +1,74 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mastercfg
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/contiv/netplugin/core"
+)
+
+const (
+	interconnectPathPrefix = StateConfigPath + "interconnect/"
+	interconnectPath       = interconnectPathPrefix + "%s"
+)
+
+// InterconnectState is a patch port pair stitching the bridges of two
+// networks together on a host
+type InterconnectState struct {
+	core.CommonState
+	Host string `json:"host"`
+	NetA string `json:"netA"`
+	NetB string `json:"netB"`
+}
+
+// InterconnectID returns the id of the interconnect of two networks on a
+// host, the same whichever order the networks are given in
+func InterconnectID(host, netA, netB string) string {
+	if netB < netA {
+		netA, netB = netB, netA
+	}
+	return host + ":" + netA + ":" + netB
+}
+
+// Connects tells whether the interconnect involves network netID
+func (s *InterconnectState) Connects(netID string) bool {
+	return s.NetA == netID || s.NetB == netID
+}
+
+// Write the state
+func (s *InterconnectState) Write() error {
+	key := fmt.Sprintf(interconnectPath, s.ID)
+	return s.StateDriver.WriteState(key, s, json.Marshal)
+}
+
+// Read the state in for a given ID.
+func (s *InterconnectState) Read(id string) error {
+	key := fmt.Sprintf(interconnectPath, id)
+	return s.StateDriver.ReadState(key, s, json.Unmarshal)
+}
+
+// ReadAll reads all the interconnects.
+func (s *InterconnectState) ReadAll() ([]core.State, error) {
+	return s.StateDriver.ReadAllState(interconnectPathPrefix, s, json.Unmarshal)
+}
+
+// Clear removes the interconnect from the state store.
+func (s *InterconnectState) Clear() error {
+	key := fmt.Sprintf(interconnectPath, s.ID)
+	return s.StateDriver.ClearState(key)
+}
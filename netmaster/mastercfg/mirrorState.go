@@ -0,0 +1,69 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mastercfg
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/contiv/netplugin/core"
+)
+
+const (
+	mirrorPathPrefix = StateConfigPath + "mirrors/"
+	mirrorPath       = mirrorPathPrefix + "%s"
+)
+
+// MirrorState is a mirror of the traffic of an endpoint to a capture port
+// on the host the endpoint is wired on
+type MirrorState struct {
+	core.CommonState
+	Host       string `json:"host"`
+	EndpointID string `json:"endpointID"`
+	DestPort   string `json:"destPort"` // the capture interface
+}
+
+// MirrorID returns the id of the mirror of endpoint epID to destPort on a
+// host. It is short enough to name the mirror in the datapath.
+func MirrorID(host, epID, destPort string) string {
+	sum := sha1.Sum([]byte(host + ":" + epID + ":" + destPort))
+	return "mir" + hex.EncodeToString(sum[:4])
+}
+
+// Write the state
+func (s *MirrorState) Write() error {
+	key := fmt.Sprintf(mirrorPath, s.ID)
+	return s.StateDriver.WriteState(key, s, json.Marshal)
+}
+
+// Read the state in for a given ID.
+func (s *MirrorState) Read(id string) error {
+	key := fmt.Sprintf(mirrorPath, id)
+	return s.StateDriver.ReadState(key, s, json.Unmarshal)
+}
+
+// ReadAll reads all the mirrors.
+func (s *MirrorState) ReadAll() ([]core.State, error) {
+	return s.StateDriver.ReadAllState(mirrorPathPrefix, s, json.Unmarshal)
+}
+
+// Clear removes the mirror from the state store.
+func (s *MirrorState) Clear() error {
+	key := fmt.Sprintf(mirrorPath, s.ID)
+	return s.StateDriver.ClearState(key)
+}
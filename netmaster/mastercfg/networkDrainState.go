@@ -0,0 +1,69 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mastercfg
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/contiv/netplugin/core"
+)
+
+const (
+	networkDrainPathPrefix = StateConfigPath + "drains/"
+	networkDrainPath       = networkDrainPathPrefix + "%s"
+)
+
+// NetworkDrainState is the progress of the drain of the endpoints a host
+// has on a network to a target network, by NetworkDrainID. It is kept until
+// the drain completes so that an interrupted drain can be resumed.
+type NetworkDrainState struct {
+	core.CommonState
+	Host    string            `json:"host"`
+	Target  string            `json:"target"`
+	Total   int               `json:"total"`   // endpoints to move
+	Moved   []string          `json:"moved"`   // endpoints moved, in order
+	Failed  map[string]string `json:"failed"`  // error by endpoint not moved yet
+	Started int64             `json:"started"` // unix time
+}
+
+// NetworkDrainID returns the id of the drain of network netID on host
+func NetworkDrainID(netID, host string) string {
+	return netID + "@" + host
+}
+
+// Write the state
+func (s *NetworkDrainState) Write() error {
+	key := fmt.Sprintf(networkDrainPath, s.ID)
+	return s.StateDriver.WriteState(key, s, json.Marshal)
+}
+
+// Read the state in for a given ID.
+func (s *NetworkDrainState) Read(id string) error {
+	key := fmt.Sprintf(networkDrainPath, id)
+	return s.StateDriver.ReadState(key, s, json.Unmarshal)
+}
+
+// ReadAll reads the drains in progress.
+func (s *NetworkDrainState) ReadAll() ([]core.State, error) {
+	return s.StateDriver.ReadAllState(networkDrainPathPrefix, s, json.Unmarshal)
+}
+
+// Clear removes the drain from the state store.
+func (s *NetworkDrainState) Clear() error {
+	key := fmt.Sprintf(networkDrainPath, s.ID)
+	return s.StateDriver.ClearState(key)
+}
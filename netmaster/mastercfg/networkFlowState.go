@@ -0,0 +1,70 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mastercfg
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/contiv/netplugin/core"
+)
+
+const (
+	networkFlowPathPrefix = StateConfigPath + "netflows/"
+	networkFlowPath       = networkFlowPathPrefix + "%s"
+)
+
+// NetworkFlowState is a custom OpenFlow rule of a network on a host,
+// programmed on the bridge of the network besides the flows netplugin
+// manages. Match and Actions are in the ovs-ofctl syntax.
+type NetworkFlowState struct {
+	core.CommonState
+	Host     string `json:"host"`
+	NetID    string `json:"netID"`
+	Name     string `json:"name"`
+	Table    int    `json:"table"`
+	Priority int    `json:"priority"`
+	Match    string `json:"match"` // matches all the packets when empty
+	Actions  string `json:"actions"`
+}
+
+// NetworkFlowID returns the id of custom flow name of a network on a host
+func NetworkFlowID(host, netID, name string) string {
+	return host + ":" + netID + ":" + name
+}
+
+// Write the state
+func (s *NetworkFlowState) Write() error {
+	key := fmt.Sprintf(networkFlowPath, s.ID)
+	return s.StateDriver.WriteState(key, s, json.Marshal)
+}
+
+// Read the state in for a given ID.
+func (s *NetworkFlowState) Read(id string) error {
+	key := fmt.Sprintf(networkFlowPath, id)
+	return s.StateDriver.ReadState(key, s, json.Unmarshal)
+}
+
+// ReadAll reads all the custom flows.
+func (s *NetworkFlowState) ReadAll() ([]core.State, error) {
+	return s.StateDriver.ReadAllState(networkFlowPathPrefix, s, json.Unmarshal)
+}
+
+// Clear removes the custom flow from the state store.
+func (s *NetworkFlowState) Clear() error {
+	key := fmt.Sprintf(networkFlowPath, s.ID)
+	return s.StateDriver.ClearState(key)
+}
@@ -18,6 +18,7 @@ package mastercfg
 import (
 	"encoding/json"
 	"fmt"
+	"net"
 
 	"github.com/contiv/netplugin/core"
 	"github.com/jainvipin/bitset"
@@ -30,6 +31,9 @@ const (
 	StateConfigPath = StateBasePath + "state/"
 	// StateOperPath is the path for operational/runtime state
 	StateOperPath = StateBasePath + "oper/"
+	// ReconcileLockKey is the cluster wide lock held while reconciling the
+	// state, by the netmaster reapers and the netplugin reconcilers
+	ReconcileLockKey = StateBasePath + "lock/reconcile"
 
 	networkConfigPathPrefix  = StateConfigPath + "nets/"
 	networkConfigPath        = networkConfigPathPrefix + "%s"
@@ -37,6 +41,10 @@ const (
 	endpointConfigPath       = endpointConfigPathPrefix + "%s"
 	epGroupConfigPathPrefix  = StateConfigPath + "endpointGroups/"
 	epGroupConfigPath        = epGroupConfigPathPrefix + "%s"
+	endpointMacPathPrefix    = StateConfigPath + "epMacs/"
+	endpointMacPath          = endpointMacPathPrefix + "%s"
+	macOwnerPathPrefix       = StateConfigPath + "macOwners/"
+	macOwnerPath             = macOwnerPathPrefix + "%s"
 )
 
 // CfgNetworkState implements the State interface for a network implemented using
@@ -62,8 +70,83 @@ type CfgNetworkState struct {
 	IPv6AllocMap  map[string]bool `json:"ipv6AllocMap"`
 	IPv6LastHost  string          `json:"ipv6LastHost"`
 	NetworkTag    string          `json:"networkTag"`
+	AutoCleanEps  bool            `json:"autoCleanEps"`
+	IPAM          string          `json:"ipam"`
+	MTU           int             `json:"mtu"` // endpoint mtu, net of encap overhead
+	// UnderlayMTU is the mtu the underlay links must support, checked by
+	// the drivers when set. Endpoints then use MTU as is.
+	UnderlayMTU int `json:"underlayMtu"`
+
+	DupAddrDetect  string `json:"dupAddrDetect"`  // probe endpoint addresses on attach
+	DupAddrTimeout int    `json:"dupAddrTimeout"` // seconds to wait for probe replies
+
+	// IPQuarantine is the seconds a released address is not reallocated,
+	// IPReleaseTimes the unix time addresses in quarantine were released
+	IPQuarantine   int              `json:"ipQuarantine"`
+	IPReleaseTimes map[string]int64 `json:"ipReleaseTimes"`
+
+	// Encrypt has the tunnels carrying the network between the hosts set
+	// up with IPsec, keyed from the overlay key
+	Encrypt bool `json:"encrypt"`
+
+	// GatewayArpProxy has the hosts answer the arp requests of the
+	// endpoints for the gateway with GatewayMac, the gateway being virtual
+	GatewayArpProxy bool   `json:"gatewayArpProxy"`
+	GatewayMac      string `json:"gatewayMac"`
+
+	// BUMMode is how the hosts handle the broadcast, multicast and unknown
+	// unicast traffic of the local endpoints: BUMModeFlood, the default
+	// when empty, BUMModeDrop or BUMModeController
+	BUMMode string `json:"bumMode"`
+
+	// AllocRanges are named ranges of the IPv4 subnet, first-last address,
+	// the endpoints asking for them get their address from. Addresses are
+	// only allocated from a range to the endpoints naming it.
+	AllocRanges map[string]string `json:"allocRanges"`
+
+	// Vrf is the linux vrf device, the routing domain, the interfaces of
+	// the network in the host netns are enslaved to. The driver creates
+	// it with table VrfTable when missing.
+	Vrf      string `json:"vrf"`
+	VrfTable int    `json:"vrfTable"`
+
+	// Deleting marks a network deleted while it had endpoints. It takes no
+	// new endpoints and is removed once its endpoints are gone or at
+	// DeleteDeadline, a unix time.
+	Deleting       bool  `json:"deleting"`
+	DeleteDeadline int64 `json:"deleteDeadline"`
 }
 
+// IPAMDhcp is the IPAM of networks addressed by an external dhcp server
+const IPAMDhcp = "dhcp"
+
+// Address families of the subnets of a network
+const (
+	AddrFamilyIPv4 = "ipv4"
+	AddrFamilyIPv6 = "ipv6"
+)
+
+// BUM traffic handling modes of a network
+const (
+	// BUMModeFlood floods the traffic, replicated to all the vteps on vxlan
+	BUMModeFlood = "flood"
+	// BUMModeDrop drops the traffic instead of flooding it
+	BUMModeDrop = "drop"
+	// BUMModeController sends the traffic to the openflow controller
+	BUMModeController = "controller"
+)
+
+// Duplicate address detection modes
+const (
+	// DupAddrDetectWarn logs a conflict and attaches the endpoint anyway
+	DupAddrDetectWarn = "warn"
+	// DupAddrDetectFail fails the attach on a conflict
+	DupAddrDetectFail = "fail"
+
+	// MaxDupAddrTimeout bounds the seconds an attach waits for probe replies
+	MaxDupAddrTimeout = 10
+)
+
 // Write the state.
 func (s *CfgNetworkState) Write() error {
 	key := fmt.Sprintf(networkConfigPath, s.ID)
@@ -81,6 +164,17 @@ func (s *CfgNetworkState) ReadAll() ([]core.State, error) {
 	return s.StateDriver.ReadAllState(networkConfigPathPrefix, s, json.Unmarshal)
 }
 
+// ReadAllWith reads all the networks at consistency, for listings that can
+// be served by a replica of the store.
+func (s *CfgNetworkState) ReadAllWith(consistency core.ReadConsistency) ([]core.State, error) {
+	return core.ReadAllStateWith(s.StateDriver, networkConfigPathPrefix, s, json.Unmarshal, consistency)
+}
+
+// ReadAllRaw returns the undecoded records of all networks
+func (s *CfgNetworkState) ReadAllRaw() ([][]byte, error) {
+	return s.StateDriver.ReadAll(networkConfigPathPrefix)
+}
+
 // WatchAll state transitions and send them through the channel.
 func (s *CfgNetworkState) WatchAll(rsps chan core.WatchState) error {
 	return s.StateDriver.WatchAllState(networkConfigPathPrefix, s, json.Unmarshal,
@@ -93,6 +187,37 @@ func (s *CfgNetworkState) Clear() error {
 	return s.StateDriver.ClearState(key)
 }
 
+// OnLink tells whether ip is in one of the subnets of the network, i.e. can
+// be reached from the interface of an endpoint without a gateway
+func (s *CfgNetworkState) OnLink(ip net.IP) bool {
+	subnet, subnetLen := s.SubnetIP, s.SubnetLen
+	if ip.To4() == nil {
+		subnet, subnetLen = s.IPv6Subnet, s.IPv6SubnetLen
+	}
+	if subnet == "" {
+		return false
+	}
+
+	_, ipNet, err := net.ParseCIDR(fmt.Sprintf("%s/%d", subnet, subnetLen))
+	if err != nil {
+		return false
+	}
+	return ipNet.Contains(ip)
+}
+
+// AddrFamilies returns the address families of the subnets of the network,
+// IPv4 first, the families its endpoints get an address of
+func (s *CfgNetworkState) AddrFamilies() []string {
+	families := []string{}
+	if s.SubnetIP != "" {
+		families = append(families, AddrFamilyIPv4)
+	}
+	if s.IPv6Subnet != "" {
+		families = append(families, AddrFamilyIPv6)
+	}
+	return families
+}
+
 // IncrEpCount Increments endpoint count
 func (s *CfgNetworkState) IncrEpCount() error {
 	s.EpCount++
@@ -16,6 +16,7 @@ limitations under the License.
 package mastercfg
 
 import (
+	"net"
 	"testing"
 
 	"github.com/contiv/netplugin/core"
@@ -66,6 +67,10 @@ func (d *testNwStateDriver) ClearState(key string) error {
 	return d.validateKey(key)
 }
 
+func (d *testNwStateDriver) Lock(key string) (func(), error) {
+	return nil, core.Errorf("not supported")
+}
+
 func (d *testNwStateDriver) ReadState(key string, value core.State,
 	unmarshal func([]byte, interface{}) error) error {
 	return d.validateKey(key)
@@ -117,3 +122,22 @@ func TestCfgNetworkStateClear(t *testing.T) {
 		t.Fatalf("clear config state failed. Error: %s", err)
 	}
 }
+
+func TestCfgNetworkStateOnLink(t *testing.T) {
+	nwCfg := &CfgNetworkState{SubnetIP: "10.1.1.0", SubnetLen: 24}
+	for ip, onLink := range map[string]bool{
+		"10.1.1.1":   true,
+		"10.1.1.255": true,
+		"10.1.2.1":   false,
+		"2016::1":    false,
+	} {
+		if nwCfg.OnLink(net.ParseIP(ip)) != onLink {
+			t.Fatalf("%s: expected on link %v", ip, onLink)
+		}
+	}
+
+	nwCfg.IPv6Subnet, nwCfg.IPv6SubnetLen = "2016::", 100
+	if !nwCfg.OnLink(net.ParseIP("2016::1")) {
+		t.Fatalf("2016::1 not on link in %s/%d", nwCfg.IPv6Subnet, nwCfg.IPv6SubnetLen)
+	}
+}
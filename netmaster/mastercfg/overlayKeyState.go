@@ -0,0 +1,68 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mastercfg
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/contiv/netplugin/core"
+)
+
+const (
+	overlayKeyPathPrefix = StateConfigPath + "overlayKey/"
+	overlayKeyPath       = overlayKeyPathPrefix + "%s"
+)
+
+// OverlayKeyID is the id of the key of the encrypted overlay
+const OverlayKeyID = "global"
+
+// OverlayKeyState is the pre-shared key of the IPsec tunnels between the
+// vteps of the hosts. A rotation writes a new key with the next generation.
+type OverlayKeyState struct {
+	core.CommonState
+	Key        string `json:"key"`
+	Generation int    `json:"generation"`
+}
+
+// Write the state
+func (s *OverlayKeyState) Write() error {
+	key := fmt.Sprintf(overlayKeyPath, s.ID)
+	return s.StateDriver.WriteState(key, s, json.Marshal)
+}
+
+// Read the state in for a given ID.
+func (s *OverlayKeyState) Read(id string) error {
+	key := fmt.Sprintf(overlayKeyPath, id)
+	return s.StateDriver.ReadState(key, s, json.Unmarshal)
+}
+
+// ReadAll reads all the overlay keys.
+func (s *OverlayKeyState) ReadAll() ([]core.State, error) {
+	return s.StateDriver.ReadAllState(overlayKeyPathPrefix, s, json.Unmarshal)
+}
+
+// Clear removes the key from the state store.
+func (s *OverlayKeyState) Clear() error {
+	key := fmt.Sprintf(overlayKeyPath, s.ID)
+	return s.StateDriver.ClearState(key)
+}
+
+// WatchAll state transitions and send them through the channel.
+func (s *OverlayKeyState) WatchAll(rsps chan core.WatchState) error {
+	return s.StateDriver.WatchAllState(overlayKeyPathPrefix, s, json.Unmarshal,
+		rsps)
+}
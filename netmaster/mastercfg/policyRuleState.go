@@ -32,6 +32,10 @@ const (
 type CfgPolicyRule struct {
 	core.CommonState
 	ofnet.OfnetPolicyRule
+
+	// Log has the agents log the packets matching the rule, which ofnet
+	// does not know about
+	Log bool `json:"log"`
 }
 
 // Write the state.
@@ -63,11 +67,13 @@ func (s *CfgPolicyRule) Clear() error {
 	return s.StateDriver.ClearState(key)
 }
 
-// addPolicyRuleState adds policy rule to state store
-func addPolicyRuleState(ofnetRule *ofnet.OfnetPolicyRule) error {
+// addPolicyRuleState adds policy rule to state store, logging the packets
+// matching it when logRule is set
+func addPolicyRuleState(ofnetRule *ofnet.OfnetPolicyRule, logRule bool) error {
 	ruleCfg := &CfgPolicyRule{}
 	ruleCfg.StateDriver = stateStore
 	ruleCfg.OfnetPolicyRule = (*ofnetRule)
+	ruleCfg.Log = logRule
 
 	// Save the rule
 	return ruleCfg.Write()
@@ -66,6 +66,10 @@ func (d *testRuleStateDriver) ClearState(key string) error {
 	return d.validateKey(key)
 }
 
+func (d *testRuleStateDriver) Lock(key string) (func(), error) {
+	return nil, core.Errorf("not supported")
+}
+
 func (d *testRuleStateDriver) ReadState(key string, value core.State,
 	unmarshal func([]byte, interface{}) error) error {
 	return d.validateKey(key)
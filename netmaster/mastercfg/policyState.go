@@ -173,7 +173,9 @@ func (gp *EpgPolicy) createOfnetRule(rule *contivModel.Rule, dir string) (*ofnet
 	// Create an ofnet rule
 	ofnetRule := new(ofnet.OfnetPolicyRule)
 	ofnetRule.RuleId = ruleID
-	ofnetRule.Priority = rule.Priority
+	// the ofnet priorities are even, leaving the odd one above each rule to
+	// the flow logging its packets
+	ofnetRule.Priority = 2 * rule.Priority
 	ofnetRule.Action = rule.Action
 
 	// See if user specified an endpoint Group in the rule
@@ -300,7 +302,7 @@ func (gp *EpgPolicy) createOfnetRule(rule *contivModel.Rule, dir string) (*ofnet
 	}
 
 	// Send AddRule to netplugin agents
-	err = addPolicyRuleState(ofnetRule)
+	err = addPolicyRuleState(ofnetRule, rule.Log)
 	if err != nil {
 		log.Errorf("Error creating rule {%+v}. Err: %v", ofnetRule, err)
 		return nil, err
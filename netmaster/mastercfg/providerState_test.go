@@ -66,6 +66,10 @@ func (d *testSvcProviderStateDriver) ClearState(key string) error {
 	return d.validateKey(key)
 }
 
+func (d *testSvcProviderStateDriver) Lock(key string) (func(), error) {
+	return nil, core.Errorf("not supported")
+}
+
 func (d *testSvcProviderStateDriver) ReadState(key string, value core.State,
 	unmarshal func([]byte, interface{}) error) error {
 	return d.validateKey(key)
@@ -0,0 +1,60 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mastercfg
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/contiv/netplugin/core"
+)
+
+const (
+	recycledEndpointPathPrefix = StateConfigPath + "recycledeps/"
+	recycledEndpointPath       = recycledEndpointPathPrefix + "%s"
+)
+
+// RecycledEndpointState is an endpoint deleted while an endpoint retention
+// is set. Its addresses stay allocated until it is restored or removed for
+// good once the retention elapsed.
+type RecycledEndpointState struct {
+	core.CommonState
+	Endpoint  CfgEndpointState `json:"endpoint"`
+	DeletedAt int64            `json:"deletedAt"` // unix time
+}
+
+// Write the state.
+func (s *RecycledEndpointState) Write() error {
+	key := fmt.Sprintf(recycledEndpointPath, s.ID)
+	return s.StateDriver.WriteState(key, s, json.Marshal)
+}
+
+// Read the state for a given identifier.
+func (s *RecycledEndpointState) Read(id string) error {
+	key := fmt.Sprintf(recycledEndpointPath, id)
+	return s.StateDriver.ReadState(key, s, json.Unmarshal)
+}
+
+// ReadAll reads all the recycled endpoints.
+func (s *RecycledEndpointState) ReadAll() ([]core.State, error) {
+	return s.StateDriver.ReadAllState(recycledEndpointPathPrefix, s, json.Unmarshal)
+}
+
+// Clear removes the state.
+func (s *RecycledEndpointState) Clear() error {
+	key := fmt.Sprintf(recycledEndpointPath, s.ID)
+	return s.StateDriver.ClearState(key)
+}
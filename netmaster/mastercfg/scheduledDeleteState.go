@@ -0,0 +1,58 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mastercfg
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/contiv/netplugin/core"
+)
+
+const (
+	scheduledDeletePathPrefix = StateConfigPath + "scheduleddeletes/"
+	scheduledDeletePath       = scheduledDeletePathPrefix + "%s"
+)
+
+// ScheduledDeleteState is the deletion of a network scheduled for a later
+// time, keyed by network id. Netmaster deletes the network once At is past.
+type ScheduledDeleteState struct {
+	core.CommonState
+	At int64 `json:"at"` // unix time
+}
+
+// Write the state.
+func (s *ScheduledDeleteState) Write() error {
+	key := fmt.Sprintf(scheduledDeletePath, s.ID)
+	return s.StateDriver.WriteState(key, s, json.Marshal)
+}
+
+// Read the state for a given identifier.
+func (s *ScheduledDeleteState) Read(id string) error {
+	key := fmt.Sprintf(scheduledDeletePath, id)
+	return s.StateDriver.ReadState(key, s, json.Unmarshal)
+}
+
+// ReadAll reads all the scheduled deletes.
+func (s *ScheduledDeleteState) ReadAll() ([]core.State, error) {
+	return s.StateDriver.ReadAllState(scheduledDeletePathPrefix, s, json.Unmarshal)
+}
+
+// Clear removes the state.
+func (s *ScheduledDeleteState) Clear() error {
+	key := fmt.Sprintf(scheduledDeletePath, s.ID)
+	return s.StateDriver.ClearState(key)
+}
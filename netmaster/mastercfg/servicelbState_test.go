@@ -66,6 +66,10 @@ func (d *testServiceLBStateDriver) ClearState(key string) error {
 	return d.validateKey(key)
 }
 
+func (d *testServiceLBStateDriver) Lock(key string) (func(), error) {
+	return nil, core.Errorf("not supported")
+}
+
 func (d *testServiceLBStateDriver) ReadState(key string, value core.State,
 	unmarshal func([]byte, interface{}) error) error {
 	return d.validateKey(key)
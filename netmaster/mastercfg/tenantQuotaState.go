@@ -0,0 +1,60 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mastercfg
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/contiv/netplugin/core"
+)
+
+const (
+	tenantQuotaPathPrefix = StateConfigPath + "tenantquotas/"
+	tenantQuotaPath       = tenantQuotaPathPrefix + "%s"
+)
+
+// TenantQuotaState caps the resources a tenant, by name, can consume. A
+// limit of zero leaves the resource unlimited.
+type TenantQuotaState struct {
+	core.CommonState
+	Networks  int `json:"networks"`
+	Endpoints int `json:"endpoints"`
+	Addresses int `json:"addresses"` // IPv4 addresses of the subnets of its networks
+}
+
+// Write the state
+func (s *TenantQuotaState) Write() error {
+	key := fmt.Sprintf(tenantQuotaPath, s.ID)
+	return s.StateDriver.WriteState(key, s, json.Marshal)
+}
+
+// Read the state in for a given ID.
+func (s *TenantQuotaState) Read(id string) error {
+	key := fmt.Sprintf(tenantQuotaPath, id)
+	return s.StateDriver.ReadState(key, s, json.Unmarshal)
+}
+
+// ReadAll reads the quotas of all the tenants.
+func (s *TenantQuotaState) ReadAll() ([]core.State, error) {
+	return s.StateDriver.ReadAllState(tenantQuotaPathPrefix, s, json.Unmarshal)
+}
+
+// Clear removes the quota of the tenant from the state store.
+func (s *TenantQuotaState) Clear() error {
+	key := fmt.Sprintf(tenantQuotaPath, s.ID)
+	return s.StateDriver.ClearState(key)
+}
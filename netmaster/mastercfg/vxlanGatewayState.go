@@ -0,0 +1,67 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mastercfg
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/contiv/netplugin/core"
+)
+
+const (
+	vxlanGatewayPathPrefix = StateConfigPath + "vxlangw/"
+	vxlanGatewayPath       = vxlanGatewayPathPrefix + "%s"
+)
+
+// VxlanGatewayState is a gateway bridging a vlan of a host interface to a
+// vxlan network on the host
+type VxlanGatewayState struct {
+	core.CommonState
+	Host   string `json:"host"`
+	NetID  string `json:"netID"`  // the vxlan network
+	Uplink string `json:"uplink"` // the interface carrying the vlan
+	Vlan   int    `json:"vlan"`
+}
+
+// VxlanGatewayID returns the id of the gateway of vxlan network netID on a
+// host
+func VxlanGatewayID(host, netID string) string {
+	return host + ":" + netID
+}
+
+// Write the state
+func (s *VxlanGatewayState) Write() error {
+	key := fmt.Sprintf(vxlanGatewayPath, s.ID)
+	return s.StateDriver.WriteState(key, s, json.Marshal)
+}
+
+// Read the state in for a given ID.
+func (s *VxlanGatewayState) Read(id string) error {
+	key := fmt.Sprintf(vxlanGatewayPath, id)
+	return s.StateDriver.ReadState(key, s, json.Unmarshal)
+}
+
+// ReadAll reads all the vxlan gateways.
+func (s *VxlanGatewayState) ReadAll() ([]core.State, error) {
+	return s.StateDriver.ReadAllState(vxlanGatewayPathPrefix, s, json.Unmarshal)
+}
+
+// Clear removes the vxlan gateway from the state store.
+func (s *VxlanGatewayState) Clear() error {
+	key := fmt.Sprintf(vxlanGatewayPath, s.ID)
+	return s.StateDriver.ClearState(key)
+}
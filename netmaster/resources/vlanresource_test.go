@@ -336,6 +336,10 @@ func (d *testVlanRsrcStateDriver) ClearState(key string) error {
 	return d.validate(key, nil, vLANResourceOperClear)
 }
 
+func (d *testVlanRsrcStateDriver) Lock(key string) (func(), error) {
+	return nil, core.Errorf("not supported")
+}
+
 func (d *testVlanRsrcStateDriver) ReadState(key string, value core.State,
 	unmarshal func([]byte, interface{}) error) error {
 	return d.validate(key, value, vLANResourceOperRead)
@@ -401,6 +401,10 @@ func (d *testVXLANRsrcStateDriver) ClearState(key string) error {
 	return d.validate(key, nil, vXLANResourceOpClear)
 }
 
+func (d *testVXLANRsrcStateDriver) Lock(key string) (func(), error) {
+	return nil, core.Errorf("not supported")
+}
+
 func (d *testVXLANRsrcStateDriver) ReadState(key string, value core.State,
 	unmarshal func([]byte, interface{}) error) error {
 	return d.validate(key, value, vXLANResourceOpRead)
@@ -16,7 +16,9 @@ limitations under the License.
 package agent
 
 import (
+	"bytes"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
 	"time"
@@ -26,6 +28,7 @@ import (
 	"github.com/contiv/netplugin/mgmtfn/dockplugin"
 	"github.com/contiv/netplugin/mgmtfn/k8splugin"
 	"github.com/contiv/netplugin/mgmtfn/mesosplugin"
+	"github.com/contiv/netplugin/netmaster/master"
 	"github.com/contiv/netplugin/netmaster/mastercfg"
 	"github.com/contiv/netplugin/netplugin/cluster"
 	"github.com/contiv/netplugin/netplugin/plugin"
@@ -56,7 +59,16 @@ func NewAgent(pluginConfig *plugin.Config) *Agent {
 	}
 
 	// Init the driver plugins..
-	err = netPlugin.Init(*pluginConfig)
+	if pluginConfig.ConfigFromStore {
+		err = netPlugin.InitFromStore(*pluginConfig)
+		if err == nil {
+			*pluginConfig = netPlugin.PluginConfig
+			opts = pluginConfig.Instance
+			err = netPlugin.WatchConfig(context.Background())
+		}
+	} else {
+		err = netPlugin.Init(*pluginConfig)
+	}
 	if err != nil {
 		log.Fatalf("Failed to initialize the plugin. Error: %s", err)
 	}
@@ -224,6 +236,8 @@ func (ag *Agent) HandleEvents() error {
 
 	go handlePolicyRuleEvents(ag.netPlugin, opts, recvErr)
 
+	go handleOverlayKeyEvents(ag.netPlugin, opts, recvErr)
+
 	if ag.pluginConfig.Instance.PluginMode == core.Docker ||
 		ag.pluginConfig.Instance.PluginMode == core.SwarmMode {
 		go ag.monitorDockerEvents(recvErr)
@@ -287,9 +301,20 @@ func (ag *Agent) serveRequests() {
 		w.Write(ns)
 	})
 
+	s.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		if err := ag.writeMetrics(w); err != nil {
+			log.Errorf("Error fetching metrics. Err: %v", err)
+			http.Error(w, "Error fetching metrics", http.StatusInternalServerError)
+		}
+	})
+
 	s = router.Methods("Delete").Subrouter()
 	s.HandleFunc("/debug/reclaimEndpoint/{id}", utils.MakeHTTPHandler(ag.ReclaimEndpointHandler))
 
+	s = router.Methods("POST").Subrouter()
+	s.HandleFunc("/debug/resyncNetwork/{id}", utils.MakeHTTPHandler(ag.ResyncNetworkHandler))
+
 	// Create HTTP server and listener
 	server := &http.Server{Handler: router}
 	listener, err := net.Listen("tcp", listenURL)
@@ -303,6 +328,51 @@ func (ag *Agent) serveRequests() {
 	go server.Serve(listener)
 }
 
+// writeMetrics writes the gauges of the agent in the prometheus text format
+func (ag *Agent) writeMetrics(w io.Writer) error {
+	nwCfg := &mastercfg.CfgNetworkState{}
+	nwCfg.StateDriver = ag.netPlugin.StateDriver
+	// metrics can lag behind, leave the leader of the store alone
+	networks, err := nwCfg.ReadAllWith(core.Serializable)
+	if core.ErrIfKeyExists(err) != nil {
+		return err
+	}
+
+	var buf, usedBuf, sizeBuf bytes.Buffer
+	fmt.Fprintf(&buf, "# HELP contiv_network_endpoints Number of endpoints of a network.\n")
+	fmt.Fprintf(&buf, "# TYPE contiv_network_endpoints gauge\n")
+	fmt.Fprintf(&usedBuf, "# HELP contiv_network_ip_pool_used Number of allocated addresses of the IPv4 pool of a network.\n")
+	fmt.Fprintf(&usedBuf, "# TYPE contiv_network_ip_pool_used gauge\n")
+	fmt.Fprintf(&sizeBuf, "# HELP contiv_network_ip_pool_size Number of usable addresses of the IPv4 pool of a network.\n")
+	fmt.Fprintf(&sizeBuf, "# TYPE contiv_network_ip_pool_size gauge\n")
+	for _, nw := range networks {
+		nwCfg := nw.(*mastercfg.CfgNetworkState)
+		fmt.Fprintf(&buf, "contiv_network_endpoints{network=%q} %d\n", nwCfg.ID, nwCfg.EpCount)
+
+		// networks addressed by dhcp have no pool
+		if nwCfg.IPAM == mastercfg.IPAMDhcp {
+			continue
+		}
+		used, total, err := master.IPPoolUtilization(nwCfg)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&usedBuf, "contiv_network_ip_pool_used{network=%q} %d\n", nwCfg.ID, used)
+		fmt.Fprintf(&sizeBuf, "contiv_network_ip_pool_size{network=%q} %d\n", nwCfg.ID, total)
+	}
+
+	for _, b := range []*bytes.Buffer{&buf, &usedBuf, &sizeBuf} {
+		if _, err := b.WriteTo(w); err != nil {
+			return err
+		}
+	}
+
+	if mw, ok := ag.netPlugin.StateDriver.(core.MetricsWriter); ok {
+		return mw.WriteMetrics(w)
+	}
+	return nil
+}
+
 // ReclaimEndpointHandler reclaims endpoint
 func (ag *Agent) ReclaimEndpointHandler(w http.ResponseWriter, r *http.Request, vars map[string]string) (interface{}, error) {
 	epID := vars["id"]
@@ -316,3 +386,15 @@ func (ag *Agent) ReclaimEndpointHandler(w http.ResponseWriter, r *http.Request,
 
 	return nil, nil
 }
+
+// ResyncNetworkHandler reprograms the datapath of a network from state
+func (ag *Agent) ResyncNetworkHandler(w http.ResponseWriter, r *http.Request, vars map[string]string) (interface{}, error) {
+	nwID := vars["id"]
+	err := ag.netPlugin.ResyncNetwork(nwID)
+	if err != nil {
+		log.Errorf("Error resyncing network %v. Err: %v", nwID, err)
+		return nil, err
+	}
+
+	return nil, nil
+}
@@ -59,6 +59,10 @@ func (ag *Agent) handleDockerEvents(events <-chan events.Message, errs <-chan er
 			}
 		case event := <-events:
 			log.Debugf("Received Docker event: {%#v}\n", event)
+			if event.Status == "die" {
+				ag.handleContainerDeath(event.ID)
+			}
+
 			// process events only when LB services exist.
 			if !ag.lbServiceExist() {
 				continue
@@ -130,6 +134,77 @@ func (ag *Agent) handleDockerEvents(events <-chan events.Message, errs <-chan er
 	}
 }
 
+// handleContainerDeath cleans up the endpoints of a dead container. Endpoints
+// on networks configured to auto-clean are deleted, the others are flagged
+// as retained.
+func (ag *Agent) handleContainerDeath(containerID string) {
+	defaultHeaders := map[string]string{"User-Agent": "Docker-Client/" + dockerversion.Version + " (" + runtime.GOOS + ")"}
+	cli, err := dockerclient.NewClient("unix:///var/run/docker.sock", "v1.21", nil, defaultHeaders)
+	if err != nil {
+		log.Errorf("Error connecting to docker. Err: %v", err)
+		return
+	}
+
+	containerInfo, err := cli.ContainerInspect(context.Background(), containerID)
+	if err != nil {
+		log.Errorf("Container Inspect failed :%s", err)
+		return
+	}
+	if containerInfo.NetworkSettings == nil {
+		return
+	}
+
+	stateDriver := ag.Plugin().StateDriver
+	for _, endpoint := range containerInfo.NetworkSettings.Networks {
+		tenant, network, serviceName, err := dockplugin.GetDockerNetworkName(endpoint.NetworkID)
+		if err != nil {
+			// not a contiv network
+			continue
+		}
+
+		netID := network + "." + tenant
+		epCfg := &mastercfg.CfgEndpointState{}
+		epCfg.StateDriver = stateDriver
+		if err := epCfg.Read(netID + "-" + endpoint.EndpointID); err != nil {
+			continue
+		}
+
+		nwCfg := &mastercfg.CfgNetworkState{}
+		nwCfg.StateDriver = stateDriver
+		if err := nwCfg.Read(netID); err != nil {
+			log.Errorf("Error reading network %s. Err: %v", netID, err)
+			continue
+		}
+
+		if !nwCfg.AutoCleanEps {
+			log.Infof("Container %s died, retaining endpoint %s", containerID, epCfg.ID)
+			epCfg.Retained = true
+			if err := epCfg.Write(); err != nil {
+				log.Errorf("Error flagging endpoint %s as retained. Err: %v", epCfg.ID, err)
+			}
+			continue
+		}
+
+		log.Infof("Container %s died, deleting endpoint %s", containerID, epCfg.ID)
+		delReq := master.DeleteEndpointRequest{
+			TenantName:  tenant,
+			NetworkName: network,
+			ServiceName: serviceName,
+			EndpointID:  endpoint.EndpointID,
+		}
+		var delResp master.DeleteEndpointResponse
+		err = cluster.MasterPostReq("/plugin/deleteEndpoint", &delReq, &delResp)
+		if err != nil {
+			log.Errorf("Error deleting endpoint %s from netmaster. Err: %v", epCfg.ID, err)
+			continue
+		}
+
+		if err := ag.Plugin().DeleteEndpoint(epCfg.ID); err != nil {
+			log.Errorf("Error deleting endpoint %s. Err: %v", epCfg.ID, err)
+		}
+	}
+}
+
 //getLabelsFromContainerInspect returns the labels associated with the container
 func getLabelsFromContainerInspect(containerInfo *types.ContainerJSON) map[string]string {
 	if containerInfo != nil && containerInfo.Config != nil {
@@ -276,7 +276,22 @@ func processEpState(netPlugin *plugin.NetPlugin, opts core.InstanceInfo, epID st
 // processRemoteEpState updates endpoint state
 func processRemoteEpState(netPlugin *plugin.NetPlugin, opts core.InstanceInfo, epCfg *mastercfg.CfgEndpointState, isDelete bool) error {
 	if !checkRemoteHost(epCfg.VtepIP, epCfg.HomingHost, opts.HostLabel) {
-		// Skip local endpoint update, as they are handled directly in dockplugin
+		// Local endpoints are created directly in dockplugin, only keep
+		// their port labels in sync with the config and remove the ones
+		// netmaster evicted or whose lease expired
+		if !isDelete {
+			if err := netPlugin.UpdateEndpointBinding(epCfg.ID); err != nil {
+				log.Errorf("Error updating the binding of endpoint %s. Err: %v", epCfg.ID, err)
+			}
+		} else if epCfg.Evicted {
+			if err := netPlugin.EndpointEvicted(epCfg); err != nil {
+				log.Errorf("Error removing evicted endpoint %s. Err: %v", epCfg.ID, err)
+				return err
+			}
+		} else if err := netPlugin.EndpointLeaseExpired(epCfg); err != nil {
+			log.Errorf("Error removing expired endpoint %s. Err: %v", epCfg.ID, err)
+			return err
+		}
 		return nil
 	}
 
@@ -499,15 +514,7 @@ func processSvcProviderUpdEvent(netPlugin *plugin.NetPlugin, svcProvider *master
 
 // processPolicyRuleState updates policy rule state
 func processPolicyRuleState(netPlugin *plugin.NetPlugin, opts core.InstanceInfo, ruleID string, isDelete bool) error {
-	// read policy config
-	ruleCfg := &mastercfg.CfgPolicyRule{}
-	ruleCfg.StateDriver = netPlugin.StateDriver
-
-	err := ruleCfg.Read(ruleID)
-	if err != nil {
-		log.Errorf("Failed to read config for policy rule '%s' \n", ruleID)
-		return err
-	}
+	var err error
 	if isDelete {
 		// Delete endpoint
 		err = netPlugin.DelPolicyRule(ruleID)
@@ -517,6 +524,15 @@ func processPolicyRuleState(netPlugin *plugin.NetPlugin, opts core.InstanceInfo,
 		}
 		log.Infof("PolicyRule %s delete operation succeeded", ruleID)
 	} else {
+		// read policy config, it is gone once deleted
+		ruleCfg := &mastercfg.CfgPolicyRule{}
+		ruleCfg.StateDriver = netPlugin.StateDriver
+		err = ruleCfg.Read(ruleID)
+		if err != nil {
+			log.Errorf("Failed to read config for policy rule '%s' \n", ruleID)
+			return err
+		}
+
 		// Create endpoint
 		err = netPlugin.AddPolicyRule(ruleID)
 		if err != nil {
@@ -617,6 +633,12 @@ func processStateEvent(netPlugin *plugin.NetPlugin, opts core.InstanceInfo, rsps
 			log.Infof("Received %q for PolicyRule: %q", eventStr, ruleCfg.RuleId)
 			processPolicyRuleState(netPlugin, opts, ruleCfg.RuleId, isDelete)
 		}
+		if keyCfg, ok := currentState.(*mastercfg.OverlayKeyState); ok && !isDelete {
+			log.Infof("Received overlay key generation %d", keyCfg.Generation)
+			if err := netPlugin.UpdateOverlayKey(); err != nil {
+				log.Errorf("Error rekeying the overlay. Err: %v", err)
+			}
+		}
 	}
 }
 
@@ -687,6 +709,15 @@ func handleGlobalCfgEvents(netPlugin *plugin.NetPlugin, opts core.InstanceInfo,
 	log.Errorf("Error from handleGlobalCfgEvents")
 }
 
+func handleOverlayKeyEvents(netPlugin *plugin.NetPlugin, opts core.InstanceInfo, recvErr chan error) {
+	rsps := make(chan core.WatchState)
+	go processStateEvent(netPlugin, opts, rsps)
+	cfg := mastercfg.OverlayKeyState{}
+	cfg.StateDriver = netPlugin.StateDriver
+	recvErr <- cfg.WatchAll(rsps)
+	log.Errorf("Error from handleOverlayKeyEvents")
+}
+
 func handlePolicyRuleEvents(netPlugin *plugin.NetPlugin, opts core.InstanceInfo, retErr chan error) {
 	rsps := make(chan core.WatchState)
 	go processStateEvent(netPlugin, opts, rsps)
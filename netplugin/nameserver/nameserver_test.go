@@ -90,6 +90,10 @@ func (ds *dummyState) ClearState(key string) error {
 	return nil
 }
 
+func (ds *dummyState) Lock(key string) (func(), error) {
+	return func() {}, nil
+}
+
 func TestEpChanError(t *testing.T) {
 	ns := new(NetpluginNameServer)
 	ds := new(dummyState)
@@ -22,6 +22,7 @@ import (
 	"github.com/contiv/netplugin/core"
 	"github.com/contiv/netplugin/netplugin/agent"
 	"github.com/contiv/netplugin/netplugin/plugin"
+	"github.com/contiv/netplugin/state"
 	"github.com/contiv/netplugin/utils"
 	"github.com/contiv/netplugin/utils/netutils"
 	"github.com/contiv/netplugin/version"
@@ -77,6 +78,9 @@ func initNetPluginConfig(ctx *cli.Context) (*plugin.Config, error) {
 		}
 	}
 	logrus.Infof("Using netplugin host: %v", hostLabel)
+	if err := utils.InitAuditLog(binName+"@"+hostLabel, ctx.String("audit-log")); err != nil {
+		return nil, fmt.Errorf("Failed to open the audit log: %v", err)
+	}
 	controlIP := ctx.String("ctrl-ip")
 	if controlIP == "" {
 		controlIP, configErr = netutils.GetDefaultAddr()
@@ -101,23 +105,70 @@ func initNetPluginConfig(ctx *cli.Context) (*plugin.Config, error) {
 	}
 	logrus.Infof("Using netplugin vlan uplinks: %v", vlanUpLinks)
 
+	if _, err := utils.NewContainerRuntime(ctx.String("container-runtime"), ctx.String("cri-endpoint")); err != nil {
+		return nil, err
+	}
+
+	readEndpoints, err := state.ParseReadEndpoints(ctx.String("state-read-endpoints"))
+	if err != nil {
+		return nil, err
+	}
+	if len(readEndpoints) != 0 && dbConfigs.StoreDriver != "etcd" {
+		return nil, fmt.Errorf("state read endpoints need the etcd state store")
+	}
+
 	vxlanPort := ctx.Int("vxlan-port")
 	logrus.Infof("Using netplugin vxlan port: %v", vxlanPort)
 
 	return &plugin.Config{
+		ConfigFromStore:         ctx.Bool("config-from-store"),
+		LooseConfig:             ctx.Bool("loose-config"),
+		RequireContainerRuntime: ctx.Bool("require-container-runtime"),
+		MonitorLinks:            ctx.Bool("monitor-links"),
+		ClearStaleBindings:      ctx.Bool("clear-stale-bindings"),
+		RateLimit: plugin.RateLimitConfig{
+			Rate:  ctx.Float64("op-rate-limit"),
+			Burst: ctx.Int("op-rate-burst"),
+			Wait:  ctx.Int("op-rate-wait"),
+		},
 		Drivers: plugin.Drivers{
 			Network: utils.OvsNameStr,
 			State:   dbConfigs.StoreDriver,
 		},
 		Instance: core.InstanceInfo{
-			HostLabel:    hostLabel,
-			CtrlIP:       controlIP,
-			VtepIP:       vtepIP,
-			UplinkIntf:   vlanUpLinks,
-			DbURL:        dbConfigs.StoreURL,
-			PluginMode:   netConfigs.Mode,
-			VxlanUDPPort: vxlanPort,
-			FwdMode:      netConfigs.ForwardMode, // TODO: pass in network mode
+			HostLabel:          hostLabel,
+			CtrlIP:             controlIP,
+			VtepIP:             vtepIP,
+			UplinkIntf:         vlanUpLinks,
+			DbURL:              dbConfigs.StoreURL,
+			StateFormat:        dbConfigs.StoreFormat,
+			StateMetrics:       ctx.Bool("state-metrics"),
+			StateReadEndpoints: readEndpoints,
+			ContainerRuntime:   ctx.String("container-runtime"),
+			CRIEndpoint:        ctx.String("cri-endpoint"),
+			PluginMode:         netConfigs.Mode,
+			VxlanUDPPort:       vxlanPort,
+			FwdMode:            netConfigs.ForwardMode, // TODO: pass in network mode
+			OvsConfig: core.OvsDriverConfig{
+				FlowLimit:     ctx.Int("ovs-flow-limit"),
+				MaxIdle:       ctx.Int("ovs-max-idle"),
+				CtZoneLimit:   ctx.Int("ovs-ct-zone-limit"),
+				OvsdbEndpoint: ctx.String("ovsdb-endpoint"),
+				OvsdbKey:      ctx.String("ovsdb-key"),
+				OvsdbCert:     ctx.String("ovsdb-cert"),
+				OvsdbCACert:   ctx.String("ovsdb-ca-cert"),
+
+				VethPoolSize:     ctx.Int("veth-pool-size"),
+				VethPoolLowWater: ctx.Int("veth-pool-low-water"),
+				VethPoolRefill:   ctx.Int("veth-pool-refill"),
+
+				FlowLogCollector: ctx.String("flow-log-collector"),
+				FlowLogSampling:  ctx.Int("flow-log-sampling"),
+
+				EnableSTP:           ctx.Bool("ovs-enable-stp"),
+				EnableMcastSnooping: ctx.Bool("ovs-enable-mcast-snooping"),
+				AgeingTime:          ctx.Int("ovs-ageing-time"),
+			},
 		},
 	}, nil
 }
@@ -153,6 +204,147 @@ func main() {
 			EnvVar: "CONTIV_NETPLUGIN_VXLAN_PORT",
 			Usage:  "set netplugin VXLAN port",
 		},
+		cli.BoolFlag{
+			Name:   "config-from-store",
+			EnvVar: "CONTIV_NETPLUGIN_CONFIG_FROM_STORE",
+			Usage:  "read the rest of the netplugin config from the state store once it is reachable",
+		},
+		cli.BoolFlag{
+			Name:   "loose-config",
+			EnvVar: "CONTIV_NETPLUGIN_LOOSE_CONFIG",
+			Usage:  "ignore the unknown fields of the config in the state store instead of rejecting it",
+		},
+		cli.IntFlag{
+			Name:   "ovs-flow-limit",
+			EnvVar: "CONTIV_NETPLUGIN_OVS_FLOW_LIMIT",
+			Usage:  "set max number of ovs datapath flows (default: 200000)",
+		},
+		cli.IntFlag{
+			Name:   "ovs-max-idle",
+			EnvVar: "CONTIV_NETPLUGIN_OVS_MAX_IDLE",
+			Usage:  "set ms an idle ovs datapath flow is kept (default: 10000)",
+		},
+		cli.IntFlag{
+			Name:   "ovs-ct-zone-limit",
+			EnvVar: "CONTIV_NETPLUGIN_OVS_CT_ZONE_LIMIT",
+			Usage:  "set max number of conntrack entries per zone (default: unlimited)",
+		},
+		cli.BoolFlag{
+			Name:   "ovs-enable-stp",
+			EnvVar: "CONTIV_NETPLUGIN_OVS_ENABLE_STP",
+			Usage:  "enable stp on the ovs bridges",
+		},
+		cli.BoolFlag{
+			Name:   "ovs-enable-mcast-snooping",
+			EnvVar: "CONTIV_NETPLUGIN_OVS_ENABLE_MCAST_SNOOPING",
+			Usage:  "enable multicast snooping on the ovs bridges",
+		},
+		cli.IntFlag{
+			Name:   "ovs-ageing-time",
+			EnvVar: "CONTIV_NETPLUGIN_OVS_AGEING_TIME",
+			Usage:  "set seconds a learned mac is kept on the ovs bridges (default: ovs default)",
+		},
+		cli.IntFlag{
+			Name:   "veth-pool-size",
+			EnvVar: "CONTIV_NETPLUGIN_VETH_POOL_SIZE",
+			Usage:  "set number of endpoint veth pairs created ahead of the attaches (default: no pool)",
+		},
+		cli.IntFlag{
+			Name:   "veth-pool-low-water",
+			EnvVar: "CONTIV_NETPLUGIN_VETH_POOL_LOW_WATER",
+			Usage:  "refill the veth pool at once when fewer pairs are left (default: half the pool size)",
+		},
+		cli.IntFlag{
+			Name:   "veth-pool-refill",
+			EnvVar: "CONTIV_NETPLUGIN_VETH_POOL_REFILL",
+			Usage:  "set seconds between background refills of the veth pool (default: 30)",
+		},
+		cli.StringFlag{
+			Name:   "flow-log-collector",
+			EnvVar: "CONTIV_NETPLUGIN_FLOW_LOG_COLLECTOR",
+			Usage:  "set host:port of the IPFIX collector of the logged policy rules (default: log their packet counts)",
+		},
+		cli.IntFlag{
+			Name:   "flow-log-sampling",
+			EnvVar: "CONTIV_NETPLUGIN_FLOW_LOG_SAMPLING",
+			Usage:  "send one in this many packets of the logged policy rules to the collector (default: 1)",
+		},
+		cli.StringFlag{
+			Name:   "container-runtime",
+			Value:  utils.RuntimeDocker,
+			EnvVar: "CONTIV_NETPLUGIN_CONTAINER_RUNTIME",
+			Usage:  "set container runtime owning the pod netns: docker, containerd or crio",
+		},
+		cli.StringFlag{
+			Name:   "cri-endpoint",
+			EnvVar: "CONTIV_NETPLUGIN_CRI_ENDPOINT",
+			Usage:  "set CRI socket of the containerd or crio runtime (default: the socket of the runtime)",
+		},
+		cli.BoolFlag{
+			Name:   "require-container-runtime",
+			EnvVar: "CONTIV_NETPLUGIN_REQUIRE_CONTAINER_RUNTIME",
+			Usage:  "fail to start when the container runtime can not be reached",
+		},
+		cli.BoolFlag{
+			Name:   "state-metrics",
+			EnvVar: "CONTIV_NETPLUGIN_STATE_METRICS",
+			Usage:  "export the request latency, errors and leader of the etcd state store on /metrics",
+		},
+		cli.StringFlag{
+			Name:   "state-read-endpoints",
+			EnvVar: "CONTIV_NETPLUGIN_STATE_READ_ENDPOINTS",
+			Usage:  "spread the serializable etcd reads over a comma-delimited list of url=weight endpoints",
+		},
+		cli.BoolFlag{
+			Name:   "monitor-links",
+			EnvVar: "CONTIV_NETPLUGIN_MONITOR_LINKS",
+			Usage:  "track the link of endpoint ports in the endpoint status",
+		},
+		cli.BoolFlag{
+			Name:   "clear-stale-bindings",
+			EnvVar: "CONTIV_NETPLUGIN_CLEAR_STALE_BINDINGS",
+			Usage:  "clear endpoint bindings to missing containers instead of rebinding them by container name",
+		},
+		cli.Float64Flag{
+			Name:   "op-rate-limit",
+			EnvVar: "CONTIV_NETPLUGIN_OP_RATE_LIMIT",
+			Usage:  "set max network and endpoint creates and deletes per second (default: unlimited)",
+		},
+		cli.IntFlag{
+			Name:   "op-rate-burst",
+			EnvVar: "CONTIV_NETPLUGIN_OP_RATE_BURST",
+			Usage:  "set number of creates and deletes allowed at once over the rate limit (default: 1)",
+		},
+		cli.IntFlag{
+			Name:   "op-rate-wait",
+			EnvVar: "CONTIV_NETPLUGIN_OP_RATE_WAIT",
+			Usage:  "set ms a rate limited operation waits before failing (default: 0, fail at once)",
+		},
+		cli.StringFlag{
+			Name:   "audit-log",
+			EnvVar: "CONTIV_NETPLUGIN_AUDIT_LOG",
+			Usage:  "record dataplane operations to a file, or to the netplugin log with \"log\" (default: off)",
+		},
+		cli.StringFlag{
+			Name:   "ovsdb-endpoint",
+			EnvVar: "CONTIV_NETPLUGIN_OVSDB_ENDPOINT",
+			Usage:  "set ovsdb server of the switch, unix:<path>, tcp:<host>:<port> or ssl:<host>:<port> (default: local ovsdb socket)",
+		},
+		cli.StringFlag{
+			Name:   "ovsdb-key",
+			EnvVar: "CONTIV_NETPLUGIN_OVSDB_KEY",
+			Usage:  "set private key file of the ssl ovsdb endpoint",
+		},
+		cli.StringFlag{
+			Name:   "ovsdb-cert",
+			EnvVar: "CONTIV_NETPLUGIN_OVSDB_CERT",
+			Usage:  "set certificate file of the ssl ovsdb endpoint",
+		},
+		cli.StringFlag{
+			Name:   "ovsdb-ca-cert",
+			EnvVar: "CONTIV_NETPLUGIN_OVSDB_CA_CERT",
+			Usage:  "set CA certificate file checking the ssl ovsdb endpoint",
+		},
 	}
 	app.Flags = utils.FlattenFlags(netpluginFlags, utils.BuildDBFlags(binName), utils.BuildNetworkFlags(binName), utils.BuildLogFlags(binName))
 	sort.Sort(cli.FlagsByName(app.Flags))
@@ -0,0 +1,113 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"github.com/Sirupsen/logrus"
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+	"github.com/contiv/netplugin/utils"
+)
+
+// ReconcileContainerBindings checks that the containers the endpoints of
+// this host are bound to still exist in the container runtime. Containers
+// get new ids when the runtime restarts: a stale binding is moved to the
+// container of the same name, and cleared when there is none or when
+// ClearStaleBindings is set. Endpoints retained when their container died
+// keep their binding and are reported until their container is back. The
+// endpoints that could not be checked are left alone and the first error is
+// returned.
+func (p *NetPlugin) ReconcileContainerBindings() error {
+	p.Lock()
+	defer p.Unlock()
+	if p.StateDriver == nil {
+		return ErrNotInitialized
+	}
+	unlock, err := p.reconcileLock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	runtime, err := p.containerRuntime()
+	if err != nil {
+		return err
+	}
+	lookup, ok := runtime.(utils.ContainerLookup)
+	if !ok {
+		return core.Errorf("container runtime does not support container lookups")
+	}
+
+	epCfg := &mastercfg.CfgEndpointState{}
+	epCfg.StateDriver = p.StateDriver
+	epCfgs, err := epCfg.ReadAll()
+	if core.ErrIfKeyExists(err) != nil {
+		return err
+	}
+
+	var firstErr error
+	for _, state := range epCfgs {
+		ep := state.(*mastercfg.CfgEndpointState)
+		if ep.ContainerID == "" || ep.HomingHost != p.PluginConfig.Instance.HostLabel {
+			continue
+		}
+		if err := p.reconcileBinding(lookup, ep); err != nil {
+			logrus.Errorf("Error reconciling the container binding of endpoint %s. Err: %v", ep.ID, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// reconcileBinding rebinds or clears the container binding of endpoint ep
+// when its container is gone
+func (p *NetPlugin) reconcileBinding(lookup utils.ContainerLookup, ep *mastercfg.CfgEndpointState) error {
+	exists, err := lookup.ContainerExists(ep.ContainerID)
+	if err != nil {
+		return err
+	}
+	if exists {
+		if !ep.Retained {
+			return nil
+		}
+		logrus.Infof("Container %s of retained endpoint %s is back", ep.ContainerID, ep.ID)
+		ep.Retained = false
+		return ep.Write()
+	}
+
+	containerID := ""
+	if ep.EPCommonName != "" && !p.PluginConfig.ClearStaleBindings {
+		containerID, err = lookup.ContainerIDByName(ep.EPCommonName)
+		if err != nil {
+			return err
+		}
+	}
+
+	if containerID != "" {
+		logrus.Infof("Rebinding endpoint %s from container %s to %s (%s)",
+			ep.ID, ep.ContainerID, containerID, ep.EPCommonName)
+		ep.Retained = false
+	} else if ep.Retained {
+		logrus.Warnf("Endpoint %s of dead container %s is retained", ep.ID, ep.ContainerID)
+		return nil
+	} else {
+		logrus.Infof("Clearing the binding of endpoint %s to missing container %s", ep.ID, ep.ContainerID)
+	}
+	ep.ContainerID = containerID
+	return ep.Write()
+}
@@ -0,0 +1,232 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"net"
+	osexec "os/exec"
+	"regexp"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+	"github.com/vishvananda/netns"
+)
+
+// Connectivity probe settings
+const (
+	connProbeCount   = 3               // pings sent per test
+	connProbeTimeout = 2 * time.Second // wait for a ping reply or a tcp connect
+)
+
+// Connectivity probes
+const (
+	ConnProbeICMP = "icmp"
+	ConnProbeTCP  = "tcp"
+)
+
+// ConnResult is the outcome of a connectivity test from the netns of an
+// endpoint to the address of another. The probe goes through the datapath,
+// so policies in effect between the endpoints apply to it.
+type ConnResult struct {
+	SrcEndpoint string        `json:"srcEndpoint"`
+	DstEndpoint string        `json:"dstEndpoint"`
+	DstIP       string        `json:"dstIP"`
+	Probe       string        `json:"probe"`
+	Port        int           `json:"port,omitempty"` // tcp probes only
+	Reachable   bool          `json:"reachable"`
+	Sent        int           `json:"sent"`
+	Received    int           `json:"received"`
+	Latency     time.Duration `json:"latency"` // average round trip, 0 when unreachable
+	Reason      string        `json:"reason,omitempty"`
+}
+
+var (
+	pingCountRe = regexp.MustCompile(`(\d+) packets transmitted, (\d+) (?:packets )?received`)
+	pingRttRe   = regexp.MustCompile(`(?:rtt|round-trip) min/avg/max/\S+ = [0-9.]+/([0-9.]+)/`)
+)
+
+// parsePingOutput fills the counters and latency of a ping result from the
+// summary of ping
+func parsePingOutput(out string, res *ConnResult) bool {
+	counts := pingCountRe.FindStringSubmatch(out)
+	if counts == nil {
+		return false
+	}
+	res.Sent, _ = strconv.Atoi(counts[1])
+	res.Received, _ = strconv.Atoi(counts[2])
+	res.Reachable = res.Received > 0
+
+	if rtt := pingRttRe.FindStringSubmatch(out); rtt != nil && res.Reachable {
+		if avg, err := strconv.ParseFloat(rtt[1], 64); err == nil {
+			res.Latency = time.Duration(avg * float64(time.Millisecond))
+		}
+	}
+	return true
+}
+
+// TestConnectivity pings the address of endpoint dstEpID from the netns of
+// the local endpoint srcEpID. An unreachable destination is reported in the
+// result, errors are for tests that could not be run.
+func (p *NetPlugin) TestConnectivity(srcEpID, dstEpID string) (ConnResult, error) {
+	res := ConnResult{SrcEndpoint: srcEpID, DstEndpoint: dstEpID, Probe: ConnProbeICMP}
+	nsPath, err := p.connTarget(&res)
+	if err != nil {
+		return res, err
+	}
+
+	nsenterPath, err := osexec.LookPath("nsenter")
+	if err != nil {
+		return res, err
+	}
+	ping := "ping"
+	if net.ParseIP(res.DstIP).To4() == nil {
+		ping = "ping6"
+	}
+
+	// ping exits with an error when it gets no reply
+	out, err := osexec.Command(nsenterPath, "--net="+nsPath, "--", ping, "-n",
+		"-c", strconv.Itoa(connProbeCount), "-W", strconv.Itoa(int(connProbeTimeout/time.Second)),
+		res.DstIP).CombinedOutput()
+	if !parsePingOutput(string(out), &res) {
+		return res, core.Errorf("error pinging %s from endpoint %s: %v - %s", res.DstIP, srcEpID, err, out)
+	}
+	if !res.Reachable {
+		res.Reason = "no reply"
+	}
+	return res, nil
+}
+
+// TestTCPConnectivity connects to port of endpoint dstEpID from the netns of
+// the local endpoint srcEpID, as TestConnectivity
+func (p *NetPlugin) TestTCPConnectivity(srcEpID, dstEpID string, port int) (ConnResult, error) {
+	res := ConnResult{SrcEndpoint: srcEpID, DstEndpoint: dstEpID, Probe: ConnProbeTCP, Port: port}
+	if port <= 0 || port > 65535 {
+		return res, core.Errorf("invalid port %d", port)
+	}
+	nsPath, err := p.connTarget(&res)
+	if err != nil {
+		return res, err
+	}
+
+	res.Sent = 1
+	latency, err := dialInNetns(nsPath, net.JoinHostPort(res.DstIP, strconv.Itoa(port)))
+	if err != nil {
+		if _, ok := err.(net.Error); !ok {
+			return res, err
+		}
+		res.Reason = err.Error()
+		return res, nil
+	}
+	res.Received = 1
+	res.Reachable = true
+	res.Latency = latency
+	return res, nil
+}
+
+// connTarget sets the destination address of a connectivity test and
+// returns the netns of its source endpoint, which must be attached on this
+// host
+func (p *NetPlugin) connTarget(res *ConnResult) (string, error) {
+	p.Lock()
+	defer p.Unlock()
+	if p.StateDriver == nil {
+		return "", ErrNotInitialized
+	}
+
+	srcCfg := &mastercfg.CfgEndpointState{}
+	srcCfg.StateDriver = p.StateDriver
+	if err := srcCfg.Read(res.SrcEndpoint); err != nil {
+		return "", err
+	}
+	dstCfg := &mastercfg.CfgEndpointState{}
+	dstCfg.StateDriver = p.StateDriver
+	if err := dstCfg.Read(res.DstEndpoint); err != nil {
+		return "", err
+	}
+
+	if srcCfg.HomingHost != p.PluginConfig.Instance.HostLabel {
+		return "", core.Errorf("endpoint %s is not on this host", srcCfg.ID)
+	}
+	if srcCfg.Status == mastercfg.EndpointStatusDetached {
+		return "", core.Errorf("endpoint %s is detached", srcCfg.ID)
+	}
+
+	res.DstIP = dstCfg.IPAddress
+	if res.DstIP == "" {
+		res.DstIP = dstCfg.IPv6Address
+	}
+	if res.DstIP == "" {
+		return "", core.Errorf("endpoint %s has no address", dstCfg.ID)
+	}
+
+	return p.endpointNetns(srcCfg)
+}
+
+// endpointNetns returns the netns of an attached endpoint. It is called
+// with the plugin lock held.
+func (p *NetPlugin) endpointNetns(epCfg *mastercfg.CfgEndpointState) (string, error) {
+	if epCfg.NetnsPath != "" {
+		return epCfg.NetnsPath, nil
+	}
+	// endpoints created by the container runtime were not attached with a
+	// netns, ask the runtime for it
+	rt, err := p.containerRuntime()
+	if err != nil {
+		return "", err
+	}
+	containerID := epCfg.ContainerID
+	if containerID == "" {
+		containerID = epCfg.EndpointID
+	}
+	return rt.GetSandboxNetns(containerID)
+}
+
+// dialInNetns connects to addr from the netns at nsPath and returns the
+// time the connection took
+func dialInNetns(nsPath, addr string) (time.Duration, error) {
+	// the netns is per thread, the socket is created in the netns of the
+	// thread calling dial
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	origNs, err := netns.Get()
+	if err != nil {
+		return 0, core.Errorf("error getting the current netns: %v", err)
+	}
+	defer origNs.Close()
+	ns, err := netns.GetFromPath(nsPath)
+	if err != nil {
+		return 0, core.Errorf("error opening netns %s: %v", nsPath, err)
+	}
+	defer ns.Close()
+
+	if err := netns.Set(ns); err != nil {
+		return 0, core.Errorf("error entering netns %s: %v", nsPath, err)
+	}
+	defer netns.Set(origNs)
+
+	start := time.Now()
+	conn, err := net.DialTimeout("tcp", addr, connProbeTimeout)
+	if err != nil {
+		return 0, err
+	}
+	latency := time.Since(start)
+	conn.Close()
+	return latency, nil
+}
@@ -0,0 +1,67 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"github.com/Sirupsen/logrus"
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+)
+
+// conntrackExempter is implemented by endpoint drivers that can take flows
+// of an endpoint out of connection tracking in its netns
+type conntrackExempter interface {
+	AddConntrackExemptions(id, nsPath string) error
+	RemoveConntrackExemptions(id, nsPath string) error
+}
+
+// checkConntrackExemptions fails if an endpoint has conntrack exemptions
+// that can not be programmed on attach
+func checkConntrackExemptions(driver core.NetworkDriver, nsPath string, ep *mastercfg.CfgEndpointState) error {
+	if len(ep.ConntrackExemptions) == 0 {
+		return nil
+	}
+	if _, ok := driver.(conntrackExempter); !ok {
+		return core.Errorf("endpoint driver does not support conntrack exemptions")
+	}
+	if nsPath == "" {
+		return core.Errorf("endpoint %s has conntrack exemptions but no netns", ep.ID)
+	}
+	return nil
+}
+
+// applyConntrackExemptions programs the conntrack exemptions of an endpoint
+// in the container netns at nsPath
+func applyConntrackExemptions(driver core.NetworkDriver, nsPath string, ep *mastercfg.CfgEndpointState) error {
+	exempter, ok := driver.(conntrackExempter)
+	if !ok || len(ep.ConntrackExemptions) == 0 {
+		return nil
+	}
+	return exempter.AddConntrackExemptions(ep.ID, nsPath)
+}
+
+// removeConntrackExemptions removes the conntrack exemptions of an attached
+// endpoint from its netns. Failures are only logged, the rules go away with
+// the netns.
+func removeConntrackExemptions(driver core.NetworkDriver, ep *mastercfg.CfgEndpointState) {
+	exempter, ok := driver.(conntrackExempter)
+	if !ok || len(ep.ConntrackExemptions) == 0 || ep.NetnsPath == "" {
+		return
+	}
+	if err := exempter.RemoveConntrackExemptions(ep.ID, ep.NetnsPath); err != nil {
+		logrus.Warnf("Error removing conntrack exemptions of endpoint %s. Err: %v", ep.ID, err)
+	}
+}
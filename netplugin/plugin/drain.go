@@ -0,0 +1,168 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+)
+
+// DrainNetwork moves the endpoints this host has on network networkID to
+// network targetNetworkID with MoveEndpointToNetwork, one at a time. The
+// progress is kept in state after each endpoint, see DrainStatus, and a
+// drain that was interrupted is resumed by calling DrainNetwork again with
+// the same target. An endpoint that fails to move does not stop the drain,
+// the failures are returned once all the endpoints were tried and are kept
+// until the endpoint moves.
+func (p *NetPlugin) DrainNetwork(networkID, targetNetworkID string) error {
+	if p.StateDriver == nil {
+		return ErrNotInitialized
+	}
+	if networkID == targetNetworkID {
+		return core.Errorf("network %s can not be drained to itself", networkID)
+	}
+
+	nwCfg := &mastercfg.CfgNetworkState{}
+	nwCfg.StateDriver = p.StateDriver
+	if err := nwCfg.Read(networkID); err != nil {
+		return core.Errorf("network %s not found", networkID)
+	}
+	if err := nwCfg.Read(targetNetworkID); err != nil {
+		return core.Errorf("network %s not found", targetNetworkID)
+	}
+	if nwCfg.Deleting {
+		return core.Errorf("network %s is being deleted", targetNetworkID)
+	}
+
+	host := p.PluginConfig.Instance.HostLabel
+	drain := &mastercfg.NetworkDrainState{}
+	drain.StateDriver = p.StateDriver
+	if err := drain.Read(mastercfg.NetworkDrainID(networkID, host)); err == nil {
+		if drain.Target != targetNetworkID {
+			return core.Errorf("network %s is being drained to %s", networkID, drain.Target)
+		}
+		logrus.Infof("Resuming drain of network %s to %s, %d endpoints moved",
+			networkID, targetNetworkID, len(drain.Moved))
+	} else {
+		drain.ID = mastercfg.NetworkDrainID(networkID, host)
+		drain.Host = host
+		drain.Target = targetNetworkID
+		drain.Started = time.Now().Unix()
+		logrus.Infof("Draining network %s to %s", networkID, targetNetworkID)
+	}
+
+	epIDs, err := p.hostEndpointIDs(networkID, host)
+	if err != nil {
+		return err
+	}
+	drain.Total = len(drain.Moved) + len(epIDs)
+	if drain.Failed == nil {
+		drain.Failed = map[string]string{}
+	}
+	if err := drain.Write(); err != nil {
+		return err
+	}
+
+	epCfg := &mastercfg.CfgEndpointState{}
+	epCfg.StateDriver = p.StateDriver
+	for _, epID := range epIDs {
+		// skip the endpoints deleted or moved away since the drain started
+		if err := epCfg.Read(epID); err != nil || epCfg.NetID != networkID {
+			delete(drain.Failed, epID)
+			drain.Total--
+			continue
+		}
+
+		if err := p.MoveEndpointToNetwork(epID, targetNetworkID); err != nil {
+			logrus.Errorf("Error draining endpoint %s of network %s. Err: %v", epID, networkID, err)
+			drain.Failed[epID] = err.Error()
+		} else {
+			delete(drain.Failed, epID)
+			drain.Moved = append(drain.Moved, epID)
+		}
+		if err := drain.Write(); err != nil {
+			logrus.Errorf("Error recording drain of network %s. Err: %v", networkID, err)
+			return err
+		}
+		logrus.Infof("Drain of network %s: %d of %d endpoints moved, %d failed",
+			networkID, len(drain.Moved), drain.Total, len(drain.Failed))
+	}
+
+	if len(drain.Failed) > 0 {
+		return core.Errorf("%d of %d endpoints of network %s not drained: %s",
+			len(drain.Failed), drain.Total, networkID, drainFailures(drain.Failed))
+	}
+	if err := drain.Clear(); err != nil {
+		logrus.Warnf("Error clearing drain of network %s. Err: %v", networkID, err)
+	}
+	logrus.Infof("Drained network %s to %s, %d endpoints moved", networkID, targetNetworkID, len(drain.Moved))
+	return nil
+}
+
+// DrainStatus returns the progress of the drain of network networkID on
+// this host that is in progress or was interrupted
+func (p *NetPlugin) DrainStatus(networkID string) (*mastercfg.NetworkDrainState, error) {
+	if p.StateDriver == nil {
+		return nil, ErrNotInitialized
+	}
+
+	drain := &mastercfg.NetworkDrainState{}
+	drain.StateDriver = p.StateDriver
+	if err := drain.Read(mastercfg.NetworkDrainID(networkID, p.PluginConfig.Instance.HostLabel)); err != nil {
+		return nil, core.Errorf("network %s is not being drained", networkID)
+	}
+	return drain, nil
+}
+
+// hostEndpointIDs returns the ids of the endpoints host has on network
+// netID, in order
+func (p *NetPlugin) hostEndpointIDs(netID, host string) ([]string, error) {
+	epCfg := &mastercfg.CfgEndpointState{}
+	epCfg.StateDriver = p.StateDriver
+	epCfgs, err := epCfg.ReadAll()
+	if core.ErrIfKeyExists(err) != nil {
+		return nil, err
+	}
+	ids := []string{}
+	for _, state := range epCfgs {
+		ep := state.(*mastercfg.CfgEndpointState)
+		if ep.NetID == netID && ep.HomingHost == host {
+			ids = append(ids, ep.ID)
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// drainFailures formats the errors of the endpoints that failed to drain
+func drainFailures(failed map[string]string) string {
+	ids := []string{}
+	for id := range failed {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	msgs := []string{}
+	for _, id := range ids {
+		msgs = append(msgs, fmt.Sprintf("%s: %s", id, failed[id]))
+	}
+	return strings.Join(msgs, "; ")
+}
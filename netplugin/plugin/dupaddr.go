@@ -0,0 +1,100 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"fmt"
+	"net"
+	osexec "os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+)
+
+// defaultDupAddrTimeout is the seconds to wait for probe replies when the
+// network does not set it
+const defaultDupAddrTimeout = 1
+
+// DupAddrError is returned when the address of an endpoint is already used
+// on its segment
+type DupAddrError struct {
+	IP   string
+	MACs []string // macs that answered for the address
+}
+
+func (e *DupAddrError) Error() string {
+	return fmt.Sprintf("address %s is already used by %s", e.IP, strings.Join(e.MACs, ", "))
+}
+
+var arpingReplyRe = regexp.MustCompile(`reply from \S+ \[([0-9A-Fa-f:]+)\]`)
+
+// parseArpingReplies returns the macs that answered an arping probe, the
+// own mac of the endpoint excluded
+func parseArpingReplies(out, ownMac string) []string {
+	seen := map[string]bool{strings.ToLower(ownMac): true}
+	macs := []string{}
+	for _, match := range arpingReplyRe.FindAllStringSubmatch(out, -1) {
+		mac := strings.ToLower(match[1])
+		if !seen[mac] {
+			seen[mac] = true
+			macs = append(macs, mac)
+		}
+	}
+	return macs
+}
+
+// detectDupAddr probes the segment of an endpoint for its IPv4 address from
+// the container netns at nsPath. IPv6 addresses go through the kernel DAD.
+func detectDupAddr(nsPath string, nw *mastercfg.CfgNetworkState, ep *mastercfg.CfgEndpointState) error {
+	if nw == nil || nw.DupAddrDetect == "" || net.ParseIP(ep.IPAddress).To4() == nil {
+		return nil
+	}
+	if nsPath == "" || ep.IntfName == "" {
+		return core.Errorf("endpoint %s needs a netns and an interface name to probe its address", ep.ID)
+	}
+
+	timeout := nw.DupAddrTimeout
+	if timeout == 0 {
+		timeout = defaultDupAddrTimeout
+	}
+
+	nsenterPath, err := osexec.LookPath("nsenter")
+	if err != nil {
+		return err
+	}
+
+	// arping exits with an error when it gets replies in DAD mode
+	out, err := osexec.Command(nsenterPath, "--net="+nsPath, "--", "arping", "-D",
+		"-I", ep.IntfName, "-w", strconv.Itoa(timeout), ep.IPAddress).CombinedOutput()
+	macs := parseArpingReplies(string(out), ep.MacAddress)
+	if err != nil && !arpingReplyRe.MatchString(string(out)) {
+		return core.Errorf("error probing address %s of endpoint %s: %v - %s", ep.IPAddress, ep.ID, err, out)
+	}
+	if len(macs) == 0 {
+		return nil
+	}
+
+	dupErr := &DupAddrError{IP: ep.IPAddress, MACs: macs}
+	if nw.DupAddrDetect == mastercfg.DupAddrDetectWarn {
+		logrus.Warnf("Endpoint %s: %v", ep.ID, dupErr)
+		return nil
+	}
+	return dupErr
+}
@@ -0,0 +1,131 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+	"github.com/contiv/ofnet"
+)
+
+// directions of the traffic of an endpoint a policy rule matches
+const (
+	PolicyIngress = "ingress"
+	PolicyEgress  = "egress"
+)
+
+// PolicyRule is a policy rule that applies to the traffic of an endpoint
+type PolicyRule struct {
+	ofnet.OfnetPolicyRule
+	Log       bool   // the packets matching the rule are logged
+	Direction string // PolicyIngress or PolicyEgress
+}
+
+// ipInPrefix tells whether ip is in prefix, an address with or without a
+// mask
+func ipInPrefix(ip net.IP, prefix string) bool {
+	if !strings.Contains(prefix, "/") {
+		return ip.Equal(net.ParseIP(prefix))
+	}
+	_, ipNet, err := net.ParseCIDR(prefix)
+	return err == nil && ipNet.Contains(ip)
+}
+
+// ruleSideMatches tells whether the source or destination of a rule, its
+// endpoint group and address, matches an endpoint
+func ruleSideMatches(epg int, ip net.IP, ruleEpg int, ruleAddr string) bool {
+	if ruleEpg != 0 {
+		if ruleEpg != epg {
+			return false
+		}
+	} else if ruleAddr == "" {
+		// a rule of no group and no address only matches through its
+		// other side
+		return false
+	}
+	return ruleAddr == "" || (ip != nil && ipInPrefix(ip, ruleAddr))
+}
+
+// byEvaluationOrder sorts rules by decreasing priority, the order the
+// datapath evaluates them, and by id among the same priority
+type byEvaluationOrder []PolicyRule
+
+func (r byEvaluationOrder) Len() int      { return len(r) }
+func (r byEvaluationOrder) Swap(i, j int) { r[i], r[j] = r[j], r[i] }
+func (r byEvaluationOrder) Less(i, j int) bool {
+	if r[i].Priority != r[j].Priority {
+		return r[i].Priority > r[j].Priority
+	}
+	return r[i].RuleId < r[j].RuleId
+}
+
+// effectivePolicy returns the rules of rules applying to the traffic of
+// endpoint ep, in evaluation order
+func effectivePolicy(ep *mastercfg.CfgEndpointState, rules []*mastercfg.CfgPolicyRule) []PolicyRule {
+	ip := net.ParseIP(ep.IPAddress)
+	effective := []PolicyRule{}
+	for _, rule := range rules {
+		dir := ""
+		if ruleSideMatches(ep.EndpointGroupID, ip, rule.DstEndpointGroup, rule.DstIpAddr) {
+			dir = PolicyIngress
+		} else if ruleSideMatches(ep.EndpointGroupID, ip, rule.SrcEndpointGroup, rule.SrcIpAddr) {
+			dir = PolicyEgress
+		}
+		if dir == "" {
+			continue
+		}
+		effective = append(effective, PolicyRule{
+			OfnetPolicyRule: rule.OfnetPolicyRule,
+			Log:             rule.Log,
+			Direction:       dir,
+		})
+	}
+	sort.Sort(byEvaluationOrder(effective))
+	return effective
+}
+
+// GetEffectivePolicy returns the policy rules that apply to the traffic of
+// endpoint epID, in the order they are evaluated. The rules are those
+// netmaster published for the agents to program: the rules of the policies
+// of the endpoint group of the endpoint, the rules of the other groups
+// naming it, and the rules matching its address.
+func (p *NetPlugin) GetEffectivePolicy(epID string) ([]PolicyRule, error) {
+	if p.StateDriver == nil {
+		return nil, ErrNotInitialized
+	}
+
+	epCfg := &mastercfg.CfgEndpointState{}
+	epCfg.StateDriver = p.StateDriver
+	if err := epCfg.Read(epID); err != nil {
+		return nil, core.Errorf("endpoint %s not found", epID)
+	}
+
+	ruleCfg := &mastercfg.CfgPolicyRule{}
+	ruleCfg.StateDriver = p.StateDriver
+	ruleCfgs, err := ruleCfg.ReadAll()
+	if core.ErrIfKeyExists(err) != nil {
+		return nil, err
+	}
+	rules := []*mastercfg.CfgPolicyRule{}
+	for _, state := range ruleCfgs {
+		rules = append(rules, state.(*mastercfg.CfgPolicyRule))
+	}
+	return effectivePolicy(epCfg, rules), nil
+}
@@ -0,0 +1,138 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"sort"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+)
+
+// initEndpointDrivers initializes the drivers endpoints can select besides
+// the network driver
+func (p *NetPlugin) initEndpointDrivers(cfg *Config) error {
+	drivers := make(map[string]core.NetworkDriver)
+	for _, name := range cfg.Drivers.EndpointDrivers {
+		if name == cfg.Drivers.Network || drivers[name] != nil {
+			continue
+		}
+		driver, err := p.Registry.NewNetworkDriver(name, &cfg.Instance)
+		if err != nil {
+			for _, d := range drivers {
+				d.Deinit()
+			}
+			return core.Errorf("error initializing endpoint driver %s: %v", name, err)
+		}
+		drivers[name] = driver
+	}
+
+	p.endpointDrivers = drivers
+	return nil
+}
+
+// deinitEndpointDrivers deinitializes the drivers set up by
+// initEndpointDrivers
+func (p *NetPlugin) deinitEndpointDrivers() {
+	for _, driver := range p.endpointDrivers {
+		driver.Deinit()
+	}
+	p.endpointDrivers = nil
+}
+
+// networkDrivers returns all the drivers wiring endpoints on this host, the
+// network driver first. Each of them sets up its own side of the networks.
+func (p *NetPlugin) networkDrivers() []core.NetworkDriver {
+	names := make([]string, 0, len(p.endpointDrivers))
+	for name := range p.endpointDrivers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	drivers := []core.NetworkDriver{p.NetworkDriver}
+	for _, name := range names {
+		drivers = append(drivers, p.endpointDrivers[name])
+	}
+	return drivers
+}
+
+// driverByName returns the endpoint driver called name, the network driver
+// when name is empty
+func (p *NetPlugin) driverByName(name string) (core.NetworkDriver, error) {
+	if name == "" || name == p.PluginConfig.Drivers.Network {
+		return p.NetworkDriver, nil
+	}
+	driver, ok := p.endpointDrivers[name]
+	if !ok {
+		return nil, core.Errorf("endpoint driver %s is not initialized", name)
+	}
+	return driver, nil
+}
+
+// endpointDriver returns the driver an endpoint is wired with. The driver
+// named in the endpoint state is used, or the one that created the endpoint
+// once the state is gone.
+func (p *NetPlugin) endpointDriver(id string) (core.NetworkDriver, error) {
+	if p.StateDriver != nil {
+		epCfg := &mastercfg.CfgEndpointState{}
+		epCfg.StateDriver = p.StateDriver
+		if err := epCfg.Read(id); err == nil {
+			return p.driverByName(epCfg.Driver)
+		}
+	}
+	p.epMutex.Lock()
+	name := p.epDrivers[id]
+	p.epMutex.Unlock()
+	return p.driverByName(name)
+}
+
+// endpointCreated records the driver that wired an endpoint, for its delete
+func (p *NetPlugin) endpointCreated(id string, driver core.NetworkDriver) {
+	p.epMutex.Lock()
+	defer p.epMutex.Unlock()
+	for name, d := range p.endpointDrivers {
+		if d == driver {
+			if p.epDrivers == nil {
+				p.epDrivers = make(map[string]string)
+			}
+			p.epDrivers[id] = name
+			return
+		}
+	}
+}
+
+// endpointDeleted forgets the driver of a deleted endpoint
+func (p *NetPlugin) endpointDeleted(id string) {
+	p.epMutex.Lock()
+	defer p.epMutex.Unlock()
+	delete(p.epDrivers, id)
+}
+
+// createNetwork sets up a network on all the drivers
+func (p *NetPlugin) createNetwork(id string) error {
+	for _, driver := range p.networkDrivers() {
+		if err := p.checkBUMSupport(driver, id); err != nil {
+			logrus.Errorf("Error creating network %s. Err: %v", id, err)
+			return err
+		}
+		if err := driver.CreateNetwork(id); err != nil {
+			logrus.Errorf("Error creating network %s. Err: %v", id, err)
+			return err
+		}
+	}
+	return nil
+}
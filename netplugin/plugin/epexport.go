@@ -0,0 +1,221 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"encoding/json"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/drivers"
+	"github.com/contiv/netplugin/netmaster/intent"
+	"github.com/contiv/netplugin/netmaster/master"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+)
+
+// endpointExportVersion is the version of the EndpointExport format
+const endpointExportVersion = 1
+
+// EndpointExport is the portable form of an endpoint, as returned by
+// ExportEndpoint and read by ImportEndpoint. The policies of the endpoint
+// follow from its endpoint group. Stats are informational, counters restart
+// from zero on the destination.
+type EndpointExport struct {
+	Version  int                        `json:"version"`
+	Endpoint mastercfg.CfgEndpointState `json:"endpoint"`
+	Tenant   string                     `json:"tenant"`
+	Network  string                     `json:"network"`
+	Stats    *drivers.EndpointStats     `json:"stats,omitempty"`
+}
+
+// ExportEndpoint quiesces an endpoint for migration and returns its full
+// spec. The datapath wiring of the endpoint is removed as by
+// DetachEndpoint, its config state and address allocation are kept.
+func (p *NetPlugin) ExportEndpoint(epID string) ([]byte, error) {
+	if err := p.rateLimit(); err != nil {
+		return nil, err
+	}
+	p.Lock()
+	defer p.Unlock()
+	if p.NetworkDriver == nil {
+		return nil, p.driverErr()
+	}
+	if p.StateDriver == nil {
+		return nil, ErrNotInitialized
+	}
+
+	epCfg := &mastercfg.CfgEndpointState{}
+	epCfg.StateDriver = p.StateDriver
+	if err := epCfg.Read(epID); err != nil {
+		return nil, err
+	}
+	nwCfg := &mastercfg.CfgNetworkState{}
+	nwCfg.StateDriver = p.StateDriver
+	if err := nwCfg.Read(epCfg.NetID); err != nil {
+		return nil, err
+	}
+
+	export := EndpointExport{
+		Version: endpointExportVersion,
+		Tenant:  nwCfg.Tenant,
+		Network: nwCfg.NetworkName,
+	}
+
+	if epCfg.Status != mastercfg.EndpointStatusDetached {
+		driver, err := p.driverByName(epCfg.Driver)
+		if err != nil {
+			return nil, err
+		}
+
+		// stats are best effort, not all drivers can read them
+		if reader, ok := driver.(endpointStatsReader); ok {
+			stats, err := reader.GetEndpointIntfStats(epID)
+			if err != nil {
+				logrus.Warnf("Error reading stats of endpoint %s. Err: %v", epID, err)
+			} else {
+				export.Stats = stats
+			}
+		}
+
+		if err := driver.DeleteEndpoint(epID); err != nil {
+			logrus.Errorf("Error quiescing endpoint %s. Err: %v", epID, err)
+			return nil, err
+		}
+		epCfg.Status = mastercfg.EndpointStatusDetached
+		if err := epCfg.Write(); err != nil {
+			return nil, err
+		}
+	}
+
+	// the importing host takes over the lease, the endpoint expires if it
+	// is not imported within its ttl
+	p.stopLease(epID)
+
+	export.Endpoint = *epCfg
+	return json.Marshal(&export)
+}
+
+// ImportEndpoint recreates an endpoint exported by ExportEndpoint on this
+// host and returns its id. The endpoint keeps its addresses; its state is
+// reused when the source host shares the state store. The endpoint is left
+// detached, AttachEndpoint wires it up in the container netns.
+func (p *NetPlugin) ImportEndpoint(data []byte) (string, error) {
+	export := EndpointExport{}
+	if err := json.Unmarshal(data, &export); err != nil {
+		return "", core.Errorf("invalid endpoint export: %v", err)
+	}
+	if export.Version != endpointExportVersion {
+		return "", core.Errorf("unsupported endpoint export version %d", export.Version)
+	}
+	ep := &export.Endpoint
+	if ep.ID == "" || ep.EndpointID == "" || ep.NetID != export.Network+"."+export.Tenant {
+		return "", core.Errorf("invalid endpoint export: endpoint %q of network %q", ep.ID, ep.NetID)
+	}
+	if err := p.rateLimit(); err != nil {
+		return "", err
+	}
+
+	p.Lock()
+	defer p.Unlock()
+	if p.StateDriver == nil {
+		return "", ErrNotInitialized
+	}
+
+	nwCfg := &mastercfg.CfgNetworkState{}
+	nwCfg.StateDriver = p.StateDriver
+	if err := nwCfg.Read(ep.NetID); err != nil {
+		return "", core.Errorf("network %s of endpoint %s not found", ep.NetID, ep.ID)
+	}
+
+	hostLabel := p.PluginConfig.Instance.HostLabel
+	epReq := master.CreateEndpointRequest{
+		TenantName:   export.Tenant,
+		NetworkName:  export.Network,
+		ServiceName:  ep.ServiceName,
+		EndpointID:   ep.EndpointID,
+		EPCommonName: ep.EPCommonName,
+		ConfigEP: intent.ConfigEP{
+			Container:    ep.EndpointID,
+			Host:         hostLabel,
+			IPAddress:    ep.IPAddress,
+			IPv6Address:  ep.IPv6Address,
+			ServiceName:  ep.ServiceName,
+			HostIntfName: ep.HostIntfName,
+			SecondaryIPs: ep.SecondaryIPs,
+			DSCP:         ep.DSCP,
+			CoS:          ep.CoS,
+			MacAddress:   ep.MacAddress,
+			MTU:          ep.MTU,
+			Labels:       ep.Labels,
+			Driver:       ep.Driver,
+			LeaseTTL:     ep.LeaseTTL,
+
+			Sysctls:         ep.Sysctls,
+			EthtoolFeatures: ep.EthtoolFeatures,
+			Promisc:         ep.Promisc,
+			AllMulti:        ep.AllMulti,
+		},
+	}
+	for _, route := range ep.Routes {
+		cfgRoute := intent.ConfigRoute{Dest: route.Dest, Gateway: route.Gateway}
+		for _, hop := range route.NextHops {
+			cfgRoute.NextHops = append(cfgRoute.NextHops,
+				intent.ConfigNextHop{Gateway: hop.Gateway, Weight: hop.Weight})
+		}
+		epReq.ConfigEP.Routes = append(epReq.ConfigEP.Routes, cfgRoute)
+	}
+	for _, exemption := range ep.ConntrackExemptions {
+		epReq.ConfigEP.ConntrackExemptions = append(epReq.ConfigEP.ConntrackExemptions,
+			intent.ConfigConntrackExemption{
+				Protocol: exemption.Protocol,
+				SrcIP:    exemption.SrcIP,
+				SrcPort:  exemption.SrcPort,
+				DstIP:    exemption.DstIP,
+				DstPort:  exemption.DstPort,
+			})
+	}
+
+	if ep.Bond != nil {
+		epReq.ConfigEP.Bond = &intent.ConfigBond{Mode: ep.Bond.Mode, Members: ep.Bond.Members}
+	}
+	epReq.ConfigEP.Tap = ep.Tap
+	if ep.RateLimit != nil {
+		epReq.ConfigEP.RateLimit = &intent.ConfigRateLimit{Rate: ep.RateLimit.Rate, Burst: ep.RateLimit.Burst}
+	}
+
+	// returns the existing state when the store is shared with the source
+	epCfg, err := master.CreateEndpoint(p.StateDriver, nwCfg, &epReq)
+	if err != nil {
+		logrus.Errorf("Error importing endpoint %s. Err: %v", ep.ID, err)
+		return "", err
+	}
+	if epCfg.ID != ep.ID {
+		return "", core.Errorf("endpoint %s was imported as %s", ep.ID, epCfg.ID)
+	}
+
+	epCfg.HomingHost = hostLabel
+	epCfg.IntfName = ep.IntfName
+	epCfg.ContainerID = ep.ContainerID
+	epCfg.Status = mastercfg.EndpointStatusDetached
+	if err := epCfg.Write(); err != nil {
+		return "", err
+	}
+
+	p.startLease(epCfg)
+
+	logrus.Infof("Imported endpoint %s", epCfg.ID)
+	return epCfg.ID, nil
+}
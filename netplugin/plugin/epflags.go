@@ -0,0 +1,142 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	osexec "os/exec"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/drivers"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+)
+
+// interface flags of an endpoint, as reported in its oper state
+const (
+	IntfFlagPromisc  = "promisc"
+	IntfFlagAllMulti = "allmulti"
+)
+
+// parseLinkFlags returns the promisc and allmulti flags set in the output
+// of ip -o link show of an interface, in that order
+func parseLinkFlags(out string) []string {
+	flags := []string{}
+	start := strings.Index(out, "<")
+	end := strings.Index(out, ">")
+	if start < 0 || end < start {
+		return flags
+	}
+	set := make(map[string]bool)
+	for _, flag := range strings.Split(out[start+1:end], ",") {
+		set[flag] = true
+	}
+	if set["PROMISC"] {
+		flags = append(flags, IntfFlagPromisc)
+	}
+	if set["ALLMULTI"] {
+		flags = append(flags, IntfFlagAllMulti)
+	}
+	return flags
+}
+
+// intfFlagArgs returns the ip arguments setting the flags of an endpoint
+// interface on or off
+func intfFlagArgs(ep *mastercfg.CfgEndpointState, on bool) []string {
+	state := "off"
+	if on {
+		state = "on"
+	}
+	args := []string{"link", "set", "dev", ep.IntfName}
+	if ep.Promisc {
+		args = append(args, "promisc", state)
+	}
+	if ep.AllMulti {
+		args = append(args, "allmulticast", state)
+	}
+	return args
+}
+
+// applyIntfFlags sets the promisc and allmulti flags of an endpoint on its
+// interface in the container netns at nsPath and returns the flags in
+// effect there
+func applyIntfFlags(nsPath string, ep *mastercfg.CfgEndpointState) ([]string, error) {
+	if !ep.Promisc && !ep.AllMulti {
+		return nil, nil
+	}
+	if nsPath == "" {
+		return nil, core.Errorf("endpoint %s has interface flags but no netns", ep.ID)
+	}
+	if ep.IntfName == "" {
+		return nil, core.Errorf("endpoint %s has interface flags but no interface name", ep.ID)
+	}
+
+	nsenterPath, err := osexec.LookPath("nsenter")
+	if err != nil {
+		return nil, err
+	}
+	args := append([]string{"--net=" + nsPath, "--", "ip"}, intfFlagArgs(ep, true)...)
+	out, err := osexec.Command(nsenterPath, args...).CombinedOutput()
+	core.Audit(nsenterPath, args, err)
+	if err != nil {
+		return nil, core.Errorf("error setting the flags of %s: %v - %s", ep.IntfName, err, out)
+	}
+
+	out, err = osexec.Command(nsenterPath, "--net="+nsPath, "--", "ip", "-o", "link", "show", "dev", ep.IntfName).CombinedOutput()
+	if err != nil {
+		return nil, core.Errorf("error reading the flags of %s: %v - %s", ep.IntfName, err, out)
+	}
+	flags := parseLinkFlags(string(out))
+	set := make(map[string]bool)
+	for _, flag := range flags {
+		set[flag] = true
+	}
+	if (ep.Promisc && !set[IntfFlagPromisc]) || (ep.AllMulti && !set[IntfFlagAllMulti]) {
+		return nil, core.Errorf("flags of %s did not take effect, in effect: %v", ep.IntfName, flags)
+	}
+	return flags, nil
+}
+
+// clearIntfFlags clears the flags of an endpoint on its interface in the
+// netns it was attached to, the interface may be gone already
+func clearIntfFlags(ep *mastercfg.CfgEndpointState) {
+	if (!ep.Promisc && !ep.AllMulti) || ep.NetnsPath == "" || ep.IntfName == "" {
+		return
+	}
+	nsenterPath, err := osexec.LookPath("nsenter")
+	if err != nil {
+		return
+	}
+	args := append([]string{"--net=" + ep.NetnsPath, "--", "ip"}, intfFlagArgs(ep, false)...)
+	out, err := osexec.Command(nsenterPath, args...).CombinedOutput()
+	core.Audit(nsenterPath, args, err)
+	if err != nil {
+		logrus.Warnf("Error clearing the flags of endpoint %s. Err: %v - %s", ep.ID, err, out)
+	}
+}
+
+// recordIntfFlags records the flags in effect on the interface of endpoint
+// id in its oper state, for FetchEndpoint. Drivers keeping no oper state
+// are skipped.
+func recordIntfFlags(stateDriver core.StateDriver, id string, flags []string) error {
+	epOper := &drivers.OperEndpointState{}
+	epOper.StateDriver = stateDriver
+	if err := epOper.Read(id); err != nil {
+		return nil
+	}
+	epOper.IntfFlags = flags
+	return epOper.Write()
+}
@@ -0,0 +1,40 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/netmaster/master"
+)
+
+// GenerateEndpointID returns the id of the endpoint of container containerID
+// on network networkID: <networkID>-<containerID>, e.g. orange.default-c1.
+// It is the id netmaster gives the endpoint, so attaching the same container
+// to the same network again finds the endpoint it created. The format is that
+// of the endpoint state keys and stays stable across versions.
+// Unlike the GenerateEndpointId(networkId, containerId string) string first
+// asked for, it is named after the ID initialism used across the tree and
+// returns an error for the container ids netmaster refuses, see
+// master.ValidateContainerID, instead of an id no endpoint can have.
+func (p *NetPlugin) GenerateEndpointID(networkID, containerID string) (string, error) {
+	if networkID == "" {
+		return "", core.Errorf("network id is required")
+	}
+	if err := master.ValidateContainerID(containerID); err != nil {
+		return "", err
+	}
+	return master.ContainerEpID(networkID, containerID), nil
+}
@@ -0,0 +1,160 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"fmt"
+	osexec "os/exec"
+	"sort"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+)
+
+// SysctlError is returned when a sysctl of an endpoint can not be set
+type SysctlError struct {
+	Key    string
+	Reason string
+}
+
+func (e *SysctlError) Error() string {
+	return fmt.Sprintf("sysctl %s: %s", e.Key, e.Reason)
+}
+
+// EthtoolFeatureError is returned when an ethtool feature of an endpoint
+// is not supported by its interface
+type EthtoolFeatureError struct {
+	Intf    string
+	Feature string
+	Reason  string
+}
+
+func (e *EthtoolFeatureError) Error() string {
+	return fmt.Sprintf("ethtool feature %s of %s: %s", e.Feature, e.Intf, e.Reason)
+}
+
+// ethtoolAliases maps the short feature names of ethtool -K to the names
+// listed by ethtool -k
+var ethtoolAliases = map[string]string{
+	"rx":     "rx-checksumming",
+	"tx":     "tx-checksumming",
+	"sg":     "scatter-gather",
+	"tso":    "tcp-segmentation-offload",
+	"ufo":    "udp-fragmentation-offload",
+	"gso":    "generic-segmentation-offload",
+	"gro":    "generic-receive-offload",
+	"lro":    "large-receive-offload",
+	"rxvlan": "rx-vlan-offload",
+	"txvlan": "tx-vlan-offload",
+	"rxhash": "receive-hashing",
+}
+
+// parseEthtoolFeatures parses the output of ethtool -k into a map of the
+// features of an interface to whether they are fixed
+func parseEthtoolFeatures(out string) map[string]bool {
+	features := make(map[string]bool)
+	for _, line := range strings.Split(out, "\n") {
+		parts := strings.SplitN(strings.TrimSpace(line), ":", 2)
+		if len(parts) != 2 || strings.HasPrefix(parts[0], "Features for") {
+			continue
+		}
+		features[parts[0]] = strings.Contains(parts[1], "[fixed]")
+	}
+	return features
+}
+
+// applyIntfSettings sets the sysctls and ethtool features of an endpoint
+// in the container netns at nsPath
+func applyIntfSettings(nsPath string, ep *mastercfg.CfgEndpointState) error {
+	if len(ep.Sysctls) == 0 && len(ep.EthtoolFeatures) == 0 {
+		return nil
+	}
+	if nsPath == "" {
+		return core.Errorf("endpoint %s has interface settings but no netns", ep.ID)
+	}
+
+	nsenterPath, err := osexec.LookPath("nsenter")
+	if err != nil {
+		return err
+	}
+	nsArg := "--net=" + nsPath
+
+	keys := make([]string, 0, len(ep.Sysctls))
+	for key := range ep.Sysctls {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		args := []string{nsArg, "--", "sysctl", "-w", key + "=" + ep.Sysctls[key]}
+		out, err := osexec.Command(nsenterPath, args...).CombinedOutput()
+		core.Audit(nsenterPath, args, err)
+		if err != nil {
+			logrus.Errorf("Error setting sysctl %s of endpoint %s. Err: %v - %s", key, ep.ID, err, out)
+			if strings.Contains(string(out), "cannot stat") || strings.Contains(string(out), "No such file") {
+				return &SysctlError{Key: key, Reason: "unknown key"}
+			}
+			return &SysctlError{Key: key, Reason: strings.TrimSpace(string(out))}
+		}
+	}
+
+	if len(ep.EthtoolFeatures) == 0 {
+		return nil
+	}
+	if ep.IntfName == "" {
+		return core.Errorf("endpoint %s has ethtool features but no interface name", ep.ID)
+	}
+
+	out, err := osexec.Command(nsenterPath, nsArg, "--", "ethtool", "-k", ep.IntfName).CombinedOutput()
+	if err != nil {
+		return core.Errorf("error listing ethtool features of %s: %v - %s", ep.IntfName, err, out)
+	}
+	supported := parseEthtoolFeatures(string(out))
+
+	features := make([]string, 0, len(ep.EthtoolFeatures))
+	for feature := range ep.EthtoolFeatures {
+		features = append(features, feature)
+	}
+	sort.Strings(features)
+	for _, feature := range features {
+		name := feature
+		if long, ok := ethtoolAliases[feature]; ok {
+			name = long
+		}
+		fixed, ok := supported[name]
+		if !ok {
+			return &EthtoolFeatureError{Intf: ep.IntfName, Feature: feature, Reason: "unknown feature"}
+		}
+		if fixed {
+			return &EthtoolFeatureError{Intf: ep.IntfName, Feature: feature, Reason: "fixed by the driver"}
+		}
+
+		state := "off"
+		if ep.EthtoolFeatures[feature] {
+			state = "on"
+		}
+		args := []string{nsArg, "--", "ethtool", "-K", ep.IntfName, feature, state}
+		out, err := osexec.Command(nsenterPath, args...).CombinedOutput()
+		core.Audit(nsenterPath, args, err)
+		if err != nil {
+			logrus.Errorf("Error setting ethtool feature %s of endpoint %s. Err: %v - %s", feature, ep.ID, err, out)
+			return &EthtoolFeatureError{Intf: ep.IntfName, Feature: feature, Reason: strings.TrimSpace(string(out))}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,75 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import "sync"
+
+// endpointLocks is a mutex keyed by endpoint id. The create, delete, attach
+// and detach of an endpoint take the lock of its id and the read lock of the
+// plugin: operations on the same endpoint run one at a time, operations on
+// different endpoints run in parallel. Everything else takes the write lock
+// of the plugin and so excludes all endpoint operations.
+type endpointLocks struct {
+	sync.Mutex
+	locks map[string]*endpointLock
+}
+
+// endpointLock is the lock of one endpoint id, it is dropped once no
+// operation holds or waits for it
+type endpointLock struct {
+	sync.Mutex
+	refs int
+}
+
+// lock takes the lock of endpoint id
+func (l *endpointLocks) lock(id string) {
+	l.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]*endpointLock)
+	}
+	epLock, ok := l.locks[id]
+	if !ok {
+		epLock = &endpointLock{}
+		l.locks[id] = epLock
+	}
+	epLock.refs++
+	l.Unlock()
+
+	epLock.Lock()
+}
+
+// unlock releases the lock of endpoint id
+func (l *endpointLocks) unlock(id string) {
+	l.Lock()
+	defer l.Unlock()
+	epLock := l.locks[id]
+	epLock.refs--
+	if epLock.refs == 0 {
+		delete(l.locks, id)
+	}
+	epLock.Unlock()
+}
+
+// lockEndpoint takes the lock of endpoint id and the read lock of the
+// plugin, and returns the function releasing both
+func (p *NetPlugin) lockEndpoint(id string) func() {
+	p.epLocks.lock(id)
+	p.RLock()
+	return func() {
+		p.RUnlock()
+		p.epLocks.unlock(id)
+	}
+}
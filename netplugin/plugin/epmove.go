@@ -0,0 +1,183 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	osexec "os/exec"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/netmaster/master"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+)
+
+// endpointMover is implemented by endpoint drivers that can rewire an
+// attached endpoint for the network in its config, keeping its interface
+type endpointMover interface {
+	MoveEndpoint(id string) error
+}
+
+// MoveEndpointToNetwork moves endpoint epID to network newNetworkID. The
+// endpoint keeps its id, container, interface and mac, and gets an address
+// in the new network. An attached endpoint has its port rewired and, when
+// its netns is known, its addresses, default route and routes replaced. A
+// failed move is rolled back.
+func (p *NetPlugin) MoveEndpointToNetwork(epID, newNetworkID string) error {
+	if err := p.rateLimit(); err != nil {
+		return err
+	}
+	defer p.lockEndpoint(epID)()
+	if p.NetworkDriver == nil {
+		return p.driverErr()
+	}
+	if p.StateDriver == nil {
+		return ErrNotInitialized
+	}
+
+	oldCfg := &mastercfg.CfgEndpointState{}
+	oldCfg.StateDriver = p.StateDriver
+	if err := oldCfg.Read(epID); err != nil {
+		return err
+	}
+	if oldCfg.NetID == newNetworkID {
+		return nil
+	}
+	oldNw := &mastercfg.CfgNetworkState{}
+	oldNw.StateDriver = p.StateDriver
+	if err := oldNw.Read(oldCfg.NetID); err != nil {
+		return err
+	}
+	newNw := &mastercfg.CfgNetworkState{}
+	newNw.StateDriver = p.StateDriver
+	if err := newNw.Read(newNetworkID); err != nil {
+		return err
+	}
+	if err := checkRouteNextHops(newNw, oldCfg); err != nil {
+		return err
+	}
+
+	var mover endpointMover
+	attached := oldCfg.Status != mastercfg.EndpointStatusDetached
+	if attached {
+		driver, err := p.driverByName(oldCfg.Driver)
+		if err != nil {
+			return err
+		}
+		var ok bool
+		if mover, ok = driver.(endpointMover); !ok {
+			return core.Errorf("endpoint driver can not move attached endpoints")
+		}
+		if oldCfg.NetnsPath != "" && oldCfg.IntfName == "" {
+			return core.Errorf("endpoint %s has a netns but no interface name", epID)
+		}
+	}
+
+	newCfg, err := master.MoveEndpoint(p.StateDriver, oldCfg, newNw)
+	if err != nil {
+		logrus.Errorf("Error moving endpoint %s to %s. Err: %v", epID, newNetworkID, err)
+		return err
+	}
+
+	if attached {
+		err = rewireEndpoint(mover, oldNw, newNw, oldCfg, newCfg)
+	}
+	if err != nil {
+		logrus.Errorf("Error rewiring endpoint %s on %s, rolling back. Err: %v", epID, newNetworkID, err)
+		p.rollbackMove(mover, oldNw, newNw, oldCfg, newCfg)
+		return err
+	}
+
+	if err := master.ReleaseEndpointNetwork(p.StateDriver, oldCfg); err != nil {
+		logrus.Warnf("Error releasing endpoint %s from %s. Err: %v", epID, oldCfg.NetID, err)
+	}
+	logrus.Infof("Moved endpoint %s from %s to %s", epID, oldCfg.NetID, newNetworkID)
+	return nil
+}
+
+// rollbackMove puts back the config of a moved endpoint and its wiring when
+// it was attached. Failures are only logged.
+func (p *NetPlugin) rollbackMove(mover endpointMover, oldNw, newNw *mastercfg.CfgNetworkState,
+	oldCfg, newCfg *mastercfg.CfgEndpointState) {
+	if err := oldCfg.Write(); err != nil {
+		logrus.Errorf("Error restoring endpoint %s. Err: %v", oldCfg.ID, err)
+		return
+	}
+	if err := master.ReleaseEndpointNetwork(p.StateDriver, newCfg); err != nil {
+		logrus.Errorf("Error releasing endpoint %s from %s. Err: %v", newCfg.ID, newCfg.NetID, err)
+	}
+	if mover != nil {
+		if err := rewireEndpoint(mover, newNw, oldNw, newCfg, oldCfg); err != nil {
+			logrus.Errorf("Error rewiring endpoint %s back on %s. Err: %v", oldCfg.ID, oldCfg.NetID, err)
+		}
+	}
+}
+
+// rewireEndpoint rewires an attached endpoint from network fromNw to toNw
+// and, when its netns is known, sets it up for its new addresses
+func rewireEndpoint(mover endpointMover, fromNw, toNw *mastercfg.CfgNetworkState,
+	fromCfg, toCfg *mastercfg.CfgEndpointState) error {
+	if err := mover.MoveEndpoint(toCfg.ID); err != nil {
+		return err
+	}
+	if toCfg.NetnsPath == "" {
+		return nil
+	}
+	args := readdressArgs(toCfg.IntfName, fromCfg.AddrCIDRs(fromNw), toCfg.AddrCIDRs(toNw), toNw.Gateway)
+	if err := runNetnsIP(toCfg.NetnsPath, args); err != nil {
+		return err
+	}
+	return applyRoutes(toCfg.NetnsPath, toCfg)
+}
+
+// readdressArgs returns the ip commands that replace the addresses from of
+// interface dev by to, and point the default route at gateway when set.
+// The new addresses are added first so that the interface always has one.
+func readdressArgs(dev string, from, to []string, gateway string) [][]string {
+	args := [][]string{}
+	for _, cidr := range to {
+		args = append(args, []string{"addr", "replace", cidr, "dev", dev})
+	}
+	for _, cidr := range from {
+		args = append(args, []string{"addr", "del", cidr, "dev", dev})
+	}
+	if gateway != "" {
+		args = append(args, []string{"-4", "route", "replace", "default", "via", gateway, "dev", dev})
+	}
+	return args
+}
+
+// runNetnsIP runs ip commands in the netns at nsPath. Deleting an address
+// that is gone already is not an error, the commands are rerun on rollback.
+func runNetnsIP(nsPath string, cmds [][]string) error {
+	nsenterPath, err := osexec.LookPath("nsenter")
+	if err != nil {
+		return err
+	}
+	for _, cmd := range cmds {
+		args := append([]string{"--net=" + nsPath, "--", "ip"}, cmd...)
+		out, err := osexec.Command(nsenterPath, args...).CombinedOutput()
+		core.Audit(nsenterPath, args, err)
+		if err == nil {
+			continue
+		}
+		if cmd[1] == "del" && strings.Contains(string(out), "Cannot assign requested address") {
+			continue
+		}
+		return core.Errorf("ip %s failed. Err: %v - %s", strings.Join(cmd, " "), err, out)
+	}
+	return nil
+}
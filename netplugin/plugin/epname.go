@@ -0,0 +1,180 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"fmt"
+	"net"
+	osexec "os/exec"
+	"strings"
+
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+)
+
+// autoIntfNameFmt names the interfaces of the endpoints attached without a
+// requested name, net0, net1, ...
+const autoIntfNameFmt = "net%d"
+
+// netnsIntfMover is implemented by endpoint drivers that move the interface
+// of an endpoint to its netns on attach, under the name picked by the plugin
+type netnsIntfMover interface {
+	MoveEndpointIntf(id, nsPath, name string) error
+}
+
+// IntfNameCollisionError is returned when the interface name requested for
+// an endpoint is taken in its netns
+type IntfNameCollisionError struct {
+	Name   string
+	NsPath string
+}
+
+func (e *IntfNameCollisionError) Error() string {
+	return fmt.Sprintf("interface %s already exists in netns %s", e.Name, e.NsPath)
+}
+
+// parseLinkNames returns the interface names in the output of ip -o link
+func parseLinkNames(out string) []string {
+	names := []string{}
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		// veth peers are listed as name@peer
+		name := strings.TrimSuffix(fields[1], ":")
+		if i := strings.Index(name, "@"); i >= 0 {
+			name = name[:i]
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// netnsIntfNames returns the names of the interfaces in the netns at nsPath
+func netnsIntfNames(nsPath string) ([]string, error) {
+	nsenterPath, err := osexec.LookPath("nsenter")
+	if err != nil {
+		return nil, err
+	}
+	out, err := osexec.Command(nsenterPath, "--net="+nsPath, "--", "ip", "-o", "link", "show").CombinedOutput()
+	if err != nil {
+		return nil, core.Errorf("error listing the interfaces of netns %s: %v - %s", nsPath, err, out)
+	}
+	return parseLinkNames(string(out)), nil
+}
+
+// pickIntfName returns requested when it is not one of the existing
+// interface names, the first free netN name when nothing is requested
+func pickIntfName(requested, nsPath string, existing []string) (string, error) {
+	taken := make(map[string]bool)
+	for _, name := range existing {
+		taken[name] = true
+	}
+	if requested != "" {
+		if taken[requested] {
+			return "", &IntfNameCollisionError{Name: requested, NsPath: nsPath}
+		}
+		return requested, nil
+	}
+	for i := 0; ; i++ {
+		name := fmt.Sprintf(autoIntfNameFmt, i)
+		if !taken[name] {
+			return name, nil
+		}
+	}
+}
+
+// nameEndpointIntf sets the name of the interface of an endpoint in the
+// netns at nsPath, when its driver moves the interface there. The name
+// requested in the endpoint config is kept when free, a free one is picked
+// when none was requested; a name picked on an earlier attach is picked
+// again. The config is written when the name changes, so that the driver
+// and the attach hooks see it.
+func nameEndpointIntf(driver core.NetworkDriver, nsPath string, ep *mastercfg.CfgEndpointState) error {
+	if _, ok := driver.(netnsIntfMover); !ok || nsPath == "" {
+		return nil
+	}
+	existing, err := netnsIntfNames(nsPath)
+	if err != nil {
+		return err
+	}
+	requested := ep.IntfName
+	if ep.IntfNameAuto {
+		requested = ""
+	}
+	name, err := pickIntfName(requested, nsPath, existing)
+	if err != nil {
+		return err
+	}
+	auto := requested == ""
+	if name == ep.IntfName && auto == ep.IntfNameAuto {
+		return nil
+	}
+	ep.IntfName = name
+	ep.IntfNameAuto = auto
+	return ep.Write()
+}
+
+// moveEndpointIntf has the driver move the interface of an endpoint to the
+// netns at nsPath, under the name set by nameEndpointIntf
+func moveEndpointIntf(driver core.NetworkDriver, nsPath string, ep *mastercfg.CfgEndpointState) error {
+	mover, ok := driver.(netnsIntfMover)
+	if !ok || nsPath == "" {
+		return nil
+	}
+	return mover.MoveEndpointIntf(ep.ID, nsPath, ep.IntfName)
+}
+
+// intfSysctl returns true when sysctl key is a setting of a single interface,
+// e.g. net.ipv4.conf.eth0.rp_filter
+func intfSysctl(key string) bool {
+	parts := strings.Split(key, ".")
+	return len(parts) > 4 && parts[0] == "net" && (parts[2] == "conf" || parts[2] == "neigh") &&
+		parts[3] != "all" && parts[3] != "default"
+}
+
+// checkIntfMoved checks that the settings of endpoint ep applied to its
+// interface in the netns at nsPath find the interface there. They run after
+// the interface is moved, drivers that do not move it leave it in the host
+// netns where the settings would miss it.
+func checkIntfMoved(driver core.NetworkDriver, nsPath string, nw *mastercfg.CfgNetworkState,
+	ep *mastercfg.CfgEndpointState) error {
+	if _, ok := driver.(netnsIntfMover); ok || nsPath == "" {
+		return nil
+	}
+
+	setting := ""
+	if nw != nil && nw.DupAddrDetect != "" && net.ParseIP(ep.IPAddress).To4() != nil {
+		setting = "duplicate address detection"
+	}
+	if len(ep.Routes) > 0 {
+		setting = "routes"
+	}
+	if len(ep.EthtoolFeatures) > 0 {
+		setting = "ethtool features"
+	}
+	for key := range ep.Sysctls {
+		if intfSysctl(key) {
+			setting = "sysctl " + key
+		}
+	}
+	if setting != "" {
+		return core.Errorf("the driver of endpoint %s does not move its interface to the netns, %s can not be applied",
+			ep.ID, setting)
+	}
+	return nil
+}
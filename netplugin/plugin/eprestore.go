@@ -0,0 +1,66 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"github.com/Sirupsen/logrus"
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/netmaster/master"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+)
+
+// RestoreEndpoint brings back endpoint id of this host from the recycle bin
+// of netmaster and programs it again with the addresses it had. The
+// endpoint goes back to the recycle bin when it can not be programmed.
+func (p *NetPlugin) RestoreEndpoint(id string) error {
+	if err := p.rateLimit(); err != nil {
+		return err
+	}
+	defer p.lockEndpoint(id)()
+	if p.NetworkDriver == nil {
+		return p.driverErr()
+	}
+	if p.StateDriver == nil {
+		return ErrNotInitialized
+	}
+
+	recycled := &mastercfg.RecycledEndpointState{}
+	recycled.StateDriver = p.StateDriver
+	if err := recycled.Read(id); err != nil {
+		return core.Errorf("endpoint %s is not in the recycle bin", id)
+	}
+	if recycled.Endpoint.HomingHost != p.PluginConfig.Instance.HostLabel {
+		return core.Errorf("endpoint %s is not on this host", id)
+	}
+
+	if _, err := master.RestoreEndpointID(p.StateDriver, id); err != nil {
+		return err
+	}
+
+	driver, err := p.endpointDriver(id)
+	if err == nil {
+		err = driver.CreateEndpoint(id)
+	}
+	if err != nil {
+		logrus.Errorf("Error programming restored endpoint %s. Err: %v", id, err)
+		if _, recErr := master.RecycleEndpointID(p.StateDriver, id); recErr != nil {
+			logrus.Errorf("Error moving endpoint %s back to the recycle bin. Err: %v", id, recErr)
+		}
+		return err
+	}
+	p.endpointCreated(id, driver)
+	return nil
+}
@@ -0,0 +1,106 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"fmt"
+	"net"
+	osexec "os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+)
+
+// RouteError is returned when a static route of an endpoint can not be set
+type RouteError struct {
+	Dest   string
+	Reason string
+}
+
+func (e *RouteError) Error() string {
+	return fmt.Sprintf("route to %s: %s", e.Dest, e.Reason)
+}
+
+// checkRouteNextHops verifies that the next hops of the routes of an
+// endpoint are reachable on its interface
+func checkRouteNextHops(nw *mastercfg.CfgNetworkState, ep *mastercfg.CfgEndpointState) error {
+	for _, route := range ep.Routes {
+		for _, hop := range route.Hops() {
+			gw := net.ParseIP(hop.Gateway)
+			if gw == nil {
+				return &RouteError{Dest: route.Dest, Reason: fmt.Sprintf("invalid gateway %q", hop.Gateway)}
+			}
+			if !nw.OnLink(gw) {
+				return &RouteError{Dest: route.Dest,
+					Reason: fmt.Sprintf("gateway %s is not reachable on %s", hop.Gateway, ep.IntfName)}
+			}
+		}
+	}
+	return nil
+}
+
+// routeArgs returns the ip arguments that set up a route through dev. A
+// route with several next hops is set up as a weighted multipath route.
+func routeArgs(route mastercfg.EndpointRoute, dev string) []string {
+	family := "-4"
+	if strings.Contains(route.Dest, ":") {
+		family = "-6"
+	}
+	args := []string{family, "route", "replace", route.Dest}
+
+	hops := route.Hops()
+	if len(hops) == 1 {
+		return append(args, "via", hops[0].Gateway, "dev", dev)
+	}
+	for _, hop := range hops {
+		args = append(args, "nexthop", "via", hop.Gateway, "dev", dev,
+			"weight", strconv.Itoa(hop.Weight))
+	}
+	return args
+}
+
+// applyRoutes sets up the static routes of an endpoint in the container
+// netns at nsPath
+func applyRoutes(nsPath string, ep *mastercfg.CfgEndpointState) error {
+	if len(ep.Routes) == 0 {
+		return nil
+	}
+	if nsPath == "" {
+		return core.Errorf("endpoint %s has routes but no netns", ep.ID)
+	}
+	if ep.IntfName == "" {
+		return core.Errorf("endpoint %s has routes but no interface name", ep.ID)
+	}
+
+	nsenterPath, err := osexec.LookPath("nsenter")
+	if err != nil {
+		return err
+	}
+
+	for _, route := range ep.Routes {
+		args := append([]string{"--net=" + nsPath, "--", "ip"}, routeArgs(route, ep.IntfName)...)
+		out, err := osexec.Command(nsenterPath, args...).CombinedOutput()
+		core.Audit(nsenterPath, args, err)
+		if err != nil {
+			logrus.Errorf("Error adding route to %s for endpoint %s. Err: %v - %s", route.Dest, ep.ID, err, out)
+			return &RouteError{Dest: route.Dest, Reason: strings.TrimSpace(string(out))}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,313 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/netmaster/intent"
+	"github.com/contiv/netplugin/netmaster/master"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+)
+
+// EndpointSpec describes an endpoint to be created in a single call, as
+// read by CreateEndpointFromSpec
+type EndpointSpec struct {
+	Tenant      string            `json:"tenant"`
+	Network     string            `json:"network"`
+	ServiceName string            `json:"serviceName"`
+	ContainerID string            `json:"containerId"` // generated when empty
+	IPAddress   string            `json:"ipAddress"`   // allocated when empty
+	IPv6Address string            `json:"ipv6Address"`
+	MacAddress  string            `json:"macAddress"`
+	MTU         int               `json:"mtu"` // network mtu when zero
+	Labels      map[string]string `json:"labels"`
+	Routes      []EndpointRoute   `json:"routes"`
+	Driver      string            `json:"driver"` // endpoint driver, the network driver when empty
+	// ForceIPReuse allows allocating an address still in the quarantine
+	// of the network
+	ForceIPReuse bool `json:"forceIpReuse"`
+	// AllocRange names the allocation range of the network the address is
+	// allocated from, IPAddress must then be in it when set
+	AllocRange string `json:"allocRange"`
+	// LeaseTTL makes the endpoint ephemeral, its record expires when not
+	// refreshed for that many seconds and the endpoint is then removed
+	LeaseTTL int `json:"leaseTtl"`
+
+	Sysctls         map[string]string `json:"sysctls"`
+	EthtoolFeatures map[string]bool   `json:"ethtoolFeatures"`
+	Promisc         bool              `json:"promisc"`
+	AllMulti        bool              `json:"allMulti"`
+
+	ConntrackExemptions []ConntrackExemption `json:"conntrackExemptions"`
+
+	// Bond backs the endpoint by a bond of host uplinks instead of a veth
+	// pair
+	Bond *EndpointBond `json:"bond"`
+
+	// Tap backs the endpoint by a tap device for a vm instead of a veth
+	// pair, the name of the tap is returned by CreateEndpointEx
+	Tap bool `json:"tap"`
+
+	// RateLimit limits the traffic the endpoint sends, with a meter when
+	// the switch supports meters
+	RateLimit *EndpointRateLimit `json:"rateLimit"`
+
+	// Template names an endpoint template whose options fill the ones
+	// the spec leaves unset
+	Template string `json:"template"`
+}
+
+// EndpointBond is the bond of host uplinks backing the endpoint, in
+// active-backup or lacp mode
+type EndpointBond struct {
+	Mode    string   `json:"mode"`
+	Members []string `json:"members"`
+}
+
+// EndpointRateLimit is the rate in kbps and burst in kb the traffic the
+// endpoint sends is limited to
+type EndpointRateLimit struct {
+	Rate  int64 `json:"rate"`
+	Burst int   `json:"burst"`
+}
+
+// ConntrackExemption is the five-tuple of flows of the endpoint that are not
+// tracked, empty addresses and zero ports match any
+type ConntrackExemption struct {
+	Protocol string `json:"protocol"`
+	SrcIP    string `json:"srcIP"`
+	SrcPort  int    `json:"srcPort"`
+	DstIP    string `json:"dstIP"`
+	DstPort  int    `json:"dstPort"`
+}
+
+// EndpointRoute is a static route of an endpoint spec, with either a
+// gateway or weighted next hops
+type EndpointRoute struct {
+	Dest     string         `json:"dest"`
+	Gateway  string         `json:"gateway"`
+	NextHops []RouteNextHop `json:"nextHops"`
+}
+
+// RouteNextHop is a weighted next hop of a multipath route
+type RouteNextHop struct {
+	Gateway string `json:"gateway"`
+	Weight  int    `json:"weight"`
+}
+
+// specError reports the spec field that failed validation
+func specError(field, format string, args ...interface{}) error {
+	return core.Errorf("endpoint spec: %s: "+format, append([]interface{}{field}, args...)...)
+}
+
+// validate checks the fields of the spec that can be checked without state
+func (spec *EndpointSpec) validate() error {
+	if spec.Tenant == "" {
+		return specError("tenant", "is required")
+	}
+	if spec.Network == "" {
+		return specError("network", "is required")
+	}
+	if spec.ContainerID != "" {
+		if err := master.ValidateContainerID(spec.ContainerID); err != nil {
+			return specError("containerId", "%v", err)
+		}
+	}
+	return spec.validateOptions()
+}
+
+// validateOptions checks the fields of the spec other than its tenant and
+// network, which endpoint templates have too
+func (spec *EndpointSpec) validateOptions() error {
+	if spec.IPAddress != "" && net.ParseIP(spec.IPAddress).To4() == nil {
+		return specError("ipAddress", "invalid address %q", spec.IPAddress)
+	}
+	if spec.IPv6Address != "" && net.ParseIP(spec.IPv6Address) == nil {
+		return specError("ipv6Address", "invalid address %q", spec.IPv6Address)
+	}
+	if spec.MacAddress != "" {
+		if _, err := net.ParseMAC(spec.MacAddress); err != nil {
+			return specError("macAddress", "invalid mac %q", spec.MacAddress)
+		}
+	}
+	if spec.LeaseTTL != 0 && spec.LeaseTTL < master.MinLeaseTTL {
+		return specError("leaseTtl", "must be at least %d seconds", master.MinLeaseTTL)
+	}
+	for idx, route := range spec.Routes {
+		if _, _, err := net.ParseCIDR(route.Dest); err != nil {
+			return specError(fmt.Sprintf("routes[%d].dest", idx), "invalid cidr %q", route.Dest)
+		}
+		if len(route.NextHops) == 0 && net.ParseIP(route.Gateway) == nil {
+			return specError(fmt.Sprintf("routes[%d].gateway", idx), "invalid address %q", route.Gateway)
+		}
+		for hopIdx, hop := range route.NextHops {
+			if net.ParseIP(hop.Gateway) == nil {
+				return specError(fmt.Sprintf("routes[%d].nextHops[%d].gateway", idx, hopIdx),
+					"invalid address %q", hop.Gateway)
+			}
+		}
+	}
+	return nil
+}
+
+// CreateEndpointFromSpec reads a JSON endpoint spec from r, writes the
+// endpoint state and creates the endpoint. It returns the endpoint id.
+// Endpoints with settings applied on attach are created detached, to be
+// wired in their netns by AttachEndpoint.
+func (p *NetPlugin) CreateEndpointFromSpec(r io.Reader) (string, error) {
+	spec := EndpointSpec{}
+	if err := json.NewDecoder(r).Decode(&spec); err != nil {
+		return "", core.Errorf("endpoint spec: %v", err)
+	}
+	if err := spec.validate(); err != nil {
+		return "", err
+	}
+	if err := p.rateLimit(); err != nil {
+		return "", err
+	}
+
+	p.Lock()
+	defer p.Unlock()
+	if p.StateDriver == nil {
+		return "", ErrNotInitialized
+	}
+	if p.NetworkDriver == nil {
+		return "", p.driverErr()
+	}
+
+	if spec.Template != "" {
+		if err := p.applyEndpointTemplate(&spec); err != nil {
+			return "", err
+		}
+	}
+
+	netID := spec.Network + "." + spec.Tenant
+	nwCfg := &mastercfg.CfgNetworkState{}
+	nwCfg.StateDriver = p.StateDriver
+	if err := nwCfg.Read(netID); err != nil {
+		return "", specError("network", "network %s not found", netID)
+	}
+
+	if spec.ContainerID == "" {
+		id := make([]byte, 16)
+		if _, err := rand.Read(id); err != nil {
+			return "", err
+		}
+		spec.ContainerID = hex.EncodeToString(id)
+	}
+
+	epReq := master.CreateEndpointRequest{
+		TenantName:  spec.Tenant,
+		NetworkName: spec.Network,
+		ServiceName: spec.ServiceName,
+		EndpointID:  spec.ContainerID,
+		ConfigEP: intent.ConfigEP{
+			Container:    spec.ContainerID,
+			Host:         p.PluginConfig.Instance.HostLabel,
+			IPAddress:    spec.IPAddress,
+			IPv6Address:  spec.IPv6Address,
+			ServiceName:  spec.ServiceName,
+			MacAddress:   spec.MacAddress,
+			MTU:          spec.MTU,
+			Labels:       spec.Labels,
+			Driver:       spec.Driver,
+			ForceIPReuse: spec.ForceIPReuse,
+			AllocRange:   spec.AllocRange,
+			LeaseTTL:     spec.LeaseTTL,
+
+			Sysctls:         spec.Sysctls,
+			EthtoolFeatures: spec.EthtoolFeatures,
+			Promisc:         spec.Promisc,
+			AllMulti:        spec.AllMulti,
+		},
+	}
+	for _, route := range spec.Routes {
+		cfgRoute := intent.ConfigRoute{Dest: route.Dest, Gateway: route.Gateway}
+		for _, hop := range route.NextHops {
+			cfgRoute.NextHops = append(cfgRoute.NextHops,
+				intent.ConfigNextHop{Gateway: hop.Gateway, Weight: hop.Weight})
+		}
+		epReq.ConfigEP.Routes = append(epReq.ConfigEP.Routes, cfgRoute)
+	}
+	for _, exemption := range spec.ConntrackExemptions {
+		epReq.ConfigEP.ConntrackExemptions = append(epReq.ConfigEP.ConntrackExemptions,
+			intent.ConfigConntrackExemption{
+				Protocol: exemption.Protocol,
+				SrcIP:    exemption.SrcIP,
+				SrcPort:  exemption.SrcPort,
+				DstIP:    exemption.DstIP,
+				DstPort:  exemption.DstPort,
+			})
+	}
+
+	if spec.Bond != nil {
+		epReq.ConfigEP.Bond = &intent.ConfigBond{Mode: spec.Bond.Mode, Members: spec.Bond.Members}
+	}
+	epReq.ConfigEP.Tap = spec.Tap
+	if spec.RateLimit != nil {
+		epReq.ConfigEP.RateLimit = &intent.ConfigRateLimit{Rate: spec.RateLimit.Rate, Burst: spec.RateLimit.Burst}
+	}
+
+	driver, err := p.driverByName(spec.Driver)
+	if err != nil {
+		return "", specError("driver", "%v", err)
+	}
+
+	// the state of an endpoint that already exists is kept on failures
+	epCfg := &mastercfg.CfgEndpointState{}
+	epCfg.StateDriver = p.StateDriver
+	existed := epCfg.Read(master.ContainerEpID(netID, spec.ContainerID)) == nil
+
+	epCfg, err = master.CreateEndpoint(p.StateDriver, nwCfg, &epReq)
+	if err != nil {
+		logrus.Errorf("Error writing state for endpoint %s on %s. Err: %v", spec.ContainerID, netID, err)
+		return "", err
+	}
+
+	// the settings applied on attach need the netns of the endpoint
+	if !existed && hasAttachSettings(epCfg) {
+		epCfg.Status = mastercfg.EndpointStatusDetached
+		if err := epCfg.Write(); err != nil {
+			return "", err
+		}
+		p.startLease(epCfg)
+		return epCfg.ID, nil
+	}
+
+	err = driver.CreateEndpoint(epCfg.ID)
+	if err != nil {
+		logrus.Errorf("Error creating endpoint %s. Err: %v", epCfg.ID, err)
+		if existed {
+			return "", err
+		}
+		if _, delErr := master.DeleteEndpointID(p.StateDriver, epCfg.ID); delErr != nil {
+			logrus.Errorf("Error removing state for endpoint %s. Err: %v", epCfg.ID, delErr)
+		}
+		return "", err
+	}
+	p.endpointCreated(epCfg.ID, driver)
+	p.startLease(epCfg)
+
+	return epCfg.ID, nil
+}
@@ -0,0 +1,219 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+)
+
+// CreateEndpointTemplate reads a JSON endpoint template from r and stores
+// it as template name. The template has the option fields of an endpoint
+// spec: serviceName, mtu, labels, routes, driver, leaseTtl, sysctls,
+// ethtoolFeatures and conntrackExemptions.
+func (p *NetPlugin) CreateEndpointTemplate(name string, r io.Reader) error {
+	return p.writeEndpointTemplate(name, r, false)
+}
+
+// UpdateEndpointTemplate replaces template name by the JSON endpoint
+// template read from r. Endpoints created from the template are unchanged.
+func (p *NetPlugin) UpdateEndpointTemplate(name string, r io.Reader) error {
+	return p.writeEndpointTemplate(name, r, true)
+}
+
+// writeEndpointTemplate validates and stores an endpoint template, which
+// must exist already when replace is set and must not otherwise
+func (p *NetPlugin) writeEndpointTemplate(name string, r io.Reader, replace bool) error {
+	if name == "" || strings.Contains(name, "/") {
+		return core.Errorf("invalid endpoint template name %q", name)
+	}
+	tmpl := &mastercfg.EndpointTemplateState{}
+	if err := json.NewDecoder(r).Decode(tmpl); err != nil {
+		return core.Errorf("endpoint template %s: %v", name, err)
+	}
+	spec := templateSpec(tmpl)
+	if err := spec.validateOptions(); err != nil {
+		return core.Errorf("endpoint template %s: %v", name, err)
+	}
+
+	p.Lock()
+	defer p.Unlock()
+	if p.StateDriver == nil {
+		return ErrNotInitialized
+	}
+
+	existing := &mastercfg.EndpointTemplateState{}
+	existing.StateDriver = p.StateDriver
+	err := existing.Read(name)
+	if replace && err != nil {
+		return core.Errorf("endpoint template %s not found", name)
+	}
+	if !replace && err == nil {
+		return core.Errorf("endpoint template %s already exists", name)
+	}
+
+	tmpl.ID = name
+	tmpl.StateDriver = p.StateDriver
+	if err := tmpl.Write(); err != nil {
+		logrus.Errorf("Error writing endpoint template %s. Err: %v", name, err)
+		return err
+	}
+	return nil
+}
+
+// GetEndpointTemplate returns endpoint template name
+func (p *NetPlugin) GetEndpointTemplate(name string) (*mastercfg.EndpointTemplateState, error) {
+	if p.StateDriver == nil {
+		return nil, ErrNotInitialized
+	}
+	tmpl := &mastercfg.EndpointTemplateState{}
+	tmpl.StateDriver = p.StateDriver
+	if err := tmpl.Read(name); err != nil {
+		return nil, core.Errorf("endpoint template %s not found", name)
+	}
+	return tmpl, nil
+}
+
+// ListEndpointTemplates returns the names of the endpoint templates, sorted
+func (p *NetPlugin) ListEndpointTemplates() ([]string, error) {
+	if p.StateDriver == nil {
+		return nil, ErrNotInitialized
+	}
+	tmpl := &mastercfg.EndpointTemplateState{}
+	tmpl.StateDriver = p.StateDriver
+	tmpls, err := tmpl.ReadAll()
+	if core.ErrIfKeyExists(err) != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for _, state := range tmpls {
+		names = append(names, state.(*mastercfg.EndpointTemplateState).ID)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// DeleteEndpointTemplate removes endpoint template name. Endpoints created
+// from it keep their options.
+func (p *NetPlugin) DeleteEndpointTemplate(name string) error {
+	p.Lock()
+	defer p.Unlock()
+	tmpl, err := p.GetEndpointTemplate(name)
+	if err != nil {
+		return err
+	}
+	return tmpl.Clear()
+}
+
+// applyEndpointTemplate merges the template named by the spec into it
+func (p *NetPlugin) applyEndpointTemplate(spec *EndpointSpec) error {
+	tmpl := &mastercfg.EndpointTemplateState{}
+	tmpl.StateDriver = p.StateDriver
+	if err := tmpl.Read(spec.Template); err != nil {
+		return specError("template", "template %s not found", spec.Template)
+	}
+	mergeTemplate(spec, templateSpec(tmpl))
+	return nil
+}
+
+// templateSpec returns an endpoint spec with the options of a template
+func templateSpec(tmpl *mastercfg.EndpointTemplateState) *EndpointSpec {
+	spec := &EndpointSpec{
+		ServiceName:     tmpl.ServiceName,
+		MTU:             tmpl.MTU,
+		Labels:          tmpl.Labels,
+		Driver:          tmpl.Driver,
+		LeaseTTL:        tmpl.LeaseTTL,
+		Sysctls:         tmpl.Sysctls,
+		EthtoolFeatures: tmpl.EthtoolFeatures,
+	}
+	for _, route := range tmpl.Routes {
+		specRoute := EndpointRoute{Dest: route.Dest, Gateway: route.Gateway}
+		for _, hop := range route.NextHops {
+			specRoute.NextHops = append(specRoute.NextHops, RouteNextHop{Gateway: hop.Gateway, Weight: hop.Weight})
+		}
+		spec.Routes = append(spec.Routes, specRoute)
+	}
+	for _, exemption := range tmpl.ConntrackExemptions {
+		spec.ConntrackExemptions = append(spec.ConntrackExemptions, ConntrackExemption{
+			Protocol: exemption.Protocol,
+			SrcIP:    exemption.SrcIP,
+			SrcPort:  exemption.SrcPort,
+			DstIP:    exemption.DstIP,
+			DstPort:  exemption.DstPort,
+		})
+	}
+	return spec
+}
+
+// mergeTemplate fills the options the spec leaves unset from the template
+// spec tmpl. Labels, sysctls and ethtool features are merged key by key,
+// the keys set in the spec win.
+func mergeTemplate(spec, tmpl *EndpointSpec) {
+	if spec.ServiceName == "" {
+		spec.ServiceName = tmpl.ServiceName
+	}
+	if spec.MTU == 0 {
+		spec.MTU = tmpl.MTU
+	}
+	if spec.Driver == "" {
+		spec.Driver = tmpl.Driver
+	}
+	if spec.LeaseTTL == 0 {
+		spec.LeaseTTL = tmpl.LeaseTTL
+	}
+	if spec.Routes == nil {
+		spec.Routes = tmpl.Routes
+	}
+	if spec.ConntrackExemptions == nil {
+		spec.ConntrackExemptions = tmpl.ConntrackExemptions
+	}
+	spec.Labels = mergeStringMap(tmpl.Labels, spec.Labels)
+	spec.Sysctls = mergeStringMap(tmpl.Sysctls, spec.Sysctls)
+
+	if len(tmpl.EthtoolFeatures) != 0 {
+		features := map[string]bool{}
+		for key, val := range tmpl.EthtoolFeatures {
+			features[key] = val
+		}
+		for key, val := range spec.EthtoolFeatures {
+			features[key] = val
+		}
+		spec.EthtoolFeatures = features
+	}
+}
+
+// mergeStringMap returns base overridden by the keys of over
+func mergeStringMap(base, over map[string]string) map[string]string {
+	if len(base) == 0 {
+		return over
+	}
+	merged := map[string]string{}
+	for key, val := range base {
+		merged[key] = val
+	}
+	for key, val := range over {
+		merged[key] = val
+	}
+	return merged
+}
@@ -0,0 +1,73 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"github.com/Sirupsen/logrus"
+	"github.com/contiv/netplugin/core"
+)
+
+// Feature flags known to the plugin. Config.Features turns them on or off,
+// the ones it leaves out keep their default.
+const (
+	// FeatureNetworkEncryption lets CreateNetworkSpec create networks
+	// with an encrypted overlay. On by default.
+	FeatureNetworkEncryption = "network-encryption"
+	// FeatureGatewayArpProxy lets CreateNetworkSpec create networks whose
+	// gateway arp is answered by the hosts. On by default.
+	FeatureGatewayArpProxy = "gateway-arp-proxy"
+)
+
+// featureDefaults are the defaults of the known feature flags
+var featureDefaults = map[string]bool{
+	FeatureNetworkEncryption: true,
+	FeatureGatewayArpProxy:   true,
+}
+
+// FeatureEnabled tells whether feature flag name is on. A flag that is
+// neither known nor set in the config is off.
+func (p *NetPlugin) FeatureEnabled(name string) bool {
+	p.featureLock.RLock()
+	defer p.featureLock.RUnlock()
+	if enabled, ok := p.features[name]; ok {
+		return enabled
+	}
+	return featureDefaults[name]
+}
+
+// setFeatures applies the feature flags of a config. It takes its own lock
+// so that FeatureEnabled can be called with the plugin lock held.
+func (p *NetPlugin) setFeatures(features map[string]bool) {
+	current := map[string]bool{}
+	for name, enabled := range features {
+		if _, ok := featureDefaults[name]; !ok {
+			logrus.Warnf("Unknown feature flag %q", name)
+		}
+		current[name] = enabled
+	}
+
+	p.featureLock.Lock()
+	defer p.featureLock.Unlock()
+	p.features = current
+}
+
+// checkFeature fails when feature flag name is off
+func (p *NetPlugin) checkFeature(name string) error {
+	if !p.FeatureEnabled(name) {
+		return core.Errorf("feature %s is disabled", name)
+	}
+	return nil
+}
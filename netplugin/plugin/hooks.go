@@ -0,0 +1,80 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"github.com/Sirupsen/logrus"
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+	"github.com/vishvananda/netns"
+)
+
+// AttachHook adjusts the interface of an endpoint when it is attached. ns is
+// the container network namespace, netns.None() when the caller did not
+// give one.
+type AttachHook func(ns netns.NsHandle, ep *mastercfg.CfgEndpointState) error
+
+type attachHook struct {
+	name string
+	fn   AttachHook
+}
+
+// AddPreAttachHook registers a hook run before the endpoint is wired up. A
+// failing pre-attach hook fails the attach.
+func (p *NetPlugin) AddPreAttachHook(name string, fn AttachHook) {
+	p.Lock()
+	defer p.Unlock()
+	p.preAttachHooks = append(p.preAttachHooks, attachHook{name, fn})
+}
+
+// AddPostAttachHook registers a hook run once the endpoint is wired up.
+// Failures of post-attach hooks are only logged.
+func (p *NetPlugin) AddPostAttachHook(name string, fn AttachHook) {
+	p.Lock()
+	defer p.Unlock()
+	p.postAttachHooks = append(p.postAttachHooks, attachHook{name, fn})
+}
+
+// openHookNetns opens the namespace handed to the attach hooks
+func (p *NetPlugin) openHookNetns(nsPath string) (netns.NsHandle, error) {
+	if nsPath == "" || (len(p.preAttachHooks) == 0 && len(p.postAttachHooks) == 0) {
+		return netns.None(), nil
+	}
+
+	ns, err := netns.GetFromPath(nsPath)
+	if err != nil {
+		return netns.None(), core.Errorf("error opening netns %s: %v", nsPath, err)
+	}
+	return ns, nil
+}
+
+func (p *NetPlugin) runPreAttachHooks(ns netns.NsHandle, ep *mastercfg.CfgEndpointState) error {
+	for _, hook := range p.preAttachHooks {
+		if err := hook.fn(ns, ep); err != nil {
+			logrus.Errorf("Pre-attach hook %s failed for endpoint %s. Err: %v", hook.name, ep.ID, err)
+			return core.Errorf("pre-attach hook %s failed: %v", hook.name, err)
+		}
+	}
+	return nil
+}
+
+func (p *NetPlugin) runPostAttachHooks(ns netns.NsHandle, ep *mastercfg.CfgEndpointState) {
+	for _, hook := range p.postAttachHooks {
+		if err := hook.fn(ns, ep); err != nil {
+			logrus.Errorf("Post-attach hook %s failed for endpoint %s. Err: %v", hook.name, ep.ID, err)
+		}
+	}
+}
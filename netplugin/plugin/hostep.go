@@ -0,0 +1,198 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"fmt"
+	"syscall"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/drivers"
+	"github.com/contiv/netplugin/netmaster/intent"
+	"github.com/contiv/netplugin/netmaster/master"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+	"github.com/vishvananda/netlink"
+)
+
+// hostEndpointContainer is the container id of the host endpoint of a host,
+// a host has at most one endpoint per network
+func hostEndpointContainer(hostLabel string) string {
+	return "host-" + hostLabel
+}
+
+// CreateHostEndpoint attaches the host itself to a network. The endpoint
+// interface stays in the host netns and gets the address allocated to the
+// endpoint, so that the host stack reaches the endpoints of the network.
+// It returns the endpoint id.
+func (p *NetPlugin) CreateHostEndpoint(networkID string) (string, error) {
+	if err := p.rateLimit(); err != nil {
+		return "", err
+	}
+	p.Lock()
+	defer p.Unlock()
+	if p.NetworkDriver == nil {
+		return "", p.driverErr()
+	}
+	if p.StateDriver == nil {
+		return "", ErrNotInitialized
+	}
+
+	nwCfg := &mastercfg.CfgNetworkState{}
+	nwCfg.StateDriver = p.StateDriver
+	if err := nwCfg.Read(networkID); err != nil {
+		return "", err
+	}
+	if nwCfg.IPAM == mastercfg.IPAMDhcp {
+		return "", core.Errorf("network %s is addressed by dhcp, host endpoints need an allocated address", networkID)
+	}
+
+	hostLabel := p.PluginConfig.Instance.HostLabel
+	containerID := hostEndpointContainer(hostLabel)
+	epID := networkID + "-" + containerID
+	existing := &mastercfg.CfgEndpointState{}
+	existing.StateDriver = p.StateDriver
+	if err := existing.Read(epID); err == nil {
+		return "", core.Errorf("host %s already has endpoint %s on network %s", hostLabel, epID, networkID)
+	}
+
+	epReq := master.CreateEndpointRequest{
+		TenantName:  nwCfg.Tenant,
+		NetworkName: nwCfg.NetworkName,
+		EndpointID:  containerID,
+		ConfigEP: intent.ConfigEP{
+			Container:    containerID,
+			Host:         hostLabel,
+			HostEndpoint: true,
+		},
+	}
+	epCfg, err := master.CreateEndpoint(p.StateDriver, nwCfg, &epReq)
+	if err != nil {
+		logrus.Errorf("Error writing state for host endpoint on %s. Err: %v", networkID, err)
+		return "", err
+	}
+
+	err = p.NetworkDriver.CreateEndpoint(epCfg.ID)
+	if err == nil {
+		err = p.configureHostEndpoint(epCfg, nwCfg)
+		if err != nil {
+			if delErr := p.NetworkDriver.DeleteEndpoint(epCfg.ID); delErr != nil {
+				logrus.Errorf("Error removing host endpoint %s. Err: %v", epCfg.ID, delErr)
+			}
+		}
+	}
+	if err != nil {
+		logrus.Errorf("Error creating host endpoint %s. Err: %v", epCfg.ID, err)
+		if _, delErr := master.DeleteEndpointID(p.StateDriver, epCfg.ID); delErr != nil {
+			logrus.Errorf("Error removing state for endpoint %s. Err: %v", epCfg.ID, delErr)
+		}
+		return "", err
+	}
+	p.endpointCreated(epCfg.ID, p.NetworkDriver)
+
+	epCfg.Status = mastercfg.EndpointStatusAttached
+	if err := epCfg.Write(); err != nil {
+		return "", err
+	}
+	return epCfg.ID, nil
+}
+
+// DeleteHostEndpoint removes a host endpoint created by CreateHostEndpoint
+// and releases its address
+func (p *NetPlugin) DeleteHostEndpoint(id string) error {
+	if err := p.rateLimit(); err != nil {
+		return err
+	}
+	p.Lock()
+	defer p.Unlock()
+	if p.NetworkDriver == nil {
+		return p.driverErr()
+	}
+	if p.StateDriver == nil {
+		return ErrNotInitialized
+	}
+
+	epCfg := &mastercfg.CfgEndpointState{}
+	epCfg.StateDriver = p.StateDriver
+	if err := epCfg.Read(id); err != nil {
+		return err
+	}
+	if !epCfg.HostEndpoint {
+		return core.Errorf("endpoint %s is not a host endpoint", id)
+	}
+	if epCfg.HomingHost != p.PluginConfig.Instance.HostLabel {
+		return core.Errorf("endpoint %s is not on this host", id)
+	}
+
+	if err := p.NetworkDriver.DeleteEndpoint(id); err != nil {
+		logrus.Errorf("Error removing host endpoint %s. Err: %v", id, err)
+		return err
+	}
+	p.endpointDeleted(id)
+
+	_, err := master.DeleteEndpointID(p.StateDriver, id)
+	return err
+}
+
+// hostEndpointIntf returns the interface of a wired host endpoint
+func (p *NetPlugin) hostEndpointIntf(id string) (string, error) {
+	epOper := &drivers.OperEndpointState{}
+	epOper.StateDriver = p.StateDriver
+	if err := epOper.Read(id); err != nil {
+		return "", err
+	}
+	if epOper.PortName == "" {
+		return "", core.Errorf("host endpoint %s has no interface", id)
+	}
+	return epOper.PortName, nil
+}
+
+// configureHostEndpoint sets the addresses of a host endpoint on its
+// interface and brings it up. Addresses already set are kept, so that the
+// endpoint can be configured again on resync.
+func (p *NetPlugin) configureHostEndpoint(epCfg *mastercfg.CfgEndpointState, nwCfg *mastercfg.CfgNetworkState) error {
+	intfName, err := p.hostEndpointIntf(epCfg.ID)
+	if err != nil {
+		return err
+	}
+	link, err := netlink.LinkByName(intfName)
+	if err != nil {
+		return core.Errorf("error finding interface %s of host endpoint %s: %v", intfName, epCfg.ID, err)
+	}
+
+	cidrs := []string{}
+	if epCfg.IPAddress != "" {
+		cidrs = append(cidrs, fmt.Sprintf("%s/%d", epCfg.IPAddress, nwCfg.SubnetLen))
+	}
+	if epCfg.IPv6Address != "" {
+		cidrs = append(cidrs, fmt.Sprintf("%s/%d", epCfg.IPv6Address, nwCfg.IPv6SubnetLen))
+	}
+	for _, cidr := range cidrs {
+		addr, err := netlink.ParseAddr(cidr)
+		if err != nil {
+			return err
+		}
+		err = netlink.AddrAdd(link, addr)
+		core.Audit("addr add", []string{intfName, cidr}, err)
+		if err != nil && err != syscall.EEXIST {
+			return core.Errorf("error adding address %s to host endpoint %s: %v", cidr, epCfg.ID, err)
+		}
+	}
+
+	err = netlink.LinkSetUp(link)
+	core.Audit("link set up", []string{intfName}, err)
+	return err
+}
@@ -0,0 +1,177 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"github.com/Sirupsen/logrus"
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+)
+
+// networkInterconnector is implemented by network drivers that can stitch
+// the bridges of two networks together
+type networkInterconnector interface {
+	ConnectNetworks(id, netA, netB string) error
+	DisconnectNetworks(id string) error
+}
+
+// interconnector returns the network driver as a networkInterconnector
+func (p *NetPlugin) interconnector() (networkInterconnector, error) {
+	if p.NetworkDriver == nil {
+		return nil, p.driverErr()
+	}
+	driver, ok := p.NetworkDriver.(networkInterconnector)
+	if !ok {
+		return nil, core.Errorf("network driver does not support interconnects")
+	}
+	return driver, nil
+}
+
+// ConnectNetworks stitches networks netA and netB together at L2 on this
+// host. The interconnect is recorded in the state store and rebuilt when
+// either network is resynced.
+func (p *NetPlugin) ConnectNetworks(netA, netB string) error {
+	if err := p.rateLimit(); err != nil {
+		return err
+	}
+	p.Lock()
+	defer p.Unlock()
+	driver, err := p.interconnector()
+	if err != nil {
+		return err
+	}
+	if p.StateDriver == nil {
+		return ErrNotInitialized
+	}
+	if netA == netB {
+		return core.Errorf("cannot connect network %s to itself", netA)
+	}
+
+	for _, netID := range []string{netA, netB} {
+		nwCfg := &mastercfg.CfgNetworkState{}
+		nwCfg.StateDriver = p.StateDriver
+		if err := nwCfg.Read(netID); err != nil {
+			return err
+		}
+	}
+
+	hostLabel := p.PluginConfig.Instance.HostLabel
+	icCfg := &mastercfg.InterconnectState{Host: hostLabel, NetA: netA, NetB: netB}
+	icCfg.StateDriver = p.StateDriver
+	icCfg.ID = mastercfg.InterconnectID(hostLabel, netA, netB)
+	if err := icCfg.Read(icCfg.ID); err == nil {
+		return core.Errorf("networks %s and %s are already connected", netA, netB)
+	}
+
+	if err := driver.ConnectNetworks(icCfg.ID, netA, netB); err != nil {
+		logrus.Errorf("Error connecting networks %s and %s. Err: %v", netA, netB, err)
+		if err := driver.DisconnectNetworks(icCfg.ID); err != nil {
+			logrus.Errorf("Error removing interconnect %s. Err: %v", icCfg.ID, err)
+		}
+		return err
+	}
+	return icCfg.Write()
+}
+
+// DisconnectNetworks removes the interconnect of networks netA and netB on
+// this host
+func (p *NetPlugin) DisconnectNetworks(netA, netB string) error {
+	if err := p.rateLimit(); err != nil {
+		return err
+	}
+	p.Lock()
+	defer p.Unlock()
+	driver, err := p.interconnector()
+	if err != nil {
+		return err
+	}
+	if p.StateDriver == nil {
+		return ErrNotInitialized
+	}
+
+	icCfg := &mastercfg.InterconnectState{}
+	icCfg.StateDriver = p.StateDriver
+	if err := icCfg.Read(mastercfg.InterconnectID(p.PluginConfig.Instance.HostLabel, netA, netB)); err != nil {
+		return core.Errorf("networks %s and %s are not connected", netA, netB)
+	}
+	return p.removeInterconnect(driver, icCfg)
+}
+
+func (p *NetPlugin) removeInterconnect(driver networkInterconnector, icCfg *mastercfg.InterconnectState) error {
+	if err := driver.DisconnectNetworks(icCfg.ID); err != nil {
+		logrus.Errorf("Error disconnecting networks %s and %s. Err: %v", icCfg.NetA, icCfg.NetB, err)
+		return err
+	}
+	return icCfg.Clear()
+}
+
+// networkInterconnects returns the interconnects of network netID on this
+// host
+func (p *NetPlugin) networkInterconnects(netID string) ([]*mastercfg.InterconnectState, error) {
+	icCfg := &mastercfg.InterconnectState{}
+	icCfg.StateDriver = p.StateDriver
+	icCfgs, err := icCfg.ReadAll()
+	if core.ErrIfKeyExists(err) != nil {
+		return nil, err
+	}
+
+	interconnects := []*mastercfg.InterconnectState{}
+	for _, state := range icCfgs {
+		ic := state.(*mastercfg.InterconnectState)
+		if ic.Host == p.PluginConfig.Instance.HostLabel && ic.Connects(netID) {
+			interconnects = append(interconnects, ic)
+		}
+	}
+	return interconnects, nil
+}
+
+// reconnectNetwork rebuilds the interconnects of network netID on this host
+func (p *NetPlugin) reconnectNetwork(netID string) error {
+	driver, ok := p.NetworkDriver.(networkInterconnector)
+	if !ok {
+		return nil
+	}
+	interconnects, err := p.networkInterconnects(netID)
+	if err != nil {
+		return err
+	}
+	for _, ic := range interconnects {
+		if err := driver.ConnectNetworks(ic.ID, ic.NetA, ic.NetB); err != nil {
+			logrus.Errorf("Error rebuilding interconnect of networks %s and %s. Err: %v", ic.NetA, ic.NetB, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// disconnectNetwork removes the interconnects of network netID on this
+// host, before the network is deleted
+func (p *NetPlugin) disconnectNetwork(netID string) error {
+	driver, ok := p.NetworkDriver.(networkInterconnector)
+	if !ok || p.StateDriver == nil {
+		return nil
+	}
+	interconnects, err := p.networkInterconnects(netID)
+	if err != nil {
+		return err
+	}
+	for _, ic := range interconnects {
+		if err := p.removeInterconnect(driver, ic); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/netmaster/master"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+)
+
+// leaseRefreshes is the number of keepalives sent per lease ttl
+const leaseRefreshes = 3
+
+// startLease renews the lease of a local endpoint with a lease ttl until the
+// lease is stopped
+func (p *NetPlugin) startLease(epCfg *mastercfg.CfgEndpointState) {
+	if epCfg.LeaseTTL == 0 {
+		return
+	}
+	p.epMutex.Lock()
+	defer p.epMutex.Unlock()
+	if _, ok := p.leases[epCfg.ID]; ok {
+		return
+	}
+	if p.leases == nil {
+		p.leases = make(map[string]chan struct{})
+	}
+
+	stop := make(chan struct{})
+	p.leases[epCfg.ID] = stop
+	go keepLease(p.StateDriver, epCfg.ID, epCfg.LeaseTTL, stop)
+}
+
+// startEndpointLease starts the lease of endpoint id when it has one
+func (p *NetPlugin) startEndpointLease(id string) {
+	if p.StateDriver == nil {
+		return
+	}
+	epCfg := &mastercfg.CfgEndpointState{}
+	epCfg.StateDriver = p.StateDriver
+	if err := epCfg.Read(id); err == nil {
+		p.startLease(epCfg)
+	}
+}
+
+// stopLease stops refreshing the lease of an endpoint, it returns false when
+// the lease was not held
+func (p *NetPlugin) stopLease(id string) bool {
+	p.epMutex.Lock()
+	defer p.epMutex.Unlock()
+	stop, ok := p.leases[id]
+	if !ok {
+		return false
+	}
+	close(stop)
+	delete(p.leases, id)
+	return true
+}
+
+// stopLeases stops refreshing all the leases
+func (p *NetPlugin) stopLeases() {
+	p.epMutex.Lock()
+	defer p.epMutex.Unlock()
+	for id, stop := range p.leases {
+		close(stop)
+		delete(p.leases, id)
+	}
+}
+
+// keepLease renews the lease of an endpoint until stop is closed. It does
+// not take the plugin lock, a renewal only needs the state driver.
+func keepLease(stateDriver core.StateDriver, id string, ttl int, stop chan struct{}) {
+	ticker := time.NewTicker(time.Duration(ttl) * time.Second / leaseRefreshes)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := master.RenewEndpointLease(stateDriver, id); err != nil {
+				logrus.Errorf("Error renewing the lease of endpoint %s. Err: %v", id, err)
+			}
+		}
+	}
+}
+
+// EndpointLeaseExpired removes the port of a local endpoint that netmaster
+// deleted because its lease expired, epCfg is its last record as seen by
+// the endpoint watch. Deletes of endpoints whose lease is not held here are
+// ignored, as are endpoints deleted on purpose.
+func (p *NetPlugin) EndpointLeaseExpired(epCfg *mastercfg.CfgEndpointState) error {
+	p.Lock()
+	defer p.Unlock()
+	if p.StateDriver == nil {
+		return ErrNotInitialized
+	}
+	if epCfg.LeaseTTL == 0 || !master.EndpointLeaseExpired(p.StateDriver, epCfg.ID) {
+		return nil
+	}
+	if !p.stopLease(epCfg.ID) {
+		return nil
+	}
+
+	logrus.Warnf("Lease of endpoint %s expired, removing it", epCfg.ID)
+	return p.removeLocalEndpoint(epCfg)
+}
+
+// EndpointEvicted removes the port of a local endpoint that netmaster
+// removed along with its network, epCfg is its last record as seen by the
+// endpoint watch. Deletes of endpoints that were not evicted are ignored.
+func (p *NetPlugin) EndpointEvicted(epCfg *mastercfg.CfgEndpointState) error {
+	p.Lock()
+	defer p.Unlock()
+	if p.StateDriver == nil {
+		return ErrNotInitialized
+	}
+	if !epCfg.Evicted {
+		return nil
+	}
+	p.stopLease(epCfg.ID)
+
+	logrus.Warnf("Endpoint %s was evicted with its network, removing it", epCfg.ID)
+	return p.removeLocalEndpoint(epCfg)
+}
+
+// removeLocalEndpoint removes the port and the oper state of a local
+// endpoint whose config netmaster already deleted
+func (p *NetPlugin) removeLocalEndpoint(epCfg *mastercfg.CfgEndpointState) error {
+	if p.NetworkDriver == nil {
+		return p.driverErr()
+	}
+	if epCfg.Status != mastercfg.EndpointStatusDetached {
+		driver, err := p.driverByName(epCfg.Driver)
+		if err != nil {
+			return err
+		}
+		if err := driver.DeleteEndpoint(epCfg.ID); err != nil {
+			logrus.Errorf("Error removing endpoint %s. Err: %v", epCfg.ID, err)
+		}
+	}
+	p.endpointDeleted(epCfg.ID)
+	return nil
+}
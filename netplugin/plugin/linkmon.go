@@ -0,0 +1,146 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"github.com/Sirupsen/logrus"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+)
+
+// linkUpdateQueueLen is the number of link changes queued for processing,
+// further changes are dropped
+const linkUpdateQueueLen = 64
+
+// LinkHook is called when the link of an endpoint port goes down or up
+// outside of attach and detach, after the endpoint status is updated
+type LinkHook func(ep *mastercfg.CfgEndpointState, up bool)
+
+type linkHook struct {
+	name string
+	fn   LinkHook
+}
+
+// linkWatcher is implemented by network drivers that report link changes
+// of endpoint ports
+type linkWatcher interface {
+	WatchEndpointLinks(handler func(endpointID string, up bool))
+}
+
+type linkUpdate struct {
+	id string
+	up bool
+}
+
+// AddLinkHook registers a hook run when the link of an endpoint port goes
+// down or up
+func (p *NetPlugin) AddLinkHook(name string, fn LinkHook) {
+	p.Lock()
+	defer p.Unlock()
+	p.linkHooks = append(p.linkHooks, linkHook{name, fn})
+}
+
+// startLinkMonitor subscribes to the link changes of the network driver when
+// MonitorLinks is set. Changes are queued, the driver reports them from its
+// ovsdb notifications which must not wait for the plugin lock.
+func (p *NetPlugin) startLinkMonitor() {
+	if !p.PluginConfig.MonitorLinks {
+		return
+	}
+	watcher, ok := p.NetworkDriver.(linkWatcher)
+	if !ok {
+		logrus.Warnf("Network driver does not report link changes, link monitor disabled")
+		return
+	}
+
+	if p.linkUpdates == nil {
+		p.linkUpdates = make(chan linkUpdate, linkUpdateQueueLen)
+		p.linkMonitorDone = make(chan struct{})
+		go p.processLinkUpdates(p.linkUpdates, p.linkMonitorDone)
+	}
+	updates := p.linkUpdates
+	watcher.WatchEndpointLinks(func(id string, up bool) {
+		select {
+		case updates <- linkUpdate{id, up}:
+		default:
+			logrus.Warnf("Link update queue full, dropping link change of endpoint %s", id)
+		}
+	})
+}
+
+// stopLinkMonitor stops processing link changes
+func (p *NetPlugin) stopLinkMonitor() {
+	if p.linkUpdates == nil {
+		return
+	}
+	close(p.linkMonitorDone)
+	p.linkUpdates = nil
+	p.linkMonitorDone = nil
+}
+
+func (p *NetPlugin) processLinkUpdates(updates <-chan linkUpdate, done <-chan struct{}) {
+	for {
+		select {
+		case upd := <-updates:
+			p.endpointLinkChanged(upd.id, upd.up)
+		case <-done:
+			return
+		}
+	}
+}
+
+// endpointLinkChanged records the link state of an endpoint in its status
+// and runs the link hooks. Detached endpoints are left alone, their port is
+// going away on purpose.
+func (p *NetPlugin) endpointLinkChanged(id string, up bool) {
+	p.Lock()
+	defer p.Unlock()
+	if p.StateDriver == nil {
+		return
+	}
+
+	epCfg := &mastercfg.CfgEndpointState{}
+	epCfg.StateDriver = p.StateDriver
+	if err := epCfg.Read(id); err != nil {
+		logrus.Debugf("Ignoring link change of unknown endpoint %s. Err: %v", id, err)
+		return
+	}
+	if epCfg.Status == mastercfg.EndpointStatusDetached {
+		return
+	}
+
+	status := mastercfg.EndpointStatusAttached
+	if !up {
+		status = mastercfg.EndpointStatusLinkDown
+	}
+	if epCfg.Status == status || (epCfg.Status == "" && up) {
+		return
+	}
+
+	if up {
+		logrus.Infof("Link of endpoint %s is up", id)
+	} else {
+		logrus.Warnf("Link of endpoint %s went down", id)
+	}
+	epCfg.Status = status
+	if err := epCfg.Write(); err != nil {
+		logrus.Errorf("Error updating the status of endpoint %s. Err: %v", id, err)
+		return
+	}
+
+	for _, hook := range p.linkHooks {
+		hook.fn(epCfg, up)
+	}
+}
@@ -0,0 +1,41 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"github.com/Sirupsen/logrus"
+	"github.com/contiv/netplugin/core"
+)
+
+// SetLogLevel changes the log level of the plugin, e.g. to debug while
+// looking into an issue, without a restart. It applies to all the logging
+// from then on and lasts until the next change or restart.
+func (p *NetPlugin) SetLogLevel(level string) error {
+	newLevel, err := logrus.ParseLevel(level)
+	if err != nil {
+		return core.Errorf("invalid log level %q", level)
+	}
+
+	// logged before the change to show even when lowering the level
+	logrus.Infof("Changing the log level from %s to %s", logrus.GetLevel(), newLevel)
+	logrus.SetLevel(newLevel)
+	return nil
+}
+
+// GetLogLevel returns the log level of the plugin
+func (p *NetPlugin) GetLogLevel() string {
+	return logrus.GetLevel().String()
+}
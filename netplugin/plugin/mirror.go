@@ -0,0 +1,209 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"sort"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+)
+
+// endpointMirrorer is implemented by endpoint drivers that can mirror the
+// traffic of a local endpoint to a capture port. Creating a mirror again
+// rebuilds it for the current wiring of the endpoint.
+type endpointMirrorer interface {
+	CreateMirror(m *mastercfg.MirrorState) error
+	DeleteMirror(m *mastercfg.MirrorState) error
+}
+
+// mirrorsByID sorts mirrors by id
+type mirrorsByID []*mastercfg.MirrorState
+
+func (m mirrorsByID) Len() int           { return len(m) }
+func (m mirrorsByID) Swap(i, j int)      { m[i], m[j] = m[j], m[i] }
+func (m mirrorsByID) Less(i, j int) bool { return m[i].ID < m[j].ID }
+
+// mirrorDriver returns the driver of endpoint epID as an endpointMirrorer
+func (p *NetPlugin) mirrorDriver(epID string) (endpointMirrorer, error) {
+	if p.NetworkDriver == nil {
+		return nil, p.driverErr()
+	}
+	driver, err := p.endpointDriver(epID)
+	if err != nil {
+		return nil, err
+	}
+	mirrorer, ok := driver.(endpointMirrorer)
+	if !ok {
+		return nil, core.Errorf("endpoint driver does not support mirrors")
+	}
+	return mirrorer, nil
+}
+
+// StartMirror mirrors the traffic in and out of endpoint epID, wired on
+// this host, to capture interface destPort and returns the id of the
+// mirror. The mirror is recorded in the state store and rebuilt whenever
+// the endpoint is programmed again, until StopMirror or the endpoint is
+// deleted.
+func (p *NetPlugin) StartMirror(epID, destPort string) (string, error) {
+	if err := p.rateLimit(); err != nil {
+		return "", err
+	}
+	defer p.lockEndpoint(epID)()
+	if p.StateDriver == nil {
+		return "", ErrNotInitialized
+	}
+	if destPort == "" {
+		return "", core.Errorf("mirror of endpoint %s has no capture port", epID)
+	}
+	driver, err := p.mirrorDriver(epID)
+	if err != nil {
+		return "", err
+	}
+
+	epCfg := &mastercfg.CfgEndpointState{}
+	epCfg.StateDriver = p.StateDriver
+	if err := epCfg.Read(epID); err != nil {
+		return "", core.Errorf("endpoint %s not found", epID)
+	}
+	if epCfg.Status == mastercfg.EndpointStatusDetached {
+		return "", core.Errorf("endpoint %s is detached", epID)
+	}
+
+	hostLabel := p.PluginConfig.Instance.HostLabel
+	mirror := &mastercfg.MirrorState{Host: hostLabel, EndpointID: epID, DestPort: destPort}
+	mirror.StateDriver = p.StateDriver
+	mirror.ID = mastercfg.MirrorID(hostLabel, epID, destPort)
+	existing := &mastercfg.MirrorState{}
+	existing.StateDriver = p.StateDriver
+	if err := existing.Read(mirror.ID); err == nil {
+		return "", core.Errorf("endpoint %s is already mirrored to %s", epID, destPort)
+	}
+
+	if err := driver.CreateMirror(mirror); err != nil {
+		logrus.Errorf("Error mirroring endpoint %s to %s. Err: %v", epID, destPort, err)
+		if err := driver.DeleteMirror(mirror); err != nil {
+			logrus.Errorf("Error removing mirror %s. Err: %v", mirror.ID, err)
+		}
+		return "", err
+	}
+	if err := mirror.Write(); err != nil {
+		driver.DeleteMirror(mirror)
+		return "", err
+	}
+	logrus.Infof("Started mirror %s of endpoint %s to %s", mirror.ID, epID, destPort)
+	return mirror.ID, nil
+}
+
+// StopMirror removes mirror mirrorID of this host
+func (p *NetPlugin) StopMirror(mirrorID string) error {
+	if err := p.rateLimit(); err != nil {
+		return err
+	}
+	if p.StateDriver == nil {
+		return ErrNotInitialized
+	}
+
+	mirror := &mastercfg.MirrorState{}
+	mirror.StateDriver = p.StateDriver
+	if err := mirror.Read(mirrorID); err != nil {
+		return core.Errorf("mirror %s not found", mirrorID)
+	}
+	if mirror.Host != p.PluginConfig.Instance.HostLabel {
+		return core.Errorf("mirror %s is not on this host", mirrorID)
+	}
+
+	defer p.lockEndpoint(mirror.EndpointID)()
+	driver, err := p.mirrorDriver(mirror.EndpointID)
+	if err != nil {
+		return err
+	}
+	return p.removeMirror(driver, mirror)
+}
+
+// ListMirrors returns the mirrors of this host, by id
+func (p *NetPlugin) ListMirrors() ([]*mastercfg.MirrorState, error) {
+	if p.StateDriver == nil {
+		return nil, ErrNotInitialized
+	}
+	return p.mirrors("")
+}
+
+func (p *NetPlugin) removeMirror(driver endpointMirrorer, mirror *mastercfg.MirrorState) error {
+	if err := driver.DeleteMirror(mirror); err != nil {
+		logrus.Errorf("Error deleting mirror %s of endpoint %s. Err: %v", mirror.ID, mirror.EndpointID, err)
+		return err
+	}
+	logrus.Infof("Stopped mirror %s of endpoint %s", mirror.ID, mirror.EndpointID)
+	return mirror.Clear()
+}
+
+// mirrors returns the mirrors of endpoint epID on this host, all the mirrors
+// of this host when epID is empty
+func (p *NetPlugin) mirrors(epID string) ([]*mastercfg.MirrorState, error) {
+	mirror := &mastercfg.MirrorState{}
+	mirror.StateDriver = p.StateDriver
+	mirrorCfgs, err := mirror.ReadAll()
+	if core.ErrIfKeyExists(err) != nil {
+		return nil, err
+	}
+
+	mirrors := []*mastercfg.MirrorState{}
+	for _, state := range mirrorCfgs {
+		m := state.(*mastercfg.MirrorState)
+		if m.Host == p.PluginConfig.Instance.HostLabel && (epID == "" || m.EndpointID == epID) {
+			mirrors = append(mirrors, m)
+		}
+	}
+	sort.Sort(mirrorsByID(mirrors))
+	return mirrors, nil
+}
+
+// rebuildMirrors rebuilds the mirrors of endpoint epID once it is programmed
+// again. Failures are only logged, mirrors are a diagnostic aid.
+func (p *NetPlugin) rebuildMirrors(driver core.NetworkDriver, epID string) {
+	mirrorer, ok := driver.(endpointMirrorer)
+	if !ok || p.StateDriver == nil {
+		return
+	}
+	mirrors, err := p.mirrors(epID)
+	if err != nil {
+		logrus.Errorf("Error reading the mirrors of endpoint %s. Err: %v", epID, err)
+		return
+	}
+	for _, mirror := range mirrors {
+		if err := mirrorer.CreateMirror(mirror); err != nil {
+			logrus.Errorf("Error rebuilding mirror %s of endpoint %s. Err: %v", mirror.ID, epID, err)
+		}
+	}
+}
+
+// removeMirrors removes the mirrors of endpoint epID, before it is deleted
+func (p *NetPlugin) removeMirrors(driver core.NetworkDriver, epID string) {
+	mirrorer, ok := driver.(endpointMirrorer)
+	if !ok || p.StateDriver == nil {
+		return
+	}
+	mirrors, err := p.mirrors(epID)
+	if err != nil {
+		logrus.Errorf("Error reading the mirrors of endpoint %s. Err: %v", epID, err)
+		return
+	}
+	for _, mirror := range mirrors {
+		p.removeMirror(mirrorer, mirror)
+	}
+}
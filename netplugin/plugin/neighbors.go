@@ -0,0 +1,131 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+)
+
+// Neighbor is an entry of the neighbor (ARP/ND) cache of an endpoint netns
+type Neighbor struct {
+	IP        string
+	Mac       string // empty while unresolved
+	Interface string
+	State     string // e.g. reachable, stale, failed
+}
+
+// neighStates names the states of neighbor cache entries
+var neighStates = []struct {
+	state int
+	name  string
+}{
+	{netlink.NUD_INCOMPLETE, "incomplete"},
+	{netlink.NUD_REACHABLE, "reachable"},
+	{netlink.NUD_STALE, "stale"},
+	{netlink.NUD_DELAY, "delay"},
+	{netlink.NUD_PROBE, "probe"},
+	{netlink.NUD_FAILED, "failed"},
+	{netlink.NUD_NOARP, "noarp"},
+	{netlink.NUD_PERMANENT, "permanent"},
+}
+
+// neighState returns the name of the state of a neighbor cache entry
+func neighState(state int) string {
+	for _, s := range neighStates {
+		if state&s.state != 0 {
+			return s.name
+		}
+	}
+	return "none"
+}
+
+// GetEndpointNeighbors returns the neighbor cache, ARP and ND entries, of the
+// netns of endpoint epID, to debug address resolution. The endpoint must be
+// attached on this host.
+func (p *NetPlugin) GetEndpointNeighbors(epID string) ([]Neighbor, error) {
+	nsPath, err := p.neighborsNetns(epID)
+	if err != nil {
+		return nil, err
+	}
+	return readNeighbors(nsPath)
+}
+
+// neighborsNetns returns the netns of endpoint epID, which must be attached
+// on this host
+func (p *NetPlugin) neighborsNetns(epID string) (string, error) {
+	p.Lock()
+	defer p.Unlock()
+	if p.StateDriver == nil {
+		return "", ErrNotInitialized
+	}
+
+	epCfg := &mastercfg.CfgEndpointState{}
+	epCfg.StateDriver = p.StateDriver
+	if err := epCfg.Read(epID); err != nil {
+		return "", core.Errorf("endpoint %s not found", epID)
+	}
+	if epCfg.HomingHost != p.PluginConfig.Instance.HostLabel {
+		return "", core.Errorf("endpoint %s is not on this host", epID)
+	}
+	if epCfg.Status == mastercfg.EndpointStatusDetached {
+		return "", core.Errorf("attached endpoint %s not found", epID)
+	}
+	return p.endpointNetns(epCfg)
+}
+
+// readNeighbors reads the neighbor cache of the netns at nsPath
+func readNeighbors(nsPath string) ([]Neighbor, error) {
+	ns, err := netns.GetFromPath(nsPath)
+	if err != nil {
+		return nil, core.Errorf("error opening netns %s: %v", nsPath, err)
+	}
+	defer ns.Close()
+	handle, err := netlink.NewHandleAt(ns)
+	if err != nil {
+		return nil, core.Errorf("error opening netlink in netns %s: %v", nsPath, err)
+	}
+	defer handle.Delete()
+
+	neighs, err := handle.NeighList(0, netlink.FAMILY_ALL)
+	if err != nil {
+		return nil, core.Errorf("error listing the neighbors in netns %s: %v", nsPath, err)
+	}
+
+	intfNames := map[int]string{}
+	neighbors := []Neighbor{}
+	for _, neigh := range neighs {
+		name, ok := intfNames[neigh.LinkIndex]
+		if !ok {
+			if link, err := handle.LinkByIndex(neigh.LinkIndex); err == nil {
+				name = link.Attrs().Name
+			}
+			intfNames[neigh.LinkIndex] = name
+		}
+		neighbor := Neighbor{
+			IP:        neigh.IP.String(),
+			Interface: name,
+			State:     neighState(neigh.State),
+		}
+		if len(neigh.HardwareAddr) != 0 {
+			neighbor.Mac = neigh.HardwareAddr.String()
+		}
+		neighbors = append(neighbors, neighbor)
+	}
+	return neighbors, nil
+}
@@ -0,0 +1,235 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+)
+
+const (
+	maxFlowTable    = 254
+	maxFlowPriority = 65535
+)
+
+var flowNameRe = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+
+// fields of a flow set by the driver or by the FlowSpec, not in its match
+// or actions. The driver matches the network of the flow, its match can not
+// pick another port, vlan or metadata.
+var reservedFlowFields = []string{"cookie", "table", "priority", "actions",
+	"in_port", "dl_vlan", "vlan_vid", "vlan_tci", "dl_vlan_pcp", "vlan_pcp", "metadata"}
+
+// FlowSpec is a custom OpenFlow rule of a network. Match and Actions are in
+// the ovs-ofctl syntax, e.g. "ip,nw_dst=10.1.1.0/24" and "drop".
+type FlowSpec struct {
+	Name     string `json:"name"` // unique in the network
+	Table    int    `json:"table"`
+	Priority int    `json:"priority"`
+	Match    string `json:"match"` // matches all the packets when empty
+	Actions  string `json:"actions"`
+}
+
+// networkFlowProgrammer is implemented by network drivers that can program
+// custom flows on the bridge of a network. Adding a flow again replaces it.
+type networkFlowProgrammer interface {
+	AddNetworkFlow(flow *mastercfg.NetworkFlowState) error
+	DeleteNetworkFlow(flow *mastercfg.NetworkFlowState) error
+}
+
+// flowProgrammer returns the network driver as a networkFlowProgrammer
+func (p *NetPlugin) flowProgrammer() (networkFlowProgrammer, error) {
+	if p.NetworkDriver == nil {
+		return nil, p.driverErr()
+	}
+	driver, ok := p.NetworkDriver.(networkFlowProgrammer)
+	if !ok {
+		return nil, core.Errorf("network driver does not support custom flows")
+	}
+	return driver, nil
+}
+
+// validateFlowSpec checks a custom flow before it is programmed
+func validateFlowSpec(flow FlowSpec) error {
+	if !flowNameRe.MatchString(flow.Name) {
+		return core.Errorf("invalid flow name %q", flow.Name)
+	}
+	if flow.Table < 0 || flow.Table > maxFlowTable {
+		return core.Errorf("invalid table %d of flow %s", flow.Table, flow.Name)
+	}
+	if flow.Priority < 0 || flow.Priority > maxFlowPriority {
+		return core.Errorf("invalid priority %d of flow %s", flow.Priority, flow.Name)
+	}
+	if strings.TrimSpace(flow.Actions) == "" {
+		return core.Errorf("flow %s has no actions", flow.Name)
+	}
+	for _, part := range []string{flow.Match, flow.Actions} {
+		if strings.ContainsAny(part, "\n\r;") {
+			return core.Errorf("invalid characters in flow %s", flow.Name)
+		}
+	}
+	for _, field := range strings.Split(flow.Match, ",") {
+		key := strings.TrimSpace(strings.SplitN(field, "=", 2)[0])
+		for _, reserved := range reservedFlowFields {
+			if key == reserved {
+				return core.Errorf("flow %s can not set %s in its match", flow.Name, reserved)
+			}
+		}
+	}
+	return nil
+}
+
+// AddNetworkFlow programs custom flow on the bridge of network networkID on
+// this host. The flow is recorded in the state store and programmed again
+// when the network is resynced. Flows only match the frames of their
+// network, are tagged apart from the ones netplugin manages, and are not
+// programmed over a flow of the same table and priority whose match
+// overlaps. Adding a flow of an existing name replaces it.
+func (p *NetPlugin) AddNetworkFlow(networkID string, flow FlowSpec) error {
+	if err := p.rateLimit(); err != nil {
+		return err
+	}
+	p.Lock()
+	defer p.Unlock()
+	driver, err := p.flowProgrammer()
+	if err != nil {
+		return err
+	}
+	if p.StateDriver == nil {
+		return ErrNotInitialized
+	}
+	if err := validateFlowSpec(flow); err != nil {
+		return err
+	}
+
+	nwCfg := &mastercfg.CfgNetworkState{}
+	nwCfg.StateDriver = p.StateDriver
+	if err := nwCfg.Read(networkID); err != nil {
+		return err
+	}
+
+	hostLabel := p.PluginConfig.Instance.HostLabel
+	flowCfg := &mastercfg.NetworkFlowState{
+		Host:     hostLabel,
+		NetID:    networkID,
+		Name:     flow.Name,
+		Table:    flow.Table,
+		Priority: flow.Priority,
+		Match:    strings.TrimSpace(flow.Match),
+		Actions:  strings.TrimSpace(flow.Actions),
+	}
+	flowCfg.StateDriver = p.StateDriver
+	flowCfg.ID = mastercfg.NetworkFlowID(hostLabel, networkID, flow.Name)
+	if err := driver.AddNetworkFlow(flowCfg); err != nil {
+		logrus.Errorf("Error adding flow %s of network %s. Err: %v", flow.Name, networkID, err)
+		return err
+	}
+	return flowCfg.Write()
+}
+
+// RemoveNetworkFlow removes custom flow name of network networkID on this
+// host
+func (p *NetPlugin) RemoveNetworkFlow(networkID, name string) error {
+	if err := p.rateLimit(); err != nil {
+		return err
+	}
+	p.Lock()
+	defer p.Unlock()
+	driver, err := p.flowProgrammer()
+	if err != nil {
+		return err
+	}
+	if p.StateDriver == nil {
+		return ErrNotInitialized
+	}
+
+	flowCfg := &mastercfg.NetworkFlowState{}
+	flowCfg.StateDriver = p.StateDriver
+	if err := flowCfg.Read(mastercfg.NetworkFlowID(p.PluginConfig.Instance.HostLabel, networkID, name)); err != nil {
+		return core.Errorf("network %s has no flow %s", networkID, name)
+	}
+	return p.removeNetworkFlow(driver, flowCfg)
+}
+
+func (p *NetPlugin) removeNetworkFlow(driver networkFlowProgrammer, flowCfg *mastercfg.NetworkFlowState) error {
+	if err := driver.DeleteNetworkFlow(flowCfg); err != nil {
+		logrus.Errorf("Error deleting flow %s of network %s. Err: %v", flowCfg.Name, flowCfg.NetID, err)
+		return err
+	}
+	return flowCfg.Clear()
+}
+
+// networkFlows returns the custom flows of network netID on this host
+func (p *NetPlugin) networkFlows(netID string) ([]*mastercfg.NetworkFlowState, error) {
+	flowCfg := &mastercfg.NetworkFlowState{}
+	flowCfg.StateDriver = p.StateDriver
+	flowCfgs, err := flowCfg.ReadAll()
+	if core.ErrIfKeyExists(err) != nil {
+		return nil, err
+	}
+
+	flows := []*mastercfg.NetworkFlowState{}
+	for _, state := range flowCfgs {
+		flow := state.(*mastercfg.NetworkFlowState)
+		if flow.Host == p.PluginConfig.Instance.HostLabel && flow.NetID == netID {
+			flows = append(flows, flow)
+		}
+	}
+	return flows, nil
+}
+
+// reprogramNetworkFlows programs the custom flows of network netID on this
+// host again
+func (p *NetPlugin) reprogramNetworkFlows(netID string) error {
+	driver, ok := p.NetworkDriver.(networkFlowProgrammer)
+	if !ok {
+		return nil
+	}
+	flows, err := p.networkFlows(netID)
+	if err != nil {
+		return err
+	}
+	for _, flow := range flows {
+		if err := driver.AddNetworkFlow(flow); err != nil {
+			logrus.Errorf("Error reprogramming flow %s of network %s. Err: %v", flow.Name, netID, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// removeNetworkFlows removes the custom flows of network netID on this
+// host, before the network is deleted
+func (p *NetPlugin) removeNetworkFlows(netID string) error {
+	driver, ok := p.NetworkDriver.(networkFlowProgrammer)
+	if !ok || p.StateDriver == nil {
+		return nil
+	}
+	flows, err := p.networkFlows(netID)
+	if err != nil {
+		return err
+	}
+	for _, flow := range flows {
+		if err := p.removeNetworkFlow(driver, flow); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -16,13 +16,21 @@ limitations under the License.
 package plugin
 
 import (
+	"errors"
 	"fmt"
 	"github.com/Sirupsen/logrus"
 	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/drivers"
+	"github.com/contiv/netplugin/netmaster/intent"
+	"github.com/contiv/netplugin/netmaster/master"
 	"github.com/contiv/netplugin/netmaster/mastercfg"
 	"github.com/contiv/netplugin/utils"
 	"github.com/contiv/netplugin/utils/netutils"
+	"os"
+	"os/signal"
+	"sort"
 	"sync"
+	"syscall"
 	"time"
 )
 
@@ -33,46 +41,217 @@ type Drivers struct {
 	Network  string `json:"network"`
 	Endpoint string `json:"endpoint"`
 	State    string `json:"state"`
+	// EndpointDrivers are network drivers endpoints can select by name
+	// instead of the network driver
+	EndpointDrivers []string `json:"endpoint-drivers"`
 }
 
 // Config has the configuration for the plugin
 type Config struct {
 	Drivers  Drivers           `json:"drivers"`
 	Instance core.InstanceInfo `json:"plugin-instance"`
+	// Observer only initializes the state driver, the plugin reports state
+	// but never programs the dataplane
+	Observer bool `json:"observer"`
+	// ConfigFromStore completes this config with the one stored at
+	// ConfigKey and reloads the plugin when the stored config changes
+	ConfigFromStore bool `json:"config-from-store"`
+	// LooseConfig ignores the unknown fields of the stored config instead
+	// of rejecting it
+	LooseConfig bool `json:"loose-config"`
+	// RequireContainerRuntime fails Init when the container runtime can not
+	// be reached. Otherwise the plugin runs without it and only the calls
+	// that need it fail.
+	RequireContainerRuntime bool `json:"require-container-runtime"`
+	// RateLimit limits the rate of network and endpoint creates and deletes
+	RateLimit RateLimitConfig `json:"rate-limit"`
+	// MonitorLinks tracks the link of endpoint ports in the endpoint
+	// status and reports link changes to the link hooks
+	MonitorLinks bool `json:"monitor-links"`
+	// ClearStaleBindings makes ReconcileContainerBindings clear the
+	// bindings to missing containers instead of rebinding them by name
+	ClearStaleBindings bool `json:"clear-stale-bindings"`
+	// Features turns feature flags on or off, see FeatureEnabled
+	Features map[string]bool `json:"features"`
+}
+
+// ErrNotInitialized is returned when a NetPlugin method is called before Init
+// succeeded, or after Deinit
+var ErrNotInitialized = errors.New("netplugin is not initialized")
+
+// ErrContainerRuntimeUnavailable is returned by methods that need the
+// container runtime when it can not be reached
+var ErrContainerRuntimeUnavailable = errors.New("container driver unavailable")
+
+// ErrObserverMode is returned by methods that would program the dataplane
+// when the plugin runs in observer mode
+var ErrObserverMode = errors.New("netplugin is in observer mode, dataplane changes are not allowed")
+
+// NetworkSpec describes a network to be created in a single call
+type NetworkSpec struct {
+	Tenant         string
+	Name           string
+	NwType         string // "data" or "infra"
+	Encap          string // "vlan" or "vxlan"
+	PktTag         int    // vlan or vxlan id, allocated when zero
+	SubnetCIDR     string
+	Gateway        string
+	IPv6SubnetCIDR string
+	IPv6Gateway    string
+	AutoCleanEps   bool // delete endpoints when their container dies
+	DupAddrDetect  string
+	DupAddrTimeout int // seconds
+	IPQuarantine   int // seconds a released address is not reallocated
+	MTU            int // endpoint mtu, e.g. 9000 for jumbo frames
+	Encrypt        bool
+	// GatewayArpProxy has the hosts answer arp for a virtual gateway, with
+	// GatewayMac when set
+	GatewayArpProxy bool
+	GatewayMac      string
+	// BUMMode is how broadcast, multicast and unknown unicast traffic is
+	// handled: flood, the default, drop or controller
+	BUMMode string
+	// Vrf is the linux vrf the network's host interfaces are enslaved to,
+	// created with table VrfTable when missing
+	Vrf      string
+	VrfTable int
+}
+
+// flowDumper is implemented by network drivers that can report the flows
+// they programmed
+type flowDumper interface {
+	DumpNetworkFlows(networkID string) ([]string, error)
+}
+
+// endpointStatsReader is implemented by network drivers that can report the
+// interface counters of a single endpoint
+type endpointStatsReader interface {
+	GetEndpointIntfStats(id string) (*drivers.EndpointStats, error)
+}
+
+// dataplaneReporter is implemented by network drivers that can locate the
+// datapath port of an endpoint
+type dataplaneReporter interface {
+	GetEndpointDataplaneInfo(id string) (*drivers.DataplaneInfo, error)
+}
+
+// endpointBinder is implemented by network drivers that label the datapath
+// ports of endpoints with their network and container
+type endpointBinder interface {
+	UpdateEndpointBinding(id string) error
 }
 
 // NetPlugin is the configuration struct for the plugin bus. Network and
 // Endpoint drivers are all present in `drivers/` and state drivers are present
 // in `state/`.
 type NetPlugin struct {
-	sync.Mutex
+	sync.RWMutex
 	ConfigFile    string
 	NetworkDriver core.NetworkDriver
 	StateDriver   core.StateDriver
 	PluginConfig  Config
+	// Registry holds the drivers available to this instance. When left
+	// unset, Init populates it with the built-in drivers and the process
+	// wide state driver is used.
+	Registry *utils.DriverRegistry
+	// ContainerRuntime resolves pod sandboxes for AttachPodEndpoint. When
+	// left unset, it is created from Instance.ContainerRuntime on first use.
+	ContainerRuntime utils.ContainerRuntime
+	runtimeReady     bool // ContainerRuntime answered its last check
+
+	// endpointDrivers are the drivers of Drivers.EndpointDrivers by name,
+	// epDrivers the ones that created endpoints by endpoint id
+	endpointDrivers map[string]core.NetworkDriver
+	epDrivers       map[string]string
+
+	// epLocks serializes the operations on each endpoint, see
+	// endpointLocks. epMutex guards epDrivers and leases, which endpoint
+	// operations update under the read lock of the plugin.
+	epLocks endpointLocks
+	epMutex sync.Mutex
+
+	limiter *tokenBucket // nil when RateLimit is unlimited
+
+	preAttachHooks  []attachHook
+	postAttachHooks []attachHook
+	linkHooks       []linkHook
+
+	linkUpdates     chan linkUpdate // nil when the link monitor is stopped
+	linkMonitorDone chan struct{}
+
+	leases map[string]chan struct{} // keepalives of the local endpoint leases
+
+	// bootstrap is the local config the stored config is merged into
+	bootstrap Config
+
+	features    map[string]bool // feature flags set by the config
+	featureLock sync.RWMutex
+
+	// releaseState releases the state driver this instance acquired, nil
+	// when it uses a state driver it did not create
+	releaseState func()
 }
 
 // Init initializes the NetPlugin instance via the configuration string passed.
 func (p *NetPlugin) Init(pluginConfig Config) error {
+	return p.init(pluginConfig, nil)
+}
+
+// init initializes the state driver, then calls load, when set, to complete
+// the config before initializing the network driver
+func (p *NetPlugin) init(pluginConfig Config, load func(Config) (Config, error)) error {
 	var err error
 	if pluginConfig.Instance.HostLabel == "" {
 		return core.Errorf("empty host-label passed")
 	}
 
-	// initialize state driver
-	p.StateDriver, err = utils.GetStateDriver()
-	if err != nil {
-		p.StateDriver, err = utils.NewStateDriver(pluginConfig.Drivers.State, &pluginConfig.Instance)
+	// initialize state driver, an instance with its own registry also gets
+	// its own state driver
+	p.releaseState = nil
+	if p.Registry != nil {
+		p.StateDriver, err = p.Registry.NewStateDriver(pluginConfig.Drivers.State, &pluginConfig.Instance)
 		if err != nil {
 			return err
 		}
+		p.releaseState = p.StateDriver.Deinit
+	} else {
+		p.Registry = utils.NewDriverRegistry()
+		p.StateDriver, err = utils.GetStateDriver()
+		if err != nil {
+			p.StateDriver, err = utils.NewStateDriver(pluginConfig.Drivers.State, &pluginConfig.Instance)
+			if err != nil {
+				return err
+			}
+			p.releaseState = utils.ReleaseStateDriver
+		}
 	}
 	defer func() {
-		if err != nil {
-			utils.ReleaseStateDriver()
+		if err != nil && p.releaseState != nil {
+			p.releaseState()
+			p.releaseState = nil
 		}
 	}()
 
+	if load != nil {
+		pluginConfig, err = load(pluginConfig)
+		if err != nil {
+			return err
+		}
+	}
+
+	limiter, err := newTokenBucket(pluginConfig.RateLimit)
+	if err != nil {
+		return err
+	}
+	p.limiter = limiter
+	p.setFeatures(pluginConfig.Features)
+
+	if pluginConfig.Observer {
+		logrus.Infof("Running in observer mode, skipping network driver initialization")
+		p.PluginConfig = pluginConfig
+		return nil
+	}
+
 	// set state driver in instance info
 	pluginConfig.Instance.StateDriver = p.StateDriver
 	err = InitGlobalSettings(p.StateDriver, &pluginConfig.Instance)
@@ -81,7 +260,7 @@ func (p *NetPlugin) Init(pluginConfig Config) error {
 	}
 
 	// initialize network driver
-	p.NetworkDriver, err = utils.NewNetworkDriver(pluginConfig.Drivers.Network, &pluginConfig.Instance)
+	p.NetworkDriver, err = p.Registry.NewNetworkDriver(pluginConfig.Drivers.Network, &pluginConfig.Instance)
 	if err != nil {
 		return err
 	}
@@ -93,10 +272,42 @@ func (p *NetPlugin) Init(pluginConfig Config) error {
 		}
 	}()
 
+	if err = p.initEndpointDrivers(&pluginConfig); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			p.deinitEndpointDrivers()
+		}
+	}()
+
+	// state and network operations do not need the container runtime
+	if _, err = p.containerRuntime(); err == ErrContainerRuntimeUnavailable {
+		if pluginConfig.RequireContainerRuntime {
+			return err
+		}
+		logrus.Warnf("Continuing without the container runtime, pod attach is unavailable")
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+
+	p.startLinkMonitor()
 	return nil
 }
 
-// Deinit is a destructor for the NetPlugin configuration.
+// driverErr returns the error for a call that needs the network driver when
+// there is none
+func (p *NetPlugin) driverErr() error {
+	if p.PluginConfig.Observer {
+		return ErrObserverMode
+	}
+	return ErrNotInitialized
+}
+
+// Deinit is a destructor for the NetPlugin configuration. The state driver
+// is only released when this instance created it.
 func (p *NetPlugin) Deinit() {
 	p.Lock()
 	defer p.Unlock()
@@ -105,89 +316,845 @@ func (p *NetPlugin) Deinit() {
 		p.NetworkDriver.Deinit()
 		p.NetworkDriver = nil
 	}
-	if p.StateDriver != nil {
-		utils.ReleaseStateDriver()
-		p.StateDriver = nil
+	p.stopLinkMonitor()
+	p.stopLeases()
+	p.deinitEndpointDrivers()
+	if p.releaseState != nil {
+		p.releaseState()
+		p.releaseState = nil
+	}
+	p.StateDriver = nil
+}
+
+// RunUntilSignal blocks until one of sigs is received, SIGINT or SIGTERM
+// when none are given, and then deinitializes the plugin. Signal delivery is
+// restored to its previous handling before returning, so callers managing
+// their own signals are not affected outside of this call.
+func (p *NetPlugin) RunUntilSignal(sigs ...os.Signal) error {
+	if len(sigs) == 0 {
+		sigs = []os.Signal{syscall.SIGINT, syscall.SIGTERM}
 	}
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, sigs...)
+	defer signal.Stop(sigCh)
+
+	sig := <-sigCh
+	logrus.Infof("Received signal %v, shutting down", sig)
+	p.Deinit()
+
+	return nil
 }
 
 // CreateNetwork creates a network for a given ID.
 func (p *NetPlugin) CreateNetwork(id string) error {
+	if err := p.rateLimit(); err != nil {
+		return err
+	}
 	p.Lock()
 	defer p.Unlock()
-	return p.NetworkDriver.CreateNetwork(id)
+	if p.NetworkDriver == nil {
+		return p.driverErr()
+	}
+	return p.createNetwork(id)
+}
+
+// CreateNetworkSpec writes the state of a network from spec and creates the
+// network, without the state having to be written beforehand.
+func (p *NetPlugin) CreateNetworkSpec(spec NetworkSpec) error {
+	if err := p.rateLimit(); err != nil {
+		return err
+	}
+	p.Lock()
+	defer p.Unlock()
+	if p.StateDriver == nil {
+		return ErrNotInitialized
+	}
+	if p.NetworkDriver == nil {
+		return p.driverErr()
+	}
+
+	if spec.Tenant == "" || spec.Name == "" {
+		return core.Errorf("network name and tenant are required")
+	}
+	if spec.Encap != "vlan" && spec.Encap != "vxlan" {
+		return core.Errorf("invalid encap %q, must be vlan or vxlan", spec.Encap)
+	}
+	if spec.Encrypt {
+		if err := p.checkFeature(FeatureNetworkEncryption); err != nil {
+			return err
+		}
+	}
+	if spec.GatewayArpProxy {
+		if err := p.checkFeature(FeatureGatewayArpProxy); err != nil {
+			return err
+		}
+	}
+
+	network := intent.ConfigNetwork{
+		Name:           spec.Name,
+		NwType:         spec.NwType,
+		PktTagType:     spec.Encap,
+		PktTag:         spec.PktTag,
+		SubnetCIDR:     spec.SubnetCIDR,
+		Gateway:        spec.Gateway,
+		IPv6SubnetCIDR: spec.IPv6SubnetCIDR,
+		IPv6Gateway:    spec.IPv6Gateway,
+
+		AutoCleanEndpoints: spec.AutoCleanEps,
+		DupAddrDetect:      spec.DupAddrDetect,
+		DupAddrTimeout:     spec.DupAddrTimeout,
+		IPQuarantine:       spec.IPQuarantine,
+		MTU:                spec.MTU,
+		Encrypt:            spec.Encrypt,
+		GatewayArpProxy:    spec.GatewayArpProxy,
+		GatewayMac:         spec.GatewayMac,
+		BUMMode:            spec.BUMMode,
+		Vrf:                spec.Vrf,
+		VrfTable:           spec.VrfTable,
+	}
+	// the state of a network that already exists is kept on failures
+	networkID := spec.Name + "." + spec.Tenant
+	nwCfg := &mastercfg.CfgNetworkState{}
+	nwCfg.StateDriver = p.StateDriver
+	existed := nwCfg.Read(networkID) == nil
+
+	err := master.CreateNetwork(network, p.StateDriver, spec.Tenant)
+	if err != nil {
+		logrus.Errorf("Error writing state for network %s.%s. Err: %v", spec.Name, spec.Tenant, err)
+		return err
+	}
+
+	err = p.createNetwork(networkID)
+	if err != nil {
+		if existed {
+			return err
+		}
+		if delErr := master.ForceDeleteNetworkID(p.StateDriver, networkID); delErr != nil {
+			logrus.Errorf("Error removing state for network %s. Err: %v", networkID, delErr)
+		}
+		return err
+	}
+
+	return nil
 }
 
 // DeleteNetwork deletes a network provided by the ID.
 func (p *NetPlugin) DeleteNetwork(id, subnet, nwType, encap string, pktTag, extPktTag int, Gw string, tenant string) error {
+	if err := p.rateLimit(); err != nil {
+		return err
+	}
 	p.Lock()
 	defer p.Unlock()
-	return p.NetworkDriver.DeleteNetwork(id, subnet, nwType, encap, pktTag, extPktTag, Gw, tenant)
+	if p.NetworkDriver == nil {
+		return p.driverErr()
+	}
+	if err := p.disconnectNetwork(id); err != nil {
+		logrus.Errorf("Error removing the interconnects of network %s. Err: %v", id, err)
+	}
+	if err := p.removeNetworkFlows(id); err != nil {
+		logrus.Errorf("Error removing the custom flows of network %s. Err: %v", id, err)
+	}
+	if err := p.removeVxlanGateways(id); err != nil {
+		logrus.Errorf("Error removing the vxlan gateway of network %s. Err: %v", id, err)
+	}
+	drivers := p.networkDrivers()
+	for i := len(drivers) - 1; i >= 0; i-- {
+		err := drivers[i].DeleteNetwork(id, subnet, nwType, encap, pktTag, extPktTag, Gw, tenant)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-// FetchNetwork retrieves a network's state given an ID.
+// FetchNetwork retrieves a network's state given an ID, including the
+// bridge options of the network
 func (p *NetPlugin) FetchNetwork(id string) (core.State, error) {
-	return nil, core.Errorf("Not implemented")
+	p.Lock()
+	defer p.Unlock()
+	if p.StateDriver == nil {
+		return nil, ErrNotInitialized
+	}
+
+	nwCfg := &mastercfg.CfgNetworkState{}
+	nwCfg.StateDriver = p.StateDriver
+	if err := nwCfg.Read(id); err != nil {
+		return nil, err
+	}
+
+	return nwCfg, nil
+}
+
+// CreateEndpointResult describes the endpoint created by CreateEndpointEx
+type CreateEndpointResult struct {
+	IPAddress   string `json:"ipAddress"`
+	IPv6Address string `json:"ipv6Address"`
+	MacAddress  string `json:"macAddress"`
+	IntfName    string `json:"intfName"` // interface created by the driver
+	Gateway     string `json:"gateway"`
+	IPv6Gateway string `json:"ipv6Gateway"`
+	// RateLimiter enforces the rate limit of the endpoint, meter or
+	// policing, when the driver reports it
+	RateLimiter string `json:"rateLimiter"`
 }
 
-// CreateEndpoint creates an endpoint for a given ID.
+// CreateEndpoint creates an endpoint for a given ID. Like the delete, attach
+// and detach of endpoints, it runs in parallel with the operations on other
+// endpoints and one at a time with those on the same endpoint.
 func (p *NetPlugin) CreateEndpoint(id string) error {
-	p.Lock()
-	defer p.Unlock()
-	return p.NetworkDriver.CreateEndpoint(id)
+	_, err := p.CreateEndpointEx(id)
+	return err
+}
+
+// CreateEndpointEx creates an endpoint like CreateEndpoint and returns its
+// addresses, interface and gateways. The result is read back from the state
+// once the endpoint is created, the fields that can not be read are left
+// empty. Detached endpoints are left to AttachEndpoint, and endpoints with
+// settings only applied on attach are refused until they were attached.
+func (p *NetPlugin) CreateEndpointEx(id string) (CreateEndpointResult, error) {
+	if err := p.rateLimit(); err != nil {
+		return CreateEndpointResult{}, err
+	}
+	defer p.lockEndpoint(id)()
+	if p.NetworkDriver == nil {
+		return CreateEndpointResult{}, p.driverErr()
+	}
+	if p.StateDriver != nil {
+		epCfg := &mastercfg.CfgEndpointState{}
+		epCfg.StateDriver = p.StateDriver
+		if err := epCfg.Read(id); err == nil {
+			if epCfg.Status == mastercfg.EndpointStatusDetached {
+				logrus.Infof("Endpoint %s is detached, it is wired by AttachEndpoint", id)
+				return p.endpointResult(id), nil
+			}
+			if epCfg.Status == "" && hasAttachSettings(epCfg) {
+				return CreateEndpointResult{}, core.Errorf("endpoint %s has settings applied on attach, it is wired by AttachEndpoint", id)
+			}
+		}
+	}
+	driver, err := p.endpointDriver(id)
+	if err != nil {
+		return CreateEndpointResult{}, err
+	}
+	if err := p.checkQoSSupport(driver, id); err != nil {
+		return CreateEndpointResult{}, err
+	}
+	if err := driver.CreateEndpoint(id); err != nil {
+		return CreateEndpointResult{}, err
+	}
+	p.endpointCreated(id, driver)
+	p.rebuildMirrors(driver, id)
+	p.startEndpointLease(id)
+	return p.endpointResult(id), nil
+}
+
+// endpointResult reads the result of the create of endpoint id from its
+// config, the config of its network and its oper state
+func (p *NetPlugin) endpointResult(id string) CreateEndpointResult {
+	result := CreateEndpointResult{}
+	if p.StateDriver == nil {
+		return result
+	}
+
+	epCfg := &mastercfg.CfgEndpointState{}
+	epCfg.StateDriver = p.StateDriver
+	if err := epCfg.Read(id); err != nil {
+		logrus.Warnf("Error reading the config of created endpoint %s. Err: %v", id, err)
+		return result
+	}
+	result.IPAddress = epCfg.IPAddress
+	result.IPv6Address = epCfg.IPv6Address
+	result.MacAddress = epCfg.MacAddress
+	result.IntfName = epCfg.IntfName
+
+	// drivers that create the interface record it in the oper state
+	epOper := &drivers.OperEndpointState{}
+	epOper.StateDriver = p.StateDriver
+	if err := epOper.Read(id); err == nil {
+		if epOper.PortName != "" {
+			result.IntfName = epOper.PortName
+		}
+		result.RateLimiter = epOper.RateLimiter
+	}
+
+	nwCfg := &mastercfg.CfgNetworkState{}
+	nwCfg.StateDriver = p.StateDriver
+	if err := nwCfg.Read(epCfg.NetID); err != nil {
+		logrus.Warnf("Error reading network %s of created endpoint %s. Err: %v", epCfg.NetID, id, err)
+		return result
+	}
+	result.Gateway = nwCfg.Gateway
+	result.IPv6Gateway = nwCfg.IPv6Gateway
+	return result
+}
+
+// checkQoSSupport fails if the endpoint asks for traffic marking that its
+// driver does not advertise
+func (p *NetPlugin) checkQoSSupport(driver core.NetworkDriver, id string) error {
+	if p.StateDriver == nil {
+		return nil
+	}
+
+	epCfg := &mastercfg.CfgEndpointState{}
+	epCfg.StateDriver = p.StateDriver
+	if err := epCfg.Read(id); err != nil {
+		// let the driver report missing state
+		return nil
+	}
+	if epCfg.DSCP == 0 && epCfg.CoS == 0 {
+		return nil
+	}
+
+	marker, ok := driver.(core.QoSMarker)
+	if epCfg.DSCP != 0 && (!ok || !marker.SupportsDSCPMarking()) {
+		return core.Errorf("network driver does not support DSCP marking")
+	}
+	if epCfg.CoS != 0 && (!ok || !marker.SupportsCoSMarking()) {
+		return core.Errorf("network driver does not support CoS marking")
+	}
+	return nil
+}
+
+// checkBUMSupport fails if the network asks for a bum mode other than
+// flooding that the driver does not advertise
+func (p *NetPlugin) checkBUMSupport(driver core.NetworkDriver, id string) error {
+	if p.StateDriver == nil {
+		return nil
+	}
+
+	nwCfg := &mastercfg.CfgNetworkState{}
+	nwCfg.StateDriver = p.StateDriver
+	if err := nwCfg.Read(id); err != nil {
+		// let the driver report missing state
+		return nil
+	}
+	if nwCfg.BUMMode == "" || nwCfg.BUMMode == mastercfg.BUMModeFlood {
+		return nil
+	}
+
+	handler, ok := driver.(core.BUMHandler)
+	if !ok || !handler.SupportsBUMMode(nwCfg.BUMMode) {
+		return core.Errorf("network driver does not support bum mode %s", nwCfg.BUMMode)
+	}
+	return nil
 }
 
 //UpdateEndpointGroup updates the endpoint with the new endpointgroup specification for the given ID.
 func (p *NetPlugin) UpdateEndpointGroup(id string) error {
 	p.Lock()
 	defer p.Unlock()
-	return p.NetworkDriver.UpdateEndpointGroup(id)
+	if p.NetworkDriver == nil {
+		return p.driverErr()
+	}
+	driver, err := p.endpointDriver(id)
+	if err != nil {
+		return err
+	}
+	return driver.UpdateEndpointGroup(id)
 }
 
 // DeleteEndpoint destroys an endpoint for an ID.
 func (p *NetPlugin) DeleteEndpoint(id string) error {
+	if err := p.rateLimit(); err != nil {
+		return err
+	}
+	defer p.lockEndpoint(id)()
+	if p.NetworkDriver == nil {
+		return p.driverErr()
+	}
+	driver, err := p.endpointDriver(id)
+	if err != nil {
+		return err
+	}
+	if p.StateDriver != nil {
+		epCfg := &mastercfg.CfgEndpointState{}
+		epCfg.StateDriver = p.StateDriver
+		if err := epCfg.Read(id); err == nil {
+			removeConntrackExemptions(driver, epCfg)
+		}
+	}
+	p.removeMirrors(driver, id)
+	if err := driver.DeleteEndpoint(id); err != nil {
+		return err
+	}
+	p.endpointDeleted(id)
+	p.stopLease(id)
+	return nil
+}
+
+// DetachEndpoint removes the datapath wiring of an endpoint but keeps its
+// config state, including the IP and MAC allocation, so that the endpoint
+// can be attached again with AttachEndpoint.
+func (p *NetPlugin) DetachEndpoint(id string) error {
+	if err := p.rateLimit(); err != nil {
+		return err
+	}
+	defer p.lockEndpoint(id)()
+	if p.NetworkDriver == nil {
+		return p.driverErr()
+	}
+	if p.StateDriver == nil {
+		return ErrNotInitialized
+	}
+
+	epCfg := &mastercfg.CfgEndpointState{}
+	epCfg.StateDriver = p.StateDriver
+	if err := epCfg.Read(id); err != nil {
+		return err
+	}
+	if epCfg.Status == mastercfg.EndpointStatusDetached {
+		return nil
+	}
+
+	driver, err := p.driverByName(epCfg.Driver)
+	if err != nil {
+		return err
+	}
+	removeConntrackExemptions(driver, epCfg)
+	clearIntfFlags(epCfg)
+	if err := driver.DeleteEndpoint(id); err != nil {
+		logrus.Errorf("Error detaching endpoint %s. Err: %v", id, err)
+		return err
+	}
+
+	epCfg.Status = mastercfg.EndpointStatusDetached
+	epCfg.NetnsPath = ""
+	return epCfg.Write()
+}
+
+// AttachEndpoint wires up a detached endpoint, one detached by
+// DetachEndpoint or created detached by CreateEndpointFromSpec, reusing its
+// IP and MAC allocation. It is the only path applying the settings checked
+// by hasAttachSettings, endpoints that are not detached are refused.
+// nsPath is the container network
+// namespace where the address of the endpoint is probed, its interface
+// settings and routes are applied, and which is handed to the attach hooks.
+// It may be empty. The promisc and allmulti flags of the endpoint are set
+// on its interface there and the flags in effect are reported by
+// FetchEndpoint. Drivers that move the interface to the netns get the
+// IntfName of the endpoint when it is free there, or the first free netN
+// name when it is empty; the name is recorded in the endpoint config the
+// hooks get. The address probe, settings and routes of the interface run
+// once it is moved, they are refused for drivers that do not move it.
+func (p *NetPlugin) AttachEndpoint(id, nsPath string) error {
+	if err := p.rateLimit(); err != nil {
+		return err
+	}
+	defer p.lockEndpoint(id)()
+	return p.attachEndpoint(id, nsPath)
+}
+
+// AttachPodEndpoint attaches an endpoint like AttachEndpoint, in the netns of
+// a Kubernetes pod. podID is the id of the pod sandbox or of any container of
+// the pod; app containers share the netns of the sandbox.
+func (p *NetPlugin) AttachPodEndpoint(id, podID string) error {
+	if err := p.rateLimit(); err != nil {
+		return err
+	}
 	p.Lock()
 	defer p.Unlock()
-	return p.NetworkDriver.DeleteEndpoint(id)
+	if p.NetworkDriver == nil {
+		return p.driverErr()
+	}
+
+	runtime, err := p.containerRuntime()
+	if err != nil {
+		return err
+	}
+
+	nsPath, err := runtime.GetSandboxNetns(podID)
+	if err != nil {
+		logrus.Errorf("Error resolving the sandbox netns of pod %s. Err: %v", podID, err)
+		// the runtime may have gone away, check it again on the next call
+		p.runtimeReady = false
+		return err
+	}
+	return p.attachEndpoint(id, nsPath)
+}
+
+// AttachEndpointToContainer attaches an endpoint like AttachEndpoint, in the
+// netns of the running container called contName, for sidecars joining the
+// network of another container. The interface gets a name free in the shared
+// netns. DetachEndpoint only removes that interface, the netns and the other
+// interfaces of the container are left alone.
+func (p *NetPlugin) AttachEndpointToContainer(id, contName string) error {
+	if err := p.rateLimit(); err != nil {
+		return err
+	}
+	p.Lock()
+	defer p.Unlock()
+	if p.NetworkDriver == nil {
+		return p.driverErr()
+	}
+
+	runtime, err := p.containerRuntime()
+	if err != nil {
+		return err
+	}
+	lookup, ok := runtime.(utils.ContainerLookup)
+	if !ok {
+		return core.Errorf("container runtime %q can not look up containers by name",
+			p.PluginConfig.Instance.ContainerRuntime)
+	}
+
+	contID, err := lookup.ContainerIDByName(contName)
+	if err != nil {
+		logrus.Errorf("Error looking up container %s. Err: %v", contName, err)
+		p.runtimeReady = false
+		return err
+	}
+	if contID == "" {
+		return core.Errorf("container %s not found", contName)
+	}
+
+	nsPath, err := runtime.GetSandboxNetns(contID)
+	if err != nil {
+		logrus.Errorf("Error resolving the netns of container %s. Err: %v", contName, err)
+		p.runtimeReady = false
+		return err
+	}
+	return p.attachEndpoint(id, nsPath)
+}
+
+// AttachEndpointToPid attaches an endpoint like AttachEndpoint, in the netns
+// of process pid, for callers that know the process of a container but not
+// its runtime. The process must have a netns of its own.
+func (p *NetPlugin) AttachEndpointToPid(id string, pid int) error {
+	if err := p.rateLimit(); err != nil {
+		return err
+	}
+	defer p.lockEndpoint(id)()
+
+	nsPath, err := utils.ProcessNetns(pid)
+	if err != nil {
+		logrus.Errorf("Error resolving the netns of process %d. Err: %v", pid, err)
+		return err
+	}
+	return p.attachEndpoint(id, nsPath)
+}
+
+// containerRuntime returns the container runtime, checking that it can be
+// reached when it could not the last time. It returns
+// ErrContainerRuntimeUnavailable when it can not.
+func (p *NetPlugin) containerRuntime() (utils.ContainerRuntime, error) {
+	if p.ContainerRuntime == nil {
+		runtime, err := utils.NewContainerRuntime(p.PluginConfig.Instance.ContainerRuntime,
+			p.PluginConfig.Instance.CRIEndpoint)
+		if err != nil {
+			return nil, err
+		}
+		p.ContainerRuntime = runtime
+	}
+
+	if !p.runtimeReady {
+		if err := p.ContainerRuntime.Ping(); err != nil {
+			logrus.Errorf("Container runtime %q is unavailable. Err: %v",
+				p.PluginConfig.Instance.ContainerRuntime, err)
+			return nil, ErrContainerRuntimeUnavailable
+		}
+		p.runtimeReady = true
+	}
+	return p.ContainerRuntime, nil
+}
+
+// hasAttachSettings returns true when endpoint ep has settings that only
+// AttachEndpoint applies: those applied in its netns and the host endpoint
+// setup
+func hasAttachSettings(ep *mastercfg.CfgEndpointState) bool {
+	return len(ep.Routes) > 0 || len(ep.Sysctls) > 0 || len(ep.EthtoolFeatures) > 0 ||
+		ep.Promisc || ep.AllMulti || len(ep.ConntrackExemptions) > 0 || ep.HostEndpoint
+}
+
+func (p *NetPlugin) attachEndpoint(id, nsPath string) error {
+	if p.NetworkDriver == nil {
+		return p.driverErr()
+	}
+	if p.StateDriver == nil {
+		return ErrNotInitialized
+	}
+
+	epCfg := &mastercfg.CfgEndpointState{}
+	epCfg.StateDriver = p.StateDriver
+	if err := epCfg.Read(id); err != nil {
+		return err
+	}
+	if epCfg.Status != mastercfg.EndpointStatusDetached {
+		return core.Errorf("endpoint %s is not detached", id)
+	}
+
+	driver, err := p.driverByName(epCfg.Driver)
+	if err != nil {
+		return err
+	}
+	if err := p.checkQoSSupport(driver, id); err != nil {
+		return err
+	}
+	if epCfg.HostEndpoint && nsPath != "" {
+		return core.Errorf("host endpoint %s stays in the host netns", id)
+	}
+	if epCfg.Bond != nil && nsPath != "" {
+		return core.Errorf("bonded endpoint %s stays in the host netns", id)
+	}
+	if epCfg.Tap && nsPath != "" {
+		return core.Errorf("tap endpoint %s is handed to a hypervisor, not to a netns", id)
+	}
+	if err := checkConntrackExemptions(driver, nsPath, epCfg); err != nil {
+		return err
+	}
+	if err := nameEndpointIntf(driver, nsPath, epCfg); err != nil {
+		return err
+	}
+
+	// the network settings only matter with routes or a netns to set up
+	var nwCfg *mastercfg.CfgNetworkState
+	if len(epCfg.Routes) > 0 || nsPath != "" || epCfg.HostEndpoint {
+		nwCfg = &mastercfg.CfgNetworkState{}
+		nwCfg.StateDriver = p.StateDriver
+		if err := nwCfg.Read(epCfg.NetID); err != nil {
+			return err
+		}
+		if err := checkRouteNextHops(nwCfg, epCfg); err != nil {
+			return err
+		}
+	}
+	if err := checkIntfMoved(driver, nsPath, nwCfg, epCfg); err != nil {
+		return err
+	}
+
+	ns, err := p.openHookNetns(nsPath)
+	if err != nil {
+		return err
+	}
+	defer ns.Close()
+
+	if err := p.runPreAttachHooks(ns, epCfg); err != nil {
+		return err
+	}
+	if err := driver.CreateEndpoint(id); err != nil {
+		logrus.Errorf("Error attaching endpoint %s. Err: %v", id, err)
+		return err
+	}
+	err = moveEndpointIntf(driver, nsPath, epCfg)
+	if err == nil {
+		err = detectDupAddr(nsPath, nwCfg, epCfg)
+	}
+	if err == nil {
+		err = applyIntfSettings(nsPath, epCfg)
+	}
+	if err == nil {
+		var flags []string
+		flags, err = applyIntfFlags(nsPath, epCfg)
+		if err == nil && flags != nil {
+			err = recordIntfFlags(p.StateDriver, id, flags)
+		}
+	}
+	if err == nil {
+		err = applyRoutes(nsPath, epCfg)
+	}
+	if err == nil {
+		err = applyConntrackExemptions(driver, nsPath, epCfg)
+	}
+	if err == nil && epCfg.HostEndpoint {
+		err = p.configureHostEndpoint(epCfg, nwCfg)
+	}
+	if err != nil {
+		logrus.Errorf("Error setting up the netns of endpoint %s. Err: %v", id, err)
+		if err := driver.DeleteEndpoint(id); err != nil {
+			logrus.Errorf("Error detaching endpoint %s. Err: %v", id, err)
+		}
+		return err
+	}
+	p.endpointCreated(id, driver)
+	p.rebuildMirrors(driver, id)
+
+	epCfg.Status = mastercfg.EndpointStatusAttached
+	epCfg.NetnsPath = nsPath
+	if err := epCfg.Write(); err != nil {
+		return err
+	}
+
+	p.runPostAttachHooks(ns, epCfg)
+	return nil
 }
 
 // CreateRemoteEndpoint creates an endpoint for a given ID.
 func (p *NetPlugin) CreateRemoteEndpoint(id string) error {
+	if err := p.rateLimit(); err != nil {
+		return err
+	}
 	p.Lock()
 	defer p.Unlock()
+	if p.NetworkDriver == nil {
+		return p.driverErr()
+	}
 	return p.NetworkDriver.CreateRemoteEndpoint(id)
 }
 
 // DeleteRemoteEndpoint destroys an endpoint for an ID.
 func (p *NetPlugin) DeleteRemoteEndpoint(id string) error {
+	if err := p.rateLimit(); err != nil {
+		return err
+	}
 	p.Lock()
 	defer p.Unlock()
+	if p.NetworkDriver == nil {
+		return p.driverErr()
+	}
 	return p.NetworkDriver.DeleteRemoteEndpoint(id)
 }
 
 // CreateHostAccPort creates a host access port
 func (p *NetPlugin) CreateHostAccPort(portName, globalIP string) (string, error) {
+	if err := p.rateLimit(); err != nil {
+		return "", err
+	}
 	p.Lock()
 	defer p.Unlock()
+	if p.NetworkDriver == nil {
+		return "", p.driverErr()
+	}
 	return p.NetworkDriver.CreateHostAccPort(portName, globalIP, p.PluginConfig.Instance.HostPvtNW)
 }
 
 // DeleteHostAccPort creates a host access port
 func (p *NetPlugin) DeleteHostAccPort(portName string) error {
+	if err := p.rateLimit(); err != nil {
+		return err
+	}
 	p.Lock()
 	defer p.Unlock()
+	if p.NetworkDriver == nil {
+		return p.driverErr()
+	}
 	return p.NetworkDriver.DeleteHostAccPort(portName)
 }
 
-// FetchEndpoint retrieves an endpoint's state for a given ID
+// FetchEndpoint retrieves an endpoint's state for a given ID, including
+// any secondary addresses assigned to it
 func (p *NetPlugin) FetchEndpoint(id string) (core.State, error) {
-	return nil, core.Errorf("Not implemented")
+	p.Lock()
+	defer p.Unlock()
+	if p.StateDriver == nil {
+		return nil, ErrNotInitialized
+	}
+
+	epOper := &drivers.OperEndpointState{}
+	epOper.StateDriver = p.StateDriver
+	if err := epOper.Read(id); err != nil {
+		return nil, err
+	}
+
+	return epOper, nil
+}
+
+// GetNetworkEndpointCount returns the number of endpoints of a network,
+// from the count netmaster keeps in the network state
+func (p *NetPlugin) GetNetworkEndpointCount(networkID string) (int, error) {
+	p.Lock()
+	defer p.Unlock()
+	if p.StateDriver == nil {
+		return 0, ErrNotInitialized
+	}
+
+	nwCfg := &mastercfg.CfgNetworkState{}
+	nwCfg.StateDriver = p.StateDriver
+	if err := nwCfg.Read(networkID); err != nil {
+		return 0, err
+	}
+	return nwCfg.EpCount, nil
+}
+
+// GetIPPoolUtilization returns the number of allocated and of usable IPv4
+// addresses of a network
+func (p *NetPlugin) GetIPPoolUtilization(networkID string) (int, int, error) {
+	p.Lock()
+	defer p.Unlock()
+	if p.StateDriver == nil {
+		return 0, 0, ErrNotInitialized
+	}
+
+	nwCfg := &mastercfg.CfgNetworkState{}
+	nwCfg.StateDriver = p.StateDriver
+	if err := nwCfg.Read(networkID); err != nil {
+		return 0, 0, err
+	}
+	return master.IPPoolUtilization(nwCfg)
+}
+
+// GetContainersOnNetwork returns the distinct containers that have an
+// endpoint on the network. Endpoints not bound to a container are skipped.
+// The endpoints are listed with a serializable read, recent changes may be
+// missing.
+func (p *NetPlugin) GetContainersOnNetwork(networkID string) ([]string, error) {
+	p.Lock()
+	defer p.Unlock()
+	if p.StateDriver == nil {
+		return nil, ErrNotInitialized
+	}
+
+	nwCfg := &mastercfg.CfgNetworkState{}
+	nwCfg.StateDriver = p.StateDriver
+	if err := nwCfg.Read(networkID); err != nil {
+		return nil, err
+	}
+
+	readEp := &mastercfg.CfgEndpointState{}
+	readEp.StateDriver = p.StateDriver
+	epCfgs, err := readEp.ReadAllWith(core.Serializable)
+	if core.ErrIfKeyExists(err) != nil {
+		return nil, err
+	}
+
+	containers := []string{}
+	seen := make(map[string]bool)
+	for _, epCfg := range epCfgs {
+		ep := epCfg.(*mastercfg.CfgEndpointState)
+		if ep.NetID != networkID || ep.ContainerID == "" || seen[ep.ContainerID] {
+			continue
+		}
+		seen[ep.ContainerID] = true
+		containers = append(containers, ep.ContainerID)
+	}
+
+	return containers, nil
+}
+
+// ListNetworksByVRF returns the ids of the networks in vrf, sorted, those in
+// no vrf when vrf is empty. The networks are listed with a serializable
+// read, recent changes may be missing.
+func (p *NetPlugin) ListNetworksByVRF(vrf string) ([]string, error) {
+	p.Lock()
+	defer p.Unlock()
+	if p.StateDriver == nil {
+		return nil, ErrNotInitialized
+	}
+
+	readNw := &mastercfg.CfgNetworkState{}
+	readNw.StateDriver = p.StateDriver
+	nwCfgs, err := readNw.ReadAllWith(core.Serializable)
+	if core.ErrIfKeyExists(err) != nil {
+		return nil, err
+	}
+
+	networks := []string{}
+	for _, nwCfg := range nwCfgs {
+		nw := nwCfg.(*mastercfg.CfgNetworkState)
+		if nw.Vrf == vrf {
+			networks = append(networks, nw.ID)
+		}
+	}
+	sort.Strings(networks)
+	return networks, nil
 }
 
 // AddPeerHost adds an peer host.
 func (p *NetPlugin) AddPeerHost(node core.ServiceInfo) error {
 	p.Lock()
 	defer p.Unlock()
+	if p.NetworkDriver == nil {
+		return p.driverErr()
+	}
 	return p.NetworkDriver.AddPeerHost(node)
 }
 
@@ -195,6 +1162,9 @@ func (p *NetPlugin) AddPeerHost(node core.ServiceInfo) error {
 func (p *NetPlugin) DeletePeerHost(node core.ServiceInfo) error {
 	p.Lock()
 	defer p.Unlock()
+	if p.NetworkDriver == nil {
+		return p.driverErr()
+	}
 	return p.NetworkDriver.DeletePeerHost(node)
 }
 
@@ -202,6 +1172,9 @@ func (p *NetPlugin) DeletePeerHost(node core.ServiceInfo) error {
 func (p *NetPlugin) AddMaster(node core.ServiceInfo) error {
 	p.Lock()
 	defer p.Unlock()
+	if p.NetworkDriver == nil {
+		return p.driverErr()
+	}
 	return p.NetworkDriver.AddMaster(node)
 }
 
@@ -209,6 +1182,9 @@ func (p *NetPlugin) AddMaster(node core.ServiceInfo) error {
 func (p *NetPlugin) DeleteMaster(node core.ServiceInfo) error {
 	p.Lock()
 	defer p.Unlock()
+	if p.NetworkDriver == nil {
+		return p.driverErr()
+	}
 	return p.NetworkDriver.DeleteMaster(node)
 }
 
@@ -216,6 +1192,9 @@ func (p *NetPlugin) DeleteMaster(node core.ServiceInfo) error {
 func (p *NetPlugin) AddBgp(id string) error {
 	p.Lock()
 	defer p.Unlock()
+	if p.NetworkDriver == nil {
+		return p.driverErr()
+	}
 	return p.NetworkDriver.AddBgp(id)
 }
 
@@ -223,6 +1202,9 @@ func (p *NetPlugin) AddBgp(id string) error {
 func (p *NetPlugin) DeleteBgp(id string) error {
 	p.Lock()
 	defer p.Unlock()
+	if p.NetworkDriver == nil {
+		return p.driverErr()
+	}
 	return p.NetworkDriver.DeleteBgp(id)
 }
 
@@ -230,6 +1212,9 @@ func (p *NetPlugin) DeleteBgp(id string) error {
 func (p *NetPlugin) AddServiceLB(servicename string, spec *core.ServiceSpec) error {
 	p.Lock()
 	defer p.Unlock()
+	if p.NetworkDriver == nil {
+		return p.driverErr()
+	}
 	return p.NetworkDriver.AddSvcSpec(servicename, spec)
 }
 
@@ -237,6 +1222,9 @@ func (p *NetPlugin) AddServiceLB(servicename string, spec *core.ServiceSpec) err
 func (p *NetPlugin) DeleteServiceLB(servicename string, spec *core.ServiceSpec) error {
 	p.Lock()
 	defer p.Unlock()
+	if p.NetworkDriver == nil {
+		return p.driverErr()
+	}
 	return p.NetworkDriver.DelSvcSpec(servicename, spec)
 }
 
@@ -244,6 +1232,10 @@ func (p *NetPlugin) DeleteServiceLB(servicename string, spec *core.ServiceSpec)
 func (p *NetPlugin) SvcProviderUpdate(servicename string, providers []string) {
 	p.Lock()
 	defer p.Unlock()
+	if p.NetworkDriver == nil {
+		logrus.Errorf("SvcProviderUpdate called before netplugin was initialized")
+		return
+	}
 	p.NetworkDriver.SvcProviderUpdate(servicename, providers)
 }
 
@@ -251,13 +1243,83 @@ func (p *NetPlugin) SvcProviderUpdate(servicename string, providers []string) {
 func (p *NetPlugin) GetEndpointStats() ([]byte, error) {
 	p.Lock()
 	defer p.Unlock()
+	if p.NetworkDriver == nil {
+		return nil, p.driverErr()
+	}
 	return p.NetworkDriver.GetEndpointStats()
 }
 
+// GetEndpointIntfStats returns the byte, packet and drop counters of an
+// endpoint attached on this host. Unlike GetEndpointStats, which reports the
+// datapath stats of all endpoints, this reads the endpoint's own interface.
+func (p *NetPlugin) GetEndpointIntfStats(epID string) (drivers.EndpointStats, error) {
+	p.Lock()
+	defer p.Unlock()
+	if p.NetworkDriver == nil {
+		return drivers.EndpointStats{}, p.driverErr()
+	}
+
+	reader, ok := p.NetworkDriver.(endpointStatsReader)
+	if !ok {
+		return drivers.EndpointStats{}, core.Errorf("network driver does not support endpoint stats")
+	}
+
+	stats, err := reader.GetEndpointIntfStats(epID)
+	if err != nil {
+		return drivers.EndpointStats{}, err
+	}
+	return *stats, nil
+}
+
+// GetEndpointDataplaneInfo returns the bridge, port, ofport and tags backing
+// a local endpoint, to look it up with the ovs tools
+func (p *NetPlugin) GetEndpointDataplaneInfo(epID string) (drivers.DataplaneInfo, error) {
+	p.Lock()
+	defer p.Unlock()
+	if p.NetworkDriver == nil {
+		return drivers.DataplaneInfo{}, p.driverErr()
+	}
+
+	driver, err := p.endpointDriver(epID)
+	if err != nil {
+		return drivers.DataplaneInfo{}, err
+	}
+	reporter, ok := driver.(dataplaneReporter)
+	if !ok {
+		return drivers.DataplaneInfo{}, core.Errorf("network driver does not report endpoint dataplane info")
+	}
+
+	info, err := reporter.GetEndpointDataplaneInfo(epID)
+	if err != nil {
+		return drivers.DataplaneInfo{}, err
+	}
+	return *info, nil
+}
+
+// UpdateEndpointBinding refreshes the labels of the datapath port of a local
+// endpoint after its config changed. It is a no-op for network drivers that
+// do not label ports.
+func (p *NetPlugin) UpdateEndpointBinding(epID string) error {
+	p.Lock()
+	defer p.Unlock()
+	if p.NetworkDriver == nil {
+		return p.driverErr()
+	}
+
+	binder, ok := p.NetworkDriver.(endpointBinder)
+	if !ok {
+		return nil
+	}
+	return binder.UpdateEndpointBinding(epID)
+}
+
 // InspectState returns current state of the plugin
 func (p *NetPlugin) InspectState() ([]byte, error) {
 	p.Lock()
 	defer p.Unlock()
+	if p.NetworkDriver == nil {
+		return nil, p.driverErr()
+	}
 	return p.NetworkDriver.InspectState()
 }
 
@@ -265,13 +1327,35 @@ func (p *NetPlugin) InspectState() ([]byte, error) {
 func (p *NetPlugin) InspectBgp() ([]byte, error) {
 	p.Lock()
 	defer p.Unlock()
+	if p.NetworkDriver == nil {
+		return nil, p.driverErr()
+	}
 	return p.NetworkDriver.InspectBgp()
 }
 
+// DebugDumpFlows returns the flows programmed for a network, for network
+// drivers that support it
+func (p *NetPlugin) DebugDumpFlows(networkID string) ([]string, error) {
+	p.Lock()
+	defer p.Unlock()
+	if p.NetworkDriver == nil {
+		return nil, p.driverErr()
+	}
+
+	dumper, ok := p.NetworkDriver.(flowDumper)
+	if !ok {
+		return nil, core.Errorf("network driver does not support dumping flows")
+	}
+	return dumper.DumpNetworkFlows(networkID)
+}
+
 // InspectNameserver returns current state of the nameserver
 func (p *NetPlugin) InspectNameserver() ([]byte, error) {
 	p.Lock()
 	defer p.Unlock()
+	if p.NetworkDriver == nil {
+		return nil, p.driverErr()
+	}
 	return p.NetworkDriver.InspectNameserver()
 }
 
@@ -279,6 +1363,9 @@ func (p *NetPlugin) InspectNameserver() ([]byte, error) {
 func (p *NetPlugin) GlobalConfigUpdate(cfg Config) error {
 	p.Lock()
 	defer p.Unlock()
+	if p.NetworkDriver == nil {
+		return p.driverErr()
+	}
 	return p.NetworkDriver.GlobalConfigUpdate(cfg.Instance)
 }
 
@@ -288,20 +1375,34 @@ func (p *NetPlugin) Reinit(cfg Config) {
 
 	p.Lock()
 	defer p.Unlock()
+	if p.PluginConfig.Observer {
+		logrus.Infof("Reinit skipped in observer mode")
+		return
+	}
 	if p.NetworkDriver != nil {
 		logrus.Infof("Reinit de-initializing NetworkDriver")
 		p.NetworkDriver.Deinit()
 		p.NetworkDriver = nil
 	}
+	p.deinitEndpointDrivers()
 
-	cfg.Instance.StateDriver, _ = utils.GetStateDriver()
-	p.NetworkDriver, err = utils.NewNetworkDriver(cfg.Drivers.Network, &cfg.Instance)
+	cfg.Instance.StateDriver = p.StateDriver
+	if p.Registry == nil {
+		p.Registry = utils.NewDriverRegistry()
+	}
+	p.NetworkDriver, err = p.Registry.NewNetworkDriver(cfg.Drivers.Network, &cfg.Instance)
 	logrus.Infof("Reinit Initializing NetworkDriver")
 
 	if err != nil {
-		logrus.Errorf("Reinit De-initializing due to error: %v", err)
-		p.NetworkDriver.Deinit()
+		logrus.Errorf("Reinit failed to initialize NetworkDriver: %v", err)
+		p.NetworkDriver = nil
+		return
 	}
+
+	if err := p.initEndpointDrivers(&cfg); err != nil {
+		logrus.Errorf("Reinit failed to initialize the endpoint drivers: %v", err)
+	}
+	p.startLinkMonitor()
 }
 
 //InitGlobalSettings initializes cluster-wide settings (e.g. fwd-mode)
@@ -364,6 +1465,10 @@ func InitGlobalSettings(stateDriver core.StateDriver, inst *core.InstanceInfo) e
 func (p *NetPlugin) AddSvcSpec(svcName string, spec *core.ServiceSpec) {
 	p.Lock()
 	defer p.Unlock()
+	if p.NetworkDriver == nil {
+		logrus.Errorf("AddSvcSpec called before netplugin was initialized")
+		return
+	}
 	p.NetworkDriver.AddSvcSpec(svcName, spec)
 }
 
@@ -371,6 +1476,10 @@ func (p *NetPlugin) AddSvcSpec(svcName string, spec *core.ServiceSpec) {
 func (p *NetPlugin) DelSvcSpec(svcName string, spec *core.ServiceSpec) {
 	p.Lock()
 	defer p.Unlock()
+	if p.NetworkDriver == nil {
+		logrus.Errorf("DelSvcSpec called before netplugin was initialized")
+		return
+	}
 	p.NetworkDriver.DelSvcSpec(svcName, spec)
 }
 
@@ -378,6 +1487,9 @@ func (p *NetPlugin) DelSvcSpec(svcName string, spec *core.ServiceSpec) {
 func (p *NetPlugin) AddPolicyRule(id string) error {
 	p.Lock()
 	defer p.Unlock()
+	if p.NetworkDriver == nil {
+		return p.driverErr()
+	}
 	return p.NetworkDriver.AddPolicyRule(id)
 }
 
@@ -385,5 +1497,8 @@ func (p *NetPlugin) AddPolicyRule(id string) error {
 func (p *NetPlugin) DelPolicyRule(id string) error {
 	p.Lock()
 	defer p.Unlock()
+	if p.NetworkDriver == nil {
+		return p.driverErr()
+	}
 	return p.NetworkDriver.DelPolicyRule(id)
 }
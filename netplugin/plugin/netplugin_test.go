@@ -17,12 +17,30 @@ package plugin
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/Sirupsen/logrus"
 	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/drivers"
+	"github.com/contiv/netplugin/netmaster/master"
 	"github.com/contiv/netplugin/netmaster/mastercfg"
 	"github.com/contiv/netplugin/state"
 	"github.com/contiv/netplugin/utils"
+	"github.com/contiv/netplugin/utils/netutils"
+	"github.com/contiv/ofnet"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
 	"testing"
+	"time"
 )
 
 var fakeStateDriver *state.FakeStateDriver
@@ -269,3 +287,3259 @@ func TestNetPluginInitInvalidConfigInvalidPrivateSubnet(t *testing.T) {
 		t.Fatalf("plugin init succeeded, should have failed!")
 	}
 }
+
+func TestEndpointEventFromWatch(t *testing.T) {
+	curr := &drivers.OperEndpointState{}
+	curr.ID = "net1.default-ep1"
+	prev := &drivers.OperEndpointState{}
+	prev.ID = "net1.default-ep1"
+
+	testData := []struct {
+		rsp   core.WatchState
+		ok    bool
+		evTyp EndpointEventType
+	}{
+		{core.WatchState{Curr: curr}, true, EndpointCreated},
+		{core.WatchState{Curr: curr, Prev: prev}, true, EndpointUpdated},
+		{core.WatchState{Prev: prev}, true, EndpointDeleted},
+		{core.WatchState{}, false, ""},
+	}
+
+	for _, d := range testData {
+		ev, ok := endpointEventFromWatch(d.rsp)
+		if ok != d.ok || ev.Type != d.evTyp {
+			t.Fatalf("unexpected event %+v (%v) for %+v", ev, ok, d.rsp)
+		}
+		if ok && ev.ID != "net1.default-ep1" {
+			t.Fatalf("unexpected endpoint id %q", ev.ID)
+		}
+	}
+}
+
+func TestGetContainersOnNetwork(t *testing.T) {
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	nwCfg := &mastercfg.CfgNetworkState{}
+	nwCfg.StateDriver = fakeStateDriver
+	nwCfg.ID = "orange.default"
+	if err := nwCfg.Write(); err != nil {
+		t.Fatalf("error writing network state. Error: %s", err)
+	}
+
+	eps := []struct {
+		id, netID, containerID string
+	}{
+		{"orange.default-ep1", "orange.default", "cont1"},
+		{"orange.default-ep2", "orange.default", "cont1"},
+		{"orange.default-ep3", "orange.default", "cont2"},
+		{"orange.default-ep4", "orange.default", ""},
+		{"blue.default-ep1", "blue.default", "cont3"},
+	}
+	for _, ep := range eps {
+		epCfg := &mastercfg.CfgEndpointState{NetID: ep.netID, ContainerID: ep.containerID}
+		epCfg.StateDriver = fakeStateDriver
+		epCfg.ID = ep.id
+		if err := epCfg.Write(); err != nil {
+			t.Fatalf("error writing endpoint state. Error: %s", err)
+		}
+	}
+
+	p := &NetPlugin{StateDriver: fakeStateDriver}
+	containers, err := p.GetContainersOnNetwork("orange.default")
+	if err != nil {
+		t.Fatalf("error listing containers. Error: %s", err)
+	}
+	if len(containers) != 2 {
+		t.Fatalf("expected containers cont1 and cont2, got %v", containers)
+	}
+	for _, c := range containers {
+		if c != "cont1" && c != "cont2" {
+			t.Fatalf("unexpected container %s on network", c)
+		}
+	}
+
+	if _, err := p.GetContainersOnNetwork("green.default"); err == nil {
+		t.Fatalf("listing containers of a missing network succeeded, expected to fail")
+	}
+}
+
+func TestListNetworksByVRF(t *testing.T) {
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	nws := []struct {
+		id, vrf string
+	}{
+		{"orange.default", "vrf-blue"},
+		{"apple.default", "vrf-blue"},
+		{"grape.default", "vrf-red"},
+		{"lemon.default", ""},
+	}
+	for _, nw := range nws {
+		nwCfg := &mastercfg.CfgNetworkState{Vrf: nw.vrf}
+		nwCfg.StateDriver = fakeStateDriver
+		nwCfg.ID = nw.id
+		if err := nwCfg.Write(); err != nil {
+			t.Fatalf("error writing network state. Error: %s", err)
+		}
+	}
+
+	p := &NetPlugin{StateDriver: fakeStateDriver}
+	for vrf, exp := range map[string]string{
+		"vrf-blue":  "apple.default,orange.default",
+		"vrf-red":   "grape.default",
+		"":          "lemon.default",
+		"vrf-green": "",
+	} {
+		networks, err := p.ListNetworksByVRF(vrf)
+		if err != nil {
+			t.Fatalf("error listing networks of vrf %q. Error: %s", vrf, err)
+		}
+		if strings.Join(networks, ",") != exp {
+			t.Fatalf("unexpected networks %v of vrf %q, expected %s", networks, vrf, exp)
+		}
+	}
+}
+
+// unhealthyDriver is a fake driver failing its health check
+type unhealthyDriver struct {
+	drivers.FakeNetEpDriver
+}
+
+func (d *unhealthyDriver) CheckHealth() error {
+	return errors.New("switch not connected")
+}
+
+func TestGetPluginStatus(t *testing.T) {
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	nws := []struct {
+		id, subnet, addrRange string
+		allocs                []uint
+		ipam                  string
+	}{
+		{"orange.default", "10.1.1.0/30", "10.1.1.1-10.1.1.2", []uint{1, 2}, ""},
+		{"apple.default", "10.1.2.0/24", "10.1.2.1-10.1.2.254", []uint{1}, ""},
+		{"grape.default", "", "", nil, mastercfg.IPAMDhcp},
+	}
+	for _, nw := range nws {
+		nwCfg := &mastercfg.CfgNetworkState{IPAM: nw.ipam, IPAddrRange: nw.addrRange}
+		nwCfg.StateDriver = fakeStateDriver
+		nwCfg.ID = nw.id
+		if nw.subnet != "" {
+			nwCfg.SubnetIP, nwCfg.SubnetLen, _ = netutils.ParseCIDR(nw.subnet)
+			netutils.InitSubnetBitset(&nwCfg.IPAllocMap, nwCfg.SubnetLen)
+			for _, alloc := range nw.allocs {
+				nwCfg.IPAllocMap.Set(alloc)
+			}
+		}
+		if err := nwCfg.Write(); err != nil {
+			t.Fatalf("error writing network state. Error: %s", err)
+		}
+	}
+	epCfg := &mastercfg.CfgEndpointState{NetID: "orange.default"}
+	epCfg.StateDriver = fakeStateDriver
+	epCfg.ID = "orange.default-ep1"
+	if err := epCfg.Write(); err != nil {
+		t.Fatalf("error writing endpoint state. Error: %s", err)
+	}
+
+	p := &NetPlugin{
+		StateDriver:   fakeStateDriver,
+		NetworkDriver: &drivers.FakeNetEpDriver{},
+		PluginConfig: Config{
+			Drivers: Drivers{Network: "ovs", State: "fakedriver"},
+		},
+		endpointDrivers: map[string]core.NetworkDriver{"sriov": &unhealthyDriver{}},
+	}
+	status, err := p.GetPluginStatus()
+	if err != nil {
+		t.Fatalf("error getting the plugin status. Error: %s", err)
+	}
+	if status.Observer || status.Networks != 3 || status.Endpoints != 1 {
+		t.Fatalf("unexpected plugin status %+v", status)
+	}
+	expPools := IPPoolSummary{Allocated: 3, Usable: 256, Exhausted: []string{"orange.default"}}
+	if !reflect.DeepEqual(status.IPPools, expPools) {
+		t.Fatalf("unexpected pool summary %+v, expected %+v", status.IPPools, expPools)
+	}
+	expDrivers := []DriverStatus{
+		{Name: "fakedriver", Role: DriverRoleState, Healthy: true},
+		{Name: "ovs", Role: DriverRoleNetwork, Healthy: true},
+		{Name: "sriov", Role: DriverRoleEndpoint, Error: "switch not connected"},
+	}
+	if !reflect.DeepEqual(status.Drivers, expDrivers) {
+		t.Fatalf("unexpected driver status %+v, expected %+v", status.Drivers, expDrivers)
+	}
+
+	// observers have no network driver
+	p = &NetPlugin{StateDriver: fakeStateDriver, PluginConfig: Config{Observer: true}}
+	status, err = p.GetPluginStatus()
+	if err != nil {
+		t.Fatalf("error getting the observer status. Error: %s", err)
+	}
+	if !status.Observer || len(status.Drivers) != 1 || !status.Drivers[0].Healthy {
+		t.Fatalf("unexpected observer status %+v", status)
+	}
+
+	if _, err := (&NetPlugin{}).GetPluginStatus(); err != ErrNotInitialized {
+		t.Fatalf("status of an uninitialized plugin returned %v", err)
+	}
+}
+
+func TestCreateNetworkSpecInvalid(t *testing.T) {
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	p := &NetPlugin{StateDriver: fakeStateDriver, NetworkDriver: &drivers.FakeNetEpDriver{}}
+	specs := []NetworkSpec{
+		{Tenant: "default", Encap: "vlan", SubnetCIDR: "10.1.1.0/24"},
+		{Name: "orange", Encap: "vlan", SubnetCIDR: "10.1.1.0/24"},
+		{Tenant: "default", Name: "orange", Encap: "gre", SubnetCIDR: "10.1.1.0/24"},
+	}
+
+	for _, spec := range specs {
+		if err := p.CreateNetworkSpec(spec); err == nil {
+			t.Fatalf("network creation with spec %+v succeeded, expected to fail", spec)
+		}
+	}
+}
+
+func TestNetPluginNotInitialized(t *testing.T) {
+	p := &NetPlugin{}
+
+	if err := p.CreateNetwork("net1.default"); err != ErrNotInitialized {
+		t.Fatalf("expected ErrNotInitialized from CreateNetwork, got %v", err)
+	}
+	if err := p.CreateEndpoint("net1.default-ep1"); err != ErrNotInitialized {
+		t.Fatalf("expected ErrNotInitialized from CreateEndpoint, got %v", err)
+	}
+	if err := p.DeleteEndpoint("net1.default-ep1"); err != ErrNotInitialized {
+		t.Fatalf("expected ErrNotInitialized from DeleteEndpoint, got %v", err)
+	}
+	if _, err := p.CreateHostAccPort("port1", "10.1.1.1"); err != ErrNotInitialized {
+		t.Fatalf("expected ErrNotInitialized from CreateHostAccPort, got %v", err)
+	}
+	if _, err := p.InspectState(); err != ErrNotInitialized {
+		t.Fatalf("expected ErrNotInitialized from InspectState, got %v", err)
+	}
+	if _, err := p.FetchEndpoint("net1.default-ep1"); err != ErrNotInitialized {
+		t.Fatalf("expected ErrNotInitialized from FetchEndpoint, got %v", err)
+	}
+
+	// methods without an error return must not panic
+	p.SvcProviderUpdate("svc1", []string{})
+	p.AddSvcSpec("svc1", &core.ServiceSpec{})
+}
+
+func TestVerifyState(t *testing.T) {
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	nwCfg := &mastercfg.CfgNetworkState{SubnetIP: "10.1.1.0", SubnetLen: 24}
+	nwCfg.StateDriver = fakeStateDriver
+	nwCfg.ID = "orange.default"
+	if err := nwCfg.Write(); err != nil {
+		t.Fatalf("error writing network state. Error: %s", err)
+	}
+
+	eps := []struct {
+		id, netID, ipAddr string
+	}{
+		{"orange.default-ep1", "orange.default", "10.1.1.1"},
+		{"orange.default-ep2", "orange.default", "10.1.1.1"},
+		{"orange.default-ep3", "orange.default", "10.1.2.1"},
+		{"blue.default-ep1", "blue.default", "10.1.1.2"},
+	}
+	for _, ep := range eps {
+		epCfg := &mastercfg.CfgEndpointState{NetID: ep.netID, IPAddress: ep.ipAddr}
+		epCfg.StateDriver = fakeStateDriver
+		epCfg.ID = ep.id
+		if err := epCfg.Write(); err != nil {
+			t.Fatalf("error writing endpoint state. Error: %s", err)
+		}
+	}
+	if err := fakeStateDriver.Write("/contiv.io/state/eps/bad", []byte("{")); err != nil {
+		t.Fatalf("error writing bad record. Error: %s", err)
+	}
+
+	p := &NetPlugin{StateDriver: fakeStateDriver}
+	problems, err := p.VerifyState()
+	if err != nil {
+		t.Fatalf("error verifying state. Error: %s", err)
+	}
+
+	// duplicate address, address outside subnet, missing network, bad record
+	if len(problems) != 4 {
+		t.Fatalf("expected 4 problems, got %+v", problems)
+	}
+
+	if err := nwCfg.Read("orange.default"); err != nil {
+		t.Fatalf("state was modified by verify. Error: %s", err)
+	}
+}
+
+func TestNetPluginDeinitSharedStateDriver(t *testing.T) {
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	pluginConfig := Config{Observer: true}
+	pluginConfig.Drivers.State = "fakedriver"
+	pluginConfig.Instance.HostLabel = "testHost"
+
+	// the state driver created by someone else outlives the plugin
+	p := &NetPlugin{}
+	if err := p.Init(pluginConfig); err != nil {
+		t.Fatalf("plugin init failed: Error: %s", err)
+	}
+	p.Deinit()
+	if d, err := utils.GetStateDriver(); err != nil || d != fakeStateDriver {
+		t.Fatalf("shared state driver released by the plugin, got %v, err %v", d, err)
+	}
+
+	// the state driver created by the plugin goes with it
+	utils.ReleaseStateDriver()
+	p = &NetPlugin{}
+	if err := p.Init(pluginConfig); err != nil {
+		t.Fatalf("plugin init failed: Error: %s", err)
+	}
+	p.Deinit()
+	if _, err := utils.GetStateDriver(); err == nil {
+		t.Fatalf("state driver created by the plugin left after its deinit")
+	}
+}
+
+func TestNetPluginObserverMode(t *testing.T) {
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	nwCfg := &mastercfg.CfgNetworkState{}
+	nwCfg.StateDriver = fakeStateDriver
+	nwCfg.ID = "orange.default"
+	if err := nwCfg.Write(); err != nil {
+		t.Fatalf("error writing network state. Error: %s", err)
+	}
+
+	p := &NetPlugin{StateDriver: fakeStateDriver}
+	p.PluginConfig.Observer = true
+
+	if err := p.CreateNetwork("orange.default"); err != ErrObserverMode {
+		t.Fatalf("expected ErrObserverMode from CreateNetwork, got %v", err)
+	}
+	if err := p.CreateEndpoint("orange.default-ep1"); err != ErrObserverMode {
+		t.Fatalf("expected ErrObserverMode from CreateEndpoint, got %v", err)
+	}
+	if err := p.CreateNetworkSpec(NetworkSpec{Tenant: "default", Name: "blue", Encap: "vlan"}); err != ErrObserverMode {
+		t.Fatalf("expected ErrObserverMode from CreateNetworkSpec, got %v", err)
+	}
+	if _, err := p.FetchNetwork("blue.default"); err == nil {
+		t.Fatalf("network was created in observer mode")
+	}
+
+	// reads work
+	if _, err := p.FetchNetwork("orange.default"); err != nil {
+		t.Fatalf("error fetching network in observer mode. Error: %s", err)
+	}
+}
+
+func TestRunUntilSignal(t *testing.T) {
+	p := &NetPlugin{}
+
+	// keep SIGUSR1 from terminating the test before the plugin registers
+	testCh := make(chan os.Signal, 1)
+	signal.Notify(testCh, syscall.SIGUSR1)
+	defer signal.Stop(testCh)
+
+	done := make(chan error)
+	go func() {
+		done <- p.RunUntilSignal(syscall.SIGUSR1)
+	}()
+
+	// the handler is registered asynchronously, keep signalling until it
+	// picks one up
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("error running until signal. Error: %s", err)
+			}
+			return
+		case <-ticker.C:
+			syscall.Kill(syscall.Getpid(), syscall.SIGUSR1)
+		case <-timeout:
+			t.Fatalf("RunUntilSignal did not return after signal")
+		}
+	}
+}
+
+func TestCreateEndpointFromSpecInvalid(t *testing.T) {
+	p := &NetPlugin{}
+
+	testData := []struct {
+		spec  string
+		field string
+	}{
+		{`{"network": "orange"}`, "tenant"},
+		{`{"tenant": "default"}`, "network"},
+		{`{"tenant": "default", "network": "orange", "containerId": "c-1"}`, "containerId"},
+		{`{"tenant": "default", "network": "orange", "ipAddress": "10.1.1"}`, "ipAddress"},
+		{`{"tenant": "default", "network": "orange", "macAddress": "02:02"}`, "macAddress"},
+		{`{"tenant": "default", "network": "orange", "leaseTtl": 1}`, "leaseTtl"},
+		{`{"tenant": "default", "network": "orange", "routes": [{"dest": "10.2.0.0", "gateway": "10.1.1.1"}]}`, "routes[0].dest"},
+		{`{"tenant": "default", "network": "orange", "routes": [{"dest": "10.2.0.0/16", "gateway": "x"}]}`, "routes[0].gateway"},
+		{`{"tenant": "default", "network": "orange", "routes": [{"dest": "10.2.0.0/16", "nextHops": [{"gateway": "10.1.1.1"}, {"gateway": "x"}]}]}`, "routes[0].nextHops[1].gateway"},
+	}
+
+	for _, d := range testData {
+		_, err := p.CreateEndpointFromSpec(strings.NewReader(d.spec))
+		if err == nil || !strings.Contains(err.Error(), "endpoint spec: "+d.field+":") {
+			t.Fatalf("spec %s: expected error on %s, got %v", d.spec, d.field, err)
+		}
+	}
+
+	// a valid spec still needs an initialized plugin
+	_, err := p.CreateEndpointFromSpec(strings.NewReader(`{"tenant": "default", "network": "orange"}`))
+	if err != ErrNotInitialized {
+		t.Fatalf("expected ErrNotInitialized, got %v", err)
+	}
+}
+
+// failingEpDriver fails to create endpoints
+type failingEpDriver struct {
+	drivers.FakeNetEpDriver
+}
+
+func (d *failingEpDriver) CreateEndpoint(id string) error {
+	return core.Errorf("no port for endpoint %s", id)
+}
+
+func TestCreateEndpointFromSpecKeepsExisting(t *testing.T) {
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	nwCfg := &mastercfg.CfgNetworkState{Tenant: "default", NetworkName: "orange"}
+	nwCfg.ID = "orange.default"
+	nwCfg.StateDriver = fakeStateDriver
+	if err := nwCfg.Write(); err != nil {
+		t.Fatalf("error writing network state. Error: %s", err)
+	}
+	epCfg := &mastercfg.CfgEndpointState{NetID: "orange.default", IPAddress: "10.1.1.2"}
+	epCfg.ID = master.ContainerEpID("orange.default", "ctr1")
+	epCfg.StateDriver = fakeStateDriver
+	if err := epCfg.Write(); err != nil {
+		t.Fatalf("error writing endpoint state. Error: %s", err)
+	}
+
+	// a failed create does not remove the endpoint it was asked for again
+	p := &NetPlugin{StateDriver: fakeStateDriver, NetworkDriver: &failingEpDriver{}}
+	_, err := p.CreateEndpointFromSpec(strings.NewReader(`{"tenant": "default", "network": "orange", "containerId": "ctr1"}`))
+	if err == nil {
+		t.Fatalf("endpoint create succeeded on a failing driver")
+	}
+	if err := epCfg.Read(epCfg.ID); err != nil {
+		t.Fatalf("existing endpoint removed by a failed create. Err: %v", err)
+	}
+}
+
+// wiringDriver tracks which endpoints have datapath wiring
+type wiringDriver struct {
+	drivers.FakeNetEpDriver
+	wired map[string]bool
+}
+
+func (d *wiringDriver) CreateEndpoint(id string) error {
+	d.wired[id] = true
+	return nil
+}
+
+func (d *wiringDriver) DeleteEndpoint(id string) error {
+	delete(d.wired, id)
+	return nil
+}
+
+func TestDetachAttachEndpoint(t *testing.T) {
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	nd := &wiringDriver{wired: map[string]bool{"orange-ep1": true}}
+	p := &NetPlugin{StateDriver: fakeStateDriver, NetworkDriver: nd}
+
+	epCfg := &mastercfg.CfgEndpointState{
+		NetID:      "orange.default",
+		IPAddress:  "10.1.1.2",
+		MacAddress: "02:02:0a:01:01:02",
+		Status:     mastercfg.EndpointStatusAttached,
+	}
+	epCfg.ID = "orange-ep1"
+	epCfg.StateDriver = fakeStateDriver
+	if err := epCfg.Write(); err != nil {
+		t.Fatalf("error writing endpoint state. Error: %s", err)
+	}
+
+	checkEp := func(status string) {
+		ep := &mastercfg.CfgEndpointState{}
+		ep.StateDriver = fakeStateDriver
+		if err := ep.Read("orange-ep1"); err != nil {
+			t.Fatalf("endpoint state not found after %s. Error: %s", status, err)
+		}
+		if ep.Status != status {
+			t.Fatalf("expected status %q, got %q", status, ep.Status)
+		}
+		if ep.IPAddress != epCfg.IPAddress || ep.MacAddress != epCfg.MacAddress {
+			t.Fatalf("address changed after %s: %s/%s", status, ep.IPAddress, ep.MacAddress)
+		}
+		if nd.wired["orange-ep1"] != (status == mastercfg.EndpointStatusAttached) {
+			t.Fatalf("unexpected datapath wiring after %s", status)
+		}
+	}
+
+	if err := p.AttachEndpoint("orange-ep1", ""); err == nil {
+		t.Fatalf("attach of an attached endpoint succeeded")
+	}
+
+	if err := p.DetachEndpoint("orange-ep1"); err != nil {
+		t.Fatalf("error detaching endpoint. Error: %s", err)
+	}
+	checkEp(mastercfg.EndpointStatusDetached)
+
+	if err := p.AttachEndpoint("orange-ep1", ""); err != nil {
+		t.Fatalf("error attaching endpoint. Error: %s", err)
+	}
+	checkEp(mastercfg.EndpointStatusAttached)
+}
+
+func TestCreateEndpointAttachSettings(t *testing.T) {
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	nd := &wiringDriver{wired: map[string]bool{}}
+	p := &NetPlugin{StateDriver: fakeStateDriver, NetworkDriver: nd}
+
+	epCfg := &mastercfg.CfgEndpointState{NetID: "orange.default", HostEndpoint: true}
+	epCfg.ID = "orange-ep1"
+	epCfg.StateDriver = fakeStateDriver
+	if err := epCfg.Write(); err != nil {
+		t.Fatalf("error writing endpoint state. Error: %s", err)
+	}
+
+	// settings applied on attach are not skipped by a plain create
+	if err := p.CreateEndpoint("orange-ep1"); err == nil {
+		t.Fatalf("create of an endpoint with attach settings succeeded")
+	}
+	if nd.wired["orange-ep1"] {
+		t.Fatalf("endpoint with attach settings wired by create")
+	}
+
+	// a detached endpoint is left to AttachEndpoint
+	epCfg.Status = mastercfg.EndpointStatusDetached
+	if err := epCfg.Write(); err != nil {
+		t.Fatalf("error writing endpoint state. Error: %s", err)
+	}
+	if err := p.CreateEndpoint("orange-ep1"); err != nil {
+		t.Fatalf("error creating detached endpoint. Error: %s", err)
+	}
+	if nd.wired["orange-ep1"] {
+		t.Fatalf("detached endpoint wired by create")
+	}
+}
+
+func TestEndpointDrivers(t *testing.T) {
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	ovs := &wiringDriver{wired: map[string]bool{}}
+	macvlan := &wiringDriver{wired: map[string]bool{}}
+	p := &NetPlugin{StateDriver: fakeStateDriver, NetworkDriver: ovs,
+		endpointDrivers: map[string]core.NetworkDriver{"macvlan": macvlan}}
+	p.PluginConfig.Drivers.Network = "ovs"
+
+	for id, driver := range map[string]string{"orange-ep1": "", "orange-ep2": "macvlan", "orange-ep3": "sriov"} {
+		epCfg := &mastercfg.CfgEndpointState{NetID: "orange.default", Driver: driver}
+		epCfg.ID = id
+		epCfg.StateDriver = fakeStateDriver
+		if err := epCfg.Write(); err != nil {
+			t.Fatalf("error writing endpoint state. Error: %s", err)
+		}
+	}
+
+	for _, id := range []string{"orange-ep1", "orange-ep2"} {
+		if err := p.CreateEndpoint(id); err != nil {
+			t.Fatalf("error creating endpoint %s. Error: %s", id, err)
+		}
+	}
+	if err := p.CreateEndpoint("orange-ep3"); err == nil {
+		t.Fatalf("endpoint created on an uninitialized driver")
+	}
+	if !ovs.wired["orange-ep1"] || ovs.wired["orange-ep2"] || !macvlan.wired["orange-ep2"] || macvlan.wired["orange-ep1"] {
+		t.Fatalf("endpoints wired on the wrong drivers: ovs %v, macvlan %v", ovs.wired, macvlan.wired)
+	}
+
+	if err := p.DetachEndpoint("orange-ep2"); err != nil || macvlan.wired["orange-ep2"] {
+		t.Fatalf("endpoint not detached from its driver. Error: %v", err)
+	}
+	if err := p.AttachEndpoint("orange-ep2", ""); err != nil || !macvlan.wired["orange-ep2"] {
+		t.Fatalf("endpoint not attached on its driver. Error: %v", err)
+	}
+
+	// the driver is remembered once the endpoint state is gone
+	epCfg := &mastercfg.CfgEndpointState{}
+	epCfg.ID = "orange-ep2"
+	epCfg.StateDriver = fakeStateDriver
+	if err := epCfg.Clear(); err != nil {
+		t.Fatalf("error clearing endpoint state. Error: %s", err)
+	}
+	if err := p.DeleteEndpoint("orange-ep2"); err != nil || macvlan.wired["orange-ep2"] {
+		t.Fatalf("endpoint not deleted from its driver. Error: %v", err)
+	}
+	if len(p.networkDrivers()) != 2 {
+		t.Fatalf("expected networks on 2 drivers, got %d", len(p.networkDrivers()))
+	}
+}
+
+// fakeRuntime maps pod container ids to netns paths
+type fakeRuntime map[string]string
+
+func (r fakeRuntime) Ping() error {
+	if r == nil {
+		return core.Errorf("runtime is down")
+	}
+	return nil
+}
+
+func (r fakeRuntime) GetSandboxNetns(podID string) (string, error) {
+	nsPath, ok := r[podID]
+	if !ok {
+		return "", core.Errorf("no such container %s", podID)
+	}
+	return nsPath, nil
+}
+
+func TestAttachTapEndpoint(t *testing.T) {
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	nd := &wiringDriver{wired: map[string]bool{}}
+	p := &NetPlugin{StateDriver: fakeStateDriver, NetworkDriver: nd}
+
+	epCfg := &mastercfg.CfgEndpointState{
+		NetID:      "orange.default",
+		IPAddress:  "10.1.1.2",
+		MacAddress: "02:02:0a:01:01:02",
+		Status:     mastercfg.EndpointStatusDetached,
+		Tap:        true,
+	}
+	epCfg.ID = "orange-vm1"
+	epCfg.StateDriver = fakeStateDriver
+	if err := epCfg.Write(); err != nil {
+		t.Fatalf("error writing endpoint state. Error: %s", err)
+	}
+
+	if err := p.AttachEndpoint("orange-vm1", "/proc/self/ns/net"); err == nil {
+		t.Fatalf("attach of a tap endpoint to a netns succeeded")
+	}
+	if nd.wired["orange-vm1"] {
+		t.Fatalf("tap endpoint wired on a failed attach")
+	}
+
+	if err := p.AttachEndpoint("orange-vm1", ""); err != nil {
+		t.Fatalf("error attaching tap endpoint. Error: %s", err)
+	}
+	if !nd.wired["orange-vm1"] {
+		t.Fatalf("tap endpoint not wired after attach")
+	}
+}
+
+func TestPickIntfName(t *testing.T) {
+	names := parseLinkNames("1: lo: <LOOPBACK,UP,LOWER_UP> mtu 65536 qdisc noqueue state UNKNOWN\n" +
+		"2: eth0@if12: <BROADCAST,MULTICAST,UP,LOWER_UP> mtu 1500 qdisc noqueue state UP\n" +
+		"3: net0@if14: <BROADCAST,MULTICAST,UP,LOWER_UP> mtu 1450 qdisc noqueue state UP\n")
+	if !reflect.DeepEqual(names, []string{"lo", "eth0", "net0"}) {
+		t.Fatalf("unexpected interface names %v", names)
+	}
+
+	if name, err := pickIntfName("", "/var/run/netns/orange", names); err != nil || name != "net1" {
+		t.Fatalf("unexpected auto name %q, err %v", name, err)
+	}
+	if name, err := pickIntfName("eth1", "/var/run/netns/orange", names); err != nil || name != "eth1" {
+		t.Fatalf("requested name not honored: %q, err %v", name, err)
+	}
+	_, err := pickIntfName("eth0", "/var/run/netns/orange", names)
+	if collision, ok := err.(*IntfNameCollisionError); !ok || collision.Name != "eth0" {
+		t.Fatalf("expected a collision on eth0, got %v", err)
+	}
+}
+
+// intfMoverDriver moves the interfaces of the endpoints it wires to their netns
+type intfMoverDriver struct {
+	wiringDriver
+	moved map[string]string
+}
+
+func (d *intfMoverDriver) MoveEndpointIntf(id, nsPath, name string) error {
+	d.moved[id] = name
+	return nil
+}
+
+func TestAttachEndpointIntfName(t *testing.T) {
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	nd := &intfMoverDriver{wiringDriver: wiringDriver{wired: map[string]bool{}}, moved: map[string]string{}}
+	p := &NetPlugin{StateDriver: fakeStateDriver, NetworkDriver: nd}
+
+	nwCfg := &mastercfg.CfgNetworkState{}
+	nwCfg.ID = "orange.default"
+	nwCfg.StateDriver = fakeStateDriver
+	if err := nwCfg.Write(); err != nil {
+		t.Fatalf("error writing network state. Error: %s", err)
+	}
+
+	for _, ep := range []struct{ id, intfName string }{{"orange-ep1", "lo"}, {"orange-ep2", ""}} {
+		epCfg := &mastercfg.CfgEndpointState{
+			NetID:    "orange.default",
+			IntfName: ep.intfName,
+			Status:   mastercfg.EndpointStatusDetached,
+		}
+		epCfg.ID = ep.id
+		epCfg.StateDriver = fakeStateDriver
+		if err := epCfg.Write(); err != nil {
+			t.Fatalf("error writing endpoint state. Error: %s", err)
+		}
+	}
+
+	// lo is taken in every netns
+	err := p.AttachEndpoint("orange-ep1", "/proc/self/ns/net")
+	if _, ok := err.(*IntfNameCollisionError); !ok {
+		t.Fatalf("expected a name collision, got %v", err)
+	}
+	if nd.wired["orange-ep1"] {
+		t.Fatalf("endpoint wired after a name collision")
+	}
+
+	if err := p.AttachEndpoint("orange-ep2", "/proc/self/ns/net"); err != nil {
+		t.Fatalf("error attaching endpoint. Error: %s", err)
+	}
+	epCfg := &mastercfg.CfgEndpointState{}
+	epCfg.StateDriver = fakeStateDriver
+	if err := epCfg.Read("orange-ep2"); err != nil {
+		t.Fatalf("error reading endpoint state. Error: %s", err)
+	}
+	if !strings.HasPrefix(epCfg.IntfName, "net") || nd.moved["orange-ep2"] != epCfg.IntfName {
+		t.Fatalf("unexpected interface name %q, moved as %q", epCfg.IntfName, nd.moved["orange-ep2"])
+	}
+	if !epCfg.IntfNameAuto {
+		t.Fatalf("picked interface name %q not recorded as picked", epCfg.IntfName)
+	}
+}
+
+func TestAttachPodEndpoint(t *testing.T) {
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	nd := &wiringDriver{wired: map[string]bool{}}
+	p := &NetPlugin{StateDriver: fakeStateDriver, NetworkDriver: nd,
+		ContainerRuntime: fakeRuntime{"app1": "/proc/10/ns/net", "pause1": "/proc/10/ns/net"}}
+
+	nwCfg := &mastercfg.CfgNetworkState{}
+	nwCfg.ID = "orange.default"
+	nwCfg.StateDriver = fakeStateDriver
+	if err := nwCfg.Write(); err != nil {
+		t.Fatalf("error writing network state. Error: %s", err)
+	}
+
+	epCfg := &mastercfg.CfgEndpointState{NetID: "orange.default", Status: mastercfg.EndpointStatusDetached}
+	epCfg.ID = "orange-pod1"
+	epCfg.StateDriver = fakeStateDriver
+	if err := epCfg.Write(); err != nil {
+		t.Fatalf("error writing endpoint state. Error: %s", err)
+	}
+
+	// endpoint state is still managed with the runtime down
+	down := &NetPlugin{StateDriver: fakeStateDriver, NetworkDriver: nd, ContainerRuntime: fakeRuntime(nil)}
+	if err := down.AttachPodEndpoint("orange-pod1", "app1"); err != ErrContainerRuntimeUnavailable {
+		t.Fatalf("expected ErrContainerRuntimeUnavailable, got %v", err)
+	}
+	if _, err := down.FetchNetwork("orange.default"); err != nil {
+		t.Fatalf("error fetching network with the runtime down. Error: %s", err)
+	}
+
+	if err := p.AttachPodEndpoint("orange-pod1", "unknown"); err == nil {
+		t.Fatalf("attach to an unknown pod succeeded")
+	}
+	if nd.wired["orange-pod1"] {
+		t.Fatalf("endpoint wired after a failed attach")
+	}
+
+	if err := p.AttachPodEndpoint("orange-pod1", "app1"); err != nil {
+		t.Fatalf("error attaching pod endpoint. Error: %s", err)
+	}
+	if !nd.wired["orange-pod1"] {
+		t.Fatalf("pod endpoint not wired after attach")
+	}
+}
+
+func TestExportImportEndpoint(t *testing.T) {
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	nd := &wiringDriver{wired: map[string]bool{"orange.default-ep1": true}}
+	src := &NetPlugin{StateDriver: fakeStateDriver, NetworkDriver: nd}
+	src.PluginConfig.Instance.HostLabel = "host1"
+	dst := &NetPlugin{StateDriver: fakeStateDriver, NetworkDriver: nd}
+	dst.PluginConfig.Instance.HostLabel = "host2"
+
+	nwCfg := &mastercfg.CfgNetworkState{Tenant: "default", NetworkName: "orange"}
+	nwCfg.ID = "orange.default"
+	nwCfg.StateDriver = fakeStateDriver
+	if err := nwCfg.Write(); err != nil {
+		t.Fatalf("error writing network state. Error: %s", err)
+	}
+
+	epCfg := &mastercfg.CfgEndpointState{
+		NetID:      "orange.default",
+		EndpointID: "ep1",
+		IPAddress:  "10.1.1.2",
+		MacAddress: "02:02:0a:01:01:02",
+		HomingHost: "host1",
+		Routes:     []mastercfg.EndpointRoute{{Dest: "10.2.0.0/16", Gateway: "10.1.1.1"}},
+		Status:     mastercfg.EndpointStatusAttached,
+	}
+	epCfg.ID = "orange.default-ep1"
+	epCfg.StateDriver = fakeStateDriver
+	if err := epCfg.Write(); err != nil {
+		t.Fatalf("error writing endpoint state. Error: %s", err)
+	}
+
+	data, err := src.ExportEndpoint(epCfg.ID)
+	if err != nil {
+		t.Fatalf("error exporting endpoint. Error: %s", err)
+	}
+	if nd.wired[epCfg.ID] {
+		t.Fatalf("endpoint still wired after export")
+	}
+
+	for _, bad := range []string{"", `{"version": 2}`, `{"version": 1, "endpoint": {"id": "x"}}`} {
+		if _, err := dst.ImportEndpoint([]byte(bad)); err == nil {
+			t.Fatalf("import of %q succeeded", bad)
+		}
+	}
+
+	id, err := dst.ImportEndpoint(data)
+	if err != nil {
+		t.Fatalf("error importing endpoint. Error: %s", err)
+	}
+	if id != epCfg.ID {
+		t.Fatalf("endpoint imported as %s, expected %s", id, epCfg.ID)
+	}
+
+	ep := &mastercfg.CfgEndpointState{}
+	ep.StateDriver = fakeStateDriver
+	if err := ep.Read(id); err != nil {
+		t.Fatalf("imported endpoint not found. Error: %s", err)
+	}
+	if ep.HomingHost != "host2" || ep.Status != mastercfg.EndpointStatusDetached {
+		t.Fatalf("unexpected imported endpoint %+v", ep)
+	}
+	if ep.IPAddress != epCfg.IPAddress || ep.MacAddress != epCfg.MacAddress ||
+		!reflect.DeepEqual(ep.Routes, epCfg.Routes) {
+		t.Fatalf("endpoint spec changed by migration: %+v", ep)
+	}
+}
+
+func TestGetNetworkEndpointCount(t *testing.T) {
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	for netID, count := range map[string]int{"orange.default": 2, "orange.default-1": 1,
+		"blue.default": 0} {
+		nwCfg := &mastercfg.CfgNetworkState{EpCount: count}
+		nwCfg.ID = netID
+		nwCfg.StateDriver = fakeStateDriver
+		if err := nwCfg.Write(); err != nil {
+			t.Fatalf("error writing network state. Error: %s", err)
+		}
+	}
+
+	p := &NetPlugin{StateDriver: fakeStateDriver}
+	for netID, expCount := range map[string]int{"orange.default": 2, "orange.default-1": 1,
+		"blue.default": 0} {
+		count, err := p.GetNetworkEndpointCount(netID)
+		if err != nil {
+			t.Fatalf("error counting endpoints of %s. Error: %s", netID, err)
+		}
+		if count != expCount {
+			t.Fatalf("expected %d endpoints on %s, got %d", expCount, netID, count)
+		}
+	}
+
+	if _, err := p.GetNetworkEndpointCount("green.default"); err == nil {
+		t.Fatalf("endpoints of a missing network counted")
+	}
+	if _, err := (&NetPlugin{}).GetNetworkEndpointCount("orange.default"); err != ErrNotInitialized {
+		t.Fatalf("expected ErrNotInitialized, got %v", err)
+	}
+}
+
+func TestGetIPPoolUtilization(t *testing.T) {
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	nwCfg := &mastercfg.CfgNetworkState{SubnetIP: "10.1.1.0", SubnetLen: 29,
+		IPAddrRange: "10.1.1.0-10.1.1.7"}
+	nwCfg.ID = "orange.default"
+	nwCfg.StateDriver = fakeStateDriver
+	netutils.InitSubnetBitset(&nwCfg.IPAllocMap, nwCfg.SubnetLen)
+	nwCfg.IPAllocMap.Set(1)
+	nwCfg.IPAllocMap.Set(6)
+	if err := nwCfg.Write(); err != nil {
+		t.Fatalf("error writing network state. Error: %s", err)
+	}
+
+	p := &NetPlugin{StateDriver: fakeStateDriver}
+	used, total, err := p.GetIPPoolUtilization("orange.default")
+	if err != nil {
+		t.Fatalf("error getting the pool utilization. Error: %s", err)
+	}
+	if used != 2 || total != 6 {
+		t.Fatalf("expected 2/6 addresses used, got %d/%d", used, total)
+	}
+
+	if _, _, err := p.GetIPPoolUtilization("blue.default"); err == nil {
+		t.Fatalf("utilization of a missing network succeeded")
+	}
+	if _, _, err := (&NetPlugin{}).GetIPPoolUtilization("orange.default"); err != ErrNotInitialized {
+		t.Fatalf("expected ErrNotInitialized, got %v", err)
+	}
+}
+
+func TestAttachEndpointHooks(t *testing.T) {
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	nd := &wiringDriver{wired: map[string]bool{}}
+	p := &NetPlugin{StateDriver: fakeStateDriver, NetworkDriver: nd}
+
+	epCfg := &mastercfg.CfgEndpointState{
+		NetID:     "orange.default",
+		IPAddress: "10.1.1.2",
+		Status:    mastercfg.EndpointStatusDetached,
+	}
+	epCfg.ID = "orange-ep1"
+	epCfg.StateDriver = fakeStateDriver
+	if err := epCfg.Write(); err != nil {
+		t.Fatalf("error writing endpoint state. Error: %s", err)
+	}
+
+	preErr := fmt.Errorf("sysctl failed")
+	hookCalls := []string{}
+	p.AddPreAttachHook("sysctl", func(ns netns.NsHandle, ep *mastercfg.CfgEndpointState) error {
+		hookCalls = append(hookCalls, "pre")
+		if ns.IsOpen() || ep.IPAddress != epCfg.IPAddress {
+			t.Fatalf("unexpected hook arguments %v, %+v", ns, ep)
+		}
+		return preErr
+	})
+	p.AddPostAttachHook("ethtool", func(ns netns.NsHandle, ep *mastercfg.CfgEndpointState) error {
+		hookCalls = append(hookCalls, "post")
+		if !nd.wired[ep.ID] {
+			t.Fatalf("post-attach hook ran before the endpoint was wired up")
+		}
+		return fmt.Errorf("ethtool failed")
+	})
+
+	// a failing pre-attach hook fails the attach
+	if err := p.AttachEndpoint("orange-ep1", ""); err == nil {
+		t.Fatalf("attach succeeded with a failing pre-attach hook")
+	}
+	if nd.wired["orange-ep1"] || strings.Join(hookCalls, ",") != "pre" {
+		t.Fatalf("attach went on after the pre-attach hook failed, hooks run: %v", hookCalls)
+	}
+
+	// a failing post-attach hook is only logged
+	preErr = nil
+	hookCalls = nil
+	if err := p.AttachEndpoint("orange-ep1", ""); err != nil {
+		t.Fatalf("error attaching endpoint. Error: %s", err)
+	}
+	if strings.Join(hookCalls, ",") != "pre,post" {
+		t.Fatalf("unexpected hooks run: %v", hookCalls)
+	}
+
+	ep := &mastercfg.CfgEndpointState{}
+	ep.StateDriver = fakeStateDriver
+	if err := ep.Read("orange-ep1"); err != nil || ep.Status != mastercfg.EndpointStatusAttached {
+		t.Fatalf("endpoint not attached, status %q. Error: %v", ep.Status, err)
+	}
+}
+
+func TestRouteArgs(t *testing.T) {
+	testData := []struct {
+		route mastercfg.EndpointRoute
+		args  string
+	}{
+		{mastercfg.EndpointRoute{Dest: "10.2.0.0/16", Gateway: "10.1.1.1"},
+			"-4 route replace 10.2.0.0/16 via 10.1.1.1 dev eth0"},
+		{mastercfg.EndpointRoute{Dest: "10.2.0.0/16", NextHops: []mastercfg.RouteNextHop{
+			{Gateway: "10.1.1.1", Weight: 3}, {Gateway: "10.1.1.2"}}},
+			"-4 route replace 10.2.0.0/16 nexthop via 10.1.1.1 dev eth0 weight 3 nexthop via 10.1.1.2 dev eth0 weight 1"},
+		{mastercfg.EndpointRoute{Dest: "2017::/64", NextHops: []mastercfg.RouteNextHop{{Gateway: "2016::1"}}},
+			"-6 route replace 2017::/64 via 2016::1 dev eth0"},
+	}
+
+	for _, d := range testData {
+		args := strings.Join(routeArgs(d.route, "eth0"), " ")
+		if args != d.args {
+			t.Fatalf("route %+v: expected args %q, got %q", d.route, d.args, args)
+		}
+	}
+
+	nwCfg := &mastercfg.CfgNetworkState{SubnetIP: "10.1.1.0", SubnetLen: 24}
+	ep := &mastercfg.CfgEndpointState{IntfName: "eth0", Routes: []mastercfg.EndpointRoute{
+		{Dest: "10.2.0.0/16", NextHops: []mastercfg.RouteNextHop{{Gateway: "10.1.1.1"}, {Gateway: "10.3.1.1"}}},
+	}}
+	if _, ok := checkRouteNextHops(nwCfg, ep).(*RouteError); !ok {
+		t.Fatalf("unreachable next hop accepted")
+	}
+	ep.Routes[0].NextHops[1].Gateway = "10.1.1.2"
+	if err := checkRouteNextHops(nwCfg, ep); err != nil {
+		t.Fatalf("error checking next hops. Error: %s", err)
+	}
+}
+
+func TestParseArpingReplies(t *testing.T) {
+	out := `ARPING 10.1.1.2 from 0.0.0.0 eth0
+Unicast reply from 10.1.1.2 [02:02:0A:01:01:02]  0.612ms
+Unicast reply from 10.1.1.2 [52:54:00:12:34:56]  0.701ms
+Unicast reply from 10.1.1.2 [52:54:00:12:34:56]  0.695ms
+Sent 2 probes (2 broadcast(s))
+Received 3 response(s)
+`
+	macs := parseArpingReplies(out, "02:02:0a:01:01:02")
+	if !reflect.DeepEqual(macs, []string{"52:54:00:12:34:56"}) {
+		t.Fatalf("unexpected conflicting macs %v", macs)
+	}
+
+	if macs := parseArpingReplies("Sent 2 probes (2 broadcast(s))\nReceived 0 response(s)\n", ""); len(macs) != 0 {
+		t.Fatalf("unexpected conflicting macs %v without replies", macs)
+	}
+
+	// without a netns the address can not be probed
+	nwCfg := &mastercfg.CfgNetworkState{DupAddrDetect: mastercfg.DupAddrDetectFail}
+	ep := &mastercfg.CfgEndpointState{IPAddress: "10.1.1.2"}
+	if err := detectDupAddr("", nwCfg, ep); err == nil {
+		t.Fatalf("address probed without a netns")
+	}
+	nwCfg.DupAddrDetect = ""
+	if err := detectDupAddr("", nwCfg, ep); err != nil {
+		t.Fatalf("address probed with detection off. Error: %s", err)
+	}
+}
+
+func TestParseEthtoolFeatures(t *testing.T) {
+	out := `Features for eth0:
+rx-checksumming: on
+tx-checksumming: on
+	tx-checksum-ipv4: off [fixed]
+tcp-segmentation-offload: on
+large-receive-offload: off [fixed]
+`
+	features := parseEthtoolFeatures(out)
+	expFeatures := map[string]bool{
+		"rx-checksumming":          false,
+		"tx-checksumming":          false,
+		"tx-checksum-ipv4":         true,
+		"tcp-segmentation-offload": false,
+		"large-receive-offload":    true,
+	}
+	if len(features) != len(expFeatures) {
+		t.Fatalf("expected features %v, got %v", expFeatures, features)
+	}
+	for name, fixed := range expFeatures {
+		if f, ok := features[name]; !ok || f != fixed {
+			t.Fatalf("expected features %v, got %v", expFeatures, features)
+		}
+	}
+
+	ep := &mastercfg.CfgEndpointState{Sysctls: map[string]string{"net.ipv4.conf.eth0.rp_filter": "2"}}
+	if err := applyIntfSettings("", ep); err == nil {
+		t.Fatalf("applied interface settings without a netns")
+	}
+
+	// interface settings need the interface moved to the netns
+	nd := &wiringDriver{wired: map[string]bool{}}
+	if err := checkIntfMoved(nd, "/proc/self/ns/net", nil, ep); err == nil {
+		t.Fatalf("interface sysctl applied to an interface left in the host netns")
+	}
+	mover := &intfMoverDriver{wiringDriver: *nd, moved: map[string]string{}}
+	if err := checkIntfMoved(mover, "/proc/self/ns/net", nil, ep); err != nil {
+		t.Fatalf("interface sysctl refused on a moved interface. Error: %s", err)
+	}
+	ep.Sysctls = map[string]string{"net.ipv4.tcp_syncookies": "1", "net.ipv4.conf.all.rp_filter": "2"}
+	if err := checkIntfMoved(nd, "/proc/self/ns/net", nil, ep); err != nil {
+		t.Fatalf("netns sysctl refused. Error: %s", err)
+	}
+	ep.EthtoolFeatures = map[string]bool{"tso": false}
+	if err := checkIntfMoved(nd, "/proc/self/ns/net", nil, ep); err == nil {
+		t.Fatalf("ethtool features applied to an interface left in the host netns")
+	}
+	ep.EthtoolFeatures = nil
+	ep.Routes = []mastercfg.EndpointRoute{{Dest: "10.2.0.0/16", NextHops: []mastercfg.RouteNextHop{
+		{Gateway: "10.1.1.1", Weight: 1}, {Gateway: "10.1.1.2", Weight: 2}}}}
+	if err := checkIntfMoved(nd, "/proc/self/ns/net", nil, ep); err == nil {
+		t.Fatalf("routes added through an interface left in the host netns")
+	}
+	if err := checkIntfMoved(mover, "/proc/self/ns/net", nil, ep); err != nil {
+		t.Fatalf("routes refused on a moved interface. Error: %s", err)
+	}
+
+	nwCfg := &mastercfg.CfgNetworkState{DupAddrDetect: mastercfg.DupAddrDetectWarn}
+	ep = &mastercfg.CfgEndpointState{IPAddress: "10.1.1.2"}
+	if err := checkIntfMoved(nd, "/proc/self/ns/net", nwCfg, ep); err == nil {
+		t.Fatalf("address probed from an interface left in the host netns")
+	}
+	if err := checkIntfMoved(mover, "/proc/self/ns/net", nwCfg, ep); err != nil {
+		t.Fatalf("address probe refused on a moved interface. Error: %s", err)
+	}
+}
+
+func TestMergeStoredConfig(t *testing.T) {
+	bootstrap := Config{
+		Drivers:  Drivers{Network: "ovs", State: "etcd"},
+		Instance: core.InstanceInfo{HostLabel: "host1", DbURL: "etcd://127.0.0.1:2379", VtepIP: "10.0.0.1"},
+	}
+
+	stored := `{"drivers": {"network": "vpp", "state": "consul"},
+		"plugin-instance": {"host-label": "host2", "db-url": "consul://1.2.3.4:8500", "fwd-mode": "routing"}}`
+	cfg, err := mergeStoredConfig(bootstrap, []byte(stored))
+	if err != nil {
+		t.Fatalf("error merging config. Error: %s", err)
+	}
+	if cfg.Drivers.Network != "vpp" || cfg.Instance.FwdMode != "routing" {
+		t.Fatalf("stored settings not applied: %+v", cfg)
+	}
+	if cfg.Drivers.State != "etcd" || cfg.Instance.DbURL != bootstrap.Instance.DbURL ||
+		cfg.Instance.HostLabel != "host1" {
+		t.Fatalf("bootstrap settings overridden: %+v", cfg)
+	}
+	if cfg.Instance.VtepIP != "10.0.0.1" {
+		t.Fatalf("unset stored settings did not keep the bootstrap value: %+v", cfg)
+	}
+
+	if _, err := mergeStoredConfig(bootstrap, []byte("{")); err == nil {
+		t.Fatalf("merged an invalid config")
+	}
+
+	typo := `{"drivers": {"netwrok": "vpp"}, "plugin-instance": {"fwd-mode": "routing"}}`
+	_, err = mergeStoredConfig(bootstrap, []byte(typo))
+	if err == nil || !strings.Contains(err.Error(), "drivers.netwrok") {
+		t.Fatalf("unexpected error merging a config with unknown fields: %v", err)
+	}
+	bootstrap.LooseConfig = true
+	cfg, err = mergeStoredConfig(bootstrap, []byte(typo))
+	if err != nil || cfg.Instance.FwdMode != "routing" || !cfg.LooseConfig {
+		t.Fatalf("loose config not merged: %+v. Error: %v", cfg, err)
+	}
+}
+
+func TestInitFromStore(t *testing.T) {
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	if err := fakeStateDriver.Write(ConfigKey, []byte(`{"observer": true}`)); err != nil {
+		t.Fatalf("error writing plugin config. Error: %s", err)
+	}
+
+	bootstrap := Config{
+		Drivers:         Drivers{Network: "ovs", State: "fakedriver"},
+		Instance:        core.InstanceInfo{HostLabel: "host1"},
+		ConfigFromStore: true,
+	}
+	p := &NetPlugin{}
+	if err := p.InitFromStore(bootstrap); err != nil {
+		t.Fatalf("error initializing from the store. Error: %s", err)
+	}
+	if !p.PluginConfig.Observer || p.PluginConfig.Instance.HostLabel != "host1" {
+		t.Fatalf("stored config not loaded: %+v", p.PluginConfig)
+	}
+
+	// reloading the current config is a no-op
+	if err := p.Reload(p.PluginConfig); err != nil {
+		t.Fatalf("error reloading the same config. Error: %s", err)
+	}
+
+	cfg := p.PluginConfig
+	cfg.Observer = false
+	if err := p.Reload(cfg); err == nil {
+		t.Fatalf("reload switched off observer mode")
+	}
+}
+
+// resyncDriver records the calls made by a network resync
+type resyncDriver struct {
+	wiringDriver
+	calls []string
+}
+
+func (d *resyncDriver) CreateNetwork(id string) error {
+	d.calls = append(d.calls, "network "+id)
+	return nil
+}
+
+func (d *resyncDriver) CreateEndpoint(id string) error {
+	d.calls = append(d.calls, "create "+id)
+	return d.wiringDriver.CreateEndpoint(id)
+}
+
+func (d *resyncDriver) DeleteEndpoint(id string) error {
+	d.calls = append(d.calls, "delete "+id)
+	return d.wiringDriver.DeleteEndpoint(id)
+}
+
+func (d *resyncDriver) CreateRemoteEndpoint(id string) error {
+	d.calls = append(d.calls, "remote "+id)
+	return nil
+}
+
+func TestResyncNetwork(t *testing.T) {
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	nd := &resyncDriver{wiringDriver: wiringDriver{wired: map[string]bool{}}}
+	md := &resyncDriver{wiringDriver: wiringDriver{wired: map[string]bool{}}}
+	p := &NetPlugin{StateDriver: fakeStateDriver, NetworkDriver: nd,
+		endpointDrivers: map[string]core.NetworkDriver{"macvlan": md}}
+	p.PluginConfig.Instance.HostLabel = "host1"
+
+	if err := p.ResyncNetwork("orange.default"); err == nil {
+		t.Fatalf("resync of an unknown network succeeded")
+	}
+
+	for _, id := range []string{"orange.default", "blue.default"} {
+		nwCfg := &mastercfg.CfgNetworkState{}
+		nwCfg.ID = id
+		nwCfg.StateDriver = fakeStateDriver
+		if err := nwCfg.Write(); err != nil {
+			t.Fatalf("error writing network state. Error: %s", err)
+		}
+	}
+
+	epCfgs := []mastercfg.CfgEndpointState{
+		{NetID: "orange.default", HomingHost: "host1"},
+		{NetID: "orange.default", HomingHost: "host1", Status: mastercfg.EndpointStatusDetached},
+		{NetID: "orange.default", HomingHost: "host2"},
+		{NetID: "blue.default", HomingHost: "host1"},
+		{NetID: "orange.default", HomingHost: "host2", HostEndpoint: true},
+		{NetID: "orange.default", HomingHost: "host1", Driver: "macvlan"},
+	}
+	for i, id := range []string{"orange-local", "orange-detached", "orange-remote", "blue-local", "orange-hostep", "orange-macvlan"} {
+		epCfgs[i].ID = id
+		epCfgs[i].StateDriver = fakeStateDriver
+		if err := epCfgs[i].Write(); err != nil {
+			t.Fatalf("error writing endpoint state. Error: %s", err)
+		}
+	}
+
+	// orange-gone has oper state but its config was deleted, the oper state
+	// of host2 is left to host2
+	operEps := map[string]string{
+		"orange-local":      "orange.default",
+		"orange-detached":   "orange.default",
+		"orange-gone":       "orange.default",
+		"orange-remote":     "orange.default",
+		"orange-remotegone": "orange.default",
+		"blue-local":        "blue.default",
+		// a host endpoint of host2 left wired here is not addressed here
+		"orange-hostep":  "orange.default",
+		"orange-macvlan": "orange.default",
+	}
+	for id, netID := range operEps {
+		epOper := &drivers.OperEndpointState{NetID: netID, HomingHost: "host1"}
+		if strings.HasPrefix(id, "orange-remote") {
+			epOper.HomingHost = "host2"
+		}
+		epOper.ID = id
+		epOper.StateDriver = fakeStateDriver
+		if err := epOper.Write(); err != nil {
+			t.Fatalf("error writing endpoint oper state. Error: %s", err)
+		}
+	}
+
+	if err := p.ResyncNetwork("orange.default"); err != nil {
+		t.Fatalf("error resyncing network. Error: %s", err)
+	}
+
+	sort.Strings(nd.calls)
+	expCalls := []string{
+		"create orange-hostep",
+		"create orange-local",
+		"delete orange-detached",
+		"delete orange-gone",
+		"network orange.default",
+		"remote orange-remote",
+	}
+	if !reflect.DeepEqual(nd.calls, expCalls) {
+		t.Fatalf("unexpected resync calls %v, expected %v", nd.calls, expCalls)
+	}
+	// endpoints of another driver are reprogrammed by it
+	sort.Strings(md.calls)
+	expCalls = []string{"create orange-macvlan", "network orange.default"}
+	if !reflect.DeepEqual(md.calls, expCalls) {
+		t.Fatalf("unexpected resync calls %v of the endpoint driver, expected %v", md.calls, expCalls)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	if _, err := newTokenBucket(RateLimitConfig{Rate: -1}); err == nil {
+		t.Fatalf("negative rate accepted")
+	}
+	if b, err := newTokenBucket(RateLimitConfig{}); err != nil || b != nil {
+		t.Fatalf("zero rate is not unlimited: %v %v", b, err)
+	}
+
+	b, err := newTokenBucket(RateLimitConfig{Rate: 2, Burst: 2})
+	if err != nil {
+		t.Fatalf("error creating bucket. Error: %s", err)
+	}
+	now := time.Now()
+	b.last = now
+	b.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if wait, ok := b.reserve(0); !ok || wait != 0 {
+			t.Fatalf("burst operation %d limited: %v %v", i, wait, ok)
+		}
+	}
+	if _, ok := b.reserve(0); ok {
+		t.Fatalf("operation over the burst allowed")
+	}
+	if wait, ok := b.reserve(time.Second); !ok || wait != 500*time.Millisecond {
+		t.Fatalf("unexpected wait %v %v", wait, ok)
+	}
+
+	// the waiting operation took the next token
+	now = now.Add(time.Second)
+	if wait, ok := b.reserve(0); !ok || wait != 0 {
+		t.Fatalf("refilled operation limited: %v %v", wait, ok)
+	}
+	if _, ok := b.reserve(0); ok {
+		t.Fatalf("operation over the refill allowed")
+	}
+
+	p := NetPlugin{limiter: b}
+	if err := p.CreateNetwork("net"); err != ErrRateLimited {
+		t.Fatalf("unexpected create error %v", err)
+	}
+	if _, err := p.FetchNetwork("net"); err != ErrNotInitialized {
+		t.Fatalf("read was rate limited: %v", err)
+	}
+}
+
+func TestReloadRateLimit(t *testing.T) {
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	p := &NetPlugin{StateDriver: fakeStateDriver}
+	p.PluginConfig.Drivers.Network = "fakedriver"
+	p.PluginConfig.Instance.StateDriver = fakeStateDriver
+
+	cfg := p.PluginConfig
+	cfg.RateLimit = RateLimitConfig{Rate: -1}
+	if err := p.Reload(cfg); err == nil {
+		t.Fatalf("invalid rate limit reloaded")
+	}
+
+	cfg.RateLimit = RateLimitConfig{Rate: 1}
+	if err := p.Reload(cfg); err != nil {
+		t.Fatalf("error reloading the rate limit. Error: %s", err)
+	}
+	if p.limiter == nil {
+		t.Fatalf("rate limit not applied by the reload")
+	}
+	if err := p.CreateNetwork("net"); err == ErrRateLimited {
+		t.Fatalf("first operation rate limited")
+	}
+	if err := p.CreateNetwork("net"); err != ErrRateLimited {
+		t.Fatalf("unexpected create error %v", err)
+	}
+
+	cfg.RateLimit = RateLimitConfig{}
+	if err := p.Reload(cfg); err != nil {
+		t.Fatalf("error reloading the rate limit. Error: %s", err)
+	}
+	if p.limiter != nil {
+		t.Fatalf("rate limit kept after the reload")
+	}
+}
+
+type linkDriver struct {
+	drivers.FakeNetEpDriver
+	handler func(endpointID string, up bool)
+}
+
+func (d *linkDriver) WatchEndpointLinks(handler func(endpointID string, up bool)) {
+	d.handler = handler
+}
+
+func TestEndpointLinkMonitor(t *testing.T) {
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	nd := &linkDriver{}
+	p := &NetPlugin{StateDriver: fakeStateDriver, NetworkDriver: nd}
+	p.startLinkMonitor()
+	if nd.handler != nil {
+		t.Fatalf("link monitor started while disabled")
+	}
+	p.PluginConfig.MonitorLinks = true
+	p.startLinkMonitor()
+	defer p.stopLinkMonitor()
+	if nd.handler == nil {
+		t.Fatalf("link monitor not started")
+	}
+
+	for id, status := range map[string]string{
+		"orange-ep1": mastercfg.EndpointStatusAttached,
+		"orange-ep2": mastercfg.EndpointStatusDetached,
+	} {
+		epCfg := &mastercfg.CfgEndpointState{NetID: "orange.default", Status: status}
+		epCfg.ID = id
+		epCfg.StateDriver = fakeStateDriver
+		if err := epCfg.Write(); err != nil {
+			t.Fatalf("error writing endpoint state. Error: %s", err)
+		}
+	}
+
+	hookCalls := make(chan string, 4)
+	p.AddLinkHook("record", func(ep *mastercfg.CfgEndpointState, up bool) {
+		hookCalls <- fmt.Sprintf("%s %v", ep.ID, up)
+	})
+
+	checkEp := func(id, status string) {
+		ep := &mastercfg.CfgEndpointState{}
+		ep.StateDriver = fakeStateDriver
+		if err := ep.Read(id); err != nil {
+			t.Fatalf("error reading endpoint state. Error: %s", err)
+		}
+		if ep.Status != status {
+			t.Fatalf("expected status %q of %s, got %q", status, id, ep.Status)
+		}
+	}
+	checkHook := func(exp string) {
+		select {
+		case call := <-hookCalls:
+			if call != exp {
+				t.Fatalf("unexpected link hook call %q, expected %q", call, exp)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("link hook not called for %q", exp)
+		}
+	}
+
+	nd.handler("orange-ep1", false)
+	checkHook("orange-ep1 false")
+	checkEp("orange-ep1", mastercfg.EndpointStatusLinkDown)
+
+	// detached and unknown endpoints are ignored
+	nd.handler("orange-ep2", false)
+	nd.handler("orange-ep3", false)
+	nd.handler("orange-ep1", true)
+	checkHook("orange-ep1 true")
+	checkEp("orange-ep1", mastercfg.EndpointStatusAttached)
+	checkEp("orange-ep2", mastercfg.EndpointStatusDetached)
+}
+
+func TestReloadLinkMonitor(t *testing.T) {
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	p := &NetPlugin{StateDriver: fakeStateDriver, Registry: utils.NewDriverRegistry()}
+	if err := p.Registry.RegisterNetworkDriver("link", reflect.TypeOf(linkDriver{})); err != nil {
+		t.Fatalf("error registering the network driver. Error: %s", err)
+	}
+	p.PluginConfig.Drivers.Network = "link"
+	p.PluginConfig.Instance.StateDriver = fakeStateDriver
+
+	// the reinit of the reload starts the monitor from the new config
+	cfg := p.PluginConfig
+	cfg.MonitorLinks = true
+	if err := p.Reload(cfg); err != nil {
+		t.Fatalf("error reloading the plugin config. Error: %s", err)
+	}
+	defer p.stopLinkMonitor()
+	nd, ok := p.NetworkDriver.(*linkDriver)
+	if !ok {
+		t.Fatalf("unexpected network driver %T", p.NetworkDriver)
+	}
+	if nd.handler == nil {
+		t.Fatalf("link monitor not started by the reload")
+	}
+}
+
+func TestEndpointLease(t *testing.T) {
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	nd := &wiringDriver{wired: map[string]bool{}}
+	p := &NetPlugin{StateDriver: fakeStateDriver, NetworkDriver: nd}
+	defer p.stopLeases()
+
+	nwCfg := &mastercfg.CfgNetworkState{Tenant: "default", NetworkName: "orange"}
+	nwCfg.ID = "orange.default"
+	nwCfg.StateDriver = fakeStateDriver
+	if err := nwCfg.Write(); err != nil {
+		t.Fatalf("error writing network state. Error: %s", err)
+	}
+
+	epCfg := &mastercfg.CfgEndpointState{NetID: "orange.default", IPAddress: "10.1.1.2", LeaseTTL: 30}
+	epCfg.ID = "orange.default-ep1"
+	epCfg.StateDriver = fakeStateDriver
+	if err := epCfg.Write(); err != nil {
+		t.Fatalf("error writing endpoint state. Error: %s", err)
+	}
+	lease := &mastercfg.EndpointLeaseState{TTL: 30, Expiry: time.Now().Unix() + 30}
+	lease.ID = epCfg.ID
+	lease.StateDriver = fakeStateDriver
+	if err := lease.Write(); err != nil {
+		t.Fatalf("error writing endpoint lease. Error: %s", err)
+	}
+
+	if err := p.CreateEndpoint(epCfg.ID); err != nil {
+		t.Fatalf("error creating endpoint. Error: %s", err)
+	}
+	if _, ok := p.leases[epCfg.ID]; !ok {
+		t.Fatalf("lease of endpoint not started")
+	}
+
+	if err := master.RenewEndpointLease(fakeStateDriver, epCfg.ID); err != nil {
+		t.Fatalf("error renewing the lease. Error: %s", err)
+	}
+
+	// endpoints deleted while their lease is live are not removed here
+	if err := p.EndpointLeaseExpired(epCfg); err != nil || !nd.wired[epCfg.ID] {
+		t.Fatalf("endpoint removed on a delete: %v", err)
+	}
+
+	lease.Expired = true
+	if err := lease.Write(); err != nil {
+		t.Fatalf("error writing endpoint lease. Error: %s", err)
+	}
+	if err := master.RenewEndpointLease(fakeStateDriver, epCfg.ID); err == nil {
+		t.Fatalf("expired lease renewed")
+	}
+	if err := p.EndpointLeaseExpired(epCfg); err != nil {
+		t.Fatalf("error removing expired endpoint. Error: %s", err)
+	}
+	if nd.wired[epCfg.ID] {
+		t.Fatalf("expired endpoint still wired")
+	}
+	if _, ok := p.leases[epCfg.ID]; ok {
+		t.Fatalf("lease of expired endpoint still held")
+	}
+
+	// a second expiry of the same endpoint is ignored
+	if err := p.EndpointLeaseExpired(epCfg); err != nil {
+		t.Fatalf("error on a repeated expiry. Error: %s", err)
+	}
+}
+
+func TestEndpointEvicted(t *testing.T) {
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	nd := &wiringDriver{wired: map[string]bool{}}
+	p := &NetPlugin{StateDriver: fakeStateDriver, NetworkDriver: nd}
+
+	nwCfg := &mastercfg.CfgNetworkState{Tenant: "default", NetworkName: "orange"}
+	nwCfg.ID = "orange.default"
+	nwCfg.StateDriver = fakeStateDriver
+	if err := nwCfg.Write(); err != nil {
+		t.Fatalf("error writing network state. Error: %s", err)
+	}
+
+	epCfg := &mastercfg.CfgEndpointState{NetID: "orange.default", IPAddress: "10.1.1.2"}
+	epCfg.ID = "orange.default-ep1"
+	epCfg.StateDriver = fakeStateDriver
+	if err := epCfg.Write(); err != nil {
+		t.Fatalf("error writing endpoint state. Error: %s", err)
+	}
+	if err := p.CreateEndpoint(epCfg.ID); err != nil {
+		t.Fatalf("error creating endpoint. Error: %s", err)
+	}
+
+	// endpoints deleted by their host are not removed here
+	if err := p.EndpointEvicted(epCfg); err != nil || !nd.wired[epCfg.ID] {
+		t.Fatalf("endpoint removed on a delete: %v", err)
+	}
+
+	epCfg.Evicted = true
+	if err := p.EndpointEvicted(epCfg); err != nil {
+		t.Fatalf("error removing evicted endpoint. Error: %s", err)
+	}
+	if nd.wired[epCfg.ID] {
+		t.Fatalf("evicted endpoint still wired")
+	}
+}
+
+func TestParsePingOutput(t *testing.T) {
+	out := `PING 10.1.1.3 (10.1.1.3) 56(84) bytes of data.
+64 bytes from 10.1.1.3: icmp_seq=1 ttl=64 time=0.061 ms
+64 bytes from 10.1.1.3: icmp_seq=3 ttl=64 time=0.049 ms
+
+--- 10.1.1.3 ping statistics ---
+3 packets transmitted, 2 received, 33% packet loss, time 2047ms
+rtt min/avg/max/mdev = 0.049/0.055/0.061/0.006 ms
+`
+	res := ConnResult{}
+	if !parsePingOutput(out, &res) {
+		t.Fatalf("ping output not parsed")
+	}
+	if !res.Reachable || res.Sent != 3 || res.Received != 2 || res.Latency != 55*time.Microsecond {
+		t.Fatalf("unexpected ping result %+v", res)
+	}
+
+	out = `--- 10.1.1.4 ping statistics ---
+3 packets transmitted, 0 received, 100% packet loss, time 2016ms
+`
+	res = ConnResult{}
+	if !parsePingOutput(out, &res) || res.Reachable || res.Latency != 0 {
+		t.Fatalf("unexpected ping result %+v", res)
+	}
+
+	if parsePingOutput("ping: unknown host", &ConnResult{}) {
+		t.Fatalf("ping error parsed as a result")
+	}
+}
+
+func TestTCPConnectivity(t *testing.T) {
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	p := &NetPlugin{StateDriver: fakeStateDriver}
+	p.PluginConfig.Instance.HostLabel = "host1"
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening. Error: %s", err)
+	}
+	defer ln.Close()
+	port := ln.Addr().(*net.TCPAddr).Port
+
+	for _, epCfg := range []*mastercfg.CfgEndpointState{
+		{HomingHost: "host1", IPAddress: "127.0.0.1", NetnsPath: "/proc/self/ns/net"},
+		{HomingHost: "host1", IPAddress: "127.0.0.1", Status: mastercfg.EndpointStatusDetached},
+		{HomingHost: "host2", IPAddress: "127.0.0.1"},
+		{HomingHost: "host2"},
+	} {
+		epCfg.ID = fmt.Sprintf("orange.default-ep%d", len(fakeStateDriver.TestState)+1)
+		epCfg.StateDriver = fakeStateDriver
+		if err := epCfg.Write(); err != nil {
+			t.Fatalf("error writing endpoint state. Error: %s", err)
+		}
+	}
+
+	res, err := p.TestTCPConnectivity("orange.default-ep1", "orange.default-ep3", port)
+	if err != nil {
+		t.Fatalf("error testing connectivity. Error: %s", err)
+	}
+	if !res.Reachable || res.DstIP != "127.0.0.1" || res.Received != 1 || res.Latency <= 0 {
+		t.Fatalf("unexpected connectivity result %+v", res)
+	}
+
+	ln.Close()
+	res, err = p.TestTCPConnectivity("orange.default-ep1", "orange.default-ep3", port)
+	if err != nil || res.Reachable || res.Reason == "" {
+		t.Fatalf("closed port reported reachable: %+v %v", res, err)
+	}
+
+	for _, d := range []struct{ src, dst string }{
+		{"orange.default-ep2", "orange.default-ep1"}, // detached
+		{"orange.default-ep3", "orange.default-ep1"}, // remote
+		{"orange.default-ep1", "orange.default-ep4"}, // no address
+		{"orange.default-ep1", "orange.default-ep5"}, // unknown
+	} {
+		if _, err := p.TestTCPConnectivity(d.src, d.dst, port); err == nil {
+			t.Fatalf("connectivity test from %s to %s succeeded", d.src, d.dst)
+		}
+	}
+	if _, err := p.TestTCPConnectivity("orange.default-ep1", "orange.default-ep3", 0); err == nil {
+		t.Fatalf("connectivity test to port 0 succeeded")
+	}
+}
+
+func TestHostEndpoint(t *testing.T) {
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	nd := &wiringDriver{wired: map[string]bool{}}
+	p := &NetPlugin{StateDriver: fakeStateDriver, NetworkDriver: nd}
+	p.PluginConfig.Instance.HostLabel = "host1"
+
+	nwCfg := &mastercfg.CfgNetworkState{Tenant: "default", NetworkName: "orange",
+		SubnetIP: "10.1.1.0", SubnetLen: 24, IPAM: mastercfg.IPAMDhcp}
+	nwCfg.ID = "orange.default"
+	nwCfg.StateDriver = fakeStateDriver
+	if err := nwCfg.Write(); err != nil {
+		t.Fatalf("error writing network state. Error: %s", err)
+	}
+	if _, err := p.CreateHostEndpoint("orange.default"); err == nil {
+		t.Fatalf("host endpoint created on a dhcp network")
+	}
+	if _, err := p.CreateHostEndpoint("blue.default"); err == nil {
+		t.Fatalf("host endpoint created on a missing network")
+	}
+
+	epCfg := &mastercfg.CfgEndpointState{NetID: "orange.default", IPAddress: "10.1.1.2",
+		HomingHost: "host1"}
+	epCfg.ID = "orange.default-ep1"
+	epCfg.StateDriver = fakeStateDriver
+	if err := epCfg.Write(); err != nil {
+		t.Fatalf("error writing endpoint state. Error: %s", err)
+	}
+	if err := p.DeleteHostEndpoint(epCfg.ID); err == nil {
+		t.Fatalf("container endpoint deleted as a host endpoint")
+	}
+
+	// the interface is configured in the netns of the test
+	intfName := "hosteptest0"
+	link := &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: intfName}, PeerName: "hosteptest1"}
+	if err := netlink.LinkAdd(link); err != nil {
+		t.Skipf("cannot create a veth interface: %v", err)
+	}
+	defer netlink.LinkDel(link)
+
+	epOper := &drivers.OperEndpointState{NetID: "orange.default", PortName: intfName}
+	epOper.ID = epCfg.ID
+	epOper.StateDriver = fakeStateDriver
+	if err := epOper.Write(); err != nil {
+		t.Fatalf("error writing endpoint oper state. Error: %s", err)
+	}
+
+	epCfg.HostEndpoint = true
+	// configuring twice, as resync does, keeps the address
+	for i := 0; i < 2; i++ {
+		if err := p.configureHostEndpoint(epCfg, nwCfg); err != nil {
+			t.Fatalf("error configuring host endpoint. Error: %s", err)
+		}
+	}
+	addrs, err := netlink.AddrList(link, netlink.FAMILY_V4)
+	if err != nil {
+		t.Fatalf("error listing addresses. Error: %s", err)
+	}
+	if len(addrs) != 1 || addrs[0].IPNet.String() != "10.1.1.2/24" {
+		t.Fatalf("unexpected addresses of the host endpoint: %v", addrs)
+	}
+}
+
+// interconnectDriver tracks the interconnects in the datapath
+type interconnectDriver struct {
+	resyncDriver
+	connected map[string]string
+}
+
+func (d *interconnectDriver) DeleteNetwork(id, subnet, nwType, encap string, pktTag, extPktTag int, gateway string, tenant string) error {
+	return nil
+}
+
+func (d *interconnectDriver) ConnectNetworks(id, netA, netB string) error {
+	d.connected[id] = netA + "," + netB
+	return nil
+}
+
+func (d *interconnectDriver) DisconnectNetworks(id string) error {
+	delete(d.connected, id)
+	return nil
+}
+
+func TestInterconnect(t *testing.T) {
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	p := &NetPlugin{StateDriver: fakeStateDriver, NetworkDriver: &wiringDriver{wired: map[string]bool{}}}
+	p.PluginConfig.Instance.HostLabel = "host1"
+	if err := p.ConnectNetworks("orange.default", "blue.default"); err == nil {
+		t.Fatalf("interconnect succeeded with a driver that does not support it")
+	}
+
+	nd := &interconnectDriver{connected: map[string]string{}}
+	nd.wired = map[string]bool{}
+	p.NetworkDriver = nd
+	if err := p.ConnectNetworks("orange.default", "blue.default"); err == nil {
+		t.Fatalf("interconnect of unknown networks succeeded")
+	}
+
+	for _, id := range []string{"orange.default", "blue.default"} {
+		nwCfg := &mastercfg.CfgNetworkState{}
+		nwCfg.ID = id
+		nwCfg.StateDriver = fakeStateDriver
+		if err := nwCfg.Write(); err != nil {
+			t.Fatalf("error writing network state. Error: %s", err)
+		}
+	}
+
+	if err := p.ConnectNetworks("orange.default", "orange.default"); err == nil {
+		t.Fatalf("interconnect of a network to itself succeeded")
+	}
+	if err := p.ConnectNetworks("orange.default", "blue.default"); err != nil {
+		t.Fatalf("error connecting networks. Error: %s", err)
+	}
+	if err := p.ConnectNetworks("blue.default", "orange.default"); err == nil {
+		t.Fatalf("second interconnect of the same networks succeeded")
+	}
+
+	id := mastercfg.InterconnectID("host1", "orange.default", "blue.default")
+	icCfg := &mastercfg.InterconnectState{}
+	icCfg.StateDriver = fakeStateDriver
+	if err := icCfg.Read(id); err != nil {
+		t.Fatalf("error reading interconnect state. Error: %s", err)
+	}
+	if nd.connected[id] != "orange.default,blue.default" {
+		t.Fatalf("unexpected interconnects in the datapath: %v", nd.connected)
+	}
+
+	// resync of either network rebuilds the interconnect
+	delete(nd.connected, id)
+	if err := p.ResyncNetwork("blue.default"); err != nil {
+		t.Fatalf("error resyncing network. Error: %s", err)
+	}
+	if nd.connected[id] != "orange.default,blue.default" {
+		t.Fatalf("interconnect not rebuilt by resync: %v", nd.connected)
+	}
+
+	if err := p.DisconnectNetworks("blue.default", "orange.default"); err != nil {
+		t.Fatalf("error disconnecting networks. Error: %s", err)
+	}
+	if len(nd.connected) != 0 {
+		t.Fatalf("interconnect left in the datapath: %v", nd.connected)
+	}
+	if err := icCfg.Read(id); err == nil {
+		t.Fatalf("interconnect state left after disconnect")
+	}
+	if err := p.DisconnectNetworks("blue.default", "orange.default"); err == nil {
+		t.Fatalf("disconnect of networks that are not connected succeeded")
+	}
+
+	// deleting a network removes its interconnects
+	if err := p.ConnectNetworks("orange.default", "blue.default"); err != nil {
+		t.Fatalf("error connecting networks. Error: %s", err)
+	}
+	if err := p.DeleteNetwork("orange.default", "", "", "", 0, 0, "", ""); err != nil {
+		t.Fatalf("error deleting network. Error: %s", err)
+	}
+	if len(nd.connected) != 0 {
+		t.Fatalf("interconnect left after network delete: %v", nd.connected)
+	}
+}
+
+// flowDriver tracks the custom flows in the datapath
+type flowDriver struct {
+	interconnectDriver
+	flows map[string]string
+}
+
+func (d *flowDriver) AddNetworkFlow(flow *mastercfg.NetworkFlowState) error {
+	d.flows[flow.ID] = flow.Match + "/" + flow.Actions
+	return nil
+}
+
+func (d *flowDriver) DeleteNetworkFlow(flow *mastercfg.NetworkFlowState) error {
+	delete(d.flows, flow.ID)
+	return nil
+}
+
+func TestNetworkFlows(t *testing.T) {
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	drop := FlowSpec{Name: "drop-telnet", Table: 0, Priority: 200, Match: "tcp,tp_dst=23", Actions: "drop"}
+	p := &NetPlugin{StateDriver: fakeStateDriver, NetworkDriver: &wiringDriver{wired: map[string]bool{}}}
+	p.PluginConfig.Instance.HostLabel = "host1"
+	if err := p.AddNetworkFlow("orange.default", drop); err == nil {
+		t.Fatalf("custom flow added with a driver that does not support it")
+	}
+
+	nd := &flowDriver{flows: map[string]string{}}
+	nd.wired = map[string]bool{}
+	nd.connected = map[string]string{}
+	p.NetworkDriver = nd
+	if err := p.AddNetworkFlow("orange.default", drop); err == nil {
+		t.Fatalf("custom flow added to an unknown network")
+	}
+
+	nwCfg := &mastercfg.CfgNetworkState{}
+	nwCfg.ID = "orange.default"
+	nwCfg.StateDriver = fakeStateDriver
+	if err := nwCfg.Write(); err != nil {
+		t.Fatalf("error writing network state. Error: %s", err)
+	}
+
+	for _, flow := range []FlowSpec{
+		{Name: "", Actions: "drop"},
+		{Name: "bad name", Actions: "drop"},
+		{Name: "f1", Table: 255, Actions: "drop"},
+		{Name: "f1", Priority: 65536, Actions: "drop"},
+		{Name: "f1", Match: "ip"},
+		{Name: "f1", Match: "cookie=0x1,ip", Actions: "drop"},
+		{Name: "f1", Match: "ip,priority=10", Actions: "drop"},
+		{Name: "f1", Match: "in_port=1,ip", Actions: "drop"},
+		{Name: "f1", Match: "ip,dl_vlan=20", Actions: "drop"},
+		{Name: "f1", Match: "ip,metadata=0x100/0xff00", Actions: "drop"},
+		{Name: "f1", Match: "ip", Actions: "drop\nadd-flow"},
+	} {
+		if err := p.AddNetworkFlow("orange.default", flow); err == nil {
+			t.Fatalf("invalid flow %+v added", flow)
+		}
+	}
+	if len(nd.flows) != 0 {
+		t.Fatalf("invalid flows programmed: %v", nd.flows)
+	}
+
+	if err := p.AddNetworkFlow("orange.default", drop); err != nil {
+		t.Fatalf("error adding custom flow. Error: %s", err)
+	}
+	id := mastercfg.NetworkFlowID("host1", "orange.default", "drop-telnet")
+	flowCfg := &mastercfg.NetworkFlowState{}
+	flowCfg.StateDriver = fakeStateDriver
+	if err := flowCfg.Read(id); err != nil {
+		t.Fatalf("error reading custom flow state. Error: %s", err)
+	}
+	if nd.flows[id] != "tcp,tp_dst=23/drop" {
+		t.Fatalf("unexpected custom flows in the datapath: %v", nd.flows)
+	}
+
+	// resync programs the flow again
+	delete(nd.flows, id)
+	if err := p.ResyncNetwork("orange.default"); err != nil {
+		t.Fatalf("error resyncing network. Error: %s", err)
+	}
+	if nd.flows[id] != "tcp,tp_dst=23/drop" {
+		t.Fatalf("custom flow not reprogrammed by resync: %v", nd.flows)
+	}
+
+	if err := p.RemoveNetworkFlow("orange.default", "drop-telnet"); err != nil {
+		t.Fatalf("error removing custom flow. Error: %s", err)
+	}
+	if len(nd.flows) != 0 {
+		t.Fatalf("custom flow left in the datapath: %v", nd.flows)
+	}
+	if err := flowCfg.Read(id); err == nil {
+		t.Fatalf("custom flow state left after remove")
+	}
+	if err := p.RemoveNetworkFlow("orange.default", "drop-telnet"); err == nil {
+		t.Fatalf("remove of an unknown custom flow succeeded")
+	}
+
+	// deleting a network removes its custom flows
+	if err := p.AddNetworkFlow("orange.default", drop); err != nil {
+		t.Fatalf("error adding custom flow. Error: %s", err)
+	}
+	if err := p.DeleteNetwork("orange.default", "", "", "", 0, 0, "", ""); err != nil {
+		t.Fatalf("error deleting network. Error: %s", err)
+	}
+	if len(nd.flows) != 0 {
+		t.Fatalf("custom flow left after network delete: %v", nd.flows)
+	}
+}
+
+// exemptDriver records the conntrack exemptions programmed by the plugin
+type exemptDriver struct {
+	wiringDriver
+	exempted map[string]string
+}
+
+func (d *exemptDriver) AddConntrackExemptions(id, nsPath string) error {
+	d.exempted[id] = nsPath
+	return nil
+}
+
+func (d *exemptDriver) RemoveConntrackExemptions(id, nsPath string) error {
+	if d.exempted[id] != nsPath {
+		return fmt.Errorf("exemptions of %s not in %s", id, nsPath)
+	}
+	delete(d.exempted, id)
+	return nil
+}
+
+func TestConntrackExemptions(t *testing.T) {
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	epCfg := &mastercfg.CfgEndpointState{
+		NetID:  "orange.default",
+		Status: mastercfg.EndpointStatusDetached,
+		ConntrackExemptions: []mastercfg.ConntrackExemption{
+			{Protocol: "tcp", DstPort: 80},
+		},
+	}
+	epCfg.ID = "orange-ep1"
+	epCfg.StateDriver = fakeStateDriver
+	if err := epCfg.Write(); err != nil {
+		t.Fatalf("error writing endpoint state. Error: %s", err)
+	}
+
+	p := &NetPlugin{StateDriver: fakeStateDriver, NetworkDriver: &wiringDriver{wired: map[string]bool{}}}
+	if err := p.AttachEndpoint("orange-ep1", "/var/run/netns/orange"); err == nil {
+		t.Fatalf("attach succeeded with a driver that does not support conntrack exemptions")
+	}
+
+	nd := &exemptDriver{wiringDriver: wiringDriver{wired: map[string]bool{}}, exempted: map[string]string{}}
+	p.NetworkDriver = nd
+	if err := p.AttachEndpoint("orange-ep1", ""); err == nil {
+		t.Fatalf("attach of an endpoint with conntrack exemptions succeeded without a netns")
+	}
+	if len(nd.wired) != 0 {
+		t.Fatalf("endpoint wired by a failed attach: %v", nd.wired)
+	}
+
+	// the rules are removed from the netns given on attach
+	nd.wired["orange-ep1"] = true
+	nd.exempted["orange-ep1"] = "/var/run/netns/orange"
+	epCfg.Status = mastercfg.EndpointStatusAttached
+	epCfg.NetnsPath = "/var/run/netns/orange"
+	if err := epCfg.Write(); err != nil {
+		t.Fatalf("error writing endpoint state. Error: %s", err)
+	}
+	if err := p.DetachEndpoint("orange-ep1"); err != nil {
+		t.Fatalf("error detaching endpoint. Error: %s", err)
+	}
+	if len(nd.exempted) != 0 {
+		t.Fatalf("conntrack exemptions left after detach: %v", nd.exempted)
+	}
+}
+
+// concurrentDriver records overlapping creates of the same endpoint. The
+// creates of the blue endpoints wait for each other, they only complete
+// when run in parallel.
+type concurrentDriver struct {
+	drivers.FakeNetEpDriver
+	sync.Mutex
+	active  map[string]int
+	overlap bool
+	blue    sync.WaitGroup
+}
+
+func (d *concurrentDriver) CreateEndpoint(id string) error {
+	d.Lock()
+	d.active[id]++
+	if d.active[id] > 1 {
+		d.overlap = true
+	}
+	d.Unlock()
+
+	if strings.HasPrefix(id, "blue") {
+		d.blue.Done()
+		met := make(chan struct{})
+		go func() {
+			d.blue.Wait()
+			close(met)
+		}()
+		select {
+		case <-met:
+		case <-time.After(5 * time.Second):
+			return fmt.Errorf("create of %s did not run in parallel with the other blue endpoint", id)
+		}
+	} else {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	d.Lock()
+	d.active[id]--
+	d.Unlock()
+	return nil
+}
+
+func TestEndpointLocking(t *testing.T) {
+	nd := &concurrentDriver{active: map[string]int{}}
+	nd.blue.Add(2)
+	p := &NetPlugin{NetworkDriver: nd}
+
+	ids := []string{"blue-ep1", "blue-ep2"}
+	for i := 0; i < 5; i++ {
+		ids = append(ids, "orange-ep1")
+	}
+
+	errs := make(chan error, len(ids))
+	for _, id := range ids {
+		go func(id string) {
+			errs <- p.CreateEndpoint(id)
+		}(id)
+	}
+	for range ids {
+		if err := <-errs; err != nil {
+			t.Fatalf("error creating endpoint. Error: %s", err)
+		}
+	}
+
+	if nd.overlap {
+		t.Fatalf("creates of the same endpoint ran in parallel")
+	}
+	if len(p.epLocks.locks) != 0 {
+		t.Fatalf("endpoint locks left after the creates: %v", p.epLocks.locks)
+	}
+}
+
+// moverDriver records the endpoints it rewired, failing the moves to
+// network failNet
+type moverDriver struct {
+	wiringDriver
+	moved   []string
+	failNet string
+}
+
+func (d *moverDriver) MoveEndpoint(id string) error {
+	epCfg := &mastercfg.CfgEndpointState{}
+	epCfg.StateDriver = fakeStateDriver
+	if err := epCfg.Read(id); err != nil {
+		return err
+	}
+	d.moved = append(d.moved, id+" "+epCfg.NetID)
+	if epCfg.NetID == d.failNet {
+		return fmt.Errorf("can not move %s to %s", id, epCfg.NetID)
+	}
+	return nil
+}
+
+func TestMoveEndpointToNetwork(t *testing.T) {
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	for idx, name := range []string{"orange", "blue"} {
+		nwCfg := &mastercfg.CfgNetworkState{Tenant: "default", SubnetLen: 29,
+			SubnetIP:    fmt.Sprintf("10.1.%d.0", idx+1),
+			IPAddrRange: fmt.Sprintf("10.1.%d.1-10.1.%d.6", idx+1, idx+1)}
+		nwCfg.ID = name + ".default"
+		nwCfg.StateDriver = fakeStateDriver
+		netutils.InitSubnetBitset(&nwCfg.IPAllocMap, nwCfg.SubnetLen)
+		if name == "orange" {
+			nwCfg.IPAllocMap.Set(2)
+			nwCfg.EpCount = 1
+		}
+		if err := nwCfg.Write(); err != nil {
+			t.Fatalf("error writing network state. Error: %s", err)
+		}
+	}
+	epCfg := &mastercfg.CfgEndpointState{
+		NetID:      "orange.default",
+		EndpointID: "ctr1",
+		IPAddress:  "10.1.1.2",
+		MacAddress: "02:02:0a:01:01:02",
+		Status:     mastercfg.EndpointStatusAttached,
+	}
+	epCfg.ID = "orange.default-ctr1"
+	epCfg.StateDriver = fakeStateDriver
+	if err := epCfg.Write(); err != nil {
+		t.Fatalf("error writing endpoint state. Error: %s", err)
+	}
+	epCount := func(netID string) int {
+		nwCfg := &mastercfg.CfgNetworkState{}
+		nwCfg.StateDriver = fakeStateDriver
+		if err := nwCfg.Read(netID); err != nil {
+			t.Fatalf("error reading network %s. Error: %s", netID, err)
+		}
+		return nwCfg.EpCount
+	}
+
+	p := &NetPlugin{StateDriver: fakeStateDriver, NetworkDriver: &wiringDriver{wired: map[string]bool{}}}
+	if err := p.MoveEndpointToNetwork(epCfg.ID, "blue.default"); err == nil {
+		t.Fatalf("attached endpoint moved by a driver that can not move endpoints")
+	}
+
+	nd := &moverDriver{wiringDriver: wiringDriver{wired: map[string]bool{}}, failNet: "orange.default"}
+	p.NetworkDriver = nd
+	if err := p.MoveEndpointToNetwork(epCfg.ID, "blue.default"); err != nil {
+		t.Fatalf("error moving endpoint. Error: %s", err)
+	}
+	if err := epCfg.Read(epCfg.ID); err != nil {
+		t.Fatalf("error reading moved endpoint. Error: %s", err)
+	}
+	if epCfg.NetID != "blue.default" || !strings.HasPrefix(epCfg.IPAddress, "10.1.2.") ||
+		epCfg.MacAddress != "02:02:0a:01:01:02" || epCfg.EndpointID != "ctr1" {
+		t.Fatalf("unexpected moved endpoint %+v", epCfg)
+	}
+	if epCount("orange.default") != 0 || epCount("blue.default") != 1 {
+		t.Fatalf("endpoint counts not moved, orange %d blue %d",
+			epCount("orange.default"), epCount("blue.default"))
+	}
+
+	// a failed move is rolled back, the endpoint is rewired in blue again
+	movedIP := epCfg.IPAddress
+	if err := p.MoveEndpointToNetwork(epCfg.ID, "orange.default"); err == nil {
+		t.Fatalf("failed move succeeded")
+	}
+	if err := epCfg.Read(epCfg.ID); err != nil {
+		t.Fatalf("error reading endpoint. Error: %s", err)
+	}
+	if epCfg.NetID != "blue.default" || epCfg.IPAddress != movedIP {
+		t.Fatalf("failed move not rolled back: %+v", epCfg)
+	}
+	if epCount("orange.default") != 0 || epCount("blue.default") != 1 {
+		t.Fatalf("endpoint counts not rolled back, orange %d blue %d",
+			epCount("orange.default"), epCount("blue.default"))
+	}
+	expMoves := []string{
+		"orange.default-ctr1 blue.default",
+		"orange.default-ctr1 orange.default",
+		"orange.default-ctr1 blue.default",
+	}
+	if !reflect.DeepEqual(nd.moved, expMoves) {
+		t.Fatalf("unexpected moves %v, expected %v", nd.moved, expMoves)
+	}
+}
+
+func TestDrainNetwork(t *testing.T) {
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	for idx, name := range []string{"orange", "blue"} {
+		nwCfg := &mastercfg.CfgNetworkState{Tenant: "default", SubnetLen: 29,
+			SubnetIP:    fmt.Sprintf("10.1.%d.0", idx+1),
+			IPAddrRange: fmt.Sprintf("10.1.%d.1-10.1.%d.6", idx+1, idx+1)}
+		nwCfg.ID = name + ".default"
+		nwCfg.StateDriver = fakeStateDriver
+		netutils.InitSubnetBitset(&nwCfg.IPAllocMap, nwCfg.SubnetLen)
+		if name == "orange" {
+			for host := uint(1); host <= 4; host++ {
+				nwCfg.IPAllocMap.Set(host)
+			}
+			nwCfg.EpCount = 4
+		}
+		if err := nwCfg.Write(); err != nil {
+			t.Fatalf("error writing network state. Error: %s", err)
+		}
+	}
+	for host := 1; host <= 4; host++ {
+		epCfg := &mastercfg.CfgEndpointState{
+			NetID:      "orange.default",
+			EndpointID: fmt.Sprintf("ctr%d", host),
+			IPAddress:  fmt.Sprintf("10.1.1.%d", host),
+			HomingHost: "host1",
+			Status:     mastercfg.EndpointStatusDetached,
+		}
+		// ctr4 is drained by its own host
+		if host == 4 {
+			epCfg.HomingHost = "host2"
+		}
+		// the route of ctr2 can not be set up on blue
+		if host == 2 {
+			epCfg.Routes = []mastercfg.EndpointRoute{{Dest: "10.2.0.0/16", Gateway: "10.1.1.6"}}
+		}
+		epCfg.ID = "orange.default-" + epCfg.EndpointID
+		epCfg.StateDriver = fakeStateDriver
+		if err := epCfg.Write(); err != nil {
+			t.Fatalf("error writing endpoint state. Error: %s", err)
+		}
+	}
+
+	p := &NetPlugin{StateDriver: fakeStateDriver, NetworkDriver: &wiringDriver{wired: map[string]bool{}}}
+	p.PluginConfig.Instance.HostLabel = "host1"
+	if err := p.DrainNetwork("orange.default", "orange.default"); err == nil {
+		t.Fatalf("network drained to itself")
+	}
+	if err := p.DrainNetwork("orange.default", "green.default"); err == nil {
+		t.Fatalf("network drained to a missing network")
+	}
+
+	// a failed endpoint does not stop the drain, the drain can be resumed
+	err := p.DrainNetwork("orange.default", "blue.default")
+	if err == nil || !strings.Contains(err.Error(), "orange.default-ctr2") {
+		t.Fatalf("drain with a failed endpoint succeeded or did not name it. Err: %v", err)
+	}
+	drain, err := p.DrainStatus("orange.default")
+	if err != nil {
+		t.Fatalf("error getting drain status. Error: %s", err)
+	}
+	if drain.Target != "blue.default" || drain.Total != 3 ||
+		!reflect.DeepEqual(drain.Moved, []string{"orange.default-ctr1", "orange.default-ctr3"}) ||
+		len(drain.Failed) != 1 || drain.Failed["orange.default-ctr2"] == "" {
+		t.Fatalf("unexpected drain status %+v", drain)
+	}
+	if err := p.DrainNetwork("orange.default", "green.default"); err == nil {
+		t.Fatalf("drain resumed to another network")
+	}
+
+	// the failures are kept until the endpoint moves
+	if err := p.DrainNetwork("orange.default", "blue.default"); err == nil {
+		t.Fatalf("resumed drain with a failed endpoint succeeded")
+	}
+	drain, err = p.DrainStatus("orange.default")
+	if err != nil || drain.Total != 3 || len(drain.Moved) != 2 || drain.Failed["orange.default-ctr2"] == "" {
+		t.Fatalf("unexpected drain status %+v. Err: %v", drain, err)
+	}
+
+	epCfg := &mastercfg.CfgEndpointState{}
+	epCfg.StateDriver = fakeStateDriver
+	if err := epCfg.Read("orange.default-ctr2"); err != nil {
+		t.Fatalf("error reading endpoint. Error: %s", err)
+	}
+	epCfg.Routes = nil
+	if err := epCfg.Write(); err != nil {
+		t.Fatalf("error writing endpoint state. Error: %s", err)
+	}
+	if err := p.DrainNetwork("orange.default", "blue.default"); err != nil {
+		t.Fatalf("error resuming drain. Error: %s", err)
+	}
+	if _, err := p.DrainStatus("orange.default"); err == nil {
+		t.Fatalf("drain status kept after the drain completed")
+	}
+	for host := 1; host <= 3; host++ {
+		if err := epCfg.Read(fmt.Sprintf("orange.default-ctr%d", host)); err != nil {
+			t.Fatalf("error reading endpoint. Error: %s", err)
+		}
+		if epCfg.NetID != "blue.default" || !strings.HasPrefix(epCfg.IPAddress, "10.1.2.") {
+			t.Fatalf("endpoint not drained: %+v", epCfg)
+		}
+	}
+	if err := epCfg.Read("orange.default-ctr4"); err != nil || epCfg.NetID != "orange.default" {
+		t.Fatalf("endpoint of another host drained. Err: %v", err)
+	}
+}
+
+type mirrorDriver struct {
+	wiringDriver
+	mirrors map[string]string // endpoint by mirror id
+	builds  int
+}
+
+func (d *mirrorDriver) CreateMirror(m *mastercfg.MirrorState) error {
+	if !d.wired[m.EndpointID] {
+		return fmt.Errorf("endpoint %s is not wired", m.EndpointID)
+	}
+	d.mirrors[m.ID] = m.EndpointID
+	d.builds++
+	return nil
+}
+
+func (d *mirrorDriver) DeleteMirror(m *mastercfg.MirrorState) error {
+	delete(d.mirrors, m.ID)
+	return nil
+}
+
+func TestMirrors(t *testing.T) {
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	epCfg := &mastercfg.CfgEndpointState{
+		NetID:     "orange.default",
+		IPAddress: "10.1.1.2",
+		Status:    mastercfg.EndpointStatusAttached,
+	}
+	epCfg.ID = "orange-ep1"
+	epCfg.StateDriver = fakeStateDriver
+	if err := epCfg.Write(); err != nil {
+		t.Fatalf("error writing endpoint state. Error: %s", err)
+	}
+
+	p := &NetPlugin{StateDriver: fakeStateDriver, NetworkDriver: &wiringDriver{wired: map[string]bool{}}}
+	p.PluginConfig.Instance.HostLabel = "host1"
+	if _, err := p.StartMirror("orange-ep1", "eth9"); err == nil {
+		t.Fatalf("mirror started by a driver that can not mirror")
+	}
+
+	nd := &mirrorDriver{wiringDriver: wiringDriver{wired: map[string]bool{"orange-ep1": true}},
+		mirrors: map[string]string{}}
+	p.NetworkDriver = nd
+	if _, err := p.StartMirror("orange-ep1", ""); err == nil {
+		t.Fatalf("mirror started without a capture port")
+	}
+	if _, err := p.StartMirror("orange-ep2", "eth9"); err == nil {
+		t.Fatalf("mirror of a missing endpoint started")
+	}
+	mirrorID, err := p.StartMirror("orange-ep1", "eth9")
+	if err != nil {
+		t.Fatalf("error starting mirror. Error: %s", err)
+	}
+	if _, err := p.StartMirror("orange-ep1", "eth9"); err == nil {
+		t.Fatalf("mirror started twice")
+	}
+	mirrors, err := p.ListMirrors()
+	if err != nil || len(mirrors) != 1 || mirrors[0].ID != mirrorID ||
+		mirrors[0].EndpointID != "orange-ep1" || mirrors[0].DestPort != "eth9" {
+		t.Fatalf("unexpected mirrors %+v. Err: %v", mirrors, err)
+	}
+
+	// the mirror is rebuilt when the endpoint is programmed again
+	if err := p.CreateEndpoint("orange-ep1"); err != nil {
+		t.Fatalf("error creating endpoint. Error: %s", err)
+	}
+	if nd.builds != 2 || nd.mirrors[mirrorID] != "orange-ep1" {
+		t.Fatalf("mirror not rebuilt, %d builds, mirrors %v", nd.builds, nd.mirrors)
+	}
+
+	if err := p.StopMirror(mirrorID); err != nil {
+		t.Fatalf("error stopping mirror. Error: %s", err)
+	}
+	if err := p.StopMirror(mirrorID); err == nil {
+		t.Fatalf("mirror stopped twice")
+	}
+	mirrors, err = p.ListMirrors()
+	if err != nil || len(mirrors) != 0 || len(nd.mirrors) != 0 {
+		t.Fatalf("mirror left after stop: %+v, %v. Err: %v", mirrors, nd.mirrors, err)
+	}
+
+	// the mirrors of an endpoint are removed with it
+	if _, err := p.StartMirror("orange-ep1", "eth9"); err != nil {
+		t.Fatalf("error starting mirror. Error: %s", err)
+	}
+	if err := p.DeleteEndpoint("orange-ep1"); err != nil {
+		t.Fatalf("error deleting endpoint. Error: %s", err)
+	}
+	mirrors, err = p.ListMirrors()
+	if err != nil || len(mirrors) != 0 || len(nd.mirrors) != 0 {
+		t.Fatalf("mirror left after endpoint delete: %+v, %v. Err: %v", mirrors, nd.mirrors, err)
+	}
+}
+
+// bumDriver is a fake driver handling bum traffic with the modes it lists
+type bumDriver struct {
+	drivers.FakeNetEpDriver
+	modes map[string]bool
+}
+
+func (d *bumDriver) SupportsBUMMode(mode string) bool {
+	return d.modes[mode]
+}
+
+func TestBUMModeSupport(t *testing.T) {
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	writeNw := func(id, mode string) {
+		nwCfg := &mastercfg.CfgNetworkState{PktTagType: "vxlan", BUMMode: mode}
+		nwCfg.ID = id
+		nwCfg.StateDriver = fakeStateDriver
+		if err := nwCfg.Write(); err != nil {
+			t.Fatalf("error writing network state. Error: %s", err)
+		}
+	}
+	writeNw("orange.default", "")
+	writeNw("green.default", mastercfg.BUMModeFlood)
+	writeNw("blue.default", mastercfg.BUMModeDrop)
+
+	p := &NetPlugin{StateDriver: fakeStateDriver}
+	plain := &drivers.FakeNetEpDriver{}
+	dropper := &bumDriver{modes: map[string]bool{mastercfg.BUMModeDrop: true}}
+	testData := []struct {
+		driver     core.NetworkDriver
+		id         string
+		shouldFail bool
+	}{
+		{plain, "orange.default", false},
+		{plain, "green.default", false},
+		{plain, "blue.default", true},
+		{dropper, "blue.default", false},
+		{&bumDriver{}, "blue.default", true},
+	}
+	for _, d := range testData {
+		err := p.checkBUMSupport(d.driver, d.id)
+		if (err != nil) != d.shouldFail {
+			t.Fatalf("network %s on %T: unexpected result %v", d.id, d.driver, err)
+		}
+	}
+
+	p.NetworkDriver = plain
+	if err := p.createNetwork("blue.default"); err == nil {
+		t.Fatalf("network created with a bum mode its driver does not support")
+	}
+}
+
+func TestAttachEndpointToPid(t *testing.T) {
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	nd := &wiringDriver{wired: map[string]bool{}}
+	p := &NetPlugin{StateDriver: fakeStateDriver, NetworkDriver: nd}
+
+	epCfg := &mastercfg.CfgEndpointState{NetID: "orange.default", Status: mastercfg.EndpointStatusDetached}
+	epCfg.ID = "orange-ep1"
+	epCfg.StateDriver = fakeStateDriver
+	if err := epCfg.Write(); err != nil {
+		t.Fatalf("error writing endpoint state. Error: %s", err)
+	}
+
+	// the test runs in the host netns
+	for _, pid := range []int{0, os.Getpid()} {
+		if err := p.AttachEndpointToPid("orange-ep1", pid); err == nil {
+			t.Fatalf("attached to the netns of pid %d", pid)
+		}
+	}
+	if nd.wired["orange-ep1"] {
+		t.Fatalf("endpoint wired by a failed attach")
+	}
+}
+
+func TestLogLevel(t *testing.T) {
+	p := &NetPlugin{}
+	defer logrus.SetLevel(logrus.GetLevel())
+
+	if err := p.SetLogLevel("debug"); err != nil {
+		t.Fatalf("error setting the log level. Error: %s", err)
+	}
+	if level := p.GetLogLevel(); level != "debug" || logrus.GetLevel() != logrus.DebugLevel {
+		t.Fatalf("unexpected log level %q after setting debug", level)
+	}
+	if err := p.SetLogLevel("verbose"); err == nil {
+		t.Fatalf("invalid log level set")
+	}
+	if level := p.GetLogLevel(); level != "debug" {
+		t.Fatalf("log level changed to %q by an invalid level", level)
+	}
+}
+
+func TestGetEndpointNeighbors(t *testing.T) {
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	p := &NetPlugin{StateDriver: fakeStateDriver}
+	p.PluginConfig.Instance.HostLabel = "host1"
+	for id, epCfg := range map[string]*mastercfg.CfgEndpointState{
+		"orange-ep1": {HomingHost: "host1", NetnsPath: "/proc/self/ns/net"},
+		"orange-ep2": {HomingHost: "host1", Status: mastercfg.EndpointStatusDetached},
+		"orange-ep3": {HomingHost: "host2", NetnsPath: "/proc/self/ns/net"},
+	} {
+		epCfg.ID = id
+		epCfg.StateDriver = fakeStateDriver
+		if err := epCfg.Write(); err != nil {
+			t.Fatalf("error writing endpoint state. Error: %s", err)
+		}
+	}
+
+	if _, err := p.GetEndpointNeighbors("orange-ep1"); err != nil {
+		t.Fatalf("error reading the neighbors of an attached endpoint. Error: %s", err)
+	}
+	for _, id := range []string{"orange-ep2", "orange-ep3", "orange-ep4"} {
+		if _, err := p.GetEndpointNeighbors(id); err == nil {
+			t.Fatalf("neighbors of endpoint %s read", id)
+		}
+	}
+
+	if state := neighState(netlink.NUD_STALE); state != "stale" {
+		t.Fatalf("unexpected neighbor state %q", state)
+	}
+	if state := neighState(netlink.NUD_NONE); state != "none" {
+		t.Fatalf("unexpected neighbor state %q", state)
+	}
+}
+
+type dataplaneDriver struct {
+	drivers.FakeNetEpDriver
+}
+
+func (d *dataplaneDriver) GetEndpointDataplaneInfo(id string) (*drivers.DataplaneInfo, error) {
+	if id != "orange-ep1" {
+		return nil, core.Errorf("endpoint %s not found on this host", id)
+	}
+	return &drivers.DataplaneInfo{Bridge: "contivVlanBridge", Port: "vport1", OfPort: 3, Vlan: 10}, nil
+}
+
+func TestGetEndpointDataplaneInfo(t *testing.T) {
+	p := &NetPlugin{NetworkDriver: &drivers.FakeNetEpDriver{}}
+	if _, err := p.GetEndpointDataplaneInfo("orange-ep1"); err == nil {
+		t.Fatalf("dataplane info reported by a driver that does not support it")
+	}
+
+	p.NetworkDriver = &dataplaneDriver{}
+	info, err := p.GetEndpointDataplaneInfo("orange-ep1")
+	if err != nil {
+		t.Fatalf("error getting dataplane info. Error: %s", err)
+	}
+	if info.Bridge != "contivVlanBridge" || info.Port != "vport1" || info.OfPort != 3 || info.Vlan != 10 {
+		t.Fatalf("unexpected dataplane info %+v", info)
+	}
+	if _, err := p.GetEndpointDataplaneInfo("orange-ep2"); err == nil {
+		t.Fatalf("dataplane info of an unknown endpoint")
+	}
+}
+
+func TestTenantQuota(t *testing.T) {
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	p := &NetPlugin{StateDriver: fakeStateDriver, NetworkDriver: &drivers.FakeNetEpDriver{}}
+	nwCfg := &mastercfg.CfgNetworkState{Tenant: "default", SubnetIP: "10.1.1.0", SubnetLen: 24}
+	nwCfg.ID = "orange.default"
+	nwCfg.StateDriver = fakeStateDriver
+	if err := nwCfg.Write(); err != nil {
+		t.Fatalf("error writing network state. Error: %s", err)
+	}
+
+	if err := p.SetTenantQuota("default", TenantQuota{Networks: -1}); err == nil {
+		t.Fatalf("negative quota set")
+	}
+	if err := p.SetTenantQuota("default", TenantQuota{Networks: 4, Addresses: 1024}); err != nil {
+		t.Fatalf("error setting quota. Error: %s", err)
+	}
+	status, err := p.GetTenantQuota("default")
+	if err != nil {
+		t.Fatalf("error getting quota. Error: %s", err)
+	}
+	if status.Quota != (TenantQuota{Networks: 4, Addresses: 1024}) ||
+		status.Usage != (master.TenantUsage{Networks: 1, Addresses: 256}) {
+		t.Fatalf("unexpected quota status %+v", status)
+	}
+}
+
+func TestGenerateEndpointID(t *testing.T) {
+	p := &NetPlugin{}
+	// the format is that of the endpoint state keys, it must not change
+	if id, err := p.GenerateEndpointID("orange.default", "c1"); err != nil || id != "orange.default-c1" {
+		t.Fatalf("unexpected endpoint id %s. Error: %v", id, err)
+	}
+	if id, err := p.GenerateEndpointID("orange.default-1", "c1"); err != nil || id != "orange.default-1-c1" {
+		t.Fatalf("unexpected endpoint id %s. Error: %v", id, err)
+	}
+	// would be orange.default-1-c1 again
+	if _, err := p.GenerateEndpointID("orange.default", "1-c1"); err == nil {
+		t.Fatalf("container id with a dash accepted")
+	}
+	for _, ids := range [][2]string{{"", "c1"}, {"orange.default", ""}} {
+		if _, err := p.GenerateEndpointID(ids[0], ids[1]); err == nil {
+			t.Fatalf("endpoint id generated from %q", ids)
+		}
+	}
+}
+
+func TestReaddressArgs(t *testing.T) {
+	args := readdressArgs("eth0", []string{"10.1.1.2/24"}, []string{"10.1.2.2/24", "2016::2/64"}, "10.1.2.1")
+	exp := []string{
+		"addr replace 10.1.2.2/24 dev eth0",
+		"addr replace 2016::2/64 dev eth0",
+		"addr del 10.1.1.2/24 dev eth0",
+		"-4 route replace default via 10.1.2.1 dev eth0",
+	}
+	if len(args) != len(exp) {
+		t.Fatalf("unexpected commands %v", args)
+	}
+	for idx, cmd := range args {
+		if strings.Join(cmd, " ") != exp[idx] {
+			t.Fatalf("unexpected command %q, expected %q", strings.Join(cmd, " "), exp[idx])
+		}
+	}
+}
+
+func TestEndpointTemplates(t *testing.T) {
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	p := &NetPlugin{StateDriver: fakeStateDriver}
+	tmpl := `{"mtu": 9000, "serviceName": "web", "labels": {"tier": "front", "env": "prod"},
+		"sysctls": {"net.ipv4.tcp_syncookies": "1"}, "routes": [{"dest": "10.2.0.0/16", "gateway": "10.1.1.1"}]}`
+	if err := p.CreateEndpointTemplate("web", strings.NewReader(tmpl)); err != nil {
+		t.Fatalf("error creating endpoint template. Error: %s", err)
+	}
+	if err := p.CreateEndpointTemplate("web", strings.NewReader(tmpl)); err == nil {
+		t.Fatalf("endpoint template created twice")
+	}
+	if err := p.UpdateEndpointTemplate("db", strings.NewReader(tmpl)); err == nil {
+		t.Fatalf("missing endpoint template updated")
+	}
+	if err := p.CreateEndpointTemplate("bad", strings.NewReader(`{"routes": [{"dest": "10.2.0.0"}]}`)); err == nil {
+		t.Fatalf("endpoint template with an invalid route created")
+	}
+	if err := p.CreateEndpointTemplate("a/b", strings.NewReader(tmpl)); err == nil {
+		t.Fatalf("endpoint template with an invalid name created")
+	}
+	names, err := p.ListEndpointTemplates()
+	if err != nil || !reflect.DeepEqual(names, []string{"web"}) {
+		t.Fatalf("unexpected endpoint templates %v. Error: %v", names, err)
+	}
+
+	// the options set in the spec win over the template
+	spec := &EndpointSpec{Tenant: "default", Network: "orange", Template: "web", MTU: 1400,
+		Labels: map[string]string{"env": "dev"}}
+	if err := p.applyEndpointTemplate(spec); err != nil {
+		t.Fatalf("error applying endpoint template. Error: %s", err)
+	}
+	if spec.MTU != 1400 || spec.ServiceName != "web" || len(spec.Routes) != 1 ||
+		spec.Sysctls["net.ipv4.tcp_syncookies"] != "1" ||
+		!reflect.DeepEqual(spec.Labels, map[string]string{"tier": "front", "env": "dev"}) {
+		t.Fatalf("unexpected spec after applying the template: %+v", spec)
+	}
+
+	if err := p.UpdateEndpointTemplate("web", strings.NewReader(`{"mtu": 1500}`)); err != nil {
+		t.Fatalf("error updating endpoint template. Error: %s", err)
+	}
+	got, err := p.GetEndpointTemplate("web")
+	if err != nil || got.MTU != 1500 || got.ServiceName != "" {
+		t.Fatalf("unexpected endpoint template %+v. Error: %v", got, err)
+	}
+
+	if err := p.DeleteEndpointTemplate("web"); err != nil {
+		t.Fatalf("error deleting endpoint template. Error: %s", err)
+	}
+	if err := p.applyEndpointTemplate(&EndpointSpec{Template: "web"}); err == nil {
+		t.Fatalf("deleted endpoint template applied")
+	}
+	if err := p.DeleteEndpointTemplate("web"); err == nil {
+		t.Fatalf("endpoint template deleted twice")
+	}
+}
+
+// lookupRuntime is a fakeRuntime that also looks containers up by name
+type lookupRuntime struct {
+	fakeRuntime
+	names map[string]string // container name to id
+}
+
+func (r lookupRuntime) ContainerExists(id string) (bool, error) {
+	_, ok := r.fakeRuntime[id]
+	return ok, nil
+}
+
+func (r lookupRuntime) ContainerIDByName(name string) (string, error) {
+	return r.names[name], nil
+}
+
+// blockingLookupRuntime is a lookupRuntime whose lookups wait for release
+type blockingLookupRuntime struct {
+	lookupRuntime
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (r blockingLookupRuntime) ContainerExists(id string) (bool, error) {
+	select {
+	case r.entered <- struct{}{}:
+	default:
+	}
+	<-r.release
+	return r.lookupRuntime.ContainerExists(id)
+}
+
+func TestConcurrentReconcilers(t *testing.T) {
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	nwCfg := &mastercfg.CfgNetworkState{}
+	nwCfg.ID = "orange.default"
+	nwCfg.StateDriver = fakeStateDriver
+	if err := nwCfg.Write(); err != nil {
+		t.Fatalf("error writing network state. Error: %s", err)
+	}
+	epCfg := &mastercfg.CfgEndpointState{ContainerID: "ctr1", HomingHost: "host1"}
+	epCfg.ID = "orange-ep1"
+	epCfg.StateDriver = fakeStateDriver
+	if err := epCfg.Write(); err != nil {
+		t.Fatalf("error writing endpoint state. Error: %s", err)
+	}
+
+	// two agents sharing the state store
+	runtime := blockingLookupRuntime{lookupRuntime: lookupRuntime{fakeRuntime: fakeRuntime{"ctr1": ""}},
+		entered: make(chan struct{}, 1), release: make(chan struct{})}
+	p1 := &NetPlugin{StateDriver: fakeStateDriver, ContainerRuntime: runtime}
+	p1.PluginConfig.Instance.HostLabel = "host1"
+	p2 := &NetPlugin{StateDriver: fakeStateDriver, ContainerRuntime: runtime,
+		NetworkDriver: &resyncDriver{wiringDriver: wiringDriver{wired: map[string]bool{}}}}
+	p2.PluginConfig.Instance.HostLabel = "host1"
+
+	done := make(chan error)
+	go func() {
+		done <- p1.ReconcileContainerBindings()
+	}()
+	<-runtime.entered
+
+	// the first reconciler holds the lock until its lookups are released
+	if err := p2.ReconcileContainerBindings(); err == nil {
+		t.Fatalf("bindings reconciled along with another reconciler")
+	}
+	if err := p2.ResyncNetwork("orange.default"); err == nil {
+		t.Fatalf("network resynced along with another reconciler")
+	}
+
+	close(runtime.release)
+	if err := <-done; err != nil {
+		t.Fatalf("error reconciling container bindings. Error: %s", err)
+	}
+	if err := p2.ReconcileContainerBindings(); err != nil {
+		t.Fatalf("error reconciling container bindings. Error: %s", err)
+	}
+	if err := p2.ResyncNetwork("orange.default"); err != nil {
+		t.Fatalf("error resyncing network. Error: %s", err)
+	}
+}
+
+func TestReconcileContainerBindings(t *testing.T) {
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	writeRetainedEp := func(id, containerID, name, host string, retained bool) {
+		epCfg := &mastercfg.CfgEndpointState{ContainerID: containerID, EPCommonName: name,
+			HomingHost: host, Retained: retained}
+		epCfg.ID = id
+		epCfg.StateDriver = fakeStateDriver
+		if err := epCfg.Write(); err != nil {
+			t.Fatalf("error writing endpoint state. Error: %s", err)
+		}
+	}
+	writeEp := func(id, containerID, name, host string) {
+		writeRetainedEp(id, containerID, name, host, false)
+	}
+	readEp := func(id string) *mastercfg.CfgEndpointState {
+		epCfg := &mastercfg.CfgEndpointState{}
+		epCfg.StateDriver = fakeStateDriver
+		if err := epCfg.Read(id); err != nil {
+			t.Fatalf("error reading endpoint state. Error: %s", err)
+		}
+		return epCfg
+	}
+	readContainer := func(id string) string {
+		return readEp(id).ContainerID
+	}
+
+	writeEp("orange-ep1", "ctr1", "/web", "host1")    // container alive
+	writeEp("orange-ep2", "ctr2", "/db", "host1")     // restarted as ctr4
+	writeEp("orange-ep3", "ctr3", "/cache", "host1")  // gone
+	writeEp("orange-ep4", "ctr5", "/remote", "host2") // not local
+
+	p := &NetPlugin{StateDriver: fakeStateDriver}
+	p.PluginConfig.Instance.HostLabel = "host1"
+	p.ContainerRuntime = fakeRuntime{"ctr1": ""}
+	if err := p.ReconcileContainerBindings(); err == nil {
+		t.Fatalf("bindings reconciled with a runtime that can not look containers up")
+	}
+
+	p.ContainerRuntime = lookupRuntime{fakeRuntime: fakeRuntime{"ctr1": "", "ctr4": ""},
+		names: map[string]string{"/db": "ctr4"}}
+	if err := p.ReconcileContainerBindings(); err != nil {
+		t.Fatalf("error reconciling container bindings. Error: %s", err)
+	}
+	for id, exp := range map[string]string{"orange-ep1": "ctr1", "orange-ep2": "ctr4",
+		"orange-ep3": "", "orange-ep4": "ctr5"} {
+		if ctr := readContainer(id); ctr != exp {
+			t.Fatalf("endpoint %s bound to %q, expected %q", id, ctr, exp)
+		}
+	}
+
+	// stale bindings are cleared even when a container has the same name
+	writeEp("orange-ep2", "ctr2", "/db", "host1")
+	p.PluginConfig.ClearStaleBindings = true
+	if err := p.ReconcileContainerBindings(); err != nil {
+		t.Fatalf("error reconciling container bindings. Error: %s", err)
+	}
+	if ctr := readContainer("orange-ep2"); ctr != "" {
+		t.Fatalf("stale binding to %s not cleared", ctr)
+	}
+
+	// retained endpoints keep their binding until their container is back
+	p.PluginConfig.ClearStaleBindings = false
+	writeRetainedEp("orange-ep5", "ctr6", "/queue", "host1", true) // dead
+	writeRetainedEp("orange-ep6", "ctr1", "/web", "host1", true)   // restarted
+	writeRetainedEp("orange-ep7", "ctr7", "/db", "host1", true)    // replaced by ctr4
+	if err := p.ReconcileContainerBindings(); err != nil {
+		t.Fatalf("error reconciling container bindings. Error: %s", err)
+	}
+	for id, exp := range map[string]struct {
+		ctr      string
+		retained bool
+	}{"orange-ep5": {"ctr6", true}, "orange-ep6": {"ctr1", false}, "orange-ep7": {"ctr4", false}} {
+		if ep := readEp(id); ep.ContainerID != exp.ctr || ep.Retained != exp.retained {
+			t.Fatalf("endpoint %s bound to %q retained %v, expected %q retained %v",
+				id, ep.ContainerID, ep.Retained, exp.ctr, exp.retained)
+		}
+	}
+}
+
+func TestFeatureFlags(t *testing.T) {
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	nd := &wiringDriver{wired: map[string]bool{}}
+	p := &NetPlugin{StateDriver: fakeStateDriver, NetworkDriver: nd}
+	p.PluginConfig.Instance.StateDriver = fakeStateDriver
+	if !p.FeatureEnabled(FeatureNetworkEncryption) || p.FeatureEnabled("new-ipam") {
+		t.Fatalf("unexpected feature flag defaults")
+	}
+
+	// changing only the flags keeps the network driver
+	cfg := p.PluginConfig
+	cfg.Features = map[string]bool{FeatureNetworkEncryption: false, "new-ipam": true}
+	if err := p.Reload(cfg); err != nil {
+		t.Fatalf("error reloading the feature flags. Error: %s", err)
+	}
+	if p.NetworkDriver != nd {
+		t.Fatalf("network driver reinitialized by a feature flag change")
+	}
+	if p.FeatureEnabled(FeatureNetworkEncryption) || !p.FeatureEnabled("new-ipam") ||
+		!p.FeatureEnabled(FeatureGatewayArpProxy) {
+		t.Fatalf("feature flags not reloaded")
+	}
+
+	spec := NetworkSpec{Tenant: "default", Name: "orange", Encap: "vxlan", SubnetCIDR: "10.1.1.0/24", Encrypt: true}
+	if err := p.CreateNetworkSpec(spec); err == nil || !strings.Contains(err.Error(), FeatureNetworkEncryption) {
+		t.Fatalf("encrypted network created with encryption disabled. Error: %v", err)
+	}
+}
+
+func TestCreateEndpointEx(t *testing.T) {
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	nwCfg := &mastercfg.CfgNetworkState{Gateway: "10.1.1.1", IPv6Gateway: "2001::1"}
+	nwCfg.ID = "orange.default"
+	nwCfg.StateDriver = fakeStateDriver
+	if err := nwCfg.Write(); err != nil {
+		t.Fatalf("error writing network state. Error: %s", err)
+	}
+	epCfg := &mastercfg.CfgEndpointState{NetID: "orange.default", IPAddress: "10.1.1.5",
+		MacAddress: "02:02:0a:01:01:05"}
+	epCfg.ID = "orange-ep1"
+	epCfg.StateDriver = fakeStateDriver
+	if err := epCfg.Write(); err != nil {
+		t.Fatalf("error writing endpoint state. Error: %s", err)
+	}
+	epOper := &drivers.OperEndpointState{PortName: "vport7"}
+	epOper.ID = "orange-ep1"
+	epOper.StateDriver = fakeStateDriver
+	if err := epOper.Write(); err != nil {
+		t.Fatalf("error writing endpoint oper state. Error: %s", err)
+	}
+
+	nd := &wiringDriver{wired: map[string]bool{}}
+	p := &NetPlugin{StateDriver: fakeStateDriver, NetworkDriver: nd}
+	result, err := p.CreateEndpointEx("orange-ep1")
+	if err != nil {
+		t.Fatalf("error creating endpoint. Error: %s", err)
+	}
+	exp := CreateEndpointResult{IPAddress: "10.1.1.5", MacAddress: "02:02:0a:01:01:05", IntfName: "vport7",
+		Gateway: "10.1.1.1", IPv6Gateway: "2001::1"}
+	if result != exp || !nd.wired["orange-ep1"] {
+		t.Fatalf("unexpected create result %+v, expected %+v", result, exp)
+	}
+}
+
+func TestRestoreEndpoint(t *testing.T) {
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	nd := &wiringDriver{wired: map[string]bool{}}
+	p := &NetPlugin{StateDriver: fakeStateDriver, NetworkDriver: nd}
+	p.PluginConfig.Instance.HostLabel = "host1"
+
+	nwCfg := &mastercfg.CfgNetworkState{Tenant: "default", NetworkName: "orange",
+		SubnetIP: "10.1.1.0", SubnetLen: 24}
+	nwCfg.ID = "orange.default"
+	nwCfg.StateDriver = fakeStateDriver
+	if err := nwCfg.Write(); err != nil {
+		t.Fatalf("error writing network state. Error: %s", err)
+	}
+
+	if err := p.RestoreEndpoint("orange.default-ep1"); err == nil {
+		t.Fatalf("endpoint restored without being recycled")
+	}
+
+	for _, host := range []string{"host1", "host2"} {
+		recycled := &mastercfg.RecycledEndpointState{}
+		recycled.ID = "orange.default-" + host
+		recycled.StateDriver = fakeStateDriver
+		recycled.Endpoint = mastercfg.CfgEndpointState{NetID: "orange.default",
+			IPAddress: "10.1.1.2", HomingHost: host}
+		recycled.Endpoint.ID = recycled.ID
+		if err := recycled.Write(); err != nil {
+			t.Fatalf("error writing recycled endpoint state. Error: %s", err)
+		}
+	}
+
+	if err := p.RestoreEndpoint("orange.default-host2"); err == nil {
+		t.Fatalf("endpoint of another host restored")
+	}
+
+	if err := p.RestoreEndpoint("orange.default-host1"); err != nil {
+		t.Fatalf("error restoring endpoint. Error: %s", err)
+	}
+	if !nd.wired["orange.default-host1"] {
+		t.Fatalf("restored endpoint not programmed")
+	}
+	epCfg := &mastercfg.CfgEndpointState{}
+	epCfg.StateDriver = fakeStateDriver
+	if err := epCfg.Read("orange.default-host1"); err != nil || epCfg.IPAddress != "10.1.1.2" {
+		t.Fatalf("restored endpoint config not written back, err %v", err)
+	}
+}
+
+func TestGetEffectivePolicy(t *testing.T) {
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	p := &NetPlugin{StateDriver: fakeStateDriver}
+
+	epCfg := &mastercfg.CfgEndpointState{NetID: "orange.default", IPAddress: "10.1.1.2",
+		EndpointGroupID: 10}
+	epCfg.ID = "orange.default-ep1"
+	epCfg.StateDriver = fakeStateDriver
+	if err := epCfg.Write(); err != nil {
+		t.Fatalf("error writing endpoint state. Error: %s", err)
+	}
+
+	rules := []ofnet.OfnetPolicyRule{
+		{RuleId: "web:1:inRx", Priority: 1, DstEndpointGroup: 10, Action: "deny"},
+		{RuleId: "web:2:inRx", Priority: 5, DstEndpointGroup: 10, SrcEndpointGroup: 20, IpProtocol: 6, DstPort: 80, Action: "accept"},
+		{RuleId: "db:1:inRx", Priority: 5, DstEndpointGroup: 20, SrcEndpointGroup: 10, Action: "accept"},
+		{RuleId: "db:2:inRx", Priority: 3, DstEndpointGroup: 20, SrcIpAddr: "10.1.1.0/24", Action: "deny"},
+		{RuleId: "db:3:inRx", Priority: 9, DstEndpointGroup: 20, Action: "deny"},
+		{RuleId: "app:1:inRx", Priority: 2, DstEndpointGroup: 10, DstIpAddr: "10.1.2.0/24", Action: "deny"},
+	}
+	for _, rule := range rules {
+		ruleCfg := &mastercfg.CfgPolicyRule{OfnetPolicyRule: rule, Log: rule.Action == "deny"}
+		ruleCfg.StateDriver = fakeStateDriver
+		if err := ruleCfg.Write(); err != nil {
+			t.Fatalf("error writing rule state. Error: %s", err)
+		}
+	}
+
+	effective, err := p.GetEffectivePolicy("orange.default-ep1")
+	if err != nil {
+		t.Fatalf("error getting effective policy. Error: %s", err)
+	}
+	got := []string{}
+	for _, rule := range effective {
+		got = append(got, rule.RuleId+"/"+rule.Direction)
+	}
+	expected := []string{"db:1:inRx/egress", "web:2:inRx/ingress", "db:2:inRx/egress", "web:1:inRx/ingress"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("unexpected effective policy %v, expected %v", got, expected)
+	}
+	if !effective[3].Log || effective[1].DstPort != 80 {
+		t.Fatalf("effective policy lost rule fields: %+v", effective)
+	}
+
+	if _, err := p.GetEffectivePolicy("orange.default-ep2"); err == nil {
+		t.Fatalf("effective policy of a missing endpoint")
+	}
+}
+
+// gatewayDriver tracks the vxlan gateways in the datapath
+type gatewayDriver struct {
+	interconnectDriver
+	gateways map[string]string
+}
+
+func (d *gatewayDriver) CreateVxlanGateway(gw *mastercfg.VxlanGatewayState) error {
+	d.gateways[gw.ID] = fmt.Sprintf("%s.%d", gw.Uplink, gw.Vlan)
+	return nil
+}
+
+func (d *gatewayDriver) DeleteVxlanGateway(gw *mastercfg.VxlanGatewayState) error {
+	delete(d.gateways, gw.ID)
+	return nil
+}
+
+func TestVxlanGateway(t *testing.T) {
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	p := &NetPlugin{StateDriver: fakeStateDriver, NetworkDriver: &wiringDriver{wired: map[string]bool{}}}
+	p.PluginConfig.Instance.HostLabel = "host1"
+	if err := p.AddVxlanGateway("orange.default", "eth1", 100); err == nil {
+		t.Fatalf("vxlan gateway added with a driver that does not support it")
+	}
+
+	nd := &gatewayDriver{gateways: map[string]string{}}
+	nd.connected = map[string]string{}
+	nd.wired = map[string]bool{}
+	p.NetworkDriver = nd
+
+	for id, tagType := range map[string]string{"orange.default": "vxlan", "blue.default": "vxlan", "green.default": "vlan"} {
+		nwCfg := &mastercfg.CfgNetworkState{PktTagType: tagType}
+		nwCfg.ID = id
+		nwCfg.StateDriver = fakeStateDriver
+		if err := nwCfg.Write(); err != nil {
+			t.Fatalf("error writing network state. Error: %s", err)
+		}
+	}
+
+	for _, d := range []struct {
+		netID  string
+		uplink string
+		vlan   int
+	}{
+		{"green.default", "eth1", 100},
+		{"red.default", "eth1", 100},
+		{"orange.default", "", 100},
+		{"orange.default", "eth1", 0},
+		{"orange.default", "eth1", 4095},
+	} {
+		if err := p.AddVxlanGateway(d.netID, d.uplink, d.vlan); err == nil {
+			t.Fatalf("invalid vxlan gateway %+v added", d)
+		}
+	}
+
+	if err := p.AddVxlanGateway("orange.default", "eth1", 100); err != nil {
+		t.Fatalf("error adding vxlan gateway. Error: %s", err)
+	}
+	if err := p.AddVxlanGateway("orange.default", "eth1", 200); err == nil {
+		t.Fatalf("second vxlan gateway of a network added")
+	}
+	if err := p.AddVxlanGateway("blue.default", "eth1", 100); err == nil {
+		t.Fatalf("vlan bridged to two networks")
+	}
+
+	id := mastercfg.VxlanGatewayID("host1", "orange.default")
+	if nd.gateways[id] != "eth1.100" {
+		t.Fatalf("unexpected vxlan gateways in the datapath: %v", nd.gateways)
+	}
+
+	// resync rebuilds the gateway
+	delete(nd.gateways, id)
+	if err := p.ResyncNetwork("orange.default"); err != nil {
+		t.Fatalf("error resyncing network. Error: %s", err)
+	}
+	if nd.gateways[id] != "eth1.100" {
+		t.Fatalf("vxlan gateway not rebuilt by resync: %v", nd.gateways)
+	}
+
+	if err := p.RemoveVxlanGateway("orange.default"); err != nil {
+		t.Fatalf("error removing vxlan gateway. Error: %s", err)
+	}
+	if len(nd.gateways) != 0 {
+		t.Fatalf("vxlan gateway left in the datapath: %v", nd.gateways)
+	}
+	if err := p.RemoveVxlanGateway("orange.default"); err == nil {
+		t.Fatalf("removal of a missing vxlan gateway succeeded")
+	}
+
+	// deleting a network removes its gateway
+	if err := p.AddVxlanGateway("blue.default", "eth1", 100); err != nil {
+		t.Fatalf("error adding vxlan gateway. Error: %s", err)
+	}
+	if err := p.DeleteNetwork("blue.default", "", "", "", 0, 0, "", ""); err != nil {
+		t.Fatalf("error deleting network. Error: %s", err)
+	}
+	if len(nd.gateways) != 0 {
+		t.Fatalf("vxlan gateway left after network delete: %v", nd.gateways)
+	}
+	gwCfg := &mastercfg.VxlanGatewayState{}
+	gwCfg.StateDriver = fakeStateDriver
+	if err := gwCfg.Read(mastercfg.VxlanGatewayID("host1", "blue.default")); err == nil {
+		t.Fatalf("vxlan gateway state left after network delete")
+	}
+}
+
+func TestParseLinkFlags(t *testing.T) {
+	flags := parseLinkFlags("5: eth0@if6: <BROADCAST,MULTICAST,ALLMULTI,PROMISC,UP,LOWER_UP> mtu 1450 qdisc noqueue state UP")
+	if !reflect.DeepEqual(flags, []string{IntfFlagPromisc, IntfFlagAllMulti}) {
+		t.Fatalf("unexpected flags %v", flags)
+	}
+	if flags := parseLinkFlags("1: lo: <LOOPBACK,UP,LOWER_UP> mtu 65536"); len(flags) != 0 {
+		t.Fatalf("unexpected flags %v", flags)
+	}
+
+	ep := &mastercfg.CfgEndpointState{IntfName: "eth0", AllMulti: true}
+	if args := intfFlagArgs(ep, true); !reflect.DeepEqual(args, []string{"link", "set", "dev", "eth0", "allmulticast", "on"}) {
+		t.Fatalf("unexpected ip arguments %v", args)
+	}
+}
+
+func TestAttachEndpointIntfFlags(t *testing.T) {
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	link := &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "cttestflags0"}, PeerName: "cttestflags1"}
+	if err := netlink.LinkAdd(link); err != nil {
+		t.Skipf("can not create a veth pair: %v", err)
+	}
+	defer netlink.LinkDel(link)
+
+	nd := &wiringDriver{wired: map[string]bool{}}
+	p := &NetPlugin{StateDriver: fakeStateDriver, NetworkDriver: nd}
+
+	nwCfg := &mastercfg.CfgNetworkState{}
+	nwCfg.ID = "orange.default"
+	nwCfg.StateDriver = fakeStateDriver
+	if err := nwCfg.Write(); err != nil {
+		t.Fatalf("error writing network state. Error: %s", err)
+	}
+	epCfg := &mastercfg.CfgEndpointState{
+		NetID:    "orange.default",
+		IntfName: "cttestflags0",
+		Status:   mastercfg.EndpointStatusDetached,
+		Promisc:  true,
+		AllMulti: true,
+	}
+	epCfg.ID = "orange-ep1"
+	epCfg.StateDriver = fakeStateDriver
+	if err := epCfg.Write(); err != nil {
+		t.Fatalf("error writing endpoint state. Error: %s", err)
+	}
+	epOper := &drivers.OperEndpointState{NetID: "orange.default"}
+	epOper.ID = "orange-ep1"
+	epOper.StateDriver = fakeStateDriver
+	if err := epOper.Write(); err != nil {
+		t.Fatalf("error writing endpoint oper state. Error: %s", err)
+	}
+
+	if err := p.AttachEndpoint("orange-ep1", ""); err == nil {
+		t.Fatalf("interface flags set without a netns")
+	}
+	if err := p.AttachEndpoint("orange-ep1", "/proc/self/ns/net"); err != nil {
+		t.Fatalf("error attaching endpoint. Error: %s", err)
+	}
+	state, err := p.FetchEndpoint("orange-ep1")
+	if err != nil {
+		t.Fatalf("error fetching endpoint. Error: %s", err)
+	}
+	if flags := state.(*drivers.OperEndpointState).IntfFlags; !reflect.DeepEqual(flags, []string{IntfFlagPromisc, IntfFlagAllMulti}) {
+		t.Fatalf("unexpected effective flags %v", flags)
+	}
+
+	if err := p.DetachEndpoint("orange-ep1"); err != nil {
+		t.Fatalf("error detaching endpoint. Error: %s", err)
+	}
+	out, err := exec.Command("ip", "-o", "link", "show", "dev", "cttestflags0").CombinedOutput()
+	if err != nil {
+		t.Fatalf("error reading the veth interface. Error: %s", err)
+	}
+	if flags := parseLinkFlags(string(out)); len(flags) != 0 {
+		t.Fatalf("flags %v left on after detach", flags)
+	}
+}
+
+func TestAttachEndpointToContainer(t *testing.T) {
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	nd := &wiringDriver{wired: map[string]bool{}}
+	p := &NetPlugin{StateDriver: fakeStateDriver, NetworkDriver: nd,
+		ContainerRuntime: fakeRuntime{"ctr1": "/proc/10/ns/net"}}
+
+	nwCfg := &mastercfg.CfgNetworkState{}
+	nwCfg.ID = "orange.default"
+	nwCfg.StateDriver = fakeStateDriver
+	if err := nwCfg.Write(); err != nil {
+		t.Fatalf("error writing network state. Error: %s", err)
+	}
+	epCfg := &mastercfg.CfgEndpointState{NetID: "orange.default", Status: mastercfg.EndpointStatusDetached}
+	epCfg.ID = "orange-sidecar1"
+	epCfg.StateDriver = fakeStateDriver
+	if err := epCfg.Write(); err != nil {
+		t.Fatalf("error writing endpoint state. Error: %s", err)
+	}
+
+	if err := p.AttachEndpointToContainer("orange-sidecar1", "/web"); err == nil {
+		t.Fatalf("attached with a runtime that can not look containers up")
+	}
+
+	p.ContainerRuntime = lookupRuntime{fakeRuntime: fakeRuntime{"ctr1": "/proc/10/ns/net"},
+		names: map[string]string{"/web": "ctr1"}}
+	if err := p.AttachEndpointToContainer("orange-sidecar1", "/db"); err == nil {
+		t.Fatalf("attached to an unknown container")
+	}
+	if err := p.AttachEndpointToContainer("orange-sidecar1", "/web"); err != nil {
+		t.Fatalf("error attaching to the container. Error: %s", err)
+	}
+	if err := epCfg.Read("orange-sidecar1"); err != nil {
+		t.Fatalf("error reading endpoint state. Error: %s", err)
+	}
+	if !nd.wired["orange-sidecar1"] || epCfg.NetnsPath != "/proc/10/ns/net" {
+		t.Fatalf("endpoint not attached in the netns of the container: %+v", epCfg)
+	}
+
+	if err := p.DetachEndpoint("orange-sidecar1"); err != nil {
+		t.Fatalf("error detaching endpoint. Error: %s", err)
+	}
+	if nd.wired["orange-sidecar1"] {
+		t.Fatalf("endpoint still wired after the detach")
+	}
+}
@@ -0,0 +1,48 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"github.com/Sirupsen/logrus"
+)
+
+// overlayKeyUpdater is implemented by network drivers that encrypt their
+// tunnels with the overlay key
+type overlayKeyUpdater interface {
+	UpdateOverlayKey() error
+}
+
+// UpdateOverlayKey rekeys the encrypted tunnels of the drivers after the
+// overlay key changed
+func (p *NetPlugin) UpdateOverlayKey() error {
+	p.Lock()
+	defer p.Unlock()
+	if p.NetworkDriver == nil {
+		return p.driverErr()
+	}
+
+	for _, driver := range p.networkDrivers() {
+		updater, ok := driver.(overlayKeyUpdater)
+		if !ok {
+			continue
+		}
+		if err := updater.UpdateOverlayKey(); err != nil {
+			logrus.Errorf("Error updating the overlay key. Err: %v", err)
+			return err
+		}
+	}
+	return nil
+}
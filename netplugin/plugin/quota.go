@@ -0,0 +1,82 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"github.com/contiv/netplugin/netmaster/master"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+)
+
+// TenantQuota is the limits of the networks, endpoints and IPv4 addresses a
+// tenant can consume, a limit of zero leaves the resource unlimited
+type TenantQuota struct {
+	Networks  int `json:"networks"`
+	Endpoints int `json:"endpoints"`
+	Addresses int `json:"addresses"`
+}
+
+// TenantQuotaStatus is the quota of a tenant and what it consumes
+type TenantQuotaStatus struct {
+	Tenant string             `json:"tenant"`
+	Quota  TenantQuota        `json:"quota"`
+	Usage  master.TenantUsage `json:"usage"`
+}
+
+// SetTenantQuota sets the quota of tenant, the network and endpoint creates
+// that would take it over the quota fail from then on. A quota of no limits
+// removes it.
+func (p *NetPlugin) SetTenantQuota(tenant string, quota TenantQuota) error {
+	p.Lock()
+	defer p.Unlock()
+	if p.StateDriver == nil {
+		return ErrNotInitialized
+	}
+
+	quotaCfg := &mastercfg.TenantQuotaState{
+		Networks:  quota.Networks,
+		Endpoints: quota.Endpoints,
+		Addresses: quota.Addresses,
+	}
+	quotaCfg.ID = tenant
+	return master.SetTenantQuota(p.StateDriver, quotaCfg)
+}
+
+// GetTenantQuota returns the quota of tenant and what it consumes
+func (p *NetPlugin) GetTenantQuota(tenant string) (TenantQuotaStatus, error) {
+	p.RLock()
+	defer p.RUnlock()
+	if p.StateDriver == nil {
+		return TenantQuotaStatus{}, ErrNotInitialized
+	}
+
+	quotaCfg, err := master.GetTenantQuota(p.StateDriver, tenant)
+	if err != nil {
+		return TenantQuotaStatus{}, err
+	}
+	usage, err := master.GetTenantUsage(p.StateDriver, tenant)
+	if err != nil {
+		return TenantQuotaStatus{}, err
+	}
+	return TenantQuotaStatus{
+		Tenant: tenant,
+		Quota: TenantQuota{
+			Networks:  quotaCfg.Networks,
+			Endpoints: quotaCfg.Endpoints,
+			Addresses: quotaCfg.Addresses,
+		},
+		Usage: *usage,
+	}, nil
+}
@@ -0,0 +1,105 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/contiv/netplugin/core"
+)
+
+// ErrRateLimited is returned by the create and delete methods when the
+// plugin is over its operation rate
+var ErrRateLimited = errors.New("netplugin operation rate limited")
+
+// RateLimitConfig limits the rate of the create and delete operations of
+// the plugin. Reads are not limited.
+type RateLimitConfig struct {
+	Rate  float64 `json:"rate"`  // operations per second, 0 is unlimited
+	Burst int     `json:"burst"` // operations allowed at once, 1 when unset
+	Wait  int     `json:"wait"`  // ms to wait for the rate, 0 fails at once
+}
+
+// tokenBucket is a token bucket refilled at rate tokens per second up to
+// burst tokens
+type tokenBucket struct {
+	sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+}
+
+// newTokenBucket returns the bucket of cfg, nil when the rate is unlimited
+func newTokenBucket(cfg RateLimitConfig) (*tokenBucket, error) {
+	if cfg.Rate < 0 || cfg.Burst < 0 || cfg.Wait < 0 {
+		return nil, core.Errorf("invalid rate limit %+v", cfg)
+	}
+	if cfg.Rate == 0 {
+		return nil, nil
+	}
+
+	burst := float64(cfg.Burst)
+	if burst == 0 {
+		burst = 1
+	}
+	return &tokenBucket{rate: cfg.Rate, burst: burst, tokens: burst, last: time.Now(), now: time.Now}, nil
+}
+
+// reserve takes a token and returns when it is available. It takes none and
+// returns false when the token is not available within maxWait.
+func (b *tokenBucket) reserve(maxWait time.Duration) (time.Duration, bool) {
+	b.Lock()
+	defer b.Unlock()
+
+	now := b.now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+
+	var wait time.Duration
+	if b.tokens < 1 {
+		wait = time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+	}
+	if wait > maxWait {
+		return 0, false
+	}
+	b.tokens--
+	return wait, true
+}
+
+// rateLimit waits for the operation rate of the plugin, or returns
+// ErrRateLimited. It must be called without holding the plugin lock.
+func (p *NetPlugin) rateLimit() error {
+	p.RLock()
+	limiter, maxWait := p.limiter, time.Duration(p.PluginConfig.RateLimit.Wait)*time.Millisecond
+	p.RUnlock()
+	if limiter == nil {
+		return nil
+	}
+
+	wait, ok := limiter.reserve(maxWait)
+	if !ok {
+		return ErrRateLimited
+	}
+	time.Sleep(wait)
+	return nil
+}
@@ -0,0 +1,162 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"github.com/Sirupsen/logrus"
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/drivers"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+)
+
+// isRemoteEndpoint tells whether an endpoint is wired on another host. Infra
+// endpoints of this host carry a vtep IP and are handled as remote ones.
+func isRemoteEndpoint(ep *mastercfg.CfgEndpointState, hostLabel string) bool {
+	return (ep.VtepIP == "" && ep.HomingHost != hostLabel) ||
+		(ep.VtepIP != "" && ep.HomingHost == hostLabel)
+}
+
+// reconcileLock takes the cluster wide reconcile lock, so that the state is
+// not reconciled by another agent or the netmaster at the same time
+func (p *NetPlugin) reconcileLock() (func(), error) {
+	unlock, err := p.StateDriver.Lock(mastercfg.ReconcileLockKey)
+	if err != nil {
+		return nil, core.Errorf("the reconcile lock is not available. Err: %v", err)
+	}
+	return unlock, nil
+}
+
+// ResyncNetwork reprograms the datapath of a single network from state: the
+// network itself, its interconnects, custom flows and vxlan gateway, the
+// endpoints wired on this host, each by its own driver, with their mirrors,
+// and the remote endpoints.
+// Local wiring left behind by deleted or detached endpoints of the network
+// is removed. Other networks are not touched.
+func (p *NetPlugin) ResyncNetwork(networkID string) error {
+	p.Lock()
+	defer p.Unlock()
+	if p.NetworkDriver == nil {
+		return p.driverErr()
+	}
+	if p.StateDriver == nil {
+		return ErrNotInitialized
+	}
+	unlock, err := p.reconcileLock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	nwCfg := &mastercfg.CfgNetworkState{}
+	nwCfg.StateDriver = p.StateDriver
+	if err := nwCfg.Read(networkID); err != nil {
+		return err
+	}
+
+	logrus.Infof("Resyncing network %s", networkID)
+	if err := p.createNetwork(networkID); err != nil {
+		return err
+	}
+	if err := p.reconnectNetwork(networkID); err != nil {
+		return err
+	}
+	if err := p.reprogramNetworkFlows(networkID); err != nil {
+		return err
+	}
+	if err := p.rebuildVxlanGateways(networkID); err != nil {
+		return err
+	}
+
+	epCfg := &mastercfg.CfgEndpointState{}
+	epCfg.StateDriver = p.StateDriver
+	epCfgs, err := epCfg.ReadAll()
+	if core.ErrIfKeyExists(err) != nil {
+		return err
+	}
+	endpoints := make(map[string]*mastercfg.CfgEndpointState)
+	for _, state := range epCfgs {
+		ep := state.(*mastercfg.CfgEndpointState)
+		if ep.NetID == networkID {
+			endpoints[ep.ID] = ep
+		}
+	}
+
+	epOper := &drivers.OperEndpointState{}
+	epOper.StateDriver = p.StateDriver
+	epOpers, err := epOper.ReadAll()
+	if core.ErrIfKeyExists(err) != nil {
+		return err
+	}
+
+	// endpoints with oper state of this host are the ones wired on it, the
+	// oper state of the other hosts is theirs to reconcile
+	hostLabel := p.PluginConfig.Instance.HostLabel
+	wired := make(map[string]bool)
+	for _, state := range epOpers {
+		oper := state.(*drivers.OperEndpointState)
+		if oper.NetID != networkID || oper.HomingHost != hostLabel {
+			continue
+		}
+		wired[oper.ID] = true
+
+		// each endpoint is reprogrammed by its own driver
+		ep, found := endpoints[oper.ID]
+		if !found || ep.Status == mastercfg.EndpointStatusDetached {
+			logrus.Infof("Removing stale endpoint %s of network %s", oper.ID, networkID)
+			driver, err := p.endpointDriver(oper.ID)
+			if err != nil {
+				return err
+			}
+			if err := driver.DeleteEndpoint(oper.ID); err != nil {
+				logrus.Errorf("Error removing stale endpoint %s. Err: %v", oper.ID, err)
+				return err
+			}
+			p.endpointDeleted(oper.ID)
+			continue
+		}
+
+		driver, err := p.driverByName(ep.Driver)
+		if err != nil {
+			return err
+		}
+		if err := driver.CreateEndpoint(oper.ID); err != nil {
+			logrus.Errorf("Error reprogramming endpoint %s. Err: %v", oper.ID, err)
+			return err
+		}
+		p.endpointCreated(oper.ID, driver)
+		p.rebuildMirrors(driver, oper.ID)
+		// host endpoints are addressed by the plugin of their host, not by
+		// a runtime
+		if ep.HostEndpoint && ep.HomingHost == hostLabel {
+			if err := p.configureHostEndpoint(ep, nwCfg); err != nil {
+				logrus.Errorf("Error reconfiguring host endpoint %s. Err: %v", oper.ID, err)
+				return err
+			}
+		}
+	}
+
+	for id, ep := range endpoints {
+		if wired[id] || !isRemoteEndpoint(ep, hostLabel) {
+			continue
+		}
+		if err := p.NetworkDriver.CreateRemoteEndpoint(id); err != nil {
+			logrus.Errorf("Error reprogramming remote endpoint %s. Err: %v", id, err)
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,160 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"sort"
+
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/netmaster/master"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+)
+
+// healthChecker is implemented by drivers that can tell whether they are
+// able to do their job, e.g. whether they reach their switch
+type healthChecker interface {
+	CheckHealth() error
+}
+
+// Driver roles of a DriverStatus
+const (
+	DriverRoleState    = "state"
+	DriverRoleNetwork  = "network"
+	DriverRoleEndpoint = "endpoint"
+)
+
+// DriverStatus is the health of a driver of the plugin
+type DriverStatus struct {
+	Name    string
+	Role    string // DriverRoleState, DriverRoleNetwork or DriverRoleEndpoint
+	Healthy bool
+	Error   string // why the driver is not healthy
+}
+
+// IPPoolSummary sums up the IPv4 address pools of the networks allocated by
+// netmaster
+type IPPoolSummary struct {
+	Allocated int
+	Usable    int
+	Exhausted []string // networks without a free address, sorted
+}
+
+// PluginStatus is the health of the plugin, as reported by GetPluginStatus
+type PluginStatus struct {
+	Observer  bool
+	Drivers   []DriverStatus
+	Networks  int
+	Endpoints int // on all the hosts
+	IPPools   IPPoolSummary
+}
+
+// driverStatus returns the health of driver name, unhealthy when it is not
+// initialized
+func driverStatus(name, role string, driver interface{}) DriverStatus {
+	status := DriverStatus{Name: name, Role: role}
+	if driver == nil {
+		status.Error = ErrNotInitialized.Error()
+		return status
+	}
+	if checker, ok := driver.(healthChecker); ok {
+		if err := checker.CheckHealth(); err != nil {
+			status.Error = err.Error()
+			return status
+		}
+	}
+	status.Healthy = true
+	return status
+}
+
+// GetPluginStatus returns the health of the drivers of the plugin, with the
+// network and endpoint counts and the address pool utilization. The state
+// driver is reported unhealthy when the state can not be read, the counts
+// are then left out. The state is read with serializable reads, recent
+// changes may be missing.
+func (p *NetPlugin) GetPluginStatus() (PluginStatus, error) {
+	p.Lock()
+	defer p.Unlock()
+	if p.StateDriver == nil {
+		return PluginStatus{}, ErrNotInitialized
+	}
+
+	cfg := p.PluginConfig
+	status := PluginStatus{Observer: cfg.Observer}
+
+	// the network driver is only missing in observer mode, by design
+	if !cfg.Observer {
+		var driver interface{}
+		if p.NetworkDriver != nil {
+			driver = p.NetworkDriver
+		}
+		status.Drivers = append(status.Drivers, driverStatus(cfg.Drivers.Network, DriverRoleNetwork, driver))
+	}
+	names := []string{}
+	for name := range p.endpointDrivers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		status.Drivers = append(status.Drivers, driverStatus(name, DriverRoleEndpoint, p.endpointDrivers[name]))
+	}
+
+	stateStatus := driverStatus(cfg.Drivers.State, DriverRoleState, p.StateDriver)
+	err := p.stateCounts(&status)
+	if err != nil {
+		stateStatus.Healthy = false
+		stateStatus.Error = err.Error()
+	}
+	status.Drivers = append([]DriverStatus{stateStatus}, status.Drivers...)
+
+	return status, nil
+}
+
+// stateCounts fills in the network and endpoint counts and the address pool
+// summary of status from the state
+func (p *NetPlugin) stateCounts(status *PluginStatus) error {
+	readNw := &mastercfg.CfgNetworkState{}
+	readNw.StateDriver = p.StateDriver
+	nwCfgs, err := readNw.ReadAllWith(core.Serializable)
+	if core.ErrIfKeyExists(err) != nil {
+		return err
+	}
+
+	readEp := &mastercfg.CfgEndpointState{}
+	readEp.StateDriver = p.StateDriver
+	epCfgs, err := readEp.ReadAllWith(core.Serializable)
+	if core.ErrIfKeyExists(err) != nil {
+		return err
+	}
+
+	status.Networks = len(nwCfgs)
+	status.Endpoints = len(epCfgs)
+	status.IPPools = IPPoolSummary{Exhausted: []string{}}
+	for _, state := range nwCfgs {
+		nwCfg := state.(*mastercfg.CfgNetworkState)
+		// networks without a pool, e.g. dhcp ones, are left out
+		allocated, usable, err := master.IPPoolUtilization(nwCfg)
+		if err != nil {
+			continue
+		}
+		status.IPPools.Allocated += allocated
+		status.IPPools.Usable += usable
+		if allocated >= usable {
+			status.IPPools.Exhausted = append(status.IPPools.Exhausted, nwCfg.ID)
+		}
+	}
+	sort.Strings(status.IPPools.Exhausted)
+	return nil
+}
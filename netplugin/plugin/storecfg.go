@@ -0,0 +1,212 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+	"github.com/contiv/netplugin/utils"
+	"golang.org/x/net/context"
+)
+
+// ConfigKey is the state store key of the plugin config shared by all hosts
+const ConfigKey = mastercfg.StateBasePath + "netplugin/config"
+
+// mergeStoredConfig overlays the stored config on the bootstrap config. The
+// state driver settings and host label always come from the bootstrap
+// config, they are needed to reach the store in the first place. Unknown
+// fields, typos most of the time, reject the stored config unless the
+// bootstrap config is loose.
+func mergeStoredConfig(bootstrap Config, data []byte) (Config, error) {
+	unknown, err := utils.UnknownJSONFields(data, &Config{})
+	if err != nil {
+		return bootstrap, core.Errorf("invalid plugin config in %s: %v", ConfigKey, err)
+	}
+	if len(unknown) != 0 {
+		if !bootstrap.LooseConfig {
+			return bootstrap, core.Errorf("unknown fields in the plugin config in %s: %s",
+				ConfigKey, strings.Join(unknown, ", "))
+		}
+		logrus.Warnf("Ignoring unknown fields in the plugin config in %s: %s", ConfigKey, strings.Join(unknown, ", "))
+	}
+
+	// round trip the bootstrap config so the result shares no maps or
+	// slices with it
+	cfg := Config{}
+	bootData, err := json.Marshal(bootstrap)
+	if err != nil {
+		return bootstrap, err
+	}
+	if err := json.Unmarshal(bootData, &cfg); err != nil {
+		return bootstrap, err
+	}
+
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return bootstrap, core.Errorf("invalid plugin config in %s: %v", ConfigKey, err)
+	}
+
+	cfg.Drivers.State = bootstrap.Drivers.State
+	cfg.Instance.DbURL = bootstrap.Instance.DbURL
+	cfg.Instance.HostLabel = bootstrap.Instance.HostLabel
+	cfg.Instance.StateDriver = bootstrap.Instance.StateDriver
+	cfg.Instance.StateFormat = bootstrap.Instance.StateFormat
+	cfg.Instance.StateMetrics = bootstrap.Instance.StateMetrics
+	cfg.Instance.StateReadEndpoints = bootstrap.Instance.StateReadEndpoints
+	cfg.ConfigFromStore = bootstrap.ConfigFromStore
+	cfg.LooseConfig = bootstrap.LooseConfig
+	return cfg, nil
+}
+
+// InitFromStore initializes the plugin in two phases. The state driver is
+// brought up from the bootstrap config, the rest of the config is then read
+// from ConfigKey. The bootstrap config is used as is when nothing is stored.
+func (p *NetPlugin) InitFromStore(bootstrap Config) error {
+	return p.init(bootstrap, p.loadStoredConfig)
+}
+
+func (p *NetPlugin) loadStoredConfig(bootstrap Config) (Config, error) {
+	p.bootstrap = bootstrap
+
+	data, err := p.StateDriver.Read(ConfigKey)
+	if err != nil {
+		if core.ErrIfKeyExists(err) != nil {
+			return bootstrap, err
+		}
+		logrus.Infof("No plugin config at %s, using the local config", ConfigKey)
+		return bootstrap, nil
+	}
+
+	logrus.Infof("Using the plugin config at %s", ConfigKey)
+	return mergeStoredConfig(bootstrap, data)
+}
+
+// WatchConfig reloads the plugin whenever the config at ConfigKey changes,
+// until ctx is cancelled, which stops the underlying watch. Deleting the
+// stored config keeps the current one.
+func (p *NetPlugin) WatchConfig(ctx context.Context) error {
+	if p.StateDriver == nil {
+		return ErrNotInitialized
+	}
+	if _, ok := p.StateDriver.(core.StoppableWatcher); !ok {
+		return core.Errorf("state driver can not stop its watches")
+	}
+
+	rsps := make(chan [2][]byte)
+	go func() {
+		for {
+			// the watch either runs in the background or returns once
+			// stopped, it is only restarted when it fails
+			err := core.WatchAllUntil(p.StateDriver, ConfigKey, rsps, ctx.Done())
+			if err == nil {
+				<-ctx.Done()
+				return
+			}
+
+			logrus.Errorf("Plugin config watch stopped, restarting. Err: %v", err)
+			select {
+			case <-time.After(watchRetryInterval):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case rsp := <-rsps:
+				if rsp[0] == nil {
+					logrus.Infof("Plugin config at %s was deleted, keeping the current config", ConfigKey)
+					continue
+				}
+				cfg, err := mergeStoredConfig(p.bootstrap, rsp[0])
+				if err != nil {
+					logrus.Errorf("Ignoring plugin config update. Err: %v", err)
+					continue
+				}
+				if err := p.Reload(cfg); err != nil {
+					logrus.Errorf("Error reloading the plugin config. Err: %v", err)
+				}
+			}
+		}
+	}()
+
+	return nil
+}
+
+// Reload applies a new config to a running plugin. The state driver is
+// kept, the network driver is re-initialized with the new config.
+func (p *NetPlugin) Reload(cfg Config) error {
+	p.Lock()
+	cur := p.PluginConfig
+	cfg.Instance.StateDriver = p.StateDriver
+	p.Unlock()
+
+	if reflect.DeepEqual(cfg, cur) {
+		return nil
+	}
+
+	// feature flags are read at runtime, changing only them needs no
+	// reinit
+	flagsOnly := cfg
+	flagsOnly.Features = cur.Features
+	if reflect.DeepEqual(flagsOnly, cur) {
+		logrus.Infof("Reloading the feature flags")
+		p.setFeatures(cfg.Features)
+		p.Lock()
+		p.PluginConfig.Features = cfg.Features
+		p.Unlock()
+		return nil
+	}
+	if cfg.Observer != cur.Observer {
+		return core.Errorf("observer mode can not be changed by a reload")
+	}
+	if cfg.Drivers.State != cur.Drivers.State || cfg.Instance.DbURL != cur.Instance.DbURL {
+		return core.Errorf("the state driver can not be changed by a reload")
+	}
+	limiter, err := newTokenBucket(cfg.RateLimit)
+	if err != nil {
+		return err
+	}
+
+	logrus.Infof("Reloading the plugin config")
+	// the config is set before the reinit, which starts the link monitor
+	// from it
+	p.Lock()
+	p.PluginConfig = cfg
+	p.setFeatures(cfg.Features)
+	// keep the current bucket, and its tokens, when the limit is unchanged
+	if !reflect.DeepEqual(cfg.RateLimit, cur.RateLimit) {
+		p.limiter = limiter
+	}
+	p.Unlock()
+	p.Reinit(cfg)
+
+	p.RLock()
+	defer p.RUnlock()
+	if p.NetworkDriver == nil && !cfg.Observer {
+		return core.Errorf("network driver %q failed to initialize", cfg.Drivers.Network)
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+)
+
+// StateProblem describes a record in the state store that fails to decode
+// or violates an invariant. ID is empty when the record could not be decoded.
+type StateProblem struct {
+	Resource string // "network" or "endpoint"
+	ID       string
+	Problem  string
+}
+
+// VerifyState audits the network and endpoint records in the state store and
+// returns the problems found. It never modifies the store.
+func (p *NetPlugin) VerifyState() ([]StateProblem, error) {
+	p.Lock()
+	defer p.Unlock()
+	if p.StateDriver == nil {
+		return nil, ErrNotInitialized
+	}
+
+	problems := []StateProblem{}
+
+	nwCfg := &mastercfg.CfgNetworkState{}
+	nwCfg.StateDriver = p.StateDriver
+	nwRecords, err := nwCfg.ReadAllRaw()
+	if err != nil && core.ErrIfKeyExists(err) != nil {
+		return nil, err
+	}
+
+	networks := map[string]*mastercfg.CfgNetworkState{}
+	for idx, rec := range nwRecords {
+		nw := &mastercfg.CfgNetworkState{}
+		if err := json.Unmarshal(rec, nw); err != nil {
+			problems = append(problems, StateProblem{
+				Resource: "network",
+				Problem:  fmt.Sprintf("record %d does not decode: %v", idx, err),
+			})
+			continue
+		}
+		if nw.ID == "" {
+			problems = append(problems, StateProblem{
+				Resource: "network",
+				Problem:  fmt.Sprintf("record %d has no id", idx),
+			})
+			continue
+		}
+		networks[nw.ID] = nw
+	}
+
+	epCfg := &mastercfg.CfgEndpointState{}
+	epCfg.StateDriver = p.StateDriver
+	epRecords, err := epCfg.ReadAllRaw()
+	if err != nil && core.ErrIfKeyExists(err) != nil {
+		return nil, err
+	}
+
+	// network id -> address -> endpoint using it
+	addrOwners := map[string]map[string]string{}
+	for idx, rec := range epRecords {
+		ep := &mastercfg.CfgEndpointState{}
+		if err := json.Unmarshal(rec, ep); err != nil {
+			problems = append(problems, StateProblem{
+				Resource: "endpoint",
+				Problem:  fmt.Sprintf("record %d does not decode: %v", idx, err),
+			})
+			continue
+		}
+
+		addProblem := func(format string, args ...interface{}) {
+			problems = append(problems, StateProblem{
+				Resource: "endpoint",
+				ID:       ep.ID,
+				Problem:  fmt.Sprintf(format, args...),
+			})
+		}
+
+		nw, found := networks[ep.NetID]
+		if !found {
+			addProblem("references missing network %q", ep.NetID)
+			continue
+		}
+
+		if addrOwners[nw.ID] == nil {
+			addrOwners[nw.ID] = map[string]string{}
+		}
+		for _, addr := range []string{ep.IPAddress, ep.IPv6Address} {
+			if addr == "" {
+				continue
+			}
+			if msg := checkAddrInSubnet(nw, addr); msg != "" {
+				addProblem("%s", msg)
+			}
+			if owner, used := addrOwners[nw.ID][addr]; used {
+				addProblem("address %s is also used by endpoint %s", addr, owner)
+			} else {
+				addrOwners[nw.ID][addr] = ep.ID
+			}
+		}
+	}
+
+	return problems, nil
+}
+
+// checkAddrInSubnet returns a description of the problem if addr is not an
+// address of the network's subnet, or an empty string if it is.
+func checkAddrInSubnet(nw *mastercfg.CfgNetworkState, addr string) string {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return fmt.Sprintf("address %q is not valid", addr)
+	}
+
+	subnetIP, subnetLen := nw.SubnetIP, nw.SubnetLen
+	if ip.To4() == nil {
+		subnetIP, subnetLen = nw.IPv6Subnet, nw.IPv6SubnetLen
+	}
+	_, subnet, err := net.ParseCIDR(fmt.Sprintf("%s/%d", subnetIP, subnetLen))
+	if err != nil {
+		return fmt.Sprintf("address %s has no matching subnet in network %s", addr, nw.ID)
+	}
+	if !subnet.Contains(ip) {
+		return fmt.Sprintf("address %s is outside subnet %s of network %s", addr, subnet, nw.ID)
+	}
+	return ""
+}
@@ -0,0 +1,194 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"github.com/Sirupsen/logrus"
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+)
+
+// vxlanGatewayer is implemented by network drivers that can bridge a vlan
+// of a host interface to a vxlan network. Creating a gateway again rebuilds
+// what is missing of it.
+type vxlanGatewayer interface {
+	CreateVxlanGateway(gw *mastercfg.VxlanGatewayState) error
+	DeleteVxlanGateway(gw *mastercfg.VxlanGatewayState) error
+}
+
+// vxlanGatewayDriver returns the network driver as a vxlanGatewayer
+func (p *NetPlugin) vxlanGatewayDriver() (vxlanGatewayer, error) {
+	if p.NetworkDriver == nil {
+		return nil, p.driverErr()
+	}
+	driver, ok := p.NetworkDriver.(vxlanGatewayer)
+	if !ok {
+		return nil, core.Errorf("network driver does not support vxlan gateways")
+	}
+	return driver, nil
+}
+
+// AddVxlanGateway bridges vlan of host interface uplink to vxlan network
+// networkID on this host, so that the hosts of a legacy vlan segment reach
+// the endpoints of the overlay. A network has one gateway per host and a
+// vlan of an interface is bridged to one network. The gateway is recorded
+// in the state store and rebuilt when the network is resynced.
+func (p *NetPlugin) AddVxlanGateway(networkID, uplink string, vlan int) error {
+	if err := p.rateLimit(); err != nil {
+		return err
+	}
+	p.Lock()
+	defer p.Unlock()
+	driver, err := p.vxlanGatewayDriver()
+	if err != nil {
+		return err
+	}
+	if p.StateDriver == nil {
+		return ErrNotInitialized
+	}
+	if uplink == "" {
+		return core.Errorf("vxlan gateway of network %s has no uplink", networkID)
+	}
+	if vlan < 1 || vlan > 4094 {
+		return core.Errorf("invalid vlan %d for the vxlan gateway of network %s", vlan, networkID)
+	}
+
+	nwCfg := &mastercfg.CfgNetworkState{}
+	nwCfg.StateDriver = p.StateDriver
+	if err := nwCfg.Read(networkID); err != nil {
+		return err
+	}
+	if nwCfg.PktTagType != "vxlan" {
+		return core.Errorf("network %s is not a vxlan network", networkID)
+	}
+
+	hostLabel := p.PluginConfig.Instance.HostLabel
+	gws, err := p.vxlanGateways("")
+	if err != nil {
+		return err
+	}
+	for _, gw := range gws {
+		if gw.NetID == networkID {
+			return core.Errorf("network %s already has a vxlan gateway", networkID)
+		}
+		if gw.Uplink == uplink && gw.Vlan == vlan {
+			return core.Errorf("vlan %d of %s is already bridged to network %s", vlan, uplink, gw.NetID)
+		}
+	}
+
+	gwCfg := &mastercfg.VxlanGatewayState{Host: hostLabel, NetID: networkID, Uplink: uplink, Vlan: vlan}
+	gwCfg.StateDriver = p.StateDriver
+	gwCfg.ID = mastercfg.VxlanGatewayID(hostLabel, networkID)
+	if err := driver.CreateVxlanGateway(gwCfg); err != nil {
+		logrus.Errorf("Error creating vxlan gateway of network %s. Err: %v", networkID, err)
+		if err := driver.DeleteVxlanGateway(gwCfg); err != nil {
+			logrus.Errorf("Error removing vxlan gateway %s. Err: %v", gwCfg.ID, err)
+		}
+		return err
+	}
+	return gwCfg.Write()
+}
+
+// RemoveVxlanGateway removes the vxlan gateway of network networkID on this
+// host
+func (p *NetPlugin) RemoveVxlanGateway(networkID string) error {
+	if err := p.rateLimit(); err != nil {
+		return err
+	}
+	p.Lock()
+	defer p.Unlock()
+	driver, err := p.vxlanGatewayDriver()
+	if err != nil {
+		return err
+	}
+	if p.StateDriver == nil {
+		return ErrNotInitialized
+	}
+
+	gwCfg := &mastercfg.VxlanGatewayState{}
+	gwCfg.StateDriver = p.StateDriver
+	if err := gwCfg.Read(mastercfg.VxlanGatewayID(p.PluginConfig.Instance.HostLabel, networkID)); err != nil {
+		return core.Errorf("network %s has no vxlan gateway", networkID)
+	}
+	return p.removeVxlanGateway(driver, gwCfg)
+}
+
+func (p *NetPlugin) removeVxlanGateway(driver vxlanGatewayer, gwCfg *mastercfg.VxlanGatewayState) error {
+	if err := driver.DeleteVxlanGateway(gwCfg); err != nil {
+		logrus.Errorf("Error deleting vxlan gateway of network %s. Err: %v", gwCfg.NetID, err)
+		return err
+	}
+	return gwCfg.Clear()
+}
+
+// vxlanGateways returns the vxlan gateways of network netID on this host,
+// all the gateways of this host when netID is empty
+func (p *NetPlugin) vxlanGateways(netID string) ([]*mastercfg.VxlanGatewayState, error) {
+	gwCfg := &mastercfg.VxlanGatewayState{}
+	gwCfg.StateDriver = p.StateDriver
+	gwCfgs, err := gwCfg.ReadAll()
+	if core.ErrIfKeyExists(err) != nil {
+		return nil, err
+	}
+
+	gws := []*mastercfg.VxlanGatewayState{}
+	for _, state := range gwCfgs {
+		gw := state.(*mastercfg.VxlanGatewayState)
+		if gw.Host == p.PluginConfig.Instance.HostLabel && (netID == "" || gw.NetID == netID) {
+			gws = append(gws, gw)
+		}
+	}
+	return gws, nil
+}
+
+// rebuildVxlanGateways rebuilds the vxlan gateway of network netID on this
+// host
+func (p *NetPlugin) rebuildVxlanGateways(netID string) error {
+	driver, ok := p.NetworkDriver.(vxlanGatewayer)
+	if !ok {
+		return nil
+	}
+	gws, err := p.vxlanGateways(netID)
+	if err != nil {
+		return err
+	}
+	for _, gw := range gws {
+		if err := driver.CreateVxlanGateway(gw); err != nil {
+			logrus.Errorf("Error rebuilding vxlan gateway of network %s. Err: %v", netID, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// removeVxlanGateways removes the vxlan gateway of network netID on this
+// host, before the network is deleted
+func (p *NetPlugin) removeVxlanGateways(netID string) error {
+	driver, ok := p.NetworkDriver.(vxlanGatewayer)
+	if !ok || p.StateDriver == nil {
+		return nil
+	}
+	gws, err := p.vxlanGateways(netID)
+	if err != nil {
+		return err
+	}
+	for _, gw := range gws {
+		if err := p.removeVxlanGateway(driver, gw); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/drivers"
+	"golang.org/x/net/context"
+)
+
+// EndpointEventType identifies the kind of change reported for an endpoint
+type EndpointEventType string
+
+const (
+	// EndpointCreated is reported when a new endpoint shows up
+	EndpointCreated EndpointEventType = "create"
+	// EndpointUpdated is reported when an existing endpoint changes
+	EndpointUpdated EndpointEventType = "modify"
+	// EndpointDeleted is reported when an endpoint goes away
+	EndpointDeleted EndpointEventType = "delete"
+)
+
+// watchRetryInterval is the delay before re-establishing a failed watch
+const watchRetryInterval = time.Second
+
+// EndpointEvent is a single endpoint lifecycle change. State holds the new
+// state of the endpoint, or the last known state for deletes.
+type EndpointEvent struct {
+	Type  EndpointEventType
+	ID    string
+	State *drivers.OperEndpointState
+}
+
+// WatchEndpoints streams endpoint lifecycle events until ctx is cancelled,
+// at which point the underlying watch is stopped and the returned channel is
+// closed. The underlying watch is re-established if the state store
+// connection drops.
+func (p *NetPlugin) WatchEndpoints(ctx context.Context) (<-chan EndpointEvent, error) {
+	if p.StateDriver == nil {
+		return nil, ErrNotInitialized
+	}
+	if _, ok := p.StateDriver.(core.StoppableWatcher); !ok {
+		return nil, core.Errorf("state driver can not stop its watches")
+	}
+
+	rsps := make(chan core.WatchState)
+	events := make(chan EndpointEvent)
+
+	go p.watchEndpointState(ctx, rsps)
+	go func() {
+		defer close(events)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case rsp := <-rsps:
+				ev, ok := endpointEventFromWatch(rsp)
+				if !ok {
+					continue
+				}
+				select {
+				case events <- ev:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return events, nil
+}
+
+// watchEndpointState runs the state driver watch, restarting it on errors
+// until ctx is cancelled
+func (p *NetPlugin) watchEndpointState(ctx context.Context, rsps chan core.WatchState) {
+	for {
+		epOper := &drivers.OperEndpointState{}
+		epOper.StateDriver = p.StateDriver
+		err := epOper.WatchAllUntil(rsps, ctx.Done())
+
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		logrus.Errorf("Endpoint watch stopped, restarting. Err: %v", err)
+		select {
+		case <-time.After(watchRetryInterval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// endpointEventFromWatch translates a state watch response into an
+// endpoint event
+func endpointEventFromWatch(rsp core.WatchState) (EndpointEvent, bool) {
+	ev := EndpointEvent{}
+
+	switch {
+	case rsp.Curr != nil && rsp.Prev != nil:
+		ev.Type = EndpointUpdated
+	case rsp.Curr != nil:
+		ev.Type = EndpointCreated
+	case rsp.Prev != nil:
+		ev.Type = EndpointDeleted
+	default:
+		return ev, false
+	}
+
+	state := rsp.Curr
+	if state == nil {
+		state = rsp.Prev
+	}
+	epOper, ok := state.(*drivers.OperEndpointState)
+	if !ok {
+		logrus.Errorf("Unexpected state type %T in endpoint watch", state)
+		return ev, false
+	}
+
+	ev.ID = epOper.ID
+	ev.State = epOper
+	return ev, true
+}
@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"net/url"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/contiv/netplugin/core"
@@ -41,6 +42,13 @@ type ConsulStateDriverConfig struct {
 // key-value store used to store config and runtime state for the netplugin.
 type ConsulStateDriver struct {
 	Client *api.Client
+
+	// Lock gives up after LockTimeout, the default is used when zero.
+	LockTimeout time.Duration
+
+	// Format of the records written, JSON when nil. Records in any format
+	// are read.
+	Format core.RecordFormat
 }
 
 // Init the driver with a core.Config.
@@ -60,6 +68,10 @@ func (d *ConsulStateDriver) Init(instInfo *core.InstanceInfo) error {
 	} else if endpoint.Scheme != "http" && endpoint.Scheme != "https" {
 		return fmt.Errorf("invalid consul URL scheme %q", endpoint.Scheme)
 	}
+	d.Format, err = NewRecordFormat(instInfo.StateFormat)
+	if err != nil {
+		return err
+	}
 	cfg := api.Config{
 		Address: endpoint.Host,
 	}
@@ -82,7 +94,10 @@ func processKey(inKey string) string {
 func (d *ConsulStateDriver) Write(key string, value []byte) error {
 	key = processKey(key)
 
-	var err error
+	value, err := recordFormat(d.Format).Encode(value)
+	if err != nil {
+		return err
+	}
 
 	for i := 0; i < maxConsulRetries; i++ {
 		_, err = d.Client.KV().Put(&api.KVPair{Key: key, Value: value}, nil)
@@ -100,15 +115,29 @@ func (d *ConsulStateDriver) Write(key string, value []byte) error {
 	return err
 }
 
+// queryOptions returns the options of a read at consistency. Serializable
+// reads may be served stale by any server, the others by the leader.
+func queryOptions(consistency core.ReadConsistency) *api.QueryOptions {
+	if consistency == core.Serializable {
+		return &api.QueryOptions{AllowStale: true}
+	}
+	return nil
+}
+
 // Read state from key.
 func (d *ConsulStateDriver) Read(key string) ([]byte, error) {
+	return d.ReadWith(key, core.Linearizable)
+}
+
+// ReadWith reads state from key at consistency.
+func (d *ConsulStateDriver) ReadWith(key string, consistency core.ReadConsistency) ([]byte, error) {
 	key = processKey(key)
 
 	var err error
 	var kv *api.KVPair
 
 	for i := 0; i < maxConsulRetries; i++ {
-		kv, _, err = d.Client.KV().Get(key, nil)
+		kv, _, err = d.Client.KV().Get(key, queryOptions(consistency))
 		if err != nil {
 			if api.IsServerError(err) || strings.Contains(err.Error(), "EOF") || strings.Contains(err.Error(), "connection refused") {
 				time.Sleep(time.Second)
@@ -123,7 +152,7 @@ func (d *ConsulStateDriver) Read(key string) ([]byte, error) {
 			return []byte{}, core.Errorf("key not found")
 		}
 
-		return kv.Value, err
+		return recordFormat(d.Format).Decode(kv.Value)
 	}
 
 	return []byte{}, err
@@ -131,13 +160,18 @@ func (d *ConsulStateDriver) Read(key string) ([]byte, error) {
 
 // ReadAll state from baseKey.
 func (d *ConsulStateDriver) ReadAll(baseKey string) ([][]byte, error) {
+	return d.ReadAllWith(baseKey, core.Linearizable)
+}
+
+// ReadAllWith reads all state from baseKey at consistency.
+func (d *ConsulStateDriver) ReadAllWith(baseKey string, consistency core.ReadConsistency) ([][]byte, error) {
 	baseKey = processKey(baseKey)
 
 	var err error
 	var kvs api.KVPairs
 
 	for i := 0; i < maxConsulRetries; i++ {
-		kvs, _, err = d.Client.KV().List(baseKey, nil)
+		kvs, _, err = d.Client.KV().List(baseKey, queryOptions(consistency))
 		if err != nil {
 			if api.IsServerError(err) || strings.Contains(err.Error(), "EOF") || strings.Contains(err.Error(), "connection refused") {
 				time.Sleep(time.Second)
@@ -156,7 +190,11 @@ func (d *ConsulStateDriver) ReadAll(baseKey string) ([][]byte, error) {
 
 		values := [][]byte{}
 		for _, kv := range kvs {
-			values = append(values, kv.Value)
+			value, err := recordFormat(d.Format).Decode(kv.Value)
+			if err != nil {
+				return [][]byte{}, err
+			}
+			values = append(values, value)
 		}
 		return values, nil
 
@@ -166,7 +204,7 @@ func (d *ConsulStateDriver) ReadAll(baseKey string) ([][]byte, error) {
 }
 
 func (d *ConsulStateDriver) channelConsulEvents(baseKey string, kvCache map[string]*api.KVPair,
-	consulRsps chan api.KVPairs, rsps chan [2][]byte, retErr chan error, stop chan bool) {
+	consulRsps chan api.KVPairs, rsps chan [2][]byte, done, stop <-chan struct{}) {
 	for {
 		select {
 		// block on change notifications
@@ -196,20 +234,41 @@ func (d *ConsulStateDriver) channelConsulEvents(baseKey string, kvCache map[stri
 				//update the map of seen keys
 				kvCache[kv.Key] = kv
 
+				rsp, ok := decodeWatchEvent(d.Format, rsp)
+				if !ok {
+					continue
+				}
 				//channel the translated response
-				rsps <- rsp
+				select {
+				case rsps <- rsp:
+				case <-done:
+					return
+				case <-stop:
+					return
+				}
 			}
 
 			// Generate Delete events for missing keys
 			for key, kv := range kvCache {
 				if _, ok := kvsRcvd[key]; !ok {
 					log.Infof("Received delete for key: %q, Pair: %+v", kv.Key, kv)
-					rsps <- [2][]byte{nil, kv.Value}
+					if rsp, ok := decodeWatchEvent(d.Format, [2][]byte{nil, kv.Value}); ok {
+						select {
+						case rsps <- rsp:
+						case <-done:
+							return
+						case <-stop:
+							return
+						}
+					}
 					// remove this key from the map of seen keys
 					delete(kvCache, key)
 				}
 			}
 
+		case <-done:
+			log.Infof("Stop request received")
+			return
 		case <-stop:
 			log.Infof("Stop request received")
 			return
@@ -219,10 +278,16 @@ func (d *ConsulStateDriver) channelConsulEvents(baseKey string, kvCache map[stri
 
 // WatchAll state transitions from baseKey
 func (d *ConsulStateDriver) WatchAll(baseKey string, rsps chan [2][]byte) error {
+	return d.WatchAllUntil(baseKey, rsps, nil)
+}
+
+// WatchAllUntil watches the state transitions from baseKey until stop is
+// closed. A blocking query in progress completes first.
+func (d *ConsulStateDriver) WatchAllUntil(baseKey string, rsps chan [2][]byte, stop <-chan struct{}) error {
 	baseKey = processKey(baseKey)
 	consulRsps := make(chan api.KVPairs, 1)
-	stop := make(chan bool, 1)
-	recvErr := make(chan error, 2)
+	done := make(chan struct{})
+	defer close(done)
 
 	// Consul returns all the keys as return value of List(). The following maps helps
 	// track the state that has been seen and used to appropriately generate
@@ -246,12 +311,12 @@ func (d *ConsulStateDriver) WatchAll(baseKey string, rsps chan [2][]byte) error
 	}
 	waitIndex = qm.LastIndex
 
-	go d.channelConsulEvents(baseKey, kvCache, consulRsps, rsps, recvErr, stop)
+	go d.channelConsulEvents(baseKey, kvCache, consulRsps, rsps, done, stop)
 
 	for {
 		select {
-		case err := <-recvErr:
-			return err
+		case <-stop:
+			return nil
 		default:
 			kvs, qm, err := d.Client.KV().List(baseKey, &api.QueryOptions{WaitIndex: waitIndex})
 			if err != nil {
@@ -261,7 +326,6 @@ func (d *ConsulStateDriver) WatchAll(baseKey string, rsps chan [2][]byte) error
 					continue
 				} else {
 					log.Errorf("consul watch failed for key %q. Error: %s. stopping watch..", baseKey, err)
-					stop <- true
 					return err
 				}
 			}
@@ -274,7 +338,11 @@ func (d *ConsulStateDriver) WatchAll(baseKey string, rsps chan [2][]byte) error
 			}
 
 			waitIndex = qm.LastIndex
-			consulRsps <- kvs
+			select {
+			case consulRsps <- kvs:
+			case <-stop:
+				return nil
+			}
 		}
 	}
 }
@@ -286,6 +354,43 @@ func (d *ConsulStateDriver) ClearState(key string) error {
 	return err
 }
 
+// Lock acquires a cluster wide lock on key. The lock is tied to a consul
+// session with a ttl, so it is released if the holder dies.
+func (d *ConsulStateDriver) Lock(key string) (func(), error) {
+	timeout := d.LockTimeout
+	if timeout <= 0 {
+		timeout = defaultLockTimeout
+	}
+
+	lock, err := d.Client.LockOpts(&api.LockOptions{
+		Key:          processKey(key),
+		SessionTTL:   lockTTL.String(),
+		LockWaitTime: timeout,
+		LockTryOnce:  true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	lostCh, err := lock.Lock(nil)
+	if err != nil {
+		return nil, err
+	}
+	if lostCh == nil {
+		return nil, core.Errorf("timed out acquiring lock %s", key)
+	}
+
+	var once sync.Once
+	unlock := func() {
+		once.Do(func() {
+			if err := lock.Unlock(); err != nil {
+				log.Errorf("Error releasing lock %s. Err: %v", key, err)
+			}
+		})
+	}
+	return unlock, nil
+}
+
 // ReadState reads key into a core.State with the unmarshaling function.
 func (d *ConsulStateDriver) ReadState(key string, value core.State,
 	unmarshal func([]byte, interface{}) error) error {
@@ -298,6 +403,17 @@ func (d *ConsulStateDriver) ReadState(key string, value core.State,
 	return unmarshal(encodedState, value)
 }
 
+// ReadStateWith reads key into a core.State at consistency.
+func (d *ConsulStateDriver) ReadStateWith(key string, value core.State,
+	unmarshal func([]byte, interface{}) error, consistency core.ReadConsistency) error {
+	encodedState, err := d.ReadWith(processKey(key), consistency)
+	if err != nil {
+		return err
+	}
+
+	return unmarshal(encodedState, value)
+}
+
 // ReadAllState Reads all the state from baseKey and returns a list of core.State.
 func (d *ConsulStateDriver) ReadAllState(baseKey string, sType core.State,
 	unmarshal func([]byte, interface{}) error) ([]core.State, error) {
@@ -305,23 +421,43 @@ func (d *ConsulStateDriver) ReadAllState(baseKey string, sType core.State,
 	return readAllStateCommon(d, baseKey, sType, unmarshal)
 }
 
+// ReadAllStateWith reads all the state from baseKey at consistency.
+func (d *ConsulStateDriver) ReadAllStateWith(baseKey string, sType core.State,
+	unmarshal func([]byte, interface{}) error, consistency core.ReadConsistency) ([]core.State, error) {
+	byteValues, err := d.ReadAllWith(processKey(baseKey), consistency)
+	if err != nil {
+		return nil, err
+	}
+	return decodeAllState(d, byteValues, sType, unmarshal)
+}
+
 // WatchAllState watches all state from the baseKey.
 func (d *ConsulStateDriver) WatchAllState(baseKey string, sType core.State,
 	unmarshal func([]byte, interface{}) error, rsps chan core.WatchState) error {
+	return d.WatchAllStateUntil(baseKey, sType, unmarshal, rsps, nil)
+}
+
+// WatchAllStateUntil watches all state from the baseKey until stop is
+// closed.
+func (d *ConsulStateDriver) WatchAllStateUntil(baseKey string, sType core.State,
+	unmarshal func([]byte, interface{}) error, rsps chan core.WatchState, stop <-chan struct{}) error {
 	baseKey = processKey(baseKey)
 	byteRsps := make(chan [2][]byte, 1)
 	recvErr := make(chan error, 1)
 
-	go channelStateEvents(d, sType, unmarshal, byteRsps, rsps, recvErr)
+	go channelStateEvents(d, sType, unmarshal, byteRsps, rsps, recvErr, stop)
 
-	err := d.WatchAll(baseKey, byteRsps)
+	err := d.WatchAllUntil(baseKey, byteRsps, stop)
 	if err != nil {
 		return err
 	}
 
-	err = <-recvErr
-	return err
-
+	select {
+	case err = <-recvErr:
+		return err
+	case <-stop:
+		return nil
+	}
 }
 
 // WriteState writes a value of core.State into a key with a given marshaling function.
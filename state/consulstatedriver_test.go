@@ -17,6 +17,7 @@ package state
 
 import (
 	"testing"
+	"time"
 
 	"github.com/contiv/netplugin/core"
 )
@@ -98,3 +99,9 @@ func TestConsulStateDriverWatchAllStateDelete(t *testing.T) {
 	driver := setupConsulDriver(t)
 	commonTestStateDriverWatchAllStateDelete(t, driver)
 }
+
+func TestConsulStateDriverLock(t *testing.T) {
+	driver := setupConsulDriver(t)
+	driver.LockTimeout = time.Second
+	commonTestStateDriverLock(t, driver)
+}
@@ -0,0 +1,169 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package state
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	etcdcontext "github.com/coreos/etcd/Godeps/_workspace/src/golang.org/x/net/context"
+	"github.com/coreos/etcd/client"
+	"golang.org/x/net/context"
+)
+
+// leaderTimeout bounds the leader query of a metrics scrape
+const leaderTimeout = 5 * time.Second
+
+// etcdOpStats are the counters of one kind of etcd request
+type etcdOpStats struct {
+	count   uint64
+	errors  uint64
+	seconds float64
+}
+
+// etcdMetrics counts the requests of the driver to etcd
+type etcdMetrics struct {
+	sync.Mutex
+	ops map[string]*etcdOpStats
+}
+
+func newEtcdMetrics() *etcdMetrics {
+	return &etcdMetrics{ops: make(map[string]*etcdOpStats)}
+}
+
+// observe records a request of kind op that started at start. Missing keys
+// and failed compare-and-swaps are answers, not errors of the store.
+func (m *etcdMetrics) observe(op string, start time.Time, err error) {
+	elapsed := time.Since(start).Seconds()
+	failed := err != nil
+	if cErr, ok := err.(client.Error); ok {
+		switch cErr.Code {
+		case client.ErrorCodeKeyNotFound, client.ErrorCodeNodeExist, client.ErrorCodeTestFailed:
+			failed = false
+		}
+	}
+
+	m.Lock()
+	defer m.Unlock()
+	stats, ok := m.ops[op]
+	if !ok {
+		stats = &etcdOpStats{}
+		m.ops[op] = stats
+	}
+	stats.count++
+	stats.seconds += elapsed
+	if failed {
+		stats.errors++
+	}
+}
+
+// write writes the request counters in the prometheus text format
+func (m *etcdMetrics) write(w io.Writer) error {
+	m.Lock()
+	ops := make([]string, 0, len(m.ops))
+	for op := range m.ops {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+
+	var durBuf, errBuf bytes.Buffer
+	fmt.Fprintf(&durBuf, "# HELP contiv_state_request_duration_seconds Latency of the requests to the state store.\n")
+	fmt.Fprintf(&durBuf, "# TYPE contiv_state_request_duration_seconds summary\n")
+	fmt.Fprintf(&errBuf, "# HELP contiv_state_request_errors_total Number of failed requests to the state store.\n")
+	fmt.Fprintf(&errBuf, "# TYPE contiv_state_request_errors_total counter\n")
+	for _, op := range ops {
+		stats := m.ops[op]
+		fmt.Fprintf(&durBuf, "contiv_state_request_duration_seconds_sum{op=%q} %g\n", op, stats.seconds)
+		fmt.Fprintf(&durBuf, "contiv_state_request_duration_seconds_count{op=%q} %d\n", op, stats.count)
+		fmt.Fprintf(&errBuf, "contiv_state_request_errors_total{op=%q} %d\n", op, stats.errors)
+	}
+	m.Unlock()
+
+	for _, b := range []*bytes.Buffer{&durBuf, &errBuf} {
+		if _, err := b.WriteTo(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// meteredKeysAPI is a client.KeysAPI recording its requests in metrics. Its
+// methods take the context package vendored by the etcd client.
+type meteredKeysAPI struct {
+	client.KeysAPI
+	metrics *etcdMetrics
+}
+
+func (k *meteredKeysAPI) Get(ctx etcdcontext.Context, key string, opts *client.GetOptions) (*client.Response, error) {
+	start := time.Now()
+	resp, err := k.KeysAPI.Get(ctx, key, opts)
+	k.metrics.observe("get", start, err)
+	return resp, err
+}
+
+func (k *meteredKeysAPI) Set(ctx etcdcontext.Context, key, value string, opts *client.SetOptions) (*client.Response, error) {
+	start := time.Now()
+	resp, err := k.KeysAPI.Set(ctx, key, value, opts)
+	k.metrics.observe("set", start, err)
+	return resp, err
+}
+
+func (k *meteredKeysAPI) Delete(ctx etcdcontext.Context, key string, opts *client.DeleteOptions) (*client.Response, error) {
+	start := time.Now()
+	resp, err := k.KeysAPI.Delete(ctx, key, opts)
+	k.metrics.observe("delete", start, err)
+	return resp, err
+}
+
+// WriteMetrics writes the metrics of the etcd store in the prometheus text
+// format: the latency and errors of the requests of the driver and the
+// current leader of the cluster. It writes nothing when the metrics of the
+// driver are not enabled.
+func (d *EtcdStateDriver) WriteMetrics(w io.Writer) error {
+	if d.metrics == nil {
+		return nil
+	}
+	if err := d.metrics.write(w); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), leaderTimeout)
+	defer cancel()
+	start := time.Now()
+	leader, err := client.NewMembersAPI(d.Client).Leader(ctx)
+	d.metrics.observe("leader", start, err)
+
+	up := 1
+	if err != nil {
+		log.Errorf("Error getting the etcd leader. Err: %v", err)
+		up = 0
+	}
+	fmt.Fprintf(w, "# HELP contiv_state_up Whether the state store answered with its leader.\n")
+	fmt.Fprintf(w, "# TYPE contiv_state_up gauge\n")
+	fmt.Fprintf(w, "contiv_state_up %d\n", up)
+	if leader == nil {
+		return nil
+	}
+	fmt.Fprintf(w, "# HELP contiv_state_leader Current leader of the state store cluster.\n")
+	fmt.Fprintf(w, "# TYPE contiv_state_leader gauge\n")
+	_, err = fmt.Fprintf(w, "contiv_state_leader{id=%q,name=%q} 1\n", leader.ID, leader.Name)
+	return err
+}
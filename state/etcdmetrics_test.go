@@ -0,0 +1,55 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package state
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/client"
+)
+
+func TestEtcdMetrics(t *testing.T) {
+	var buf bytes.Buffer
+	d := &EtcdStateDriver{}
+	if err := d.WriteMetrics(&buf); err != nil || buf.Len() != 0 {
+		t.Fatalf("metrics written while not enabled: %q, %v", buf.String(), err)
+	}
+
+	m := newEtcdMetrics()
+	start := time.Now()
+	m.observe("get", start, nil)
+	m.observe("get", start, client.Error{Code: client.ErrorCodeKeyNotFound})
+	m.observe("get", start, errors.New("connection refused"))
+	m.observe("set", start, client.Error{Code: client.ErrorCodeTestFailed})
+
+	if err := m.write(&buf); err != nil {
+		t.Fatalf("error writing metrics. Error: %s", err)
+	}
+	for _, line := range []string{
+		`contiv_state_request_duration_seconds_count{op="get"} 3`,
+		`contiv_state_request_duration_seconds_count{op="set"} 1`,
+		`contiv_state_request_errors_total{op="get"} 1`,
+		`contiv_state_request_errors_total{op="set"} 0`,
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Fatalf("metric %q missing from:\n%s", line, buf.String())
+		}
+	}
+}
@@ -0,0 +1,150 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package state
+
+import (
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/contiv/netplugin/core"
+	"github.com/coreos/etcd/client"
+)
+
+// etcdEndpoint returns the client url of an etcd endpoint, etcd:// urls are
+// served over http
+func etcdEndpoint(rawURL string) (string, error) {
+	endpoint, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	if endpoint.Scheme == "etcd" {
+		endpoint.Scheme = "http"
+	} else if endpoint.Scheme != "http" && endpoint.Scheme != "https" {
+		return "", core.Errorf("invalid etcd URL scheme %q", endpoint.Scheme)
+	}
+	return endpoint.String(), nil
+}
+
+// ParseReadEndpoints parses a comma separated list of url=weight etcd
+// endpoints to spread the serializable reads over. The weight is 1 when
+// left out.
+func ParseReadEndpoints(list string) (map[string]int, error) {
+	endpoints := map[string]int{}
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		weight := 1
+		if idx := strings.LastIndex(item, "="); idx >= 0 {
+			var err error
+			weight, err = strconv.Atoi(item[idx+1:])
+			if err != nil {
+				return nil, core.Errorf("invalid weight of read endpoint %q", item)
+			}
+			item = item[:idx]
+		}
+		if _, err := etcdEndpoint(item); err != nil {
+			return nil, core.Errorf("invalid read endpoint %q: %v", item, err)
+		}
+		if weight <= 0 {
+			return nil, core.Errorf("read endpoint %s needs a positive weight", item)
+		}
+		endpoints[item] = weight
+	}
+	return endpoints, nil
+}
+
+// weightedRR picks indexes in smooth weighted round robin: each index is
+// picked in proportion to its weight, and the picks of the heavier ones are
+// spread out rather than bunched together
+type weightedRR struct {
+	sync.Mutex
+	weights []int
+	current []int
+	total   int
+}
+
+func newWeightedRR(weights []int) *weightedRR {
+	rr := &weightedRR{weights: weights, current: make([]int, len(weights))}
+	for _, weight := range weights {
+		rr.total += weight
+	}
+	return rr
+}
+
+// next returns the next index picked
+func (rr *weightedRR) next() int {
+	rr.Lock()
+	defer rr.Unlock()
+
+	best := 0
+	for idx, weight := range rr.weights {
+		rr.current[idx] += weight
+		if rr.current[idx] > rr.current[best] {
+			best = idx
+		}
+	}
+	rr.current[best] -= rr.total
+	return best
+}
+
+// initReadEndpoints creates a keys api per read endpoint, in the order of
+// the endpoint urls
+func (d *EtcdStateDriver) initReadEndpoints(endpoints map[string]int) error {
+	urls := []string{}
+	for rawURL := range endpoints {
+		urls = append(urls, rawURL)
+	}
+	sort.Strings(urls)
+
+	weights := []int{}
+	for _, rawURL := range urls {
+		endpoint, err := etcdEndpoint(rawURL)
+		if err != nil {
+			return err
+		}
+		if endpoints[rawURL] <= 0 {
+			return core.Errorf("read endpoint %s needs a positive weight", rawURL)
+		}
+		// without sync the client sticks to the endpoint it is given
+		readClient, err := client.New(client.Config{Endpoints: []string{endpoint}})
+		if err != nil {
+			return err
+		}
+		keysAPI := client.NewKeysAPI(readClient)
+		if d.metrics != nil {
+			keysAPI = &meteredKeysAPI{KeysAPI: keysAPI, metrics: d.metrics}
+		}
+		d.readAPIs = append(d.readAPIs, keysAPI)
+		weights = append(weights, endpoints[rawURL])
+	}
+	d.reads = newWeightedRR(weights)
+	return nil
+}
+
+// readAPI returns the keys api of a read at consistency. Serializable reads
+// are spread over the read endpoints when there are any, the others go to
+// the main endpoint.
+func (d *EtcdStateDriver) readAPI(consistency core.ReadConsistency) client.KeysAPI {
+	if consistency != core.Serializable || len(d.readAPIs) == 0 {
+		return d.KeysAPI
+	}
+	return d.readAPIs[d.reads.next()]
+}
@@ -18,8 +18,10 @@ package state
 import (
 	"errors"
 	"fmt"
-	"net/url"
+	"math/rand"
+	"os"
 	"reflect"
+	"sync"
 	"time"
 
 	"golang.org/x/net/context"
@@ -33,6 +35,13 @@ import (
 const (
 	ctxTimeout     = 20 * time.Second // etcd timeout
 	maxEtcdRetries = 10               // Max times to retry in case of failure
+
+	defaultWatchRetryBase = 500 * time.Millisecond // first watch reconnect delay
+	defaultWatchRetryCap  = 30 * time.Second       // longest watch reconnect delay
+
+	defaultLockTimeout = 30 * time.Second       // longest wait for a lock
+	lockTTL            = 15 * time.Second       // lock lifetime without refresh
+	lockPollInterval   = 500 * time.Millisecond // delay between lock attempts
 )
 
 // EtcdStateDriverConfig encapsulates the etcd endpoints used to communicate
@@ -48,28 +57,71 @@ type EtcdStateDriverConfig struct {
 type EtcdStateDriver struct {
 	Client  client.Client
 	KeysAPI client.KeysAPI
+
+	// Watch reconnects back off exponentially from WatchRetryBase up to
+	// WatchRetryCap, with jitter so that plugins don't reconnect in lockstep.
+	// Defaults are used when zero.
+	WatchRetryBase time.Duration
+	WatchRetryCap  time.Duration
+
+	// Lock gives up after LockTimeout, the default is used when zero.
+	LockTimeout time.Duration
+
+	// Format of the records written, JSON when nil. Records in any format
+	// are read.
+	Format core.RecordFormat
+
+	metrics *etcdMetrics // nil when the metrics are not enabled
+
+	// readAPIs serve the serializable reads when set, picked by reads
+	readAPIs []client.KeysAPI
+	reads    *weightedRR
+}
+
+// watchBackoff returns the delay before the given watch reconnect attempt,
+// picked at random between half and all of the exponential backoff.
+func (d *EtcdStateDriver) watchBackoff(attempt int) time.Duration {
+	base, limit := d.WatchRetryBase, d.WatchRetryCap
+	if base <= 0 {
+		base = defaultWatchRetryBase
+	}
+	if limit <= 0 {
+		limit = defaultWatchRetryCap
+	}
+	if limit < base {
+		limit = base
+	}
+
+	delay := base
+	for i := 0; i < attempt && delay < limit; i++ {
+		delay *= 2
+	}
+	if delay > limit {
+		delay = limit
+	}
+
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(delay-half)+1))
 }
 
 // Init the driver with a core.Config.
 func (d *EtcdStateDriver) Init(instInfo *core.InstanceInfo) error {
 	var err error
-	var endpoint *url.URL
 
 	if instInfo == nil || instInfo.DbURL == "" {
 		return errors.New("no etcd config found")
 	}
-	endpoint, err = url.Parse(instInfo.DbURL)
+	endpoint, err := etcdEndpoint(instInfo.DbURL)
 	if err != nil {
 		return err
 	}
-	if endpoint.Scheme == "etcd" {
-		endpoint.Scheme = "http"
-	} else if endpoint.Scheme != "http" && endpoint.Scheme != "https" {
-		return core.Errorf("invalid etcd URL scheme %q", endpoint.Scheme)
+	d.Format, err = NewRecordFormat(instInfo.StateFormat)
+	if err != nil {
+		return err
 	}
 	// TODO: support multi-endpoints
 	etcdConfig := client.Config{
-		Endpoints: []string{endpoint.String()},
+		Endpoints: []string{endpoint},
 	}
 
 	d.Client, err = client.New(etcdConfig)
@@ -79,7 +131,14 @@ func (d *EtcdStateDriver) Init(instInfo *core.InstanceInfo) error {
 
 	// Create keys api
 	d.KeysAPI = client.NewKeysAPI(d.Client)
+	if instInfo.StateMetrics {
+		d.metrics = newEtcdMetrics()
+		d.KeysAPI = &meteredKeysAPI{KeysAPI: d.KeysAPI, metrics: d.metrics}
+	}
 
+	if len(instInfo.StateReadEndpoints) != 0 {
+		return d.initReadEndpoints(instInfo.StateReadEndpoints)
+	}
 	return nil
 }
 
@@ -91,7 +150,10 @@ func (d *EtcdStateDriver) Write(key string, value []byte) error {
 	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeout)
 	defer cancel()
 
-	var err error
+	value, err := recordFormat(d.Format).Encode(value)
+	if err != nil {
+		return err
+	}
 
 	for i := 0; i < maxEtcdRetries; i++ {
 		_, err = d.KeysAPI.Set(ctx, key, string(value[:]), nil)
@@ -110,6 +172,13 @@ func (d *EtcdStateDriver) Write(key string, value []byte) error {
 
 // Read state from key.
 func (d *EtcdStateDriver) Read(key string) ([]byte, error) {
+	return d.ReadWith(key, core.Linearizable)
+}
+
+// ReadWith reads state from key at consistency. Linearizable reads are
+// quorum reads, serializable ones are answered by the member the client
+// talks to, one of the read endpoints when they are set.
+func (d *EtcdStateDriver) ReadWith(key string, consistency core.ReadConsistency) ([]byte, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeout)
 	defer cancel()
 
@@ -117,10 +186,10 @@ func (d *EtcdStateDriver) Read(key string) ([]byte, error) {
 	var resp *client.Response
 
 	for i := 0; i < maxEtcdRetries; i++ {
-		resp, err = d.KeysAPI.Get(ctx, key, &client.GetOptions{Quorum: true})
+		resp, err = d.readAPI(consistency).Get(ctx, key, &client.GetOptions{Quorum: consistency == core.Linearizable})
 		if err == nil {
 			if resp != nil && resp.Node != nil {
-				return []byte(resp.Node.Value), nil
+				return recordFormat(d.Format).Decode([]byte(resp.Node.Value))
 			}
 
 			return []byte{}, fmt.Errorf("error reading from etcd")
@@ -144,6 +213,11 @@ func (d *EtcdStateDriver) Read(key string) ([]byte, error) {
 
 // ReadAll state from baseKey.
 func (d *EtcdStateDriver) ReadAll(baseKey string) ([][]byte, error) {
+	return d.ReadAllWith(baseKey, core.Linearizable)
+}
+
+// ReadAllWith reads all state from baseKey at consistency.
+func (d *EtcdStateDriver) ReadAllWith(baseKey string, consistency core.ReadConsistency) ([][]byte, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeout)
 	defer cancel()
 
@@ -151,11 +225,16 @@ func (d *EtcdStateDriver) ReadAll(baseKey string) ([][]byte, error) {
 	var resp *client.Response
 
 	for i := 0; i < maxEtcdRetries; i++ {
-		resp, err = d.KeysAPI.Get(ctx, baseKey, &client.GetOptions{Recursive: true, Quorum: true})
+		resp, err = d.readAPI(consistency).Get(ctx, baseKey, &client.GetOptions{Recursive: true,
+			Quorum: consistency == core.Linearizable})
 		if err == nil {
 			values := [][]byte{}
 			for _, node := range resp.Node.Nodes {
-				values = append(values, []byte(node.Value))
+				value, err := recordFormat(d.Format).Decode([]byte(node.Value))
+				if err != nil {
+					return [][]byte{}, err
+				}
+				values = append(values, value)
 			}
 			return values, nil
 		}
@@ -176,15 +255,102 @@ func (d *EtcdStateDriver) ReadAll(baseKey string) ([][]byte, error) {
 	return [][]byte{}, err
 }
 
-func (d *EtcdStateDriver) channelEtcdEvents(watcher client.Watcher, rsps chan [2][]byte) {
+// Lock acquires a cluster wide lock on key. The lock is a ttl key created
+// only if absent and refreshed while held, so it expires if the holder dies.
+func (d *EtcdStateDriver) Lock(key string) (func(), error) {
+	timeout := d.LockTimeout
+	if timeout <= 0 {
+		timeout = defaultLockTimeout
+	}
+	owner := fmt.Sprintf("%d-%d", os.Getpid(), rand.Int63())
+	deadline := time.Now().Add(timeout)
+
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), ctxTimeout)
+		_, err := d.KeysAPI.Set(ctx, key, owner,
+			&client.SetOptions{PrevExist: client.PrevNoExist, TTL: lockTTL})
+		cancel()
+		if err == nil {
+			break
+		}
+
+		cErr, ok := err.(client.Error)
+		if (!ok || cErr.Code != client.ErrorCodeNodeExist) &&
+			err.Error() != client.ErrClusterUnavailable.Error() {
+			return nil, err
+		}
+		if time.Now().After(deadline) {
+			return nil, core.Errorf("timed out acquiring lock %s", key)
+		}
+		time.Sleep(lockPollInterval)
+	}
+
+	stop := make(chan struct{})
+	go d.refreshLock(key, owner, stop)
+
+	var once sync.Once
+	unlock := func() {
+		once.Do(func() {
+			close(stop)
+			ctx, cancel := context.WithTimeout(context.Background(), ctxTimeout)
+			defer cancel()
+			_, err := d.KeysAPI.Delete(ctx, key, &client.DeleteOptions{PrevValue: owner})
+			if err != nil {
+				log.Errorf("Error releasing lock %s. Err: %v", key, err)
+			}
+		})
+	}
+	return unlock, nil
+}
+
+// refreshLock keeps a held lock from expiring until stop is closed
+func (d *EtcdStateDriver) refreshLock(key, owner string, stop chan struct{}) {
+	ticker := time.NewTicker(lockTTL / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), ctxTimeout)
+			_, err := d.KeysAPI.Set(ctx, key, "", &client.SetOptions{PrevExist: client.PrevExist,
+				PrevValue: owner, TTL: lockTTL, Refresh: true})
+			cancel()
+			if err == nil {
+				continue
+			}
+			if cErr, ok := err.(client.Error); ok &&
+				(cErr.Code == client.ErrorCodeKeyNotFound || cErr.Code == client.ErrorCodeTestFailed) {
+				log.Errorf("Lock %s was lost", key)
+				return
+			}
+			log.Errorf("Error refreshing lock %s. Err: %v", key, err)
+		}
+	}
+}
+
+func (d *EtcdStateDriver) channelEtcdEvents(ctx context.Context, watcher client.Watcher,
+	rsps chan [2][]byte, stop <-chan struct{}) {
+	attempt := 0
 	for {
 		// block on change notifications
-		etcdRsp, err := watcher.Next(context.Background())
+		etcdRsp, err := watcher.Next(ctx)
 		if err != nil {
-			log.Errorf("Error %v during watch", err)
-			time.Sleep(time.Second)
+			if ctx.Err() != nil {
+				return
+			}
+			delay := d.watchBackoff(attempt)
+			log.Errorf("Error %v during watch, retrying in %v", err, delay)
+			select {
+			case <-time.After(delay):
+			case <-stop:
+				return
+			}
+			attempt++
 			continue
 		}
+		attempt = 0
 
 		// XXX: The logic below assumes that the node returned is always a node
 		// of interest. Eg: If we set a watch on /a/b/c, then we are mostly
@@ -207,20 +373,41 @@ func (d *EtcdStateDriver) channelEtcdEvents(watcher client.Watcher, rsps chan [2
 		}
 
 		log.Debugf("Received %q for key: %s", eventStr, etcdRsp.Node.Key)
+		rsp, ok := decodeWatchEvent(d.Format, rsp)
+		if !ok {
+			continue
+		}
 		//channel the translated response
-		rsps <- rsp
+		select {
+		case rsps <- rsp:
+		case <-stop:
+			return
+		}
 	}
 }
 
 // WatchAll state transitions from baseKey
 func (d *EtcdStateDriver) WatchAll(baseKey string, rsps chan [2][]byte) error {
+	return d.WatchAllUntil(baseKey, rsps, nil)
+}
+
+// WatchAllUntil watches the state transitions from baseKey until stop is
+// closed
+func (d *EtcdStateDriver) WatchAllUntil(baseKey string, rsps chan [2][]byte, stop <-chan struct{}) error {
 	watcher := d.KeysAPI.Watcher(baseKey, &client.WatcherOptions{Recursive: true})
 	if watcher == nil {
 		log.Errorf("etcd watch failed.")
 		return errors.New("etcd watch failed")
 	}
 
-	go d.channelEtcdEvents(watcher, rsps)
+	ctx, cancel := context.WithCancel(context.Background())
+	if stop != nil {
+		go func() {
+			<-stop
+			cancel()
+		}()
+	}
+	go d.channelEtcdEvents(ctx, watcher, rsps, stop)
 
 	return nil
 }
@@ -245,23 +432,40 @@ func (d *EtcdStateDriver) ReadState(key string, value core.State,
 	return unmarshal(encodedState, value)
 }
 
+// ReadStateWith reads key into a core.State at consistency.
+func (d *EtcdStateDriver) ReadStateWith(key string, value core.State,
+	unmarshal func([]byte, interface{}) error, consistency core.ReadConsistency) error {
+	encodedState, err := d.ReadWith(key, consistency)
+	if err != nil {
+		return err
+	}
+
+	return unmarshal(encodedState, value)
+}
+
 // readAllStateCommon reads and unmarshals (given a function) all state into a
 // list of core.State objects.
 // XXX: move this to some common file
 func readAllStateCommon(d core.StateDriver, baseKey string, sType core.State,
 	unmarshal func([]byte, interface{}) error) ([]core.State, error) {
-	stateType := reflect.TypeOf(sType)
-	sliceType := reflect.SliceOf(stateType)
-	values := reflect.MakeSlice(sliceType, 0, 1)
-
 	byteValues, err := d.ReadAll(baseKey)
 	if err != nil {
 		return nil, err
 	}
+	return decodeAllState(d, byteValues, sType, unmarshal)
+}
+
+// decodeAllState unmarshals the records read by a driver into a list of
+// core.State objects
+func decodeAllState(d core.StateDriver, byteValues [][]byte, sType core.State,
+	unmarshal func([]byte, interface{}) error) ([]core.State, error) {
+	stateType := reflect.TypeOf(sType)
+	sliceType := reflect.SliceOf(stateType)
+	values := reflect.MakeSlice(sliceType, 0, 1)
+
 	for _, byteValue := range byteValues {
 		value := reflect.New(stateType)
-		err = unmarshal(byteValue, value.Interface())
-		if err != nil {
+		if err := unmarshal(byteValue, value.Interface()); err != nil {
 			return nil, err
 		}
 		values = reflect.Append(values, value.Elem())
@@ -288,16 +492,31 @@ func (d *EtcdStateDriver) ReadAllState(baseKey string, sType core.State,
 	return readAllStateCommon(d, baseKey, sType, unmarshal)
 }
 
+// ReadAllStateWith reads all the state from baseKey at consistency.
+func (d *EtcdStateDriver) ReadAllStateWith(baseKey string, sType core.State,
+	unmarshal func([]byte, interface{}) error, consistency core.ReadConsistency) ([]core.State, error) {
+	byteValues, err := d.ReadAllWith(baseKey, consistency)
+	if err != nil {
+		return nil, err
+	}
+	return decodeAllState(d, byteValues, sType, unmarshal)
+}
+
 // channelStateEvents watches for updates(created, modify, delete) to a state of
 // specified type and unmarshals (given a function) all changes and puts then on
 // channel of core.WatchState objects.
 // XXX: move this to some common file
 func channelStateEvents(d core.StateDriver, sType core.State,
 	unmarshal func([]byte, interface{}) error,
-	byteRsps chan [2][]byte, rsps chan core.WatchState, retErr chan error) {
+	byteRsps chan [2][]byte, rsps chan core.WatchState, retErr chan error, stop <-chan struct{}) {
 	for {
 		// block on change notifications
-		byteRsp := <-byteRsps
+		var byteRsp [2][]byte
+		select {
+		case byteRsp = <-byteRsps:
+		case <-stop:
+			return
+		}
 
 		rsp := core.WatchState{Curr: nil, Prev: nil}
 		for i := 0; i < 2; i++ {
@@ -328,28 +547,72 @@ func channelStateEvents(d core.StateDriver, sType core.State,
 		}
 
 		//channel the translated response
-		rsps <- rsp
+		select {
+		case rsps <- rsp:
+		case <-stop:
+			return
+		}
 	}
 }
 
 // WatchAllState watches all state from the baseKey.
 func (d *EtcdStateDriver) WatchAllState(baseKey string, sType core.State,
 	unmarshal func([]byte, interface{}) error, rsps chan core.WatchState) error {
+	return d.WatchAllStateUntil(baseKey, sType, unmarshal, rsps, nil)
+}
+
+// WatchAllStateUntil watches all state from the baseKey until stop is
+// closed.
+func (d *EtcdStateDriver) WatchAllStateUntil(baseKey string, sType core.State,
+	unmarshal func([]byte, interface{}) error, rsps chan core.WatchState, stop <-chan struct{}) error {
 	byteRsps := make(chan [2][]byte, 1)
-	recvErr := make(chan error, 1)
 
-	err := d.WatchAll(baseKey, byteRsps)
+	err := d.WatchAllUntil(baseKey, byteRsps, stop)
 	if err != nil {
 		log.Errorf("WatchAll returned %v", err)
 		return err
 	}
 
-	for {
-		go channelStateEvents(d, sType, unmarshal, byteRsps, rsps, recvErr)
+	relayStateEvents(d, sType, unmarshal, byteRsps, rsps, stop, d.watchBackoff)
+	return nil
+}
 
-		err = <-recvErr
-		log.Errorf("Err from channelStateEvents %v", err)
-		time.Sleep(time.Second)
+// relayStateEvents translates the events of byteRsps into state events on
+// rsps until stop is closed. Translation is restarted after backoff when it
+// fails, the backoff attempts are counted again once an event is delivered.
+func relayStateEvents(d core.StateDriver, sType core.State, unmarshal func([]byte, interface{}) error,
+	byteRsps chan [2][]byte, rsps chan core.WatchState, stop <-chan struct{},
+	backoff func(attempt int) time.Duration) {
+	stateRsps := make(chan core.WatchState)
+	recvErr := make(chan error, 1)
+
+	attempt := 0
+	for {
+		go channelStateEvents(d, sType, unmarshal, byteRsps, stateRsps, recvErr, stop)
+
+		var err error
+		for err == nil {
+			select {
+			case rsp := <-stateRsps:
+				attempt = 0
+				select {
+				case rsps <- rsp:
+				case <-stop:
+					return
+				}
+			case err = <-recvErr:
+			case <-stop:
+				return
+			}
+		}
+		delay := backoff(attempt)
+		log.Errorf("Err from channelStateEvents %v, retrying in %v", err, delay)
+		select {
+		case <-time.After(delay):
+		case <-stop:
+			return
+		}
+		attempt++
 	}
 }
 
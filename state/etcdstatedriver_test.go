@@ -18,10 +18,13 @@ package state
 import (
 	"bytes"
 	"encoding/json"
+	"reflect"
 	"testing"
 	"time"
 
 	"github.com/contiv/netplugin/core"
+	etcdcontext "github.com/coreos/etcd/Godeps/_workspace/src/golang.org/x/net/context"
+	"github.com/coreos/etcd/client"
 )
 
 const (
@@ -451,3 +454,175 @@ func TestEtcdStateDriverWatchAllStateDelete(t *testing.T) {
 	driver := setupEtcdDriver(t)
 	commonTestStateDriverWatchAllStateDelete(t, driver)
 }
+
+func commonTestStateDriverLock(t *testing.T, d core.StateDriver) {
+	lockKey := "TestKeyLock"
+
+	unlock, err := d.Lock(lockKey)
+	if err != nil {
+		t.Fatalf("failed to acquire lock. Error: %s", err)
+	}
+
+	if _, err := d.Lock(lockKey); err == nil {
+		t.Fatalf("acquired a held lock, expected to time out")
+	}
+
+	unlock()
+	// a second release is a no-op
+	unlock()
+
+	unlock, err = d.Lock(lockKey)
+	if err != nil {
+		t.Fatalf("failed to acquire a released lock. Error: %s", err)
+	}
+	unlock()
+}
+
+func TestEtcdStateDriverLock(t *testing.T) {
+	driver := setupEtcdDriver(t)
+	driver.LockTimeout = time.Second
+	commonTestStateDriverLock(t, driver)
+}
+
+func TestEtcdStateDriverWatchBackoff(t *testing.T) {
+	driver := &EtcdStateDriver{
+		WatchRetryBase: 100 * time.Millisecond,
+		WatchRetryCap:  time.Second,
+	}
+
+	testData := []struct {
+		attempt  int
+		min, max time.Duration
+	}{
+		{0, 50 * time.Millisecond, 100 * time.Millisecond},
+		{1, 100 * time.Millisecond, 200 * time.Millisecond},
+		{3, 400 * time.Millisecond, 800 * time.Millisecond},
+		{10, 500 * time.Millisecond, time.Second},
+		{100, 500 * time.Millisecond, time.Second},
+	}
+
+	for _, d := range testData {
+		for i := 0; i < 20; i++ {
+			delay := driver.watchBackoff(d.attempt)
+			if delay < d.min || delay > d.max {
+				t.Fatalf("attempt %d: delay %v outside [%v, %v]", d.attempt, delay, d.min, d.max)
+			}
+		}
+	}
+
+	// defaults apply when unset
+	driver = &EtcdStateDriver{}
+	if delay := driver.watchBackoff(100); delay > defaultWatchRetryCap {
+		t.Fatalf("delay %v exceeds the default cap", delay)
+	}
+}
+
+func TestRelayStateEventsBackoffReset(t *testing.T) {
+	byteRsps := make(chan [2][]byte)
+	rsps := make(chan core.WatchState)
+	stop := make(chan struct{})
+	attempts := make(chan int, 10)
+	backoff := func(attempt int) time.Duration {
+		attempts <- attempt
+		return 0
+	}
+	go relayStateEvents(&EtcdStateDriver{}, &testState{}, json.Unmarshal, byteRsps, rsps, stop, backoff)
+	defer close(stop)
+
+	bad := [2][]byte{[]byte("{"), nil}
+	good := [2][]byte{[]byte(`{"IntField": 1}`), nil}
+	byteRsps <- bad
+	byteRsps <- bad
+	byteRsps <- good
+	if rsp := <-rsps; rsp.Curr.(*testState).IntField != 1 {
+		t.Fatalf("unexpected state event %+v", rsp.Curr)
+	}
+	byteRsps <- bad
+
+	for i, exp := range []int{0, 1, 0} {
+		if attempt := <-attempts; attempt != exp {
+			t.Fatalf("retry %d: backoff attempt %d, expected %d", i, attempt, exp)
+		}
+	}
+}
+
+// quorumKeysAPI answers every get with the same node, recording whether
+// the gets were quorum reads
+type quorumKeysAPI struct {
+	client.KeysAPI
+	quorum []bool
+}
+
+func (k *quorumKeysAPI) Get(ctx etcdcontext.Context, key string, opts *client.GetOptions) (*client.Response, error) {
+	k.quorum = append(k.quorum, opts.Quorum)
+	node := &client.Node{Key: key, Value: `{"intField":1}`}
+	return &client.Response{Node: &client.Node{Key: key, Nodes: client.Nodes{node}, Value: node.Value}}, nil
+}
+
+func TestEtcdReadConsistency(t *testing.T) {
+	keys := &quorumKeysAPI{}
+	var driver core.StateDriver = &EtcdStateDriver{KeysAPI: keys}
+
+	if _, err := driver.Read("/contiv/orange"); err != nil {
+		t.Fatalf("error reading. Error: %s", err)
+	}
+	states, err := core.ReadAllStateWith(driver, "/contiv/", &testState{}, json.Unmarshal, core.Serializable)
+	if err != nil || len(states) != 1 {
+		t.Fatalf("error listing state %v. Error: %v", states, err)
+	}
+	value := &testState{}
+	if err := core.ReadStateWith(driver, "/contiv/orange", value, json.Unmarshal, core.Serializable); err != nil {
+		t.Fatalf("error reading state. Error: %s", err)
+	}
+	if value.IntField != 1 {
+		t.Fatalf("unexpected state %+v", value)
+	}
+	if !reflect.DeepEqual(keys.quorum, []bool{true, false, false}) {
+		t.Fatalf("unexpected quorum reads %v", keys.quorum)
+	}
+}
+
+func TestEtcdWeightedReads(t *testing.T) {
+	main, heavy, light := &quorumKeysAPI{}, &quorumKeysAPI{}, &quorumKeysAPI{}
+	driver := &EtcdStateDriver{KeysAPI: main, readAPIs: []client.KeysAPI{heavy, light},
+		reads: newWeightedRR([]int{3, 1})}
+
+	for i := 0; i < 8; i++ {
+		if _, err := driver.ReadWith("/contiv/orange", core.Serializable); err != nil {
+			t.Fatalf("error reading. Error: %s", err)
+		}
+	}
+	if _, err := driver.Read("/contiv/orange"); err != nil {
+		t.Fatalf("error reading. Error: %s", err)
+	}
+	if len(heavy.quorum) != 6 || len(light.quorum) != 2 || len(main.quorum) != 1 {
+		t.Fatalf("unexpected read spread %d/%d/%d", len(heavy.quorum), len(light.quorum), len(main.quorum))
+	}
+
+	// the picks of the heavier endpoint are spread out
+	rr := newWeightedRR([]int{2, 1})
+	picks := []int{}
+	for i := 0; i < 6; i++ {
+		picks = append(picks, rr.next())
+	}
+	if !reflect.DeepEqual(picks, []int{0, 1, 0, 0, 1, 0}) {
+		t.Fatalf("unexpected weighted picks %v", picks)
+	}
+}
+
+func TestParseReadEndpoints(t *testing.T) {
+	endpoints, err := ParseReadEndpoints("http://10.1.1.1:2379=3, etcd://10.1.1.2:2379,")
+	if err != nil {
+		t.Fatalf("error parsing read endpoints. Error: %s", err)
+	}
+	exp := map[string]int{"http://10.1.1.1:2379": 3, "etcd://10.1.1.2:2379": 1}
+	if !reflect.DeepEqual(endpoints, exp) {
+		t.Fatalf("unexpected read endpoints %v, expected %v", endpoints, exp)
+	}
+
+	for _, list := range []string{"http://10.1.1.1:2379=0", "http://10.1.1.1:2379=x", "tcp://10.1.1.1:2379"} {
+		if _, err := ParseReadEndpoints(list); err == nil {
+			t.Fatalf("invalid read endpoints %q parsed", list)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package state
 
 import (
 	"strings"
+	"sync"
 
 	"github.com/contiv/netplugin/core"
 
@@ -20,6 +21,8 @@ type FakeStateDriverConfig struct{}
 // unit-tests
 type FakeStateDriver struct {
 	TestState map[string]valueData
+	locks     map[string]bool
+	lockMutex sync.Mutex
 }
 
 // Init the driver
@@ -77,6 +80,25 @@ func (d *FakeStateDriver) ClearState(key string) error {
 	return nil
 }
 
+// Lock takes the lock on key, failing right away if it is held
+func (d *FakeStateDriver) Lock(key string) (func(), error) {
+	d.lockMutex.Lock()
+	defer d.lockMutex.Unlock()
+	if d.locks == nil {
+		d.locks = make(map[string]bool)
+	}
+	if d.locks[key] {
+		return nil, core.Errorf("lock %s is held", key)
+	}
+	d.locks[key] = true
+
+	return func() {
+		d.lockMutex.Lock()
+		defer d.lockMutex.Unlock()
+		delete(d.locks, key)
+	}, nil
+}
+
 // ReadState unmarshals state into a core.State
 func (d *FakeStateDriver) ReadState(key string, value core.State,
 	unmarshal func([]byte, interface{}) error) error {
@@ -0,0 +1,131 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package state
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/base64"
+	"io/ioutil"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/contiv/netplugin/core"
+)
+
+// Formats of the state records
+const (
+	// RecordFormatJSON stores records as is, the default
+	RecordFormatJSON = "json"
+	// RecordFormatDeflate stores records deflate compressed, base64 encoded
+	// as etcd values must be text
+	RecordFormatDeflate = "deflate"
+)
+
+// deflatePrefix starts the records in the deflate format. No JSON text
+// starts with it, records without it are taken as plain JSON.
+const deflatePrefix = "z:"
+
+// NewRecordFormat returns the record format called name, JSON when name is
+// empty
+func NewRecordFormat(name string) (core.RecordFormat, error) {
+	switch name {
+	case "", RecordFormatJSON:
+		return jsonFormat{}, nil
+	case RecordFormatDeflate:
+		return deflateFormat{}, nil
+	}
+	return nil, core.Errorf("unknown state record format %q", name)
+}
+
+// recordFormat returns format, or the JSON format when it is not set
+func recordFormat(format core.RecordFormat) core.RecordFormat {
+	if format == nil {
+		return jsonFormat{}
+	}
+	return format
+}
+
+type jsonFormat struct{}
+
+func (jsonFormat) Name() string { return RecordFormatJSON }
+
+func (jsonFormat) Encode(record []byte) ([]byte, error) { return record, nil }
+
+func (jsonFormat) Decode(data []byte) ([]byte, error) { return decodeRecord(data) }
+
+type deflateFormat struct{}
+
+func (deflateFormat) Name() string { return RecordFormatDeflate }
+
+func (deflateFormat) Encode(record []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(record); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, len(deflatePrefix)+base64.StdEncoding.EncodedLen(buf.Len()))
+	copy(data, deflatePrefix)
+	base64.StdEncoding.Encode(data[len(deflatePrefix):], buf.Bytes())
+	return data, nil
+}
+
+func (deflateFormat) Decode(data []byte) ([]byte, error) { return decodeRecord(data) }
+
+// decodeRecord returns the JSON of a record stored in any format
+func decodeRecord(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, []byte(deflatePrefix)) {
+		return data, nil
+	}
+
+	compressed := make([]byte, base64.StdEncoding.DecodedLen(len(data)-len(deflatePrefix)))
+	n, err := base64.StdEncoding.Decode(compressed, data[len(deflatePrefix):])
+	if err != nil {
+		return nil, core.Errorf("invalid deflate record: %v", err)
+	}
+
+	r := flate.NewReader(bytes.NewReader(compressed[:n]))
+	defer r.Close()
+	record, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, core.Errorf("invalid deflate record: %v", err)
+	}
+	return record, nil
+}
+
+// decodeWatchEvent decodes the records of a watch event. An event with a
+// record that can not be decoded is dropped, rather than seen as a create
+// or delete of the key; ok is false then.
+func decodeWatchEvent(format core.RecordFormat, rsp [2][]byte) ([2][]byte, bool) {
+	for i := range rsp {
+		if rsp[i] == nil {
+			continue
+		}
+		record, err := recordFormat(format).Decode(rsp[i])
+		if err != nil {
+			log.Errorf("Error decoding watched record, dropping the event. Err: %v", err)
+			return rsp, false
+		}
+		rsp[i] = record
+	}
+	return rsp, true
+}
@@ -0,0 +1,75 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package state
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRecordFormat(t *testing.T) {
+	record := []byte(`{"id":"orange","ipAllocMap":"` + strings.Repeat("0", 512) + `"}`)
+
+	if _, err := NewRecordFormat("gob"); err == nil {
+		t.Fatalf("unknown record format accepted")
+	}
+
+	for _, name := range []string{"", RecordFormatJSON, RecordFormatDeflate} {
+		format, err := NewRecordFormat(name)
+		if err != nil {
+			t.Fatalf("error creating record format %q. Error: %s", name, err)
+		}
+		data, err := format.Encode(record)
+		if err != nil {
+			t.Fatalf("error encoding record in %s. Error: %s", format.Name(), err)
+		}
+		if name == RecordFormatDeflate && len(data) >= len(record) {
+			t.Fatalf("deflate record of %d bytes is not smaller than %d", len(data), len(record))
+		}
+
+		// records are read back whatever the format of the reader
+		for _, reader := range []string{RecordFormatJSON, RecordFormatDeflate} {
+			readFormat, _ := NewRecordFormat(reader)
+			decoded, err := readFormat.Decode(data)
+			if err != nil {
+				t.Fatalf("error decoding %s record in %s. Error: %s", format.Name(), reader, err)
+			}
+			if !bytes.Equal(decoded, record) {
+				t.Fatalf("%s record decoded in %s as %q", format.Name(), reader, decoded)
+			}
+		}
+	}
+
+	if _, err := decodeRecord([]byte(deflatePrefix + "!!")); err == nil {
+		t.Fatalf("invalid deflate record decoded")
+	}
+}
+
+func TestDecodeWatchEvent(t *testing.T) {
+	format, _ := NewRecordFormat(RecordFormatDeflate)
+	data, _ := format.Encode([]byte(`{"id":"orange"}`))
+
+	rsp, ok := decodeWatchEvent(format, [2][]byte{data, nil})
+	if !ok || string(rsp[0]) != `{"id":"orange"}` || rsp[1] != nil {
+		t.Fatalf("unexpected decoded event %q, ok %v", rsp, ok)
+	}
+
+	// an undecodable record does not turn into a create or delete
+	if _, ok := decodeWatchEvent(format, [2][]byte{[]byte(deflatePrefix + "!!"), data}); ok {
+		t.Fatalf("event with an undecodable record kept")
+	}
+}
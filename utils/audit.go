@@ -0,0 +1,101 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/contiv/netplugin/core"
+)
+
+// AuditLogToLogger is the audit log setting that sends the audit records
+// to the process logger
+const AuditLogToLogger = "log"
+
+// FileAuditSink appends audit records to a file, one JSON record per line
+type FileAuditSink struct {
+	sync.Mutex
+	file *os.File
+}
+
+// NewFileAuditSink opens the audit file at path for appending
+func NewFileAuditSink(path string) (*FileAuditSink, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &FileAuditSink{file: file}, nil
+}
+
+// Audit writes a record to the file
+func (s *FileAuditSink) Audit(rec core.AuditRecord) {
+	data, err := json.Marshal(&rec)
+	if err != nil {
+		logrus.Errorf("Error encoding audit record %+v. Err: %v", rec, err)
+		return
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	if _, err := s.file.Write(append(data, '\n')); err != nil {
+		logrus.Errorf("Error writing audit record %+v. Err: %v", rec, err)
+	}
+}
+
+// LoggerAuditSink sends audit records to a logger
+type LoggerAuditSink struct {
+	Logger logrus.FieldLogger
+}
+
+// Audit logs a record, failed operations at warning level
+func (s *LoggerAuditSink) Audit(rec core.AuditRecord) {
+	entry := s.Logger.WithFields(logrus.Fields{
+		"audit":  true,
+		"time":   rec.Time,
+		"actor":  rec.Actor,
+		"op":     rec.Op,
+		"params": strings.Join(rec.Params, " "),
+	})
+	if rec.Error != "" {
+		entry.WithField("error", rec.Error).Warn("dataplane operation failed")
+		return
+	}
+	entry.Info("dataplane operation")
+}
+
+// InitAuditLog turns on the audit trail of the dataplane mutations done by
+// actor. auditLog is AuditLogToLogger, the path of a file, or empty to keep
+// auditing off.
+func InitAuditLog(actor, auditLog string) error {
+	switch auditLog {
+	case "":
+		return nil
+	case AuditLogToLogger:
+		core.SetAuditSink(actor, &LoggerAuditSink{Logger: logrus.StandardLogger()})
+		return nil
+	}
+
+	sink, err := NewFileAuditSink(auditLog)
+	if err != nil {
+		return err
+	}
+	core.SetAuditSink(actor, sink)
+	return nil
+}
@@ -0,0 +1,78 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/contiv/netplugin/core"
+)
+
+func TestFileAuditLog(t *testing.T) {
+	file, err := ioutil.TempFile("", "audit")
+	if err != nil {
+		t.Fatalf("error creating audit file. Error: %s", err)
+	}
+	file.Close()
+	defer os.Remove(file.Name())
+
+	if err := InitAuditLog("netplugin@host1", file.Name()); err != nil {
+		t.Fatalf("error initializing audit log. Error: %s", err)
+	}
+	defer core.SetAuditSink("", nil)
+
+	core.Audit("route add", []string{"10.2.0.0/16", "10.1.1.1"}, nil)
+	core.Audit("route del", []string{"10.2.0.0/16", "10.1.1.1"}, errors.New("no such process"))
+
+	data, err := ioutil.ReadFile(file.Name())
+	if err != nil {
+		t.Fatalf("error reading audit file. Error: %s", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 audit records, got %q", data)
+	}
+
+	recs := make([]core.AuditRecord, len(lines))
+	for i, line := range lines {
+		if err := json.Unmarshal([]byte(line), &recs[i]); err != nil {
+			t.Fatalf("invalid audit record %q. Error: %s", line, err)
+		}
+		if recs[i].Actor != "netplugin@host1" || recs[i].Time.IsZero() {
+			t.Fatalf("unexpected audit record %+v", recs[i])
+		}
+	}
+	if recs[0].Op != "route add" || recs[0].Error != "" ||
+		!reflect.DeepEqual(recs[0].Params, []string{"10.2.0.0/16", "10.1.1.1"}) {
+		t.Fatalf("unexpected audit record %+v", recs[0])
+	}
+	if recs[1].Op != "route del" || recs[1].Error != "no such process" {
+		t.Fatalf("unexpected audit record %+v", recs[1])
+	}
+
+	// nothing is recorded once auditing is turned off
+	core.SetAuditSink("", nil)
+	core.Audit("route add", nil, nil)
+	if after, _ := ioutil.ReadFile(file.Name()); len(after) != len(data) {
+		t.Fatalf("audit record written with auditing off")
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"github.com/Sirupsen/logrus"
 	logrus_syslog "github.com/Sirupsen/logrus/hooks/syslog"
 	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/state"
 	"github.com/urfave/cli"
 	"log/syslog"
 	"net/url"
@@ -67,6 +68,14 @@ func BuildDBFlags(binary string) []cli.Flag {
 			EnvVar: fmt.Sprintf("CONTIV_%s_CONSUL_ENDPOINTS", binUpper),
 			Usage:  fmt.Sprintf("a comma-delimited list of %s consul endpoints", binLower),
 		},
+		cli.StringFlag{
+			Name:   "state-format",
+			Value:  state.RecordFormatJSON,
+			EnvVar: fmt.Sprintf("CONTIV_%s_STATE_FORMAT", binUpper),
+			Usage: fmt.Sprintf("set the format of the state records written by %s, options: [%s, %s]. "+
+				"Records in any format are read, switch to %s only once all nodes support it",
+				binLower, state.RecordFormatJSON, state.RecordFormatDeflate, state.RecordFormatDeflate),
+		},
 	}
 }
 
@@ -74,6 +83,7 @@ func BuildDBFlags(binary string) []cli.Flag {
 type DBConfigs struct {
 	StoreDriver string
 	StoreURL    string
+	StoreFormat string
 }
 
 // BuildLogFlags CLI logging flags for given binary
@@ -210,9 +220,16 @@ func ValidateDBOptions(binary string, ctx *cli.Context) (*DBConfigs, error) {
 		return nil, fmt.Errorf("invalid %s %s endpoints: empty", binary, storeDriver)
 	}
 
+	storeFormat := ctx.String("state-format")
+	if _, err := state.NewRecordFormat(storeFormat); err != nil {
+		return nil, err
+	}
+	logrus.Infof("Using %s state record format: %s", binary, storeFormat)
+
 	return &DBConfigs{
 		StoreDriver: storeDriver,
 		StoreURL:    storeURL,
+		StoreFormat: storeFormat,
 	}, nil
 }
 
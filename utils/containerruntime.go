@@ -0,0 +1,316 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	osexec "os/exec"
+	"regexp"
+	"strings"
+
+	"github.com/contiv/netplugin/core"
+	dockerclient "github.com/docker/docker/client"
+	"golang.org/x/net/context"
+)
+
+// Container runtimes
+const (
+	RuntimeDocker     = "docker"
+	RuntimeContainerd = "containerd"
+	RuntimeCrio       = "crio"
+)
+
+// CRI sockets of the runtimes reached through crictl
+const (
+	containerdCRIEndpoint = "unix:///run/containerd/containerd.sock"
+	crioCRIEndpoint       = "unix:///var/run/crio/crio.sock"
+)
+
+// maxNetnsHops bounds the chain of containers sharing a netns followed to
+// its owner
+const maxNetnsHops = 4
+
+// ContainerRuntime gives access to the containers of a container runtime
+type ContainerRuntime interface {
+	// Ping checks that the runtime can be reached
+	Ping() error
+	// GetSandboxNetns returns the netns path of the pod sandbox, the
+	// infra container owning the netns of a pod. podID is the id of the
+	// sandbox or of any container of the pod.
+	GetSandboxNetns(podID string) (string, error)
+}
+
+// ContainerLookup is implemented by container runtimes that can look up
+// containers by id and by name
+type ContainerLookup interface {
+	// ContainerExists tells whether container or pod sandbox id exists
+	ContainerExists(id string) (bool, error)
+	// ContainerIDByName returns the id of the container or pod sandbox
+	// called name, "" when there is none
+	ContainerIDByName(name string) (string, error)
+}
+
+// ContainerRuntimes returns the names of the supported container runtimes
+func ContainerRuntimes() []string {
+	return []string{RuntimeContainerd, RuntimeCrio, RuntimeDocker}
+}
+
+// NewContainerRuntime returns the runtime called name, docker when name is
+// empty. criEndpoint overrides the CRI socket of the containerd and crio
+// runtimes, the default socket of the runtime is used when it is empty.
+func NewContainerRuntime(name, criEndpoint string) (ContainerRuntime, error) {
+	switch name {
+	case "", RuntimeDocker:
+		if criEndpoint != "" {
+			return nil, core.Errorf("container runtime %q has no CRI endpoint", RuntimeDocker)
+		}
+		return &dockerRuntime{}, nil
+	case RuntimeContainerd:
+		return newCRIRuntime(containerdCRIEndpoint, criEndpoint)
+	case RuntimeCrio:
+		return newCRIRuntime(crioCRIEndpoint, criEndpoint)
+	}
+	return nil, core.Errorf("unknown container runtime %q", name)
+}
+
+// newCRIRuntime returns a CRI runtime reached on endpoint, defEndpoint when
+// endpoint is empty. A bare socket path is taken as a unix socket.
+func newCRIRuntime(defEndpoint, endpoint string) (ContainerRuntime, error) {
+	if endpoint == "" {
+		return &criRuntime{endpoint: defEndpoint}, nil
+	}
+	if strings.HasPrefix(endpoint, "/") {
+		endpoint = "unix://" + endpoint
+	}
+	if !strings.HasPrefix(endpoint, "unix:///") {
+		return nil, core.Errorf("invalid CRI endpoint %q, expected a unix socket", endpoint)
+	}
+	return &criRuntime{endpoint: endpoint}, nil
+}
+
+// pidNetns returns the netns path of a process
+func pidNetns(pid int) string {
+	return fmt.Sprintf("/proc/%d/ns/net", pid)
+}
+
+// ProcessNetns returns the netns path of process pid, without going through
+// a container runtime. The process must exist and be in a netns other than
+// the one of the caller, the host netns.
+func ProcessNetns(pid int) (string, error) {
+	if pid <= 0 {
+		return "", core.Errorf("invalid pid %d", pid)
+	}
+	if _, err := os.Stat(fmt.Sprintf("/proc/%d", pid)); err != nil {
+		return "", core.Errorf("process %d not found", pid)
+	}
+
+	nsPath := pidNetns(pid)
+	nsInfo, err := os.Stat(nsPath)
+	if err != nil {
+		return "", core.Errorf("process %d has no net namespace. Err: %v", pid, err)
+	}
+	hostInfo, err := os.Stat(pidNetns(os.Getpid()))
+	if err != nil {
+		return "", err
+	}
+	if os.SameFile(nsInfo, hostInfo) {
+		return "", core.Errorf("process %d is in the host net namespace", pid)
+	}
+	return nsPath, nil
+}
+
+// dockerRuntime resolves pod sandboxes through the docker api. Pod
+// containers join the netns of the sandbox with a container: network mode.
+type dockerRuntime struct{}
+
+func (r *dockerRuntime) Ping() error {
+	cli, err := GetDockerClient()
+	if err != nil {
+		return err
+	}
+	_, err = cli.Ping(context.Background())
+	return err
+}
+
+func (r *dockerRuntime) GetSandboxNetns(podID string) (string, error) {
+	cli, err := GetDockerClient()
+	if err != nil {
+		return "", err
+	}
+
+	id := podID
+	for i := 0; i < maxNetnsHops; i++ {
+		info, err := cli.ContainerInspect(context.Background(), id)
+		if err != nil {
+			return "", err
+		}
+		if info.HostConfig != nil && info.HostConfig.NetworkMode.IsContainer() {
+			id = info.HostConfig.NetworkMode.ConnectedContainer()
+			continue
+		}
+		if info.State == nil || info.State.Pid == 0 {
+			return "", core.Errorf("sandbox %s of %s is not running", id, podID)
+		}
+		return pidNetns(info.State.Pid), nil
+	}
+	return "", core.Errorf("too many netns hops resolving the sandbox of %s", podID)
+}
+
+func (r *dockerRuntime) ContainerExists(id string) (bool, error) {
+	cli, err := GetDockerClient()
+	if err != nil {
+		return false, err
+	}
+	_, err = cli.ContainerInspect(context.Background(), id)
+	if dockerclient.IsErrContainerNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (r *dockerRuntime) ContainerIDByName(name string) (string, error) {
+	cli, err := GetDockerClient()
+	if err != nil {
+		return "", err
+	}
+	info, err := cli.ContainerInspect(context.Background(), strings.TrimPrefix(name, "/"))
+	if dockerclient.IsErrContainerNotFound(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return info.ID, nil
+}
+
+// criRuntime resolves pod sandboxes of a CRI runtime with crictl
+type criRuntime struct {
+	endpoint string
+}
+
+func (r *criRuntime) crictl(args ...string) ([]byte, error) {
+	crictlPath, err := osexec.LookPath("crictl")
+	if err != nil {
+		return nil, err
+	}
+	args = append([]string{"--runtime-endpoint", r.endpoint}, args...)
+	out, err := osexec.Command(crictlPath, args...).Output()
+	if err != nil {
+		return nil, core.Errorf("crictl %v failed. Err: %v", args, err)
+	}
+	return out, nil
+}
+
+func (r *criRuntime) Ping() error {
+	_, err := r.crictl("version")
+	return err
+}
+
+func (r *criRuntime) GetSandboxNetns(podID string) (string, error) {
+	sandboxID := podID
+	out, err := r.crictl("inspectp", "-o", "json", podID)
+	if err != nil {
+		// not a sandbox, look up the sandbox of the container
+		ctrOut, ctrErr := r.crictl("inspect", "-o", "json", podID)
+		if ctrErr != nil {
+			return "", err
+		}
+		sandboxID, err = parseCRIContainerSandbox(ctrOut)
+		if err != nil {
+			return "", err
+		}
+		out, err = r.crictl("inspectp", "-o", "json", sandboxID)
+		if err != nil {
+			return "", err
+		}
+	}
+	return parseCRISandboxNetns(out)
+}
+
+func (r *criRuntime) ContainerExists(id string) (bool, error) {
+	id, err := r.findID("--id", id)
+	return id != "", err
+}
+
+func (r *criRuntime) ContainerIDByName(name string) (string, error) {
+	return r.findID("--name", "^"+regexp.QuoteMeta(name)+"$")
+}
+
+// findID returns the id of the first container, or else pod sandbox,
+// matching crictl filter flag with value, "" when none does
+func (r *criRuntime) findID(flag, value string) (string, error) {
+	for _, args := range [][]string{{"ps", "-a"}, {"pods"}} {
+		out, err := r.crictl(append(args, "-q", flag, value)...)
+		if err != nil {
+			return "", err
+		}
+		if ids := strings.Fields(string(out)); len(ids) != 0 {
+			return ids[0], nil
+		}
+	}
+	return "", nil
+}
+
+// criInspect holds the fields of crictl inspect and inspectp outputs that
+// locate a sandbox
+type criInspect struct {
+	Info struct {
+		SandboxID   string `json:"sandboxID"`
+		Pid         int    `json:"pid"`
+		RuntimeSpec struct {
+			Linux struct {
+				Namespaces []struct {
+					Type string `json:"type"`
+					Path string `json:"path"`
+				} `json:"namespaces"`
+			} `json:"linux"`
+		} `json:"runtimeSpec"`
+	} `json:"info"`
+}
+
+// parseCRIContainerSandbox returns the sandbox id in the crictl inspect
+// output of a container
+func parseCRIContainerSandbox(data []byte) (string, error) {
+	inspect := criInspect{}
+	if err := json.Unmarshal(data, &inspect); err != nil {
+		return "", core.Errorf("invalid crictl inspect output: %v", err)
+	}
+	if inspect.Info.SandboxID == "" {
+		return "", core.Errorf("no sandbox id in crictl inspect output")
+	}
+	return inspect.Info.SandboxID, nil
+}
+
+// parseCRISandboxNetns returns the netns path in the crictl inspectp output
+// of a sandbox. Runtimes that create the netns themselves list it in the
+// runtime spec, the netns of the sandbox process is used otherwise.
+func parseCRISandboxNetns(data []byte) (string, error) {
+	inspect := criInspect{}
+	if err := json.Unmarshal(data, &inspect); err != nil {
+		return "", core.Errorf("invalid crictl inspectp output: %v", err)
+	}
+	for _, ns := range inspect.Info.RuntimeSpec.Linux.Namespaces {
+		if ns.Type == "network" && ns.Path != "" {
+			return ns.Path, nil
+		}
+	}
+	if inspect.Info.Pid == 0 {
+		return "", core.Errorf("no netns in crictl inspectp output")
+	}
+	return pidNetns(inspect.Info.Pid), nil
+}
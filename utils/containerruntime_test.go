@@ -0,0 +1,97 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"os"
+	"testing"
+)
+
+func TestParseCRIInspect(t *testing.T) {
+	ctr := `{"status": {"id": "app1"}, "info": {"sandboxID": "pause1", "pid": 120}}`
+	sandboxID, err := parseCRIContainerSandbox([]byte(ctr))
+	if err != nil || sandboxID != "pause1" {
+		t.Fatalf("unexpected sandbox %q of container. Error: %v", sandboxID, err)
+	}
+	if _, err := parseCRIContainerSandbox([]byte(`{"info": {}}`)); err == nil {
+		t.Fatalf("container without a sandbox id parsed")
+	}
+
+	testData := []struct {
+		out   string
+		netns string
+	}{
+		{`{"info": {"pid": 100, "runtimeSpec": {"linux": {"namespaces": [{"type": "pid"}, {"type": "network", "path": "/var/run/netns/cni-1234"}]}}}}`, "/var/run/netns/cni-1234"},
+		{`{"info": {"pid": 100, "runtimeSpec": {"linux": {"namespaces": [{"type": "network"}]}}}}`, "/proc/100/ns/net"},
+		{`{"info": {"pid": 100}}`, "/proc/100/ns/net"},
+	}
+	for _, d := range testData {
+		netns, err := parseCRISandboxNetns([]byte(d.out))
+		if err != nil || netns != d.netns {
+			t.Fatalf("expected netns %q from %s, got %q. Error: %v", d.netns, d.out, netns, err)
+		}
+	}
+
+	for _, out := range []string{`{"info": {}}`, `not json`} {
+		if _, err := parseCRISandboxNetns([]byte(out)); err == nil {
+			t.Fatalf("netns parsed from %s", out)
+		}
+	}
+
+	if _, err := NewContainerRuntime("rkt", ""); err == nil {
+		t.Fatalf("unknown container runtime created")
+	}
+}
+
+func TestCRIEndpoint(t *testing.T) {
+	testData := []struct {
+		runtime     string
+		criEndpoint string
+		endpoint    string
+	}{
+		{RuntimeCrio, "", crioCRIEndpoint},
+		{RuntimeContainerd, "", containerdCRIEndpoint},
+		{RuntimeCrio, "/run/crio/crio.sock", "unix:///run/crio/crio.sock"},
+		{RuntimeCrio, "unix:///run/crio/crio.sock", "unix:///run/crio/crio.sock"},
+	}
+	for _, d := range testData {
+		runtime, err := NewContainerRuntime(d.runtime, d.criEndpoint)
+		if err != nil {
+			t.Fatalf("error creating runtime %s with endpoint %q. Err: %v", d.runtime, d.criEndpoint, err)
+		}
+		cri, ok := runtime.(*criRuntime)
+		if !ok || cri.endpoint != d.endpoint {
+			t.Fatalf("expected runtime %s on %s, got %+v", d.runtime, d.endpoint, runtime)
+		}
+	}
+
+	for _, d := range [][2]string{{RuntimeDocker, "/run/crio/crio.sock"}, {RuntimeCrio, "tcp://10.1.1.1:10010"}, {RuntimeCrio, "crio.sock"}} {
+		if _, err := NewContainerRuntime(d[0], d[1]); err == nil {
+			t.Fatalf("runtime %s created with CRI endpoint %q", d[0], d[1])
+		}
+	}
+}
+
+func TestProcessNetns(t *testing.T) {
+	for _, pid := range []int{0, -1, 1 << 30} {
+		if _, err := ProcessNetns(pid); err == nil {
+			t.Fatalf("netns of invalid pid %d resolved", pid)
+		}
+	}
+	if _, err := ProcessNetns(os.Getpid()); err == nil {
+		t.Fatalf("netns of a process in the host netns resolved")
+	}
+}
@@ -2,6 +2,8 @@ package utils
 
 import (
 	"reflect"
+	"sort"
+	"sync"
 
 	"github.com/contiv/netplugin/core"
 	"github.com/contiv/netplugin/drivers"
@@ -61,10 +63,117 @@ const (
 	VppNameStr = "vpp"
 )
 
+// Driver roles reported by AvailableDrivers
+const (
+	NetworkDriverRole   = "network"
+	EndpointDriverRole  = "endpoint"
+	StateDriverRole     = "state"
+	ContainerDriverRole = "container"
+)
+
 var (
 	gStateDriver core.StateDriver
 )
 
+// DriverRegistry is a set of network and state drivers that can be
+// instantiated by name. It allows a caller to use a driver set that differs
+// from the package wide registries without modifying them.
+type DriverRegistry struct {
+	lock           sync.RWMutex // protects the driver maps
+	networkDrivers map[string]driverConfigTypes
+	stateDrivers   map[string]driverConfigTypes
+}
+
+// copyRegistry returns a shallow copy of a driver registry
+func copyRegistry(registry map[string]driverConfigTypes) map[string]driverConfigTypes {
+	c := make(map[string]driverConfigTypes, len(registry))
+	for name, types := range registry {
+		c[name] = types
+	}
+	return c
+}
+
+// NewDriverRegistry returns a registry populated with the built-in drivers
+func NewDriverRegistry() *DriverRegistry {
+	return &DriverRegistry{
+		networkDrivers: copyRegistry(networkDriverRegistry),
+		stateDrivers:   copyRegistry(stateDriverRegistry),
+	}
+}
+
+// RegisterNetworkDriver adds a network driver type to the registry. The type
+// must implement core.NetworkDriver through a pointer receiver.
+func (r *DriverRegistry) RegisterNetworkDriver(name string, driverType reflect.Type) error {
+	if !reflect.PtrTo(driverType).Implements(reflect.TypeOf((*core.NetworkDriver)(nil)).Elem()) {
+		return core.Errorf("%s does not implement a network driver", driverType)
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.networkDrivers[name] = driverConfigTypes{DriverType: driverType, ConfigType: driverType}
+	return nil
+}
+
+// RegisterStateDriver adds a state driver type to the registry. The type
+// must implement core.StateDriver through a pointer receiver.
+func (r *DriverRegistry) RegisterStateDriver(name string, driverType reflect.Type) error {
+	if !reflect.PtrTo(driverType).Implements(reflect.TypeOf((*core.StateDriver)(nil)).Elem()) {
+		return core.Errorf("%s does not implement a state driver", driverType)
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.stateDrivers[name] = driverConfigTypes{DriverType: driverType, ConfigType: driverType}
+	return nil
+}
+
+// NewNetworkDriver instantiates a 'named' network-driver from the registry
+func (r *DriverRegistry) NewNetworkDriver(name string, instInfo *core.InstanceInfo) (core.NetworkDriver, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return newNetworkDriver(r.networkDrivers, name, instInfo)
+}
+
+// NewStateDriver instantiates a 'named' state-driver from the registry.
+// Unlike the package level NewStateDriver, the driver is owned by the caller
+// and is not registered as the process wide state-driver.
+func (r *DriverRegistry) NewStateDriver(name string, instInfo *core.InstanceInfo) (core.StateDriver, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return newStateDriver(r.stateDrivers, name, instInfo)
+}
+
+// AvailableDrivers returns the names of the drivers of the registry by role,
+// including the drivers registered at runtime
+func (r *DriverRegistry) AvailableDrivers() map[string][]string {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return availableDrivers(r.networkDrivers, r.stateDrivers)
+}
+
+// AvailableDrivers returns the names of the built-in drivers by role
+func AvailableDrivers() map[string][]string {
+	return availableDrivers(networkDriverRegistry, stateDriverRegistry)
+}
+
+func availableDrivers(networkDrivers, stateDrivers map[string]driverConfigTypes) map[string][]string {
+	return map[string][]string{
+		NetworkDriverRole: driverNames(networkDrivers),
+		// endpoints are wired by the network drivers
+		EndpointDriverRole:  driverNames(networkDrivers),
+		StateDriverRole:     driverNames(stateDrivers),
+		ContainerDriverRole: ContainerRuntimes(),
+	}
+}
+
+// driverNames returns the sorted names of a driver registry
+func driverNames(registry map[string]driverConfigTypes) []string {
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 // initHelper initializes the NetPlugin by mapping driver names to
 // configuration, then it imports the configuration.
 func initHelper(driverRegistry map[string]driverConfigTypes, driverName string) (core.Driver, error) {
@@ -88,7 +197,22 @@ func NewStateDriver(name string, instInfo *core.InstanceInfo) (core.StateDriver,
 		return nil, core.Errorf("statedriver instance already exists.")
 	}
 
-	driver, err := initHelper(stateDriverRegistry, name)
+	d, err := newStateDriver(stateDriverRegistry, name, instInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	gStateDriver = d
+	return d, nil
+}
+
+func newStateDriver(driverRegistry map[string]driverConfigTypes, name string,
+	instInfo *core.InstanceInfo) (core.StateDriver, error) {
+	if name == "" || instInfo == nil {
+		return nil, core.Errorf("invalid driver name or configuration passed.")
+	}
+
+	driver, err := initHelper(driverRegistry, name)
 	if err != nil {
 		return nil, err
 	}
@@ -99,7 +223,6 @@ func NewStateDriver(name string, instInfo *core.InstanceInfo) (core.StateDriver,
 		return nil, err
 	}
 
-	gStateDriver = d
 	return d, nil
 }
 
@@ -122,11 +245,16 @@ func ReleaseStateDriver() {
 
 // NewNetworkDriver instantiates a 'named' network-driver with specified configuration
 func NewNetworkDriver(name string, instInfo *core.InstanceInfo) (core.NetworkDriver, error) {
+	return newNetworkDriver(networkDriverRegistry, name, instInfo)
+}
+
+func newNetworkDriver(driverRegistry map[string]driverConfigTypes, name string,
+	instInfo *core.InstanceInfo) (core.NetworkDriver, error) {
 	if name == "" || instInfo == nil {
 		return nil, core.Errorf("invalid driver name or configuration passed.")
 	}
 
-	driver, err := initHelper(networkDriverRegistry, name)
+	driver, err := initHelper(driverRegistry, name)
 	if err != nil {
 		return nil, err
 	}
@@ -1,9 +1,11 @@
 package utils
 
 import (
+	"reflect"
 	"testing"
 
 	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/drivers"
 )
 
 func TestNewStateDriverValidConfig(t *testing.T) {
@@ -76,3 +78,72 @@ func TestNewNetworkDriverInvalidDriverName(t *testing.T) {
 		t.Fatalf("network driver instantiation succeeded, expected to fail")
 	}
 }
+
+func TestDriverRegistryIsolation(t *testing.T) {
+	r1 := NewDriverRegistry()
+	r2 := NewDriverRegistry()
+
+	err := r1.RegisterNetworkDriver("custom", reflect.TypeOf(drivers.FakeNetEpDriver{}))
+	if err != nil {
+		t.Fatalf("failed to register network driver. Error: %s", err)
+	}
+
+	instInfo := &core.InstanceInfo{}
+	if _, err := r1.NewNetworkDriver("custom", instInfo); err != nil {
+		t.Fatalf("failed to instantiate custom network driver. Error: %s", err)
+	}
+	if _, err := r2.NewNetworkDriver("custom", instInfo); err == nil {
+		t.Fatalf("custom driver leaked into another registry")
+	}
+	if _, err := NewNetworkDriver("custom", instInfo); err == nil {
+		t.Fatalf("custom driver leaked into the package registry")
+	}
+}
+
+func TestDriverRegistryStateDriverNotGlobal(t *testing.T) {
+	r := NewDriverRegistry()
+	drv, err := r.NewStateDriver("fakedriver", &core.InstanceInfo{})
+	if err != nil {
+		t.Fatalf("failed to instantiate state driver. Error: %s", err)
+	}
+	defer drv.Deinit()
+
+	if _, err := GetStateDriver(); err == nil {
+		t.Fatalf("registry state driver was registered as the global state driver")
+	}
+}
+
+func TestDriverRegistryRegisterInvalidType(t *testing.T) {
+	r := NewDriverRegistry()
+	if err := r.RegisterNetworkDriver("bogus", reflect.TypeOf(core.InstanceInfo{})); err == nil {
+		t.Fatalf("registering an invalid network driver succeeded, expected to fail")
+	}
+	if err := r.RegisterStateDriver("bogus", reflect.TypeOf(core.InstanceInfo{})); err == nil {
+		t.Fatalf("registering an invalid state driver succeeded, expected to fail")
+	}
+}
+
+func TestAvailableDrivers(t *testing.T) {
+	expDrivers := map[string][]string{
+		NetworkDriverRole:   {"fakedriver", OvsNameStr, VppNameStr},
+		EndpointDriverRole:  {"fakedriver", OvsNameStr, VppNameStr},
+		StateDriverRole:     {ConsulNameStr, EtcdNameStr, "fakedriver"},
+		ContainerDriverRole: {RuntimeContainerd, RuntimeCrio, RuntimeDocker},
+	}
+	if avail := AvailableDrivers(); !reflect.DeepEqual(avail, expDrivers) {
+		t.Fatalf("unexpected drivers %v, expected %v", avail, expDrivers)
+	}
+
+	r := NewDriverRegistry()
+	if err := r.RegisterNetworkDriver("custom", reflect.TypeOf(drivers.FakeNetEpDriver{})); err != nil {
+		t.Fatalf("failed to register network driver. Error: %s", err)
+	}
+	avail := r.AvailableDrivers()
+	expNetDrivers := []string{"custom", "fakedriver", OvsNameStr, VppNameStr}
+	if !reflect.DeepEqual(avail[NetworkDriverRole], expNetDrivers) {
+		t.Fatalf("unexpected network drivers %v, expected %v", avail[NetworkDriverRole], expNetDrivers)
+	}
+	if len(AvailableDrivers()[NetworkDriverRole]) != 3 {
+		t.Fatalf("custom driver listed in the package registry")
+	}
+}
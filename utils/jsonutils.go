@@ -0,0 +1,103 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// UnknownJSONFields returns the fields of the JSON object data that have no
+// field to decode into in v, by their dotted paths, e.g. drivers.netwrok.
+// Field names match the way encoding/json matches them, ignoring case.
+func UnknownJSONFields(data []byte, v interface{}) ([]string, error) {
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	unknown := []string{}
+	collectUnknownFields(doc, reflect.TypeOf(v), "", &unknown)
+	sort.Strings(unknown)
+	return unknown, nil
+}
+
+// jsonFields returns the fields of a struct by their lowercased JSON names,
+// with the fields of the embedded structs
+func jsonFields(t reflect.Type, fields map[string]reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		ft := f.Type
+		if f.Anonymous && name == "" {
+			for ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				jsonFields(ft, fields)
+				continue
+			}
+		}
+		if f.PkgPath != "" && !f.Anonymous {
+			continue // unexported
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields[strings.ToLower(name)] = f.Type
+	}
+}
+
+func collectUnknownFields(doc interface{}, t reflect.Type, path string, unknown *[]string) {
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil {
+		return
+	}
+
+	switch val := doc.(type) {
+	case map[string]interface{}:
+		switch t.Kind() {
+		case reflect.Struct:
+			fields := map[string]reflect.Type{}
+			jsonFields(t, fields)
+			for key, child := range val {
+				ft, ok := fields[strings.ToLower(key)]
+				if !ok {
+					*unknown = append(*unknown, path+key)
+					continue
+				}
+				collectUnknownFields(child, ft, path+key+".", unknown)
+			}
+		case reflect.Map:
+			for key, child := range val {
+				collectUnknownFields(child, t.Elem(), path+key+".", unknown)
+			}
+		}
+	case []interface{}:
+		if t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+			for _, child := range val {
+				collectUnknownFields(child, t.Elem(), path, unknown)
+			}
+		}
+	}
+}
@@ -0,0 +1,65 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+type jsonTestBase struct {
+	ID string `json:"id"`
+}
+
+type jsonTestItem struct {
+	Name string `json:"name"`
+}
+
+type jsonTestConfig struct {
+	jsonTestBase
+	Endpoints []string                `json:"endpoints"`
+	Items     []jsonTestItem          `json:"items"`
+	ByName    map[string]jsonTestItem `json:"by-name"`
+	Inner     *jsonTestItem           `json:"inner"`
+	Skipped   string                  `json:"-"`
+	Untagged  int
+	Any       interface{} `json:"any"`
+}
+
+func TestUnknownJSONFields(t *testing.T) {
+	data := []byte(`{
+		"id": "a",
+		"endpoits": ["x"],
+		"items": [{"name": "b"}, {"nmae": "c"}],
+		"by-name": {"d": {"name": "d", "size": 1}},
+		"inner": {"Name": "e", "extra": true},
+		"skipped": "f",
+		"untagged": 2,
+		"any": {"whatever": 3}
+	}`)
+	unknown, err := UnknownJSONFields(data, &jsonTestConfig{})
+	if err != nil {
+		t.Fatalf("error checking the fields. Error: %s", err)
+	}
+	exp := []string{"by-name.d.size", "endpoits", "inner.extra", "items.nmae", "skipped"}
+	if !reflect.DeepEqual(unknown, exp) {
+		t.Fatalf("unexpected unknown fields %v, expected %v", unknown, exp)
+	}
+
+	if _, err := UnknownJSONFields([]byte("{"), &jsonTestConfig{}); err == nil {
+		t.Fatalf("checked the fields of invalid json")
+	}
+}
@@ -19,13 +19,21 @@ type ContivConfig struct {
 	K8sCert      string `json:"K8S_CERT,omitempty"`
 	K8sToken     string `json:"K8S_TOKEN,omitempty"`
 	SvcSubnet    string `json:"SVC_SUBNET,omitempty"`
+	// seconds to wait for a pod netns to show up before failing the attach
+	NetnsRetryTimeout int `json:"NETNS_RETRY_TIMEOUT,omitempty"`
+	// seconds to wait for a dhcp lease on dhcp networks
+	DhcpTimeout int `json:"DHCP_TIMEOUT,omitempty"`
+	// keep the dhcp client running to renew the lease
+	DhcpRenew bool `json:"DHCP_RENEW,omitempty"`
 }
 
 // contivKubeCfgFile holds credentials to access k8s api server
 const (
-	contivKubeCfgFile = "/var/contiv/config/contiv.json"
-	defSvcSubnet      = "10.254.0.0/16"
-	tokenFile         = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	contivKubeCfgFile    = "/var/contiv/config/contiv.json"
+	defSvcSubnet         = "10.254.0.0/16"
+	defNetnsRetryTimeout = 10
+	defDhcpTimeout       = 30
+	tokenFile            = "/var/run/secrets/kubernetes.io/serviceaccount/token"
 )
 
 // GetK8SConfig reads and parses the contivKubeCfgFile
@@ -36,6 +44,9 @@ func GetK8SConfig(pCfg *ContivConfig) error {
 	}
 
 	pCfg.SvcSubnet = defSvcSubnet
+	pCfg.NetnsRetryTimeout = defNetnsRetryTimeout
+	pCfg.DhcpTimeout = defDhcpTimeout
+	pCfg.DhcpRenew = true
 	err = json.Unmarshal(bytes, pCfg)
 	if err != nil {
 		return fmt.Errorf("Error parsing config file: %s", err)
@@ -502,7 +502,7 @@ func GetSubnetIPv6(subnetAddr string, subnetLen uint, hostID string) (string, er
 
 	subnetIP := net.ParseIP(subnetAddr)
 	hostidIP := net.ParseIP(hostID)
-	hostIP := net.IPv6zero
+	hostIP := make(net.IP, net.IPv6len)
 
 	var offset int
 	for offset = 0; offset < int(subnetLen/8); offset++ {
@@ -530,7 +530,7 @@ func GetIPv6HostID(subnetAddr string, subnetLen uint, hostAddr string) (string,
 		return "", core.Errorf("subnet length %d not supported", subnetLen)
 	}
 	// Initialize hostID
-	hostID := net.IPv6zero
+	hostID := make(net.IP, net.IPv6len)
 
 	var offset uint
 
@@ -678,7 +678,9 @@ func SetInterfaceIP(name string, ipstr string) error {
 		return err
 	}
 	netlink.LinkSetUp(iface)
-	return netlink.AddrAdd(iface, ipaddr)
+	err = netlink.AddrAdd(iface, ipaddr)
+	core.Audit("addr add", []string{name, ipstr}, err)
+	return err
 }
 
 // SetInterfaceMac : Set mac address of an interface
@@ -691,7 +693,9 @@ func SetInterfaceMac(name string, macaddr string) error {
 	if err != nil {
 		return err
 	}
-	return netlink.LinkSetHardwareAddr(iface, hwaddr)
+	err = netlink.LinkSetHardwareAddr(iface, hwaddr)
+	core.Audit("link set address", []string{name, macaddr}, err)
+	return err
 }
 
 // GetNetlinkAddrList returns a list of local IP addresses
@@ -779,6 +783,31 @@ func GetHostLowestLinkMtu() (int, error) {
 	return lowestMTU, nil
 }
 
+// GetAddrIntfName returns the name of the local interface holding an address
+func GetAddrIntfName(addr string) (string, error) {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return "", core.Errorf("invalid address %q", addr)
+	}
+
+	intfList, err := net.Interfaces()
+	if err != nil {
+		return "", err
+	}
+	for _, intf := range intfList {
+		addrs, err := intf.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, a := range addrs {
+			if ipNet, ok := a.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+				return intf.Name, nil
+			}
+		}
+	}
+	return "", core.Errorf("no interface with address %s", addr)
+}
+
 // IsAddrLocal check if an address is local
 func IsAddrLocal(findAddr string) bool {
 	// get the local addr list
@@ -936,8 +965,10 @@ func SetIPMasquerade(intf, netmask string) error {
 		return nil
 	}
 
-	out, err := osexec.Command(ipTablesPath, "-t", "nat", "-A", "POSTROUTING", "-s", netmask,
-		"!", "-o", intf, "-j", "MASQUERADE").CombinedOutput()
+	args := []string{"-t", "nat", "-A", "POSTROUTING", "-s", netmask,
+		"!", "-o", intf, "-j", "MASQUERADE"}
+	out, err := osexec.Command(ipTablesPath, args...).CombinedOutput()
+	core.Audit(ipTablesPath, args, err)
 	if err != nil {
 		log.Errorf("Setting ip tables failed: %v %s", err, out)
 	} else {
@@ -1097,7 +1128,9 @@ func AddIPRoute(cidr, gw string) error {
 		Gw:  gwIP,
 	}
 
-	return netlink.RouteAdd(&newRoute)
+	err = netlink.RouteAdd(&newRoute)
+	core.Audit("route add", []string{cidr, gw}, err)
+	return err
 }
 
 // DelIPRoute deletes the specified ip route
@@ -1113,7 +1146,9 @@ func DelIPRoute(cidr, gw string) error {
 		return fmt.Errorf("Unable to parse gw %s", gw)
 	}
 
-	return netlink.RouteDel(&netlink.Route{Dst: dst, Gw: gwIP})
+	err = netlink.RouteDel(&netlink.Route{Dst: dst, Gw: gwIP})
+	core.Audit("route del", []string{cidr, gw}, err)
+	return err
 }
 
 // ValidateBindAddress format in "address:port"
@@ -542,3 +542,13 @@ func TestIsOverlappingSubnet(t *testing.T) {
 	}
 
 }
+
+func TestGetAddrIntfName(t *testing.T) {
+	intfName, err := GetAddrIntfName("127.0.0.1")
+	assertOnTrue(t, err != nil || intfName != "lo", fmt.Sprintf("loopback address on %q: %v", intfName, err))
+
+	_, err = GetAddrIntfName("192.0.2.123")
+	assertOnTrue(t, err == nil, "interface found for a foreign address")
+	_, err = GetAddrIntfName("1.2.3")
+	assertOnTrue(t, err == nil, "interface found for an invalid address")
+}